@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AdminAuth guards the admin endpoints with a static bearer token. With an
+// empty token the admin API is disabled and every request gets a 404, so a
+// default install never exposes profiling data.
+func AdminAuth(token string) func(http.Handler) http.Handler {
+	return bearerAuth("admin", "admin.token", func() string { return token })
+}
+
+// WorkerAuth guards the endpoints remote indexing workers use with
+// workers.token from the config file. Like the admin API, it is disabled
+// while the token is empty.
+func WorkerAuth(token func() string) func(http.Handler) http.Handler {
+	return bearerAuth("worker", "workers.token", token)
+}
+
+// authContextKey is the request context key of the API a request was
+// authenticated for.
+type authContextKey struct{}
+
+// authenticated returns the API ("admin", "worker") whose token r carried,
+// or "" if it wasn't checked by bearerAuth.
+func authenticated(r *http.Request) string {
+	api, _ := r.Context().Value(authContextKey{}).(string)
+	return api
+}
+
+// bearerAuth checks requests against the bearer token returned by token;
+// setting names where it is configured.
+func bearerAuth(api, setting string, token func() string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			want := token()
+			if want == "" {
+				writeError(w, http.StatusNotFound, CodeNotFound, "%s API is disabled (set %s)", api, setting)
+				return
+			}
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="intelsk-`+api+`"`)
+				writeError(w, http.StatusUnauthorized, CodeUnauthorized, "missing or invalid %s token", api)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, api)))
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+// alertClipPadSec is how much video the clip link of an alert covers on
+// each side of the matched frame.
+const alertClipPadSec = 15
+
+type AlertsHandler struct {
+	alerts   *services.AlertService
+	cfg      *config.AppConfig
+	settings *services.SettingsService
+}
+
+func NewAlertsHandler(alerts *services.AlertService, cfg *config.AppConfig, settings *services.SettingsService) *AlertsHandler {
+	return &AlertsHandler{alerts: alerts, cfg: cfg, settings: settings}
+}
+
+// withURLs fills in the frame and clip links of an alert with a matched
+// frame and of its views, rooted at the workspace's API base, and gives
+// their times in general.timezone.
+func (h *AlertsHandler) withURLs(a models.Alert) models.Alert {
+	if a.FramePath != "" {
+		a.FrameURL = buildFrameURL(h.cfg.APIBase(), a.FramePath)
+	}
+	if a.SourceVideo != "" {
+		offset := services.SeekOffset(a.Timestamp, a.SourceVideo)
+		a.ClipURL = fmt.Sprintf("%s/videos/%s/clip?start=%d&end=%d", h.cfg.APIBase(), sourceVideoID(a.SourceVideo),
+			max(offset-alertClipPadSec, 0), offset+alertClipPadSec)
+	}
+	a.Timestamp = services.LocalTimestamp(a.Timestamp, h.settings.Location())
+	for i := range a.Views {
+		a.Views[i] = h.withURLs(a.Views[i])
+	}
+	return a
+}
+
+// List returns the alerts, newest first, filtered by ?camera_id=, ?rule=,
+// ?status= and the inclusive ?start_date= and ?end_date=, with
+// ?limit=/?offset= pagination.
+func (h *AlertsHandler) List(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	q := r.URL.Query()
+	filter := services.AlertFilter{
+		CameraID:  q.Get("camera_id"),
+		Rule:      q.Get("rule"),
+		Status:    q.Get("status"),
+		StartDate: q.Get("start_date"),
+		EndDate:   q.Get("end_date"),
+	}
+	if filter.Status != "" && !services.ValidAlertStatus(filter.Status) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "status must be new, acknowledged or dismissed")
+		return
+	}
+	for _, d := range []string{filter.StartDate, filter.EndDate} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid date %q (expected YYYY-MM-DD)", d)
+			return
+		}
+	}
+
+	alerts, err := h.alerts.List(filter)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	alerts = paginate(w, alerts, page)
+	for i := range alerts {
+		alerts[i] = h.withURLs(alerts[i])
+	}
+	writeJSON(w, http.StatusOK, alerts)
+}
+
+// Get returns a single alert.
+func (h *AlertsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	a, err := h.alerts.Get(chi.URLParam(r, "alert_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.withURLs(a))
+}
+
+// Ack marks an alert acknowledged.
+func (h *AlertsHandler) Ack(w http.ResponseWriter, r *http.Request) {
+	h.setStatus(w, r, services.AlertAcknowledged)
+}
+
+// Dismiss marks an alert dismissed.
+func (h *AlertsHandler) Dismiss(w http.ResponseWriter, r *http.Request) {
+	h.setStatus(w, r, services.AlertDismissed)
+}
+
+func (h *AlertsHandler) setStatus(w http.ResponseWriter, r *http.Request, status string) {
+	a, err := h.alerts.SetStatus(chi.URLParam(r, "alert_id"), status)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.withURLs(a))
+}
+
+// Rules returns the notification throttling of every alert rule: its quiet
+// hours, its cooldown and how many alerts wait for its next digest.
+func (h *AlertsHandler) Rules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.alerts.Rules()
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// UpdateRule gives a rule quiet hours or a cooldown of its own.
+func (h *AlertsHandler) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateAlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	rs, err := h.alerts.UpdateRule(chi.URLParam(r, "rule"), req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rs)
+}
+
+// ResetRule puts a rule back on the alerts.* throttling settings.
+func (h *AlertsHandler) ResetRule(w http.ResponseWriter, r *http.Request) {
+	rs, err := h.alerts.ResetRule(chi.URLParam(r, "rule"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rs)
+}
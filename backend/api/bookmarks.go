@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+type BookmarksHandler struct {
+	bookmarks *services.BookmarkService
+	cfg       *config.AppConfig
+	settings  *services.SettingsService
+}
+
+func NewBookmarksHandler(bookmarks *services.BookmarkService, cfg *config.AppConfig, settings *services.SettingsService) *BookmarksHandler {
+	return &BookmarksHandler{bookmarks: bookmarks, cfg: cfg, settings: settings}
+}
+
+// withURLs fills in the frame, video and clip links of a bookmark, as for
+// a search result, and gives its time in general.timezone.
+func (h *BookmarksHandler) withURLs(b models.Bookmark) models.Bookmark {
+	b.FrameURL = buildFrameURL(h.cfg.APIBase(), b.FramePath)
+	if b.SourceVideo != "" {
+		b.SourceVideoURL = buildVideoURL(h.cfg.APIBase(), b.SourceVideo)
+		b.SeekOffsetSec = services.SeekOffset(b.Timestamp, b.SourceVideo)
+		b.ClipURL = resultClipURL(h.cfg.APIBase(), b.FrameID)
+	}
+	b.Timestamp = services.LocalTimestamp(b.Timestamp, h.settings.Location())
+	return b
+}
+
+// List returns the bookmarks by the frames' time, filtered by ?camera_id=,
+// ?tag= and the inclusive ?start_date= and ?end_date=, with
+// ?limit=/?offset= pagination.
+func (h *BookmarksHandler) List(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	q := r.URL.Query()
+	filter := services.BookmarkFilter{
+		CameraID:  q.Get("camera_id"),
+		Tag:       q.Get("tag"),
+		StartDate: q.Get("start_date"),
+		EndDate:   q.Get("end_date"),
+	}
+	for _, d := range []string{filter.StartDate, filter.EndDate} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid date %q (expected YYYY-MM-DD)", d)
+			return
+		}
+	}
+
+	bookmarks, err := h.bookmarks.List(filter)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	bookmarks = paginate(w, bookmarks, page)
+	for i := range bookmarks {
+		bookmarks[i] = h.withURLs(bookmarks[i])
+	}
+	writeJSON(w, http.StatusOK, bookmarks)
+}
+
+// Create bookmarks an indexed frame with a note and tags.
+func (h *BookmarksHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateBookmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	b, err := h.bookmarks.Create(req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, h.withURLs(b))
+}
+
+// Get returns a single bookmark.
+func (h *BookmarksHandler) Get(w http.ResponseWriter, r *http.Request) {
+	b, err := h.bookmarks.Get(chi.URLParam(r, "bookmark_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.withURLs(b))
+}
+
+// Update changes the note or tags of a bookmark.
+func (h *BookmarksHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateBookmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	b, err := h.bookmarks.Update(chi.URLParam(r, "bookmark_id"), req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.withURLs(b))
+}
+
+// Delete removes a bookmark.
+func (h *BookmarksHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.bookmarks.Delete(chi.URLParam(r, "bookmark_id")); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -1,74 +1,163 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/google/uuid"
 	"github.com/intelsk/backend/config"
 	"github.com/intelsk/backend/models"
 	"github.com/intelsk/backend/services"
 )
 
 type CamerasHandler struct {
-	svc        *services.CameraService
-	cfg        *config.AppConfig
-	mlClient   *services.MLClient
-	storage    *services.Storage
-	settings   *services.SettingsService
-	streamer   *services.Streamer
-	mu         sync.Mutex
-	uploadJobs map[string]*uploadJob
+	svc      *services.CameraService
+	cfg      *config.AppConfig
+	encoder  services.Encoder
+	workers  *services.WorkerPool
+	storage  *services.Storage
+	settings *services.SettingsService
+	streamer *services.Streamer
+	signer   *streamSigner
+	jobs     *services.JobManager
 }
 
-type uploadJob struct {
-	Events []uploadJobEvent
-	doneCh chan struct{}
+// uploadJobParams are the params of an upload's extract/index job. An
+// import sets URL (and Filename, if given) instead of Paths: the job
+// downloads the video first.
+type uploadJobParams struct {
+	CameraID string   `json:"camera_id"`
+	Date     string   `json:"date"`
+	Paths    []string `json:"paths"`
+	URL      string   `json:"url,omitempty"`
+	Filename string   `json:"filename,omitempty"`
 }
 
-type uploadJobEvent struct {
-	Stage       string `json:"stage"`                  // "transcoding", "done", "extracting", "indexing", "complete"
-	File        string `json:"file,omitempty"`
-	Current     int    `json:"current,omitempty"`
-	Total       int    `json:"total,omitempty"`
-	FramesDone  int    `json:"frames_done,omitempty"`
-	FramesTotal int    `json:"frames_total,omitempty"`
+// redactUploadParams drops the query and credentials of an import's URL,
+// such as the signature of a presigned one, from the params jobs are
+// listed with.
+func redactUploadParams(raw json.RawMessage) json.RawMessage {
+	var params uploadJobParams
+	if json.Unmarshal(raw, &params) != nil || params.URL == "" {
+		return raw
+	}
+	params.URL = services.RedactURL(params.URL)
+	redacted, err := json.Marshal(params)
+	if err != nil {
+		return raw
+	}
+	return redacted
+}
+
+func NewCamerasHandler(svc *services.CameraService, cfg *config.AppConfig, encoder services.Encoder, workers *services.WorkerPool, storage *services.Storage, settings *services.SettingsService, streamer *services.Streamer, jobs *services.JobManager) *CamerasHandler {
+	h := &CamerasHandler{
+		svc:      svc,
+		cfg:      cfg,
+		encoder:  encoder,
+		workers:  workers,
+		storage:  storage,
+		settings: settings,
+		streamer: streamer,
+		signer:   newStreamSigner(cfg.Stream.SigningKey, time.Duration(cfg.Stream.TokenTTLSec)*time.Second),
+		jobs:     jobs,
+	}
+	jobs.Register(services.JobTypeUpload, h.runUploadJob)
+	jobs.RedactBy(services.JobTypeUpload, redactUploadParams)
+	jobs.Register(services.JobTypeArchive, h.runArchive)
+	jobs.KeyBy(services.JobTypeArchive, archiveJobKeys)
+	return h
 }
 
-func NewCamerasHandler(svc *services.CameraService, cfg *config.AppConfig, mlClient *services.MLClient, storage *services.Storage, settings *services.SettingsService, streamer *services.Streamer) *CamerasHandler {
-	return &CamerasHandler{
-		svc:        svc,
-		cfg:        cfg,
-		mlClient:   mlClient,
-		storage:    storage,
-		settings:   settings,
-		streamer:   streamer,
-		uploadJobs: make(map[string]*uploadJob),
+// archiveJobKeys keys archive jobs by their camera and date, so a date is
+// only archived by one job at a time.
+func archiveJobKeys(params json.RawMessage) []string {
+	var p services.ArchiveParams
+	if json.Unmarshal(params, &p) != nil {
+		return nil
 	}
+	return []string{services.JobKey(p.CameraID, p.Date)}
 }
 
+// List returns cameras, optionally filtered by ?type=, ?status= and ?q= (a
+// case-insensitive substring of the ID or name), sorted by ?sort= (id, name,
+// type, created_at) and paginated with ?limit=/?offset=.
 func (h *CamerasHandler) List(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePageParams(r, "id", "name", "type", "created_at")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+
 	cameras, err := h.svc.List()
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, cameras)
+
+	q := r.URL.Query()
+	camType := q.Get("type")
+	status := q.Get("status")
+	search := strings.ToLower(q.Get("q"))
+
+	filtered := make([]models.CameraInfo, 0, len(cameras))
+	for _, cam := range cameras {
+		if camType != "" && cam.Type != camType {
+			continue
+		}
+		if status != "" && cam.Status != status {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(cam.ID), search) &&
+			!strings.Contains(strings.ToLower(cam.Name), search) {
+			continue
+		}
+		filtered = append(filtered, cam)
+	}
+
+	// Cameras come back sorted by ID; re-sort only when asked to
+	if page.Sort != "" || page.Desc {
+		less := func(a, b models.CameraInfo) bool {
+			var x, y string
+			switch page.Sort {
+			case "name":
+				x, y = strings.ToLower(a.Name), strings.ToLower(b.Name)
+			case "type":
+				x, y = a.Type, b.Type
+			case "created_at":
+				x, y = a.CreatedAt, b.CreatedAt
+			}
+			if x != y {
+				return x < y
+			}
+			return a.ID < b.ID
+		}
+		sort.Slice(filtered, func(i, j int) bool {
+			if page.Desc {
+				return less(filtered[j], filtered[i])
+			}
+			return less(filtered[i], filtered[j])
+		})
+	}
+
+	writeJSON(w, http.StatusOK, paginate(w, filtered, page))
 }
 
 func (h *CamerasHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	cam, err := h.svc.Get(id)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, cam)
@@ -77,13 +166,13 @@ func (h *CamerasHandler) Get(w http.ResponseWriter, r *http.Request) {
 func (h *CamerasHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateCameraRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
 		return
 	}
 
 	cam, err := h.svc.Create(req)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusCreated, cam)
@@ -93,13 +182,40 @@ func (h *CamerasHandler) Update(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	var req models.UpdateCameraRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
 		return
 	}
 
 	cam, err := h.svc.Update(id, req)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cam)
+}
+
+// Rename changes a camera's ID, moving its data and index along. It is
+// refused while jobs are running, as they may be writing the camera's
+// frames, and jobs submitted meanwhile wait for it; its live stream, if
+// any, is stopped.
+func (h *CamerasHandler) Rename(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req models.RenameCameraRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	unlock, err := h.jobs.ReserveAll("rename of camera " + id)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	defer unlock()
+
+	h.streamer.Stop(id)
+	cam, err := h.svc.Rename(id, req.NewID)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, cam)
@@ -110,7 +226,7 @@ func (h *CamerasHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	deleteData := r.URL.Query().Get("delete_data") == "true"
 
 	if err := h.svc.Delete(id, deleteData); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
@@ -120,7 +236,7 @@ func (h *CamerasHandler) Stats(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	stats, err := h.svc.Stats(id)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, stats)
@@ -130,21 +246,152 @@ func (h *CamerasHandler) ListVideos(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	videos, err := h.svc.ListVideos(id)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, videos)
 }
 
+// Timeline returns a camera's recordings for ?date=YYYY-MM-DD laid out on a
+// continuous day, with the gaps between them, for NVR-style scrubbing.
+func (h *CamerasHandler) Timeline(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tl, err := h.svc.Timeline(id, r.URL.Query().Get("date"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	for i := range tl.Segments {
+		tl.Segments[i].VideoURL = videoPlayURL(h.cfg.APIBase(), tl.Segments[i].VideoID)
+	}
+	writeJSON(w, http.StatusOK, tl)
+}
+
+// defaultFramePageSize is the page of Frames without ?limit=.
+const defaultFramePageSize = 100
+
+// Frames pages through the frames extracted for the camera on ?date=, in
+// chronological order (?order=desc for newest first), with their frame,
+// video and clip links and whether they are indexed. Pages are ?limit=
+// (default 100) frames from ?offset=, or ?page= (from 1) in steps of limit;
+// X-Total-Count has the day's total.
+func (h *CamerasHandler) Frames(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("limit") == "" {
+		page.Limit = defaultFramePageSize
+	}
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "page must be a positive integer")
+			return
+		}
+		page.Offset = (n - 1) * page.Limit
+	}
+
+	frames, err := h.svc.Frames(chi.URLParam(r, "id"), q.Get("date"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if page.Desc {
+		slices.Reverse(frames)
+	}
+	frames = paginate(w, frames, page)
+	apiBase := h.cfg.APIBase()
+	for i, f := range frames {
+		frames[i].FrameURL = buildFrameURL(apiBase, f.FramePath)
+		if f.SourceVideo != "" {
+			frames[i].SourceVideoURL = buildVideoURL(apiBase, f.SourceVideo)
+			frames[i].SeekOffsetSec = services.SeekOffset(f.Timestamp, f.SourceVideo)
+			if f.Indexed {
+				frames[i].ClipURL = resultClipURL(apiBase, f.FrameID)
+			}
+		}
+		frames[i].Timestamp = services.LocalTimestamp(f.Timestamp, h.settings.Location())
+	}
+	writeJSON(w, http.StatusOK, frames)
+}
+
+// Timelapse serves a timelapse MP4 of the camera's extracted frames for
+// ?date=, built on first request (see CameraService.Timelapse). ?fps= and
+// ?height= shape it; ?download=1 sends it as an attachment.
+func (h *CamerasHandler) Timelapse(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	date := r.URL.Query().Get("date")
+	opts := services.TimelapseOptions{FPS: services.DefaultTimelapseFPS, Height: services.DefaultTimelapseHeight}
+	for name, dst := range map[string]*int{"fps": &opts.FPS, "height": &opts.Height} {
+		if v := r.URL.Query().Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%s must be an integer", name)
+				return
+			}
+			*dst = n
+		}
+	}
+	path, err := h.svc.Timelapse(r.Context(), id, date, opts)
+	if err != nil {
+		if r.Context().Err() == nil {
+			writeServiceError(w, err)
+		}
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	name := fmt.Sprintf("%s_%s_timelapse.mp4", id, date)
+	if r.URL.Query().Get("download") == "1" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// TimelineLocate resolves ?at=YYYY-MM-DDTHH:MM:SS (wall clock) to the
+// recording to play and the offset to seek to, or the gap it falls in.
+func (h *CamerasHandler) TimelineLocate(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	at, err := time.Parse(services.TimelineTimeLayout, r.URL.Query().Get("at"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "at must be YYYY-MM-DDTHH:MM:SS")
+		return
+	}
+	pos, err := h.svc.Locate(id, at)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if pos.VideoID != "" {
+		pos.VideoURL = videoPlayURL(h.cfg.APIBase(), pos.VideoID)
+		pos.Segment.VideoURL = pos.VideoURL
+	}
+	writeJSON(w, http.StatusOK, pos)
+}
+
 func (h *CamerasHandler) CleanData(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	scope := r.URL.Query().Get("scope")
 	if scope != "videos" && scope != "all" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "scope must be 'videos' or 'all'"})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "scope must be 'videos' or 'all'")
 		return
 	}
 	if err := h.svc.CleanData(id, scope); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
 	h.svc.InvalidateThumbnail(id)
@@ -156,56 +403,200 @@ func (h *CamerasHandler) DeleteVideo(w http.ResponseWriter, r *http.Request) {
 	date := r.URL.Query().Get("date")
 	filename := r.URL.Query().Get("filename")
 	if date == "" || filename == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "date and filename are required"})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "date and filename are required")
 		return
 	}
 	if err := h.svc.DeleteVideo(id, date, filename); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
 	h.svc.InvalidateThumbnail(id)
 	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
 
+// DeleteDate removes a date of a camera: its videos, frames, embeddings,
+// alerts and history entry. It is refused while a job is working on it.
+func (h *CamerasHandler) DeleteDate(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	date := chi.URLParam(r, "date")
+	key := services.JobKey(id, date)
+	unlock, err := h.jobs.Reserve(key, "deletion of "+key)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	defer unlock()
+	deleted, err := h.svc.DeleteDate(id, date)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deleted)
+}
+
+// ArchiveDate starts an archive job merging a date's recordings into one
+// file, once they are all indexed, and returns it with 202.
+func (h *CamerasHandler) ArchiveDate(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	date := chi.URLParam(r, "date")
+	if err := h.svc.ArchiveReady(id, date); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	job, err := h.jobs.Submit(services.JobTypeArchive, services.ArchiveParams{CameraID: id, Date: date})
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	info, err := h.jobs.Get(job.ID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, info)
+}
+
+// runArchive is the JobRunner of archive jobs: it merges a date's
+// recordings into its day archive while holding the date, so no processing
+// job adds to its manifest meanwhile.
+func (h *CamerasHandler) runArchive(ctx context.Context, job *services.Job, emit func(services.ProgressEvent)) error {
+	var params services.ArchiveParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return fmt.Errorf("invalid job params: %w", err)
+	}
+	if err := job.WaitForSlot(ctx); err != nil {
+		return err
+	}
+	unlock, err := job.Lock(ctx, services.JobKey(params.CameraID, params.Date))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	clock := services.VideoClock{
+		Source:   services.TimestampSource(h.settings),
+		Location: h.settings.Location(),
+	}
+	merged, err := h.svc.ArchiveDate(ctx, params.CameraID, params.Date, clock, emit)
+	if err != nil {
+		return err
+	}
+	h.svc.InvalidateThumbnail(params.CameraID)
+	emit(services.ProgressEvent{Stage: "complete", CameraID: params.CameraID, Date: params.Date, Percent: 100,
+		Message: fmt.Sprintf("merged %d recordings of %s/%s into one archive", merged, params.CameraID, params.Date)})
+	return nil
+}
+
 func (h *CamerasHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
+	// Cap the whole request at the most it may legitimately contain
+	r.Body = http.MaxBytesReader(w, r.Body, int64(h.cfg.Upload.MaxFiles)*h.cfg.MaxUploadBytes()+(1<<20))
+
 	// 32 MB memory limit; rest spills to disk
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "failed to parse multipart form"})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "failed to parse multipart form")
 		return
 	}
 
 	files := r.MultipartForm.File["files"]
 	if len(files) == 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no files provided"})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "no files provided")
+		return
+	}
+	if len(files) > h.cfg.Upload.MaxFiles {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+			"too many files: %d (limit %d per request)", len(files), h.cfg.Upload.MaxFiles)
 		return
 	}
 
-	var paths []string
+	results := make([]models.UploadFileResult, 0, len(files))
 	for _, fh := range files {
-		// Skip non-.mp4 files
-		if strings.ToLower(filepath.Ext(fh.Filename)) != ".mp4" {
-			continue
+		result := models.UploadFileResult{Filename: fh.Filename}
+		switch {
+		case strings.ToLower(filepath.Ext(fh.Filename)) != ".mp4":
+			result.Error = "not an .mp4 file"
+		case fh.Size > h.cfg.MaxUploadBytes():
+			result.Error = fmt.Sprintf("file exceeds the %d MB upload limit", h.cfg.Upload.MaxFileSizeMB)
+		default:
+			file, err := fh.Open()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, CodeInternal, "failed to open uploaded file")
+				return
+			}
+			path, err := h.svc.Upload(id, file, fh.Filename)
+			file.Close()
+			if err != nil {
+				writeServiceError(w, err)
+				return
+			}
+			result = h.acceptUpload(id, fh.Filename, path)
 		}
+		results = append(results, result)
+	}
 
-		file, err := fh.Open()
-		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to open uploaded file"})
-			return
-		}
+	h.startPostUpload(w, id, results)
+}
 
-		path, err := h.svc.Upload(id, file, fh.Filename)
-		file.Close()
-		if err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
-			return
+// acceptUpload probes a file staged in the uploads directory, removes it if
+// it isn't a readable video and otherwise places it in the camera's video
+// directory. A duplicate of a video already stored is dropped as the
+// duplicates mode (the videos.duplicates setting) says.
+func (h *CamerasHandler) acceptUpload(id, filename, staged string) models.UploadFileResult {
+	result := models.UploadFileResult{Filename: filename}
+	probe, err := services.ValidateVideo(staged)
+	if err != nil {
+		os.Remove(staged)
+		result.Error = err.Error()
+		return result
+	}
+	path, dup, err := h.svc.PlaceUpload(id, staged, filename, services.DuplicateMode(h.settings))
+	if err != nil {
+		os.Remove(staged)
+		result.Error = err.Error()
+		return result
+	}
+	if dup != "" {
+		result.DuplicateOf = filepath.Base(dup)
+		result.Error = "duplicate of " + result.DuplicateOf
+		if path != "" {
+			result.Error += ", kept as " + filepath.Base(path)
 		}
-		paths = append(paths, path)
+		return result
 	}
+	result.Accepted = true
+	result.Path = path
+	result.Codec = probe.Codec
+	result.DurationSec = probe.DurationSec
+	return result
+}
 
+// startPostUpload runs after files land in the camera's video directory:
+// it kicks off the extract/index job configured for the camera for
+// the accepted files and writes the upload response, including the per-file
+// results. A request where every file was rejected fails with 400.
+func (h *CamerasHandler) startPostUpload(w http.ResponseWriter, id string, results []models.UploadFileResult) {
+	var paths []string
+	duplicates := 0
+	for _, res := range results {
+		if res.Accepted {
+			paths = append(paths, res.Path)
+		}
+		if res.DuplicateOf != "" {
+			duplicates++
+		}
+	}
+	if len(paths) == 0 && duplicates == len(results) {
+		// Everything was stored already; nothing to process
+		writeJSON(w, http.StatusOK, map[string]any{"status": "duplicate", "results": results})
+		return
+	}
 	if len(paths) == 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no .mp4 files found in upload"})
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Code:    CodeInvalidRequest,
+			Message: "no valid video files in upload",
+			Details: results,
+		})
 		return
 	}
 
@@ -213,119 +604,158 @@ func (h *CamerasHandler) Upload(w http.ResponseWriter, r *http.Request) {
 
 	cam, err := h.svc.Get(id)
 	if err != nil {
-		writeJSON(w, http.StatusOK, map[string]any{"status": "uploaded", "paths": paths})
+		writeJSON(w, http.StatusOK, map[string]any{"status": "uploaded", "paths": paths, "results": results})
 		return
 	}
 
-	shouldTranscode := services.ShouldTranscode(cam.Config)
-	shouldProcess := services.ShouldProcessOnUpload(cam.Config)
-
-	// Probe each file for HEVC codec (only if transcoding enabled)
-	var hevcPaths []string
-	if shouldTranscode {
-		for _, p := range paths {
-			codec, err := services.ProbeVideoCodec(p)
-			if err != nil {
-				log.Printf("Probe warning for %s: %v", p, err)
-				continue
-			}
-			if codec == "hevc" {
-				hevcPaths = append(hevcPaths, p)
-			}
-		}
+	// Originals are kept as uploaded; HEVC is transcoded at playback time
+	// (see VideoHandler.Play)
+	if !services.ShouldProcessOnUpload(cam.Config) {
+		writeJSON(w, http.StatusOK, map[string]any{"status": "uploaded", "paths": paths, "results": results})
+		return
 	}
 
-	needsTranscode := len(hevcPaths) > 0
-	if !needsTranscode && !shouldProcess {
-		writeJSON(w, http.StatusOK, map[string]any{"status": "uploaded", "paths": paths})
+	// Start background upload job (extract + index)
+	job, err := h.jobs.Submit(services.JobTypeUpload, uploadJobParams{
+		CameraID: id,
+		Date:     h.settings.Now().Format("2006-01-02"),
+		Paths:    paths,
+	})
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
-	// Start background upload job (transcode + extract + index)
-	jobID := uuid.New().String()
-	job := &uploadJob{
-		doneCh: make(chan struct{}),
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":  "uploaded",
+		"paths":   paths,
+		"job_id":  job.ID,
+		"results": results,
+	})
+}
+
+// Import starts an upload job that downloads a video from a URL, http or
+// https such as an S3 presigned URL, into the camera's video directory and
+// then extracts and indexes it like an uploaded one. The download's
+// progress comes as "downloading" events on /upload/status.
+func (h *CamerasHandler) Import(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req models.ImportURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	if _, err := h.svc.Get(id); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	filename, err := services.ImportSource(req.URL, req.Filename)
+	if err != nil {
+		writeServiceError(w, err)
+		return
 	}
 
-	h.mu.Lock()
-	h.uploadJobs[jobID] = job
-	h.mu.Unlock()
+	job, err := h.jobs.Submit(services.JobTypeUpload, uploadJobParams{
+		CameraID: id,
+		Date:     h.settings.Now().Format("2006-01-02"),
+		URL:      req.URL,
+		Filename: filename,
+	})
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"status":   "importing",
+		"filename": filename,
+		"job_id":   job.ID,
+	})
+}
 
-	go h.runUploadJob(job, id, paths, hevcPaths, shouldProcess)
+// TrackedUploadCount returns the number of upload jobs (running or
+// recently finished) held in memory.
+func (h *CamerasHandler) TrackedUploadCount() int {
+	return h.jobs.TrackedCount(services.JobTypeUpload)
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"status": "uploaded",
-		"paths":  paths,
-		"job_id": jobID,
-	})
+// ActiveUploadCount returns the number of upload jobs still running.
+func (h *CamerasHandler) ActiveUploadCount() int {
+	return h.jobs.ActiveCount(services.JobTypeUpload)
 }
 
-func (h *CamerasHandler) runUploadJob(job *uploadJob, cameraID string, allPaths, hevcPaths []string, shouldProcess bool) {
-	defer close(job.doneCh)
-
-	// Phase 1: Transcode HEVC files
-	if len(hevcPaths) > 0 {
-		total := len(hevcPaths)
-		for i, p := range hevcPaths {
-			current := i + 1
-			h.mu.Lock()
-			job.Events = append(job.Events, uploadJobEvent{
-				Stage:   "transcoding",
-				File:    filepath.Base(p),
-				Current: current,
-				Total:   total,
-			})
-			h.mu.Unlock()
-
-			if err := services.TranscodeIfNeeded(p); err != nil {
-				log.Printf("Transcode error for %s: %v", p, err)
-			}
+// runUploadJob is the JobRunner of upload jobs: it extracts frames from the
+// uploaded files and indexes them, after downloading the file of an import.
+func (h *CamerasHandler) runUploadJob(ctx context.Context, job *services.Job, emit func(services.ProgressEvent)) error {
+	var params uploadJobParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return fmt.Errorf("invalid job params: %w", err)
+	}
+	cameraID, date := params.CameraID, params.Date
 
-			h.mu.Lock()
-			job.Events = append(job.Events, uploadJobEvent{
-				Stage:   "done",
-				File:    filepath.Base(p),
-				Current: current,
-				Total:   total,
-			})
-			h.mu.Unlock()
+	// Events carry the job's percent complete and ETA
+	progress := services.NewJobProgress([]services.ProgressTask{{CameraID: cameraID, Date: date, Download: params.URL != ""}})
+	emitEvent := emit
+	emit = func(ev services.ProgressEvent) { emitEvent(progress.Update(ev)) }
+
+	if err := job.WaitForSlot(ctx); err != nil {
+		return err
+	}
+	if params.URL != "" {
+		path, err := h.importVideo(ctx, job, params, emit)
+		if err != nil || path == "" {
+			return err
 		}
+		// Saved under the date it was downloaded on
+		params.Paths = []string{path}
+		date = filepath.Base(filepath.Dir(path))
 	}
-
-	if !shouldProcess {
-		h.mu.Lock()
-		job.Events = append(job.Events, uploadJobEvent{Stage: "complete"})
-		h.mu.Unlock()
-		return
+	// Processing jobs on the same camera and date write the same manifest
+	unlock, err := job.Lock(ctx, services.JobKey(cameraID, date))
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	// Phase 2: Extract frames from all uploaded files
-	date := time.Now().Format("2006-01-02")
+	// Phase 1: Extract frames from all uploaded files
 	videosDir := filepath.Join(h.cfg.App.DataDir, "videos", cameraID, date)
 	framesDir := filepath.Join(h.cfg.Extraction.StoragePath, cameraID, date)
+	clock := services.VideoClock{
+		Source:   services.TimestampSource(h.settings),
+		Location: h.settings.Location(),
+	}
+	if cam, err := h.svc.Get(cameraID); err == nil {
+		clock.OffsetSec = services.ClockOffset(cam.Config)
+	}
 
-	h.mu.Lock()
-	job.Events = append(job.Events, uploadJobEvent{Stage: "extracting"})
-	h.mu.Unlock()
+	emit(services.ProgressEvent{Stage: "extracting", CameraID: cameraID, Date: date, VideosTotal: len(params.Paths)})
 
 	existingFrames, _ := services.LoadManifest(framesDir)
 	var newFrames []models.FrameMetadata
 
-	for _, p := range allPaths {
-		h.mu.Lock()
-		job.Events = append(job.Events, uploadJobEvent{
-			Stage: "extracting",
-			File:  filepath.Base(p),
+	for i, p := range params.Paths {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		emit(services.ProgressEvent{
+			Stage:       "extracting",
+			CameraID:    cameraID,
+			Date:        date,
+			File:        filepath.Base(p),
+			VideosDone:  i,
+			VideosTotal: len(params.Paths),
 		})
-		h.mu.Unlock()
 
-		frames, err := services.ExtractFramesTime(
+		frames, err := services.ExtractFramesTimeContext(ctx,
 			p, framesDir,
 			h.settings.GetInt("extraction.time_interval_sec"),
 			h.settings.GetInt("extraction.output_quality"),
+			clock,
 		)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if err != nil {
-			log.Printf("Extraction failed for %s: %v", p, err)
+			job.Logf("Extraction failed for %s: %v", p, err)
 			continue
 		}
 
@@ -336,135 +766,203 @@ func (h *CamerasHandler) runUploadJob(job *uploadJob, cameraID string, allPaths,
 		newFrames = append(newFrames, frames...)
 	}
 
-	allFrames := append(existingFrames, newFrames...)
+	allFrames := services.MergeManifest(existingFrames, newFrames)
 	if err := services.WriteManifest(framesDir, allFrames); err != nil {
-		log.Printf("Writing manifest for %s/%s: %v", cameraID, date, err)
+		job.Logf("Writing manifest for %s/%s: %v", cameraID, date, err)
 	}
 
-	// Phase 3: Index frames via ML pipeline
+	// Phase 2: Index frames via ML pipeline
 	manifest := filepath.Join(framesDir, "manifest.json")
 	if _, err := os.Stat(manifest); err != nil {
-		log.Printf("No manifest for %s/%s after extraction", cameraID, date)
-		h.mu.Lock()
-		job.Events = append(job.Events, uploadJobEvent{Stage: "complete"})
-		h.mu.Unlock()
-		return
+		job.Logf("No manifest for %s/%s after extraction", cameraID, date)
+		emit(services.ProgressEvent{Stage: "complete", CameraID: cameraID, Date: date})
+		return nil
 	}
 
-	// Wait for ML sidecar
-	if err := h.mlClient.WaitForReady(120 * time.Second); err != nil {
-		log.Printf("ML sidecar not ready: %v", err)
-		h.mu.Lock()
-		job.Events = append(job.Events, uploadJobEvent{Stage: "complete"})
-		h.mu.Unlock()
-		return
+	var encoder services.ImageEncoder = h.encoder
+	if h.workers.Enabled() {
+		// Batches wait in the worker queue until a worker picks them up
+		encoder = h.workers
+	} else if err := h.encoder.WaitForReady(h.encoder.ReadyTimeout()); err != nil {
+		return err
 	}
 
-	h.mu.Lock()
-	job.Events = append(job.Events, uploadJobEvent{Stage: "indexing"})
-	h.mu.Unlock()
+	emit(services.ProgressEvent{Stage: "indexing", CameraID: cameraID, Date: date})
 
-	pipeline := services.NewPipeline(h.mlClient, h.storage, h.settings.GetInt("clip.batch_size"))
+	pipeline := services.NewPipeline(h.encoder, h.storage, h.settings.GetInt("clip.batch_size")).
+		WithContext(ctx).
+		WithEncoder(encoder)
 
-	// Bridge pipeline progress events into upload job events
+	// Pass on the pipeline's indexing progress
 	progressCh := make(chan services.ProgressEvent, 64)
 	progressDone := make(chan struct{})
 	go func() {
 		defer close(progressDone)
 		for ev := range progressCh {
 			if ev.Stage == "indexing" {
-				h.mu.Lock()
-				job.Events = append(job.Events, uploadJobEvent{
+				emit(services.ProgressEvent{
 					Stage:       "indexing",
+					CameraID:    cameraID,
+					Date:        date,
 					FramesDone:  ev.FramesDone,
 					FramesTotal: ev.FramesTotal,
 				})
-				h.mu.Unlock()
 			}
 		}
 	}()
 
-	if err := pipeline.IndexFrames(framesDir, progressCh); err != nil {
-		log.Printf("Indexing failed for %s/%s: %v", cameraID, date, err)
-	}
+	err = pipeline.IndexFrames(framesDir, progressCh)
 	close(progressCh)
 	<-progressDone
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("indexing %s/%s: %w", cameraID, date, err)
+	}
 
 	// Update process history
-	allVideoFiles := ListVideoFiles(videosDir)
-	AddProcessHistory(h.cfg.Process.HistoryPath, cameraID, date, allVideoFiles)
+	allVideoFiles := services.ListVideoFiles(videosDir)
+	services.AddProcessHistory(h.cfg.Process.HistoryPath, cameraID, date, allVideoFiles)
 
-	h.mu.Lock()
-	job.Events = append(job.Events, uploadJobEvent{Stage: "complete"})
-	h.mu.Unlock()
+	emit(services.ProgressEvent{Stage: "complete", CameraID: cameraID, Date: date})
+	return nil
 }
 
-func (h *CamerasHandler) UploadStatus(w http.ResponseWriter, r *http.Request) {
-	jobID := r.URL.Query().Get("job_id")
-	if jobID == "" {
-		http.Error(w, `{"error":"job_id query parameter required"}`, http.StatusBadRequest)
-		return
+// importVideo downloads the video of an import job and checks it is a
+// readable one. It returns "" once the job is done, when the camera doesn't
+// process uploads.
+func (h *CamerasHandler) importVideo(ctx context.Context, job *services.Job, params uploadJobParams, emit func(services.ProgressEvent)) (string, error) {
+	source := services.RedactURL(params.URL)
+	event := services.ProgressEvent{Stage: "downloading", CameraID: params.CameraID, Date: params.Date, File: params.Filename}
+	event.Message = "Downloading " + source
+	emit(event)
+	path, err := h.svc.ImportURL(ctx, params.CameraID, params.URL, params.Filename, func(done, total int64) {
+		event.BytesDone, event.BytesTotal = done, total
+		event.Message = fmt.Sprintf("Downloading %s (%d MB)", source, done>>20)
+		if total > 0 {
+			event.Message = fmt.Sprintf("Downloading %s (%d/%d MB)", source, done>>20, total>>20)
+		}
+		emit(event)
+	})
+	if err != nil {
+		return "", err
 	}
+	result := h.acceptUpload(params.CameraID, params.Filename, path)
+	if result.DuplicateOf != "" {
+		job.Logf("%s: %s", params.Filename, result.Error)
+		emit(services.ProgressEvent{Stage: "complete", CameraID: params.CameraID, Date: params.Date,
+			Message: fmt.Sprintf("%s is a %s; not processed", params.Filename, result.Error)})
+		return "", nil
+	}
+	if !result.Accepted {
+		return "", fmt.Errorf("%s: %s", params.Filename, result.Error)
+	}
+	job.Logf("Imported %s as %s (%s, %.0fs)", source, path, result.Codec, result.DurationSec)
+	h.svc.InvalidateThumbnail(params.CameraID)
 
-	h.mu.Lock()
-	job, ok := h.uploadJobs[jobID]
-	h.mu.Unlock()
-
-	if !ok {
-		http.Error(w, `{"error":"job not found"}`, http.StatusNotFound)
-		return
+	if cam, err := h.svc.Get(params.CameraID); err == nil && !services.ShouldProcessOnUpload(cam.Config) {
+		emit(services.ProgressEvent{Stage: "complete", CameraID: params.CameraID, Date: params.Date,
+			Message: "Imported " + filepath.Base(path)})
+		return "", nil
 	}
+	return path, nil
+}
 
-	// SSE stream
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+// UploadJobInfo describes an upload's extract/index job, for GET
+// /cameras/{id}/upload/jobs.
+type UploadJobInfo struct {
+	JobID      string                  `json:"job_id"`
+	Status     string                  `json:"status"`
+	Error      string                  `json:"error,omitempty"`
+	Date       string                  `json:"date"`
+	Files      []string                `json:"files"`
+	CreatedAt  time.Time               `json:"created_at"`
+	FinishedAt *time.Time              `json:"finished_at,omitempty"`
+	Events     int                     `json:"events"`
+	LastEvent  *services.ProgressEvent `json:"last_event,omitempty"`
+}
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+// UploadJobs lists the camera's upload jobs, newest first, optionally only
+// those with ?status= (e.g. running). A page reloaded while an upload is
+// being indexed finds its job here and re-attaches to /upload/status.
+func (h *CamerasHandler) UploadJobs(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := h.svc.Get(id); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	page, err := parsePageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
 		return
 	}
 
-	// Send any events already collected
-	h.mu.Lock()
-	sent := len(job.Events)
-	for _, ev := range job.Events {
-		data, _ := json.Marshal(ev)
-		fmt.Fprintf(w, "data: %s\n\n", data)
+	recorded, err := h.jobs.List(services.JobTypeUpload)
+	if err != nil {
+		writeServiceError(w, err)
+		return
 	}
-	h.mu.Unlock()
-	flusher.Flush()
+	status := r.URL.Query().Get("status")
+	jobs := []UploadJobInfo{}
+	for _, job := range recorded {
+		var params uploadJobParams
+		json.Unmarshal(job.Params, &params)
+		if params.CameraID != id || (status != "" && job.Status != status) {
+			continue
+		}
+		info := UploadJobInfo{
+			JobID:      job.ID,
+			Status:     job.Status,
+			Error:      job.Error,
+			Date:       params.Date,
+			Files:      make([]string, 0, len(params.Paths)),
+			CreatedAt:  job.CreatedAt,
+			FinishedAt: job.FinishedAt,
+			Events:     job.Events,
+		}
+		for _, p := range params.Paths {
+			info.Files = append(info.Files, filepath.Base(p))
+		}
+		if params.URL != "" {
+			info.Files = append(info.Files, params.Filename)
+		}
+		var ev services.ProgressEvent
+		if job.LastEvent != nil && json.Unmarshal(job.LastEvent, &ev) == nil {
+			info.LastEvent = &ev
+		}
+		jobs = append(jobs, info)
+	}
+	writeJSON(w, http.StatusOK, paginate(w, jobs, page))
+}
 
-	// Stream new events until done
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
+// UploadStatus streams an upload job's progress as SSE, from its first
+// event, so a reloaded page can re-attach to a job it started.
+func (h *CamerasHandler) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	jobID := r.URL.Query().Get("job_id")
+	if jobID == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "job_id query parameter required")
+		return
+	}
+	// Only the camera's own upload jobs are served here
+	job, err := h.jobs.Get(jobID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	var params uploadJobParams
+	json.Unmarshal(job.Params, &params)
+	if job.Type != services.JobTypeUpload || params.CameraID != chi.URLParam(r, "id") {
+		writeError(w, http.StatusNotFound, CodeJobNotFound, "upload job %s not found for camera %s", jobID, chi.URLParam(r, "id"))
+		return
+	}
 
-	for {
-		select {
-		case <-r.Context().Done():
-			return
-		case <-job.doneCh:
-			// Send remaining events
-			h.mu.Lock()
-			for i := sent; i < len(job.Events); i++ {
-				data, _ := json.Marshal(job.Events[i])
-				fmt.Fprintf(w, "data: %s\n\n", data)
-			}
-			h.mu.Unlock()
-			flusher.Flush()
-			return
-		case <-ticker.C:
-			h.mu.Lock()
-			for i := sent; i < len(job.Events); i++ {
-				data, _ := json.Marshal(job.Events[i])
-				fmt.Fprintf(w, "data: %s\n\n", data)
-			}
-			sent = len(job.Events)
-			h.mu.Unlock()
-			flusher.Flush()
-		}
+	mu, events, done, err := h.jobs.EventLog(jobID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
 	}
+	serveEventStream(w, r, mu, events, done)
 }
 
 // Snapshot proxies a JPEG snapshot from a Hikvision camera.
@@ -472,14 +970,14 @@ func (h *CamerasHandler) Snapshot(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	cam, err := h.svc.Get(id)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
 
 	if cam.Type == "hikvision" {
 		nvrIP := h.settings.Get("nvr.ip")
 		if nvrIP == "" {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "NVR IP not configured in settings"})
+			writeError(w, http.StatusBadRequest, CodeNVRNotConfigured, "NVR IP not configured in settings")
 			return
 		}
 		nvrUsername := h.settings.Get("nvr.username")
@@ -489,7 +987,7 @@ func (h *CamerasHandler) Snapshot(w http.ResponseWriter, r *http.Request) {
 		client := services.NewHikvisionClient(nvrIP, nvrUsername, nvrPassword)
 		data, err := client.Snapshot(channel)
 		if err != nil {
-			writeJSON(w, http.StatusBadGateway, map[string]string{"error": fmt.Sprintf("snapshot failed: %v", err)})
+			writeServiceError(w, fmt.Errorf("snapshot failed: %w", err))
 			return
 		}
 
@@ -499,33 +997,46 @@ func (h *CamerasHandler) Snapshot(w http.ResponseWriter, r *http.Request) {
 	} else {
 		data, err := h.svc.Thumbnail(id)
 		if err != nil {
-			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			writeServiceError(w, err)
 			return
 		}
 
 		w.Header().Set("Content-Type", "image/jpeg")
 		w.Header().Set("Cache-Control", "public, max-age=300")
-		w.Write(data)
+		serveBytesConditional(w, r, data)
 	}
 }
 
-// StreamStart starts an HLS stream for a Hikvision camera.
+// StreamStart starts an HLS stream for a Hikvision camera. With ?start=
+// (and optionally ?end=, both YYYY-MM-DDTHH:MM:SS) it streams the NVR's
+// recordings of that stretch instead of the live feed, so they can be
+// reviewed before a process job has downloaded them.
 func (h *CamerasHandler) StreamStart(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	cam, err := h.svc.Get(id)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
 
-	if cam.Type != "hikvision" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "streaming only available for hikvision cameras"})
+	params := services.ResolveStreamParams(h.settings, cam.Config)
+	start, end, playback, err := parsePlaybackRange(r, h.settings.Location())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	if cam.Type == "local" {
+		if playback {
+			writeError(w, http.StatusBadRequest, CodeUnsupported, "local camera recordings play from /videos; start and end need an NVR camera")
+			return
+		}
+		h.startPlayback(w, r, id, params)
 		return
 	}
 
 	nvrIP := h.settings.Get("nvr.ip")
 	if nvrIP == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "NVR IP not configured in settings"})
+		writeError(w, http.StatusBadRequest, CodeNVRNotConfigured, "NVR IP not configured in settings")
 		return
 	}
 	nvrRTSPPort := h.settings.GetInt("nvr.rtsp_port")
@@ -534,32 +1045,130 @@ func (h *CamerasHandler) StreamStart(w http.ResponseWriter, r *http.Request) {
 	}
 	nvrUsername := h.settings.Get("nvr.username")
 	nvrPassword := h.settings.Get("nvr.password")
-	rtspURL := services.CameraRTSPUrl(cam, nvrIP, nvrRTSPPort, nvrUsername, nvrPassword, 2) // substream for live view
-	if err := h.streamer.Start(id, rtspURL); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("stream start failed: %v", err)})
+	if playback {
+		url := services.PlaybackRTSPUrl(nvrIP, nvrRTSPPort, nvrUsername, nvrPassword, services.NVRChannel(cam), start, end)
+		err = h.streamer.StartNVR(id, url, params)
+	} else {
+		rtspURL := services.CameraRTSPUrl(cam, nvrIP, nvrRTSPPort, nvrUsername, nvrPassword, params.NVRStream)
+		err = h.streamer.Start(id, rtspURL, params)
+	}
+	if err != nil {
+		writeStreamStartError(w, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "started"})
+	h.writeStreamStarted(w, r, id)
 }
 
-// StreamServe serves HLS files (index.m3u8 + .ts segments) for an active stream.
-func (h *CamerasHandler) StreamServe(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	filename := chi.URLParam(r, "filename")
+const (
+	// defaultNVRPlayback is how much NVR playback streams when no end is
+	// given.
+	defaultNVRPlayback = time.Hour
+	maxNVRPlayback     = 24 * time.Hour
+)
 
-	h.streamer.Touch(id)
+// parsePlaybackRange reads StreamStart's start and end query parameters,
+// wall-clock times in loc. ok is false when start is absent, i.e. for the
+// live feed.
+func parsePlaybackRange(r *http.Request, loc *time.Location) (start, end time.Time, ok bool, err error) {
+	q := r.URL.Query()
+	if q.Get("start") == "" {
+		if q.Get("end") != "" {
+			return start, end, false, fmt.Errorf("end needs a start")
+		}
+		return start, end, false, nil
+	}
+	start, err = time.ParseInLocation(services.TimelineTimeLayout, q.Get("start"), loc)
+	if err != nil {
+		return start, end, false, fmt.Errorf("start must be YYYY-MM-DDTHH:MM:SS, got %q", q.Get("start"))
+	}
+	end = start.Add(defaultNVRPlayback)
+	if v := q.Get("end"); v != "" {
+		if end, err = time.ParseInLocation(services.TimelineTimeLayout, v, loc); err != nil {
+			return start, end, false, fmt.Errorf("end must be YYYY-MM-DDTHH:MM:SS, got %q", v)
+		}
+	}
+	switch {
+	case !start.Before(time.Now()):
+		return start, end, false, fmt.Errorf("start is in the future")
+	case !end.After(start):
+		return start, end, false, fmt.Errorf("end must be after start")
+	case end.Sub(start) > maxNVRPlayback:
+		return start, end, false, fmt.Errorf("playback is limited to %s", maxNVRPlayback)
+	}
+	return start, end, true, nil
+}
 
-	dir := h.streamer.Dir(id)
-	if dir == "" {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "stream not active"})
+// startPlayback starts the pseudo-live stream of a local camera: its most
+// recent recordings played back-to-back in a loop.
+func (h *CamerasHandler) startPlayback(w http.ResponseWriter, r *http.Request, id string, params services.StreamParams) {
+	files, err := h.svc.RecentVideos(id, playbackRecordings)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if len(files) == 0 {
+		writeError(w, http.StatusNotFound, CodeVideoNotFound, "camera has no recordings to play")
 		return
 	}
+	if err := h.streamer.StartPlayback(id, files, params); err != nil {
+		writeStreamStartError(w, err)
+		return
+	}
+	h.writeStreamStarted(w, r, id)
+}
+
+// writeStreamStartError reports why a stream didn't start. At
+// stream.max_streams with every stream being watched the client may retry
+// once a live view closes.
+func writeStreamStartError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrStreamLimit) {
+		w.Header().Set("Retry-After", "15")
+		writeServiceError(w, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, CodeStreamFailed, "stream start failed: %v", err)
+}
+
+// playbackRecordings is how many of a local camera's newest recordings its
+// pseudo-live stream loops over.
+const playbackRecordings = 10
+
+func (h *CamerasHandler) writeStreamStarted(w http.ResponseWriter, r *http.Request, id string) {
+	// The playlist URL is signed; StreamServe signs everything it lists
+	playlist := strings.TrimSuffix(r.URL.Path, "/start") + "/index.m3u8"
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":       "started",
+		"source":       h.streamer.Status(id).Source,
+		"playlist_url": playlist + "?" + h.signer.query(id, "index.m3u8", h.signer.ttl),
+	})
+}
+
+// StreamServe serves HLS files (index.m3u8 plus .ts, or init.mp4 and .m4s
+// segments and parts) for an active stream. Every request needs the signed
+// exp/sig query from StreamStart (for the playlist) or from the served
+// playlist (for everything else).
+func (h *CamerasHandler) StreamServe(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	filename := chi.URLParam(r, "filename")
 
 	// Sanitize filename
 	safeFile := filepath.Base(filename)
 	if safeFile != filename || safeFile == ".." || safeFile == "." {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid filename"})
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid filename")
+		return
+	}
+
+	if !h.signer.valid(id, safeFile, r.URL.Query()) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "missing, invalid or expired stream token")
+		return
+	}
+
+	h.streamer.Touch(id)
+
+	dir := h.streamer.Dir(id)
+	if dir == "" {
+		writeError(w, http.StatusNotFound, CodeStreamNotActive, "stream not active")
 		return
 	}
 
@@ -568,20 +1177,55 @@ func (h *CamerasHandler) StreamServe(w http.ResponseWriter, r *http.Request) {
 	// Set appropriate content type
 	switch {
 	case strings.HasSuffix(safeFile, ".m3u8"):
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			writeError(w, http.StatusNotFound, CodeNotFound, "playlist not ready")
+			return
+		}
 		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache, no-store")
+		w.Write(h.signer.signPlaylist(id, data))
+		return
 	case strings.HasSuffix(safeFile, ".ts"):
 		w.Header().Set("Content-Type", "video/mp2t")
+	case strings.HasSuffix(safeFile, ".m4s"):
+		w.Header().Set("Content-Type", "video/iso.segment")
+	case strings.HasSuffix(safeFile, ".mp4"):
+		w.Header().Set("Content-Type", "video/mp4")
 	}
 	w.Header().Set("Cache-Control", "no-cache, no-store")
 
 	http.ServeFile(w, r, filePath)
 }
 
+// StreamStatus reports the health of a camera's live stream: starting,
+// healthy, reconnecting (ffmpeg is being restarted), failed or stopped.
+func (h *CamerasHandler) StreamStatus(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := h.svc.Get(id); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.streamer.Status(id))
+}
+
+// StreamDiagnostics reports a camera's stream status together with
+// ffmpeg's recent output (credentials redacted), uptime, frame rate and
+// bitrate, to tell e.g. an RTSP auth failure from an unsupported codec.
+func (h *CamerasHandler) StreamDiagnostics(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := h.svc.Get(id); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.streamer.Diagnostics(id))
+}
+
 // StreamStop stops an active HLS stream.
 func (h *CamerasHandler) StreamStop(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if err := h.streamer.Stop(id); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeServiceError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"status": "stopped"})
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+type CasesHandler struct {
+	cases    *services.CaseService
+	cfg      *config.AppConfig
+	settings *services.SettingsService
+}
+
+func NewCasesHandler(cases *services.CaseService, cfg *config.AppConfig, settings *services.SettingsService) *CasesHandler {
+	return &CasesHandler{cases: cases, cfg: cfg, settings: settings}
+}
+
+// itemWithURLs fills in the frame, video and clip links of a case item. A
+// clip item links to its own span of the video, the others to the clip
+// around their frame. Its time is given in general.timezone.
+func (h *CasesHandler) itemWithURLs(it models.CaseItem) models.CaseItem {
+	if it.FramePath != "" {
+		it.FrameURL = buildFrameURL(h.cfg.APIBase(), it.FramePath)
+	}
+	if it.SourceVideo == "" {
+		it.Timestamp = services.LocalTimestamp(it.Timestamp, h.settings.Location())
+		return it
+	}
+	it.SourceVideoURL = buildVideoURL(h.cfg.APIBase(), it.SourceVideo)
+	it.SeekOffsetSec = services.SeekOffset(it.Timestamp, it.SourceVideo)
+	if it.Kind == services.CaseItemClip {
+		it.ClipURL = fmt.Sprintf("%s/videos/%s/clip?start=%d&end=%d", h.cfg.APIBase(),
+			sourceVideoID(it.SourceVideo), it.StartSec, it.EndSec)
+	} else {
+		it.ClipURL = resultClipURL(h.cfg.APIBase(), it.FrameID)
+	}
+	it.Timestamp = services.LocalTimestamp(it.Timestamp, h.settings.Location())
+	return it
+}
+
+func (h *CasesHandler) withURLs(c models.Case) models.Case {
+	for i := range c.Items {
+		c.Items[i] = h.itemWithURLs(c.Items[i])
+	}
+	return c
+}
+
+// List returns the cases, most recently updated first, filtered by
+// ?status=, with ?limit=/?offset= pagination.
+func (h *CasesHandler) List(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !services.ValidCaseStatus(status) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "status must be open or closed")
+		return
+	}
+	cases, err := h.cases.List(status)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, paginate(w, cases, page))
+}
+
+// Create opens a new case.
+func (h *CasesHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateCaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	c, err := h.cases.Create(req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// Get returns a case with its items.
+func (h *CasesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	c, err := h.cases.Get(chi.URLParam(r, "case_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.withURLs(c))
+}
+
+// Update changes the title, description or status of a case.
+func (h *CasesHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateCaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	c, err := h.cases.Update(chi.URLParam(r, "case_id"), req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.withURLs(c))
+}
+
+// Delete removes a case and its items.
+func (h *CasesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.cases.Delete(chi.URLParam(r, "case_id")); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// AddItem attaches a search result, frame, clip or note to a case.
+func (h *CasesHandler) AddItem(w http.ResponseWriter, r *http.Request) {
+	var req models.AddCaseItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	it, err := h.cases.AddItem(chi.URLParam(r, "case_id"), req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, h.itemWithURLs(it))
+}
+
+// RemoveItem detaches an item from a case.
+func (h *CasesHandler) RemoveItem(w http.ResponseWriter, r *http.Request) {
+	if err := h.cases.RemoveItem(chi.URLParam(r, "case_id"), chi.URLParam(r, "item_id")); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// Export downloads a case as a zip archive of its frames, clips, case.json
+// and a summary.md report. The archive is streamed as its clips are cut,
+// so a failure part way only shows as a truncated download.
+func (h *CasesHandler) Export(w http.ResponseWriter, r *http.Request) {
+	c, err := h.cases.Get(chi.URLParam(r, "case_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": "case_" + c.ID + ".zip"}))
+	if err := h.cases.Export(r.Context(), c, w); err != nil && r.Context().Err() == nil {
+		log.Printf("Exporting case %s: %v", c.ID, err)
+	}
+}
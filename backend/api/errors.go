@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/intelsk/backend/services"
+)
+
+// Machine-readable error codes returned in the "code" field of every error
+// response. Clients should branch on these rather than on message text.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeNotFound           = "not_found"
+	CodeCameraNotFound     = "camera_not_found"
+	CodeVideoNotFound      = "video_not_found"
+	CodeFrameNotFound      = "frame_not_found"
+	CodeJobNotFound        = "job_not_found"
+	CodeJobFinished        = "job_finished"
+	CodeScheduleNotFound   = "schedule_not_found"
+	CodeAlertNotFound      = "alert_not_found"
+	CodeBookmarkNotFound   = "bookmark_not_found"
+	CodeCaseNotFound       = "case_not_found"
+	CodeCaseItemNotFound   = "case_item_not_found"
+	CodePersonNotFound     = "person_not_found"
+	CodeTaskNotFound       = "task_not_found"
+	CodeAlreadyExists      = "already_exists"
+	CodeWorkspaceNotFound  = "workspace_not_found"
+	CodeUploadNotFound     = "upload_not_found"
+	CodeUploadOffset       = "upload_offset_mismatch"
+	CodeBusy               = "busy"
+	CodeUnsupported        = "unsupported"
+	CodeStreamNotActive    = "stream_not_active"
+	CodeStreamFailed       = "stream_failed"
+	CodeStreamLimit        = "stream_limit"
+	CodeNVRNotConfigured   = "nvr_not_configured"
+	CodeNVRUnreachable     = "nvr_unreachable"
+	CodeNVRAuthFailed      = "nvr_auth_failed"
+	CodeNVRTimeout         = "nvr_timeout"
+	CodeNVRError           = "nvr_error"
+	CodeSidecarUnavailable = "sidecar_unavailable"
+	CodeSidecarTimeout     = "sidecar_timeout"
+	CodeSidecarError       = "sidecar_error"
+	CodeRateLimited        = "rate_limited"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeInternal           = "internal_error"
+)
+
+// ErrorResponse is the JSON body of every error response. The message stays
+// under "error" so older clients that only read that field keep working.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"error"`
+	Details any    `json:"details,omitempty"`
+}
+
+// writeError writes an error response with an explicit status and code.
+func writeError(w http.ResponseWriter, status int, code, format string, args ...any) {
+	writeJSON(w, status, ErrorResponse{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// writeServiceError maps an error returned by the services package to an
+// HTTP status and error code. Unclassified errors become 500s and are logged.
+func writeServiceError(w http.ResponseWriter, err error) {
+	status, code := classifyError(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("internal error: %v", err)
+	}
+	writeJSON(w, status, ErrorResponse{Code: code, Message: err.Error()})
+}
+
+func classifyError(err error) (int, string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidInput):
+		return http.StatusBadRequest, CodeInvalidRequest
+	case errors.Is(err, services.ErrCameraNotFound):
+		return http.StatusNotFound, CodeCameraNotFound
+	case errors.Is(err, services.ErrVideoNotFound):
+		return http.StatusNotFound, CodeVideoNotFound
+	case errors.Is(err, services.ErrFrameNotFound):
+		return http.StatusNotFound, CodeFrameNotFound
+	case errors.Is(err, services.ErrWorkspaceNotFound):
+		return http.StatusNotFound, CodeWorkspaceNotFound
+	case errors.Is(err, services.ErrUploadNotFound):
+		return http.StatusNotFound, CodeUploadNotFound
+	case errors.Is(err, services.ErrUploadOffset):
+		return http.StatusConflict, CodeUploadOffset
+	case errors.Is(err, services.ErrJobNotFound):
+		return http.StatusNotFound, CodeJobNotFound
+	case errors.Is(err, services.ErrJobFinished):
+		return http.StatusConflict, CodeJobFinished
+	case errors.Is(err, services.ErrScheduleNotFound):
+		return http.StatusNotFound, CodeScheduleNotFound
+	case errors.Is(err, services.ErrAlertNotFound):
+		return http.StatusNotFound, CodeAlertNotFound
+	case errors.Is(err, services.ErrBookmarkNotFound):
+		return http.StatusNotFound, CodeBookmarkNotFound
+	case errors.Is(err, services.ErrCaseNotFound):
+		return http.StatusNotFound, CodeCaseNotFound
+	case errors.Is(err, services.ErrPersonNotFound):
+		return http.StatusNotFound, CodePersonNotFound
+	case errors.Is(err, services.ErrCaseItemNotFound):
+		return http.StatusNotFound, CodeCaseItemNotFound
+	case errors.Is(err, services.ErrTaskNotFound):
+		return http.StatusNotFound, CodeTaskNotFound
+	case errors.Is(err, services.ErrAlreadyExists):
+		return http.StatusConflict, CodeAlreadyExists
+	case errors.Is(err, services.ErrBusy):
+		return http.StatusConflict, CodeBusy
+	case errors.Is(err, services.ErrStreamLimit):
+		return http.StatusServiceUnavailable, CodeStreamLimit
+	case errors.Is(err, services.ErrSidecarTimeout):
+		return http.StatusGatewayTimeout, CodeSidecarTimeout
+	case errors.Is(err, services.ErrSidecarUnavailable):
+		return http.StatusServiceUnavailable, CodeSidecarUnavailable
+	case errors.Is(err, services.ErrSidecarFailed):
+		return http.StatusBadGateway, CodeSidecarError
+	case errors.Is(err, services.ErrNVRAuth):
+		return http.StatusBadGateway, CodeNVRAuthFailed
+	case errors.Is(err, services.ErrNVRUnreachable):
+		return http.StatusBadGateway, CodeNVRUnreachable
+	case errors.Is(err, services.ErrNVRTimeout):
+		return http.StatusGatewayTimeout, CodeNVRTimeout
+	case errors.Is(err, services.ErrNVRFailed):
+		return http.StatusBadGateway, CodeNVRError
+	default:
+		return http.StatusInternalServerError, CodeInternal
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/services"
+)
+
+// ServeFrames serves extracted frame images from the frames storage path
+// with path traversal protection. ?w= and ?h= ask for the frame fitted
+// within that many pixels, a cached variant (see services.ResizedFrame).
+func ServeFrames(cfg *config.AppConfig) http.HandlerFunc {
+	framesDir := cfg.Extraction.StoragePath
+	absFramesDir, _ := filepath.Abs(framesDir)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Extract path after /api/frames/
+		framePath := chi.URLParam(r, "*")
+		if framePath == "" {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "frame path required")
+			return
+		}
+
+		filePath := filepath.Join(framesDir, framePath)
+
+		// Path traversal protection
+		absPath, err := filepath.Abs(filePath)
+		if err != nil || !strings.HasPrefix(absPath, absFramesDir) {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid frame path")
+			return
+		}
+
+		fi, err := os.Stat(absPath)
+		if err != nil || fi.IsDir() {
+			writeError(w, http.StatusNotFound, CodeNotFound, "frame not found")
+			return
+		}
+
+		var width, height int
+		for name, dst := range map[string]*int{"w": &width, "h": &height} {
+			if v := r.URL.Query().Get(name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%s must be a positive integer", name)
+					return
+				}
+				*dst = n
+			}
+		}
+		if width > 0 || height > 0 {
+			cacheDir, err := services.FrameSizesDir(cfg, absPath)
+			if err == nil {
+				absPath, err = services.ResizedFrame(r.Context(), absPath, cacheDir, width, height)
+			}
+			if err != nil {
+				if r.Context().Err() == nil {
+					writeServiceError(w, err)
+				}
+				return
+			}
+			if fi, err = os.Stat(absPath); err != nil {
+				writeError(w, http.StatusNotFound, CodeNotFound, "frame not found")
+				return
+			}
+		}
+
+		// Frames never change once extracted (re-extraction or blurring
+		// produces a new mtime, and so a new variant), so let clients cache
+		// and revalidate cheaply.
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Header().Set("ETag", fileETag(fi))
+		http.ServeFile(w, r, absPath)
+	}
+}
+
+// fileETag builds a weak validator from a file's size and modification time,
+// which is enough to detect re-extraction without hashing the content.
+// http.ServeFile/ServeContent honour If-None-Match against this header.
+func fileETag(fi os.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, fi.Size(), fi.ModTime().UnixNano())
+}
+
+// serveBytesConditional writes an in-memory body with a content-hash ETag,
+// answering 304 Not Modified when the client's If-None-Match matches.
+func serveBytesConditional(w http.ResponseWriter, r *http.Request, data []byte) {
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
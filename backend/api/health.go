@@ -0,0 +1,239 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+// healthProbeTTL is how long the results of the NVR and sidecar probes are
+// reused, so polling /api/health doesn't turn into traffic to either.
+const healthProbeTTL = 5 * time.Second
+
+type HealthHandler struct {
+	cfg      *config.AppConfig
+	encoder  services.Encoder
+	storage  *services.Storage
+	settings *services.SettingsService
+	streamer *services.Streamer
+	process  *ProcessHandler
+	cameras  *CamerasHandler
+
+	sidecar probeCache
+	nvr     probeCache
+}
+
+// probeCache holds the last result of a component check. Callers arriving
+// while the check runs wait for it rather than starting their own.
+type probeCache struct {
+	mu     sync.Mutex
+	at     time.Time
+	result models.ComponentHealth
+}
+
+// get returns the cached result, running check when it is older than
+// healthProbeTTL.
+func (c *probeCache) get(check func() models.ComponentHealth) models.ComponentHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.at.IsZero() || time.Since(c.at) >= healthProbeTTL {
+		c.result = check()
+		c.at = time.Now()
+	}
+	return c.result
+}
+
+func NewHealthHandler(cfg *config.AppConfig, encoder services.Encoder, storage *services.Storage, settings *services.SettingsService, streamer *services.Streamer, process *ProcessHandler, cameras *CamerasHandler) *HealthHandler {
+	return &HealthHandler{
+		cfg:      cfg,
+		encoder:  encoder,
+		storage:  storage,
+		settings: settings,
+		streamer: streamer,
+		process:  process,
+		cameras:  cameras,
+	}
+}
+
+// Health reports component-level status. Checks run concurrently so a slow
+// NVR or sidecar does not delay the others, and their results are reused
+// for healthProbeTTL. The response is always 200; the top-level status is
+// "degraded" when any component reports an error. The endpoint is public,
+// so it leaves out paths and addresses.
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func() models.ComponentHealth{
+		"database":   h.checkDatabase,
+		"disk":       h.checkDisk,
+		"ffmpeg":     func() models.ComponentHealth { return checkTool("ffmpeg") },
+		"ffprobe":    func() models.ComponentHealth { return checkTool("ffprobe") },
+		"ml_sidecar": func() models.ComponentHealth { return h.sidecar.get(h.checkSidecar) },
+		"nvr":        func() models.ComponentHealth { return h.nvr.get(h.checkNVR) },
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	components := make(map[string]models.ComponentHealth, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := check()
+			mu.Lock()
+			components[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	resp := models.HealthResponse{
+		Status:        "ok",
+		MLSidecar:     "ok",
+		Components:    components,
+		ActiveJobs:    h.process.ActiveJobCount(),
+		ActiveUploads: h.cameras.ActiveUploadCount(),
+		ActiveStreams: h.streamer.ActiveCount(),
+	}
+	if components["ml_sidecar"].Status != "ok" {
+		resp.MLSidecar = "unavailable"
+	}
+	for _, c := range components {
+		if c.Status == "error" {
+			resp.Status = "degraded"
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Liveness answers /healthz: the process is up and serving HTTP. It checks
+// no dependencies so orchestrators only restart the container when it is
+// truly wedged.
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readiness answers /readyz: 200 when the database is usable and the ML
+// sidecar is reachable, 503 otherwise. With mlservice.allow_degraded an
+// unreachable sidecar still yields 200 but with status "degraded".
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"config": "ok", "database": "ok", "ml_sidecar": "ok"}
+	status := "ok"
+	code := http.StatusOK
+
+	if err := h.storage.DB().PingContext(r.Context()); err != nil {
+		checks["database"] = healthError(err, "database unavailable")
+		status = "not_ready"
+		code = http.StatusServiceUnavailable
+	}
+	if err := h.encoder.HealthCheck(); err != nil {
+		checks["ml_sidecar"] = healthError(err, "ML sidecar unavailable")
+		if !h.cfg.MLService.AllowDegraded {
+			status = "not_ready"
+			code = http.StatusServiceUnavailable
+		} else if status == "ok" {
+			status = "degraded"
+		}
+	}
+
+	writeJSON(w, code, map[string]any{"status": status, "checks": checks})
+}
+
+func (h *HealthHandler) checkDatabase() models.ComponentHealth {
+	details := map[string]any{}
+	if fi, err := os.Stat(h.cfg.Storage.DBPath); err == nil {
+		details["size_bytes"] = fi.Size()
+	}
+	if err := h.storage.CheckWritable(); err != nil {
+		return models.ComponentHealth{Status: "error", Error: healthError(err, "database not writable"), Details: details}
+	}
+	return models.ComponentHealth{Status: "ok", Details: details}
+}
+
+func (h *HealthHandler) checkDisk() models.ComponentHealth {
+	usage, err := services.GetDiskUsage(h.cfg.App.DataDir)
+	if err != nil {
+		return models.ComponentHealth{Status: "error", Error: healthError(err, "disk usage unavailable")}
+	}
+	// Below the alerts.disk_low_gb alert threshold the disk is a warning
+	status := "ok"
+	if low := h.settings.GetFloat64("alerts.disk_low_gb"); low > 0 && float64(usage.FreeBytes) < low*(1<<30) {
+		status = "warning"
+	}
+	return models.ComponentHealth{Status: status, Details: map[string]any{
+		"free_bytes":  usage.FreeBytes,
+		"total_bytes": usage.TotalBytes,
+	}}
+}
+
+func checkTool(name string) models.ComponentHealth {
+	version, err := services.ToolVersion(name)
+	if err != nil {
+		return models.ComponentHealth{Status: "error", Error: healthError(err, name+" not found")}
+	}
+	return models.ComponentHealth{Status: "ok", Details: map[string]any{"version": version}}
+}
+
+func (h *HealthHandler) checkSidecar() models.ComponentHealth {
+	details := map[string]any{}
+	switch enc := h.encoder.(type) {
+	case *services.MLClient:
+		details["circuit"] = enc.CircuitState()
+		if instances := enc.Instances(); len(instances) > 1 {
+			list := make([]map[string]any, len(instances))
+			for i, in := range instances {
+				list[i] = map[string]any{"circuit": in.Circuit, "in_flight": in.InFlight}
+			}
+			details["instances"] = list
+		}
+		if requests := enc.Requests(); requests != nil {
+			details["requests"] = requests
+		}
+		if size := enc.TunedBatchSize(); size > 0 {
+			details["batch_size"] = size
+		}
+	case *services.ONNXEncoder:
+		details["backend"] = services.BackendONNX
+	}
+	if err := h.encoder.HealthCheck(); err != nil {
+		return models.ComponentHealth{Status: "error", Error: healthError(err, "ML sidecar unavailable"), Details: details}
+	}
+	info, err := h.encoder.GetModelInfo()
+	if err != nil {
+		return models.ComponentHealth{Status: "warning", Error: healthError(err, "model info unavailable"), Details: details}
+	}
+	details["preset"] = info.Preset
+	details["model"] = info.Model
+	details["embedding_dim"] = info.EmbeddingDim
+	if info.Status != "" {
+		details["model_status"] = info.Status
+	}
+	return models.ComponentHealth{Status: "ok", Details: details}
+}
+
+func (h *HealthHandler) checkNVR() models.ComponentHealth {
+	ip := h.settings.Get("nvr.ip")
+	if ip == "" {
+		return models.ComponentHealth{Status: "not_configured"}
+	}
+	client := services.NewHikvisionClient(ip, h.settings.Get("nvr.username"), h.settings.Get("nvr.password"))
+	client.SetTimeout(5 * time.Second)
+	if err := client.Ping(); err != nil {
+		return models.ComponentHealth{Status: "error", Error: healthError(err, "NVR unreachable")}
+	}
+	return models.ComponentHealth{Status: "ok"}
+}
+
+// healthError describes err for the public health endpoints by its error
+// code, or by fallback when it has none: the messages themselves hold
+// URLs and paths.
+func healthError(err error, fallback string) string {
+	if _, code := classifyError(err); code != CodeInternal {
+		return code
+	}
+	return fallback
+}
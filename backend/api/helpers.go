@@ -2,9 +2,11 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
-
-	"github.com/intelsk/backend/services"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -13,14 +15,64 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	json.NewEncoder(w).Encode(v)
 }
 
-func HealthCheck(w http.ResponseWriter, r *http.Request, mlClient *services.MLClient) {
-	mlStatus := "ok"
-	if err := mlClient.HealthCheck(); err != nil {
-		mlStatus = "unavailable"
+// pageParams holds the common limit/offset/sort query parameters accepted by
+// list endpoints. A zero Limit means "no limit".
+type pageParams struct {
+	Limit  int
+	Offset int
+	Sort   string
+	Desc   bool
+}
+
+// parsePageParams reads ?limit=, ?offset=, ?sort= and ?order= from the query.
+// sort must be one of allowedSorts; when omitted Sort is left empty and the
+// handler keeps its natural ordering.
+func parsePageParams(r *http.Request, allowedSorts ...string) (pageParams, error) {
+	q := r.URL.Query()
+	var p pageParams
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("limit must be a non-negative integer")
+		}
+		p.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("offset must be a non-negative integer")
+		}
+		p.Offset = n
+	}
+
+	if v := q.Get("sort"); v != "" {
+		if !slices.Contains(allowedSorts, v) {
+			return p, fmt.Errorf("sort must be one of: %s", strings.Join(allowedSorts, ", "))
+		}
+		p.Sort = v
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		p.Desc = true
+	default:
+		return p, fmt.Errorf("order must be 'asc' or 'desc'")
 	}
+	return p, nil
+}
 
-	writeJSON(w, http.StatusOK, map[string]string{
-		"status":     "ok",
-		"ml_sidecar": mlStatus,
-	})
+// paginate slices items according to p and sets the X-Total-Count header so
+// clients can render page controls without a separate count request.
+func paginate[T any](w http.ResponseWriter, items []T, p pageParams) []T {
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(items)))
+	if p.Offset >= len(items) {
+		return []T{}
+	}
+	items = items[p.Offset:]
+	if p.Limit > 0 && p.Limit < len(items) {
+		items = items[:p.Limit]
+	}
+	return items
 }
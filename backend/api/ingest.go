@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/services"
+)
+
+// IngestHandler serves continuous NVR ingest: the cameras with auto_ingest
+// set in their config are polled every ingest.interval_min minutes, and
+// their new recordings downloaded, extracted and indexed by ingest jobs.
+type IngestHandler struct {
+	cfg       *config.AppConfig
+	ingester  *services.Ingester
+	process   *ProcessHandler
+	cameraSvc *services.CameraService
+	jobs      *services.JobManager
+}
+
+func NewIngestHandler(cfg *config.AppConfig, ingester *services.Ingester, process *ProcessHandler, cameraSvc *services.CameraService, jobs *services.JobManager) *IngestHandler {
+	h := &IngestHandler{
+		cfg:       cfg,
+		ingester:  ingester,
+		process:   process,
+		cameraSvc: cameraSvc,
+		jobs:      jobs,
+	}
+	jobs.Register(services.JobTypeIngest, h.runIngest)
+	return h
+}
+
+// List returns the ingest state of every camera with auto_ingest set.
+func (h *IngestHandler) List(w http.ResponseWriter, r *http.Request) {
+	states, err := h.ingester.List()
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, states)
+}
+
+// Run polls a camera now and returns the ingest job it started.
+func (h *IngestHandler) Run(w http.ResponseWriter, r *http.Request) {
+	job, err := h.ingester.RunNow(chi.URLParam(r, "id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	info, err := h.jobs.Get(job.ID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, info)
+}
+
+// runIngest is the JobRunner of ingest jobs: it downloads a camera's
+// recordings finished since its cursor, then extracts and indexes the
+// dates they fall on. Recordings downloaded before a failure are still
+// indexed.
+func (h *IngestHandler) runIngest(ctx context.Context, job *services.Job, emit func(services.ProgressEvent)) error {
+	var params services.IngestParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return fmt.Errorf("invalid job params: %w", err)
+	}
+	cam, err := h.cameraSvc.Get(params.CameraID)
+	if err != nil {
+		return err
+	}
+
+	events := make(chan services.ProgressEvent, 64)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for ev := range events {
+			emit(ev)
+		}
+	}()
+	defer func() {
+		close(events)
+		<-forwarded
+	}()
+
+	if err := job.WaitForSlot(ctx); err != nil {
+		return err
+	}
+	dates, downloadErr := h.ingester.Download(ctx, cam, events)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(dates) > 0 {
+		h.cameraSvc.InvalidateThumbnail(cam.ID)
+		pipeline, err := h.process.newPipeline(ctx, events)
+		if err != nil {
+			return err
+		}
+		opts := services.ExtractionOptionsFromSettings(h.process.settings)
+		for _, date := range dates {
+			unlock, err := job.Lock(ctx, services.JobKey(cam.ID, date))
+			if err != nil {
+				return err
+			}
+			services.ProcessCameraDate(h.cfg, pipeline, opts, cam.ID, date, events)
+			unlock()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+	if downloadErr != nil {
+		return downloadErr
+	}
+
+	events <- services.ProgressEvent{Stage: "complete", CameraID: cam.ID, Message: fmt.Sprintf("ingested %d date(s)", len(dates))}
+	return nil
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+// JobsHandler serves the background jobs of every type (processing runs and
+// upload extract/index jobs) recorded by the JobManager.
+type JobsHandler struct {
+	jobs *services.JobManager
+}
+
+func NewJobsHandler(jobs *services.JobManager) *JobsHandler {
+	return &JobsHandler{jobs: jobs}
+}
+
+// List returns the recorded jobs, newest first, optionally filtered by
+// ?type= (process, upload) and ?status=, and paginated with ?limit=/?offset=.
+func (h *JobsHandler) List(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+
+	jobs, err := h.jobs.List(r.URL.Query().Get("type"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filtered := make([]models.Job, 0, len(jobs))
+		for _, job := range jobs {
+			if job.Status == status {
+				filtered = append(filtered, job)
+			}
+		}
+		jobs = filtered
+	}
+	writeJSON(w, http.StatusOK, paginate(w, jobs, page))
+}
+
+func (h *JobsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Get(chi.URLParam(r, "job_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// Cancel stops a running or queued job; it ends with status "cancelled".
+func (h *JobsHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "job_id")
+	if err := h.jobs.Cancel(jobID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, models.ProcessResponse{JobID: jobID, Status: "cancelling"})
+}
+
+// Retry starts a new job with the type and params of a finished one and
+// returns it; its retry_of names the original.
+func (h *JobsHandler) Retry(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Retry(chi.URLParam(r, "job_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	info, err := h.jobs.Get(job.ID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, info)
+}
+
+// Events streams a job's progress events (SSE), like /process/status.
+func (h *JobsHandler) Events(w http.ResponseWriter, r *http.Request) {
+	mu, events, done, err := h.jobs.EventLog(chi.URLParam(r, "job_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	serveEventStream(w, r, mu, events, done)
+}
+
+// Log returns a job's full log: every progress event and the log lines
+// (ffmpeg errors, sidecar and NVR failures) recorded while it ran, oldest
+// first. ?format=text returns it as plain text, one entry per line.
+func (h *JobsHandler) Log(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.jobs.Log(chi.URLParam(r, "job_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if r.URL.Query().Get("format") != "text" {
+		writeJSON(w, http.StatusOK, entries)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, e := range entries {
+		fmt.Fprintln(w, FormatJobLogEntry(e))
+	}
+}
+
+// FormatJobLogEntry renders an entry as "time [stage] message" for events
+// and "time log: message" for log lines.
+func FormatJobLogEntry(e models.JobLogEntry) string {
+	at := e.Time.Format("2006-01-02 15:04:05.000")
+	if e.Kind != "event" {
+		return fmt.Sprintf("%s %s: %s", at, e.Kind, e.Message)
+	}
+	var ev services.ProgressEvent
+	json.Unmarshal(e.Event, &ev)
+	return fmt.Sprintf("%s [%s] %s", at, ev.Stage, e.Message)
+}
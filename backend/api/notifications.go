@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/services"
+)
+
+type NotificationsHandler struct {
+	notifier *services.Notifier
+}
+
+func NewNotificationsHandler(notifier *services.Notifier) *NotificationsHandler {
+	return &NotificationsHandler{notifier: notifier}
+}
+
+// Channels lists the notification channels and whether each is configured.
+func (h *NotificationsHandler) Channels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.notifier.Channels())
+}
+
+// Test sends a test notification through one channel with the current
+// settings. Like NVRStatus, a delivery failure is reported in the body
+// rather than as an error status.
+func (h *NotificationsHandler) Test(w http.ResponseWriter, r *http.Request) {
+	err := h.notifier.Test(r.Context(), chi.URLParam(r, "channel"))
+	if errors.Is(err, services.ErrInvalidInput) {
+		writeServiceError(w, err)
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "sent"})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+// PersonsHandler serves the persons enrolled for face search, who are
+// enrolled with the faces CLI, and the erasure of their data.
+type PersonsHandler struct {
+	faces *services.FaceService
+}
+
+func NewPersonsHandler(faces *services.FaceService) *PersonsHandler {
+	return &PersonsHandler{faces: faces}
+}
+
+// List returns the enrolled persons sorted by name.
+func (h *PersonsHandler) List(w http.ResponseWriter, r *http.Request) {
+	persons, err := h.faces.Persons()
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, persons)
+}
+
+// Erase deletes or anonymizes a person's face embeddings, optionally blurs
+// or deletes the frames they appear in, unenrolls them and returns the
+// erasure report. An empty body erases with the defaults.
+func (h *PersonsHandler) Erase(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	var req models.ErasureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	report, err := h.faces.Erase(name, req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if !report.DryRun {
+		// The report names the person; the log only says an erasure ran
+		log.Printf("Erasure: %d faces %sd, %d frames blurred, %d deleted, %d errors",
+			report.FaceEmbeddings, report.Embeddings, report.FramesBlurred, report.FramesDeleted, len(report.Errors))
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -1,17 +1,17 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
+	"sort"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/intelsk/backend/config"
 	"github.com/intelsk/backend/models"
 	"github.com/intelsk/backend/services"
@@ -19,55 +19,86 @@ import (
 
 type ProcessHandler struct {
 	cfg       *config.AppConfig
-	mlClient  *services.MLClient
+	encoder   services.Encoder
 	storage   *services.Storage
 	settings  *services.SettingsService
 	cameraSvc *services.CameraService
-
-	mu         sync.Mutex
-	activeJobs map[string]*jobState
+	jobs      *services.JobManager
+	workers   *services.WorkerPool
 }
 
-type jobState struct {
-	ID       string
-	Status   string // "running", "complete", "failed"
-	Error    string
-	Events   []services.ProgressEvent
-	eventCh  chan services.ProgressEvent
-	doneCh   chan struct{}
+func NewProcessHandler(cfg *config.AppConfig, encoder services.Encoder, workers *services.WorkerPool, storage *services.Storage, settings *services.SettingsService, cameraSvc *services.CameraService, jobs *services.JobManager) *ProcessHandler {
+	h := &ProcessHandler{
+		cfg:       cfg,
+		encoder:   encoder,
+		workers:   workers,
+		storage:   storage,
+		settings:  settings,
+		cameraSvc: cameraSvc,
+		jobs:      jobs,
+	}
+	jobs.Register(services.JobTypeProcess, h.runPipeline)
+	jobs.KeyBy(services.JobTypeProcess, processJobKeys)
+	return h
 }
 
-func NewProcessHandler(cfg *config.AppConfig, mlClient *services.MLClient, storage *services.Storage, settings *services.SettingsService, cameraSvc *services.CameraService) *ProcessHandler {
-	return &ProcessHandler{
-		cfg:        cfg,
-		mlClient:   mlClient,
-		storage:    storage,
-		settings:   settings,
-		cameraSvc:  cameraSvc,
-		activeJobs: make(map[string]*jobState),
+// processJobKeys keys a processing job by each camera and date it covers,
+// and the hours of them, if it covers only some; a job for some hours then
+// only joins one for the same hours.
+func processJobKeys(params json.RawMessage) []string {
+	var req models.ProcessRequest
+	if json.Unmarshal(params, &req) != nil {
+		return nil
+	}
+	dates, err := services.DateRange(req.StartDate, req.EndDate)
+	if err != nil {
+		return nil
 	}
+	hours, err := services.NewHourWindow(req.StartHour, req.EndHour)
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	for _, camID := range req.CameraIDs {
+		for _, date := range dates {
+			key := services.JobKey(camID, date)
+			if hours != nil {
+				key += " " + hours.String()
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
 
 func (h *ProcessHandler) Start(w http.ResponseWriter, r *http.Request) {
 	var req models.ProcessRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
 		return
 	}
 
 	if len(req.CameraIDs) == 0 || req.StartDate == "" {
-		http.Error(w, `{"error":"camera_ids and start_date are required"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "camera_ids and start_date are required")
 		return
 	}
 
 	if req.EndDate == "" {
 		req.EndDate = req.StartDate
 	}
+	if req.Priority < services.MinJobPriority || req.Priority > services.MaxJobPriority {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "priority must be between %d and %d", services.MinJobPriority, services.MaxJobPriority)
+		return
+	}
+	if _, err := services.NewHourWindow(req.StartHour, req.EndHour); err != nil {
+		writeServiceError(w, err)
+		return
+	}
 
 	// Quick check: any new videos to process across all cameras × dates?
 	// Skip cache check for hikvision cameras (they need NVR download first)
-	history := loadProcessHistory(h.cfg.Process.HistoryPath)
-	dates, err := dateRange(req.StartDate, req.EndDate)
+	history := services.LoadProcessHistory(h.cfg.Process.HistoryPath)
+	dates, err := services.DateRange(req.StartDate, req.EndDate)
 	if err == nil {
 		allCached := true
 		for _, camID := range req.CameraIDs {
@@ -78,7 +109,7 @@ func (h *ProcessHandler) Start(w http.ResponseWriter, r *http.Request) {
 			}
 			for _, date := range dates {
 				videosDir := filepath.Join(h.cfg.App.DataDir, "videos", camID, date)
-				if len(newVideosForDate(history, camID, date, videosDir)) > 0 {
+				if len(services.NewVideosForDate(history, camID, date, videosDir)) > 0 {
 					allCached = false
 					break
 				}
@@ -96,537 +127,332 @@ func (h *ProcessHandler) Start(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Create job
-	jobID := fmt.Sprintf("job_%d", time.Now().UnixMilli())
-	job := &jobState{
-		ID:      jobID,
-		Status:  "running",
-		eventCh: make(chan services.ProgressEvent, 64),
-		doneCh:  make(chan struct{}),
+	// Run pipeline in background, unless a running job already covers
+	// every camera and date
+	job, existing, err := h.jobs.SubmitWithPriority(services.JobTypeProcess, req, req.Priority)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if existing {
+		writeJSON(w, http.StatusOK, models.ProcessResponse{
+			JobID:  job.ID,
+			Status: "already_running",
+		})
+		return
 	}
 
-	h.mu.Lock()
-	h.activeJobs[jobID] = job
-	h.mu.Unlock()
-
-	// Run pipeline in background
-	go h.runPipeline(job, req)
-
+	status := "started"
+	if !services.ProcessWindowFromSettings(h.settings).Open(time.Now()) {
+		status = "queued"
+	}
 	writeJSON(w, http.StatusAccepted, models.ProcessResponse{
-		JobID:  jobID,
-		Status: "started",
+		JobID:  job.ID,
+		Status: status,
 	})
 }
 
-func (h *ProcessHandler) runPipeline(job *jobState, req models.ProcessRequest) {
-	defer close(job.doneCh)
+// TrackedJobCount returns the number of processing jobs (running or
+// recently finished) held in memory.
+func (h *ProcessHandler) TrackedJobCount() int {
+	return h.jobs.TrackedCount(services.JobTypeProcess)
+}
 
-	// Wait for ML sidecar to be ready before starting
-	job.eventCh <- services.ProgressEvent{
-		Stage:   "waiting",
-		Message: "waiting for ML sidecar...",
+// ActiveJobCount returns the number of processing jobs still running or
+// queued for the processing window.
+func (h *ProcessHandler) ActiveJobCount() int {
+	return h.jobs.ActiveCount(services.JobTypeProcess)
+}
+
+// runPipeline is the JobRunner of processing jobs: it downloads NVR
+// recordings where needed, then extracts and indexes every camera and date
+// of the request, most recent date first. Between dates it gives its slot
+// to higher-priority jobs waiting for one.
+func (h *ProcessHandler) runPipeline(ctx context.Context, job *services.Job, emit func(services.ProgressEvent)) error {
+	var req models.ProcessRequest
+	if err := json.Unmarshal(job.Params, &req); err != nil {
+		return fmt.Errorf("invalid job params: %w", err)
 	}
-	if err := h.mlClient.WaitForReady(120 * time.Second); err != nil {
-		job.eventCh <- services.ProgressEvent{
-			Stage:   "error",
-			Message: fmt.Sprintf("ML sidecar not ready: %v", err),
-		}
-		close(job.eventCh)
-		h.mu.Lock()
-		job.Status = "failed"
-		job.Error = err.Error()
-		h.mu.Unlock()
-		return
+	dates, err := services.DateRange(req.StartDate, req.EndDate)
+	if err != nil {
+		return fmt.Errorf("invalid date range: %w", err)
 	}
+	hours, err := services.NewHourWindow(req.StartHour, req.EndHour)
+	if err != nil {
+		return err
+	}
+	// Last night's footage is usually wanted before the rest of a backfill.
+	// The NVR download keeps the request's order, as start_time and
+	// end_time bound its first and last date.
+	newestFirst := slices.Clone(dates)
+	slices.Reverse(newestFirst)
 
-	pipeline := services.NewPipeline(h.mlClient, h.storage, h.settings.GetInt("clip.batch_size"))
+	// The work is one task per camera and date, NVR cameras downloading
+	// first; events are forwarded with the job's percent complete and ETA
+	var tasks []services.ProgressTask
+	for _, camID := range req.CameraIDs {
+		cam, err := h.cameraSvc.Get(camID)
+		download := err == nil && cam.Type == "hikvision"
+		for _, date := range newestFirst {
+			tasks = append(tasks, services.ProgressTask{CameraID: camID, Date: date, Download: download})
+		}
+	}
+	progress := services.NewJobProgress(tasks)
 
-	// Collect events from pipeline into job state
+	// The pipeline and NVR download report progress on a channel
+	events := make(chan services.ProgressEvent, 64)
+	forwarded := make(chan struct{})
 	go func() {
-		for ev := range job.eventCh {
-			h.mu.Lock()
-			job.Events = append(job.Events, ev)
-			h.mu.Unlock()
+		defer close(forwarded)
+		for ev := range events {
+			emit(progress.Update(ev))
 		}
 	}()
+	defer func() {
+		close(events)
+		<-forwarded
+	}()
 
-	for _, camID := range req.CameraIDs {
-		dates, err := dateRange(req.StartDate, req.EndDate)
-		if err != nil {
-			h.mu.Lock()
-			job.Status = "failed"
-			job.Error = fmt.Sprintf("invalid date range: %v", err)
-			h.mu.Unlock()
-			close(job.eventCh)
-			return
+	// Outside the processing window, queue until it opens
+	window := func() services.ProcessWindow { return services.ProcessWindowFromSettings(h.settings) }
+	if w := window(); !w.Open(time.Now()) {
+		job.SetStatus("queued")
+		events <- services.ProgressEvent{
+			Stage:   "queued",
+			Message: fmt.Sprintf("queued until the processing window (%s) opens at %s", w, w.NextOpen(time.Now()).Format("15:04")),
 		}
-
-		// Check if this is a hikvision camera — download recordings from NVR
-		cam, camErr := h.cameraSvc.Get(camID)
-		if camErr == nil && cam.Type == "hikvision" {
-			h.downloadFromNVR(job, cam, dates, req.StartTime, req.EndTime)
+		if err := services.WaitForProcessWindow(ctx, window); err != nil {
+			return err
 		}
+		job.SetStatus("running")
+	}
 
-		for _, date := range dates {
-			videosDir := filepath.Join(h.cfg.App.DataDir, "videos", camID, date)
-			framesDir := filepath.Join(h.cfg.Extraction.StoragePath, camID, date)
-
-			// Determine which videos still need processing
-			history := loadProcessHistory(h.cfg.Process.HistoryPath)
-			videosToProcess := newVideosForDate(history, camID, date, videosDir)
-
-			if len(videosToProcess) == 0 {
-				job.eventCh <- services.ProgressEvent{
-					Stage:    "skipped",
-					CameraID: camID,
-					Message:  fmt.Sprintf("%s/%s already indexed", camID, date),
-				}
-				continue
-			}
-
-			// Step 1: Extract frames from new videos only
-			job.eventCh <- services.ProgressEvent{
-				Stage:    "extracting",
-				CameraID: camID,
-				Message:  fmt.Sprintf("extracting frames from %d video(s) for %s/%s", len(videosToProcess), camID, date),
-			}
-
-			// Load existing manifest (frames from previously processed videos)
-			existingFrames, _ := services.LoadManifest(framesDir)
-			var newFrames []models.FrameMetadata
-
-			for _, videoFile := range videosToProcess {
-				videoPath := filepath.Join(videosDir, videoFile)
-				frames, err := services.ExtractFramesTime(
-					videoPath, framesDir,
-					h.settings.GetInt("extraction.time_interval_sec"),
-					h.settings.GetInt("extraction.output_quality"),
-				)
-				if err != nil {
-					log.Printf("extraction failed for %s: %v", videoPath, err)
-					continue
-				}
+	// Then for a job slot, so runs don't all compete for ffmpeg and the
+	// sidecar at once
+	if err := job.WaitForSlot(ctx); err != nil {
+		return err
+	}
 
-				if h.settings.GetBool("extraction.dedup_enabled") {
-					frames, _ = services.DeduplicateFrames(frames, h.settings.GetInt("extraction.dedup_phash_threshold"))
-				}
+	pipeline, err := h.newPipeline(ctx, events)
+	if err != nil {
+		return err
+	}
 
-				newFrames = append(newFrames, frames...)
+	opts := services.ExtractionOptionsFromSettings(h.settings)
+	opts.Hours = hours
+	for _, camID := range req.CameraIDs {
+		// Check if this is a hikvision camera — download recordings from NVR
+		cam, camErr := h.cameraSvc.Get(camID)
+		if camErr == nil && cam.Type == "hikvision" {
+			if err := job.YieldSlot(ctx); err != nil {
+				return err
 			}
-
-			// Merge with existing and write combined manifest
-			allFrames := append(existingFrames, newFrames...)
-			if err := services.WriteManifest(framesDir, allFrames); err != nil {
-				log.Printf("writing manifest for %s/%s: %v", camID, date, err)
+			if services.DownloadFromNVR(ctx, h.cfg, h.settings, cam, dates, req.StartTime, req.EndTime, hours, job.Resumes > 0, events) > 0 {
+				h.cameraSvc.InvalidateThumbnail(cam.ID)
 			}
+		}
 
-			// Step 2: Index frames (pipeline handles incrementality via index_state.json)
-			manifest := filepath.Join(framesDir, "manifest.json")
-			if _, err := os.Stat(manifest); err != nil {
-				job.eventCh <- services.ProgressEvent{
-					Stage:    "error",
-					CameraID: camID,
-					Message:  fmt.Sprintf("no manifest for %s/%s after extraction", camID, date),
-				}
-				continue
+		for _, date := range newestFirst {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-
-			job.eventCh <- services.ProgressEvent{
-				Stage:    "indexing",
-				CameraID: camID,
-				Message:  fmt.Sprintf("indexing frames for %s/%s", camID, date),
+			if err := job.YieldSlot(ctx); err != nil {
+				return err
 			}
-
-			if err := pipeline.IndexFrames(framesDir, job.eventCh); err != nil {
-				log.Printf("indexing failed for %s/%s: %v", camID, date, err)
-				job.eventCh <- services.ProgressEvent{
-					Stage:    "error",
-					CameraID: camID,
-					Message:  fmt.Sprintf("indexing failed: %v", err),
-				}
-				continue
+			// Another job may be on the same camera and date; the history
+			// then skips what it indexed
+			unlock, err := job.Lock(ctx, services.JobKey(camID, date))
+			if err != nil {
+				return err
 			}
-
-			// Record all videos for this camera+date in process history
-			allVideoFiles := ListVideoFiles(videosDir)
-			AddProcessHistory(h.cfg.Process.HistoryPath, camID, date, allVideoFiles)
+			// Failures are reported as "error" events; carry on with the rest
+			services.ProcessCameraDate(h.cfg, pipeline, opts, camID, date, events)
+			unlock()
 		}
 	}
-
-	job.eventCh <- services.ProgressEvent{Stage: "complete", Message: "all processing complete"}
-	close(job.eventCh)
-
-	h.mu.Lock()
-	job.Status = "complete"
-	h.mu.Unlock()
-}
-
-// parseHHMM parses an "HH:MM" string into hour and minute components.
-func parseHHMM(s string) (hour, minute int, ok bool) {
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return 0, 0, false
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
-	parts := strings.SplitN(s, ":", 2)
-	if len(parts) != 2 {
-		return 0, 0, false
-	}
-	h, err1 := strconv.Atoi(parts[0])
-	m, err2 := strconv.Atoi(parts[1])
-	if err1 != nil || err2 != nil || h < 0 || h > 23 || m < 0 || m > 59 {
-		return 0, 0, false
-	}
-	return h, m, true
+
+	progress.Finish()
+	events <- services.ProgressEvent{Stage: "complete", Message: "all processing complete"}
+	return nil
 }
 
-// downloadFromNVR downloads recordings from the NVR for a hikvision camera.
-// startTime and endTime are optional "HH:MM" strings that constrain the query
-// window on the first and last date respectively.
-// Returns true if any new recordings were downloaded.
-func (h *ProcessHandler) downloadFromNVR(job *jobState, cam *models.CameraInfo, dates []string, startTime, endTime string) bool {
-	nvrIP := h.settings.Get("nvr.ip")
-	if nvrIP == "" {
-		job.eventCh <- services.ProgressEvent{
-			Stage:    "error",
-			CameraID: cam.ID,
-			Message:  "NVR IP not configured in settings",
+// newPipeline returns a pipeline for a job running with ctx, once the ML
+// sidecar is ready, or one that indexes on remote workers in worker mode.
+func (h *ProcessHandler) newPipeline(ctx context.Context, events chan<- services.ProgressEvent) (*services.Pipeline, error) {
+	pipeline := services.NewPipeline(h.encoder, h.storage, h.settings.GetInt("clip.batch_size")).
+		WithContext(ctx)
+	if h.workers.Enabled() {
+		// Frames are indexed by remote workers; batches wait in their queue
+		// until one picks them up
+		events <- services.ProgressEvent{
+			Stage:   "waiting",
+			Message: "indexing on remote workers",
 		}
-		return false
+		return pipeline.WithEncoder(h.workers), nil
 	}
-	nvrUsername := h.settings.Get("nvr.username")
-	nvrPassword := h.settings.Get("nvr.password")
-
-	nvrClient := services.NewHikvisionClient(nvrIP, nvrUsername, nvrPassword)
-
-	channel := services.NVRChannel(cam)
-	downloaded := 0
 
-	for i, date := range dates {
-		dayStart, err := time.Parse("2006-01-02", date)
+	// Wait for ML sidecar to be ready before starting; a cancel doesn't
+	// have to sit out the timeout
+	events <- services.ProgressEvent{
+		Stage:   "waiting",
+		Message: "waiting for ML sidecar...",
+	}
+	ready := make(chan error, 1)
+	go func() { ready <- h.encoder.WaitForReady(h.encoder.ReadyTimeout()) }()
+	select {
+	case err := <-ready:
 		if err != nil {
-			continue
-		}
-		dayEnd := dayStart.Add(24*time.Hour - time.Second)
-
-		// Apply time boundaries on the first and last dates
-		isFirst := i == 0
-		isLast := i == len(dates)-1
-		if isFirst {
-			if h, m, ok := parseHHMM(startTime); ok {
-				dayStart = dayStart.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute)
-			}
-		}
-		if isLast {
-			if h, m, ok := parseHHMM(endTime); ok {
-				dayEnd = time.Date(dayStart.Year(), dayStart.Month(), dayStart.Day(), h, m, 59, 0, dayStart.Location())
-				if !isFirst {
-					// For the last date (when it's a different day), use that date
-					lastDay, _ := time.Parse("2006-01-02", date)
-					dayEnd = time.Date(lastDay.Year(), lastDay.Month(), lastDay.Day(), h, m, 59, 0, lastDay.Location())
-				}
-			}
+			return nil, err
 		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return pipeline, nil
+}
 
-		job.eventCh <- services.ProgressEvent{
-			Stage:    "downloading",
-			CameraID: cam.ID,
-			Message:  fmt.Sprintf("Searching recordings for %s on %s", cam.Name, date),
-		}
+// JobInfo describes a processing job, for GET /process/jobs.
+type JobInfo struct {
+	JobID      string                  `json:"job_id"`
+	Status     string                  `json:"status"`
+	Error      string                  `json:"error,omitempty"`
+	CameraIDs  []string                `json:"camera_ids"`
+	StartDate  string                  `json:"start_date"`
+	EndDate    string                  `json:"end_date"`
+	Priority   int                     `json:"priority"`
+	CreatedAt  time.Time               `json:"created_at"`
+	FinishedAt *time.Time              `json:"finished_at,omitempty"`
+	Events     int                     `json:"events"`
+	LastEvent  *services.ProgressEvent `json:"last_event,omitempty"`
+}
 
-		recordings, err := nvrClient.SearchRecordings(channel, dayStart, dayEnd)
-		if err != nil {
-			log.Printf("NVR search failed for %s/%s: %v", cam.ID, date, err)
-			job.eventCh <- services.ProgressEvent{
-				Stage:    "error",
-				CameraID: cam.ID,
-				Message:  fmt.Sprintf("NVR search failed for %s: %v", date, err),
-			}
-			continue
-		}
+// Jobs lists the recorded processing jobs, newest first. GET /jobs lists
+// jobs of every type.
+func (h *ProcessHandler) Jobs(w http.ResponseWriter, r *http.Request) {
+	recorded, err := h.jobs.List(services.JobTypeProcess)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
 
-		if len(recordings) == 0 {
-			job.eventCh <- services.ProgressEvent{
-				Stage:    "downloading",
-				CameraID: cam.ID,
-				Message:  fmt.Sprintf("No recordings found for %s on %s", cam.Name, date),
-			}
-			continue
+	jobs := make([]JobInfo, 0, len(recorded))
+	for _, job := range recorded {
+		var req models.ProcessRequest
+		json.Unmarshal(job.Params, &req)
+		info := JobInfo{
+			JobID:      job.ID,
+			Status:     job.Status,
+			Error:      job.Error,
+			CameraIDs:  req.CameraIDs,
+			StartDate:  req.StartDate,
+			EndDate:    req.EndDate,
+			Priority:   job.Priority,
+			CreatedAt:  job.CreatedAt,
+			FinishedAt: job.FinishedAt,
+			Events:     job.Events,
 		}
-
-		videosDir := filepath.Join(h.cfg.App.DataDir, "videos", cam.ID, date)
-		os.MkdirAll(videosDir, 0o755)
-
-		// Clean up stale .tmp files from previous failed downloads
-		cleanTmpFiles(videosDir)
-
-		total := len(recordings)
-		for i, rec := range recordings {
-			filename := fmt.Sprintf("%s.mp4", rec.StartTime.Format("1504"))
-			outputPath := filepath.Join(videosDir, filename)
-
-			// Handle filename collision: if file exists, try _1, _2, etc.
-			if _, err := os.Stat(outputPath); err == nil {
-				base := rec.StartTime.Format("1504")
-				found := false
-				for j := 1; j <= 100; j++ {
-					candidate := filepath.Join(videosDir, fmt.Sprintf("%s_%d.mp4", base, j))
-					if _, err := os.Stat(candidate); os.IsNotExist(err) {
-						outputPath = candidate
-						filename = fmt.Sprintf("%s_%d.mp4", base, j)
-						found = true
-						break
-					}
-				}
-				if !found {
-					continue
-				}
-			}
-
-			job.eventCh <- services.ProgressEvent{
-				Stage:    "downloading",
-				CameraID: cam.ID,
-				Message:  fmt.Sprintf("Downloading %s %s-%s (%d/%d)...", cam.Name, rec.StartTime.Format("15:04"), rec.EndTime.Format("15:04"), i+1, total),
-			}
-
-			if err := nvrClient.DownloadClip(rec.PlaybackURI, outputPath); err != nil {
-				log.Printf("NVR download failed for %s: %v", filename, err)
-				job.eventCh <- services.ProgressEvent{
-					Stage:    "error",
-					CameraID: cam.ID,
-					Message:  fmt.Sprintf("Download failed for %s: %v", filename, err),
-				}
-				continue
-			}
-
-			// Transcode HEVC to H.264 if enabled for this camera
-			if services.ShouldTranscode(cam.Config) {
-				job.eventCh <- services.ProgressEvent{
-					Stage:    "transcoding",
-					CameraID: cam.ID,
-					Message:  fmt.Sprintf("Transcoding %s (%d/%d)...", filename, i+1, total),
-				}
-				if err := services.TranscodeIfNeeded(outputPath); err != nil {
-					log.Printf("Transcode failed for %s: %v", filename, err)
-					job.eventCh <- services.ProgressEvent{
-						Stage:    "error",
-						CameraID: cam.ID,
-						Message:  fmt.Sprintf("Transcode failed for %s: %v", filename, err),
-					}
-				}
-			}
-
-			downloaded++
+		var ev services.ProgressEvent
+		if job.LastEvent != nil && json.Unmarshal(job.LastEvent, &ev) == nil {
+			info.LastEvent = &ev
 		}
+		jobs = append(jobs, info)
 	}
-
-	if downloaded > 0 {
-		h.cameraSvc.InvalidateThumbnail(cam.ID)
-	}
-	return downloaded > 0
+	writeJSON(w, http.StatusOK, jobs)
 }
 
-// cleanTmpFiles removes stale .tmp files from a directory.
-func cleanTmpFiles(dir string) {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
+// Cancel stops a running or queued job. Running ffmpeg, NVR downloads and
+// sidecar requests are aborted; what it finished stays indexed. The job ends with status
+// "cancelled" and a final "cancelled" event.
+func (h *ProcessHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "job_id")
+	if err := h.jobs.Cancel(jobID); err != nil {
+		writeServiceError(w, err)
 		return
 	}
-	for _, e := range entries {
-		if !e.IsDir() && strings.HasSuffix(e.Name(), ".tmp") {
-			os.Remove(filepath.Join(dir, e.Name()))
-		}
-	}
+	writeJSON(w, http.StatusAccepted, models.ProcessResponse{JobID: jobID, Status: "cancelling"})
 }
 
 func (h *ProcessHandler) Status(w http.ResponseWriter, r *http.Request) {
 	jobID := r.URL.Query().Get("job_id")
 	if jobID == "" {
-		http.Error(w, `{"error":"job_id query parameter required"}`, http.StatusBadRequest)
-		return
-	}
-
-	h.mu.Lock()
-	job, ok := h.activeJobs[jobID]
-	h.mu.Unlock()
-
-	if !ok {
-		http.Error(w, `{"error":"job not found"}`, http.StatusNotFound)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "job_id query parameter required")
 		return
 	}
 
-	// SSE stream
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+	mu, events, done, err := h.jobs.EventLog(jobID)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
-
-	// Send any events already collected
-	h.mu.Lock()
-	sent := len(job.Events)
-	for _, ev := range job.Events {
-		data, _ := json.Marshal(ev)
-		fmt.Fprintf(w, "data: %s\n\n", data)
-	}
-	h.mu.Unlock()
-	flusher.Flush()
-
-	// Stream new events until done
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-r.Context().Done():
-			return
-		case <-job.doneCh:
-			// Send remaining events
-			h.mu.Lock()
-			for i := sent; i < len(job.Events); i++ {
-				data, _ := json.Marshal(job.Events[i])
-				fmt.Fprintf(w, "data: %s\n\n", data)
-			}
-			h.mu.Unlock()
-			flusher.Flush()
-			return
-		case <-ticker.C:
-			h.mu.Lock()
-			for i := sent; i < len(job.Events); i++ {
-				data, _ := json.Marshal(job.Events[i])
-				fmt.Fprintf(w, "data: %s\n\n", data)
-			}
-			sent = len(job.Events)
-			h.mu.Unlock()
-			flusher.Flush()
-		}
-	}
+	serveEventStream(w, r, mu, events, done)
 }
 
+// History returns the process history, optionally filtered by ?camera_id=
+// (comma-separated), ?start_date= and ?end_date= (inclusive, YYYY-MM-DD),
+// sorted by ?sort= (date, camera_id, indexed_at) and paginated with
+// ?limit=/?offset=.
 func (h *ProcessHandler) History(w http.ResponseWriter, r *http.Request) {
-	history := loadProcessHistory(h.cfg.Process.HistoryPath)
-	writeJSON(w, http.StatusOK, history)
-}
-
-// Process history helpers
-
-func loadProcessHistory(path string) []models.ProcessHistoryEntry {
-	data, err := os.ReadFile(path)
+	page, err := parsePageParams(r, "date", "camera_id", "indexed_at")
 	if err != nil {
-		return nil
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
 	}
-	var history []models.ProcessHistoryEntry
-	json.Unmarshal(data, &history)
-	return history
-}
 
-// ListVideoFiles returns basenames of .mp4 files in a directory.
-func ListVideoFiles(dir string) []string {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return nil
+	q := r.URL.Query()
+	var cameraIDs []string
+	if v := q.Get("camera_id"); v != "" {
+		cameraIDs = strings.Split(v, ",")
 	}
-	var files []string
-	for _, e := range entries {
-		if e.IsDir() || !strings.HasSuffix(strings.ToLower(e.Name()), ".mp4") {
+	startDate := q.Get("start_date")
+	endDate := q.Get("end_date")
+	for _, d := range []string{startDate, endDate} {
+		if d == "" {
 			continue
 		}
-		files = append(files, e.Name())
-	}
-	return files
-}
-
-// newVideosForDate returns video basenames in videosDir that haven't been
-// processed yet according to the history. If the history entry for this
-// camera+date has no Videos list (legacy format), it is treated as fully
-// processed for backward compatibility.
-func newVideosForDate(history []models.ProcessHistoryEntry, cameraID, date, videosDir string) []string {
-	allVideos := ListVideoFiles(videosDir)
-	if len(allVideos) == 0 {
-		return nil
-	}
-
-	// Find history entry
-	var processed []string
-	for _, h := range history {
-		if h.CameraID == cameraID && h.Date == date {
-			if len(h.Videos) == 0 {
-				// Legacy entry without video list — treat as fully processed
-				return nil
-			}
-			processed = h.Videos
-			break
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid date %q (expected YYYY-MM-DD)", d)
+			return
 		}
 	}
 
-	processedSet := make(map[string]bool, len(processed))
-	for _, v := range processed {
-		processedSet[v] = true
-	}
-
-	var newVids []string
-	for _, v := range allVideos {
-		if !processedSet[v] {
-			newVids = append(newVids, v)
+	history := services.LoadProcessHistory(h.cfg.Process.HistoryPath)
+	filtered := make([]models.ProcessHistoryEntry, 0, len(history))
+	for _, e := range history {
+		if len(cameraIDs) > 0 && !slices.Contains(cameraIDs, e.CameraID) {
+			continue
 		}
-	}
-	return newVids
-}
-
-func AddProcessHistory(path, cameraID, date string, videos []string) {
-	history := loadProcessHistory(path)
-
-	// Update existing entry or add new one
-	found := false
-	for i := range history {
-		if history[i].CameraID == cameraID && history[i].Date == date {
-			history[i].Videos = videos
-			history[i].IndexedAt = time.Now()
-			found = true
-			break
+		// YYYY-MM-DD strings compare correctly lexicographically
+		if startDate != "" && e.Date < startDate {
+			continue
 		}
+		if endDate != "" && e.Date > endDate {
+			continue
+		}
+		filtered = append(filtered, e)
 	}
 
-	if !found {
-		history = append(history, models.ProcessHistoryEntry{
-			CameraID:  cameraID,
-			Date:      date,
-			Videos:    videos,
-			IndexedAt: time.Now(),
+	if page.Sort != "" || page.Desc {
+		less := func(a, b models.ProcessHistoryEntry) bool {
+			if page.Sort == "indexed_at" && !a.IndexedAt.Equal(b.IndexedAt) {
+				return a.IndexedAt.Before(b.IndexedAt)
+			}
+			if page.Sort == "camera_id" && a.CameraID != b.CameraID {
+				return a.CameraID < b.CameraID
+			}
+			if a.Date != b.Date {
+				return a.Date < b.Date
+			}
+			return a.CameraID < b.CameraID
+		}
+		sort.Slice(filtered, func(i, j int) bool {
+			if page.Desc {
+				return less(filtered[j], filtered[i])
+			}
+			return less(filtered[i], filtered[j])
 		})
 	}
 
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		log.Printf("creating history directory: %v", err)
-		return
-	}
-
-	data, err := json.MarshalIndent(history, "", "  ")
-	if err != nil {
-		log.Printf("marshaling process history: %v", err)
-		return
-	}
-	if err := os.WriteFile(path, data, 0o644); err != nil {
-		log.Printf("writing process history: %v", err)
-	}
-}
-
-func dateRange(start, end string) ([]string, error) {
-	startDate, err := time.Parse("2006-01-02", start)
-	if err != nil {
-		return nil, fmt.Errorf("invalid start date: %w", err)
-	}
-	endDate, err := time.Parse("2006-01-02", end)
-	if err != nil {
-		return nil, fmt.Errorf("invalid end date: %w", err)
-	}
-
-	var dates []string
-	for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
-		dates = append(dates, d.Format("2006-01-02"))
-	}
-	return dates, nil
+	writeJSON(w, http.StatusOK, paginate(w, filtered, page))
 }
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter enforces a token-bucket limit per client: per remote IP
+// address with Middleware, per authenticated token with KeyMiddleware.
+type RateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+	off   bool
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// NewRateLimiter creates a limiter allowing perMinute requests per client on
+// average, with bursts of up to burst requests. A negative perMinute turns
+// the limit off.
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	rl := &RateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		off:     perMinute < 0,
+		buckets: make(map[string]*bucket),
+	}
+	if !rl.off {
+		go rl.cleanupLoop()
+	}
+	return rl
+}
+
+// Middleware rejects requests from clients that exceeded their budget with
+// 429 Too Many Requests and a Retry-After header.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rl.limited(w, clientKey(r)) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// KeyMiddleware is Middleware with a budget per token rather than per
+// address, for the routes behind AdminAuth or WorkerAuth, which it must
+// follow. Requests it finds unauthenticated pass.
+func (rl *RateLimiter) KeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := authenticated(r); key != "" && rl.limited(w, "key:"+key) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limited consumes a request of key's budget, answering 429 and returning
+// true when it is used up.
+func (rl *RateLimiter) limited(w http.ResponseWriter, key string) bool {
+	if rl.off {
+		return false
+	}
+	ok, retryAfter := rl.allow(key)
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, CodeRateLimited,
+		"rate limit exceeded, retry in %s", retryAfter.Round(time.Second))
+	return true
+}
+
+// allow consumes one token for key. When the bucket is empty it returns false
+// together with the time until the next token becomes available.
+func (rl *RateLimiter) allow(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.burst, lastSeen: now}
+		rl.buckets[key] = b
+	}
+
+	// Refill based on elapsed time
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*rl.rate)
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if rl.rate <= 0 {
+		return false, time.Minute
+	}
+	wait := time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	return false, wait
+}
+
+// cleanupLoop drops buckets for clients that have been idle long enough to
+// be back at full capacity, so the map doesn't grow without bound.
+func (rl *RateLimiter) cleanupLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		rl.mu.Lock()
+		for key, b := range rl.buckets {
+			if time.Since(b.lastSeen) > 10*time.Minute {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// clientKey identifies the client of r by its address. Headers such as
+// X-API-Key are not authenticated, so a client could pick a fresh bucket per
+// request with them; tokens count only once checked, see KeyMiddleware.
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
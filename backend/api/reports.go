@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/intelsk/backend/services"
+)
+
+type ReportsHandler struct {
+	reports *services.ReportService
+}
+
+func NewReportsHandler(reports *services.ReportService) *ReportsHandler {
+	return &ReportsHandler{reports: reports}
+}
+
+// Daily returns the activity summary of ?date= (YYYY-MM-DD, yesterday by
+// default) for ?camera=, or for every camera without one.
+func (h *ReportsHandler) Daily(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	report, err := h.reports.Daily(q.Get("camera"), q.Get("date"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
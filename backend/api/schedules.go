@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+// SchedulesHandler manages the cron schedules that process the previous
+// day's recordings automatically.
+type SchedulesHandler struct {
+	scheduler *services.Scheduler
+	jobs      *services.JobManager
+}
+
+func NewSchedulesHandler(scheduler *services.Scheduler, jobs *services.JobManager) *SchedulesHandler {
+	return &SchedulesHandler{scheduler: scheduler, jobs: jobs}
+}
+
+func (h *SchedulesHandler) List(w http.ResponseWriter, r *http.Request) {
+	schedules, err := h.scheduler.List()
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, schedules)
+}
+
+func (h *SchedulesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	sch, err := h.scheduler.Get(chi.URLParam(r, "schedule_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sch)
+}
+
+func (h *SchedulesHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+
+	sch, err := h.scheduler.Create(req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sch)
+}
+
+func (h *SchedulesHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+
+	sch, err := h.scheduler.Update(chi.URLParam(r, "schedule_id"), req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sch)
+}
+
+func (h *SchedulesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.scheduler.Delete(chi.URLParam(r, "schedule_id")); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// Run runs a schedule now and returns the processing job it started, or
+// {"status": "no_cameras"} when there was nothing to process.
+func (h *SchedulesHandler) Run(w http.ResponseWriter, r *http.Request) {
+	job, err := h.scheduler.RunNow(chi.URLParam(r, "schedule_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if job == nil {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "no_cameras"})
+		return
+	}
+	info, err := h.jobs.Get(job.ID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, info)
+}
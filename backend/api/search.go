@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
@@ -15,29 +15,39 @@ import (
 	"github.com/intelsk/backend/services"
 )
 
+// filteredFetchFactor is how many more candidates a search with a filter
+// fetches, as the filter may drop most of them.
+const filteredFetchFactor = 10
+
 type SearchHandler struct {
-	cfg      *config.AppConfig
-	mlClient *services.MLClient
-	settings *services.SettingsService
+	cfg        *config.AppConfig
+	encoder    services.Encoder
+	settings   *services.SettingsService
+	bookmarks  *services.BookmarkService
+	filters    *services.SearchFilterService
+	enrichment *services.EnrichmentService
 }
 
-func NewSearchHandler(cfg *config.AppConfig, mlClient *services.MLClient, settings *services.SettingsService) *SearchHandler {
+func NewSearchHandler(cfg *config.AppConfig, encoder services.Encoder, settings *services.SettingsService, bookmarks *services.BookmarkService, filters *services.SearchFilterService, enrichment *services.EnrichmentService) *SearchHandler {
 	return &SearchHandler{
-		cfg:      cfg,
-		mlClient: mlClient,
-		settings: settings,
+		cfg:        cfg,
+		encoder:    encoder,
+		settings:   settings,
+		bookmarks:  bookmarks,
+		filters:    filters,
+		enrichment: enrichment,
 	}
 }
 
 func (h *SearchHandler) TextSearch(w http.ResponseWriter, r *http.Request) {
 	var req models.TextSearchRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
 		return
 	}
 
 	if req.Query == "" {
-		http.Error(w, `{"error":"query is required"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "query is required")
 		return
 	}
 
@@ -47,29 +57,69 @@ func (h *SearchHandler) TextSearch(w http.ResponseWriter, r *http.Request) {
 
 	minScore := h.settings.GetFloat64("search.min_score")
 
+	dedupWindow := h.settings.GetInt("search.dedup_window_sec")
+	if req.DedupWindowSec != nil {
+		if *req.DedupWindowSec < 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "dedup_window_sec must not be negative")
+			return
+		}
+		dedupWindow = *req.DedupWindowSec
+	}
+
+	// Times without a zone are on the cameras' wall clock
+	loc := h.settings.Location()
+	startTime, endTime, err := services.TimeRange(req.StartTime, req.EndTime, loc)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	var filter *services.SearchFilter
+	if req.Filter != nil {
+		if filter, err = h.filters.Parse(req.Filter); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+	}
+
 	// Request more results than needed to compensate for dedup filtering
 	fetchLimit := req.Limit * 4
 	if fetchLimit < 100 {
 		fetchLimit = 100
 	}
+	if filter != nil {
+		fetchLimit *= filteredFetchFactor
+	}
 
-	results, err := h.mlClient.SearchByText(
+	results, err := h.encoder.SearchByText(
 		h.cfg.Storage.DBPath,
 		req.Query,
 		req.CameraIDs,
-		req.StartTime,
-		req.EndTime,
+		startTime,
+		endTime,
 		fetchLimit,
 		minScore,
 	)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"search failed: %s"}`, err), http.StatusInternalServerError)
+		writeServiceError(w, fmt.Errorf("search failed: %w", err))
 		return
 	}
 
+	if filter != nil {
+		results, err = h.filters.Apply(filter, results, services.FaceSearchOptions{
+			CameraIDs: req.CameraIDs,
+			StartTime: startTime,
+			EndTime:   endTime,
+		})
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+	}
+
 	// Deduplicate: keep only the best-scoring frame per camera
-	// per time window (60s). Results are already sorted by score descending.
-	results = deduplicateResults(results, 60)
+	// per time window. Results are already sorted by score descending.
+	results = deduplicateResults(results, dedupWindow)
 
 	if len(results) > req.Limit {
 		results = results[:req.Limit]
@@ -81,8 +131,30 @@ func (h *SearchHandler) TextSearch(w http.ResponseWriter, r *http.Request) {
 	})
 
 	apiResults := make([]models.APISearchResult, len(results))
+	frameIDs := make([]string, len(results))
+	timestamps := make([]string, len(results))
 	for i, r := range results {
-		apiResults[i] = mapSearchResult(r, h.cfg.App.DataDir)
+		apiResults[i] = mapSearchResult(r, h.cfg.APIBase(), loc)
+		frameIDs[i] = r.ID
+		timestamps[i] = r.Timestamp
+	}
+
+	// The light and weather at the site when each frame was taken
+	for i, c := range h.enrichment.Frames(timestamps) {
+		apiResults[i].Context = c
+	}
+
+	// Flag the frames already bookmarked
+	bookmarked, err := h.bookmarks.ByFrame(frameIDs)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	for i := range apiResults {
+		if id, ok := bookmarked[apiResults[i].FrameID]; ok {
+			apiResults[i].Bookmarked = true
+			apiResults[i].BookmarkID = id
+		}
 	}
 
 	writeJSON(w, http.StatusOK, models.SearchResponse{
@@ -93,29 +165,37 @@ func (h *SearchHandler) TextSearch(w http.ResponseWriter, r *http.Request) {
 }
 
 // mapSearchResult converts an ML sidecar SearchResult into an API-facing
-// APISearchResult, populating video URL and seek offset.
-func mapSearchResult(r models.SearchResult, dataDir string) models.APISearchResult {
+// APISearchResult, populating video URL and seek offset. URLs are rooted at
+// apiBase so results from a workspace link back into that workspace; the
+// timestamp is given in loc.
+func mapSearchResult(r models.SearchResult, apiBase string, loc *time.Location) models.APISearchResult {
 	result := models.APISearchResult{
 		FrameID:   r.ID,
-		FrameURL:  buildFrameURL(r.FramePath),
+		FrameURL:  buildFrameURL(apiBase, r.FramePath),
 		CameraID:  r.CameraID,
-		Timestamp: r.Timestamp,
+		Timestamp: services.LocalTimestamp(r.Timestamp, loc),
 		Score:     r.Score,
 	}
 
 	// Build source_video_url and seek_offset_sec
 	if r.SourceVideo != "" {
-		result.SourceVideoURL = buildVideoURL(r.SourceVideo)
-		result.SeekOffsetSec = computeSeekOffset(r.Timestamp, r.SourceVideo)
+		result.SourceVideoURL = buildVideoURL(apiBase, r.SourceVideo)
+		result.SeekOffsetSec = services.SeekOffset(r.Timestamp, r.SourceVideo)
+		result.ClipURL = resultClipURL(apiBase, r.ID)
 	}
 
 	return result
 }
 
+// resultClipURL is the link of VideoHandler.ResultClip for an indexed frame.
+func resultClipURL(apiBase, frameID string) string {
+	return apiBase + "/search/results/" + url.PathEscape(frameID) + "/clip"
+}
+
 // buildFrameURL constructs the API URL for a frame image.
 // frame_path like "frames/front_door/2026-02-18/frame_000042.jpg"
 // or absolute path — we extract the relative part after "frames/"
-func buildFrameURL(framePath string) string {
+func buildFrameURL(apiBase, framePath string) string {
 	// Normalize to forward slashes for URL
 	fp := filepath.ToSlash(framePath)
 
@@ -126,13 +206,18 @@ func buildFrameURL(framePath string) string {
 		fp = filepath.Base(fp)
 	}
 
-	return "/api/frames/" + fp
+	return apiBase + "/frames/" + fp
 }
 
 // buildVideoURL encodes a source_video path as a video ID URL.
 // "videos/front_door/2026-02-18/1400.mp4" → "/api/videos/front_door--2026-02-18--1400/play"
+func buildVideoURL(apiBase, sourceVideo string) string {
+	return videoPlayURL(apiBase, sourceVideoID(sourceVideo))
+}
+
+// sourceVideoID encodes a source_video path as a video ID.
 // Also handles absolute paths by extracting the part after "videos/"
-func buildVideoURL(sourceVideo string) string {
+func sourceVideoID(sourceVideo string) string {
 	sv := filepath.ToSlash(sourceVideo)
 
 	// Extract the part after "videos/" (handles both relative and absolute paths)
@@ -144,52 +229,12 @@ func buildVideoURL(sourceVideo string) string {
 	sv = strings.TrimSuffix(sv, ".mp4")
 
 	// Replace / with --
-	videoID := strings.ReplaceAll(sv, "/", "--")
-
-	return "/api/videos/" + videoID + "/play"
+	return strings.ReplaceAll(sv, "/", "--")
 }
 
-// computeSeekOffset calculates seconds into the video segment.
-// Frame timestamp like "2026-02-18T14:23:05" from video "1400.mp4" (starts at 14:00)
-// → 23*60 + 5 = 1385 seconds.
-// For non-hour-based filenames, assumes the video starts at midnight and
-// computes offset from there.
-func computeSeekOffset(timestamp, sourceVideo string) int {
-	// Parse frame timestamp
-	var frameTime time.Time
-	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05"} {
-		t, err := time.Parse(layout, timestamp)
-		if err == nil {
-			frameTime = t
-			break
-		}
-	}
-	if frameTime.IsZero() {
-		return 0
-	}
-
-	// Try to extract hour from video filename (e.g., "1400.mp4" → hour 14).
-	// Default to 0 (midnight) for non-hour filenames.
-	hour := 0
-	sv := filepath.ToSlash(sourceVideo)
-	base := filepath.Base(sv)
-	stem := strings.TrimSuffix(base, filepath.Ext(base))
-
-	if len(stem) >= 2 {
-		if h, err := strconv.Atoi(stem[:2]); err == nil && h >= 0 && h <= 23 {
-			hour = h
-		}
-	}
-
-	// Compute offset: frame time - segment start hour
-	segmentStart := time.Date(frameTime.Year(), frameTime.Month(), frameTime.Day(),
-		hour, 0, 0, 0, frameTime.Location())
-
-	offset := int(frameTime.Sub(segmentStart).Seconds())
-	if offset < 0 {
-		return 0
-	}
-	return offset
+// videoPlayURL is the playback URL of a video ID.
+func videoPlayURL(apiBase, videoID string) string {
+	return apiBase + "/videos/" + videoID + "/play"
 }
 
 // deduplicateResults removes near-duplicate search results. For each camera,
@@ -1,12 +1,14 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/intelsk/backend/config"
 	"github.com/intelsk/backend/models"
@@ -16,30 +18,39 @@ import (
 type SettingsHandler struct {
 	settings *services.SettingsService
 	cfg      *config.AppConfig
-	mlClient *services.MLClient
+	encoder  services.Encoder
 	storage  *services.Storage
+	jobs     *services.JobManager
 }
 
-func NewSettingsHandler(settings *services.SettingsService, cfg *config.AppConfig, mlClient *services.MLClient, storage *services.Storage) *SettingsHandler {
-	return &SettingsHandler{
+func NewSettingsHandler(settings *services.SettingsService, cfg *config.AppConfig, encoder services.Encoder, storage *services.Storage, jobs *services.JobManager) *SettingsHandler {
+	h := &SettingsHandler{
 		settings: settings,
 		cfg:      cfg,
-		mlClient: mlClient,
+		encoder:  encoder,
 		storage:  storage,
+		jobs:     jobs,
+	}
+	jobs.Register(services.JobTypeReembed, h.runReembed)
+	return h
+}
+
+func (h *SettingsHandler) response() models.SettingsResponse {
+	return models.SettingsResponse{
+		Settings:        h.settings.All(),
+		Defaults:        h.settings.Defaults(),
+		RestartRequired: h.settings.RestartRequired(),
 	}
 }
 
 func (h *SettingsHandler) Get(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, models.SettingsResponse{
-		Settings: h.settings.All(),
-		Defaults: h.settings.Defaults(),
-	})
+	writeJSON(w, http.StatusOK, h.response())
 }
 
 func (h *SettingsHandler) Update(w http.ResponseWriter, r *http.Request) {
 	var req models.SettingsUpdateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
 		return
 	}
 
@@ -52,75 +63,217 @@ func (h *SettingsHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	if len(errors) > 0 {
 		writeJSON(w, http.StatusBadRequest, map[string]any{
-			"errors":   errors,
-			"settings": h.settings.All(),
-			"defaults": h.settings.Defaults(),
+			"code":             CodeInvalidRequest,
+			"error":            "invalid settings",
+			"errors":           errors,
+			"settings":         h.settings.All(),
+			"defaults":         h.settings.Defaults(),
+			"restart_required": h.settings.RestartRequired(),
 		})
 		return
 	}
 
-	writeJSON(w, http.StatusOK, models.SettingsResponse{
-		Settings: h.settings.All(),
-		Defaults: h.settings.Defaults(),
+	writeJSON(w, http.StatusOK, h.response())
+}
+
+// Schema lists every setting with its type, range, default and description.
+func (h *SettingsHandler) Schema(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.settings.Schema())
+}
+
+// Events streams a SettingsChangeEvent (SSE) whenever settings change, so
+// open clients can refresh. Ids count changes since the server started.
+func (h *SettingsHandler) Events(w http.ResponseWriter, r *http.Request) {
+	mu, events := h.settings.Events()
+	if lastEventID(r) == 0 {
+		// A new client has just loaded the settings; only later changes matter
+		mu.Lock()
+		r.Header.Set("Last-Event-ID", strconv.Itoa(len(*events)))
+		mu.Unlock()
+	}
+	serveEventStream(w, r, mu, events, nil)
+}
+
+// settingsPassphraseHeader carries the passphrase that encrypts secrets in
+// a settings export, kept out of the URL so it doesn't end up in logs.
+const settingsPassphraseHeader = "X-Settings-Passphrase"
+
+// Export downloads all settings as JSON for Import on another installation.
+// Secrets are masked unless the request sends a passphrase to encrypt them.
+func (h *SettingsHandler) Export(w http.ResponseWriter, r *http.Request) {
+	exp, err := h.settings.Export(r.Header.Get(settingsPassphraseHeader))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="intelsk-settings.json"`)
+	writeJSON(w, http.StatusOK, exp)
+}
+
+// Import applies a settings export. Nothing is stored unless every value is
+// valid; encrypted secrets need the export's passphrase in the header.
+func (h *SettingsHandler) Import(w http.ResponseWriter, r *http.Request) {
+	var exp models.SettingsExport
+	if err := json.NewDecoder(r.Body).Decode(&exp); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	skipped, err := h.settings.Import(&exp, r.Header.Get(settingsPassphraseHeader))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"settings":         h.settings.All(),
+		"defaults":         h.settings.Defaults(),
+		"restart_required": h.settings.RestartRequired(),
+		"skipped":          skipped,
 	})
 }
 
 func (h *SettingsHandler) GetClipModel(w http.ResponseWriter, r *http.Request) {
-	info, err := h.mlClient.GetModelInfo()
+	info, err := h.encoder.GetModelInfo()
 	if err != nil {
-		writeJSON(w, http.StatusBadGateway, map[string]string{
-			"error": fmt.Sprintf("failed to get model info: %v", err),
-		})
+		writeServiceError(w, fmt.Errorf("failed to get model info: %w", err))
 		return
 	}
 	writeJSON(w, http.StatusOK, info)
 }
 
+// SwitchClipModel switches the CLIP model to a preset. An empty index
+// switches at once; otherwise a reembed job encodes every indexed frame
+// again with the new model, while search keeps using the old one, and the
+// response is 202 with the job's ID.
 func (h *SettingsHandler) SwitchClipModel(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Preset string `json:"preset"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Preset == "" {
-		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
 		return
 	}
-
-	// Reload model in ML sidecar (downloads weights if needed)
-	info, err := h.mlClient.ReloadModel(req.Preset)
-	if err != nil {
-		writeJSON(w, http.StatusBadGateway, map[string]string{
-			"error": fmt.Sprintf("failed to reload model: %v", err),
-		})
+	if h.jobs.ActiveCount(services.JobTypeReembed) > 0 {
+		writeError(w, http.StatusConflict, CodeBusy, "a model switch is already running")
 		return
 	}
 
-	// Clear all clip embeddings
-	if _, err := h.storage.DB().Exec("DELETE FROM clip_embeddings"); err != nil {
-		log.Printf("warning: failed to clear clip_embeddings: %v", err)
+	var indexed int
+	if err := h.storage.DB().QueryRow("SELECT COUNT(*) FROM clip_embeddings").Scan(&indexed); err != nil {
+		writeServiceError(w, err)
+		return
 	}
-
-	// Delete all index_state.json files under storage path
-	filepath.Walk(h.cfg.Extraction.StoragePath, func(path string, fi os.FileInfo, err error) error {
+	if indexed == 0 || req.Preset == h.settings.Get("clip.model") {
+		// Reload model in ML sidecar (downloads weights if needed)
+		info, err := h.encoder.ReloadModel(req.Preset)
 		if err != nil {
-			return nil
+			writeServiceError(w, fmt.Errorf("failed to reload model: %w", err))
+			return
 		}
-		if fi.Name() == "index_state.json" {
-			os.Remove(path)
+		// Update clip.model setting; the sidecar already runs it
+		if err := h.settings.SetApplied("clip.model", req.Preset); err != nil {
+			log.Printf("warning: failed to update clip.model setting: %v", err)
 		}
-		return nil
-	})
+		writeJSON(w, http.StatusOK, info)
+		return
+	}
 
-	// Clear process history
-	if h.cfg.Process.HistoryPath != "" {
-		os.Remove(h.cfg.Process.HistoryPath)
+	// A migration left unfinished may be for another preset
+	if err := services.DiscardReembed(h.storage); err != nil {
+		writeServiceError(w, err)
+		return
 	}
+	job, err := h.jobs.Submit(services.JobTypeReembed, services.ReembedParams{Preset: req.Preset})
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, services.ModelInfo{Preset: req.Preset, Status: "migrating", JobID: job.ID})
+}
 
-	// Update clip.model setting
-	if err := h.settings.Set("clip.model", req.Preset); err != nil {
-		log.Printf("warning: failed to update clip.model setting: %v", err)
+// runReembed is the JobRunner of reembed jobs: it migrates the index to
+// the preset, then applies it to clip.model. A cancelled migration is
+// discarded; a failed one is kept for a retry to resume.
+func (h *SettingsHandler) runReembed(ctx context.Context, job *services.Job, emit func(services.ProgressEvent)) error {
+	var params services.ReembedParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return fmt.Errorf("invalid job params: %w", err)
 	}
+	if err := job.WaitForSlot(ctx); err != nil {
+		return err
+	}
+	frames, err := services.Reembed(ctx, h.storage, h.encoder, h.cfg.Extraction.StoragePath, params.Preset,
+		h.settings.GetInt("clip.batch_size"), emit)
+	if err == nil {
+		if err := h.settings.SetApplied("clip.model", params.Preset); err != nil {
+			log.Printf("warning: failed to update clip.model setting: %v", err)
+		}
+		emit(services.ProgressEvent{Stage: "complete", FramesDone: frames, FramesTotal: frames, Percent: 100,
+			Message: fmt.Sprintf("switched to %s, re-embedded %d frames", params.Preset, frames)})
+		return nil
+	}
+	if ctx.Err() != nil {
+		if err := services.DiscardReembed(h.storage); err != nil {
+			log.Printf("warning: discarding the migration to %s: %v", params.Preset, err)
+		}
+		// Drop the model staged for it
+		if _, err := h.encoder.ReloadModel(h.settings.Get("clip.model")); err != nil {
+			log.Printf("warning: reloading %s: %v", h.settings.Get("clip.model"), err)
+		}
+	}
+	return err
+}
 
-	writeJSON(w, http.StatusOK, info)
+// modelDownloadPoll is how often ModelDownload asks the sidecar for
+// progress.
+const modelDownloadPoll = time.Second
+
+// ModelDownload streams the ML sidecar's progress loading a preset (SSE):
+// a ModelDownload event whenever it changes, so the Settings page can show
+// a model switch downloading weights rather than a request that seems to
+// hang. The stream ends once a load of the preset it saw going is ready or
+// has failed. The ONNX encoder loads local files and reports nothing.
+func (h *SettingsHandler) ModelDownload(w http.ResponseWriter, r *http.Request) {
+	preset := r.URL.Query().Get("preset")
+	if preset == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "preset is required")
+		return
+	}
+	mlClient, ok := h.encoder.(*services.MLClient)
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeUnsupported, "model downloads are only reported by the ML sidecar")
+		return
+	}
+
+	var mu sync.Mutex
+	var events []services.ModelDownload
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(modelDownloadPoll)
+		defer ticker.Stop()
+		var last services.ModelDownload
+		seen := false
+		for {
+			// Errors reaching the sidecar are skipped; a load keeps it busy
+			if d, err := mlClient.ModelDownload(); err == nil && d.Preset == preset && *d != last {
+				last = *d
+				mu.Lock()
+				events = append(events, *d)
+				mu.Unlock()
+				if d.Loading() {
+					seen = true
+				} else if seen {
+					return
+				}
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	serveEventStream(w, r, &mu, &events, done)
 }
 
 // NVRStatus tests connectivity and authentication to the NVR using the current settings.
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ssePollInterval      = 500 * time.Millisecond
+	sseHeartbeatInterval = 15 * time.Second
+	// sseRetryMs tells EventSource how long to wait before reconnecting.
+	sseRetryMs = 3000
+)
+
+// serveEventStream streams a job's event log as Server-Sent Events until done
+// is closed or the client goes away. Each event's id is its 1-based position
+// in the log, so a reconnecting client sending Last-Event-ID (EventSource
+// does this automatically; ?last_event_id= works too) resumes right after
+// the last event it saw instead of replaying or missing any. A comment line
+// is sent every sseHeartbeatInterval so proxies don't drop idle connections.
+// events is read under mu, the lock the job's producer appends under.
+func serveEventStream[T any](w http.ResponseWriter, r *http.Request, mu *sync.Mutex, events *[]T, done <-chan struct{}) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "streaming not supported")
+		return
+	}
+
+	sent := lastEventID(r)
+	mu.Lock()
+	if sent > len(*events) {
+		sent = len(*events)
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: don't buffer the stream
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetryMs)
+
+	flush := func() {
+		mu.Lock()
+		for ; sent < len(*events); sent++ {
+			data, _ := json.Marshal((*events)[sent])
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", sent+1, data)
+		}
+		mu.Unlock()
+		flusher.Flush()
+	}
+	flush()
+
+	ticker := time.NewTicker(ssePollInterval)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-done:
+			flush()
+			return
+		case <-ticker.C:
+			flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// lastEventID returns the id of the last event the client received, from the
+// Last-Event-ID header or the last_event_id query parameter (0 if absent).
+func lastEventID(r *http.Request) int {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
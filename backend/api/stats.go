@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+type StatsHandler struct {
+	cfg       *config.AppConfig
+	storage   *services.Storage
+	cameraSvc *services.CameraService
+	jobs      *services.JobManager
+	streamer  *services.Streamer
+	encoder   services.Encoder
+}
+
+func NewStatsHandler(cfg *config.AppConfig, storage *services.Storage, cameraSvc *services.CameraService, jobs *services.JobManager, streamer *services.Streamer, encoder services.Encoder) *StatsHandler {
+	return &StatsHandler{
+		cfg:       cfg,
+		storage:   storage,
+		cameraSvc: cameraSvc,
+		jobs:      jobs,
+		streamer:  streamer,
+		encoder:   encoder,
+	}
+}
+
+// Stats returns the workspace's totals in one call: the figures of
+// `backend stats` (videos and bytes stored, frames extracted and indexed,
+// embeddings, database size, per camera and in total) with the cameras by
+// type and status, the active jobs and streams, and the sidecar's model.
+// An unreachable sidecar is reported in the response, not as an error.
+func (h *StatsHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	sys, err := services.CollectStats(h.cfg, h.storage.DB())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	cameras, err := h.cameraSvc.List()
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	stats := models.ServerStats{
+		SystemStats:     *sys,
+		CamerasByType:   make(map[string]int),
+		CamerasByStatus: make(map[string]int),
+		ActiveJobs:      h.jobs.ActiveCounts(),
+		ActiveStreams:   h.streamer.ActiveCount(),
+		Sidecar:         models.SidecarStats{Status: "ok"},
+	}
+	for _, cam := range cameras {
+		stats.CamerasByType[cam.Type]++
+		stats.CamerasByStatus[cam.Status]++
+	}
+	if info, err := h.encoder.GetModelInfo(); err != nil {
+		stats.Sidecar = models.SidecarStats{Status: "error", Error: err.Error()}
+	} else {
+		stats.Sidecar.Preset = info.Preset
+		stats.Sidecar.Model = info.Model
+		stats.Sidecar.EmbeddingDim = info.EmbeddingDim
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
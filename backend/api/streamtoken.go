@@ -0,0 +1,92 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// segmentTokenTTL is how long the URLs written into a served playlist stay
+// valid. Players fetch them right after the playlist, and every reload of
+// the playlist mints fresh ones.
+const segmentTokenTTL = time.Minute
+
+// streamSigner issues and checks the ?exp=&sig= tokens on live stream URLs.
+// A token covers one file of one camera's stream until exp (Unix seconds),
+// so a shared URL stops working once it expires.
+type streamSigner struct {
+	key []byte
+	ttl time.Duration // validity of playlist URLs handed out by StreamStart
+}
+
+// newStreamSigner uses key, or a random one if it's empty; URLs signed with
+// a random key stop working when the server restarts.
+func newStreamSigner(key string, ttl time.Duration) *streamSigner {
+	if key == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			log.Fatalf("generating stream signing key: %v", err)
+		}
+		return &streamSigner{key: b, ttl: ttl}
+	}
+	return &streamSigner{key: []byte(key), ttl: ttl}
+}
+
+func (s *streamSigner) sig(cameraID, file string, exp int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(cameraID + "/" + file + "\n" + strconv.FormatInt(exp, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// query returns the signed query string ("exp=...&sig=...") for a file.
+func (s *streamSigner) query(cameraID, file string, ttl time.Duration) string {
+	exp := time.Now().Add(ttl).Unix()
+	return url.Values{
+		"exp": {strconv.FormatInt(exp, 10)},
+		"sig": {s.sig(cameraID, file, exp)},
+	}.Encode()
+}
+
+// valid reports whether q carries an unexpired signature for the file.
+func (s *streamSigner) valid(cameraID, file string, q url.Values) bool {
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	want := s.sig(cameraID, file, exp)
+	return subtle.ConstantTimeCompare([]byte(q.Get("sig")), []byte(want)) == 1
+}
+
+var playlistURIAttr = regexp.MustCompile(`URI="([^"]+)"`)
+
+// signPlaylist appends a short-lived token to every URI in an HLS playlist:
+// segment lines and the URI attributes of tags such as EXT-X-MAP and
+// EXT-X-PART.
+func (s *streamSigner) signPlaylist(cameraID string, playlist []byte) []byte {
+	sign := func(uri string) string {
+		if strings.Contains(uri, "://") || strings.Contains(uri, "?") {
+			return uri
+		}
+		return uri + "?" + s.query(cameraID, uri, segmentTokenTTL)
+	}
+	lines := strings.Split(string(playlist), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#"):
+			lines[i] = playlistURIAttr.ReplaceAllStringFunc(line, func(attr string) string {
+				return `URI="` + sign(playlistURIAttr.FindStringSubmatch(attr)[1]) + `"`
+			})
+		case strings.TrimSpace(line) != "":
+			lines[i] = sign(strings.TrimSpace(line))
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
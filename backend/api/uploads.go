@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/models"
+)
+
+// maxChunkSize caps a single PATCH body. Clients pick their own chunk size
+// below this; 8 MB is what the web UI uses.
+const maxChunkSize = 64 << 20
+
+// CreateUpload starts a resumable upload: POST {filename, size} returns an
+// upload_id to send chunks to.
+func (h *CamerasHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	sess, err := h.svc.CreateUploadSession(chi.URLParam(r, "id"), req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", "0")
+	writeJSON(w, http.StatusCreated, sess)
+}
+
+// GetUpload reports how many bytes of an upload the server has, so a client
+// can resume after a dropped connection.
+func (h *CamerasHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	sess, err := h.svc.GetUploadSession(chi.URLParam(r, "id"), chi.URLParam(r, "upload_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+	writeJSON(w, http.StatusOK, sess)
+}
+
+// UploadChunk appends the request body at the offset given in the
+// Upload-Offset header. On an offset mismatch it answers 409 with the
+// server's offset in the same header.
+func (h *CamerasHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Upload-Offset header must be a non-negative integer")
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxChunkSize)
+	newOffset, err := h.svc.AppendUploadChunk(chi.URLParam(r, "id"), chi.URLParam(r, "upload_id"), offset, body)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, CodeInvalidRequest, "chunk exceeds %d bytes", maxChunkSize)
+			return
+		}
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"offset": newOffset})
+}
+
+// AbortUpload discards an unfinished upload.
+func (h *CamerasHandler) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.AbortUpload(chi.URLParam(r, "id"), chi.URLParam(r, "upload_id")); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "aborted"})
+}
+
+// FinalizeUploads assembles one or more completed uploads into the camera's
+// video directory and starts the same post-upload processing as the
+// multipart endpoint. The response has the same shape as POST .../upload.
+func (h *CamerasHandler) FinalizeUploads(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req models.FinalizeUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.UploadIDs) == 0 {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "upload_ids is required")
+		return
+	}
+
+	if len(req.UploadIDs) > h.cfg.Upload.MaxFiles {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+			"too many files: %d (limit %d per request)", len(req.UploadIDs), h.cfg.Upload.MaxFiles)
+		return
+	}
+
+	// Check every upload first so a bad ID doesn't leave a half-finalized batch
+	sessions := make([]*models.UploadSession, len(req.UploadIDs))
+	for i, uploadID := range req.UploadIDs {
+		if slices.Contains(req.UploadIDs[:i], uploadID) {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "upload %s is listed twice", uploadID)
+			return
+		}
+		sess, err := h.svc.GetUploadSession(id, uploadID)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		if sess.Offset != sess.Size {
+			writeError(w, http.StatusConflict, CodeUploadOffset,
+				"upload %s incomplete: %d of %d bytes received", uploadID, sess.Offset, sess.Size)
+			return
+		}
+		sessions[i] = sess
+	}
+
+	// An upload finalized meanwhile by another request fails on its own;
+	// the files already placed still get processed
+	results := make([]models.UploadFileResult, 0, len(sessions))
+	for _, sess := range sessions {
+		path, err := h.svc.FinalizeUpload(id, sess.ID)
+		if err != nil {
+			results = append(results, models.UploadFileResult{Filename: sess.Filename, Error: err.Error()})
+			continue
+		}
+		results = append(results, h.acceptUpload(id, sess.Filename, path))
+	}
+
+	h.startPostUpload(w, id, results)
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Version is the current API version. Breaking changes to request or
+// response models go into a new version; /api/v1 stays as documented.
+const Version = "v1"
+
+// VersionHeader reports the API version that served the request.
+func VersionHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", Version)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LegacyPrefix serves the unversioned /api paths exactly like /api/v1 but
+// marks the response as deprecated and points at the versioned successor
+// (RFC 8594 style), so scripts written against /api keep working while
+// their owners get a hint to migrate.
+func LegacyPrefix(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := "/api/" + Version + strings.TrimPrefix(r.URL.Path, "/api")
+		w.Header().Set("Deprecation", "true")
+		w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
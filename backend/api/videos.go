@@ -1,44 +1,326 @@
 package api
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"mime"
 	"net/http"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/services"
 )
 
 type VideoHandler struct {
-	dataDir   string
-	videosDir string
+	storage     *services.Storage
+	dataDir     string
+	videosDir   string
+	previewsDir string
 }
 
-func NewVideoHandler(cfg *config.AppConfig) *VideoHandler {
+func NewVideoHandler(cfg *config.AppConfig, storage *services.Storage) *VideoHandler {
 	videosDir := filepath.Join(cfg.App.DataDir, "videos")
 	absVideos, _ := filepath.Abs(videosDir)
+	absPreviews, _ := filepath.Abs(filepath.Join(cfg.App.DataDir, "previews"))
 	return &VideoHandler{
-		dataDir:   cfg.App.DataDir,
-		videosDir: absVideos,
+		storage:     storage,
+		dataDir:     cfg.App.DataDir,
+		videosDir:   absVideos,
+		previewsDir: absPreviews,
 	}
 }
 
 // Play serves a video file by its encoded video ID.
 // Video ID format: "front_door--2026-02-18--1400" → "videos/front_door/2026-02-18/1400.mp4"
 // http.ServeFile handles Range requests automatically for seeking support.
+//
+// With ?transcode=1 the video is instead piped through ffmpeg as fragmented
+// MP4 (H.264 remuxed, HEVC transcoded), for browsers that can't decode the
+// original. That stream can't seek, so ?start=SECONDS sets where it begins.
 func (h *VideoHandler) Play(w http.ResponseWriter, r *http.Request) {
 	videoID := chi.URLParam(r, "video_id")
+	absPath, ok := h.videoPath(w, videoID)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("transcode") != "1" {
+		http.ServeFile(w, r, absPath)
+		return
+	}
+
+	if _, err := os.Stat(absPath); err != nil {
+		writeError(w, http.StatusNotFound, CodeVideoNotFound, "video not found")
+		return
+	}
+	var start float64
+	if v := r.URL.Query().Get("start"); v != "" {
+		var err error
+		start, err = strconv.ParseFloat(v, 64)
+		if err != nil || start < 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "start must be a non-negative number of seconds")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := services.StreamPlayback(r.Context(), absPath, start, w); err != nil && r.Context().Err() == nil {
+		// Headers are usually sent by now; the player sees a truncated video
+		log.Printf("Playback transcode for %s: %v", videoID, err)
+	}
+}
+
+// Clip cuts start..end (seconds, ?start=&end=) out of a video with ffmpeg
+// stream copy and sends it as an MP4 download. Without re-encoding the cut
+// snaps to the keyframe at or before start, so the clip may begin slightly
+// early.
+func (h *VideoHandler) Clip(w http.ResponseWriter, r *http.Request) {
+	videoID := chi.URLParam(r, "video_id")
+	start, errStart := strconv.ParseFloat(r.URL.Query().Get("start"), 64)
+	end, errEnd := strconv.ParseFloat(r.URL.Query().Get("end"), 64)
+	if errStart != nil || errEnd != nil || start < 0 || end <= start {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "start and end must be seconds with 0 <= start < end")
+		return
+	}
+	if end-start > maxClipSec {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "clips are limited to %d seconds", maxClipSec)
+		return
+	}
+
+	h.serveClip(w, r, videoID, start, end, false)
+}
+
+// ResultClip cuts a clip around a search result's frame out of its source
+// video: ?pad= seconds (default 15) on each side of the frame's seek
+// offset. It plays inline, or downloads with ?download=1, so a search hit
+// is one click from a clip to share.
+func (h *VideoHandler) ResultClip(w http.ResponseWriter, r *http.Request) {
+	pad := float64(defaultResultClipPadSec)
+	if v := r.URL.Query().Get("pad"); v != "" {
+		var err error
+		pad, err = strconv.ParseFloat(v, 64)
+		if err != nil || pad <= 0 || pad > maxClipSec/2 {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "pad must be seconds between 0 and %d", maxClipSec/2)
+			return
+		}
+	}
+	frame, err := h.storage.GetClipEmbedding(chi.URLParam(r, "frame_id"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if frame.SourceVideo == "" {
+		writeError(w, http.StatusNotFound, CodeVideoNotFound, "frame has no source video")
+		return
+	}
+	offset := float64(services.SeekOffset(frame.Timestamp, frame.SourceVideo))
+	h.serveClip(w, r, sourceVideoID(frame.SourceVideo), max(offset-pad, 0), offset+pad,
+		r.URL.Query().Get("download") != "1")
+}
 
-	// Decode video ID back to file path
-	relativePath := strings.ReplaceAll(videoID, "--", "/") + ".mp4"
-	filePath := filepath.Join(h.dataDir, "videos", relativePath)
+// serveClip sends start..end of a video, with end cut back to its
+// duration, as an attachment or inline.
+func (h *VideoHandler) serveClip(w http.ResponseWriter, r *http.Request, videoID string, start, end float64, inline bool) {
+	absPath, ok := h.videoPath(w, videoID)
+	if !ok {
+		return
+	}
+
+	if _, err := os.Stat(absPath); err != nil {
+		writeError(w, http.StatusNotFound, CodeVideoNotFound, "video not found")
+		return
+	}
+	probe, err := services.ProbeVideo(absPath)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if start >= probe.DurationSec {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+			"start is past the end of the video (%.1fs)", probe.DurationSec)
+		return
+	}
+	end = math.Min(end, probe.DurationSec)
+
+	tmp, err := os.CreateTemp("", "intelsk-clip-*.mp4")
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := services.ExtractClip(r.Context(), absPath, tmp.Name(), start, end); err != nil {
+		if r.Context().Err() == nil {
+			writeServiceError(w, err)
+		}
+		return
+	}
+
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	name := fmt.Sprintf("%s_%d-%d.mp4", videoID, int(start), int(math.Ceil(end)))
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// Download serves a video whole as an attachment named after its camera,
+// date and start ("front_door_2026-02-18_1400.mp4"), for exporting evidence.
+// With ?checksum=sha256 (or md5) the file's digest is sent as Repr-Digest
+// (RFC 9530) and, in hex as sha256sum prints it, X-Checksum-SHA256, so the
+// copy can be verified. Range requests resume interrupted downloads.
+func (h *VideoHandler) Download(w http.ResponseWriter, r *http.Request) {
+	videoID := chi.URLParam(r, "video_id")
+	absPath, ok := h.videoPath(w, videoID)
+	if !ok {
+		return
+	}
+	f, err := os.Open(absPath)
+	if err != nil {
+		writeError(w, http.StatusNotFound, CodeVideoNotFound, "video not found")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	if alg := r.URL.Query().Get("checksum"); alg != "" {
+		sum, err := services.FileChecksum(absPath, alg)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		digestAlg := alg
+		if alg == services.ChecksumSHA256 {
+			digestAlg = "sha-256"
+		}
+		w.Header().Set("Repr-Digest", digestAlg+"=:"+base64.StdEncoding.EncodeToString(sum)+":")
+		w.Header().Set("X-Checksum-"+strings.ToUpper(alg), hex.EncodeToString(sum))
+	}
+
+	name := strings.ReplaceAll(videoID, "--", "_") + ".mp4"
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// Previews serves the WebVTT index of a video's hover-scrub preview sprites,
+// generating them on the first request (see services.PreviewSprites). Its
+// cues point at previews/sprite_NNN.jpg relative to this URL.
+func (h *VideoHandler) Previews(w http.ResponseWriter, r *http.Request) {
+	videoID := chi.URLParam(r, "video_id")
+	absPath, ok := h.videoPath(w, videoID)
+	if !ok {
+		return
+	}
+	vtt, err := services.PreviewSprites(r.Context(), absPath, filepath.Join(h.previewsDir, videoRelPath(videoID)))
+	if err != nil {
+		if r.Context().Err() == nil {
+			writeServiceError(w, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, vtt)
+}
+
+// Poster serves a cached JPEG poster of a video, for lists of recordings:
+// its middle frame, or with ?frame=first its first (see services.VideoPoster).
+func (h *VideoHandler) Poster(w http.ResponseWriter, r *http.Request) {
+	videoID := chi.URLParam(r, "video_id")
+	absPath, ok := h.videoPath(w, videoID)
+	if !ok {
+		return
+	}
+	frame := r.URL.Query().Get("frame")
+	if frame == "" {
+		frame = services.PosterMiddle
+	}
+	poster, err := services.VideoPoster(r.Context(), absPath, filepath.Join(h.previewsDir, videoRelPath(videoID)), frame)
+	if err != nil {
+		if r.Context().Err() == nil {
+			writeServiceError(w, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, poster)
+}
+
+var previewSpriteRe = regexp.MustCompile(`^sprite_[0-9]+\.jpg$`)
+
+// PreviewSprite serves one sprite sheet listed by Previews.
+func (h *VideoHandler) PreviewSprite(w http.ResponseWriter, r *http.Request) {
+	videoID := chi.URLParam(r, "video_id")
+	file := chi.URLParam(r, "file")
+	if _, ok := h.videoPath(w, videoID); !ok {
+		return
+	}
+	if !previewSpriteRe.MatchString(file) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid sprite name")
+		return
+	}
+	path := filepath.Join(h.previewsDir, videoRelPath(videoID), file)
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, http.StatusNotFound, CodeVideoNotFound, "preview not found; request previews.vtt first")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, path)
+}
+
+// maxClipSec caps exported clips; longer spans should be downloaded whole.
+const maxClipSec = 3600
+
+// defaultResultClipPadSec is how much video a search result's clip covers
+// on each side of the frame without ?pad=.
+const defaultResultClipPadSec = 15
+
+// videoPath decodes a video ID into its file under data/videos, writing a
+// 400 and returning false if the ID escapes that directory.
+func (h *VideoHandler) videoPath(w http.ResponseWriter, videoID string) (string, bool) {
+	// "front_door--2026-02-18--1400" → "videos/front_door/2026-02-18/1400.mp4"
+	filePath := filepath.Join(h.dataDir, "videos", videoRelPath(videoID)+".mp4")
 
 	// Path validation: ensure resolved path stays within data/videos/
 	absPath, err := filepath.Abs(filePath)
 	if err != nil || !strings.HasPrefix(absPath, h.videosDir) {
-		http.Error(w, "invalid video ID", http.StatusBadRequest)
-		return
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid video ID")
+		return "", false
 	}
+	return absPath, true
+}
 
-	http.ServeFile(w, r, absPath)
+// videoRelPath maps a video ID to its path below data/videos, without the
+// extension: "front_door--2026-02-18--1400" → "front_door/2026-02-18/1400".
+// The same layout holds the video's previews below data/previews.
+func videoRelPath(videoID string) string {
+	return strings.ReplaceAll(videoID, "--", "/")
 }
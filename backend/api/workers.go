@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+// workerPollTimeout is how long a claim waits for a batch before answering
+// 204, so idle workers don't hammer the server.
+const workerPollTimeout = 25 * time.Second
+
+// WorkersHandler serves remote indexing workers ('backend worker'): they
+// claim frame batches, download the frames and post back the embeddings.
+// Workers name themselves with ?worker=.
+type WorkersHandler struct {
+	pool *services.WorkerPool
+}
+
+func NewWorkersHandler(pool *services.WorkerPool) *WorkersHandler {
+	return &WorkersHandler{pool: pool}
+}
+
+// Status returns whether worker mode is on, the queue and the workers seen.
+func (h *WorkersHandler) Status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.pool.Status())
+}
+
+// workerName returns the ?worker= name, writing a 400 when it is missing.
+func workerName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.URL.Query().Get("worker")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "worker query parameter required")
+		return "", false
+	}
+	return name, true
+}
+
+// Claim hands the worker the next batch, waiting up to workerPollTimeout for
+// one; 204 when there is none.
+func (h *WorkersHandler) Claim(w http.ResponseWriter, r *http.Request) {
+	worker, ok := workerName(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), workerPollTimeout)
+	defer cancel()
+	task, ok := h.pool.Claim(ctx, worker)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+// Frame serves frame {index} of a claimed batch.
+func (h *WorkersHandler) Frame(w http.ResponseWriter, r *http.Request) {
+	worker, ok := workerName(w, r)
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid frame index")
+		return
+	}
+	path, err := h.pool.FramePath(chi.URLParam(r, "task_id"), worker, index)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	http.ServeFile(w, r, path)
+}
+
+// Result takes a batch's embeddings, or the error the worker hit.
+func (h *WorkersHandler) Result(w http.ResponseWriter, r *http.Request) {
+	worker, ok := workerName(w, r)
+	if !ok {
+		return
+	}
+	var result models.WorkerResult
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	if err := h.pool.Complete(chi.URLParam(r, "task_id"), worker, result); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "accepted"})
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+// WorkspaceMux owns the per-workspace routers. Router opens a workspace on
+// first use; Close releases its database and streams and fails with
+// services.ErrBusy while jobs are still running.
+type WorkspaceMux interface {
+	Router(id string) (http.Handler, error)
+	Close(id string) error
+}
+
+type WorkspacesHandler struct {
+	svc *services.WorkspaceService
+	mux WorkspaceMux
+}
+
+func NewWorkspacesHandler(svc *services.WorkspaceService, mux WorkspaceMux) *WorkspacesHandler {
+	return &WorkspacesHandler{svc: svc, mux: mux}
+}
+
+// Dispatch serves a request mounted under /api/workspaces/{workspace} with
+// that workspace's router.
+func (h *WorkspacesHandler) Dispatch(w http.ResponseWriter, r *http.Request) {
+	router, err := h.mux.Router(chi.URLParam(r, "workspace"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	router.ServeHTTP(w, r)
+}
+
+func (h *WorkspacesHandler) List(w http.ResponseWriter, r *http.Request) {
+	workspaces, err := h.svc.List()
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, workspaces)
+}
+
+func (h *WorkspacesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.svc.Get(chi.URLParam(r, "workspace"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ws)
+}
+
+func (h *WorkspacesHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+
+	ws, err := h.svc.Create(req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ws)
+}
+
+// Delete unregisters a workspace. With ?delete_data=true its videos, frames
+// and database are removed as well.
+func (h *WorkspacesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "workspace")
+	deleteData := r.URL.Query().Get("delete_data") == "true"
+
+	if _, err := h.svc.Get(id); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if err := h.mux.Close(id); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if err := h.svc.Delete(id, deleteData); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -1,11 +1,18 @@
 package server
 
 import (
+	"context"
+	"expvar"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"path/filepath"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -14,137 +21,273 @@ import (
 	"github.com/intelsk/backend/api"
 	"github.com/intelsk/backend/config"
 	"github.com/intelsk/backend/services"
+	"github.com/intelsk/backend/web"
 )
 
-func Start(cfg *config.AppConfig) {
-	// Init ML client
+// Options are the process-level settings of 'serve' that don't belong in
+// the YAML config.
+type Options struct {
+	// PIDFile, if set, receives the server's process ID while it runs.
+	PIDFile string
+	// WaitForML delays readiness (and READY=1 to systemd) until the ML
+	// sidecar answers, for at most this long. Zero doesn't wait.
+	WaitForML time.Duration
+}
+
+// shutdownTimeout bounds how long in-flight requests may take to finish
+// after SIGTERM before the server stops anyway.
+const shutdownTimeout = 30 * time.Second
+
+// Start runs the HTTP server until SIGINT or SIGTERM. SIGHUP re-reads the
+// runtime settings from the database. Under systemd (Type=notify) readiness,
+// reloads, shutdown and watchdog pings are reported via sd_notify.
+func Start(cfg *config.AppConfig, opts Options) {
+	if opts.PIDFile != "" {
+		if err := writePIDFile(opts.PIDFile); err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer os.Remove(opts.PIDFile)
+	}
+
+	// Init ML client; the default workspace's ml.* settings say where the
+	// sidecar is. With mlservice.backend onnx the CLIP encoders run in
+	// process instead.
 	mlClient := services.NewMLClient(cfg.MLService.URL)
-	log.Printf("ML sidecar configured at %s", cfg.MLService.URL)
+	encoder, err := services.NewEncoder(cfg, mlClient)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	// Jobs of every workspace share the same slots, sized by the default
+	// workspace's jobs.max_concurrent setting
+	jobQueue := services.NewJobQueue(1)
+	// So does the queue of remote indexing workers, switched on by the
+	// default workspace's workers.* settings
+	workerPool := services.NewWorkerPool()
 
-	// Init storage
-	storage, err := services.NewStorage(cfg.Storage.DBPath)
+	// Init the default workspace; others are opened on first request
+	defaultStack, err := newWorkspaceStack(cfg, encoder, jobQueue, workerPool)
 	if err != nil {
-		log.Fatalf("opening storage: %v", err)
+		log.Fatalf("%v", err)
 	}
-	defer storage.Close()
+	defer defaultStack.storage.Close()
 	log.Printf("SQLite storage at %s", cfg.Storage.DBPath)
 
-	// Init settings
-	settingsSvc := services.NewSettingsService(storage.DB(), cfg)
+	mlClient.Configure(services.MLConfigFromSettings(defaultStack.settings, cfg))
+	if onnx, ok := encoder.(*services.ONNXEncoder); ok {
+		log.Printf("CLIP encoders run in process on the ONNX models in %s", onnx.Dir())
+	} else {
+		log.Printf("ML sidecar configured at %s", mlClient.BaseURL())
+	}
+	defaultStack.settings.OnChange(func(keys []string) {
+		if !slices.ContainsFunc(keys, func(k string) bool {
+			return strings.HasPrefix(k, "ml.") || strings.HasPrefix(k, "clip.batch_")
+		}) {
+			return
+		}
+		mlClient.Configure(services.MLConfigFromSettings(defaultStack.settings, cfg))
+		log.Printf("ML sidecar reconfigured at %s", mlClient.BaseURL())
+	})
+	jobQueue.SetLimit(defaultStack.settings.GetInt("jobs.max_concurrent"))
+	defaultStack.settings.OnChange(func(keys []string) {
+		if slices.Contains(keys, "jobs.max_concurrent") {
+			jobQueue.SetLimit(defaultStack.settings.GetInt("jobs.max_concurrent"))
+		}
+	})
+	configureWorkers := func() {
+		workerPool.Configure(defaultStack.settings.GetBool("workers.enabled"), cfg.Workers.Token)
+	}
+	configureWorkers()
+	if workerPool.Enabled() {
+		log.Printf("Worker mode: frames are indexed by remote workers")
+	}
+	defaultStack.settings.OnChange(func(keys []string) {
+		if slices.Contains(keys, "workers.enabled") {
+			configureWorkers()
+		}
+	})
+	defaultStack.start()
+	if opts.WaitForML > 0 {
+		sdNotify("STATUS=Waiting for ML sidecar")
+		log.Printf("Waiting up to %s for ML sidecar...", opts.WaitForML)
+		if err := encoder.WaitForReady(opts.WaitForML); err != nil {
+			log.Printf("WARNING: ML sidecar not ready, starting anyway: %v", err)
+		}
+	}
 
 	// Sync CLIP model: if the saved setting differs from the default,
 	// tell the ML sidecar to load the correct model on startup.
-	if savedModel := settingsSvc.Get("clip.model"); savedModel != "" && savedModel != "mobileclip-s0" {
+	if savedModel := defaultStack.settings.Get("clip.model"); savedModel != "" && savedModel != "mobileclip-s0" {
 		log.Printf("Saved CLIP model is %q, waiting for ML sidecar to sync...", savedModel)
-		if err := mlClient.WaitForReady(120 * time.Second); err != nil {
+		if err := encoder.WaitForReady(encoder.ReadyTimeout()); err != nil {
 			log.Printf("WARNING: ML sidecar not ready, cannot sync model: %v", err)
-		} else if _, err := mlClient.ReloadModel(savedModel); err != nil {
+		} else if _, err := encoder.ReloadModel(savedModel); err != nil {
 			log.Printf("WARNING: failed to reload saved CLIP model %q: %v", savedModel, err)
 		} else {
 			log.Printf("ML sidecar synced to model %q", savedModel)
 		}
 	}
 
-	// Init services
-	cameraSvc := services.NewCameraService(storage.DB(), cfg)
-	streamer := services.NewStreamer(filepath.Join(cfg.App.DataDir, "streams"))
-	streamer.StartCleanup()
-
 	// Init handlers
-	processHandler := api.NewProcessHandler(cfg, mlClient, storage, settingsSvc, cameraSvc)
-	searchHandler := api.NewSearchHandler(cfg, mlClient, settingsSvc)
-	camerasHandler := api.NewCamerasHandler(cameraSvc, cfg, mlClient, storage, settingsSvc, streamer)
-	videoHandler := api.NewVideoHandler(cfg)
-	settingsHandler := api.NewSettingsHandler(settingsSvc, cfg, mlClient, storage)
+	healthHandler := api.NewHealthHandler(cfg, encoder, defaultStack.storage, defaultStack.settings,
+		defaultStack.streamer, defaultStack.process, defaultStack.cameras)
 
 	// Router
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	// gzip/deflate for text payloads only. JPEG frames, MP4 playback and TS
+	// segments are already compressed (and MP4 relies on Range requests), so
+	// they are deliberately left out of the type list.
+	r.Use(middleware.Compress(5,
+		"application/json",
+		"application/vnd.apple.mpegurl",
+		"text/plain",
+		"text/html",
+		"text/css",
+		"text/javascript",
+		"application/javascript",
+		"image/svg+xml",
+	))
+	if cfg.CORS.AllowCredentials && slices.Contains(cfg.CORS.AllowedOrigins, "*") {
+		log.Printf("WARNING: cors.allow_credentials is ignored with a wildcard origin; list explicit origins instead")
+		cfg.CORS.AllowCredentials = false
+	}
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300,
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		ExposedHeaders:   []string{"Link", "X-Total-Count", "API-Version", "Deprecation", "Upload-Offset"},
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           cfg.CORS.MaxAge,
 	}))
 
-	// API routes
-	r.Route("/api", func(r chi.Router) {
-		r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-			api.HealthCheck(w, r, mlClient)
+	// Rate limiting: a general per-client budget plus a much tighter one for
+	// endpoints that hit the ML sidecar or spawn ffmpeg.
+	// The admin and worker APIs get a budget per token instead.
+	limit := func(next http.Handler) http.Handler { return next }
+	limitExpensive, limitKey := limit, limit
+	if cfg.RateLimit.Enabled {
+		limit = api.NewRateLimiter(cfg.RateLimit.RequestsPerMinute, cfg.RateLimit.Burst).Middleware
+		limitExpensive = api.NewRateLimiter(cfg.RateLimit.ExpensiveRequestsPerMinute, cfg.RateLimit.ExpensiveBurst).Middleware
+		limitKey = api.NewRateLimiter(cfg.RateLimit.KeyRequestsPerMinute, cfg.RateLimit.KeyBurst).KeyMiddleware
+		log.Printf("Rate limiting enabled: %d req/min (expensive: %d req/min, per token: %d req/min)",
+			cfg.RateLimit.RequestsPerMinute, cfg.RateLimit.ExpensiveRequestsPerMinute, cfg.RateLimit.KeyRequestsPerMinute)
+	}
+
+	// Probes for orchestrators and reverse proxies (not rate limited)
+	r.Get("/healthz", healthHandler.Liveness)
+	r.Get("/readyz", healthHandler.Readiness)
+
+	// Workspaces share the ML sidecar and job slots; everything else is
+	// isolated
+	workspaceSvc := services.NewWorkspaceService(defaultStack.storage.DB(), cfg)
+	workspaces := newWorkspaceManager(cfg, encoder, jobQueue, workerPool, workspaceSvc, limitExpensive, defaultStack)
+	workspacesHandler := api.NewWorkspacesHandler(workspaceSvc, workspaces)
+	workersHandler := api.NewWorkersHandler(workerPool)
+
+	// Shown at /api/v1/admin/debug/vars next to memstats and cmdline
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("workspaces", expvar.Func(func() any { return workspaces.stats() }))
+	expvar.Publish("job_queue", expvar.Func(func() any {
+		limit, running, waiting := jobQueue.Stats()
+		return map[string]int{"limit": limit, "running": running, "waiting": waiting}
+	}))
+	expvar.Publish("ml_requests", expvar.Func(func() any { return mlClient.Requests() }))
+
+	// API routes, mounted at /api/v1 and, for existing clients, at the
+	// legacy unversioned /api prefix (marked deprecated).
+	apiRoutes := func(r chi.Router) {
+		// Remote indexing workers poll and fetch frames continuously; they
+		// authenticate with workers.token and are limited per token, not
+		// per address
+		r.Group(func(r chi.Router) {
+			r.Use(api.WorkerAuth(workerPool.Token), limitKey)
+			r.Post("/workers/claim", workersHandler.Claim)
+			r.Get("/workers/tasks/{task_id}/frames/{index}", workersHandler.Frame)
+			r.Post("/workers/tasks/{task_id}/result", workersHandler.Result)
 		})
 
-		// Process pipeline
-		r.Post("/process", processHandler.Start)
-		r.Get("/process/status", processHandler.Status)
-		r.Get("/process/history", processHandler.History)
-
-		// Search
-		r.Post("/search/text", searchHandler.TextSearch)
-
-		// Settings
-		r.Get("/settings", settingsHandler.Get)
-		r.Put("/settings", settingsHandler.Update)
-		r.Get("/settings/nvr/status", settingsHandler.NVRStatus)
-
-		// CLIP model
-		r.Get("/clip/model", settingsHandler.GetClipModel)
-		r.Post("/clip/model", settingsHandler.SwitchClipModel)
-
-		// Cameras
-		r.Get("/cameras", camerasHandler.List)
-		r.Get("/cameras/{id}", camerasHandler.Get)
-		r.Post("/cameras", camerasHandler.Create)
-		r.Put("/cameras/{id}", camerasHandler.Update)
-		r.Delete("/cameras/{id}", camerasHandler.Delete)
-		r.Get("/cameras/{id}/stats", camerasHandler.Stats)
-		r.Get("/cameras/{id}/videos", camerasHandler.ListVideos)
-		r.Delete("/cameras/{id}/videos", camerasHandler.DeleteVideo)
-		r.Delete("/cameras/{id}/data", camerasHandler.CleanData)
-		r.Post("/cameras/{id}/upload", camerasHandler.Upload)
-		r.Get("/cameras/{id}/upload/status", camerasHandler.UploadStatus)
-		r.Get("/cameras/{id}/snapshot", camerasHandler.Snapshot)
-		r.Post("/cameras/{id}/stream/start", camerasHandler.StreamStart)
-		r.Get("/cameras/{id}/stream/{filename}", camerasHandler.StreamServe)
-		r.Post("/cameras/{id}/stream/stop", camerasHandler.StreamStop)
-
-		// Video playback
-		r.Get("/videos/{video_id}/play", videoHandler.Play)
-
-		// Static frame serving with path traversal protection
-		r.Get("/frames/*", serveFrames(cfg))
+		r.Group(func(r chi.Router) {
+			r.Use(limit)
+
+			r.Get("/health", healthHandler.Health)
+
+			// CLIP model (sidecar-wide, so not scoped to a workspace)
+			r.Get("/clip/model", defaultStack.settingsHandler.GetClipModel)
+			r.With(limitExpensive).Post("/clip/model", defaultStack.settingsHandler.SwitchClipModel)
+			r.Get("/clip/model/download", defaultStack.settingsHandler.ModelDownload)
+
+			// Remote indexing queue (shared by all workspaces)
+			r.Get("/workers", workersHandler.Status)
+
+			// Admin: pprof and expvar, bearer-token protected
+			r.With(api.AdminAuth(cfg.Admin.Token), limitKey).Mount("/admin/debug", middleware.Profiler())
+
+			// Workspaces
+			r.Get("/workspaces", workspacesHandler.List)
+			r.Post("/workspaces", workspacesHandler.Create)
+			r.Get("/workspaces/{workspace}", workspacesHandler.Get)
+			r.Delete("/workspaces/{workspace}", workspacesHandler.Delete)
+			r.Mount("/workspaces/{workspace}/", http.HandlerFunc(workspacesHandler.Dispatch))
+
+			// Default workspace
+			defaultStack.routes(r, limitExpensive)
+		})
+	}
+	r.Route("/api/"+api.Version, func(r chi.Router) {
+		r.Use(api.VersionHeader)
+		apiRoutes(r)
+	})
+	r.Route("/api", func(r chi.Router) {
+		r.Use(api.VersionHeader, api.LegacyPrefix)
+		apiRoutes(r)
 	})
 
+	// Embedded web UI with SPA fallback for every non-/api path
+	r.Handle("/*", web.Handler())
+
 	addr := fmt.Sprintf("%s:%d", cfg.App.Host, cfg.App.Port)
-	log.Printf("Starting server on %s", addr)
-	if err := http.ListenAndServe(addr, r); err != nil {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
 		log.Fatalf("server failed: %v", err)
 	}
-}
-
-func serveFrames(cfg *config.AppConfig) http.HandlerFunc {
-	framesDir := cfg.Extraction.StoragePath
-	absFramesDir, _ := filepath.Abs(framesDir)
+	srv := &http.Server{Handler: r}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+	log.Printf("Starting server on %s", addr)
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Extract path after /api/frames/
-		framePath := chi.URLParam(r, "*")
-		if framePath == "" {
-			http.Error(w, "frame path required", http.StatusBadRequest)
-			return
-		}
+	// Listening, so dependent units ordered After= this one may start
+	sdNotify(fmt.Sprintf("READY=1\nSTATUS=Serving on %s\nMAINPID=%d", addr, os.Getpid()))
+	startWatchdog()
 
-		filePath := filepath.Join(framesDir, framePath)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		select {
+		case err := <-serveErr:
+			log.Fatalf("server failed: %v", err)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				sdReloading()
+				workspaces.reloadSettings()
+				log.Printf("SIGHUP: runtime settings reloaded")
+				sdNotify("READY=1")
+				continue
+			}
 
-		// Path traversal protection
-		absPath, err := filepath.Abs(filePath)
-		if err != nil || !strings.HasPrefix(absPath, absFramesDir) {
-			http.Error(w, "invalid frame path", http.StatusBadRequest)
+			log.Printf("%v: shutting down (waiting up to %s for requests)", sig, shutdownTimeout)
+			sdNotify("STOPPING=1")
+			signal.Stop(signals)
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("WARNING: shutdown: %v", err)
+			}
+			cancel()
+			if n := workspaces.shutdown(); n > 0 {
+				log.Printf("WARNING: %d jobs interrupted; they resume when the server starts again (jobs.resume_interrupted)", n)
+			}
+			log.Printf("Server stopped")
 			return
 		}
-
-		http.ServeFile(w, r, absPath)
 	}
 }
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends a state string (e.g. "READY=1") to the service manager
+// over $NOTIFY_SOCKET, as sd_notify(3) does. Without the variable (not
+// started by systemd, or not Type=notify) it does nothing.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// A leading @ names a socket in the abstract namespace
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Printf("WARNING: sd_notify: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("WARNING: sd_notify: %v", err)
+	}
+}
+
+// sdReloading tells systemd a reload started. Type=notify-reload units
+// require the MONOTONIC_USEC timestamp alongside it.
+func sdReloading() {
+	usec, ok := monotonicUsec()
+	if !ok {
+		sdNotify("RELOADING=1")
+		return
+	}
+	sdNotify(fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d", usec))
+}
+
+// startWatchdog pings the systemd watchdog at half the interval set by
+// WatchdogSec=, if any.
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
+
+// writePIDFile writes the process ID to path. It refuses if the file names
+// another process that is still running, so two servers can't share a data
+// directory by accident.
+func writePIDFile(path string) error {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() && processAlive(pid) {
+			return fmt.Errorf("pid file %s: process %d is still running", path, pid)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading pid file: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return fmt.Errorf("writing pid file: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
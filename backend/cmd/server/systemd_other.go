@@ -0,0 +1,14 @@
+//go:build !unix
+
+package server
+
+// monotonicUsec is not supported on this platform (there is no systemd).
+func monotonicUsec() (int64, bool) {
+	return 0, false
+}
+
+// processAlive can't be determined on this platform; a stale pid file is
+// simply overwritten.
+func processAlive(pid int) bool {
+	return false
+}
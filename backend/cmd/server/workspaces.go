@@ -0,0 +1,405 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/intelsk/backend/api"
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/services"
+)
+
+// workspaceStack is the full set of services and handlers serving one
+// workspace. Every workspace gets its own database, settings, streamer and
+// job tables; only the ML sidecar client and the job slots are shared.
+type workspaceStack struct {
+	cfg       *config.AppConfig
+	storage   *services.Storage
+	settings  *services.SettingsService
+	streamer  *services.Streamer
+	jobs      *services.JobManager
+	scheduler *services.Scheduler
+	ingester  *services.Ingester
+	archiver  *services.Archiver
+	notifier  *services.Notifier
+	alerts    *services.AlertService
+	monitor   *services.CameraMonitor
+	disk      *services.DiskMonitor
+
+	process         *api.ProcessHandler
+	search          *api.SearchHandler
+	cameras         *api.CamerasHandler
+	jobsHandler     *api.JobsHandler
+	schedules       *api.SchedulesHandler
+	ingest          *api.IngestHandler
+	video           *api.VideoHandler
+	settingsHandler *api.SettingsHandler
+	notifications   *api.NotificationsHandler
+	alertsHandler   *api.AlertsHandler
+	reports         *api.ReportsHandler
+	bookmarks       *api.BookmarksHandler
+	cases           *api.CasesHandler
+	persons         *api.PersonsHandler
+	stats           *api.StatsHandler
+}
+
+func newWorkspaceStack(cfg *config.AppConfig, encoder services.Encoder, jobQueue *services.JobQueue, workers *services.WorkerPool) (*workspaceStack, error) {
+	storage, err := services.NewStorage(cfg.Storage.DBPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening storage: %w", err)
+	}
+
+	settingsSvc := services.NewSettingsService(storage.DB(), cfg)
+	cameraSvc := services.NewCameraService(storage.DB(), cfg).WithClock(settingsSvc.Now)
+	streamer := services.NewStreamer(filepath.Join(cfg.App.DataDir, "streams"), cfg.Stream)
+	streamer.StartCleanup()
+	jobs := services.NewJobManager(storage.DB(), settingsSvc).WithQueue(jobQueue)
+	jobs.StartPruning()
+	notifier := services.NewNotifier(settingsSvc)
+	jobs.OnFinish(notifier.JobFinished)
+	alerts := services.NewAlertService(storage.DB(), settingsSvc, notifier)
+	monitor := services.NewCameraMonitor(settingsSvc, cameraSvc, alerts)
+	disk := services.NewDiskMonitor(cfg, settingsSvc, alerts)
+	storage.OnIndexed(services.NewFrameWatch(encoder, settingsSvc, alerts).Indexed)
+	jobs.WithDiskCheck(disk.Critical)
+	// Started by start, once NewProcessHandler has registered the process
+	// runner
+	scheduler := services.NewScheduler(storage.DB(), settingsSvc, cameraSvc, jobs)
+	ingester := services.NewIngester(storage.DB(), cfg, settingsSvc, cameraSvc, jobs)
+	// Submits archive jobs, whose runner NewCamerasHandler registers
+	archiver := services.NewArchiver(cfg, settingsSvc, cameraSvc, jobs)
+
+	// Apply changed stream settings to the streams already running
+	settingsSvc.OnChange(func(keys []string) {
+		if !slices.ContainsFunc(keys, func(k string) bool { return strings.HasPrefix(k, "stream.") }) {
+			return
+		}
+		go streamer.ApplyParams(func(cameraID string) services.StreamParams {
+			var camConfig map[string]any
+			if cam, err := cameraSvc.Get(cameraID); err == nil {
+				camConfig = cam.Config
+			}
+			return services.ResolveStreamParams(settingsSvc, camConfig)
+		})
+	})
+
+	bookmarks := services.NewBookmarkService(storage).WithClock(settingsSvc.Now)
+	enrichment := services.NewEnrichmentService(storage.DB(), settingsSvc)
+
+	process := api.NewProcessHandler(cfg, encoder, workers, storage, settingsSvc, cameraSvc, jobs)
+	stack := &workspaceStack{
+		cfg:             cfg,
+		storage:         storage,
+		settings:        settingsSvc,
+		streamer:        streamer,
+		jobs:            jobs,
+		scheduler:       scheduler,
+		ingester:        ingester,
+		archiver:        archiver,
+		notifier:        notifier,
+		alerts:          alerts,
+		monitor:         monitor,
+		disk:            disk,
+		process:         process,
+		search:          api.NewSearchHandler(cfg, encoder, settingsSvc, bookmarks, services.NewSearchFilterService(cfg, storage, settingsSvc, cameraSvc, encoder), enrichment),
+		cameras:         api.NewCamerasHandler(cameraSvc, cfg, encoder, workers, storage, settingsSvc, streamer, jobs),
+		jobsHandler:     api.NewJobsHandler(jobs),
+		schedules:       api.NewSchedulesHandler(scheduler, jobs),
+		ingest:          api.NewIngestHandler(cfg, ingester, process, cameraSvc, jobs),
+		video:           api.NewVideoHandler(cfg, storage),
+		settingsHandler: api.NewSettingsHandler(settingsSvc, cfg, encoder, storage, jobs),
+		notifications:   api.NewNotificationsHandler(notifier),
+		alertsHandler:   api.NewAlertsHandler(alerts, cfg, settingsSvc),
+		reports:         api.NewReportsHandler(services.NewReportService(cfg, storage.DB(), settingsSvc, cameraSvc, encoder, enrichment)),
+		bookmarks:       api.NewBookmarksHandler(bookmarks, cfg, settingsSvc),
+		cases:           api.NewCasesHandler(services.NewCaseService(storage), cfg, settingsSvc),
+		persons:         api.NewPersonsHandler(services.NewFaceService(cfg, storage, nil).WithJobs(jobs)),
+		stats:           api.NewStatsHandler(cfg, storage, cameraSvc, jobs, streamer, encoder),
+	}
+	return stack, nil
+}
+
+// start resumes the workspace's interrupted jobs and starts its scheduler,
+// auto-ingest poller, archiver, camera and disk monitors and alert digests.
+// The shared ML client and worker pool must be configured by then.
+func (s *workspaceStack) start() {
+	// Before the scheduler, which must see a resumed run as still running
+	s.jobs.ResumeInterrupted()
+	s.scheduler.Start()
+	s.ingester.Start()
+	s.archiver.Start()
+	s.monitor.Start()
+	s.disk.Start()
+	s.alerts.Start()
+}
+
+// routes registers the workspace-scoped API. The same routes are served at
+// /api for the default workspace and at /api/workspaces/{workspace} for the
+// others.
+func (s *workspaceStack) routes(r chi.Router, limitExpensive func(http.Handler) http.Handler) {
+	// Process pipeline
+	r.With(limitExpensive).Post("/process", s.process.Start)
+	r.Get("/process/status", s.process.Status)
+	r.Get("/process/history", s.process.History)
+	r.Get("/process/jobs", s.process.Jobs)
+	r.Post("/process/jobs/{job_id}/cancel", s.process.Cancel)
+
+	// Background jobs of every type
+	r.Get("/jobs", s.jobsHandler.List)
+	r.Get("/jobs/{job_id}", s.jobsHandler.Get)
+	r.Get("/jobs/{job_id}/events", s.jobsHandler.Events)
+	r.Get("/jobs/{job_id}/log", s.jobsHandler.Log)
+	r.Post("/jobs/{job_id}/cancel", s.jobsHandler.Cancel)
+	r.With(limitExpensive).Post("/jobs/{job_id}/retry", s.jobsHandler.Retry)
+
+	// Scheduled processing
+	r.Get("/schedules", s.schedules.List)
+	r.Post("/schedules", s.schedules.Create)
+	r.Get("/schedules/{schedule_id}", s.schedules.Get)
+	r.Put("/schedules/{schedule_id}", s.schedules.Update)
+	r.Delete("/schedules/{schedule_id}", s.schedules.Delete)
+	r.With(limitExpensive).Post("/schedules/{schedule_id}/run", s.schedules.Run)
+
+	// Continuous NVR ingest
+	r.Get("/ingest", s.ingest.List)
+	r.With(limitExpensive).Post("/ingest/{id}/run", s.ingest.Run)
+
+	// Alerts
+	r.Get("/alerts", s.alertsHandler.List)
+	r.Get("/alerts/rules", s.alertsHandler.Rules)
+	r.Put("/alerts/rules/{rule}", s.alertsHandler.UpdateRule)
+	r.Delete("/alerts/rules/{rule}", s.alertsHandler.ResetRule)
+	r.Get("/alerts/{alert_id}", s.alertsHandler.Get)
+	r.Post("/alerts/{alert_id}/ack", s.alertsHandler.Ack)
+	r.Post("/alerts/{alert_id}/dismiss", s.alertsHandler.Dismiss)
+
+	// Bookmarks
+	r.Get("/bookmarks", s.bookmarks.List)
+	r.Post("/bookmarks", s.bookmarks.Create)
+	r.Get("/bookmarks/{bookmark_id}", s.bookmarks.Get)
+	r.Put("/bookmarks/{bookmark_id}", s.bookmarks.Update)
+	r.Delete("/bookmarks/{bookmark_id}", s.bookmarks.Delete)
+
+	// Cases
+	r.Get("/cases", s.cases.List)
+	r.Post("/cases", s.cases.Create)
+	r.Get("/cases/{case_id}", s.cases.Get)
+	r.Put("/cases/{case_id}", s.cases.Update)
+	r.Delete("/cases/{case_id}", s.cases.Delete)
+	r.Post("/cases/{case_id}/items", s.cases.AddItem)
+	r.Delete("/cases/{case_id}/items/{item_id}", s.cases.RemoveItem)
+	r.With(limitExpensive).Get("/cases/{case_id}/export", s.cases.Export)
+
+	// Enrolled persons and the erasure of their data
+	r.Get("/persons", s.persons.List)
+	r.With(limitExpensive).Post("/persons/{name}/erase", s.persons.Erase)
+
+	// Statistics
+	r.Get("/stats", s.stats.Stats)
+
+	// Reports
+	r.With(limitExpensive).Get("/reports/daily", s.reports.Daily)
+
+	// Search
+	r.With(limitExpensive).Post("/search/text", s.search.TextSearch)
+	r.With(limitExpensive).Get("/search/results/{frame_id}/clip", s.video.ResultClip)
+
+	// Settings
+	r.Get("/settings", s.settingsHandler.Get)
+	r.Put("/settings", s.settingsHandler.Update)
+	r.Get("/settings/schema", s.settingsHandler.Schema)
+	r.Get("/settings/events", s.settingsHandler.Events)
+	r.Get("/settings/export", s.settingsHandler.Export)
+	r.Post("/settings/import", s.settingsHandler.Import)
+	r.Get("/settings/nvr/status", s.settingsHandler.NVRStatus)
+	r.Get("/settings/notifications", s.notifications.Channels)
+	r.Post("/settings/notifications/{channel}/test", s.notifications.Test)
+
+	// Cameras
+	r.Get("/cameras", s.cameras.List)
+	r.Get("/cameras/{id}", s.cameras.Get)
+	r.Post("/cameras", s.cameras.Create)
+	r.Put("/cameras/{id}", s.cameras.Update)
+	r.Delete("/cameras/{id}", s.cameras.Delete)
+	r.Post("/cameras/{id}/rename", s.cameras.Rename)
+	r.Get("/cameras/{id}/stats", s.cameras.Stats)
+	r.Get("/cameras/{id}/videos", s.cameras.ListVideos)
+	r.Get("/cameras/{id}/frames", s.cameras.Frames)
+	r.Get("/cameras/{id}/timeline", s.cameras.Timeline)
+	r.Get("/cameras/{id}/timeline/locate", s.cameras.TimelineLocate)
+	r.With(limitExpensive).Get("/cameras/{id}/timelapse", s.cameras.Timelapse)
+	r.Delete("/cameras/{id}/videos", s.cameras.DeleteVideo)
+	r.Delete("/cameras/{id}/dates/{date}", s.cameras.DeleteDate)
+	r.With(limitExpensive).Post("/cameras/{id}/dates/{date}/archive", s.cameras.ArchiveDate)
+	r.Delete("/cameras/{id}/data", s.cameras.CleanData)
+	r.With(limitExpensive).Post("/cameras/{id}/upload", s.cameras.Upload)
+	r.With(limitExpensive).Post("/cameras/{id}/import", s.cameras.Import)
+	r.Get("/cameras/{id}/upload/status", s.cameras.UploadStatus)
+	r.Get("/cameras/{id}/upload/jobs", s.cameras.UploadJobs)
+	r.With(limitExpensive).Post("/cameras/{id}/uploads", s.cameras.CreateUpload)
+	r.With(limitExpensive).Post("/cameras/{id}/uploads/finalize", s.cameras.FinalizeUploads)
+	r.Get("/cameras/{id}/uploads/{upload_id}", s.cameras.GetUpload)
+	r.Patch("/cameras/{id}/uploads/{upload_id}", s.cameras.UploadChunk)
+	r.Delete("/cameras/{id}/uploads/{upload_id}", s.cameras.AbortUpload)
+	r.Get("/cameras/{id}/snapshot", s.cameras.Snapshot)
+	r.Post("/cameras/{id}/stream/start", s.cameras.StreamStart)
+	r.Get("/cameras/{id}/stream/status", s.cameras.StreamStatus)
+	r.Get("/cameras/{id}/stream/diagnostics", s.cameras.StreamDiagnostics)
+	r.Get("/cameras/{id}/stream/{filename}", s.cameras.StreamServe)
+	r.Post("/cameras/{id}/stream/stop", s.cameras.StreamStop)
+
+	// Video playback
+	r.Get("/videos/{video_id}/play", s.video.Play)
+	r.With(limitExpensive).Get("/videos/{video_id}/clip", s.video.Clip)
+	r.Get("/videos/{video_id}/download", s.video.Download)
+	r.With(limitExpensive).Get("/videos/{video_id}/previews.vtt", s.video.Previews)
+	r.Get("/videos/{video_id}/previews/{file}", s.video.PreviewSprite)
+	r.Get("/videos/{video_id}/poster", s.video.Poster)
+
+	// Static frame serving with path traversal protection
+	r.Get("/frames/*", api.ServeFrames(s.cfg))
+}
+
+// workspaceManager opens non-default workspaces lazily on first request and
+// keeps them open until they are deleted.
+type workspaceManager struct {
+	cfg            *config.AppConfig
+	encoder        services.Encoder
+	jobQueue       *services.JobQueue
+	workers        *services.WorkerPool
+	svc            *services.WorkspaceService
+	limitExpensive func(http.Handler) http.Handler
+
+	mu      sync.Mutex
+	stacks  map[string]*workspaceStack
+	routers map[string]http.Handler
+}
+
+// newWorkspaceManager creates a manager whose default workspace is served by
+// the already open defaultStack.
+func newWorkspaceManager(cfg *config.AppConfig, encoder services.Encoder, jobQueue *services.JobQueue, workers *services.WorkerPool, svc *services.WorkspaceService, limitExpensive func(http.Handler) http.Handler, defaultStack *workspaceStack) *workspaceManager {
+	router := chi.NewRouter()
+	defaultStack.routes(router, limitExpensive)
+	return &workspaceManager{
+		cfg:            cfg,
+		encoder:        encoder,
+		jobQueue:       jobQueue,
+		workers:        workers,
+		svc:            svc,
+		limitExpensive: limitExpensive,
+		stacks:         map[string]*workspaceStack{config.DefaultWorkspace: defaultStack},
+		routers:        map[string]http.Handler{config.DefaultWorkspace: router},
+	}
+}
+
+// Router implements api.WorkspaceMux.
+func (m *workspaceManager) Router(id string) (http.Handler, error) {
+	if _, err := m.svc.Get(id); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if router, ok := m.routers[id]; ok {
+		return router, nil
+	}
+
+	stack, err := newWorkspaceStack(m.cfg.ForWorkspace(id), m.encoder, m.jobQueue, m.workers)
+	if err != nil {
+		return nil, fmt.Errorf("opening workspace %s: %w", id, err)
+	}
+	stack.start()
+	router := chi.NewRouter()
+	stack.routes(router, m.limitExpensive)
+
+	m.stacks[id] = stack
+	m.routers[id] = router
+	return router, nil
+}
+
+// stats reports the in-memory job tables and stream counts of every open
+// workspace, published via expvar for leak diagnosis.
+func (m *workspaceManager) stats() map[string]any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]any, len(m.stacks))
+	for id, stack := range m.stacks {
+		out[id] = map[string]int{
+			"process_jobs":        stack.process.TrackedJobCount(),
+			"process_jobs_active": stack.process.ActiveJobCount(),
+			"upload_jobs":         stack.cameras.TrackedUploadCount(),
+			"upload_jobs_active":  stack.cameras.ActiveUploadCount(),
+			"streams":             stack.streamer.ActiveCount(),
+		}
+	}
+	return out
+}
+
+// Close implements api.WorkspaceMux. It refuses while the workspace still
+// has jobs of any type running or queued, stops its live streams and closes
+// its database.
+func (m *workspaceManager) Close(id string) error {
+	if id == config.DefaultWorkspace {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stack, ok := m.stacks[id]
+	if !ok {
+		return nil
+	}
+	if stack.jobs.ActiveCount("") > 0 {
+		return fmt.Errorf("workspace %s has running jobs: %w", id, services.ErrBusy)
+	}
+	stack.streamer.StopAll()
+	stack.scheduler.Close()
+	stack.ingester.Close()
+	stack.archiver.Close()
+	stack.monitor.Close()
+	stack.disk.Close()
+	stack.alerts.Close()
+	stack.jobs.Close()
+	delete(m.stacks, id)
+	delete(m.routers, id)
+	return stack.storage.Close()
+}
+
+// reloadSettings re-reads the runtime settings of every open workspace.
+func (m *workspaceManager) reloadSettings() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, stack := range m.stacks {
+		stack.settings.Reload()
+	}
+}
+
+// shutdown stops the live streams of every open workspace and closes the
+// databases of all but the default one, which Start closes itself. It
+// returns how many jobs were still running or queued.
+func (m *workspaceManager) shutdown() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	active := 0
+	for id, stack := range m.stacks {
+		active += stack.jobs.ActiveCount("")
+		stack.streamer.StopAll()
+		stack.scheduler.Close()
+		stack.ingester.Close()
+		stack.archiver.Close()
+		stack.monitor.Close()
+		stack.disk.Close()
+		stack.alerts.Close()
+		stack.jobs.Close()
+		if id != config.DefaultWorkspace {
+			stack.storage.Close()
+		}
+	}
+	return active
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// flagCapture, when set, receives each command's FlagSet in place of
+// parsing arguments. Completion scripts are generated from the real flag
+// definitions this way, so they can't drift from the commands.
+var flagCapture func(fs *flag.FlagSet)
+
+// parseFlags parses a command's arguments. Every command calls it before
+// doing any work.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if flagCapture != nil {
+		flagCapture(fs)
+		// Stop the command here; only its goroutine ends
+		runtime.Goexit()
+	}
+	fs.Parse(args)
+}
+
+// commandFlags returns the flags a command defines, without running it.
+func commandFlags(c command) []*flag.Flag {
+	captured := make(chan *flag.FlagSet, 1)
+	flagCapture = func(fs *flag.FlagSet) { captured <- fs }
+	defer func() { flagCapture = nil }()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.run(nil)
+	}()
+	<-done
+
+	var flags []*flag.Flag
+	select {
+	case fs := <-captured:
+		fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+	default:
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+// commandWords are the positional arguments a command accepts.
+var commandWords = map[string]string{
+	"completion": "bash zsh fish",
+	"config":     "validate",
+	"faces":      "enroll index persons search erase",
+	"jobs":       "list tail log cancel retry",
+}
+
+// pathFlags take a file or directory, so the shells complete paths for them.
+var pathFlags = map[string]bool{"root": true, "video": true, "src": true, "copy-to": true, "pid-file": true}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func runCompletion(args []string) {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	name := fs.String("name", filepath.Base(os.Args[0]), "program name to complete (e.g. intelsk for the single binary)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: backend completion [-name NAME] bash|zsh|fish")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	switch fs.Arg(0) {
+	case "bash":
+		fmt.Print(bashCompletion(*name))
+	case "zsh":
+		fmt.Print(zshCompletion(*name))
+	case "fish":
+		fmt.Print(fishCompletion(*name))
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported shell %q\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+func bashCompletion(name string) string {
+	fn := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(name)
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s\n", name)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" i cmd=\"\"\n")
+	b.WriteString("\tfor ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("\t\tcase \"${COMP_WORDS[i]}\" in -json|--json) ;; *) cmd=\"${COMP_WORDS[i]}\"; break ;; esac\n")
+	b.WriteString("\tdone\n")
+	fmt.Fprintf(&b, "\tif [[ -z \"$cmd\" ]]; then\n\t\tCOMPREPLY=($(compgen -W \"--json %s\" -- \"$cur\"))\n\t\treturn\n\tfi\n",
+		strings.Join(names, " "))
+	b.WriteString("\tif [[ \"$cur\" != -* ]]; then\n\t\tcase \"$cmd\" in\n")
+	for _, c := range commands {
+		if words, ok := commandWords[c.name]; ok {
+			fmt.Fprintf(&b, "\t\t%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", c.name, words)
+		}
+	}
+	b.WriteString("\t\tesac\n")
+	b.WriteString("\t\t# Flag values (paths, IDs, dates) fall back to default completion\n")
+	b.WriteString("\t\treturn\n\tfi\n")
+	b.WriteString("\tcase \"$cmd\" in\n")
+	for _, c := range commands {
+		var opts []string
+		for _, f := range commandFlags(c) {
+			opts = append(opts, "-"+f.Name)
+		}
+		fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", c.name, strings.Join(opts, " "))
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -o default -F %s %s\n", fn, name)
+	return b.String()
+}
+
+func zshCompletion(name string) string {
+	fn := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(name)
+	esc := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]")
+	escDescribe := strings.NewReplacer("'", "'\\''", ":", "\\:")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n\n", name)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("\tlocal -a commands\n\tcommands=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "\t\t'%s:%s'\n", c.name, escDescribe.Replace(c.summary))
+	}
+	b.WriteString("\t)\n")
+	b.WriteString("\tlocal -i first=2\n")
+	b.WriteString("\t[[ $words[2] == (-json|--json) ]] && first=3\n")
+	b.WriteString("\tif (( CURRENT == first )); then\n\t\t_describe 'command' commands\n\t\treturn\n\tfi\n")
+	b.WriteString("\t# Let _arguments see the subcommand as the command name\n")
+	b.WriteString("\twords=(\"${(@)words[first,-1]}\")\n\t(( CURRENT -= first - 1 ))\n")
+	b.WriteString("\tcase $words[1] in\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "\t%s)\n\t\t_arguments", c.name)
+		for _, f := range commandFlags(c) {
+			spec := fmt.Sprintf("-%s[%s]", f.Name, esc.Replace(f.Usage))
+			switch {
+			case isBoolFlag(f):
+			case pathFlags[f.Name]:
+				spec += ":path:_files"
+			default:
+				spec += ": "
+			}
+			fmt.Fprintf(&b, " \\\n\t\t\t'%s'", spec)
+		}
+		if words, ok := commandWords[c.name]; ok {
+			fmt.Fprintf(&b, " \\\n\t\t\t'1:argument:(%s)'", words)
+		}
+		b.WriteString(" ;;\n")
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "if [ \"$funcstack[1]\" = \"%s\" ]; then\n\t%s \"$@\"\nelse\n\tcompdef %s %s\nfi\n", fn, fn, fn, name)
+	return b.String()
+}
+
+func fishCompletion(name string) string {
+	esc := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s\n", name)
+	fmt.Fprintf(&b, "complete -c %s -f\n", name)
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	noCmd := fmt.Sprintf("not __fish_seen_subcommand_from %s", strings.Join(names, " "))
+	fmt.Fprintf(&b, "complete -c %s -n '%s' -l json -d 'JSON output on stdout'\n", name, noCmd)
+	for _, c := range commands {
+		fmt.Fprintf(&b, "complete -c %s -n '%s' -a %s -d '%s'\n", name, noCmd, c.name, esc.Replace(c.summary))
+	}
+	for _, c := range commands {
+		cond := "__fish_seen_subcommand_from " + c.name
+		for _, f := range commandFlags(c) {
+			line := fmt.Sprintf("complete -c %s -n '%s' -o %s -d '%s'", name, cond, f.Name, esc.Replace(f.Usage))
+			switch {
+			case isBoolFlag(f):
+			case pathFlags[f.Name]:
+				line += " -r -F"
+			default:
+				line += " -r"
+			}
+			b.WriteString(line + "\n")
+		}
+		if words, ok := commandWords[c.name]; ok {
+			fmt.Fprintf(&b, "complete -c %s -n '%s' -a '%s'\n", name, cond, words)
+		}
+	}
+	return b.String()
+}
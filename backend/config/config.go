@@ -2,7 +2,9 @@ package config
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v3"
 )
@@ -28,6 +30,17 @@ type ExtractionSettings struct {
 
 type MLServiceSettings struct {
 	URL string `yaml:"url"`
+	// AllowDegraded keeps /readyz returning 200 (status "degraded") while the
+	// sidecar is unreachable, so search/indexing outages don't pull the API
+	// out of the load balancer.
+	AllowDegraded bool `yaml:"allow_degraded"`
+	// Backend is "sidecar", the Python service at URL, or "onnx" to run the
+	// CLIP encoders in the backend with ONNX Runtime: the models exported
+	// to ONNXDir, and the runtime library at ONNXLibrary (empty = the
+	// platform's default name). Face detection always needs the sidecar.
+	Backend     string `yaml:"backend"`
+	ONNXDir     string `yaml:"onnx_dir"`
+	ONNXLibrary string `yaml:"onnx_library"`
 }
 
 type StorageSettings struct {
@@ -42,6 +55,75 @@ type ProcessSettings struct {
 	HistoryPath string `yaml:"history_path"`
 }
 
+// UploadSettings limits what a single upload request may contain. Every file
+// is also probed with ffprobe and rejected if it isn't a readable video.
+type UploadSettings struct {
+	MaxFileSizeMB int `yaml:"max_file_size_mb"`
+	MaxFiles      int `yaml:"max_files"`
+}
+
+// AdminSettings protects the /api/v1/admin endpoints (pprof, expvar). The
+// admin API is disabled while Token is empty.
+type AdminSettings struct {
+	Token string `yaml:"token"`
+}
+
+// WorkersSettings protects the API remote indexing workers use. It is
+// disabled while Token is empty, and workers.enabled has no effect then.
+type WorkersSettings struct {
+	Token string `yaml:"token"`
+}
+
+// RateLimitSettings configures the per-client request limits. Clients are
+// identified by their remote IP, and the admin and worker APIs by their
+// token. The "expensive" limits apply on top of the general ones to
+// endpoints that hit the ML sidecar or spawn ffmpeg (search, process,
+// upload). A zero limit takes the default, a negative one turns it off.
+type RateLimitSettings struct {
+	Enabled                    bool `yaml:"enabled"`
+	RequestsPerMinute          int  `yaml:"requests_per_minute"`
+	Burst                      int  `yaml:"burst"`
+	ExpensiveRequestsPerMinute int  `yaml:"expensive_requests_per_minute"`
+	ExpensiveBurst             int  `yaml:"expensive_burst"`
+	KeyRequestsPerMinute       int  `yaml:"key_requests_per_minute"`
+	KeyBurst                   int  `yaml:"key_burst"`
+}
+
+// CORSSettings controls the cross-origin policy. The default allows any
+// origin without credentials, which suits local development; deployments
+// behind a real domain should list their frontend origin explicitly.
+type CORSSettings struct {
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAge           int      `yaml:"max_age"`
+}
+
+// StreamSettings configures live view transcoding (RTSP → HLS). Format
+// "llhls" writes fMP4 parts of PartSec and a Low-Latency HLS playlist,
+// "fmp4" plain fMP4 segments and "ts" the MPEG-TS segments for players
+// without fMP4 support. SegmentSec is the full segment length.
+type StreamSettings struct {
+	Format     string  `yaml:"format"`
+	SegmentSec float64 `yaml:"segment_sec"`
+	PartSec    float64 `yaml:"part_sec"`
+	// SigningKey signs stream URLs; empty means a random key per run, so
+	// URLs handed out before a restart stop working. TokenTTLSec is how
+	// long a playlist URL from stream/start stays valid.
+	SigningKey  string `yaml:"signing_key"`
+	TokenTTLSec int    `yaml:"token_ttl_sec"`
+	// HWAccel picks the H.264 encoder: "none" (libx264), "vaapi", "nvenc",
+	// "qsv", or "auto" to use the first that works. VAAPIDevice is the DRM
+	// render node for VAAPI.
+	HWAccel     string `yaml:"hwaccel"`
+	VAAPIDevice string `yaml:"vaapi_device"`
+	// MaxStreams caps the ffmpeg processes of live views across all
+	// workspaces; starting one more stops the least recently watched
+	// stream, or is refused while every stream is being watched.
+	MaxStreams int `yaml:"max_streams"`
+}
+
 type AppConfig struct {
 	App        AppSettings        `yaml:"app"`
 	Extraction ExtractionSettings `yaml:"extraction"`
@@ -49,10 +131,65 @@ type AppConfig struct {
 	Storage    StorageSettings    `yaml:"storage"`
 	CLIP       CLIPSettings       `yaml:"clip"`
 	Process    ProcessSettings    `yaml:"process"`
+	Upload     UploadSettings     `yaml:"upload"`
+	RateLimit  RateLimitSettings  `yaml:"ratelimit"`
+	CORS       CORSSettings       `yaml:"cors"`
+	Admin      AdminSettings      `yaml:"admin"`
+	Workers    WorkersSettings    `yaml:"workers"`
+	Stream     StreamSettings     `yaml:"stream"`
+
+	// Workspace is the workspace this config is scoped to ("" for the
+	// default one). It is set by ForWorkspace, never read from YAML.
+	Workspace string `yaml:"-"`
+
+	// EnvOverrides lists the keys set from INTELSK_* environment variables
+	// (see ApplyEnv).
+	EnvOverrides []string `yaml:"-"`
+}
+
+// DefaultWorkspace is the ID of the workspace that uses the top-level data
+// paths from app.yaml, so single-site installs keep their existing layout.
+const DefaultWorkspace = "default"
+
+// MaxUploadBytes returns the per-file upload limit in bytes.
+func (c *AppConfig) MaxUploadBytes() int64 {
+	return int64(c.Upload.MaxFileSizeMB) << 20
+}
+
+// ForWorkspace returns a copy of the config whose data paths point into the
+// workspace's own directory under data_dir/workspaces/{id}. Every other
+// setting is shared with the receiver.
+func (c *AppConfig) ForWorkspace(id string) *AppConfig {
+	if id == "" || id == DefaultWorkspace {
+		return c
+	}
+	ws := *c
+	root := c.WorkspaceDir(id)
+	ws.Workspace = id
+	ws.App.DataDir = root
+	ws.Extraction.StoragePath = filepath.Join(root, "frames")
+	ws.Storage.DBPath = filepath.Join(root, "intelsk.db")
+	ws.Process.HistoryPath = filepath.Join(root, "process_history.json")
+	return &ws
+}
+
+// WorkspaceDir returns the directory holding all data of a non-default workspace.
+func (c *AppConfig) WorkspaceDir(id string) string {
+	return filepath.Join(c.App.DataDir, "workspaces", id)
+}
+
+// APIBase returns the versioned URL prefix of this config's workspace API,
+// used when building frame and video URLs that are handed back to clients.
+func (c *AppConfig) APIBase() string {
+	if c.Workspace == "" {
+		return "/api/v1"
+	}
+	return "/api/v1/workspaces/" + c.Workspace
 }
 
 // LoadConfig reads and parses two YAML files (app config and extraction config)
-// and merges them into a single AppConfig struct.
+// and merges them into a single AppConfig struct. INTELSK_* environment
+// variables override keys from either file.
 func LoadConfig(appYaml, extractionYaml string) (*AppConfig, error) {
 	cfg := &AppConfig{}
 
@@ -64,6 +201,14 @@ func LoadConfig(appYaml, extractionYaml string) (*AppConfig, error) {
 		return nil, fmt.Errorf("loading %s: %w", extractionYaml, err)
 	}
 
+	// Environment variables win over the YAML files, so containers can be
+	// configured without templating them
+	overrides, err := ApplyEnv(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.EnvOverrides = overrides
+
 	if cfg.App.Host == "" {
 		cfg.App.Host = "0.0.0.0"
 	}
@@ -88,6 +233,12 @@ func LoadConfig(appYaml, extractionYaml string) (*AppConfig, error) {
 	if cfg.MLService.URL == "" {
 		cfg.MLService.URL = "http://localhost:8001"
 	}
+	if cfg.MLService.Backend == "" {
+		cfg.MLService.Backend = "sidecar"
+	}
+	if cfg.MLService.ONNXDir == "" {
+		cfg.MLService.ONNXDir = "data/models"
+	}
 	if cfg.Storage.DBPath == "" {
 		cfg.Storage.DBPath = "data/intelsk.db"
 	}
@@ -97,10 +248,83 @@ func LoadConfig(appYaml, extractionYaml string) (*AppConfig, error) {
 	if cfg.Process.HistoryPath == "" {
 		cfg.Process.HistoryPath = "data/process_history.json"
 	}
+	if cfg.Upload.MaxFileSizeMB == 0 {
+		cfg.Upload.MaxFileSizeMB = 10240
+	}
+	if cfg.Upload.MaxFiles == 0 {
+		cfg.Upload.MaxFiles = 50
+	}
+	if len(cfg.CORS.AllowedOrigins) == 0 {
+		cfg.CORS.AllowedOrigins = []string{"*"}
+	}
+	if len(cfg.CORS.AllowedMethods) == 0 {
+		cfg.CORS.AllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	}
+	if len(cfg.CORS.AllowedHeaders) == 0 {
+		cfg.CORS.AllowedHeaders = []string{"Accept", "Authorization", "Content-Type", "Upload-Offset", "X-Settings-Passphrase"}
+	}
+	if cfg.CORS.MaxAge == 0 {
+		cfg.CORS.MaxAge = 300
+	}
+	if cfg.RateLimit.RequestsPerMinute == 0 {
+		cfg.RateLimit.RequestsPerMinute = 600
+	}
+	if cfg.RateLimit.Burst == 0 {
+		cfg.RateLimit.Burst = 100
+	}
+	if cfg.RateLimit.ExpensiveRequestsPerMinute == 0 {
+		cfg.RateLimit.ExpensiveRequestsPerMinute = 30
+	}
+	if cfg.RateLimit.ExpensiveBurst == 0 {
+		cfg.RateLimit.ExpensiveBurst = 5
+	}
+	if cfg.RateLimit.KeyRequestsPerMinute == 0 {
+		cfg.RateLimit.KeyRequestsPerMinute = 6000
+	}
+	if cfg.RateLimit.KeyBurst == 0 {
+		cfg.RateLimit.KeyBurst = 1000
+	}
+	if cfg.Stream.Format == "" {
+		cfg.Stream.Format = "llhls"
+	}
+	if cfg.Stream.SegmentSec == 0 {
+		cfg.Stream.SegmentSec = 2
+	}
+	if cfg.Stream.PartSec == 0 {
+		cfg.Stream.PartSec = 0.5
+	}
+	if cfg.Stream.TokenTTLSec == 0 {
+		cfg.Stream.TokenTTLSec = 3600
+	}
+	if cfg.Stream.HWAccel == "" {
+		cfg.Stream.HWAccel = "auto"
+	}
+	if cfg.Stream.VAAPIDevice == "" {
+		cfg.Stream.VAAPIDevice = "/dev/dri/renderD128"
+	}
+	if cfg.Stream.MaxStreams == 0 {
+		cfg.Stream.MaxStreams = 8
+	}
 
 	return cfg, nil
 }
 
+// CheckKnownFields reports keys in a config file that don't correspond to
+// any setting, which LoadConfig silently ignores (usually a typo).
+func CheckKnownFields(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&AppConfig{}); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
 func loadYAML(path string, out interface{}) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts every environment variable that overrides a config key.
+// The rest of the name is the YAML path upper-cased with dots turned into
+// underscores: app.port is INTELSK_APP_PORT, storage.db_path is
+// INTELSK_STORAGE_DB_PATH. Lists are comma-separated.
+const EnvPrefix = "INTELSK_"
+
+// EnvVar returns the environment variable that overrides a config key such
+// as "mlservice.url".
+func EnvVar(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// envFields maps each overridable key ("app.port") to its field in cfg.
+func envFields(cfg *AppConfig) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := yamlName(root.Type().Field(i))
+		if section == "" || root.Field(i).Kind() != reflect.Struct {
+			continue
+		}
+		sv := root.Field(i)
+		for j := 0; j < sv.NumField(); j++ {
+			if name := yamlName(sv.Type().Field(j)); name != "" {
+				fields[section+"."+name] = sv.Field(j)
+			}
+		}
+	}
+	return fields
+}
+
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// ApplyEnv overrides config keys from INTELSK_* environment variables and
+// returns the keys it set, sorted. Variables that match no key are ignored
+// here; see UnknownEnv.
+func ApplyEnv(cfg *AppConfig) ([]string, error) {
+	var applied []string
+	for key, field := range envFields(cfg) {
+		raw, ok := os.LookupEnv(EnvVar(key))
+		if !ok {
+			continue
+		}
+		if err := setField(field, raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvVar(key), err)
+		}
+		applied = append(applied, key)
+	}
+	sort.Strings(applied)
+	return applied, nil
+}
+
+// UnknownEnv returns the INTELSK_* environment variables that don't
+// correspond to any config key (usually a typo).
+func UnknownEnv() []string {
+	known := make(map[string]bool)
+	for key := range envFields(&AppConfig{}) {
+		known[EnvVar(key)] = true
+	}
+	var unknown []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, EnvPrefix) && !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func setField(field reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				items = append(items, s)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Kind())
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/services"
+	"gopkg.in/yaml.v3"
+)
+
+func runConfig(args []string) {
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	skipPort := fs.Bool("skip-port", false, "don't check that app.port is free (e.g. while the server is running)")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+
+	sub := ""
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	parseFlags(fs, args)
+	if sub != "validate" {
+		fmt.Fprintln(os.Stderr, "Usage: backend config validate [flags]")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+
+	root := resolveRoot()
+	var results []doctorResult
+	for _, name := range []string{"app.yaml", "extraction.yaml"} {
+		path := filepath.Join(root, "config", name)
+		if err := config.CheckKnownFields(path); err != nil {
+			status := "WARN"
+			if errors.Is(err, os.ErrNotExist) {
+				status = "FAIL"
+			}
+			results = append(results, doctorResult{Name: name, Status: status, Detail: err.Error(),
+				Hint: "unknown keys are ignored; check the spelling against doc/DESIGN.md"})
+		}
+	}
+
+	cfg, err := readAppConfig()
+	if err != nil {
+		results = append(results, doctorResult{Name: "config", Status: "FAIL", Detail: err.Error(),
+			Hint: "fix the YAML in config/app.yaml / config/extraction.yaml, or pass -root"})
+		reportValidation(results, nil)
+	}
+
+	results = append(results, validateEnv(cfg))
+	results = append(results, validateListen(cfg, *skipPort))
+	results = append(results, validateConfigValues(cfg)...)
+	results = append(results,
+		validatePath("app.data_dir", cfg.App.DataDir),
+		validatePath("extraction.storage_path", cfg.Extraction.StoragePath),
+		validatePath("storage.db_path", filepath.Dir(cfg.Storage.DBPath)),
+		validatePath("process.history_path", filepath.Dir(cfg.Process.HistoryPath)),
+	)
+	results = append(results, validateStoredSettings(cfg.Storage.DBPath)...)
+
+	effective := *cfg
+	if effective.Admin.Token != "" {
+		effective.Admin.Token = "********"
+	}
+	if effective.Workers.Token != "" {
+		effective.Workers.Token = "********"
+	}
+	if effective.Stream.SigningKey != "" {
+		effective.Stream.SigningKey = "********"
+	}
+	out, err := yaml.Marshal(&effective)
+	if err != nil {
+		results = append(results, doctorResult{Name: "config", Status: "FAIL", Detail: err.Error()})
+	}
+
+	reportValidation(results, out)
+}
+
+// reportValidation prints the effective config (YAML) and the check results
+// and exits non-zero if any check failed.
+func reportValidation(results []doctorResult, effective []byte) {
+	if jsonOutput {
+		// Round-trip through YAML so the keys match the config files
+		var tree map[string]any
+		yaml.Unmarshal(effective, &tree)
+		failed := slices.ContainsFunc(results, func(r doctorResult) bool { return r.Status == "FAIL" })
+		printJSON(map[string]any{"valid": !failed, "checks": results, "config": tree})
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if effective != nil {
+		fmt.Println("# Effective configuration (environment and defaults applied, paths resolved)")
+		fmt.Print(string(effective))
+		fmt.Println()
+	}
+	if printDoctorReport(results) {
+		os.Exit(1)
+	}
+}
+
+// validateEnv reports which keys INTELSK_* variables override and warns
+// about variables that match no key.
+func validateEnv(cfg *config.AppConfig) doctorResult {
+	if unknown := config.UnknownEnv(); len(unknown) > 0 {
+		return doctorResult{Name: "environment", Status: "WARN",
+			Detail: "unknown variables: " + strings.Join(unknown, ", "),
+			Hint:   "they are ignored; names are INTELSK_ plus the key, e.g. INTELSK_APP_PORT for app.port"}
+	}
+	if len(cfg.EnvOverrides) == 0 {
+		return doctorResult{Name: "environment", Status: "PASS", Detail: "no INTELSK_* overrides"}
+	}
+	return doctorResult{Name: "environment", Status: "PASS",
+		Detail: "overridden: " + strings.Join(cfg.EnvOverrides, ", ")}
+}
+
+// validateListen checks app.host/app.port and that nothing else is already
+// listening there.
+func validateListen(cfg *config.AppConfig, skipPort bool) doctorResult {
+	if cfg.App.Port < 1 || cfg.App.Port > 65535 {
+		return doctorResult{Name: "app.port", Status: "FAIL",
+			Detail: fmt.Sprintf("%d is out of range", cfg.App.Port),
+			Hint:   "set app.port to a value between 1 and 65535"}
+	}
+	addr := net.JoinHostPort(cfg.App.Host, strconv.Itoa(cfg.App.Port))
+	if skipPort {
+		return doctorResult{Name: "app.port", Status: "PASS", Detail: addr + " (availability not checked)"}
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return doctorResult{Name: "app.port", Status: "FAIL", Detail: fmt.Sprintf("cannot listen on %s: %v", addr, err),
+			Hint: "another process (maybe a running 'backend serve') holds the port; pass -skip-port if that is expected"}
+	}
+	ln.Close()
+	return doctorResult{Name: "app.port", Status: "PASS", Detail: addr + " is available"}
+}
+
+// validateConfigValues checks values that LoadConfig accepts but the server
+// would reject or misbehave on. Extraction and CLIP values seed the runtime
+// settings, so they must satisfy the same ranges as PUT /api/settings.
+func validateConfigValues(cfg *config.AppConfig) []doctorResult {
+	var results []doctorResult
+	fail := func(name, detail, hint string) {
+		results = append(results, doctorResult{Name: name, Status: "FAIL", Detail: detail, Hint: hint})
+	}
+	warn := func(name, detail, hint string) {
+		results = append(results, doctorResult{Name: name, Status: "WARN", Detail: detail, Hint: hint})
+	}
+
+	if u, err := url.Parse(cfg.MLService.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		fail("mlservice.url", fmt.Sprintf("%q is not a valid URL", cfg.MLService.URL),
+			"set mlservice.url to e.g. http://localhost:8001")
+	}
+
+	seeded := []struct {
+		key   string
+		value int
+	}{
+		{"extraction.time_interval_sec", cfg.Extraction.TimeIntervalSec},
+		{"extraction.output_quality", cfg.Extraction.OutputQuality},
+		{"extraction.dedup_phash_threshold", cfg.Extraction.DedupPHashThreshold},
+		{"clip.batch_size", cfg.CLIP.BatchSize},
+	}
+	for _, s := range seeded {
+		if err := services.ValidateSetting(s.key, s.value); err != nil {
+			fail(s.key, fmt.Sprintf("%d: %v", s.value, err), "")
+		}
+	}
+	if cfg.Extraction.Method != "" && cfg.Extraction.Method != "time" {
+		warn("extraction.method", fmt.Sprintf("%q is not implemented", cfg.Extraction.Method),
+			"only time-interval extraction exists; the value is ignored")
+	}
+	if cfg.Extraction.OutputFormat != "" && cfg.Extraction.OutputFormat != "jpg" {
+		warn("extraction.output_format", fmt.Sprintf("%q is not implemented", cfg.Extraction.OutputFormat),
+			"frames are always written as JPEG; the value is ignored")
+	}
+
+	if cfg.Upload.MaxFileSizeMB < 0 || cfg.Upload.MaxFiles < 0 {
+		fail("upload", fmt.Sprintf("max_file_size_mb=%d max_files=%d must be positive",
+			cfg.Upload.MaxFileSizeMB, cfg.Upload.MaxFiles), "")
+	}
+	if cfg.RateLimit.Enabled && (cfg.RateLimit.Burst < 0 || cfg.RateLimit.ExpensiveBurst < 0 || cfg.RateLimit.KeyBurst < 0) {
+		fail("ratelimit", "bursts must not be negative",
+			"set them to 0 for the defaults; a negative requests_per_minute turns a limit off")
+	}
+	if cfg.CORS.MaxAge < 0 {
+		fail("cors.max_age", fmt.Sprintf("%d must not be negative", cfg.CORS.MaxAge), "")
+	}
+	if cfg.CORS.AllowCredentials && slices.Contains(cfg.CORS.AllowedOrigins, "*") {
+		warn("cors", "allow_credentials is ignored with a wildcard origin",
+			"list explicit origins in cors.allowed_origins")
+	}
+	if f := cfg.Stream.Format; f != "ts" && f != "fmp4" && f != "llhls" {
+		fail("stream.format", fmt.Sprintf("%q is not a stream format", f), "use llhls, fmp4 or ts")
+	}
+	if cfg.Stream.SegmentSec <= 0 || cfg.Stream.PartSec <= 0 || cfg.Stream.PartSec > cfg.Stream.SegmentSec {
+		fail("stream", fmt.Sprintf("segment_sec=%g part_sec=%g", cfg.Stream.SegmentSec, cfg.Stream.PartSec),
+			"both must be positive and part_sec must not exceed segment_sec")
+	}
+	if err := services.ValidateSetting("stream.segment_sec", cfg.Stream.SegmentSec); err != nil {
+		fail("stream.segment_sec", fmt.Sprintf("%g: %v", cfg.Stream.SegmentSec, err), "")
+	}
+	switch cfg.Stream.HWAccel {
+	case services.HWAccelAuto, services.HWAccelNone, services.HWAccelVAAPI, services.HWAccelNVENC, services.HWAccelQSV:
+	default:
+		fail("stream.hwaccel", fmt.Sprintf("%q is not a hardware accelerator", cfg.Stream.HWAccel),
+			"use auto, none, vaapi, nvenc or qsv")
+	}
+	if cfg.Stream.MaxStreams < 1 {
+		fail("stream.max_streams", fmt.Sprintf("%d must be at least 1", cfg.Stream.MaxStreams), "")
+	}
+	if cfg.Stream.TokenTTLSec < 0 {
+		fail("stream.token_ttl_sec", fmt.Sprintf("%d must not be negative", cfg.Stream.TokenTTLSec), "")
+	}
+	if k := cfg.Stream.SigningKey; k != "" && len(k) < 16 {
+		warn("stream.signing_key", fmt.Sprintf("only %d characters long", len(k)),
+			"use a long random key, e.g. 'openssl rand -hex 32'")
+	}
+	if t := cfg.Admin.Token; t != "" && len(t) < 16 {
+		warn("admin.token", fmt.Sprintf("only %d characters long", len(t)),
+			"use a long random token, e.g. 'openssl rand -hex 32'")
+	}
+	if t := cfg.Workers.Token; t != "" && len(t) < 16 {
+		warn("workers.token", fmt.Sprintf("only %d characters long", len(t)),
+			"use a long random token, e.g. 'openssl rand -hex 32'")
+	}
+
+	if len(results) == 0 {
+		results = append(results, doctorResult{Name: "values", Status: "PASS", Detail: "all values within range"})
+	}
+	return results
+}
+
+// validatePath checks that dir exists and is writable, or, if it doesn't
+// exist yet, that its nearest existing parent is (serve creates it).
+// Nothing is created.
+func validatePath(name, dir string) doctorResult {
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return doctorResult{Name: name, Status: "FAIL", Detail: existing + " is not a directory"}
+			}
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return doctorResult{Name: name, Status: "FAIL", Detail: fmt.Sprintf("no existing parent of %s", dir)}
+		}
+		existing = parent
+	}
+
+	probe, err := os.CreateTemp(existing, ".config-validate-*")
+	if err != nil {
+		return doctorResult{Name: name, Status: "FAIL", Detail: fmt.Sprintf("%s is not writable: %v", existing, err),
+			Hint: "fix permissions, e.g. 'chown -R $(whoami) " + existing + "'"}
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	if existing != dir {
+		return doctorResult{Name: name, Status: "PASS", Detail: dir + " (will be created)"}
+	}
+	return doctorResult{Name: name, Status: "PASS", Detail: dir}
+}
+
+// validateStoredSettings checks the runtime settings saved in the database
+// (read-only), e.g. values written by an older version with other ranges.
+func validateStoredSettings(dbPath string) []doctorResult {
+	storage, err := services.OpenStorageReadOnly(dbPath)
+	if err != nil {
+		return nil // no database yet; the YAML values above become the settings
+	}
+	defer storage.Close()
+
+	rows, err := storage.DB().Query("SELECT key, value FROM settings ORDER BY key")
+	if err != nil {
+		return []doctorResult{{Name: "settings", Status: "FAIL", Detail: err.Error()}}
+	}
+	defer rows.Close()
+
+	var results []doctorResult
+	checked := 0
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			continue
+		}
+		checked++
+		if err := services.ValidateSetting(key, value); err != nil {
+			results = append(results, doctorResult{Name: "settings", Status: "WARN",
+				Detail: fmt.Sprintf("%s=%q: %v", key, value, err),
+				Hint:   "fix it on the Settings page or with PUT /api/settings"})
+		}
+	}
+	if len(results) == 0 {
+		results = append(results, doctorResult{Name: "settings", Status: "PASS",
+			Detail: fmt.Sprintf("%d stored settings within range", checked)})
+	}
+	return results
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/intelsk/backend/services"
+)
+
+func runDedup(args []string) {
+	fs := flag.NewFlagSet("dedup", flag.ExitOnError)
+	camera := fs.String("camera", "", "camera ID, comma-separated for several")
+	allCameras := fs.Bool("all-cameras", false, "de-duplicate every camera")
+	date := fs.String("date", "", "single date in YYYY-MM-DD format (same as -start X -end X)")
+	start := fs.String("start", "", "first date of the range (YYYY-MM-DD)")
+	end := fs.String("end", "", "last date of the range (default: -start)")
+	threshold := fs.Int("threshold", -1, "pHash distance below which frames are duplicates (default: extraction.dedup_phash_threshold setting)")
+	dryRun := fs.Bool("dry-run", false, "only report duplicates, delete nothing")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+	parseFlags(fs, args)
+
+	if *date != "" {
+		if *start != "" || *end != "" {
+			log.Fatalf("-date cannot be combined with -start/-end")
+		}
+		*start, *end = *date, *date
+	}
+	if (*camera == "") == !*allCameras || *start == "" {
+		fmt.Fprintln(os.Stderr, "error: one of -camera or -all-cameras, and -date or -start, are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *end == "" {
+		*end = *start
+	}
+	dates, err := services.DateRange(*start, *end)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(dates) == 0 {
+		log.Fatalf("-end is before -start")
+	}
+
+	cfg := loadAppConfig()
+
+	storage, err := services.NewStorage(cfg.Storage.DBPath)
+	if err != nil {
+		log.Fatalf("opening storage: %v", err)
+	}
+	defer storage.Close()
+	settings := services.NewSettingsService(storage.DB(), cfg)
+
+	if *threshold < 0 {
+		*threshold = settings.GetInt("extraction.dedup_phash_threshold")
+	} else if err := services.ValidateSetting("extraction.dedup_phash_threshold", *threshold); err != nil {
+		log.Fatalf("-threshold: %v", err)
+	}
+
+	var cameraIDs []string
+	if *allCameras {
+		cameras, err := services.NewCameraService(storage.DB(), cfg).List()
+		if err != nil {
+			log.Fatalf("listing cameras: %v", err)
+		}
+		for _, cam := range cameras {
+			cameraIDs = append(cameraIDs, cam.ID)
+		}
+		if len(cameraIDs) == 0 {
+			log.Fatalf("no cameras configured")
+		}
+	} else {
+		for _, id := range strings.Split(*camera, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				cameraIDs = append(cameraIDs, id)
+			}
+		}
+	}
+
+	var results []*services.DedupResult
+	var frames, dups int
+	for _, id := range cameraIDs {
+		for _, d := range dates {
+			// Dates that were never extracted are simply skipped
+			framesDir, err := services.ResolveFramesDir(cfg.Extraction.StoragePath, id, d)
+			if err != nil {
+				continue
+			}
+			res, err := services.DedupFrames(storage, framesDir, *threshold, *dryRun)
+			if err != nil {
+				log.Fatalf("%s/%s: %v", id, d, err)
+			}
+			results = append(results, res)
+			frames += res.Frames
+			dups += res.Duplicates
+			if !jsonOutput {
+				fmt.Printf("%s %s: %d/%d duplicate frames", id, d, res.Duplicates, res.Frames)
+				if !*dryRun && res.Duplicates > 0 {
+					fmt.Printf(" removed (%d CLIP, %d face embeddings)", res.ClipEmbeddings, res.FaceEmbeddings)
+				}
+				fmt.Println()
+			}
+		}
+	}
+
+	if jsonOutput {
+		printJSON(map[string]any{
+			"threshold":  *threshold,
+			"dry_run":    *dryRun,
+			"frames":     frames,
+			"duplicates": dups,
+			"results":    append([]*services.DedupResult{}, results...),
+		})
+		return
+	}
+	if len(results) == 0 {
+		fmt.Println("No extracted frames found for the given cameras and dates.")
+		return
+	}
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d of %d frames (threshold %d)\n", verb, dups, frames, *threshold)
+}
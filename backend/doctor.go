@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/services"
+)
+
+// doctorResult is one line of the doctor report. Hint tells the user what to
+// do about a warning or failure.
+type doctorResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // "PASS", "WARN" or "FAIL"
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+const (
+	doctorMinFreeBytes  = 1 << 30  // below this the pipeline will fail soon
+	doctorWarnFreeBytes = 10 << 30 // a day of footage from a few cameras
+)
+
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	parseFlags(fs, args)
+
+	var results []doctorResult
+	results = append(results, doctorTool("ffmpeg"), doctorTool("ffprobe"))
+
+	cfg, err := readAppConfig()
+	if err != nil {
+		results = append(results, doctorResult{
+			Name: "config", Status: "FAIL", Detail: err.Error(),
+			Hint: "fix the YAML in config/app.yaml / config/extraction.yaml, or pass -root",
+		})
+		printDoctorReport(results)
+		os.Exit(1)
+	}
+	results = append(results, doctorConfig(cfg), doctorHWAccel(cfg))
+	results = append(results, doctorDataDir(cfg.App.DataDir)...)
+	results = append(results, doctorDatabase(cfg.Storage.DBPath))
+	results = append(results, doctorSidecar(cfg)...)
+
+	if printDoctorReport(results) {
+		os.Exit(1)
+	}
+}
+
+// printDoctorReport prints the results and reports whether any check failed.
+func printDoctorReport(results []doctorResult) bool {
+	failed := false
+	for _, r := range results {
+		fmt.Printf("[%s] %-12s %s\n", r.Status, r.Name, r.Detail)
+		if r.Hint != "" && r.Status != "PASS" {
+			fmt.Printf("       %-12s -> %s\n", "", r.Hint)
+		}
+		if r.Status == "FAIL" {
+			failed = true
+		}
+	}
+	if failed {
+		fmt.Println("\nSome checks failed.")
+	} else {
+		fmt.Println("\nAll checks passed.")
+	}
+	return failed
+}
+
+func doctorTool(name string) doctorResult {
+	version, err := services.ToolVersion(name)
+	if err != nil {
+		return doctorResult{Name: name, Status: "FAIL", Detail: err.Error(),
+			Hint: "install ffmpeg (e.g. 'brew install ffmpeg' or 'apt install ffmpeg') and make sure it is on PATH"}
+	}
+	return doctorResult{Name: name, Status: "PASS", Detail: version}
+}
+
+func doctorConfig(cfg *config.AppConfig) doctorResult {
+	u, err := url.Parse(cfg.MLService.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return doctorResult{Name: "config", Status: "FAIL",
+			Detail: fmt.Sprintf("mlservice.url %q is not a valid URL", cfg.MLService.URL),
+			Hint:   "set mlservice.url to e.g. http://localhost:8001"}
+	}
+	if cfg.App.Port < 1 || cfg.App.Port > 65535 {
+		return doctorResult{Name: "config", Status: "FAIL",
+			Detail: fmt.Sprintf("app.port %d is out of range", cfg.App.Port),
+			Hint:   "set app.port to a value between 1 and 65535"}
+	}
+	if cfg.Extraction.TimeIntervalSec < 1 {
+		return doctorResult{Name: "config", Status: "FAIL",
+			Detail: fmt.Sprintf("extraction.time_interval_sec %d must be positive", cfg.Extraction.TimeIntervalSec),
+			Hint:   "set extraction.time_interval_sec in config/extraction.yaml"}
+	}
+	return doctorResult{Name: "config", Status: "PASS", Detail: "config/app.yaml and config/extraction.yaml parsed"}
+}
+
+// doctorHWAccel reports which encoder live views use, trying the configured
+// accelerator (or each one, for auto) on a short test clip.
+func doctorHWAccel(cfg *config.AppConfig) doctorResult {
+	ctx := context.Background()
+	switch accel := cfg.Stream.HWAccel; accel {
+	case services.HWAccelNone:
+		return doctorResult{Name: "hwaccel", Status: "PASS", Detail: "disabled; live views encode with libx264"}
+	case services.HWAccelAuto:
+		found := services.DetectHWAccel(ctx, cfg.Stream.VAAPIDevice)
+		if found == services.HWAccelNone {
+			return doctorResult{Name: "hwaccel", Status: "PASS", Detail: "no hardware encoder works; live views encode with libx264"}
+		}
+		return doctorResult{Name: "hwaccel", Status: "PASS", Detail: "auto: using " + found}
+	default:
+		if err := services.ProbeHWAccel(ctx, accel, cfg.Stream.VAAPIDevice); err != nil {
+			return doctorResult{Name: "hwaccel", Status: "FAIL", Detail: fmt.Sprintf("%s: %v", accel, err),
+				Hint: "check the GPU driver and access to stream.vaapi_device, or set stream.hwaccel to auto"}
+		}
+		return doctorResult{Name: "hwaccel", Status: "PASS", Detail: accel + " encoder works"}
+	}
+}
+
+func doctorDataDir(dir string) []doctorResult {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return []doctorResult{{Name: "data dir", Status: "WARN", Detail: dir + " does not exist yet",
+			Hint: "create it, or point app.data_dir at a writable location"}}
+	}
+	if err != nil {
+		return []doctorResult{{Name: "data dir", Status: "FAIL", Detail: err.Error(),
+			Hint: "point app.data_dir at a writable location"}}
+	}
+	if !fi.IsDir() {
+		return []doctorResult{{Name: "data dir", Status: "FAIL", Detail: dir + " is not a directory",
+			Hint: "point app.data_dir at a directory"}}
+	}
+	probe, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return []doctorResult{{Name: "data dir", Status: "FAIL", Detail: fmt.Sprintf("%s is not writable: %v", dir, err),
+			Hint: "fix permissions, e.g. 'chown -R $(whoami) " + dir + "'"}}
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	results := []doctorResult{{Name: "data dir", Status: "PASS", Detail: dir + " is writable"}}
+
+	usage, err := services.GetDiskUsage(dir)
+	if err != nil {
+		return append(results, doctorResult{Name: "disk", Status: "WARN", Detail: err.Error()})
+	}
+	detail := fmt.Sprintf("%.1f GB free of %.1f GB", gib(usage.FreeBytes), gib(usage.TotalBytes))
+	switch {
+	case usage.FreeBytes < doctorMinFreeBytes:
+		results = append(results, doctorResult{Name: "disk", Status: "FAIL", Detail: detail,
+			Hint: "free up space (delete old footage per camera); uploads and extraction will fail"})
+	case usage.FreeBytes < doctorWarnFreeBytes:
+		results = append(results, doctorResult{Name: "disk", Status: "WARN", Detail: detail,
+			Hint: "less than 10 GB free; consider cleaning old footage"})
+	default:
+		results = append(results, doctorResult{Name: "disk", Status: "PASS", Detail: detail})
+	}
+	return results
+}
+
+func doctorDatabase(dbPath string) doctorResult {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return doctorResult{Name: "database", Status: "WARN", Detail: dbPath + " does not exist yet",
+			Hint: "it is created on the first 'backend serve' or 'backend index'"}
+	}
+	storage, err := services.OpenStorageReadOnly(dbPath)
+	if err != nil {
+		return doctorResult{Name: "database", Status: "FAIL", Detail: err.Error(),
+			Hint: "check file permissions of " + filepath.Dir(dbPath)}
+	}
+	defer storage.Close()
+
+	start := time.Now()
+	if err := storage.IntegrityCheck(); err != nil {
+		return doctorResult{Name: "database", Status: "FAIL", Detail: err.Error(),
+			Hint: "restore from a backup, or recover with 'sqlite3 " + dbPath + " .recover'"}
+	}
+	return doctorResult{Name: "database", Status: "PASS",
+		Detail: fmt.Sprintf("%s integrity ok (%s)", dbPath, time.Since(start).Round(time.Millisecond))}
+}
+
+func doctorSidecar(cfg *config.AppConfig) []doctorResult {
+	mlClient := newMLClient(cfg)
+	if err := mlClient.HealthCheck(); err != nil {
+		return []doctorResult{{Name: "ml sidecar", Status: "FAIL", Detail: err.Error(),
+			Hint: "start it with 'make run' (or 'cd mlservice && uvicorn main:app --port 8001') and check ml.url in Settings, or mlservice.url"}}
+	}
+	results := []doctorResult{{Name: "ml sidecar", Status: "PASS", Detail: mlClient.BaseURL() + " is reachable"}}
+
+	info, err := mlClient.GetModelInfo()
+	if err != nil {
+		return append(results, doctorResult{Name: "clip model", Status: "WARN", Detail: err.Error(),
+			Hint: "the sidecar may still be loading its model; retry in a minute"})
+	}
+	detail := fmt.Sprintf("%s (%s, dim %d)", info.Preset, info.Model, info.EmbeddingDim)
+	if saved := savedClipModel(cfg.Storage.DBPath); saved != "" && saved != info.Preset {
+		return append(results, doctorResult{Name: "clip model", Status: "WARN",
+			Detail: fmt.Sprintf("sidecar runs %s but settings expect %q", detail, saved),
+			Hint:   "restart 'backend serve' to resync, or switch the model in Settings"})
+	}
+	return append(results, doctorResult{Name: "clip model", Status: "PASS", Detail: detail})
+}
+
+// savedClipModel reads the clip.model setting without opening the DB for
+// writing; it returns "" when unknown.
+func savedClipModel(dbPath string) string {
+	storage, err := services.OpenStorageReadOnly(dbPath)
+	if err != nil {
+		return ""
+	}
+	defer storage.Close()
+	var model string
+	storage.DB().QueryRow("SELECT value FROM settings WHERE key = 'clip.model'").Scan(&model)
+	return model
+}
+
+func gib(b uint64) float64 {
+	return float64(b) / (1 << 30)
+}
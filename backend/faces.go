@@ -0,0 +1,292 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+const facesUsage = `Usage:
+  backend faces enroll -name NAME PHOTO...   Enroll a person from photos with exactly one face
+  backend faces index -camera ID -date D     Detect faces in extracted frames (or -start/-end)
+  backend faces persons                      List enrolled persons
+  backend faces search -name NAME [flags]    Find frames where a person appears
+  backend faces erase -name NAME [flags]     Erase a person's faces (and frames) and unenroll them`
+
+func runFaces(args []string) {
+	fs := flag.NewFlagSet("faces", flag.ExitOnError)
+	name := fs.String("name", "", "person name (enroll, search, erase)")
+	camera := fs.String("camera", "", "camera ID, comma-separated for several (index, search)")
+	date := fs.String("date", "", "single date in YYYY-MM-DD format (index)")
+	start := fs.String("start", "", "first date (index) or earliest time (search)")
+	end := fs.String("end", "", "last date (index) or latest time (search)")
+	maxDistance := fs.Float64("max-distance", services.DefaultFaceDistance, "L2 distance below which a face matches (search, erase)")
+	limit := fs.Int("limit", 20, "max results (search)")
+	embeddings := fs.String("embeddings", services.EraseDelete, "delete or anonymize the person's face embeddings (erase)")
+	frames := fs.String("frames", services.EraseKeep, "keep, blur or delete the frames they appear in (erase)")
+	dryRun := fs.Bool("dry-run", false, "only report what would be erased (erase)")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, facesUsage)
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	sub := ""
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	parseFlags(fs, args)
+
+	var cameraIDs []string
+	for _, id := range strings.Split(*camera, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			cameraIDs = append(cameraIDs, id)
+		}
+	}
+
+	switch sub {
+	case "enroll":
+		if *name == "" || fs.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "error: -name and at least one photo are required")
+			fs.Usage()
+			os.Exit(1)
+		}
+		facesEnroll(*name, fs.Args())
+	case "index":
+		if *date != "" {
+			if *start != "" || *end != "" {
+				log.Fatalf("-date cannot be combined with -start/-end")
+			}
+			*start, *end = *date, *date
+		}
+		if len(cameraIDs) == 0 || *start == "" {
+			fmt.Fprintln(os.Stderr, "error: -camera and -date (or -start) are required")
+			fs.Usage()
+			os.Exit(1)
+		}
+		if *end == "" {
+			*end = *start
+		}
+		dates, err := services.DateRange(*start, *end)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if len(dates) == 0 {
+			log.Fatalf("-end is before -start")
+		}
+		facesIndex(cameraIDs, dates)
+	case "persons":
+		facesPersons()
+	case "search":
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "error: -name is required")
+			fs.Usage()
+			os.Exit(1)
+		}
+		startTime, endTime, err := searchTimeRange(loadAppConfig().Storage.DBPath, *start, *end)
+		if err != nil {
+			log.Fatalf("invalid -start/-end: %v", err)
+		}
+		facesSearch(*name, services.FaceSearchOptions{
+			CameraIDs:   cameraIDs,
+			StartTime:   startTime,
+			EndTime:     endTime,
+			MaxDistance: *maxDistance,
+			Limit:       *limit,
+		})
+	case "erase":
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "error: -name is required")
+			fs.Usage()
+			os.Exit(1)
+		}
+		facesErase(*name, models.ErasureRequest{
+			Embeddings:  *embeddings,
+			Frames:      *frames,
+			MaxDistance: *maxDistance,
+			DryRun:      *dryRun,
+		})
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// openFaceService opens the storage and ML client a faces subcommand needs.
+// The caller closes the returned storage.
+func openFaceService(needSidecar bool) (*config.AppConfig, *services.FaceService, *services.Storage) {
+	cfg := loadAppConfig()
+	storage, err := services.NewStorage(cfg.Storage.DBPath)
+	if err != nil {
+		log.Fatalf("opening storage: %v", err)
+	}
+	mlClient := newMLClient(cfg)
+	if needSidecar {
+		statusf("Waiting for ML sidecar at %s...\n", mlClient.BaseURL())
+		if err := mlClient.WaitForReady(120 * time.Second); err != nil {
+			log.Fatalf("ML sidecar not ready: %v", err)
+		}
+	}
+	return cfg, services.NewFaceService(cfg, storage, mlClient), storage
+}
+
+func facesEnroll(name string, photos []string) {
+	_, faces, storage := openFaceService(true)
+	defer storage.Close()
+
+	results, err := faces.Enroll(name, photos)
+	if err != nil {
+		log.Fatalf("enrolling %s: %v", name, err)
+	}
+	added := 0
+	for _, r := range results {
+		if r.Added {
+			added++
+		}
+	}
+	if jsonOutput {
+		printJSON(map[string]any{"name": name, "added": added, "photos": results})
+	} else {
+		for _, r := range results {
+			if r.Added {
+				fmt.Printf("  added    %s\n", r.Photo)
+			} else {
+				fmt.Printf("  skipped  %s: %s\n", r.Photo, r.Error)
+			}
+		}
+		fmt.Printf("Enrolled %d of %d photos for %q (%s)\n", added, len(results), name, faces.RegistryPath())
+	}
+	if added == 0 {
+		os.Exit(1)
+	}
+}
+
+func facesIndex(cameraIDs, dates []string) {
+	cfg, faces, storage := openFaceService(true)
+	defer storage.Close()
+
+	events := make(chan services.ProgressEvent, 64)
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		for ev := range events {
+			printProgress(ev)
+		}
+	}()
+
+	var failed []string
+	for _, id := range cameraIDs {
+		for _, d := range dates {
+			framesDir, err := services.ResolveFramesDir(cfg.Extraction.StoragePath, id, d)
+			if err != nil {
+				// Nothing extracted for this date yet
+				continue
+			}
+			if err := faces.IndexFaces(framesDir, events); err != nil {
+				events <- services.ProgressEvent{Stage: "error", CameraID: id, Message: err.Error()}
+				failed = append(failed, id+"/"+d)
+			}
+		}
+	}
+	close(events)
+	<-printed
+
+	if jsonOutput {
+		printJSONLine(processSummary{Stage: "done", CameraIDs: cameraIDs, Dates: dates, Failed: append([]string{}, failed...)})
+	}
+	if len(failed) > 0 {
+		log.Fatalf("face detection failed for %s", strings.Join(failed, ", "))
+	}
+}
+
+func facesPersons() {
+	_, faces, storage := openFaceService(false)
+	defer storage.Close()
+
+	persons, err := faces.Persons()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if jsonOutput {
+		printJSON(persons)
+		return
+	}
+	if len(persons) == 0 {
+		fmt.Println("No persons enrolled. Use 'backend faces enroll -name NAME PHOTO...'.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tENCODINGS\tFIRST SOURCE\t")
+	for _, p := range persons {
+		first := "-"
+		if len(p.Sources) > 0 {
+			first = p.Sources[0]
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t\n", p.Name, p.Encodings, first)
+	}
+	tw.Flush()
+}
+
+func facesSearch(name string, opts services.FaceSearchOptions) {
+	_, faces, storage := openFaceService(false)
+	defer storage.Close()
+
+	results, err := faces.SearchPerson(name, opts)
+	if err != nil {
+		log.Fatalf("search failed: %v", err)
+	}
+	if jsonOutput {
+		rows := make([]searchRow, len(results))
+		for i, r := range results {
+			rows[i] = searchRow{Rank: i + 1, SearchResult: r}
+		}
+		printJSON(rows)
+		return
+	}
+	fmt.Printf("Appearances of %q\n\n", name)
+	fmt.Print(services.FormatResultsTable(results))
+}
+
+func facesErase(name string, req models.ErasureRequest) {
+	_, faces, storage := openFaceService(false)
+	defer storage.Close()
+
+	report, err := faces.Erase(name, req)
+	if err != nil {
+		log.Fatalf("erasing %s: %v", name, err)
+	}
+	if jsonOutput {
+		printJSON(report)
+	} else {
+		verb := "Erased"
+		if report.DryRun {
+			verb = "Would erase"
+		}
+		fmt.Printf("%s %q: %d reference encodings, %d face embeddings (%s)\n",
+			verb, name, report.References, report.FaceEmbeddings, report.Embeddings)
+		fmt.Printf("  frames found     %d\n", report.FramesFound)
+		if report.Frames != services.EraseKeep {
+			fmt.Printf("  frames blurred   %d\n  frames deleted   %d\n", report.FramesBlurred, report.FramesDeleted)
+		}
+		if report.Bookmarks+report.CaseItems > 0 {
+			fmt.Printf("  kept for review  %d bookmarks, %d case items\n", report.Bookmarks, report.CaseItems)
+		}
+		for _, e := range report.Errors {
+			fmt.Printf("  error: %s\n", e)
+		}
+	}
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/intelsk/backend/services"
+)
+
+func runGC(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be removed, delete nothing")
+	minAge := fs.Duration("min-age", time.Hour, "leave temp files, unfinished extractions and stream directories younger than this")
+	verbose := fs.Bool("v", false, "list every file and directory removed")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+	parseFlags(fs, args)
+
+	cfg := loadAppConfig()
+
+	storage, err := services.NewStorage(cfg.Storage.DBPath)
+	if err != nil {
+		log.Fatalf("opening storage: %v", err)
+	}
+	defer storage.Close()
+
+	result, err := services.CollectGarbage(cfg, storage, services.GCOptions{DryRun: *dryRun, MinAge: *minAge})
+	if err != nil {
+		log.Fatalf("gc failed: %v", err)
+	}
+
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	if *verbose {
+		for _, it := range result.Items {
+			fmt.Printf("  %-6s  %9s  %s\n", it.Kind, formatBytes(it.Bytes), it.Path)
+		}
+	}
+
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s:\n", verb)
+	fmt.Printf("  %d CLIP and %d face embeddings of missing frames (%s)\n",
+		result.ClipEmbeddings, result.FaceEmbeddings, formatBytes(result.EmbeddingBytes))
+	fmt.Printf("  %d frame files not in any manifest\n", result.FrameFiles)
+	fmt.Printf("  %d stale index state files\n", result.StateFiles)
+	fmt.Printf("  %d temporary files\n", result.TmpFiles)
+	fmt.Printf("  %d stream directories\n", result.StreamDirs)
+	fmt.Printf("  %d preview directories\n", result.PreviewDirs)
+	fmt.Printf("  %d timelapses\n", result.TimelapseFiles)
+	fmt.Printf("  %d resized frames\n", result.FrameSizeFiles)
+	fmt.Printf("Total: %s (%s on disk)\n", formatBytes(result.TotalBytes()), formatBytes(result.FileBytes))
+}
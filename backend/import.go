@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	src := fs.String("src", "", "directory (searched recursively) or single video file to import (required)")
+	camera := fs.String("camera", "", "camera ID to import into; created as a local camera if missing (required)")
+	date := fs.String("date", "", "force all files onto this date (YYYY-MM-DD); times are still inferred")
+	dateFrom := fs.String("date-from", "auto", "where to read the recording time: filename, mtime or auto")
+	datePattern := fs.String("date-pattern", "", "regexp with named groups year, month, day[, hour, minute, second] matched against filenames")
+	move := fs.Bool("move", false, "move files instead of copying them")
+	dryRun := fs.Bool("dry-run", false, "print what would be imported without touching anything")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	parseFlags(fs, args)
+
+	if *src == "" || *camera == "" {
+		fmt.Fprintln(os.Stderr, "error: -src and -camera flags are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var pattern *regexp.Regexp
+	if *datePattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(*datePattern); err != nil {
+			log.Fatalf("invalid -date-pattern: %v", err)
+		}
+	}
+	var forcedDate time.Time
+	if *date != "" {
+		var err error
+		if forcedDate, err = time.ParseInLocation("2006-01-02", *date, time.Local); err != nil {
+			log.Fatalf("invalid -date: %v", err)
+		}
+	}
+
+	cfg := loadAppConfig()
+
+	files, err := findImportableVideos(*src)
+	if err != nil {
+		log.Fatalf("scanning %s: %v", *src, err)
+	}
+	if len(files) == 0 {
+		log.Fatalf("no video files (%s) found in %s", strings.Join(services.ImportableExtensions, ", "), *src)
+	}
+	fmt.Printf("Found %d video file(s) in %s\n", len(files), *src)
+
+	if !*dryRun {
+		ensureImportCamera(cfg, *camera)
+	}
+
+	dates := make(map[string]int)
+	var imported, skipped, failed int
+	for _, f := range files {
+		rt, err := services.InferRecordingTime(f, *dateFrom, pattern)
+		if err != nil {
+			fmt.Printf("  FAIL %s: %v (use -date or -date-pattern)\n", f, err)
+			failed++
+			continue
+		}
+		if !forcedDate.IsZero() {
+			rt.Start = time.Date(forcedDate.Year(), forcedDate.Month(), forcedDate.Day(),
+				rt.Start.Hour(), rt.Start.Minute(), rt.Start.Second(), 0, time.Local)
+			rt.Source = "override"
+		}
+
+		dayDir := filepath.Join(cfg.App.DataDir, "videos", *camera, rt.Start.Format("2006-01-02"))
+		if alreadyImported(f, dayDir) {
+			fmt.Printf("  SKIP %s: already imported\n", f)
+			skipped++
+			continue
+		}
+
+		dest := services.ImportDestination(cfg.App.DataDir, *camera, f, rt)
+		when := rt.Start.Format("2006-01-02 15:04")
+		if !rt.HasTime {
+			when = rt.Start.Format("2006-01-02") + " (time unknown)"
+		}
+		fmt.Printf("  %s -> %s  [%s from %s]\n", f, dest, when, rt.Source)
+		if *dryRun {
+			imported++
+			dates[rt.Start.Format("2006-01-02")]++
+			continue
+		}
+		if err := services.ImportVideo(f, dest, *move); err != nil {
+			fmt.Printf("  FAIL %s: %v\n", f, err)
+			failed++
+			continue
+		}
+		imported++
+		dates[rt.Start.Format("2006-01-02")]++
+	}
+
+	verb := "Imported"
+	if *dryRun {
+		verb = "Would import"
+	}
+	fmt.Printf("\n%s %d file(s), skipped %d, failed %d\n", verb, imported, skipped, failed)
+	if imported > 0 && !*dryRun {
+		days := make([]string, 0, len(dates))
+		for d := range dates {
+			days = append(days, d)
+		}
+		sort.Strings(days)
+		fmt.Printf("Index the new footage with 'backend process' + 'backend index' or the Process page, e.g.:\n")
+		fmt.Printf("  backend process -camera %s -date %s\n", *camera, days[0])
+		if len(days) > 1 {
+			fmt.Printf("  (%d dates: %s .. %s)\n", len(days), days[0], days[len(days)-1])
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// findImportableVideos returns the video files under root (or root itself
+// if it is a file), sorted by path. Hidden directories are skipped.
+func findImportableVideos(root string) ([]string, error) {
+	isVideo := func(name string) bool {
+		return slices.Contains(services.ImportableExtensions, strings.ToLower(filepath.Ext(name)))
+	}
+
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		if !isVideo(root) {
+			return nil, nil
+		}
+		return []string{root}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && isVideo(d.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// alreadyImported reports whether dayDir holds an MP4 with the same size and
+// mtime as src, which is what a previous copy-import leaves behind.
+func alreadyImported(src, dayDir string) bool {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false
+	}
+	entries, err := os.ReadDir(dayDir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if info.Size() == srcInfo.Size() && info.ModTime().Equal(srcInfo.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureImportCamera creates cameraID as a local camera if it doesn't exist.
+func ensureImportCamera(cfg *config.AppConfig, cameraID string) {
+	storage, err := services.NewStorage(cfg.Storage.DBPath)
+	if err != nil {
+		log.Fatalf("opening storage: %v", err)
+	}
+	defer storage.Close()
+
+	cameraSvc := services.NewCameraService(storage.DB(), cfg)
+	if _, err := cameraSvc.Get(cameraID); err == nil {
+		return
+	}
+	if _, err := cameraSvc.Create(models.CreateCameraRequest{ID: cameraID, Name: cameraID, Type: "local"}); err != nil {
+		log.Fatalf("creating camera %s: %v", cameraID, err)
+	}
+	fmt.Printf("Created local camera %q\n", cameraID)
+}
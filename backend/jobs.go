@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/intelsk/backend/api"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+const jobsUsage = `Usage:
+  backend jobs list [flags]          List the server's processing jobs, newest first
+  backend jobs tail [flags] JOB_ID   Follow a job's progress until it finishes
+  backend jobs log [flags] JOB_ID    Print a job's recorded events and log lines
+  backend jobs cancel [flags] JOB_ID Cancel a running job
+  backend jobs retry [flags] JOB_ID  Run a finished job again`
+
+// jobsClient talks to a running server's process API.
+type jobsClient struct {
+	base string // e.g. http://localhost:8000/api/v1
+	http *http.Client
+}
+
+func runJobs(args []string) {
+	fs := flag.NewFlagSet("jobs", flag.ExitOnError)
+	serverURL := fs.String("server", "", "server URL (default: http://localhost:{app.port})")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, jobsUsage)
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	sub := ""
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	parseFlags(fs, args)
+
+	cfg := loadAppConfig()
+	if *serverURL == "" {
+		host := cfg.App.Host
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		*serverURL = "http://" + net.JoinHostPort(host, strconv.Itoa(cfg.App.Port))
+	}
+	client := &jobsClient{
+		base: strings.TrimSuffix(*serverURL, "/") + cfg.APIBase(),
+		http: &http.Client{Timeout: 30 * time.Second},
+	}
+
+	switch sub {
+	case "list":
+		client.list()
+	case "tail", "log", "cancel", "retry":
+		if fs.NArg() != 1 {
+			fmt.Fprintf(os.Stderr, "error: %s needs exactly one job ID\n", sub)
+			fs.Usage()
+			os.Exit(1)
+		}
+		switch sub {
+		case "tail":
+			client.tail(fs.Arg(0))
+		case "log":
+			client.log(fs.Arg(0))
+		case "cancel":
+			client.cancel(fs.Arg(0))
+		default:
+			client.retry(fs.Arg(0))
+		}
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// do sends a request and decodes a JSON response into out. Error responses
+// are returned with the server's message.
+func (c *jobsClient) do(method, path string, out any) error {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w (is 'backend serve' running?)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var apiErr api.ErrorResponse
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s", apiErr.Message)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *jobsClient) jobs() []api.JobInfo {
+	var jobs []api.JobInfo
+	if err := c.do(http.MethodGet, "/process/jobs", &jobs); err != nil {
+		log.Fatalf("listing jobs: %v", err)
+	}
+	return jobs
+}
+
+func (c *jobsClient) list() {
+	jobs := c.jobs()
+	if jsonOutput {
+		printJSON(jobs)
+		return
+	}
+	if len(jobs) == 0 {
+		fmt.Println("No jobs recorded.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tSTATUS\tCAMERAS\tDATES\tSTARTED\tLAST EVENT\t")
+	for _, j := range jobs {
+		dates := j.StartDate
+		if j.EndDate != "" && j.EndDate != j.StartDate {
+			dates += ".." + j.EndDate
+		}
+		last := "-"
+		if j.LastEvent != nil {
+			last = fmt.Sprintf("[%s] %s", j.LastEvent.Stage, j.LastEvent.Message)
+		}
+		if j.Error != "" {
+			last = j.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t\n", j.JobID, j.Status, strings.Join(j.CameraIDs, ","),
+			dates, j.CreatedAt.Local().Format("2006-01-02 15:04:05"), last)
+	}
+	tw.Flush()
+}
+
+// tail follows /process/status, the SSE stream the web UI uses, printing
+// each event. A dropped connection is resumed with Last-Event-ID so no
+// event is printed twice or missed. Exits non-zero unless the job completed.
+func (c *jobsClient) tail(jobID string) {
+	// No overall timeout: the stream lasts as long as the job
+	stream := &http.Client{}
+	lastID := 0
+	for retries := 0; ; {
+		req, err := http.NewRequest(http.MethodGet, c.base+"/process/status?job_id="+jobID, nil)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		if lastID > 0 {
+			req.Header.Set("Last-Event-ID", strconv.Itoa(lastID))
+		}
+		resp, err := stream.Do(req)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			var apiErr api.ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&apiErr)
+			resp.Body.Close()
+			log.Fatalf("job %s: %s", jobID, apiErr.Message)
+		}
+		if err == nil {
+			retries = 0
+			var ok bool
+			lastID, ok = readEventStream(resp.Body, lastID)
+			resp.Body.Close()
+			if ok {
+				break
+			}
+		}
+		if retries++; retries > 5 {
+			log.Fatalf("lost the event stream of job %s", jobID)
+		}
+		time.Sleep(3 * time.Second)
+	}
+
+	for _, j := range c.jobs() {
+		if j.JobID != jobID {
+			continue
+		}
+		if jsonOutput {
+			printJSONLine(j)
+		} else {
+			statusf("Job %s %s\n", j.JobID, j.Status)
+		}
+		if j.Status != "complete" {
+			os.Exit(1)
+		}
+	}
+}
+
+// readEventStream prints the events of an SSE body and returns the id of the
+// last one. ok is true when the server ended the stream (the job finished)
+// rather than the connection failing.
+func readEventStream(body io.Reader, lastID int) (int, bool) {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			if n, err := strconv.Atoi(strings.TrimPrefix(line, "id: ")); err == nil {
+				lastID = n
+			}
+		case strings.HasPrefix(line, "data: "):
+			var ev services.ProgressEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err == nil {
+				printProgress(ev)
+			}
+		}
+	}
+	return lastID, sc.Err() == nil
+}
+
+// log prints a job's log, which is kept after it finishes and across server
+// restarts until the job is pruned.
+func (c *jobsClient) log(jobID string) {
+	var entries []models.JobLogEntry
+	if err := c.do(http.MethodGet, "/jobs/"+jobID+"/log", &entries); err != nil {
+		log.Fatalf("reading the log of %s: %v", jobID, err)
+	}
+	if jsonOutput {
+		printJSON(entries)
+		return
+	}
+	for _, e := range entries {
+		e.Time = e.Time.Local()
+		fmt.Println(api.FormatJobLogEntry(e))
+	}
+}
+
+func (c *jobsClient) cancel(jobID string) {
+	var resp models.ProcessResponse
+	if err := c.do(http.MethodPost, "/process/jobs/"+jobID+"/cancel", &resp); err != nil {
+		log.Fatalf("cancelling %s: %v", jobID, err)
+	}
+	if jsonOutput {
+		printJSON(resp)
+		return
+	}
+	fmt.Printf("Job %s is %s; it stops shortly\n", resp.JobID, resp.Status)
+}
+
+func (c *jobsClient) retry(jobID string) {
+	var job models.Job
+	if err := c.do(http.MethodPost, "/jobs/"+jobID+"/retry", &job); err != nil {
+		log.Fatalf("retrying %s: %v", jobID, err)
+	}
+	if jsonOutput {
+		printJSON(job)
+		return
+	}
+	fmt.Printf("Job %s started again as %s (follow it with 'backend jobs tail %s')\n", jobID, job.ID, job.ID)
+}
@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+	// Timezone database for general.timezone in containers without one
+	_ "time/tzdata"
 
 	"github.com/intelsk/backend/cmd/server"
 	"github.com/intelsk/backend/config"
@@ -15,44 +17,80 @@ import (
 )
 
 var rootDir string
+var workspaceID string
+
+// defaultDBPath is the database of the default workspace, whose ml.*
+// settings say where the ML sidecar is for every workspace.
+var defaultDBPath string
+
+// command is a CLI subcommand. The table drives dispatch, the usage text
+// and the generated shell completions.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// commands is filled in by init because runCompletion refers back to it.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"extract", "Extract frames from a single video file", runExtract},
+		{"process", "Extract and index new videos for cameras and dates", runProcess},
+		{"index", "Index extracted frames via CLIP embeddings", runIndex},
+		{"dedup", "Remove near-duplicate frames and their embeddings after the fact", runDedup},
+		{"faces", "Enroll persons, detect faces in frames and search appearances", runFaces},
+		{"gc", "Remove orphaned embeddings, unreferenced frames, temp files and old streams", runGC},
+		{"search", "Search indexed frames by text query", runSearch},
+		{"serve", "Start the HTTP API server", runServe},
+		{"worker", "Index frames for a remote server on this machine's ML sidecar", runWorker},
+		{"jobs", "List, follow, cancel and retry jobs of a running server", runJobs},
+		{"watch", "Extract and index videos as they appear in data/videos or a drop folder", runWatch},
+		{"stats", "Show per-camera video, frame and embedding counts", runStats},
+		{"timelapse", "Render a camera's extracted frames for a date as a timelapse MP4", runTimelapse},
+		{"import", "Import an existing directory of recordings into a camera", runImport},
+		{"config", "Validate the YAML configuration and print the effective values", runConfig},
+		{"doctor", "Check tools, config, data directory, DB and ML sidecar", runDoctor},
+		{"completion", "Print a bash, zsh or fish completion script", runCompletion},
+	}
+}
 
 func main() {
-	if len(os.Args) < 2 {
+	args := os.Args[1:]
+	// Global flags may precede the command: backend --json stats
+	for len(args) > 0 && (args[0] == "-json" || args[0] == "--json") {
+		jsonOutput = true
+		args = args[1:]
+	}
+	if len(args) < 1 {
 		printUsage()
 		os.Exit(1)
 	}
 
-	command := os.Args[1]
-	switch command {
-	case "extract":
-		runExtract(os.Args[2:])
-	case "process":
-		runProcess(os.Args[2:])
-	case "index":
-		runIndex(os.Args[2:])
-	case "search":
-		runSearch(os.Args[2:])
-	case "serve":
-		runServe(os.Args[2:])
-	default:
-		fmt.Fprintf(os.Stderr, "unknown command: %s\n", command)
-		printUsage()
-		os.Exit(1)
+	for _, c := range commands {
+		if c.name == args[0] {
+			c.run(args[1:])
+			return
+		}
 	}
+	fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+	printUsage()
+	os.Exit(1)
 }
 
 func printUsage() {
-	fmt.Fprintln(os.Stderr, "Usage: backend <command> [flags]")
+	fmt.Fprintln(os.Stderr, "Usage: backend [--json] <command> [flags]")
 	fmt.Fprintln(os.Stderr, "")
 	fmt.Fprintln(os.Stderr, "Commands:")
-	fmt.Fprintln(os.Stderr, "  extract   Extract frames from a single video file")
-	fmt.Fprintln(os.Stderr, "  process   Extract frames from all videos for a camera+date")
-	fmt.Fprintln(os.Stderr, "  index     Index extracted frames via CLIP embeddings")
-	fmt.Fprintln(os.Stderr, "  search    Search indexed frames by text query")
-	fmt.Fprintln(os.Stderr, "  serve     Start the HTTP API server")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", c.name, c.summary)
+	}
 	fmt.Fprintln(os.Stderr, "")
 	fmt.Fprintln(os.Stderr, "Common flags:")
-	fmt.Fprintln(os.Stderr, "  -root     Project root directory (default: parent of backend/)")
+	fmt.Fprintln(os.Stderr, "  -root       Project root directory (default: parent of backend/)")
+	fmt.Fprintln(os.Stderr, "  -workspace  Workspace to operate on (default: default)")
+	fmt.Fprintln(os.Stderr, "  -json       JSON output on stdout (extract, process, index, search, stats)")
 	fmt.Fprintln(os.Stderr, "")
 	fmt.Fprintln(os.Stderr, "Run 'backend <command> -help' for details.")
 }
@@ -61,6 +99,12 @@ func addRootFlag(fs *flag.FlagSet) {
 	fs.StringVar(&rootDir, "root", "", "project root directory (default: parent of backend/)")
 }
 
+// addWorkspaceFlag lets a command operate on a workspace other than the
+// default one (see 'serve' and /api/workspaces).
+func addWorkspaceFlag(fs *flag.FlagSet) {
+	fs.StringVar(&workspaceID, "workspace", config.DefaultWorkspace, "workspace to operate on")
+}
+
 func resolveRoot() string {
 	if rootDir != "" {
 		abs, err := filepath.Abs(rootDir)
@@ -95,13 +139,23 @@ func resolveRoot() string {
 }
 
 func loadAppConfig() *config.AppConfig {
+	cfg, err := readAppConfig()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return cfg
+}
+
+// readAppConfig loads config/app.yaml and config/extraction.yaml from the
+// project root, makes data paths absolute and applies -workspace.
+func readAppConfig() (*config.AppConfig, error) {
 	root := resolveRoot()
 	appYaml := filepath.Join(root, "config", "app.yaml")
 	extractionYaml := filepath.Join(root, "config", "extraction.yaml")
 
 	cfg, err := config.LoadConfig(appYaml, extractionYaml)
 	if err != nil {
-		log.Fatalf("loading config: %v", err)
+		return nil, fmt.Errorf("loading config: %w", err)
 	}
 
 	// Make relative paths absolute against project root
@@ -117,15 +171,28 @@ func loadAppConfig() *config.AppConfig {
 	if !filepath.IsAbs(cfg.Process.HistoryPath) {
 		cfg.Process.HistoryPath = filepath.Join(root, cfg.Process.HistoryPath)
 	}
+	if !filepath.IsAbs(cfg.MLService.ONNXDir) {
+		cfg.MLService.ONNXDir = filepath.Join(root, cfg.MLService.ONNXDir)
+	}
 
-	return cfg
+	defaultDBPath = cfg.Storage.DBPath
+	if workspaceID != "" && workspaceID != config.DefaultWorkspace {
+		if _, err := os.Stat(cfg.WorkspaceDir(workspaceID)); err != nil {
+			return nil, fmt.Errorf("unknown workspace %q (create it via POST /api/workspaces)", workspaceID)
+		}
+		cfg = cfg.ForWorkspace(workspaceID)
+	}
+
+	return cfg, nil
 }
 
 func runExtract(args []string) {
 	fs := flag.NewFlagSet("extract", flag.ExitOnError)
 	video := fs.String("video", "", "path to video file (required)")
 	addRootFlag(fs)
-	fs.Parse(args)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+	parseFlags(fs, args)
 
 	if *video == "" {
 		fmt.Fprintln(os.Stderr, "error: -video flag is required")
@@ -134,49 +201,26 @@ func runExtract(args []string) {
 	}
 
 	cfg := loadAppConfig()
-	extractVideo(cfg, *video)
-}
-
-func runProcess(args []string) {
-	fs := flag.NewFlagSet("process", flag.ExitOnError)
-	camera := fs.String("camera", "", "camera ID (required)")
-	date := fs.String("date", "", "date in YYYY-MM-DD format (required)")
-	addRootFlag(fs)
-	fs.Parse(args)
-
-	if *camera == "" || *date == "" {
-		fmt.Fprintln(os.Stderr, "error: -camera and -date flags are required")
-		fs.Usage()
-		os.Exit(1)
-	}
-
-	cfg := loadAppConfig()
-
-	videosDir := filepath.Join(cfg.App.DataDir, "videos", *camera, *date)
-	entries, err := os.ReadDir(videosDir)
-	if err != nil {
-		log.Fatalf("reading videos directory %s: %v", videosDir, err)
-	}
-
-	var videos []string
-	for _, e := range entries {
-		if !e.IsDir() && strings.HasSuffix(strings.ToLower(e.Name()), ".mp4") {
-			videos = append(videos, filepath.Join(videosDir, e.Name()))
-		}
-	}
-
-	if len(videos) == 0 {
-		log.Fatalf("no MP4 files found in %s", videosDir)
+	result := extractVideo(cfg, *video)
+	if jsonOutput {
+		printJSON(result)
 	}
+}
 
-	fmt.Printf("Found %d video(s) in %s\n", len(videos), videosDir)
-	for _, v := range videos {
-		extractVideo(cfg, v)
-	}
+// extractResult is the -json output of 'extract'.
+type extractResult struct {
+	Video             string `json:"video"`
+	CameraID          string `json:"camera_id"`
+	Date              string `json:"date"`
+	OutputDir         string `json:"output_dir"`
+	Manifest          string `json:"manifest"`
+	FramesExtracted   int    `json:"frames_extracted"`
+	DuplicatesRemoved int    `json:"duplicates_removed"`
+	Frames            int    `json:"frames"`
 }
 
-func extractVideo(cfg *config.AppConfig, videoPath string) {
-	fmt.Printf("Extracting frames from %s (interval=%ds, quality=%d)\n",
+func extractVideo(cfg *config.AppConfig, videoPath string) extractResult {
+	statusf("Extracting frames from %s (interval=%ds, quality=%d)\n",
 		videoPath, cfg.Extraction.TimeIntervalSec, cfg.Extraction.OutputQuality)
 
 	// Derive output directory from video path structure:
@@ -203,23 +247,34 @@ func extractVideo(cfg *config.AppConfig, videoPath string) {
 	if err != nil {
 		log.Fatalf("extraction failed: %v", err)
 	}
-	fmt.Printf("Extracted %d frame(s)\n", len(frames))
+	statusf("Extracted %d frame(s)\n", len(frames))
+	result := extractResult{
+		Video:           abs,
+		CameraID:        cameraID,
+		Date:            dateStr,
+		OutputDir:       outputDir,
+		Manifest:        filepath.Join(outputDir, "manifest.json"),
+		FramesExtracted: len(frames),
+	}
 
 	if cfg.Extraction.DedupEnabled {
-		fmt.Printf("Running de-duplication (threshold=%d)...\n", cfg.Extraction.DedupPHashThreshold)
+		statusf("Running de-duplication (threshold=%d)...\n", cfg.Extraction.DedupPHashThreshold)
 		before := len(frames)
 		frames, err = services.DeduplicateFrames(frames, cfg.Extraction.DedupPHashThreshold)
 		if err != nil {
 			log.Fatalf("dedup failed: %v", err)
 		}
-		fmt.Printf("De-duplication: %d → %d frames (%d duplicates removed)\n",
+		statusf("De-duplication: %d → %d frames (%d duplicates removed)\n",
 			before, len(frames), before-len(frames))
+		result.DuplicatesRemoved = before - len(frames)
 	}
+	result.Frames = len(frames)
 
 	if err := services.WriteManifest(outputDir, frames); err != nil {
 		log.Fatalf("writing manifest: %v", err)
 	}
-	fmt.Printf("Manifest written to %s/manifest.json\n", outputDir)
+	statusf("Manifest written to %s/manifest.json\n", outputDir)
+	return result
 }
 
 func runIndex(args []string) {
@@ -227,7 +282,9 @@ func runIndex(args []string) {
 	camera := fs.String("camera", "", "camera ID (required)")
 	date := fs.String("date", "", "date in YYYY-MM-DD format (required)")
 	addRootFlag(fs)
-	fs.Parse(args)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+	parseFlags(fs, args)
 
 	if *camera == "" || *date == "" {
 		fmt.Fprintln(os.Stderr, "error: -camera and -date flags are required")
@@ -244,12 +301,12 @@ func runIndex(args []string) {
 	}
 
 	// Init ML client and wait for sidecar
-	mlClient := services.NewMLClient(cfg.MLService.URL)
-	fmt.Printf("Waiting for ML sidecar at %s...\n", cfg.MLService.URL)
+	mlClient := newMLClient(cfg)
+	statusf("Waiting for ML sidecar at %s...\n", mlClient.BaseURL())
 	if err := mlClient.WaitForReady(60 * time.Second); err != nil {
 		log.Fatalf("ML sidecar not ready: %v", err)
 	}
-	fmt.Println("ML sidecar ready")
+	statusf("ML sidecar ready\n")
 
 	// Init storage
 	storage, err := services.NewStorage(cfg.Storage.DBPath)
@@ -261,66 +318,36 @@ func runIndex(args []string) {
 	// Build and run pipeline
 	pipeline := services.NewPipeline(mlClient, storage, cfg.CLIP.BatchSize)
 	progress := make(chan services.ProgressEvent, 10)
+	printed := make(chan struct{})
 
 	go func() {
+		defer close(printed)
 		for ev := range progress {
-			if ev.FramesTotal > 0 {
-				fmt.Printf("[%s] %s — %d/%d frames\n",
-					ev.Stage, ev.Message, ev.FramesDone, ev.FramesTotal)
-			} else {
-				fmt.Printf("[%s] %s\n", ev.Stage, ev.Message)
-			}
+			printProgress(ev)
 		}
 	}()
 
-	if err := pipeline.IndexFrames(framesDir, progress); err != nil {
-		log.Fatalf("indexing failed: %v", err)
-	}
+	err = pipeline.IndexFrames(framesDir, progress)
 	close(progress)
-
-	fmt.Println("Indexing complete")
-}
-
-func runSearch(args []string) {
-	fs := flag.NewFlagSet("search", flag.ExitOnError)
-	text := fs.String("text", "", "text query (required)")
-	camera := fs.String("camera", "", "camera ID filter (optional)")
-	limit := fs.Int("limit", 20, "max results")
-	addRootFlag(fs)
-	fs.Parse(args)
-
-	if *text == "" {
-		fmt.Fprintln(os.Stderr, "error: -text flag is required")
-		fs.Usage()
-		os.Exit(1)
-	}
-
-	cfg := loadAppConfig()
-
-	mlClient := services.NewMLClient(cfg.MLService.URL)
-	if err := mlClient.HealthCheck(); err != nil {
-		log.Fatalf("ML sidecar health check failed: %v", err)
-	}
-
-	var cameraIDs []string
-	if *camera != "" {
-		cameraIDs = []string{*camera}
-	}
-
-	results, err := mlClient.SearchByText(cfg.Storage.DBPath, *text, cameraIDs, "", "", *limit, 0.18)
+	<-printed
 	if err != nil {
-		log.Fatalf("search failed: %v", err)
+		log.Fatalf("indexing failed: %v", err)
 	}
 
-	fmt.Printf("Results for query: %q\n\n", *text)
-	fmt.Print(services.FormatResultsTable(results))
+	if jsonOutput {
+		printJSONLine(services.ProgressEvent{Stage: "done", CameraID: *camera, Message: "indexing complete"})
+		return
+	}
+	fmt.Println("Indexing complete")
 }
 
 func runServe(args []string) {
 	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	pidFile := fs.String("pid-file", "", "write the process ID to this file while running")
+	waitML := fs.Duration("wait-ml", 0, "wait up to this long for the ML sidecar before reporting ready (e.g. 2m)")
 	addRootFlag(fs)
-	fs.Parse(args)
+	parseFlags(fs, args)
 
 	cfg := loadAppConfig()
-	server.Start(cfg)
+	server.Start(cfg, server.Options{PIDFile: *pidFile, WaitForML: *waitML})
 }
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type FrameMetadata struct {
 	FramePath        string    `json:"frame_path"`
@@ -33,6 +36,15 @@ type ProcessRequest struct {
 	EndDate   string   `json:"end_date"`
 	StartTime string   `json:"start_time,omitempty"`
 	EndTime   string   `json:"end_time,omitempty"`
+	// StartHour and EndHour limit the run to those hours of every date,
+	// from the start of StartHour (0-23) up to EndHour (1-24), e.g. 18 and
+	// 22 for 18:00-22:00; either left out is the start or end of the day
+	StartHour *int `json:"start_hour,omitempty"`
+	EndHour   *int `json:"end_hour,omitempty"`
+	// Priority orders the job against the others waiting for a slot, from
+	// -10 to 10; higher goes first and preempts running lower-priority jobs
+	// between dates.
+	Priority int `json:"priority,omitempty"`
 }
 
 type ProcessResponse struct {
@@ -40,12 +52,287 @@ type ProcessResponse struct {
 	Status string `json:"status"`
 }
 
+// Job is a background job (a processing run or an upload's extract/index)
+// as recorded in the jobs table and listed by GET /api/jobs.
+type Job struct {
+	ID     string          `json:"job_id"`
+	Type   string          `json:"type"` // "process", "upload"
+	Params json.RawMessage `json:"params"`
+	// Status is "queued", "running", "complete", "failed" or "cancelled".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// RetryOf is the job this one re-runs, if any.
+	RetryOf string `json:"retry_of,omitempty"`
+	// Resumes counts the server restarts the job was resumed after.
+	Resumes int `json:"resumes,omitempty"`
+	// Priority orders jobs waiting for a slot; higher goes first.
+	Priority   int        `json:"priority"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Events counts the progress events so far; LastEvent is the latest.
+	Events    int             `json:"events"`
+	LastEvent json.RawMessage `json:"last_event,omitempty"`
+}
+
+// WorkerTask is a batch of frames handed to a remote indexing worker. The
+// worker fetches frames 0 to Frames-1 and must post their embeddings within
+// LeaseSec.
+type WorkerTask struct {
+	TaskID   string `json:"task_id"`
+	Frames   int    `json:"frames"`
+	LeaseSec int    `json:"lease_sec"`
+}
+
+// WorkerResult is what a worker posts for a batch: one embedding per frame,
+// in order, or the error encoding failed with.
+type WorkerResult struct {
+	Embeddings [][]float64 `json:"embeddings,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// WorkerInfo describes a remote indexing worker seen by the server.
+type WorkerInfo struct {
+	Name     string    `json:"name"`
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"last_seen"`
+	Claimed  int       `json:"claimed"` // batches it holds now
+	Batches  int       `json:"batches"` // batches it finished
+	Frames   int       `json:"frames"`
+	Failed   int       `json:"failed"`
+}
+
+// WorkersStatus is the state of the remote indexing queue.
+type WorkersStatus struct {
+	Enabled bool         `json:"enabled"`
+	Pending int          `json:"pending"` // batches waiting for a worker
+	Claimed int          `json:"claimed"` // batches being encoded
+	Workers []WorkerInfo `json:"workers"`
+}
+
+// JobLogEntry is one line of a job's log: a progress event, or a log line
+// such as an ffmpeg error with the job's detail behind it.
+type JobLogEntry struct {
+	Seq  int       `json:"seq"`
+	Time time.Time `json:"time"`
+	// Kind is "event" or "log".
+	Kind    string          `json:"kind"`
+	Message string          `json:"message"`
+	Event   json.RawMessage `json:"event,omitempty"`
+}
+
+// Schedule processes recordings automatically: each time Cron fires (in the
+// general.timezone zone) it submits a processing job for the previous day.
+type Schedule struct {
+	ID string `json:"id"`
+	// CameraID is the camera processed; empty processes every camera.
+	CameraID  string     `json:"camera_id,omitempty"`
+	Cron      string     `json:"cron"`
+	Enabled   bool       `json:"enabled"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	LastJobID string     `json:"last_job_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// IngestState is how far auto-ingest got for a camera: Cursor is the end
+// of the last recording ingested, in the NVR's wall-clock time.
+type IngestState struct {
+	CameraID  string     `json:"camera_id"`
+	Cursor    *time.Time `json:"cursor,omitempty"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	LastJobID string     `json:"last_job_id,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// Alert is an event raised by an alert rule that needs an operator's
+// attention. Status is "new", "acknowledged" or "dismissed".
+type Alert struct {
+	ID       string `json:"alert_id"`
+	Rule     string `json:"rule"`
+	CameraID string `json:"camera_id,omitempty"`
+	Status   string `json:"status"`
+	Title    string `json:"title"`
+	Message  string `json:"message,omitempty"`
+	// Date is the camera wall-clock date of the event and Timestamp its
+	// time, stored in UTC like a frame's and given in general.timezone by
+	// the API
+	Date      string `json:"date"`
+	Timestamp string `json:"timestamp,omitempty"`
+	// FramePath and SourceVideo locate the matched frame and its video;
+	// the API turns them into FrameURL and ClipURL
+	FramePath      string     `json:"-"`
+	SourceVideo    string     `json:"-"`
+	FrameURL       string     `json:"frame_url,omitempty"`
+	ClipURL        string     `json:"clip_url,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	// GroupID is the alert this one was grouped into: one of the same rule
+	// matched on another camera at about the same time. Views lists the
+	// alerts grouped into this one, each a view of the event from its camera.
+	GroupID string  `json:"group_id,omitempty"`
+	Views   []Alert `json:"views,omitempty"`
+}
+
+// Bookmark marks a frame an investigator found worth keeping, with a
+// free-text note and tags. Timestamp is the frame's, like a search
+// result's.
+type Bookmark struct {
+	ID        string   `json:"bookmark_id"`
+	FrameID   string   `json:"frame_id"`
+	CameraID  string   `json:"camera_id"`
+	Timestamp string   `json:"timestamp"`
+	Note      string   `json:"note"`
+	Tags      []string `json:"tags"`
+	// FramePath and SourceVideo locate the frame and its video; the API
+	// turns them into the URLs
+	FramePath      string    `json:"-"`
+	SourceVideo    string    `json:"-"`
+	FrameURL       string    `json:"frame_url,omitempty"`
+	SourceVideoURL string    `json:"source_video_url,omitempty"`
+	SeekOffsetSec  int       `json:"seek_offset_sec"`
+	ClipURL        string    `json:"clip_url,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type CreateBookmarkRequest struct {
+	FrameID string   `json:"frame_id"`
+	Note    string   `json:"note,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// UpdateBookmarkRequest changes the fields that are set; tags replace the
+// bookmark's.
+type UpdateBookmarkRequest struct {
+	Note *string   `json:"note,omitempty"`
+	Tags *[]string `json:"tags,omitempty"`
+}
+
+// Case gathers the findings of an investigation: search results, frames,
+// clips and notes. Items is only set on a single case.
+type Case struct {
+	ID          string     `json:"case_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Status      string     `json:"status"` // "open", "closed"
+	ItemCount   int        `json:"item_count"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Items       []CaseItem `json:"items,omitempty"`
+}
+
+// CaseItem is one finding attached to a case. A "result" is a search hit,
+// with the query and score that found it; a "frame" any indexed frame; a
+// "clip" StartSec..EndSec of the frame's source video; a "note" just text.
+// Every kind may carry a note.
+type CaseItem struct {
+	ID        string  `json:"item_id"`
+	Kind      string  `json:"kind"`
+	FrameID   string  `json:"frame_id,omitempty"`
+	CameraID  string  `json:"camera_id,omitempty"`
+	Timestamp string  `json:"timestamp,omitempty"`
+	Query     string  `json:"query,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+	StartSec  int     `json:"start_sec,omitempty"`
+	EndSec    int     `json:"end_sec,omitempty"`
+	Note      string  `json:"note,omitempty"`
+	// FramePath and SourceVideo locate the frame and its video; the API
+	// turns them into the URLs
+	FramePath      string    `json:"-"`
+	SourceVideo    string    `json:"-"`
+	FrameURL       string    `json:"frame_url,omitempty"`
+	SourceVideoURL string    `json:"source_video_url,omitempty"`
+	SeekOffsetSec  int       `json:"seek_offset_sec,omitempty"`
+	ClipURL        string    `json:"clip_url,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type CreateCaseRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// UpdateCaseRequest changes the fields that are set.
+type UpdateCaseRequest struct {
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Status      *string `json:"status,omitempty"`
+}
+
+// AddCaseItemRequest attaches an item to a case. FrameID is required for
+// every kind but "note", which requires Note. PadSec sets the seconds a
+// clip covers on each side of the frame (default 15).
+type AddCaseItemRequest struct {
+	Kind    string  `json:"kind"`
+	FrameID string  `json:"frame_id,omitempty"`
+	Query   string  `json:"query,omitempty"`
+	Score   float64 `json:"score,omitempty"`
+	PadSec  int     `json:"pad_sec,omitempty"`
+	Note    string  `json:"note,omitempty"`
+}
+
+// AlertRuleSettings throttles the notifications of one alert rule: none
+// between QuietStart and QuietEnd (HH:MM, wrapping past midnight), and at
+// most one per camera every CooldownMin minutes. The alerts held back are
+// sent as one digest once the quiet hours or the cooldown end.
+type AlertRuleSettings struct {
+	Rule        string `json:"rule"`
+	QuietStart  string `json:"quiet_start"`
+	QuietEnd    string `json:"quiet_end"`
+	CooldownMin int    `json:"cooldown_min"`
+	Custom      bool   `json:"custom"` // false: the alerts.quiet_* and alerts.cooldown_min defaults
+	Held        int    `json:"held"`   // alerts waiting for the next digest
+}
+
+// UpdateAlertRuleRequest changes the fields that are set.
+type UpdateAlertRuleRequest struct {
+	QuietStart  *string `json:"quiet_start,omitempty"`
+	QuietEnd    *string `json:"quiet_end,omitempty"`
+	CooldownMin *int    `json:"cooldown_min,omitempty"`
+}
+
+type CreateScheduleRequest struct {
+	CameraID string `json:"camera_id,omitempty"`
+	Cron     string `json:"cron"`
+	Enabled  *bool  `json:"enabled,omitempty"` // default true
+}
+
+// UpdateScheduleRequest changes the fields that are set.
+type UpdateScheduleRequest struct {
+	CameraID *string `json:"camera_id,omitempty"`
+	Cron     *string `json:"cron,omitempty"`
+	Enabled  *bool   `json:"enabled,omitempty"`
+}
+
 type TextSearchRequest struct {
 	Query     string   `json:"query"`
 	CameraIDs []string `json:"camera_ids,omitempty"`
 	StartTime string   `json:"start_time,omitempty"`
 	EndTime   string   `json:"end_time,omitempty"`
 	Limit     int      `json:"limit"`
+	// DedupWindowSec overrides the search.dedup_window_sec setting for
+	// this request; 0 returns every matching frame.
+	DedupWindowSec *int `json:"dedup_window_sec,omitempty"`
+	// Filter narrows the results by what is known about each frame
+	// besides how well it matches the query.
+	Filter *SearchFilter `json:"filter,omitempty"`
+}
+
+// SearchFilter is a condition on search results, or an AND, OR or NOT of
+// others: exactly one of And, Or, Not and Field is set. A condition tests
+// Field with Op ("eq" if empty) against Value, e.g.
+// {"field": "weekday", "op": "in", "value": ["sat", "sun"]}.
+type SearchFilter struct {
+	And   []SearchFilter  `json:"and,omitempty"`
+	Or    []SearchFilter  `json:"or,omitempty"`
+	Not   *SearchFilter   `json:"not,omitempty"`
+	Field string          `json:"field,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
 }
 
 type APISearchResult struct {
@@ -56,6 +343,31 @@ type APISearchResult struct {
 	Score          float64 `json:"score"`
 	SourceVideoURL string  `json:"source_video_url,omitempty"`
 	SeekOffsetSec  int     `json:"seek_offset_sec"`
+	// ClipURL cuts a clip of the source video around the frame; ?pad=
+	// sets the seconds on each side and ?download=1 makes it an attachment
+	ClipURL string `json:"clip_url,omitempty"`
+	// BookmarkID is the frame's bookmark, if it has one
+	Bookmarked bool   `json:"bookmarked"`
+	BookmarkID string `json:"bookmark_id,omitempty"`
+	// Context is the light and weather at the site when the frame was
+	// taken, with enrichment configured
+	Context *FrameContext `json:"context,omitempty"`
+}
+
+// FrameInfo is an extracted frame of a camera's day, for browsing the
+// frames in order rather than through a search.
+type FrameInfo struct {
+	FrameID        string `json:"frame_id"`
+	Timestamp      string `json:"timestamp"`
+	FramePath      string `json:"-"`
+	FrameURL       string `json:"frame_url"`
+	SourceVideo    string `json:"-"`
+	SourceVideoURL string `json:"source_video_url,omitempty"`
+	SeekOffsetSec  int    `json:"seek_offset_sec"`
+	// Indexed is whether the frame has a CLIP embedding, so search can
+	// find it; ClipURL is only set then
+	Indexed bool   `json:"indexed"`
+	ClipURL string `json:"clip_url,omitempty"`
 }
 
 type SearchResponse struct {
@@ -88,6 +400,58 @@ type CreateCameraRequest struct {
 	Config map[string]any `json:"config,omitempty"`
 }
 
+// RenameCameraRequest is the body of POST /api/cameras/{id}/rename.
+type RenameCameraRequest struct {
+	NewID string `json:"new_id"`
+}
+
+// DeletedDate is what DELETE /api/cameras/{id}/dates/{date} removed.
+type DeletedDate struct {
+	CameraID       string `json:"camera_id"`
+	Date           string `json:"date"`
+	Videos         int    `json:"videos"`
+	Frames         int    `json:"frames"`
+	ClipEmbeddings int64  `json:"clip_embeddings"`
+	FaceEmbeddings int64  `json:"face_embeddings"`
+	Alerts         int64  `json:"alerts"`
+	// Bytes is the disk space the date's files took
+	Bytes int64 `json:"bytes"`
+}
+
+// ErasureRequest is the body of POST /api/persons/{name}/erase. Empty
+// fields take their defaults: embeddings "delete", frames "keep" and
+// max_distance the face search's.
+type ErasureRequest struct {
+	Embeddings  string  `json:"embeddings"` // "delete" or "anonymize"
+	Frames      string  `json:"frames"`     // "keep", "blur" or "delete"
+	MaxDistance float64 `json:"max_distance"`
+	DryRun      bool    `json:"dry_run"`
+}
+
+// ErasureReport is what erasing a person's data did, or with DryRun would
+// do.
+type ErasureReport struct {
+	Person      string    `json:"person"`
+	DryRun      bool      `json:"dry_run,omitempty"`
+	Embeddings  string    `json:"embeddings"`
+	Frames      string    `json:"frames"`
+	MaxDistance float64   `json:"max_distance"`
+	ErasedAt    time.Time `json:"erased_at"`
+	// References are the enrollment encodings removed from the registry
+	References     int `json:"references"`
+	FaceEmbeddings int `json:"face_embeddings"`
+	// Frames the person appears in, by camera, and what was done to them
+	FramesFound    int            `json:"frames_found"`
+	FramesBlurred  int            `json:"frames_blurred"`
+	FramesDeleted  int            `json:"frames_deleted"`
+	ClipEmbeddings int64          `json:"clip_embeddings"`
+	Cameras        map[string]int `json:"cameras"`
+	// Bookmarks and case items of the frames are kept for review
+	Bookmarks int      `json:"bookmarks"`
+	CaseItems int      `json:"case_items"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
 type UpdateCameraRequest struct {
 	Name   string         `json:"name,omitempty"`
 	Config map[string]any `json:"config,omitempty"`
@@ -99,17 +463,314 @@ type VideoFile struct {
 	Size     int64  `json:"size"`
 }
 
+// CameraTimeline lays a camera's recordings for one date out on a
+// continuous day. Times are seconds since midnight, wall clock as in the
+// recordings' file names.
+type CameraTimeline struct {
+	CameraID    string            `json:"camera_id"`
+	Date        string            `json:"date"`
+	DaySec      float64           `json:"day_sec"`      // 86400, or the time so far for today
+	RecordedSec float64           `json:"recorded_sec"` // covered by at least one recording
+	Segments    []TimelineSegment `json:"segments"`     // by start time; may overlap
+	Gaps        []TimelineGap     `json:"gaps"`
+	Untimed     []string          `json:"untimed,omitempty"` // files whose name has no start time
+}
+
+type TimelineSegment struct {
+	VideoID  string  `json:"video_id"`
+	VideoURL string  `json:"video_url"`
+	Filename string  `json:"filename"`
+	StartSec float64 `json:"start_sec"`
+	EndSec   float64 `json:"end_sec"`
+	// Where the recording begins in the video: 0 but in a day archive
+	VideoStartSec float64 `json:"video_start_sec,omitempty"`
+}
+
+type TimelineGap struct {
+	StartSec float64 `json:"start_sec"`
+	EndSec   float64 `json:"end_sec"`
+}
+
+// TimelinePosition resolves a wall-clock time to the recording covering it
+// and the offset to seek to, or to the gap it falls in.
+type TimelinePosition struct {
+	CameraID  string           `json:"camera_id"`
+	At        string           `json:"at"`
+	VideoID   string           `json:"video_id,omitempty"`
+	VideoURL  string           `json:"video_url,omitempty"`
+	OffsetSec float64          `json:"offset_sec"`
+	Segment   *TimelineSegment `json:"segment,omitempty"`
+	Gap       *TimelineGap     `json:"gap,omitempty"`
+}
+
 type CameraDateStats struct {
 	Date       string `json:"date"`
 	VideoCount int    `json:"video_count"`
 	FrameCount int    `json:"frame_count"`
 }
 
+// CameraStats aggregates storage and indexing figures for one camera.
+type CameraStats struct {
+	CameraID          string     `json:"camera_id"`
+	Name              string     `json:"name,omitempty"`
+	VideoCount        int        `json:"video_count"`
+	VideoBytes        int64      `json:"video_bytes"`
+	FrameCount        int        `json:"frame_count"`
+	IndexedFrames     int        `json:"indexed_frames"`
+	ClipEmbeddings    int        `json:"clip_embeddings"`
+	FaceEmbeddings    int        `json:"face_embeddings"`
+	LastProcessedDate string     `json:"last_processed_date,omitempty"`
+	LastIndexedAt     *time.Time `json:"last_indexed_at,omitempty"`
+}
+
+// SystemStats is CameraStats for every camera plus workspace-wide totals.
+type SystemStats struct {
+	Cameras        []CameraStats `json:"cameras"`
+	VideoCount     int           `json:"video_count"`
+	VideoBytes     int64         `json:"video_bytes"`
+	FrameCount     int           `json:"frame_count"`
+	IndexedFrames  int           `json:"indexed_frames"`
+	ClipEmbeddings int           `json:"clip_embeddings"`
+	FaceEmbeddings int           `json:"face_embeddings"`
+	DBBytes        int64         `json:"db_bytes"`
+}
+
+// ServerStats is SystemStats plus what only the running server knows: its
+// cameras by type and status, active jobs and streams, and the sidecar's
+// model, for status pages.
+type ServerStats struct {
+	SystemStats
+	CamerasByType   map[string]int `json:"cameras_by_type"`
+	CamerasByStatus map[string]int `json:"cameras_by_status"`
+	// ActiveJobs counts the running and queued jobs by type
+	ActiveJobs    map[string]int `json:"active_jobs"`
+	ActiveStreams int            `json:"active_streams"`
+	Sidecar       SidecarStats   `json:"sidecar"`
+}
+
+// SidecarStats is the CLIP model the encoder serves, or why it couldn't
+// be asked.
+type SidecarStats struct {
+	Status       string `json:"status"` // "ok", "error"
+	Error        string `json:"error,omitempty"`
+	Preset       string `json:"preset,omitempty"`
+	Model        string `json:"model,omitempty"`
+	EmbeddingDim int    `json:"embedding_dim,omitempty"`
+}
+
+// DailyReport summarizes a day of one camera, or of every camera, for a
+// morning review: what was recorded and indexed, the alerts, when it was
+// busiest, what was seen and the storage it took.
+type DailyReport struct {
+	Date            string        `json:"date"`
+	CameraID        string        `json:"camera_id,omitempty"`
+	Cameras         []string      `json:"cameras"`
+	Videos          int           `json:"videos"`
+	FramesExtracted int           `json:"frames_extracted"`
+	FramesIndexed   int           `json:"frames_indexed"`
+	Faces           int           `json:"faces"`
+	Alerts          ReportAlerts  `json:"alerts"`
+	Hours           []ReportHour  `json:"hours"`
+	BusiestHours    []int         `json:"busiest_hours"`
+	TopClasses      []ReportClass `json:"top_classes"`
+	// ClassesError says why TopClasses is empty when the encoder couldn't
+	// be asked, e.g. with the ML sidecar down
+	ClassesError string        `json:"classes_error,omitempty"`
+	Storage      ReportStorage `json:"storage"`
+	// Context is the day's sun times and weather at the site, with
+	// enrichment configured
+	Context *DayContext `json:"context,omitempty"`
+}
+
+// ReportAlerts counts a day's alerts.
+type ReportAlerts struct {
+	Total    int            `json:"total"`
+	ByRule   map[string]int `json:"by_rule"`
+	ByStatus map[string]int `json:"by_status"`
+}
+
+// ReportHour is one hour of a DailyReport. Frames are the extracted frames
+// left after deduplication, so they follow how much changed on camera.
+type ReportHour struct {
+	Hour   int `json:"hour"`
+	Frames int `json:"frames"`
+	Alerts int `json:"alerts"`
+	// Light is the light at the middle of the hour, with enrichment
+	// configured: "day", "twilight" or "night"
+	Light string `json:"light,omitempty"`
+}
+
+// ReportClass is how many of a day's indexed frames show an object class,
+// by CLIP zero-shot classification.
+type ReportClass struct {
+	Class  string `json:"class"`
+	Frames int    `json:"frames"`
+}
+
+// ReportStorage is the disk space a day took, in bytes.
+type ReportStorage struct {
+	VideoBytes     int64 `json:"video_bytes"`
+	FrameBytes     int64 `json:"frame_bytes"`
+	EmbeddingBytes int64 `json:"embedding_bytes"`
+	TotalBytes     int64 `json:"total_bytes"`
+}
+
 type SettingsResponse struct {
 	Settings map[string]any `json:"settings"`
 	Defaults map[string]any `json:"defaults"`
+	// RestartRequired lists changed settings that apply only after a restart
+	RestartRequired []string `json:"restart_required"`
+}
+
+// SettingSchema describes one runtime setting for GET /api/settings/schema.
+// Min and Max are set for numeric settings only.
+type SettingSchema struct {
+	Key         string   `json:"key"`
+	Type        string   `json:"type"` // "string", "int", "float", "bool" or "time" (HH:MM)
+	Category    string   `json:"category"`
+	Default     any      `json:"default"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	Secret      bool     `json:"secret,omitempty"`
+	Restart     bool     `json:"restart,omitempty"`
+	Description string   `json:"description"`
+}
+
+// NotificationChannelInfo is one notification channel in GET
+// /api/settings/notifications.
+type NotificationChannelInfo struct {
+	Name       string `json:"name"` // "email", "telegram", "slack" or "webhook"
+	Configured bool   `json:"configured"`
+}
+
+// SettingsChangeEvent is streamed by GET /api/settings/events whenever
+// settings change, through the API, an import or a reload.
+type SettingsChangeEvent struct {
+	Keys            []string  `json:"keys"`
+	ChangedAt       time.Time `json:"changed_at"`
+	RestartRequired []string  `json:"restart_required"`
 }
 
 type SettingsUpdateRequest struct {
 	Settings map[string]any `json:"settings"`
 }
+
+// SettingsExport is a portable copy of a workspace's settings, for moving a
+// tuned configuration to another installation. Secrets are masked in
+// Settings, or moved to Encrypted (AES-GCM, keyed by a passphrase and Salt)
+// when the export was given a passphrase.
+type SettingsExport struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Settings   map[string]any    `json:"settings"`
+	Encrypted  map[string]string `json:"encrypted,omitempty"`
+	Salt       string            `json:"salt,omitempty"`
+}
+
+// ComponentHealth is the status of a single dependency in the health report.
+// Status is "ok", "warning", "error", or "not_configured".
+type ComponentHealth struct {
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type HealthResponse struct {
+	Status        string                     `json:"status"` // "ok" or "degraded"
+	MLSidecar     string                     `json:"ml_sidecar"`
+	Components    map[string]ComponentHealth `json:"components"`
+	ActiveJobs    int                        `json:"active_jobs"`
+	ActiveUploads int                        `json:"active_uploads"`
+	ActiveStreams int                        `json:"active_streams"`
+}
+
+// Workspace is an isolated tenant with its own cameras, footage, settings
+// and database. The "default" workspace uses the top-level data paths.
+type Workspace struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	DataDir   string `json:"data_dir"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+type CreateWorkspaceRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UploadSession tracks a resumable chunked upload. Offset is the number of
+// bytes received so far; the upload can be finalized once Offset == Size.
+type UploadSession struct {
+	ID        string `json:"upload_id"`
+	CameraID  string `json:"camera_id"`
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	Offset    int64  `json:"offset"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type CreateUploadRequest struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+type FinalizeUploadRequest struct {
+	UploadIDs []string `json:"upload_ids"`
+}
+
+// ImportURLRequest is the body of POST /cameras/{id}/import. Filename
+// names the saved video when the URL path doesn't end in one.
+type ImportURLRequest struct {
+	URL      string `json:"url"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// UploadFileResult reports whether a single uploaded file was accepted.
+// Rejected files are removed again; Error says why. A duplicate of a stored
+// video isn't accepted either: DuplicateOf names that video.
+type UploadFileResult struct {
+	Filename    string  `json:"filename"`
+	Accepted    bool    `json:"accepted"`
+	Path        string  `json:"path,omitempty"`
+	Codec       string  `json:"codec,omitempty"`
+	DurationSec float64 `json:"duration_sec,omitempty"`
+	DuplicateOf string  `json:"duplicate_of,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// DayContext is the outside context of a day at the cameras' site: when the
+// sun rose and set, and the weather hour by hour. Times are RFC 3339 in
+// general.timezone; a sun time is empty on a day the sun doesn't cross
+// that altitude, as in a polar summer or winter.
+type DayContext struct {
+	Date    string `json:"date"`
+	Dawn    string `json:"dawn,omitempty"` // civil twilight begins
+	Sunrise string `json:"sunrise,omitempty"`
+	Sunset  string `json:"sunset,omitempty"`
+	Dusk    string `json:"dusk,omitempty"` // civil twilight ends
+	// Weather is empty without a weather provider; WeatherError says why
+	// it is when the provider couldn't be asked
+	Weather      []WeatherHour `json:"weather"`
+	WeatherError string        `json:"weather_error,omitempty"`
+}
+
+// WeatherHour is the weather of one hour of a DayContext, as its provider
+// reports it. A value the provider had no data for is left out.
+type WeatherHour struct {
+	Hour            int      `json:"hour"`
+	TemperatureC    *float64 `json:"temperature_c,omitempty"`
+	PrecipitationMM *float64 `json:"precipitation_mm,omitempty"`
+	CloudCoverPct   *float64 `json:"cloud_cover_pct,omitempty"`
+	// Code is the WMO weather code, Conditions its short description
+	// (e.g. "fog", "rain")
+	Code       *int   `json:"code,omitempty"`
+	Conditions string `json:"conditions,omitempty"`
+}
+
+// FrameContext is the outside context of one frame: the light, "day",
+// "twilight" or "night", and the weather of its hour.
+type FrameContext struct {
+	Light   string       `json:"light"`
+	Weather *WeatherHour `json:"weather,omitempty"`
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// jsonOutput is set by --json (before the command) or a command's -json
+// flag. Results then go to stdout as JSON and human-readable progress moves
+// to stderr, so stdout can be piped straight into jq or another program.
+var jsonOutput bool
+
+func addJSONFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&jsonOutput, "json", jsonOutput, "write machine-readable JSON to stdout")
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("writing JSON: %v", err)
+	}
+}
+
+// printJSONLine writes v to stdout as a single line of JSON, for commands
+// that stream one object per progress event (NDJSON).
+func printJSONLine(v any) {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		log.Fatalf("writing JSON: %v", err)
+	}
+}
+
+// statusf prints a human-readable status line: to stdout normally, to
+// stderr when stdout carries JSON.
+func statusf(format string, args ...any) {
+	out := os.Stdout
+	if jsonOutput {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, format, args...)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/services"
+)
+
+// processUnit is one camera+date handed to a worker.
+type processUnit struct {
+	cameraID string
+	date     string
+}
+
+func runProcess(args []string) {
+	fs := flag.NewFlagSet("process", flag.ExitOnError)
+	camera := fs.String("camera", "", "camera ID, comma-separated for several")
+	allCameras := fs.Bool("all-cameras", false, "process every camera")
+	date := fs.String("date", "", "single date in YYYY-MM-DD format (same as -start X -end X)")
+	start := fs.String("start", "", "first date of the range (YYYY-MM-DD)")
+	end := fs.String("end", "", "last date of the range (default: -start)")
+	startHour := fs.Int("start-hour", 0, "process only from this hour (0-23) of each date")
+	endHour := fs.Int("end-hour", 24, "process only up to this hour (1-24) of each date")
+	parallel := fs.Int("parallel", 1, "number of camera+date combinations processed at once")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+	parseFlags(fs, args)
+
+	if *date != "" {
+		if *start != "" || *end != "" {
+			log.Fatalf("-date cannot be combined with -start/-end")
+		}
+		*start, *end = *date, *date
+	}
+	if (*camera == "") == !*allCameras || *start == "" {
+		fmt.Fprintln(os.Stderr, "error: one of -camera or -all-cameras, and -date or -start, are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *end == "" {
+		*end = *start
+	}
+	if *parallel < 1 {
+		log.Fatalf("-parallel must be at least 1")
+	}
+	hours, err := services.NewHourWindow(startHour, endHour)
+	if err != nil {
+		log.Fatalf("-start-hour/-end-hour: %v", err)
+	}
+	dates, err := services.DateRange(*start, *end)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(dates) == 0 {
+		log.Fatalf("-end is before -start")
+	}
+
+	cfg := loadAppConfig()
+
+	storage, err := services.NewStorage(cfg.Storage.DBPath)
+	if err != nil {
+		log.Fatalf("opening storage: %v", err)
+	}
+	defer storage.Close()
+	settings := services.NewSettingsService(storage.DB(), cfg)
+	cameraSvc := services.NewCameraService(storage.DB(), cfg)
+
+	var cameraIDs []string
+	if *allCameras {
+		cameras, err := cameraSvc.List()
+		if err != nil {
+			log.Fatalf("listing cameras: %v", err)
+		}
+		for _, cam := range cameras {
+			cameraIDs = append(cameraIDs, cam.ID)
+		}
+		if len(cameraIDs) == 0 {
+			log.Fatalf("no cameras configured")
+		}
+	} else {
+		for _, id := range strings.Split(*camera, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				cameraIDs = append(cameraIDs, id)
+			}
+		}
+	}
+
+	mlClient := newMLClient(cfg)
+	encoder := newEncoder(cfg, mlClient, settings.Get("clip.model"))
+	if _, ok := encoder.(*services.MLClient); ok {
+		statusf("Waiting for ML sidecar at %s...\n", mlClient.BaseURL())
+	}
+	if err := encoder.WaitForReady(encoder.ReadyTimeout()); err != nil {
+		log.Fatalf("ML sidecar not ready: %v", err)
+	}
+
+	events := make(chan services.ProgressEvent, 64)
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		for ev := range events {
+			printProgress(ev)
+		}
+	}()
+
+	// Hikvision recordings are fetched first, one camera at a time, exactly
+	// as a POST /api/process would; NVRs handle parallel playback poorly.
+	for _, id := range cameraIDs {
+		cam, err := cameraSvc.Get(id)
+		if err == nil && cam.Type == "hikvision" {
+			if services.DownloadFromNVR(context.Background(), cfg, settings, cam, dates, "", "", hours, false, events) > 0 {
+				cameraSvc.InvalidateThumbnail(cam.ID)
+			}
+		}
+	}
+
+	units := make(chan processUnit)
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+	)
+	pipeline := services.NewPipeline(encoder, storage, settings.GetInt("clip.batch_size"))
+	opts := services.ExtractionOptionsFromSettings(settings)
+	opts.Hours = hours
+	for i := 0; i < *parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range units {
+				if err := services.ProcessCameraDate(cfg, pipeline, opts, u.cameraID, u.date, events); err != nil {
+					mu.Lock()
+					failed = append(failed, u.cameraID+"/"+u.date)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, id := range cameraIDs {
+		for _, d := range dates {
+			units <- processUnit{cameraID: id, date: d}
+		}
+	}
+	close(units)
+	wg.Wait()
+	close(events)
+	<-printed
+
+	if jsonOutput {
+		printJSONLine(processSummary{Stage: "done", CameraIDs: cameraIDs, Dates: dates, Failed: append([]string{}, failed...)})
+	}
+	if len(failed) > 0 {
+		log.Fatalf("processing failed for %s", strings.Join(failed, ", "))
+	}
+	statusf("Processed %d camera(s) x %d date(s)\n", len(cameraIDs), len(dates))
+}
+
+// processSummary is the last line of 'process -json' output.
+type processSummary struct {
+	Stage     string   `json:"stage"`
+	CameraIDs []string `json:"camera_ids"`
+	Dates     []string `json:"dates"`
+	Failed    []string `json:"failed"`
+}
+
+// printProgress prints a pipeline event, as one JSON line with -json.
+func printProgress(ev services.ProgressEvent) {
+	if jsonOutput {
+		printJSONLine(ev)
+		return
+	}
+	prefix := fmt.Sprintf("[%s]", ev.Stage)
+	if ev.CameraID != "" {
+		prefix += " " + ev.CameraID + ":"
+	}
+	// Jobs of a running server report their overall progress
+	var overall string
+	if ev.Percent > 0 {
+		overall = fmt.Sprintf(" (%.1f%%", ev.Percent)
+		if ev.ETASec > 0 {
+			overall += fmt.Sprintf(", ~%s left", time.Duration(ev.ETASec)*time.Second)
+		}
+		overall += ")"
+	}
+	if ev.FramesTotal > 0 {
+		fmt.Printf("%s %s — %d/%d frames%s\n", prefix, ev.Message, ev.FramesDone, ev.FramesTotal, overall)
+	} else {
+		fmt.Printf("%s %s%s\n", prefix, ev.Message, overall)
+	}
+}
+
+// newEncoder returns the encoder mlservice.backend picks for a CLI command.
+// The embedded ONNX encoder loads preset, the clip.model the index is built
+// with, as the server does at startup.
+// newMLClient returns a client for the ML sidecar the server uses: the
+// ml.* settings of the default workspace say where it is, mlservice.url
+// when there are none. Their database is only read.
+func newMLClient(cfg *config.AppConfig) *services.MLClient {
+	mlClient := services.NewMLClient(cfg.MLService.URL)
+	if _, err := os.Stat(defaultDBPath); err != nil {
+		return mlClient
+	}
+	storage, err := services.OpenStorageReadOnly(defaultDBPath)
+	if err != nil {
+		return mlClient
+	}
+	defer storage.Close()
+	mlClient.Configure(services.MLConfigFromSettings(services.NewSettingsService(storage.DB(), cfg), cfg))
+	return mlClient
+}
+
+func newEncoder(cfg *config.AppConfig, mlClient *services.MLClient, preset string) services.Encoder {
+	encoder, err := services.NewEncoder(cfg, mlClient)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if _, ok := encoder.(*services.ONNXEncoder); ok && preset != "" {
+		if _, err := encoder.ReloadModel(preset); err != nil {
+			log.Fatalf("loading CLIP model %s: %v", preset, err)
+		}
+	}
+	return encoder
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+// searchRow is one result as written by -format json/csv.
+type searchRow struct {
+	Rank int `json:"rank"`
+	models.SearchResult
+	CopiedTo string `json:"copied_to,omitempty"`
+}
+
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	text := fs.String("text", "", "text query (required)")
+	camera := fs.String("camera", "", "camera ID filter, comma-separated for several (optional)")
+	start := fs.String("start", "", "only frames at or after this time (YYYY-MM-DD or YYYY-MM-DDTHH:MM[:SS])")
+	end := fs.String("end", "", "only frames at or before this time (a date alone means end of day)")
+	limit := fs.Int("limit", 20, "max results")
+	minScore := fs.Float64("min-score", 0.18, "minimum similarity score (0.0-1.0)")
+	format := fs.String("format", "table", "output format: table, json or csv")
+	copyTo := fs.String("copy-to", "", "copy matched frames into this directory")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+	parseFlags(fs, args)
+
+	if *text == "" {
+		fmt.Fprintln(os.Stderr, "error: -text flag is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if jsonOutput {
+		*format = "json"
+	}
+	switch *format {
+	case "table", "json", "csv":
+	default:
+		log.Fatalf("invalid -format %q: must be table, json or csv", *format)
+	}
+	if *minScore < 0 || *minScore > 1 {
+		log.Fatalf("invalid -min-score %v: must be between 0.0 and 1.0", *minScore)
+	}
+
+	cfg := loadAppConfig()
+	startTime, endTime, err := searchTimeRange(cfg.Storage.DBPath, *start, *end)
+	if err != nil {
+		log.Fatalf("invalid -start/-end: %v", err)
+	}
+
+	// The embedded ONNX encoder has to load the model the index was built
+	// with; the database is only read
+	var preset string
+	if cfg.MLService.Backend == services.BackendONNX {
+		if storage, err := services.OpenStorageReadOnly(cfg.Storage.DBPath); err == nil {
+			storage.DB().QueryRow("SELECT value FROM settings WHERE key = 'clip.model'").Scan(&preset)
+			storage.Close()
+		}
+	}
+	encoder := newEncoder(cfg, newMLClient(cfg), preset)
+	if err := encoder.HealthCheck(); err != nil {
+		log.Fatalf("ML sidecar health check failed: %v", err)
+	}
+
+	var cameraIDs []string
+	for _, id := range strings.Split(*camera, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			cameraIDs = append(cameraIDs, id)
+		}
+	}
+
+	results, err := encoder.SearchByText(cfg.Storage.DBPath, *text, cameraIDs, startTime, endTime, *limit, *minScore)
+	if err != nil {
+		log.Fatalf("search failed: %v", err)
+	}
+
+	rows := make([]searchRow, len(results))
+	for i, r := range results {
+		rows[i] = searchRow{Rank: i + 1, SearchResult: r}
+	}
+
+	if *copyTo != "" {
+		if err := os.MkdirAll(*copyTo, 0o755); err != nil {
+			log.Fatalf("creating %s: %v", *copyTo, err)
+		}
+		for i := range rows {
+			src := resolveFramePath(cfg, rows[i].FramePath)
+			dst := filepath.Join(*copyTo, fmt.Sprintf("%03d_%s_%s", rows[i].Rank, rows[i].CameraID, filepath.Base(src)))
+			if err := services.CopyFile(src, dst); err != nil {
+				log.Printf("WARNING: copying frame %s: %v", src, err)
+				continue
+			}
+			rows[i].CopiedTo = dst
+		}
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			log.Fatalf("writing JSON: %v", err)
+		}
+	case "csv":
+		cw := csv.NewWriter(os.Stdout)
+		cw.Write([]string{"rank", "score", "id", "camera_id", "timestamp", "frame_path", "source_video", "copied_to"})
+		for _, r := range rows {
+			cw.Write([]string{strconv.Itoa(r.Rank), strconv.FormatFloat(r.Score, 'f', 4, 64), r.ID,
+				r.CameraID, r.Timestamp, r.FramePath, r.SourceVideo, r.CopiedTo})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Fatalf("writing CSV: %v", err)
+		}
+	default:
+		fmt.Printf("Results for query: %q\n\n", *text)
+		fmt.Print(services.FormatResultsTable(results))
+		if *copyTo != "" {
+			copied := 0
+			for _, r := range rows {
+				if r.CopiedTo != "" {
+					copied++
+				}
+			}
+			fmt.Printf("\nCopied %d frames to %s\n", copied, *copyTo)
+		}
+	}
+}
+
+// searchTimeRange validates -start/-end and converts them to the stored
+// UTC form the search filters compare against. Dates and times without a
+// zone are read in the general.timezone of the database at dbPath; a date
+// alone as -end means the end of that day.
+func searchTimeRange(dbPath, start, end string) (string, string, error) {
+	if start == "" && end == "" {
+		return "", "", nil
+	}
+	loc := time.Local
+	if storage, err := services.OpenStorageReadOnly(dbPath); err == nil {
+		var zone string
+		storage.DB().QueryRow("SELECT value FROM settings WHERE key = 'general.timezone'").Scan(&zone)
+		storage.Close()
+		if l, err := time.LoadLocation(zone); err == nil && zone != "" {
+			loc = l
+		}
+	}
+	return services.TimeRange(start, end, loc)
+}
+
+// resolveFramePath maps a stored frame_path, which may be absolute or
+// relative to the data directory, to a file on disk.
+func resolveFramePath(cfg *config.AppConfig, framePath string) string {
+	if filepath.IsAbs(framePath) {
+		if _, err := os.Stat(framePath); err == nil {
+			return framePath
+		}
+	}
+	fp := filepath.ToSlash(framePath)
+	if idx := strings.Index(fp, "frames/"); idx >= 0 {
+		return filepath.Join(cfg.Extraction.StoragePath, filepath.FromSlash(fp[idx+len("frames/"):]))
+	}
+	return framePath
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/intelsk/backend/models"
+)
+
+const (
+	// clipAttachmentTimeout bounds cutting the clip of one alert.
+	clipAttachmentTimeout = 2 * time.Minute
+	// maxClipAttachmentBytes keeps clips under the attachment limits of
+	// common mail servers; a larger clip is left out.
+	maxClipAttachmentBytes = 20 << 20
+)
+
+// attachments returns the files sent with the notification of a: with
+// notify.attach_frame its matched frame, and with notify.attach_clip a clip
+// of notify.clip_sec seconds each side of it cut from its video. A file
+// that can't be read or cut is left out, so the alert is still notified.
+func (s *AlertService) attachments(a models.Alert) []Attachment {
+	var files []Attachment
+	if a.FramePath != "" && s.settings.GetBool("notify.attach_frame") {
+		data, err := os.ReadFile(a.FramePath)
+		if err != nil {
+			log.Printf("Alert %s: reading frame: %v", a.ID, err)
+		} else {
+			files = append(files, Attachment{Name: filepath.Base(a.FramePath), ContentType: "image/jpeg", Data: data})
+		}
+	}
+	if a.SourceVideo != "" && a.Timestamp != "" && s.settings.GetBool("notify.attach_clip") {
+		clip, err := s.clip(a)
+		if err != nil {
+			log.Printf("Alert %s: cutting clip: %v", a.ID, err)
+		} else if clip != nil {
+			files = append(files, *clip)
+		}
+	}
+	return files
+}
+
+// clip cuts the clip attachment of a, or returns nil when it is too large
+// to send.
+func (s *AlertService) clip(a models.Alert) (*Attachment, error) {
+	if _, err := os.Stat(a.SourceVideo); err != nil {
+		return nil, err
+	}
+	pad := s.settings.GetInt("notify.clip_sec")
+	offset := SeekOffset(a.Timestamp, a.SourceVideo)
+	start, end := max(offset-pad, 0), offset+pad
+
+	tmp, err := os.CreateTemp("", "intelsk-alert-*.mp4")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	ctx, cancel := context.WithTimeout(context.Background(), clipAttachmentTimeout)
+	defer cancel()
+	if err := ExtractClip(ctx, a.SourceVideo, tmp.Name(), float64(start), float64(end)); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxClipAttachmentBytes {
+		log.Printf("Alert %s: clip of %d MB is too large to attach", a.ID, len(data)>>20)
+		return nil, nil
+	}
+	return &Attachment{
+		Name:        fmt.Sprintf("%s_%s.mp4", a.CameraID, strings.ReplaceAll(LocalTimestamp(a.Timestamp, s.settings.Location()), ":", "")),
+		ContentType: "video/mp4",
+		Data:        data,
+	}, nil
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/intelsk/backend/models"
+)
+
+// Alert statuses. An operator acknowledges an alert they are dealing with,
+// or dismisses one that needs nothing.
+const (
+	AlertNew          = "new"
+	AlertAcknowledged = "acknowledged"
+	AlertDismissed    = "dismissed"
+)
+
+// AlertFilter selects alerts for AlertService.List. Empty fields match
+// every alert; dates are inclusive YYYY-MM-DD bounds.
+type AlertFilter struct {
+	CameraID  string
+	Rule      string
+	Status    string
+	StartDate string
+	EndDate   string
+}
+
+// AlertService keeps the history of a workspace's alerts in the alerts
+// table and notifies the configured channels of each new one, or of a
+// digest of them when the rule's quiet hours or cooldown hold them back.
+type AlertService struct {
+	db       *sql.DB
+	settings *SettingsService
+	notifier *Notifier
+
+	// failing holds the checks reported to Track as failing, by rule and
+	// camera
+	mu      sync.Mutex
+	failing map[string]bool
+
+	// throttleMu orders the cooldown checks of new alerts with the digests
+	throttleMu sync.Mutex
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewAlertService(db *sql.DB, settings *SettingsService, notifier *Notifier) *AlertService {
+	return &AlertService{
+		db:       db,
+		settings: settings,
+		notifier: notifier,
+		failing:  make(map[string]bool),
+		stop:     make(chan struct{}),
+	}
+}
+
+const alertColumns = `id, rule, camera_id, status, title, message, date, timestamp, frame_path, source_video,
+	created_at, updated_at, acknowledged_at, group_id`
+
+func scanAlert(row interface{ Scan(...any) error }) (models.Alert, error) {
+	var a models.Alert
+	var createdAt, updatedAt string
+	var acknowledgedAt sql.NullString
+	if err := row.Scan(&a.ID, &a.Rule, &a.CameraID, &a.Status, &a.Title, &a.Message, &a.Date,
+		&a.Timestamp, &a.FramePath, &a.SourceVideo, &createdAt, &updatedAt, &acknowledgedAt, &a.GroupID); err != nil {
+		return a, err
+	}
+	a.CreatedAt, _ = time.Parse(jobTimeLayout, createdAt)
+	a.UpdatedAt, _ = time.Parse(jobTimeLayout, updatedAt)
+	if acknowledgedAt.Valid {
+		t, _ := time.Parse(jobTimeLayout, acknowledgedAt.String)
+		a.AcknowledgedAt = &t
+	}
+	return a, nil
+}
+
+// Raise records a new alert and notifies the configured channels of it,
+// unless its rule is in its quiet hours or its rule and camera notified
+// within the cooldown; then it is held for the rule's next digest. An alert
+// with a Timestamp within alerts.group_window_sec of a new alert of the same
+// rule on another camera is grouped into that one as a further view of the
+// event, and not notified. Rule and Title are required; an alert without a
+// Date gets today's, in the general.timezone zone.
+func (s *AlertService) Raise(a models.Alert) (models.Alert, error) {
+	if a.Rule == "" || a.Title == "" {
+		return a, newError(ErrInvalidInput, "an alert needs a rule and a title")
+	}
+	if a.Date == "" {
+		if t, err := time.Parse(time.RFC3339, a.Timestamp); err == nil {
+			a.Date = t.In(s.settings.Location()).Format("2006-01-02")
+		} else {
+			a.Date = s.settings.Now().Format("2006-01-02")
+		}
+	}
+	now := time.Now().UTC()
+	a.ID = uuid.New().String()
+	a.Status = AlertNew
+	a.CreatedAt, a.UpdatedAt = now, now
+	a.AcknowledgedAt = nil
+
+	s.throttleMu.Lock()
+	a.GroupID = s.findGroup(a)
+	state, notifiedAt := notifySent, sql.NullString{String: now.Format(jobTimeLayout), Valid: true}
+	switch {
+	case a.GroupID != "":
+		state, notifiedAt = notifyGrouped, sql.NullString{}
+	case s.holdNotification(a.Rule, a.CameraID, now):
+		state, notifiedAt = notifyHeld, sql.NullString{}
+	}
+	_, err := s.db.Exec(`INSERT INTO alerts (id, rule, camera_id, status, title, message, date, timestamp,
+		frame_path, source_video, created_at, updated_at, notify_state, notified_at, group_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.Rule, a.CameraID, a.Status, a.Title, a.Message, a.Date, a.Timestamp,
+		a.FramePath, a.SourceVideo, now.Format(jobTimeLayout), now.Format(jobTimeLayout), state, notifiedAt, a.GroupID)
+	s.throttleMu.Unlock()
+	if err != nil {
+		return a, fmt.Errorf("inserting alert: %w", err)
+	}
+	if state != notifySent {
+		return a, nil
+	}
+
+	n := Notification{
+		Event:    "alert",
+		Title:    a.Title,
+		Message:  a.Message,
+		CameraID: a.CameraID,
+		AlertID:  a.ID,
+		Time:     now,
+	}
+	if a.FramePath == "" {
+		s.notifier.Notify(n)
+		return a, nil
+	}
+	// Reading the frame and cutting the clip must not hold up the caller
+	go func() {
+		n.Attachments = s.attachments(a)
+		s.notifier.Notify(n)
+	}()
+	return a, nil
+}
+
+// List returns the alerts matching f, newest first, with their views. An
+// alert grouped into another is listed as a view of that one, which
+// CameraID matches when any of its views is from the camera.
+func (s *AlertService) List(f AlertFilter) ([]models.Alert, error) {
+	where := []string{"group_id = ''"}
+	var args []any
+	if f.CameraID != "" {
+		where = append(where, "(camera_id = ? OR id IN (SELECT group_id FROM alerts WHERE camera_id = ?))")
+		args = append(args, f.CameraID, f.CameraID)
+	}
+	for _, c := range []struct{ column, value string }{
+		{"rule = ?", f.Rule},
+		{"status = ?", f.Status},
+		{"date >= ?", f.StartDate},
+		{"date <= ?", f.EndDate},
+	} {
+		if c.value != "" {
+			where = append(where, c.column)
+			args = append(args, c.value)
+		}
+	}
+	cond := strings.Join(where, " AND ")
+	alerts, err := s.query("SELECT "+alertColumns+" FROM alerts WHERE "+cond+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	if alerts == nil {
+		alerts = []models.Alert{}
+	}
+
+	views, err := s.query("SELECT "+alertColumns+" FROM alerts WHERE group_id IN (SELECT id FROM alerts WHERE "+
+		cond+") ORDER BY timestamp", args...)
+	if err != nil {
+		return nil, err
+	}
+	byGroup := make(map[string][]models.Alert)
+	for _, v := range views {
+		byGroup[v.GroupID] = append(byGroup[v.GroupID], v)
+	}
+	for i := range alerts {
+		alerts[i].Views = byGroup[alerts[i].ID]
+	}
+	return alerts, nil
+}
+
+func (s *AlertService) query(query string, args ...any) ([]models.Alert, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var alerts []models.Alert
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning alert row: %w", err)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// findGroup returns the alert a is to be grouped into: the earliest new,
+// ungrouped alert of the same rule on another camera whose timestamp is
+// within alerts.group_window_sec of a's, or "" when there is none. Frame
+// matches group only with matches of the same query, which their title
+// names.
+func (s *AlertService) findGroup(a models.Alert) string {
+	window := time.Duration(s.settings.GetInt("alerts.group_window_sec")) * time.Second
+	if window == 0 || a.Timestamp == "" {
+		return ""
+	}
+	t, err := time.Parse(time.RFC3339, a.Timestamp)
+	if err != nil {
+		return ""
+	}
+	title := ""
+	if a.Rule == RuleFrameMatch {
+		title = a.Title
+	}
+	var id string
+	err = s.db.QueryRow(`SELECT id FROM alerts WHERE rule = ? AND camera_id != ? AND group_id = '' AND status = ?
+		AND timestamp BETWEEN ? AND ? AND (? = '' OR title = ?) ORDER BY timestamp LIMIT 1`,
+		a.Rule, a.CameraID, AlertNew, FormatTimestamp(t.Add(-window)), FormatTimestamp(t.Add(window)),
+		title, title).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Grouping %s alert: %v", a.Rule, err)
+	}
+	return id
+}
+
+// Get returns a single alert with its views.
+func (s *AlertService) Get(id string) (models.Alert, error) {
+	a, err := scanAlert(s.db.QueryRow("SELECT "+alertColumns+" FROM alerts WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return a, newError(ErrAlertNotFound, "alert %s not found", id)
+	}
+	if err != nil {
+		return a, fmt.Errorf("querying alert %s: %w", id, err)
+	}
+	a.Views, err = s.query("SELECT "+alertColumns+" FROM alerts WHERE group_id = ? ORDER BY timestamp", id)
+	return a, err
+}
+
+// SetStatus moves an alert and its views to status: acknowledged or
+// dismissed, or back to new. The first move out of new records when the
+// alert was acknowledged.
+func (s *AlertService) SetStatus(id, status string) (models.Alert, error) {
+	if !ValidAlertStatus(status) {
+		return models.Alert{}, newError(ErrInvalidInput, "unknown alert status %q", status)
+	}
+	now := time.Now().UTC().Format(jobTimeLayout)
+	res, err := s.db.Exec(`UPDATE alerts SET status = ?, updated_at = ?,
+		acknowledged_at = CASE WHEN ? != 'new' THEN COALESCE(acknowledged_at, ?) END WHERE id = ? OR group_id = ?`,
+		status, now, status, now, id, id)
+	if err != nil {
+		return models.Alert{}, fmt.Errorf("updating alert %s: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Alert{}, newError(ErrAlertNotFound, "alert %s not found", id)
+	}
+	return s.Get(id)
+}
+
+// ValidAlertStatus reports whether status is one of the alert statuses.
+func ValidAlertStatus(status string) bool {
+	return status == AlertNew || status == AlertAcknowledged || status == AlertDismissed
+}
+
+// Track records the outcome of a periodic check, like a camera answering,
+// for a monitor that runs it over and over. The first failure raises an
+// alert titled title, timestamped with the check so that cameras failing
+// together group, unless one of the same rule and camera from before a
+// restart is still new; the first success after a failure notifies the
+// channels that subject recovered.
+func (s *AlertService) Track(rule, cameraID, subject string, failure error, title string) {
+	key := rule + "/" + cameraID
+	s.mu.Lock()
+	wasFailing := s.failing[key]
+	s.failing[key] = failure != nil
+	s.mu.Unlock()
+
+	switch {
+	case failure != nil && !wasFailing:
+		open, err := s.List(AlertFilter{CameraID: cameraID, Rule: rule, Status: AlertNew})
+		if err == nil && len(open) > 0 {
+			return
+		}
+		if _, err := s.Raise(models.Alert{
+			Rule:      rule,
+			CameraID:  cameraID,
+			Title:     title,
+			Message:   failure.Error(),
+			Timestamp: FormatTimestamp(time.Now()),
+		}); err != nil {
+			log.Printf("raising %s alert: %v", rule, err)
+		}
+	case failure == nil && wasFailing:
+		s.notifier.Notify(Notification{
+			Event:    rule + "_recovered",
+			Title:    fmt.Sprintf("%s recovered", subject),
+			Message:  fmt.Sprintf("%s is working again.", subject),
+			CameraID: cameraID,
+		})
+	}
+}
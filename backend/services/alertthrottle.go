@@ -0,0 +1,297 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/intelsk/backend/models"
+)
+
+// Notification states of an alert. A held alert waits for the digest of its
+// rule; sent and digested alerts have been notified. A grouped alert is
+// never notified itself: the alert it was grouped into already was.
+const (
+	notifySent     = "sent"
+	notifyHeld     = "held"
+	notifyDigested = "digested"
+	notifyGrouped  = "grouped"
+)
+
+// digestMaxLines caps the alerts listed one by one in a digest.
+const digestMaxLines = 10
+
+// RuleSettings returns the throttling of rule: its own row in alert_rules,
+// or the alerts.quiet_start, alerts.quiet_end and alerts.cooldown_min
+// settings.
+func (s *AlertService) RuleSettings(rule string) (models.AlertRuleSettings, error) {
+	rs := models.AlertRuleSettings{Rule: rule}
+	err := s.db.QueryRow("SELECT quiet_start, quiet_end, cooldown_min FROM alert_rules WHERE rule = ?", rule).
+		Scan(&rs.QuietStart, &rs.QuietEnd, &rs.CooldownMin)
+	switch {
+	case err == sql.ErrNoRows:
+		rs.QuietStart = s.settings.Get("alerts.quiet_start")
+		rs.QuietEnd = s.settings.Get("alerts.quiet_end")
+		rs.CooldownMin = s.settings.GetInt("alerts.cooldown_min")
+	case err != nil:
+		return rs, fmt.Errorf("querying alert rule %s: %w", rule, err)
+	default:
+		rs.Custom = true
+	}
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM alerts WHERE rule = ? AND notify_state = ?", rule, notifyHeld).
+		Scan(&rs.Held); err != nil {
+		return rs, fmt.Errorf("counting held alerts of %s: %w", rule, err)
+	}
+	return rs, nil
+}
+
+// Rules returns the throttling of every rule that has raised an alert or
+// has settings of its own, by rule.
+func (s *AlertService) Rules() ([]models.AlertRuleSettings, error) {
+	rows, err := s.db.Query("SELECT rule FROM alert_rules UNION SELECT DISTINCT rule FROM alerts ORDER BY rule")
+	if err != nil {
+		return nil, fmt.Errorf("querying alert rules: %w", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning alert rule: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rules := make([]models.AlertRuleSettings, 0, len(names))
+	for _, name := range names {
+		rs, err := s.RuleSettings(name)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rs)
+	}
+	return rules, nil
+}
+
+// UpdateRule gives rule throttling of its own, starting from its current
+// settings and changing the fields set in req.
+func (s *AlertService) UpdateRule(rule string, req models.UpdateAlertRuleRequest) (models.AlertRuleSettings, error) {
+	rs, err := s.RuleSettings(rule)
+	if err != nil {
+		return rs, err
+	}
+	if req.QuietStart != nil {
+		rs.QuietStart = *req.QuietStart
+	}
+	if req.QuietEnd != nil {
+		rs.QuietEnd = *req.QuietEnd
+	}
+	if req.CooldownMin != nil {
+		rs.CooldownMin = *req.CooldownMin
+	}
+	for _, t := range []*string{&rs.QuietStart, &rs.QuietEnd} {
+		if *t == "" {
+			continue
+		}
+		parsed, err := time.Parse("15:04", *t)
+		if err != nil {
+			return rs, newError(ErrInvalidInput, "expected HH:MM, got %q", *t)
+		}
+		*t = parsed.Format("15:04")
+	}
+	if rs.CooldownMin < 0 || rs.CooldownMin > 1440 {
+		return rs, newError(ErrInvalidInput, "cooldown_min must be between 0 and 1440")
+	}
+
+	if _, err := s.db.Exec(`INSERT INTO alert_rules (rule, quiet_start, quiet_end, cooldown_min, updated_at)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT(rule) DO UPDATE SET quiet_start = excluded.quiet_start,
+		quiet_end = excluded.quiet_end, cooldown_min = excluded.cooldown_min, updated_at = excluded.updated_at`,
+		rule, rs.QuietStart, rs.QuietEnd, rs.CooldownMin, time.Now().UTC().Format(jobTimeLayout)); err != nil {
+		return rs, fmt.Errorf("saving alert rule %s: %w", rule, err)
+	}
+	return s.RuleSettings(rule)
+}
+
+// ResetRule drops the throttling of rule's own, back to the alerts.*
+// settings.
+func (s *AlertService) ResetRule(rule string) (models.AlertRuleSettings, error) {
+	if _, err := s.db.Exec("DELETE FROM alert_rules WHERE rule = ?", rule); err != nil {
+		return models.AlertRuleSettings{}, fmt.Errorf("deleting alert rule %s: %w", rule, err)
+	}
+	return s.RuleSettings(rule)
+}
+
+// quietHours returns the quiet hours of rs as a window of the day, like the
+// processing window.
+func (s *AlertService) quietHours(rs models.AlertRuleSettings) ProcessWindow {
+	return ProcessWindow{Start: rs.QuietStart, End: rs.QuietEnd, Location: s.settings.Location()}
+}
+
+// coolingDown reports whether an alert of rule on cameraID was notified,
+// alone or in a digest, less than cooldown before now.
+func (s *AlertService) coolingDown(rule, cameraID string, cooldown time.Duration, now time.Time) bool {
+	if cooldown <= 0 {
+		return false
+	}
+	var last sql.NullString
+	s.db.QueryRow("SELECT MAX(notified_at) FROM alerts WHERE rule = ? AND camera_id = ?", rule, cameraID).Scan(&last)
+	if !last.Valid {
+		return false
+	}
+	t, err := time.Parse(jobTimeLayout, last.String)
+	return err == nil && now.Sub(t) < cooldown
+}
+
+// holdNotification reports whether a new alert of rule on cameraID must
+// wait for a digest rather than notify now.
+func (s *AlertService) holdNotification(rule, cameraID string, now time.Time) bool {
+	rs, err := s.RuleSettings(rule)
+	if err != nil {
+		log.Printf("Alert throttling: %v", err)
+		return false
+	}
+	if s.quietHours(rs).Enabled() && s.quietHours(rs).Open(now) {
+		return true
+	}
+	return s.coolingDown(rule, cameraID, time.Duration(rs.CooldownMin)*time.Minute, now)
+}
+
+// sendDigests notifies the held alerts whose rule is out of its quiet hours
+// and whose camera is out of its cooldown, one digest per rule. Held alerts
+// an operator has already acknowledged or dismissed are dropped from it.
+func (s *AlertService) sendDigests() {
+	s.throttleMu.Lock()
+	defer s.throttleMu.Unlock()
+
+	held, err := s.heldAlerts()
+	if err != nil {
+		log.Printf("Alert digests: %v", err)
+		return
+	}
+	now := time.Now().UTC()
+	byRule := make(map[string][]models.Alert)
+	for _, a := range held {
+		byRule[a.Rule] = append(byRule[a.Rule], a)
+	}
+	for rule, alerts := range byRule {
+		rs, err := s.RuleSettings(rule)
+		if err != nil {
+			log.Printf("Alert digests: %v", err)
+			continue
+		}
+		if s.quietHours(rs).Enabled() && s.quietHours(rs).Open(now) {
+			continue
+		}
+		cooldown := time.Duration(rs.CooldownMin) * time.Minute
+		var due []models.Alert
+		cooling := make(map[string]bool)
+		for _, a := range alerts {
+			c, ok := cooling[a.CameraID]
+			if !ok {
+				c = s.coolingDown(rule, a.CameraID, cooldown, now)
+				cooling[a.CameraID] = c
+			}
+			if !c {
+				due = append(due, a)
+			}
+		}
+		if len(due) == 0 {
+			continue
+		}
+
+		ids := make([]any, len(due))
+		var open []models.Alert
+		for i, a := range due {
+			ids[i] = a.ID
+			if a.Status == AlertNew {
+				open = append(open, a)
+			}
+		}
+		args := append([]any{notifyDigested, now.Format(jobTimeLayout)}, ids...)
+		if _, err := s.db.Exec("UPDATE alerts SET notify_state = ?, notified_at = ? WHERE id IN (?"+
+			strings.Repeat(", ?", len(ids)-1)+")", args...); err != nil {
+			log.Printf("Alert digests: marking %s alerts digested: %v", rule, err)
+			continue
+		}
+		if len(open) > 0 {
+			s.notifier.Notify(digest(rule, open, now, s.settings.Location()))
+		}
+	}
+}
+
+// heldAlerts returns the alerts waiting for a digest, oldest first.
+func (s *AlertService) heldAlerts() ([]models.Alert, error) {
+	rows, err := s.db.Query("SELECT "+alertColumns+" FROM alerts WHERE notify_state = ? ORDER BY created_at", notifyHeld)
+	if err != nil {
+		return nil, fmt.Errorf("querying held alerts: %w", err)
+	}
+	defer rows.Close()
+	var alerts []models.Alert
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning alert row: %w", err)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// digest sums up the held alerts of one rule in a single notification,
+// with their times in loc.
+func digest(rule string, alerts []models.Alert, now time.Time, loc *time.Location) Notification {
+	cameras := make(map[string]bool)
+	for _, a := range alerts {
+		cameras[a.CameraID] = true
+	}
+	var lines []string
+	for i, a := range alerts {
+		if i == digestMaxLines {
+			lines = append(lines, fmt.Sprintf("... and %d more", len(alerts)-digestMaxLines))
+			break
+		}
+		when := a.CreatedAt.In(loc).Format("2006-01-02 15:04")
+		if t, err := time.Parse(time.RFC3339, a.Timestamp); err == nil {
+			when = t.In(loc).Format("2006-01-02 15:04:05")
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s", when, a.Title))
+	}
+	n := Notification{
+		Event:   "alert_digest",
+		Title:   fmt.Sprintf("%d %s alerts", len(alerts), rule),
+		Message: strings.Join(lines, "\n"),
+		Time:    now,
+	}
+	if len(cameras) == 1 {
+		n.CameraID = alerts[0].CameraID
+	}
+	return n
+}
+
+// Start sends the digests of held alerts, checking every minute, until
+// Close.
+func (s *AlertService) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sendDigests()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the digests. Alerts still held are sent after a restart.
+func (s *AlertService) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/intelsk/backend/models"
+)
+
+// RuleFrameMatch is the rule of the alerts FrameWatch raises.
+const RuleFrameMatch = "frame_match"
+
+// FrameWatch raises frame_match alerts on newly indexed frames that match
+// one of the alerts.watch_queries text queries by at least
+// alerts.watch_min_score, like a search that runs on every indexed batch.
+type FrameWatch struct {
+	encoder  Encoder
+	settings *SettingsService
+	alerts   *AlertService
+
+	// embeddings caches the text embeddings of the queries by model and
+	// query
+	mu         sync.Mutex
+	embeddings map[string][]float64
+}
+
+func NewFrameWatch(encoder Encoder, settings *SettingsService, alerts *AlertService) *FrameWatch {
+	return &FrameWatch{encoder: encoder, settings: settings, alerts: alerts, embeddings: make(map[string][]float64)}
+}
+
+// Indexed checks a batch of newly indexed frames, for Storage.OnIndexed.
+// Of the frames of a camera matching a query, only the best raises an
+// alert, so a person standing in view for the whole batch makes one. The
+// queries are encoded and the alerts raised in the background.
+func (w *FrameWatch) Indexed(frames []IndexedFrame) {
+	queries := w.queries()
+	if len(queries) == 0 || len(frames) == 0 {
+		return
+	}
+	go func() {
+		minScore := w.settings.GetFloat64("alerts.watch_min_score")
+		for _, query := range queries {
+			emb, err := w.embedding(query)
+			if err != nil {
+				log.Printf("Watching for %q: %v", query, err)
+				continue
+			}
+			best := make(map[string]int)
+			scores := make([]float64, len(frames))
+			for i, f := range frames {
+				if len(f.Embedding) != len(emb) {
+					continue // indexed with another model
+				}
+				// Both are L2-normalized, so the dot product is the cosine
+				for j := range emb {
+					scores[i] += f.Embedding[j] * emb[j]
+				}
+				if scores[i] < minScore {
+					continue
+				}
+				if b, ok := best[f.CameraID]; !ok || scores[i] > scores[b] {
+					best[f.CameraID] = i
+				}
+			}
+			for _, i := range best {
+				w.raise(query, frames[i], scores[i])
+			}
+		}
+	}()
+}
+
+func (w *FrameWatch) raise(query string, f IndexedFrame, score float64) {
+	ts := FormatTimestamp(f.Timestamp)
+	if _, err := w.alerts.Raise(models.Alert{
+		Rule:     RuleFrameMatch,
+		CameraID: f.CameraID,
+		Title:    fmt.Sprintf("%q seen", query),
+		Message: fmt.Sprintf("A frame of camera %s from %s matches %q (score %.2f).",
+			f.CameraID, LocalTimestamp(ts, w.settings.Location()), query, score),
+		Timestamp:   ts,
+		FramePath:   f.FramePath,
+		SourceVideo: f.SourceVideo,
+	}); err != nil {
+		log.Printf("raising %s alert: %v", RuleFrameMatch, err)
+	}
+}
+
+// queries returns the alerts.watch_queries queries.
+func (w *FrameWatch) queries() []string {
+	var queries []string
+	for _, q := range strings.Split(w.settings.Get("alerts.watch_queries"), ",") {
+		if q = strings.TrimSpace(q); q != "" {
+			queries = append(queries, q)
+		}
+	}
+	return queries
+}
+
+// embedding returns the text embedding of query, encoded once per model.
+func (w *FrameWatch) embedding(query string) ([]float64, error) {
+	key := w.settings.Get("clip.model") + "\x00" + query
+	w.mu.Lock()
+	emb, ok := w.embeddings[key]
+	w.mu.Unlock()
+	if ok {
+		return emb, nil
+	}
+	emb, err := w.encoder.EncodeText(query)
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	w.embeddings[key] = emb
+	w.mu.Unlock()
+	return emb, nil
+}
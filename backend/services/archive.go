@@ -0,0 +1,476 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+)
+
+// A date's recordings, once all are extracted and indexed, can be merged
+// into one day archive next to them: dayArchiveName holds the recordings
+// one after another, and dayArchiveIndex where each of them begins in it.
+const (
+	dayArchiveName  = "archive.mp4"
+	dayArchiveIndex = "archive.json"
+)
+
+// archiveDurationSlack is how far the archive's length may be off the sum
+// of its recordings' before the merge is taken to have gone wrong.
+const archiveDurationSlack = 2.0
+
+// ArchiveParams are the params of an archive job: the camera and date whose
+// recordings are merged.
+type ArchiveParams struct {
+	CameraID string `json:"camera_id"`
+	Date     string `json:"date"`
+}
+
+// dayArchive is the contents of dayArchiveIndex.
+type dayArchive struct {
+	Segments []archiveSegment `json:"segments"`
+}
+
+// archiveSegment is a recording merged into a day archive. Start and
+// OffsetSec are those its frames were stamped with, as in videoTimesFile;
+// AtSec is where it begins in the archive.
+type archiveSegment struct {
+	Name        string  `json:"name"`
+	Start       string  `json:"start"`
+	OffsetSec   int     `json:"clock_offset_sec,omitempty"`
+	AtSec       float64 `json:"at_sec"`
+	DurationSec float64 `json:"duration_sec"`
+}
+
+// dayArchiveCache remembers parsed dayArchiveIndex contents by path and
+// mtime, so seek offsets into an archive don't re-read it per frame.
+var dayArchiveCache = struct {
+	sync.Mutex
+	m map[string]cachedDayArchive
+}{m: make(map[string]cachedDayArchive)}
+
+type cachedDayArchive struct {
+	modTime time.Time
+	archive *dayArchive
+}
+
+// loadDayArchive reads the dayArchiveIndex in dir, nil when the date has no
+// archive.
+func loadDayArchive(dir string) *dayArchive {
+	path := filepath.Join(dir, dayArchiveIndex)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	dayArchiveCache.Lock()
+	c, ok := dayArchiveCache.m[path]
+	dayArchiveCache.Unlock()
+	if ok && c.modTime.Equal(info.ModTime()) {
+		return c.archive
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var archive dayArchive
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return nil
+	}
+	dayArchiveCache.Lock()
+	dayArchiveCache.m[path] = cachedDayArchive{modTime: info.ModTime(), archive: &archive}
+	dayArchiveCache.Unlock()
+	return &archive
+}
+
+// start returns when the segment began with its clock offset applied, the
+// time its frames are stamped from.
+func (seg archiveSegment) start() (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, seg.Start)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.Add(time.Duration(seg.OffsetSec) * time.Second), true
+}
+
+// merged returns the paths of the archive's recordings still in videosDir:
+// all of them right after it is built, or those an interrupted run left.
+func (a *dayArchive) merged(videosDir string) map[string]bool {
+	paths := make(map[string]bool)
+	for _, seg := range a.Segments {
+		path := filepath.Join(videosDir, seg.Name)
+		if _, err := os.Stat(path); err == nil {
+			paths[path] = true
+		}
+	}
+	return paths
+}
+
+// archiveSeekOffset returns the seconds into the day archive sourceVideo
+// at which the frame stamped frameTime was recorded, taken from the
+// recording that started last at or before it. ok is false when
+// sourceVideo is no day archive.
+func archiveSeekOffset(sourceVideo string, frameTime time.Time) (offset int, ok bool) {
+	path := filepath.FromSlash(sourceVideo)
+	if filepath.Base(path) != dayArchiveName {
+		return 0, false
+	}
+	archive := loadDayArchive(filepath.Dir(path))
+	if archive == nil {
+		return 0, false
+	}
+	var best *archiveSegment
+	var bestStart time.Time
+	for i, seg := range archive.Segments {
+		start, ok := seg.start()
+		if !ok || start.After(frameTime) {
+			continue
+		}
+		if best == nil || start.After(bestStart) {
+			best, bestStart = &archive.Segments[i], start
+		}
+	}
+	if best == nil {
+		return 0, true
+	}
+	return int(best.AtSec + frameTime.Sub(bestStart).Seconds()), true
+}
+
+// ArchiveReady returns why the recordings of camera id on date can't be
+// merged into a day archive yet, or nil when they can: the day is over, and
+// every recording is recorded as processed and every frame extracted from
+// them indexed.
+func (s *CameraService) ArchiveReady(id, date string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return newError(ErrInvalidInput, "date must be YYYY-MM-DD, got %q", date)
+	}
+	if date >= s.now().Format("2006-01-02") {
+		return newError(ErrInvalidInput, "%s is not over yet", date)
+	}
+
+	videosDir := filepath.Join(s.cfg.App.DataDir, "videos", id, date)
+	if archive := loadDayArchive(videosDir); archive != nil {
+		// Only an interrupted run is left to finish
+		if len(archive.merged(videosDir)) == 0 {
+			return newError(ErrAlreadyExists, "%s/%s is already archived", id, date)
+		}
+		return nil
+	}
+	if len(ListVideoFiles(videosDir)) == 0 {
+		return newError(ErrVideoNotFound, "camera %s has no recordings for %s", id, date)
+	}
+	history := LoadProcessHistory(s.cfg.Process.HistoryPath)
+	if !slices.ContainsFunc(history, func(h models.ProcessHistoryEntry) bool { return h.CameraID == id && h.Date == date }) {
+		return newError(ErrInvalidInput, "%s/%s has not been processed", id, date)
+	}
+	if pending := NewVideosForDate(history, id, date, videosDir); len(pending) > 0 {
+		return newError(ErrInvalidInput, "%s/%s has %d recordings not processed yet", id, date, len(pending))
+	}
+
+	framesDir := filepath.Join(s.cfg.Extraction.StoragePath, id, date)
+	frames, err := LoadManifest(framesDir)
+	if err != nil {
+		return fmt.Errorf("reading the manifest of %s/%s: %w", id, date, err)
+	}
+	state := loadIndexState(filepath.Join(framesDir, "index_state.json"))
+	pending := 0
+	for _, f := range frames {
+		if !state.IndexedFrames[frameID(f)] {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return newError(ErrInvalidInput, "%s/%s has %d frames not indexed yet", id, date, pending)
+	}
+	return nil
+}
+
+// ArchiveDate merges the recordings of camera id on date into a day archive
+// with ffmpeg's concat demuxer, copying the streams, so a date takes one
+// file rather than one per recording. The frames, embeddings, bookmarks,
+// case items and alerts of the recordings then point at the archive, and
+// seek offsets into it come from its index. The recordings are removed
+// last: an interrupted run leaves them in place, and running it again
+// finishes the job. Recordings added to an archived date stay files of
+// their own. clock dates recordings processed before their start was
+// recorded. It returns how many recordings were merged.
+func (s *CameraService) ArchiveDate(ctx context.Context, id, date string, clock VideoClock, emit func(ProgressEvent)) (int, error) {
+	videosDir := filepath.Join(s.cfg.App.DataDir, "videos", id, date)
+	framesDir := filepath.Join(s.cfg.Extraction.StoragePath, id, date)
+	archivePath := filepath.Join(videosDir, dayArchiveName)
+
+	archive := loadDayArchive(videosDir)
+	if archive == nil {
+		if err := s.ArchiveReady(id, date); err != nil {
+			return 0, err
+		}
+		var err error
+		if archive, err = buildDayArchive(ctx, videosDir, date, clock, emit); err != nil {
+			return 0, err
+		}
+	}
+
+	segments := archive.merged(videosDir)
+	if len(segments) == 0 {
+		return 0, newError(ErrAlreadyExists, "%s/%s is already archived", id, date)
+	}
+
+	emit(ProgressEvent{Stage: "archiving", CameraID: id, Date: date,
+		Message: fmt.Sprintf("pointing the frames of %d recordings of %s/%s at the archive", len(segments), id, date)})
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("archiving %s/%s: %w", id, date, err)
+	}
+	defer tx.Rollback()
+	for _, t := range renamedTables {
+		if t.videos == "" {
+			continue
+		}
+		for path := range segments {
+			if _, err := tx.Exec("UPDATE "+t.table+" SET "+t.videos+" = ? WHERE "+t.videos+" = ?", archivePath, path); err != nil {
+				return 0, fmt.Errorf("archiving %s/%s in %s: %w", id, date, t.table, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("archiving %s/%s: %w", id, date, err)
+	}
+
+	frames, err := LoadManifest(framesDir)
+	if err != nil {
+		return 0, fmt.Errorf("reading the manifest of %s/%s: %w", id, date, err)
+	}
+	changed := false
+	for i := range frames {
+		if segments[frames[i].SourceVideo] {
+			frames[i].SourceVideo = archivePath
+			changed = true
+		}
+	}
+	if changed {
+		if err := WriteManifest(framesDir, frames); err != nil {
+			return 0, fmt.Errorf("writing the manifest of %s/%s: %w", id, date, err)
+		}
+	}
+
+	for path := range segments {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("archiving %s/%s: %w", id, date, err)
+		}
+		// Their preview sprites give way to the archive's
+		stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		os.RemoveAll(filepath.Join(s.cfg.App.DataDir, "previews", id, date, stem))
+	}
+	return len(segments), nil
+}
+
+// buildDayArchive merges the recordings in videosDir, in the order they
+// started, into dayArchiveName and writes its dayArchiveIndex. The streams
+// are copied, so every recording must have the same codec and frame size.
+func buildDayArchive(ctx context.Context, videosDir, date string, clock VideoClock, emit func(ProgressEvent)) (*dayArchive, error) {
+	loc := clock.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	day, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return nil, newError(ErrInvalidInput, "date must be YYYY-MM-DD, got %q", date)
+	}
+
+	names := ListVideoFiles(videosDir)
+	times := loadVideoTimes(videosDir)
+	archive := &dayArchive{}
+	var first *VideoProbe
+	for _, name := range names {
+		path := filepath.Join(videosDir, name)
+		probe, err := ProbeVideo(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if probe.DurationSec <= 0 {
+			return nil, newError(ErrInvalidInput, "%s has no duration", name)
+		}
+		if first == nil {
+			first = probe
+		} else if probe.Codec != first.Codec || probe.Width != first.Width || probe.Height != first.Height {
+			return nil, newError(ErrInvalidInput, "%s is %s %dx%d and %s is %s %dx%d; recordings can only be merged without re-encoding when they match",
+				names[0], first.Codec, first.Width, first.Height, name, probe.Codec, probe.Width, probe.Height)
+		}
+		seg := archiveSegment{Name: name, DurationSec: probe.DurationSec}
+		if vt, ok := times[name]; ok {
+			seg.Start, seg.OffsetSec = vt.Start, vt.OffsetSec
+		} else {
+			// Processed before starts were recorded, without the offset
+			seg.Start = videoStart(path, day, clock).Format(time.RFC3339)
+		}
+		archive.Segments = append(archive.Segments, seg)
+	}
+	slices.SortStableFunc(archive.Segments, func(a, b archiveSegment) int {
+		ta, _ := a.start()
+		tb, _ := b.start()
+		if c := ta.Compare(tb); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	// The concat demuxer places each file right after the one before
+	list, err := os.CreateTemp(videosDir, "archive-*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("writing the archive list: %w", err)
+	}
+	defer os.Remove(list.Name())
+	total := 0.0
+	for i := range archive.Segments {
+		seg := &archive.Segments[i]
+		seg.AtSec = total
+		total += seg.DurationSec
+		abs, err := filepath.Abs(filepath.Join(videosDir, seg.Name))
+		if err != nil {
+			list.Close()
+			return nil, err
+		}
+		fmt.Fprintf(list, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+	}
+	if err := list.Close(); err != nil {
+		return nil, fmt.Errorf("writing the archive list: %w", err)
+	}
+
+	emit(ProgressEvent{Stage: "archiving", VideosTotal: len(archive.Segments),
+		Message: fmt.Sprintf("merging %d recordings (%.0f min) into %s", len(archive.Segments), total/60, dayArchiveName)})
+	tmp := filepath.Join(videosDir, dayArchiveName+".tmp")
+	cmd := exec.CommandContext(ctx,
+		"ffmpeg", "-v", "error",
+		"-f", "concat", "-safe", "0",
+		"-i", list.Name(),
+		"-map", "0:v:0", "-map", "0:a?",
+		"-c", "copy",
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		"-y", tmp,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("ffmpeg concat: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	probe, err := ProbeVideo(tmp)
+	if err != nil || math.Abs(probe.DurationSec-total) > archiveDurationSlack {
+		os.Remove(tmp)
+		if err != nil {
+			return nil, fmt.Errorf("reading the merged archive: %w", err)
+		}
+		return nil, fmt.Errorf("the merged archive is %.1fs long, its recordings %.1fs", probe.DurationSec, total)
+	}
+
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(videosDir, dayArchiveName)); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	// Written last, so an archive with an index is whole
+	indexTmp := filepath.Join(videosDir, dayArchiveIndex+".tmp")
+	if err := os.WriteFile(indexTmp, data, 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(indexTmp, filepath.Join(videosDir, dayArchiveIndex)); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// Archiver merges the recordings of dates videos.archive_after_days old
+// into day archives: every hour it submits an archive job for each date
+// that is ready (see ArchiveReady) and not archived yet.
+type Archiver struct {
+	cfg      *config.AppConfig
+	settings *SettingsService
+	cameras  *CameraService
+	jobs     *JobManager
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewArchiver(cfg *config.AppConfig, settings *SettingsService, cameras *CameraService, jobs *JobManager) *Archiver {
+	return &Archiver{
+		cfg:      cfg,
+		settings: settings,
+		cameras:  cameras,
+		jobs:     jobs,
+		stop:     make(chan struct{}),
+	}
+}
+
+// check submits the archive jobs due.
+func (a *Archiver) check() {
+	days := a.settings.GetInt("videos.archive_after_days")
+	if days <= 0 {
+		return
+	}
+	cutoff := a.settings.Now().AddDate(0, 0, -days).Format("2006-01-02")
+	cameras, err := a.cameras.List()
+	if err != nil {
+		log.Printf("checking dates to archive: %v", err)
+		return
+	}
+	for _, cam := range cameras {
+		entries, _ := os.ReadDir(filepath.Join(a.cfg.App.DataDir, "videos", cam.ID))
+		for _, e := range entries {
+			date := e.Name()
+			if !e.IsDir() || date > cutoff || a.jobs.Working(JobKey(cam.ID, date)) != "" {
+				continue
+			}
+			if err := a.cameras.ArchiveReady(cam.ID, date); err != nil {
+				continue
+			}
+			job, err := a.jobs.Submit(JobTypeArchive, ArchiveParams{CameraID: cam.ID, Date: date})
+			if err != nil {
+				log.Printf("Archiving %s/%s: %v", cam.ID, date, err)
+				continue
+			}
+			log.Printf("Archiving %s/%s in job %s", cam.ID, date, job.ID)
+		}
+	}
+}
+
+// Start checks for dates to archive every hour until Close.
+func (a *Archiver) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			a.check()
+			select {
+			case <-ticker.C:
+			case <-a.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the checks. Archive jobs already submitted are not affected.
+func (a *Archiver) Close() {
+	a.stopOnce.Do(func() { close(a.stop) })
+}
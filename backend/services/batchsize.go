@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// BatchSizer is an ImageEncoder that picks the size of the batches it is
+// sent from how fast it has been encoding them. The pipeline asks it before
+// every batch, falling back to clip.batch_size while it has no opinion.
+type BatchSizer interface {
+	ImageEncoder
+	BatchSize(fallback int) int
+}
+
+// adaptiveBatch tunes the image encoding batch size between min and max
+// from the latency of the batches: a full batch done in under half the
+// target grows it by half, a batch over the target shrinks it to what fits
+// the target, and a timed out one halves it. It starts from the fallback
+// size the first caller brings.
+type adaptiveBatch struct {
+	mu       sync.Mutex
+	enabled  bool
+	min, max int
+	target   time.Duration
+	size     int // 0 until the first batch
+}
+
+// configure sets the bounds and target, keeping the size learnt so far
+// within the new bounds.
+func (a *adaptiveBatch) configure(enabled bool, minSize, maxSize int, target time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.enabled = enabled
+	a.min = max(minSize, 1)
+	a.max = max(maxSize, a.min)
+	a.target = target
+	if a.size != 0 {
+		a.size = a.clamp(a.size)
+	}
+}
+
+func (a *adaptiveBatch) clamp(n int) int {
+	return min(max(n, a.min), a.max)
+}
+
+// get returns the batch size to send, fallback when tuning is off.
+func (a *adaptiveBatch) get(fallback int) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.enabled {
+		return fallback
+	}
+	if a.size == 0 {
+		a.size = a.clamp(fallback)
+	}
+	return a.size
+}
+
+// current returns the tuned size, or 0 when tuning is off or no batch has
+// been sent yet.
+func (a *adaptiveBatch) current() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.enabled {
+		return 0
+	}
+	return a.size
+}
+
+// observe adjusts the size from a batch of n frames that took took and
+// ended with err. Failures other than timeouts say nothing about the size.
+func (a *adaptiveBatch) observe(n int, took time.Duration, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.enabled || a.size == 0 || n == 0 {
+		return
+	}
+	switch {
+	case errors.Is(err, ErrSidecarTimeout):
+		a.size = a.clamp(min(a.size, n) / 2)
+	case err != nil:
+	case took > a.target:
+		a.size = a.clamp(int(float64(n) * float64(a.target) / float64(took)))
+	case took < a.target/2 && n >= a.size:
+		// Only a full batch shows the size is too small, not a tail
+		a.size = a.clamp(max(n*3/2, n+1))
+	}
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/intelsk/backend/models"
+)
+
+// maxBookmarkTags and maxBookmarkNote bound what one bookmark holds.
+const (
+	maxBookmarkTags = 32
+	maxBookmarkNote = 10000
+)
+
+// BookmarkFilter selects bookmarks for BookmarkService.List. Empty fields
+// match every bookmark; dates are inclusive YYYY-MM-DD bounds on the
+// frame's timestamp, days in the service's clock's zone.
+type BookmarkFilter struct {
+	CameraID  string
+	Tag       string
+	StartDate string
+	EndDate   string
+}
+
+// BookmarkService keeps the frames investigators bookmarked, with their
+// notes and tags, in the bookmarks table. A frame has at most one
+// bookmark. The frame's camera, time, file and video are copied from its
+// CLIP embedding, so a bookmark outlives the index being rebuilt.
+type BookmarkService struct {
+	db      *sql.DB
+	storage *Storage
+	now     func() time.Time
+}
+
+func NewBookmarkService(storage *Storage) *BookmarkService {
+	return &BookmarkService{db: storage.DB(), storage: storage, now: time.Now}
+}
+
+// WithClock makes List read dates in the zone of now, e.g.
+// SettingsService.Now to follow the general.timezone setting.
+func (s *BookmarkService) WithClock(now func() time.Time) *BookmarkService {
+	s.now = now
+	return s
+}
+
+const bookmarkColumns = `id, frame_id, camera_id, timestamp, frame_path, source_video, note, tags, created_at, updated_at`
+
+func scanBookmark(row interface{ Scan(...any) error }) (models.Bookmark, error) {
+	var b models.Bookmark
+	var tags, createdAt, updatedAt string
+	if err := row.Scan(&b.ID, &b.FrameID, &b.CameraID, &b.Timestamp, &b.FramePath, &b.SourceVideo,
+		&b.Note, &tags, &createdAt, &updatedAt); err != nil {
+		return b, err
+	}
+	json.Unmarshal([]byte(tags), &b.Tags)
+	if b.Tags == nil {
+		b.Tags = []string{}
+	}
+	b.CreatedAt, _ = time.Parse(jobTimeLayout, createdAt)
+	b.UpdatedAt, _ = time.Parse(jobTimeLayout, updatedAt)
+	return b, nil
+}
+
+// normalizeTags trims the tags and drops empty and repeated ones.
+func normalizeTags(tags []string) ([]string, error) {
+	out := []string{}
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) > maxBookmarkTags {
+		return nil, newError(ErrInvalidInput, "a bookmark has at most %d tags", maxBookmarkTags)
+	}
+	return out, nil
+}
+
+// Create bookmarks an indexed frame. A frame already bookmarked is
+// ErrAlreadyExists; edit its bookmark instead.
+func (s *BookmarkService) Create(req models.CreateBookmarkRequest) (models.Bookmark, error) {
+	if req.FrameID == "" {
+		return models.Bookmark{}, newError(ErrInvalidInput, "frame_id is required")
+	}
+	if len(req.Note) > maxBookmarkNote {
+		return models.Bookmark{}, newError(ErrInvalidInput, "note is limited to %d bytes", maxBookmarkNote)
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return models.Bookmark{}, err
+	}
+	frame, err := s.storage.GetClipEmbedding(req.FrameID)
+	if err != nil {
+		return models.Bookmark{}, err
+	}
+	if id, ok := s.lookup(req.FrameID); ok {
+		return models.Bookmark{}, newError(ErrAlreadyExists, "frame %s is already bookmarked as %s", req.FrameID, id)
+	}
+
+	id := uuid.New().String()
+	now := time.Now().UTC().Format(jobTimeLayout)
+	tagsJSON, _ := json.Marshal(tags)
+	if _, err := s.db.Exec(`INSERT INTO bookmarks (`+bookmarkColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, frame.ID, frame.CameraID, frame.Timestamp, frame.FramePath, frame.SourceVideo,
+		req.Note, string(tagsJSON), now, now); err != nil {
+		return models.Bookmark{}, fmt.Errorf("inserting bookmark: %w", err)
+	}
+	return s.Get(id)
+}
+
+func (s *BookmarkService) lookup(frameID string) (string, bool) {
+	var id string
+	err := s.db.QueryRow("SELECT id FROM bookmarks WHERE frame_id = ?", frameID).Scan(&id)
+	return id, err == nil
+}
+
+// Get returns a single bookmark.
+func (s *BookmarkService) Get(id string) (models.Bookmark, error) {
+	b, err := scanBookmark(s.db.QueryRow("SELECT "+bookmarkColumns+" FROM bookmarks WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return b, newError(ErrBookmarkNotFound, "bookmark %s not found", id)
+	}
+	if err != nil {
+		return b, fmt.Errorf("querying bookmark %s: %w", id, err)
+	}
+	return b, nil
+}
+
+// List returns the bookmarks matching f, by the frames' time, oldest first.
+func (s *BookmarkService) List(f BookmarkFilter) ([]models.Bookmark, error) {
+	from, to, err := TimeRange(f.StartDate, f.EndDate, s.now().Location())
+	if err != nil {
+		return nil, err
+	}
+	var where []string
+	var args []any
+	for _, c := range []struct{ column, value string }{
+		{"camera_id = ?", f.CameraID},
+		{"EXISTS (SELECT 1 FROM json_each(bookmarks.tags) WHERE value = ?)", f.Tag},
+		{"timestamp >= ?", from},
+		{"timestamp <= ?", to},
+	} {
+		if c.value != "" {
+			where = append(where, c.column)
+			args = append(args, c.value)
+		}
+	}
+	query := "SELECT " + bookmarkColumns + " FROM bookmarks"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := s.db.Query(query+" ORDER BY timestamp, created_at", args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	bookmarks := []models.Bookmark{}
+	for rows.Next() {
+		b, err := scanBookmark(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning bookmark row: %w", err)
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	return bookmarks, rows.Err()
+}
+
+// Update changes the fields set in req.
+func (s *BookmarkService) Update(id string, req models.UpdateBookmarkRequest) (models.Bookmark, error) {
+	b, err := s.Get(id)
+	if err != nil {
+		return b, err
+	}
+	if req.Note != nil {
+		if len(*req.Note) > maxBookmarkNote {
+			return b, newError(ErrInvalidInput, "note is limited to %d bytes", maxBookmarkNote)
+		}
+		b.Note = *req.Note
+	}
+	if req.Tags != nil {
+		if b.Tags, err = normalizeTags(*req.Tags); err != nil {
+			return b, err
+		}
+	}
+	tagsJSON, _ := json.Marshal(b.Tags)
+	if _, err := s.db.Exec("UPDATE bookmarks SET note = ?, tags = ?, updated_at = ? WHERE id = ?",
+		b.Note, string(tagsJSON), time.Now().UTC().Format(jobTimeLayout), id); err != nil {
+		return b, fmt.Errorf("updating bookmark: %w", err)
+	}
+	return s.Get(id)
+}
+
+// Delete removes a bookmark.
+func (s *BookmarkService) Delete(id string) error {
+	res, err := s.db.Exec("DELETE FROM bookmarks WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting bookmark: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newError(ErrBookmarkNotFound, "bookmark %s not found", id)
+	}
+	return nil
+}
+
+// ByFrame returns the bookmark IDs of those of frameIDs that are
+// bookmarked, keyed by frame ID.
+func (s *BookmarkService) ByFrame(frameIDs []string) (map[string]string, error) {
+	ids := make(map[string]string)
+	if len(frameIDs) == 0 {
+		return ids, nil
+	}
+	args := make([]any, len(frameIDs))
+	for i, id := range frameIDs {
+		args[i] = id
+	}
+	rows, err := s.db.Query("SELECT frame_id, id FROM bookmarks WHERE frame_id IN (?"+
+		strings.Repeat(", ?", len(frameIDs)-1)+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying bookmarks: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var frameID, id string
+		if err := rows.Scan(&frameID, &id); err != nil {
+			return nil, fmt.Errorf("scanning bookmark row: %w", err)
+		}
+		ids[frameID] = id
+	}
+	return ids, rows.Err()
+}
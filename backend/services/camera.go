@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,10 +25,18 @@ var validCameraID = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,63}$`)
 type CameraService struct {
 	db  *sql.DB
 	cfg *config.AppConfig
+	now func() time.Time
 }
 
 func NewCameraService(db *sql.DB, cfg *config.AppConfig) *CameraService {
-	return &CameraService{db: db, cfg: cfg}
+	return &CameraService{db: db, cfg: cfg, now: time.Now}
+}
+
+// WithClock makes the service date uploads and timelines by now, e.g.
+// SettingsService.Now to follow the general.timezone setting.
+func (s *CameraService) WithClock(now func() time.Time) *CameraService {
+	s.now = now
+	return s
 }
 
 // List returns cameras merged from the DB and filesystem.
@@ -72,7 +82,7 @@ func (s *CameraService) Get(id string) (*models.CameraInfo, error) {
 	).Scan(&cam.ID, &cam.Name, &cam.Type, &configJSON, &cam.CreatedAt, &cam.UpdatedAt)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("camera not found: %s", id)
+		return nil, newError(ErrCameraNotFound, "camera not found: %s", id)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("querying camera: %w", err)
@@ -88,27 +98,32 @@ func (s *CameraService) Get(id string) (*models.CameraInfo, error) {
 // Create validates and inserts a new camera, creating its video directory.
 func (s *CameraService) Create(req models.CreateCameraRequest) (*models.CameraInfo, error) {
 	if !validCameraID.MatchString(req.ID) {
-		return nil, fmt.Errorf("invalid camera ID: must match [a-zA-Z0-9][a-zA-Z0-9_-]{0,63}")
+		return nil, newError(ErrInvalidInput, "invalid camera ID: must match [a-zA-Z0-9][a-zA-Z0-9_-]{0,63}")
 	}
 	if req.Name == "" {
-		return nil, fmt.Errorf("name is required")
+		return nil, newError(ErrInvalidInput, "name is required")
 	}
 	if req.Type != "local" && req.Type != "hikvision" {
-		return nil, fmt.Errorf("type must be 'local' or 'hikvision'")
+		return nil, newError(ErrInvalidInput, "type must be 'local' or 'hikvision'")
 	}
 
 	// Check for duplicates (DB or filesystem)
 	if _, err := s.Get(req.ID); err == nil {
-		return nil, fmt.Errorf("camera already exists: %s", req.ID)
+		return nil, newError(ErrAlreadyExists, "camera already exists: %s", req.ID)
 	}
 
 	config := req.Config
 	if config == nil {
 		config = map[string]any{}
 	}
-	// Default transcode=true
-	if _, ok := config["transcode"]; !ok {
-		config["transcode"] = true
+	if err := ValidateStreamOverrides(config); err != nil {
+		return nil, err
+	}
+	if err := ValidateClockOffset(config); err != nil {
+		return nil, err
+	}
+	if err := ValidateCameraTags(config); err != nil {
+		return nil, err
 	}
 	configJSON, err := json.Marshal(config)
 	if err != nil {
@@ -138,7 +153,7 @@ func (s *CameraService) Update(id string, req models.UpdateCameraRequest) (*mode
 	var existing string
 	err := s.db.QueryRow("SELECT id FROM cameras WHERE id = ?", id).Scan(&existing)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("camera not found in database: %s (filesystem-only cameras cannot be edited)", id)
+		return nil, newError(ErrCameraNotFound, "camera not found in database: %s (filesystem-only cameras cannot be edited)", id)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("querying camera: %w", err)
@@ -150,6 +165,15 @@ func (s *CameraService) Update(id string, req models.UpdateCameraRequest) (*mode
 		}
 	}
 	if req.Config != nil {
+		if err := ValidateStreamOverrides(req.Config); err != nil {
+			return nil, err
+		}
+		if err := ValidateClockOffset(req.Config); err != nil {
+			return nil, err
+		}
+		if err := ValidateCameraTags(req.Config); err != nil {
+			return nil, err
+		}
 		configJSON, err := json.Marshal(req.Config)
 		if err != nil {
 			return nil, fmt.Errorf("marshaling config: %w", err)
@@ -166,11 +190,19 @@ func (s *CameraService) Update(id string, req models.UpdateCameraRequest) (*mode
 func (s *CameraService) Delete(id string, deleteData bool) error {
 	// Delete DB row (may not exist for filesystem-only cameras)
 	s.db.Exec("DELETE FROM cameras WHERE id = ?", id)
+	s.db.Exec("DELETE FROM ingest_state WHERE camera_id = ?", id)
 
 	if deleteData {
+		// Alerts point at the frames and videos removed below; the views
+		// other cameras had of them stand alone again
+		s.db.Exec("UPDATE alerts SET group_id = '' WHERE group_id IN (SELECT id FROM alerts WHERE camera_id = ?)", id)
+		s.db.Exec("DELETE FROM alerts WHERE camera_id = ?", id)
+
 		// Remove video files
 		videosDir := filepath.Join(s.cfg.App.DataDir, "videos", id)
 		os.RemoveAll(videosDir)
+		os.RemoveAll(filepath.Join(s.cfg.App.DataDir, "previews", id))
+		os.RemoveAll(filepath.Join(s.cfg.App.DataDir, "timelapses", id))
 
 		// Remove extracted frames
 		framesDir := filepath.Join(s.cfg.Extraction.StoragePath, id)
@@ -187,15 +219,37 @@ func (s *CameraService) Delete(id string, deleteData bool) error {
 	return nil
 }
 
-// Upload saves an uploaded file to the camera's video directory.
-// Only works for cameras with type "local".
+// Upload saves an uploaded file to the uploads directory and returns its
+// path, to be validated and placed with PlaceUpload.
 func (s *CameraService) Upload(id string, file io.Reader, filename string) (string, error) {
 	if _, err := s.Get(id); err != nil {
 		return "", err
 	}
+	if err := os.MkdirAll(s.uploadsDir(), 0o755); err != nil {
+		return "", fmt.Errorf("creating uploads directory: %w", err)
+	}
+
+	destPath := s.stagedUploadPath()
+	out, err := os.Create(destPath)
+	if err != nil {
+		return "", fmt.Errorf("creating file: %w", err)
+	}
 
-	// Create dated subdirectory
-	today := time.Now().Format("2006-01-02")
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
+		os.Remove(destPath)
+		return "", fmt.Errorf("saving file: %w", err)
+	}
+	out.Close()
+
+	return destPath, nil
+}
+
+// newVideoPath returns a free destination for an uploaded file in today's
+// directory of the camera, creating the directory if needed. The filename is
+// sanitized and suffixed with _1, _2, ... on collision.
+func (s *CameraService) newVideoPath(id, filename string) (string, error) {
+	today := s.now().Format("2006-01-02")
 	dir := filepath.Join(s.cfg.App.DataDir, "videos", id, today)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return "", fmt.Errorf("creating directory: %w", err)
@@ -226,19 +280,6 @@ func (s *CameraService) Upload(id string, file io.Reader, filename string) (stri
 			}
 		}
 	}
-
-	out, err := os.Create(destPath)
-	if err != nil {
-		return "", fmt.Errorf("creating file: %w", err)
-	}
-
-	if _, err := io.Copy(out, file); err != nil {
-		out.Close()
-		os.Remove(destPath)
-		return "", fmt.Errorf("saving file: %w", err)
-	}
-	out.Close()
-
 	return destPath, nil
 }
 
@@ -257,48 +298,186 @@ func ProbeVideoCodec(filePath string) (string, error) {
 	return strings.TrimSpace(string(out)), nil
 }
 
-// TranscodeIfNeeded checks the video codec and transcodes HEVC to H.264 in-place.
-func TranscodeIfNeeded(filePath string) error {
+// VideoProbe is the subset of ffprobe output used to validate uploads.
+type VideoProbe struct {
+	Codec       string
+	DurationSec float64
+	Width       int
+	Height      int
+}
+
+// ProbeVideo reads the first video stream and container duration of a file.
+// It fails if ffprobe can't parse the file or finds no video stream.
+func ProbeVideo(filePath string) (*VideoProbe, error) {
+	out, err := exec.Command(
+		"ffprobe", "-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,width,height:format=duration",
+		"-of", "json",
+		filePath,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	var parsed struct {
+		Streams []struct {
+			CodecName string `json:"codec_name"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing ffprobe output: %w", err)
+	}
+	if len(parsed.Streams) == 0 {
+		return nil, fmt.Errorf("no video stream")
+	}
+	probe := &VideoProbe{
+		Codec:  parsed.Streams[0].CodecName,
+		Width:  parsed.Streams[0].Width,
+		Height: parsed.Streams[0].Height,
+	}
+	probe.DurationSec, _ = strconv.ParseFloat(parsed.Format.Duration, 64)
+	return probe, nil
+}
+
+// ValidateVideo rejects files that aren't readable videos: ffprobe must find
+// a video stream with a known codec and the container a positive duration.
+func ValidateVideo(filePath string) (*VideoProbe, error) {
+	probe, err := ProbeVideo(filePath)
+	if err != nil {
+		return nil, newError(ErrInvalidInput, "not a readable video: %v", err)
+	}
+	if probe.Codec == "" {
+		return nil, newError(ErrInvalidInput, "video codec could not be determined")
+	}
+	if probe.DurationSec <= 0 {
+		return nil, newError(ErrInvalidInput, "video duration could not be determined")
+	}
+	return probe, nil
+}
+
+// StreamPlayback writes filePath to w as fragmented MP4, starting startSec
+// into the video, for browsers that can't play the original (HEVC). H.264 is
+// only remuxed; anything else is transcoded to H.264 on the fly. The original
+// file is never modified. ffmpeg is stopped when ctx is cancelled, e.g. when
+// the player disconnects.
+func StreamPlayback(ctx context.Context, filePath string, startSec float64, w io.Writer) error {
 	codec, err := ProbeVideoCodec(filePath)
 	if err != nil {
 		return err
 	}
-	if codec != "hevc" {
-		return nil
+	video := []string{"-c:v", "copy"}
+	if codec != "h264" {
+		video = []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p"}
 	}
 
-	log.Printf("Transcoding HEVC video: %s", filePath)
-	tmpPath := filePath + ".transcoding.mp4"
-	cmd := exec.Command(
-		"ffmpeg", "-i", filePath,
-		"-c:v", "libx264", "-crf", "23",
+	args := []string{"-v", "error"}
+	if startSec > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(startSec, 'f', 3, 64))
+	}
+	args = append(args, "-i", filePath)
+	args = append(args, video...)
+	args = append(args,
 		"-c:a", "aac",
-		"-y", tmpPath,
+		// Fragmented MP4 can be played while it's written to a pipe
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4", "pipe:1",
 	)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("ffmpeg transcode: %w: %s", err, string(out))
+
+	var stderr strings.Builder
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg playback: %w: %s", err, strings.TrimSpace(stderr.String()))
 	}
+	return nil
+}
 
-	if err := os.Rename(tmpPath, filePath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("replacing original with transcoded file: %w", err)
+// ExtractClip copies start..end (seconds) of filePath into dst without
+// re-encoding. The cut begins at the keyframe at or before start.
+func ExtractClip(ctx context.Context, filePath, dst string, startSec, endSec float64) error {
+	cmd := exec.CommandContext(ctx,
+		"ffmpeg", "-v", "error",
+		"-ss", strconv.FormatFloat(startSec, 'f', 3, 64),
+		"-i", filePath,
+		"-t", strconv.FormatFloat(endSec-startSec, 'f', 3, 64),
+		"-map", "0:v:0", "-map", "0:a?",
+		"-c", "copy",
+		"-avoid_negative_ts", "make_zero",
+		"-movflags", "+faststart",
+		"-y", dst,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg clip: %w: %s", err, strings.TrimSpace(string(out)))
 	}
-	log.Printf("Transcode complete: %s", filePath)
 	return nil
 }
 
-// ShouldTranscode returns whether transcoding is enabled for this camera config.
-func ShouldTranscode(config map[string]any) bool {
-	v, ok := config["transcode"]
-	if !ok {
-		return true // default enabled
+// SeekOffset calculates seconds into the video segment.
+// Frame timestamp like "2026-02-18T14:23:05" from video "1400.mp4" (starts at 14:00)
+// → 23*60 + 5 = 1385 seconds.
+// Videos extracted since their start and clock offset are recorded (see
+// recordVideoTime) are measured from that start, with the offset their
+// frames were shifted by taken back out, and a day archive from where the
+// recording of the frame begins in it. For older ones the start hour comes
+// from the filename, or midnight for non-hour-based filenames.
+func SeekOffset(timestamp, sourceVideo string) int {
+	// Parse frame timestamp
+	var frameTime time.Time
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05"} {
+		t, err := time.Parse(layout, timestamp)
+		if err == nil {
+			frameTime = t
+			break
+		}
 	}
-	b, ok := v.(bool)
-	if !ok {
-		return true
+	if frameTime.IsZero() {
+		return 0
 	}
-	return b
+
+	if offset, ok := archiveSeekOffset(sourceVideo, frameTime); ok {
+		return offset
+	}
+	if start, offsetSec, ok := recordedVideoTime(sourceVideo); ok {
+		// Both are instants, so a DST change inside the video costs nothing
+		offset := int(frameTime.Sub(start).Seconds()) - offsetSec
+		if offset < 0 {
+			return 0
+		}
+		return offset
+	}
+
+	// Try to extract hour from video filename (e.g., "1400.mp4" → hour 14).
+	// Default to 0 (midnight) for non-hour filenames.
+	hour := 0
+	sv := filepath.ToSlash(sourceVideo)
+	base := filepath.Base(sv)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+
+	if len(stem) >= 2 {
+		if h, err := strconv.Atoi(stem[:2]); err == nil && h >= 0 && h <= 23 {
+			hour = h
+		}
+	}
+
+	// Compute offset: frame time - segment start hour
+	segmentStart := time.Date(frameTime.Year(), frameTime.Month(), frameTime.Day(),
+		hour, 0, 0, 0, frameTime.Location())
+
+	offset := int(frameTime.Sub(segmentStart).Seconds())
+	if offset < 0 {
+		return 0
+	}
+	return offset
 }
 
 // ShouldProcessOnUpload returns whether automatic processing (extract + index)
@@ -429,6 +608,30 @@ func (s *CameraService) ListVideos(id string) ([]models.VideoFile, error) {
 	return files, nil
 }
 
+// RecentVideos returns the paths of a camera's newest limit recordings, oldest
+// first, for pseudo-live playback.
+func (s *CameraService) RecentVideos(id string, limit int) ([]string, error) {
+	files, err := s.ListVideos(id)
+	if err != nil {
+		return nil, err
+	}
+	// ListVideos sorts newest date first but files within a date ascending
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Date != files[j].Date {
+			return files[i].Date > files[j].Date
+		}
+		return files[i].Filename > files[j].Filename
+	})
+	if len(files) > limit {
+		files = files[:limit]
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[len(files)-1-i] = filepath.Join(s.cfg.App.DataDir, "videos", id, f.Date, f.Filename)
+	}
+	return paths, nil
+}
+
 // CleanData removes data for a camera. scope "videos" deletes only videos;
 // scope "all" also removes frames, embeddings, and process history.
 func (s *CameraService) CleanData(id string, scope string) error {
@@ -440,10 +643,12 @@ func (s *CameraService) CleanData(id string, scope string) error {
 	videosDir := filepath.Join(s.cfg.App.DataDir, "videos", id)
 	os.RemoveAll(videosDir)
 	os.MkdirAll(videosDir, 0o755)
+	os.RemoveAll(filepath.Join(s.cfg.App.DataDir, "previews", id))
 
 	if scope == "all" {
 		framesDir := filepath.Join(s.cfg.Extraction.StoragePath, id)
 		os.RemoveAll(framesDir)
+		os.RemoveAll(filepath.Join(s.cfg.App.DataDir, "timelapses", id))
 		s.db.Exec("DELETE FROM clip_embeddings WHERE camera_id = ?", id)
 		s.db.Exec("DELETE FROM face_embeddings WHERE camera_id = ?", id)
 		s.removeFromProcessHistory(id)
@@ -462,19 +667,28 @@ func (s *CameraService) DeleteVideo(id, date, filename string) error {
 	safeDate := filepath.Base(date)
 	safeFile := filepath.Base(filename)
 	if safeDate != date || safeFile != filename || safeDate == ".." || safeFile == ".." {
-		return fmt.Errorf("invalid date or filename")
+		return newError(ErrInvalidInput, "invalid date or filename")
 	}
 
 	filePath := filepath.Join(s.cfg.App.DataDir, "videos", id, safeDate, safeFile)
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("video not found")
+		return newError(ErrVideoNotFound, "video not found")
 	}
 	if err := os.Remove(filePath); err != nil {
 		return fmt.Errorf("removing video: %w", err)
 	}
+	if safeFile == dayArchiveName {
+		os.Remove(filepath.Join(filepath.Dir(filePath), dayArchiveIndex))
+	}
 
 	// Remove frames and embeddings associated with this video
 	s.cleanVideoFramesAndEmbeddings(id, safeDate, filePath)
+	os.RemoveAll(filepath.Join(s.cfg.App.DataDir, "previews", id, safeDate, strings.TrimSuffix(safeFile, filepath.Ext(safeFile))))
+	// Timelapses of the date show the video's frames
+	timelapses, _ := filepath.Glob(filepath.Join(s.cfg.App.DataDir, "timelapses", id, safeDate+"_*.mp4"))
+	for _, t := range timelapses {
+		os.Remove(t)
+	}
 
 	// Clean up empty date directory
 	dateDir := filepath.Join(s.cfg.App.DataDir, "videos", id, safeDate)
@@ -601,7 +815,7 @@ func (s *CameraService) Thumbnail(id string) ([]byte, error) {
 	videosDir := filepath.Join(s.cfg.App.DataDir, "videos", id)
 	dateEntries, err := os.ReadDir(videosDir)
 	if err != nil {
-		return nil, fmt.Errorf("no videos found for camera %s", id)
+		return nil, newError(ErrVideoNotFound, "no videos found for camera %s", id)
 	}
 	// Sort dates newest first
 	sort.Slice(dateEntries, func(i, j int) bool {
@@ -646,7 +860,7 @@ func (s *CameraService) Thumbnail(id string) ([]byte, error) {
 		return data, nil
 	}
 
-	return nil, fmt.Errorf("no videos or frames found for camera %s", id)
+	return nil, newError(ErrVideoNotFound, "no videos or frames found for camera %s", id)
 }
 
 // InvalidateThumbnail removes the cached thumbnail for a camera.
@@ -656,6 +870,9 @@ func (s *CameraService) InvalidateThumbnail(id string) {
 }
 
 func (s *CameraService) removeFromProcessHistory(cameraID string) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
 	historyPath := s.cfg.Process.HistoryPath
 	data, err := os.ReadFile(historyPath)
 	if err != nil {
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/intelsk/backend/models"
+)
+
+// DeleteDate removes one date of a camera: its videos, frames (with their
+// resized variants), previews and timelapses, the CLIP and face embeddings
+// of its frames, the alerts pointing at them and its process history entry,
+// so the date can be downloaded or uploaded and processed again from
+// scratch. Bookmarks and case items of its frames are kept; their frames are
+// then missing. The database changes are one transaction made before any
+// file is touched, so a failure leaves either everything or the files alone,
+// and running it again finishes the job. The caller makes sure no job is
+// using the date.
+func (s *CameraService) DeleteDate(id, date string) (*models.DeletedDate, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return nil, newError(ErrInvalidInput, "date must be YYYY-MM-DD, got %q", date)
+	}
+
+	videosDir := filepath.Join(s.cfg.App.DataDir, "videos", id, date)
+	framesDir := filepath.Join(s.cfg.Extraction.StoragePath, id, date)
+	previewsDir := filepath.Join(s.cfg.App.DataDir, "previews", id, date)
+	frameSizesDir := filepath.Join(s.cfg.App.DataDir, "frame_sizes", id, date)
+	timelapses, _ := filepath.Glob(filepath.Join(s.cfg.App.DataDir, "timelapses", id, date+"_*.mp4"))
+
+	deleted := &models.DeletedDate{CameraID: id, Date: date}
+	deleted.Videos = len(ListVideoFiles(videosDir))
+	if _, err := os.Stat(filepath.Join(videosDir, dayArchiveName)); err == nil {
+		deleted.Videos++
+	}
+	if frames, err := LoadManifest(framesDir); err == nil {
+		deleted.Frames = len(frames)
+	}
+	deleted.Bytes = dirSize(videosDir) + dirSize(framesDir) + dirSize(previewsDir) + dirSize(frameSizesDir)
+	for _, t := range timelapses {
+		if info, err := os.Stat(t); err == nil {
+			deleted.Bytes += info.Size()
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("deleting %s/%s: %w", id, date, err)
+	}
+	defer tx.Rollback()
+	// Rows of the date's frames: frame_path is under its frame directory
+	prefix := framesDir + string(filepath.Separator)
+	under := "camera_id = ? AND substr(frame_path, 1, length(?)) = ?"
+	for _, table := range []string{"clip_embeddings", "face_embeddings"} {
+		res, err := tx.Exec("DELETE FROM "+table+" WHERE "+under, id, prefix, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("deleting %s/%s from %s: %w", id, date, table, err)
+		}
+		n, _ := res.RowsAffected()
+		if table == "clip_embeddings" {
+			deleted.ClipEmbeddings = n
+		} else {
+			deleted.FaceEmbeddings = n
+		}
+	}
+	// The views other cameras had of the alerts stand alone again
+	if _, err := tx.Exec("UPDATE alerts SET group_id = '' WHERE group_id IN (SELECT id FROM alerts WHERE "+under+")", id, prefix, prefix); err != nil {
+		return nil, fmt.Errorf("deleting %s/%s from alerts: %w", id, date, err)
+	}
+	res, err := tx.Exec("DELETE FROM alerts WHERE "+under, id, prefix, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("deleting %s/%s from alerts: %w", id, date, err)
+	}
+	deleted.Alerts, _ = res.RowsAffected()
+
+	if deleted.Videos == 0 && deleted.Frames == 0 && deleted.ClipEmbeddings == 0 && deleted.FaceEmbeddings == 0 && deleted.Bytes == 0 {
+		return nil, newError(ErrVideoNotFound, "camera %s has no data for %s", id, date)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("deleting %s/%s: %w", id, date, err)
+	}
+
+	if err := removeDateFromProcessHistory(s.cfg.Process.HistoryPath, id, date); err != nil {
+		return nil, fmt.Errorf("deleting %s/%s from the process history: %w", id, date, err)
+	}
+	for _, dir := range []string{videosDir, framesDir, previewsDir, frameSizesDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, fmt.Errorf("deleting %s/%s: %w", id, date, err)
+		}
+	}
+	for _, t := range timelapses {
+		if err := os.Remove(t); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("deleting %s/%s: %w", id, date, err)
+		}
+	}
+	s.InvalidateThumbnail(id)
+	return deleted, nil
+}
+
+// removeDateFromProcessHistory drops the history entry of cameraID/date.
+func removeDateFromProcessHistory(path, cameraID, date string) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	history := LoadProcessHistory(path)
+	kept := make([]models.ProcessHistoryEntry, 0, len(history))
+	for _, h := range history {
+		if h.CameraID != cameraID || h.Date != date {
+			kept = append(kept, h)
+		}
+	}
+	if len(kept) == len(history) {
+		return nil
+	}
+	data, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
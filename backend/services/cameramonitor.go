@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Alert rules raised by CameraMonitor.
+const (
+	RuleNVROffline    = "nvr_offline"
+	RuleCameraOffline = "camera_offline"
+	RuleNoRecordings  = "no_recordings"
+)
+
+// CameraMonitor watches the NVR and its Hikvision cameras: every
+// alerts.camera_check_min minutes it pings the NVR, takes a snapshot of each
+// camera's channel and, with alerts.no_recording_hours set, checks that the
+// channel recorded something in that many hours. A check that starts
+// failing raises an alert; one that recovers sends a notification. Silent
+// recording gaps are the worst failure of a CCTV system, found only when
+// the footage is needed.
+type CameraMonitor struct {
+	settings *SettingsService
+	cameras  *CameraService
+	alerts   *AlertService
+
+	mu        sync.Mutex
+	lastCheck time.Time
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewCameraMonitor(settings *SettingsService, cameras *CameraService, alerts *AlertService) *CameraMonitor {
+	return &CameraMonitor{
+		settings: settings,
+		cameras:  cameras,
+		alerts:   alerts,
+		stop:     make(chan struct{}),
+	}
+}
+
+// nvrNow returns the current wall-clock time in the general.timezone zone,
+// labelled UTC like the NVR's recording times.
+func nvrNow(settings *SettingsService) time.Time {
+	now := settings.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.UTC)
+}
+
+// check runs every check once.
+func (m *CameraMonitor) check() {
+	nvrIP := m.settings.Get("nvr.ip")
+	if nvrIP == "" {
+		return
+	}
+	client := NewHikvisionClient(nvrIP, m.settings.Get("nvr.username"), m.settings.Get("nvr.password"))
+	client.SetTimeout(15 * time.Second)
+
+	err := client.Ping()
+	m.alerts.Track(RuleNVROffline, "", "NVR", err, fmt.Sprintf("NVR %s is unreachable", nvrIP))
+	if err != nil {
+		// Every camera goes through the NVR; one alert says it all
+		return
+	}
+
+	cameras, err := m.cameras.List()
+	if err != nil {
+		log.Printf("Camera monitor: listing cameras: %v", err)
+		return
+	}
+	gapHours := m.settings.GetInt("alerts.no_recording_hours")
+	for i := range cameras {
+		cam := &cameras[i]
+		if cam.Type != "hikvision" {
+			continue
+		}
+		channel := NVRChannel(cam)
+		_, err := client.Snapshot(channel)
+		m.alerts.Track(RuleCameraOffline, cam.ID, cam.Name, err, fmt.Sprintf("Camera %s is offline", cam.Name))
+		if err != nil || gapHours == 0 {
+			continue
+		}
+
+		now := nvrNow(m.settings)
+		recordings, err := client.SearchRecordings(channel, now.Add(-time.Duration(gapHours)*time.Hour), now)
+		if err != nil {
+			log.Printf("Camera monitor: searching recordings of %s: %v", cam.ID, err)
+			continue
+		}
+		var gap error
+		if len(recordings) == 0 {
+			gap = fmt.Errorf("NVR channel %d has no recordings in the last %d hours", channel, gapHours)
+		}
+		m.alerts.Track(RuleNoRecordings, cam.ID, cam.Name, gap, fmt.Sprintf("Camera %s stopped recording", cam.Name))
+	}
+}
+
+// tick checks once alerts.camera_check_min minutes have passed since the
+// last check; 0 turns the monitor off.
+func (m *CameraMonitor) tick() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	interval := time.Duration(m.settings.GetInt("alerts.camera_check_min")) * time.Minute
+	if interval == 0 || time.Since(m.lastCheck) < interval {
+		return
+	}
+	m.lastCheck = time.Now()
+	m.check()
+}
+
+// Start checks the cameras until Close.
+func (m *CameraMonitor) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			m.tick()
+			select {
+			case <-ticker.C:
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the checks.
+func (m *CameraMonitor) Close() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/intelsk/backend/models"
+)
+
+// renamedTables lists the tables naming a camera: the column holding frame
+// IDs ({camera_id}_...), if any, and the columns holding paths under the
+// camera's frame and video directories. An optional table exists only at
+// times, like reembedTable while the CLIP model is being switched.
+var renamedTables = []struct {
+	table    string
+	idCol    string
+	frames   string
+	videos   string
+	optional bool
+}{
+	{table: "clip_embeddings", idCol: "id", frames: "frame_path", videos: "source_video"},
+	{table: reembedTable, idCol: "id", frames: "frame_path", videos: "source_video", optional: true},
+	{table: "face_embeddings", idCol: "id", frames: "frame_path"},
+	{table: "bookmarks", idCol: "frame_id", frames: "frame_path", videos: "source_video"},
+	{table: "case_items", idCol: "frame_id", frames: "frame_path", videos: "source_video"},
+	{table: "alerts", frames: "frame_path", videos: "source_video"},
+	{table: "schedules"},
+	{table: "ingest_state"},
+}
+
+// cameraRename is what changes when a camera's ID does: the frame ID prefix
+// and the frame and video directory prefixes of stored paths.
+type cameraRename struct {
+	oldID, newID         string
+	oldFrames, newFrames string
+	oldVideos, newVideos string
+}
+
+// reverse undoes r.
+func (r cameraRename) reverse() cameraRename {
+	return cameraRename{
+		oldID: r.newID, newID: r.oldID,
+		oldFrames: r.newFrames, newFrames: r.oldFrames,
+		oldVideos: r.newVideos, newVideos: r.oldVideos,
+	}
+}
+
+func swapPrefix(s, from, to string) string {
+	if rest, ok := strings.CutPrefix(s, from); ok {
+		return to + rest
+	}
+	return s
+}
+
+func (r cameraRename) frameID(id string) string {
+	return swapPrefix(id, r.oldID+"_", r.newID+"_")
+}
+
+func (r cameraRename) framePath(p string) string {
+	return swapPrefix(p, r.oldFrames+string(filepath.Separator), r.newFrames+string(filepath.Separator))
+}
+
+func (r cameraRename) videoPath(p string) string {
+	return swapPrefix(p, r.oldVideos+string(filepath.Separator), r.newVideos+string(filepath.Separator))
+}
+
+// Rename changes a camera's ID to newID. Its videos, frames, resized frames,
+// previews and timelapses move to directories named for newID, and the
+// embeddings, bookmarks, case items, alerts, schedules, frame manifests,
+// index state and process history naming it follow, so nothing needs
+// re-indexing. The database changes are one transaction, and directories and
+// manifests already changed are put back when a later step fails. The caller
+// makes sure no job is using the camera.
+func (s *CameraService) Rename(id, newID string) (*models.CameraInfo, error) {
+	if !validCameraID.MatchString(newID) {
+		return nil, newError(ErrInvalidInput, "invalid camera ID: must match [a-zA-Z0-9][a-zA-Z0-9_-]{0,63}")
+	}
+	if newID == id {
+		return nil, newError(ErrInvalidInput, "camera %s already has that ID", id)
+	}
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	if _, err := s.Get(newID); err == nil {
+		return nil, newError(ErrAlreadyExists, "camera already exists: %s", newID)
+	}
+
+	r := cameraRename{
+		oldID: id, newID: newID,
+		oldFrames: filepath.Join(s.cfg.Extraction.StoragePath, id),
+		newFrames: filepath.Join(s.cfg.Extraction.StoragePath, newID),
+		oldVideos: filepath.Join(s.cfg.App.DataDir, "videos", id),
+		newVideos: filepath.Join(s.cfg.App.DataDir, "videos", newID),
+	}
+	dirs := [][2]string{
+		{r.oldVideos, r.newVideos},
+		{r.oldFrames, r.newFrames},
+		{filepath.Join(s.cfg.App.DataDir, "previews", id), filepath.Join(s.cfg.App.DataDir, "previews", newID)},
+		{filepath.Join(s.cfg.App.DataDir, "frame_sizes", id), filepath.Join(s.cfg.App.DataDir, "frame_sizes", newID)},
+		{filepath.Join(s.cfg.App.DataDir, "timelapses", id), filepath.Join(s.cfg.App.DataDir, "timelapses", newID)},
+	}
+	for _, d := range dirs {
+		if _, err := os.Stat(d[1]); err == nil {
+			return nil, newError(ErrAlreadyExists, "%s already exists", d[1])
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("renaming camera: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE cameras SET id = ?, updated_at = datetime('now') WHERE id = ?", newID, id); err != nil {
+		return nil, fmt.Errorf("renaming camera: %w", err)
+	}
+	for _, t := range renamedTables {
+		if t.optional {
+			var n int
+			if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", t.table).
+				Scan(&n); err != nil {
+				return nil, fmt.Errorf("renaming camera in %s: %w", t.table, err)
+			}
+			if n == 0 {
+				continue
+			}
+		}
+		if err := renameInTable(tx, t.table, t.idCol, t.frames, t.videos, r); err != nil {
+			return nil, fmt.Errorf("renaming camera in %s: %w", t.table, err)
+		}
+	}
+
+	// Move the directories, then rewrite the manifests in the new one
+	var moved [][2]string
+	undoMoves := func() {
+		for i := len(moved) - 1; i >= 0; i-- {
+			if err := os.Rename(moved[i][1], moved[i][0]); err != nil {
+				log.Printf("Renaming camera %s: moving %s back: %v", id, moved[i][1], err)
+			}
+		}
+	}
+	for _, d := range dirs {
+		if err := os.Rename(d[0], d[1]); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			undoMoves()
+			return nil, fmt.Errorf("renaming camera: moving %s: %w", d[0], err)
+		}
+		moved = append(moved, d)
+	}
+	if err := renameInFrames(r.newFrames, r); err != nil {
+		renameInFrames(r.newFrames, r.reverse())
+		undoMoves()
+		return nil, fmt.Errorf("renaming camera: rewriting manifests: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		renameInFrames(r.newFrames, r.reverse())
+		undoMoves()
+		return nil, fmt.Errorf("renaming camera: %w", err)
+	}
+
+	renameInProcessHistory(s.cfg.Process.HistoryPath, id, newID)
+	s.InvalidateThumbnail(id)
+	return s.Get(newID)
+}
+
+// renameInTable rewrites the camera ID, frame IDs and paths of r's camera
+// in table.
+func renameInTable(tx *sql.Tx, table, idCol, frames, videos string, r cameraRename) error {
+	sets := []string{"camera_id = ?"}
+	args := []any{r.newID}
+	swap := func(col, from, to string) {
+		sets = append(sets, fmt.Sprintf("%[1]s = CASE WHEN substr(%[1]s, 1, length(?)) = ? THEN ? || substr(%[1]s, length(?) + 1) ELSE %[1]s END", col))
+		args = append(args, from, from, to, from)
+	}
+	sep := string(filepath.Separator)
+	if idCol != "" {
+		swap(idCol, r.oldID+"_", r.newID+"_")
+	}
+	if frames != "" {
+		swap(frames, r.oldFrames+sep, r.newFrames+sep)
+	}
+	if videos != "" {
+		swap(videos, r.oldVideos+sep, r.newVideos+sep)
+	}
+	args = append(args, r.oldID)
+	_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE camera_id = ?", table, strings.Join(sets, ", ")), args...)
+	return err
+}
+
+// renameInFrames rewrites the manifests and index state of each date
+// directory in framesDir for r.
+func renameInFrames(framesDir string, r cameraRename) error {
+	entries, err := os.ReadDir(framesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(framesDir, e.Name())
+		frames, err := LoadManifest(dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+		if frames != nil {
+			for i := range frames {
+				frames[i].CameraID = r.newID
+				frames[i].FramePath = r.framePath(frames[i].FramePath)
+				frames[i].SourceVideo = r.videoPath(frames[i].SourceVideo)
+			}
+			if err := WriteManifest(dir, frames); err != nil {
+				return fmt.Errorf("%s: %w", dir, err)
+			}
+		}
+		for _, name := range []string{"index_state.json", "face_state.json"} {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			state := loadIndexState(path)
+			indexed := make(map[string]bool, len(state.IndexedFrames))
+			for k, v := range state.IndexedFrames {
+				indexed[r.frameID(k)] = v
+			}
+			state.IndexedFrames = indexed
+			if err := saveIndexState(path, state); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// renameInProcessHistory moves the process history entries of oldID to
+// newID. Videos are listed by name, so they need no rewriting.
+func renameInProcessHistory(path, oldID, newID string) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	history := LoadProcessHistory(path)
+	changed := false
+	for i := range history {
+		if history[i].CameraID == oldID {
+			history[i].CameraID = newID
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		log.Printf("marshaling process history: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Printf("writing process history: %v", err)
+	}
+}
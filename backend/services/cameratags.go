@@ -0,0 +1,44 @@
+package services
+
+import "strings"
+
+// Bounds on a camera config's "tags".
+const (
+	maxCameraTags   = 32
+	maxCameraTagLen = 64
+)
+
+// CameraTags returns a camera config's "tags", e.g. ["outdoor",
+// "entrance"], which search filters can select cameras by.
+func CameraTags(config map[string]any) []string {
+	list, _ := config["tags"].([]any)
+	tags := make([]string, 0, len(list))
+	for _, v := range list {
+		if t, ok := v.(string); ok {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// ValidateCameraTags checks a camera config's "tags".
+func ValidateCameraTags(camConfig map[string]any) error {
+	v, ok := camConfig["tags"]
+	if !ok {
+		return nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return newError(ErrInvalidInput, "config.tags must be a list of strings")
+	}
+	if len(list) > maxCameraTags {
+		return newError(ErrInvalidInput, "config.tags holds at most %d tags", maxCameraTags)
+	}
+	for _, t := range list {
+		s, ok := t.(string)
+		if !ok || strings.TrimSpace(s) == "" || len(s) > maxCameraTagLen {
+			return newError(ErrInvalidInput, "config.tags must be non-empty strings of at most %d bytes", maxCameraTagLen)
+		}
+	}
+	return nil
+}
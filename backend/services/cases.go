@@ -0,0 +1,484 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/intelsk/backend/models"
+)
+
+const (
+	CaseOpen   = "open"
+	CaseClosed = "closed"
+
+	CaseItemResult = "result"
+	CaseItemFrame  = "frame"
+	CaseItemClip   = "clip"
+	CaseItemNote   = "note"
+)
+
+const (
+	// defaultCaseClipPadSec and maxCaseClipPadSec bound the seconds a clip
+	// item covers on each side of its frame.
+	defaultCaseClipPadSec = 15
+	maxCaseClipPadSec     = 1800
+	maxCaseTitle          = 200
+	maxCaseText           = 10000
+	// caseClipTimeout bounds cutting one clip of an export.
+	caseClipTimeout = 2 * time.Minute
+)
+
+// CaseService keeps investigations in the cases and case_items tables. An
+// item copies its frame's camera, time, file and video from the CLIP
+// index, like a bookmark, so a case outlives the index being rebuilt.
+type CaseService struct {
+	db      *sql.DB
+	storage *Storage
+}
+
+func NewCaseService(storage *Storage) *CaseService {
+	return &CaseService{db: storage.DB(), storage: storage}
+}
+
+const caseColumns = `id, title, description, status, created_at, updated_at,
+	(SELECT COUNT(*) FROM case_items WHERE case_items.case_id = cases.id)`
+
+const caseItemColumns = `id, kind, frame_id, camera_id, timestamp, frame_path, source_video,
+	query, score, start_sec, end_sec, note, created_at`
+
+func scanCase(row interface{ Scan(...any) error }) (models.Case, error) {
+	var c models.Case
+	var createdAt, updatedAt string
+	if err := row.Scan(&c.ID, &c.Title, &c.Description, &c.Status, &createdAt, &updatedAt, &c.ItemCount); err != nil {
+		return c, err
+	}
+	c.CreatedAt, _ = time.Parse(jobTimeLayout, createdAt)
+	c.UpdatedAt, _ = time.Parse(jobTimeLayout, updatedAt)
+	return c, nil
+}
+
+func scanCaseItem(row interface{ Scan(...any) error }) (models.CaseItem, error) {
+	var it models.CaseItem
+	var createdAt string
+	if err := row.Scan(&it.ID, &it.Kind, &it.FrameID, &it.CameraID, &it.Timestamp, &it.FramePath, &it.SourceVideo,
+		&it.Query, &it.Score, &it.StartSec, &it.EndSec, &it.Note, &createdAt); err != nil {
+		return it, err
+	}
+	it.CreatedAt, _ = time.Parse(jobTimeLayout, createdAt)
+	return it, nil
+}
+
+// ValidCaseStatus reports whether status is one of the case statuses.
+func ValidCaseStatus(status string) bool {
+	return status == CaseOpen || status == CaseClosed
+}
+
+func checkCaseText(title, description string) error {
+	if strings.TrimSpace(title) == "" {
+		return newError(ErrInvalidInput, "title is required")
+	}
+	if len(title) > maxCaseTitle {
+		return newError(ErrInvalidInput, "title is limited to %d bytes", maxCaseTitle)
+	}
+	if len(description) > maxCaseText {
+		return newError(ErrInvalidInput, "description is limited to %d bytes", maxCaseText)
+	}
+	return nil
+}
+
+// Create opens a new case.
+func (s *CaseService) Create(req models.CreateCaseRequest) (models.Case, error) {
+	req.Title = strings.TrimSpace(req.Title)
+	if err := checkCaseText(req.Title, req.Description); err != nil {
+		return models.Case{}, err
+	}
+	id := uuid.New().String()
+	now := time.Now().UTC().Format(jobTimeLayout)
+	if _, err := s.db.Exec("INSERT INTO cases (id, title, description, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		id, req.Title, req.Description, CaseOpen, now, now); err != nil {
+		return models.Case{}, fmt.Errorf("inserting case: %w", err)
+	}
+	return s.Get(id)
+}
+
+// List returns the cases, most recently updated first, with their item
+// counts but not their items. An empty status matches every case.
+func (s *CaseService) List(status string) ([]models.Case, error) {
+	query := "SELECT " + caseColumns + " FROM cases"
+	var args []any
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	rows, err := s.db.Query(query+" ORDER BY updated_at DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying cases: %w", err)
+	}
+	defer rows.Close()
+
+	cases := []models.Case{}
+	for rows.Next() {
+		c, err := scanCase(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning case row: %w", err)
+		}
+		cases = append(cases, c)
+	}
+	return cases, rows.Err()
+}
+
+// Get returns a case with its items, in the order they were attached.
+func (s *CaseService) Get(id string) (models.Case, error) {
+	c, err := scanCase(s.db.QueryRow("SELECT "+caseColumns+" FROM cases WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return c, newError(ErrCaseNotFound, "case %s not found", id)
+	}
+	if err != nil {
+		return c, fmt.Errorf("querying case %s: %w", id, err)
+	}
+
+	rows, err := s.db.Query("SELECT "+caseItemColumns+" FROM case_items WHERE case_id = ? ORDER BY created_at, rowid", id)
+	if err != nil {
+		return c, fmt.Errorf("querying case items: %w", err)
+	}
+	defer rows.Close()
+	c.Items = []models.CaseItem{}
+	for rows.Next() {
+		it, err := scanCaseItem(rows)
+		if err != nil {
+			return c, fmt.Errorf("scanning case item row: %w", err)
+		}
+		c.Items = append(c.Items, it)
+	}
+	return c, rows.Err()
+}
+
+// Update changes the fields set in req.
+func (s *CaseService) Update(id string, req models.UpdateCaseRequest) (models.Case, error) {
+	c, err := s.Get(id)
+	if err != nil {
+		return c, err
+	}
+	if req.Title != nil {
+		c.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Description != nil {
+		c.Description = *req.Description
+	}
+	if req.Status != nil {
+		if !ValidCaseStatus(*req.Status) {
+			return c, newError(ErrInvalidInput, "status must be open or closed")
+		}
+		c.Status = *req.Status
+	}
+	if err := checkCaseText(c.Title, c.Description); err != nil {
+		return c, err
+	}
+	if _, err := s.db.Exec("UPDATE cases SET title = ?, description = ?, status = ?, updated_at = ? WHERE id = ?",
+		c.Title, c.Description, c.Status, time.Now().UTC().Format(jobTimeLayout), id); err != nil {
+		return c, fmt.Errorf("updating case: %w", err)
+	}
+	return s.Get(id)
+}
+
+// Delete removes a case and its items.
+func (s *CaseService) Delete(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM cases WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting case: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newError(ErrCaseNotFound, "case %s not found", id)
+	}
+	if _, err := tx.Exec("DELETE FROM case_items WHERE case_id = ?", id); err != nil {
+		return fmt.Errorf("deleting case items: %w", err)
+	}
+	return tx.Commit()
+}
+
+// AddItem attaches an item to a case. The frame of a result, frame or
+// clip must be indexed; a clip also needs its source video.
+func (s *CaseService) AddItem(caseID string, req models.AddCaseItemRequest) (models.CaseItem, error) {
+	if _, err := s.Get(caseID); err != nil {
+		return models.CaseItem{}, err
+	}
+	if len(req.Note) > maxCaseText {
+		return models.CaseItem{}, newError(ErrInvalidInput, "note is limited to %d bytes", maxCaseText)
+	}
+	it := models.CaseItem{ID: uuid.New().String(), Kind: req.Kind, Note: req.Note}
+	switch req.Kind {
+	case CaseItemNote:
+		if strings.TrimSpace(req.Note) == "" {
+			return it, newError(ErrInvalidInput, "a note item needs a note")
+		}
+	case CaseItemResult, CaseItemFrame, CaseItemClip:
+		if req.FrameID == "" {
+			return it, newError(ErrInvalidInput, "a %s item needs a frame_id", req.Kind)
+		}
+		frame, err := s.storage.GetClipEmbedding(req.FrameID)
+		if err != nil {
+			return it, err
+		}
+		it.FrameID, it.CameraID, it.Timestamp = frame.ID, frame.CameraID, frame.Timestamp
+		it.FramePath, it.SourceVideo = frame.FramePath, frame.SourceVideo
+		if req.Kind == CaseItemResult {
+			it.Query, it.Score = req.Query, req.Score
+		}
+		if req.Kind == CaseItemClip {
+			if frame.SourceVideo == "" {
+				return it, newError(ErrVideoNotFound, "frame %s has no source video", req.FrameID)
+			}
+			pad := req.PadSec
+			if pad == 0 {
+				pad = defaultCaseClipPadSec
+			}
+			if pad < 0 || pad > maxCaseClipPadSec {
+				return it, newError(ErrInvalidInput, "pad_sec must be between 1 and %d", maxCaseClipPadSec)
+			}
+			offset := SeekOffset(frame.Timestamp, frame.SourceVideo)
+			it.StartSec, it.EndSec = max(offset-pad, 0), offset+pad
+		}
+	default:
+		return it, newError(ErrInvalidInput, "kind must be result, frame, clip or note")
+	}
+
+	now := time.Now().UTC().Format(jobTimeLayout)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return it, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO case_items (id, case_id, kind, frame_id, camera_id, timestamp, frame_path, source_video,
+		query, score, start_sec, end_sec, note, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		it.ID, caseID, it.Kind, it.FrameID, it.CameraID, it.Timestamp, it.FramePath, it.SourceVideo,
+		it.Query, it.Score, it.StartSec, it.EndSec, it.Note, now); err != nil {
+		return it, fmt.Errorf("inserting case item: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE cases SET updated_at = ? WHERE id = ?", now, caseID); err != nil {
+		return it, fmt.Errorf("updating case: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return it, err
+	}
+	it.CreatedAt, _ = time.Parse(jobTimeLayout, now)
+	return it, nil
+}
+
+// RemoveItem detaches an item from a case.
+func (s *CaseService) RemoveItem(caseID, itemID string) error {
+	res, err := s.db.Exec("DELETE FROM case_items WHERE id = ? AND case_id = ?", itemID, caseID)
+	if err != nil {
+		return fmt.Errorf("deleting case item: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := s.Get(caseID); err != nil {
+			return err
+		}
+		return newError(ErrCaseItemNotFound, "item %s not found in case %s", itemID, caseID)
+	}
+	_, err = s.db.Exec("UPDATE cases SET updated_at = ? WHERE id = ?", time.Now().UTC().Format(jobTimeLayout), caseID)
+	return err
+}
+
+// Export writes c as a zip archive to w: the frame of every item under
+// frames/, the clip items cut from their videos under clips/, case.json and
+// a summary.md report listing the items in order with their files. A frame
+// or clip that can't be read or cut is left out and listed as missing in
+// the report, so the rest of the case is still exported.
+func (s *CaseService) Export(ctx context.Context, c models.Case, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	files := make([][]string, len(c.Items))
+	var missing []string
+
+	// Items of one frame share its file; exported records whether it was
+	exported := make(map[string]bool)
+	for i, it := range c.Items {
+		if it.FramePath != "" {
+			name := "frames/" + it.FrameID + filepath.Ext(it.FramePath)
+			if _, tried := exported[name]; !tried {
+				err := zipFile(zw, name, it.FramePath)
+				if err != nil {
+					log.Printf("Case %s: exporting frame %s: %v", c.ID, it.FrameID, err)
+					missing = append(missing, fmt.Sprintf("%s (%v)", name, err))
+				}
+				exported[name] = err == nil
+			}
+			if exported[name] {
+				files[i] = append(files[i], name)
+			}
+		}
+		if it.Kind == CaseItemClip {
+			name := fmt.Sprintf("clips/%s_%d-%d.mp4", it.FrameID, it.StartSec, it.EndSec)
+			if exported[name] {
+				files[i] = append(files[i], name)
+				continue
+			}
+			if err := s.zipClip(ctx, zw, name, it); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("Case %s: exporting clip of %s: %v", c.ID, it.FrameID, err)
+				missing = append(missing, fmt.Sprintf("%s (%v)", name, err))
+				continue
+			}
+			exported[name] = true
+			files[i] = append(files[i], name)
+		}
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := zipBytes(zw, "case.json", data); err != nil {
+		return err
+	}
+	if err := zipBytes(zw, "summary.md", []byte(caseSummary(c, files, missing))); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// zipClip cuts the clip of a clip item into the archive.
+func (s *CaseService) zipClip(ctx context.Context, zw *zip.Writer, name string, it models.CaseItem) error {
+	if _, err := os.Stat(it.SourceVideo); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "intelsk-case-*.mp4")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	ctx, cancel := context.WithTimeout(ctx, caseClipTimeout)
+	defer cancel()
+	if err := ExtractClip(ctx, it.SourceVideo, tmp.Name(), float64(it.StartSec), float64(it.EndSec)); err != nil {
+		return err
+	}
+	return zipFile(zw, name, tmp.Name())
+}
+
+// zipFile copies the file at path into the archive as name. The archive is
+// only written to once the file is open.
+func zipFile(zw *zip.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Frames and clips are compressed already
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, f)
+	return err
+}
+
+func zipBytes(zw *zip.Writer, name string, data []byte) error {
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(data)
+	return err
+}
+
+// caseSummary renders the summary.md report of an exported case: what it
+// holds, which cameras and period its footage covers, and each item with
+// its note and files.
+func caseSummary(c models.Case, files [][]string, missing []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", c.Title)
+	fmt.Fprintf(&b, "- Case: %s\n- Status: %s\n- Created: %s\n- Updated: %s\n- Exported: %s\n\n",
+		c.ID, c.Status, c.CreatedAt.Format(time.RFC3339), c.UpdatedAt.Format(time.RFC3339),
+		time.Now().UTC().Format(time.RFC3339))
+	if c.Description != "" {
+		fmt.Fprintf(&b, "%s\n\n", c.Description)
+	}
+
+	counts := make(map[string]int)
+	var cameras []string
+	var first, last string
+	for _, it := range c.Items {
+		counts[it.Kind]++
+		if it.CameraID != "" && !slices.Contains(cameras, it.CameraID) {
+			cameras = append(cameras, it.CameraID)
+		}
+		if it.Timestamp != "" {
+			if first == "" || it.Timestamp < first {
+				first = it.Timestamp
+			}
+			if it.Timestamp > last {
+				last = it.Timestamp
+			}
+		}
+	}
+	b.WriteString("## Summary\n\n")
+	fmt.Fprintf(&b, "- Items: %d (%d search results, %d frames, %d clips, %d notes)\n", len(c.Items),
+		counts[CaseItemResult], counts[CaseItemFrame], counts[CaseItemClip], counts[CaseItemNote])
+	if len(cameras) > 0 {
+		fmt.Fprintf(&b, "- Cameras: %s\n", strings.Join(cameras, ", "))
+		fmt.Fprintf(&b, "- Footage: %s to %s\n", first, last)
+	}
+	b.WriteString("\n## Items\n")
+
+	for i, it := range c.Items {
+		fmt.Fprintf(&b, "\n### %d. %s", i+1, caseItemTitle(it))
+		if it.CameraID != "" {
+			fmt.Fprintf(&b, " — %s %s", it.CameraID, it.Timestamp)
+		}
+		b.WriteString("\n\n")
+		if it.Query != "" {
+			fmt.Fprintf(&b, "- Query: %q (score %.3f)\n", it.Query, it.Score)
+		}
+		if it.Kind == CaseItemClip {
+			fmt.Fprintf(&b, "- Clip: %d s to %d s of %s\n", it.StartSec, it.EndSec, filepath.Base(it.SourceVideo))
+		}
+		for _, f := range files[i] {
+			fmt.Fprintf(&b, "- File: [%s](%s)\n", f, f)
+		}
+		fmt.Fprintf(&b, "- Added: %s\n", it.CreatedAt.Format(time.RFC3339))
+		if it.Note != "" {
+			fmt.Fprintf(&b, "\n%s\n", it.Note)
+		}
+	}
+
+	if len(missing) > 0 {
+		b.WriteString("\n## Missing Files\n\n")
+		for _, m := range missing {
+			fmt.Fprintf(&b, "- %s\n", m)
+		}
+	}
+	return b.String()
+}
+
+func caseItemTitle(it models.CaseItem) string {
+	switch it.Kind {
+	case CaseItemResult:
+		return "Search result"
+	case CaseItemFrame:
+		return "Frame"
+	case CaseItemClip:
+		return "Clip"
+	default:
+		return "Note"
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Checksum algorithms FileChecksum supports.
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA256 = "sha256"
+)
+
+// checksumCache remembers file digests by path, algorithm, size and mtime,
+// so downloading a recording again doesn't re-read all of it first.
+var checksumCache = struct {
+	sync.Mutex
+	m map[string]cachedChecksum
+}{m: make(map[string]cachedChecksum)}
+
+type cachedChecksum struct {
+	size    int64
+	modTime time.Time
+	sum     []byte
+}
+
+// FileChecksum returns the MD5 or SHA-256 digest of the file at path.
+func FileChecksum(path, alg string) ([]byte, error) {
+	var h hash.Hash
+	switch alg {
+	case ChecksumMD5:
+		h = md5.New()
+	case ChecksumSHA256:
+		h = sha256.New()
+	default:
+		return nil, newError(ErrInvalidInput, "checksum must be %s or %s, got %q", ChecksumMD5, ChecksumSHA256, alg)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	key := alg + ":" + path
+	checksumCache.Lock()
+	c, ok := checksumCache.m[key]
+	checksumCache.Unlock()
+	if ok && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		return c.sum, nil
+	}
+
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	sum := h.Sum(nil)
+	checksumCache.Lock()
+	checksumCache.m[key] = cachedChecksum{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	checksumCache.Unlock()
+	return sum, nil
+}
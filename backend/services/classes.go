@@ -0,0 +1,80 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Classifier sorts frames into the reports.classes object classes by CLIP
+// zero-shot classification: a frame is of the class whose prompt its
+// embedding is closest to, if it is at least search.min_score close.
+type Classifier struct {
+	encoder  Encoder
+	settings *SettingsService
+
+	// embeddings caches the text embeddings of the prompts by model and
+	// class
+	mu         sync.Mutex
+	embeddings map[string][]float64
+}
+
+func NewClassifier(encoder Encoder, settings *SettingsService) *Classifier {
+	return &Classifier{encoder: encoder, settings: settings, embeddings: make(map[string][]float64)}
+}
+
+// Classes returns the reports.classes classes.
+func (c *Classifier) Classes() []string {
+	var classes []string
+	for _, class := range strings.Split(c.settings.Get("reports.classes"), ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			classes = append(classes, class)
+		}
+	}
+	return classes
+}
+
+// Prompts returns the reports.classes classes with the text embeddings of
+// their prompts, encoded once per model.
+func (c *Classifier) Prompts() ([]string, [][]float64, error) {
+	classes := c.Classes()
+	model := c.settings.Get("clip.model")
+	prompts := make([][]float64, len(classes))
+	for i, class := range classes {
+		key := model + "\x00" + class
+		c.mu.Lock()
+		emb, ok := c.embeddings[key]
+		c.mu.Unlock()
+		if !ok {
+			var err error
+			if emb, err = c.encoder.EncodeText("a photo of a " + class); err != nil {
+				return classes, nil, fmt.Errorf("classifying frames: %w", err)
+			}
+			c.mu.Lock()
+			c.embeddings[key] = emb
+			c.mu.Unlock()
+		}
+		prompts[i] = emb
+	}
+	return classes, prompts, nil
+}
+
+// ClassOf returns the index of the prompt emb is closest to, if it is at
+// least minScore close, or -1.
+func ClassOf(emb []float64, prompts [][]float64, minScore float64) int {
+	best, bestScore := -1, minScore
+	for i, p := range prompts {
+		if len(p) != len(emb) {
+			continue // indexed with another model
+		}
+		// Both are L2-normalized, so the dot product is the cosine
+		var score float64
+		for j := range emb {
+			score += emb[j] * p[j]
+		}
+		if score >= bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
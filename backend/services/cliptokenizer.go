@@ -0,0 +1,166 @@
+package services
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// clipVocabMerges is how many BPE merges of the vocabulary file CLIP uses.
+const clipVocabMerges = 49152 - 256 - 2
+
+var clipTokenPattern = regexp.MustCompile(`(?i)<start_of_text>|<end_of_text>|'s|'t|'re|'ve|'m|'ll|'d|\p{L}+|\p{N}|[^\s\p{L}\p{N}]+`)
+
+// clipTokenizer is the byte-level BPE tokenizer of CLIP text encoders, a
+// port of open_clip's SimpleTokenizer reading the same
+// bpe_simple_vocab_16e6.txt.gz.
+type clipTokenizer struct {
+	encoder     map[string]int
+	ranks       map[[2]string]int
+	byteEncoder [256]string
+	sot, eot    int
+}
+
+// clipByteUnicode maps every byte to a printable rune, the way GPT-2 and
+// CLIP's bytes_to_unicode do, in that function's order.
+func clipByteUnicode() ([]byte, [256]string) {
+	var order []byte
+	var printable [256]bool
+	for _, r := range [][2]int{{'!', '~'}, {0xA1, 0xAC}, {0xAE, 0xFF}} {
+		for b := r[0]; b <= r[1]; b++ {
+			printable[b] = true
+			order = append(order, byte(b))
+		}
+	}
+	var enc [256]string
+	for b := range 256 {
+		if printable[b] {
+			enc[b] = string(rune(b))
+		}
+	}
+	n := 0
+	for b := range 256 {
+		if !printable[b] {
+			enc[b] = string(rune(256 + n))
+			order = append(order, byte(b))
+			n++
+		}
+	}
+	return order, enc
+}
+
+func loadCLIPTokenizer(vocabPath string) (*clipTokenizer, error) {
+	f, err := os.Open(vocabPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", vocabPath, err)
+	}
+	defer gz.Close()
+
+	var merges [][2]string
+	scanner := bufio.NewScanner(gz)
+	scanner.Scan() // version header
+	for len(merges) < clipVocabMerges && scanner.Scan() {
+		a, b, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			return nil, fmt.Errorf("reading %s: malformed merge %q", vocabPath, scanner.Text())
+		}
+		merges = append(merges, [2]string{a, b})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", vocabPath, err)
+	}
+
+	t := &clipTokenizer{encoder: make(map[string]int), ranks: make(map[[2]string]int, len(merges))}
+	order, enc := clipByteUnicode()
+	t.byteEncoder = enc
+	var vocab []string
+	for _, b := range order {
+		vocab = append(vocab, enc[b])
+	}
+	for _, b := range order {
+		vocab = append(vocab, enc[b]+"</w>")
+	}
+	for i, m := range merges {
+		vocab = append(vocab, m[0]+m[1])
+		t.ranks[m] = i
+	}
+	vocab = append(vocab, "<start_of_text>", "<end_of_text>")
+	for i, v := range vocab {
+		t.encoder[v] = i
+	}
+	t.sot, t.eot = t.encoder["<start_of_text>"], t.encoder["<end_of_text>"]
+	return t, nil
+}
+
+// bpe splits a word, in byte-encoded runes, into vocabulary entries.
+func (t *clipTokenizer) bpe(token string) []string {
+	var word []string
+	for _, r := range token {
+		word = append(word, string(r))
+	}
+	word[len(word)-1] += "</w>"
+	for len(word) > 1 {
+		best, bestRank := -1, 0
+		for i := 0; i < len(word)-1; i++ {
+			if rank, ok := t.ranks[[2]string{word[i], word[i+1]}]; ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		first, second := word[best], word[best+1]
+		merged := word[:0:0]
+		for i := 0; i < len(word); i++ {
+			if i < len(word)-1 && word[i] == first && word[i+1] == second {
+				merged = append(merged, first+second)
+				i++
+			} else {
+				merged = append(merged, word[i])
+			}
+		}
+		word = merged
+	}
+	return word
+}
+
+// tokenize encodes text into contextLength token IDs: start of text, the
+// text, end of text, zero padding. Text too long is cut, keeping the end
+// of text token.
+func (t *clipTokenizer) tokenize(text string, contextLength int) []int64 {
+	text = html.UnescapeString(html.UnescapeString(text))
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+
+	ids := []int64{int64(t.sot)}
+	for _, token := range clipTokenPattern.FindAllString(text, -1) {
+		if id, ok := t.encoder[token]; ok && (id == t.sot || id == t.eot) {
+			ids = append(ids, int64(id))
+			continue
+		}
+		var encoded strings.Builder
+		for _, b := range []byte(token) {
+			encoded.WriteString(t.byteEncoder[b])
+		}
+		for _, piece := range t.bpe(encoded.String()) {
+			ids = append(ids, int64(t.encoder[piece]))
+		}
+	}
+	ids = append(ids, int64(t.eot))
+
+	tokens := make([]int64, contextLength)
+	if len(ids) > contextLength {
+		ids = ids[:contextLength]
+		ids[contextLength-1] = int64(t.eot)
+	}
+	copy(tokens, ids)
+	return tokens
+}
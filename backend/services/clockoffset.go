@@ -0,0 +1,50 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+)
+
+// maxClockOffsetSec bounds a camera's clock_offset_sec to a day either way.
+const maxClockOffsetSec = 24 * 3600
+
+// ClockOffset returns a camera config's "clock_offset_sec": the seconds to
+// add to the camera's clock to get the real time. Defaults to 0.
+func ClockOffset(config map[string]any) int {
+	v, ok := config["clock_offset_sec"].(float64)
+	if !ok {
+		return 0
+	}
+	return int(math.Round(v))
+}
+
+// ValidateClockOffset checks a camera config's "clock_offset_sec".
+func ValidateClockOffset(camConfig map[string]any) error {
+	v, ok := camConfig["clock_offset_sec"]
+	if !ok {
+		return nil
+	}
+	sec, ok := v.(float64)
+	if !ok || sec != math.Trunc(sec) {
+		return newError(ErrInvalidInput, "config.clock_offset_sec must be a whole number of seconds")
+	}
+	if math.Abs(sec) > maxClockOffsetSec {
+		return newError(ErrInvalidInput, "config.clock_offset_sec must be between %d and %d", -maxClockOffsetSec, maxClockOffsetSec)
+	}
+	return nil
+}
+
+// cameraClockOffset reads the clock offset of cameraID from the database.
+// Cameras without a row (filesystem-only ones) have none.
+func cameraClockOffset(db *sql.DB, cameraID string) int {
+	var configJSON string
+	if err := db.QueryRow("SELECT config FROM cameras WHERE id = ?", cameraID).Scan(&configJSON); err != nil {
+		return 0
+	}
+	var config map[string]any
+	if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
+		return 0
+	}
+	return ClockOffset(config)
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/intelsk/backend/models"
+)
+
+// DedupResult summarises a de-duplication pass over one frames directory.
+type DedupResult struct {
+	CameraID       string `json:"camera_id"`
+	Date           string `json:"date"`
+	Frames         int    `json:"frames"`
+	Duplicates     int    `json:"duplicates"`
+	ClipEmbeddings int64  `json:"clip_embeddings_deleted"`
+	FaceEmbeddings int64  `json:"face_embeddings_deleted"`
+	DryRun         bool   `json:"dry_run,omitempty"`
+}
+
+// DedupFrames runs perceptual-hash de-duplication over frames that were
+// already extracted for a camera+date. No hashes are stored, so they are
+// recomputed from the JPEGs. Frames are compared per source video, exactly
+// as extraction does. Duplicates lose their JPEG, their CLIP and face
+// embeddings and their index state entry, and the manifest is rewritten.
+// With dryRun nothing is changed.
+func DedupFrames(storage *Storage, framesDir string, threshold int, dryRun bool) (*DedupResult, error) {
+	frames, err := LoadManifest(framesDir)
+	if err != nil {
+		return nil, err
+	}
+	result := &DedupResult{
+		CameraID: filepath.Base(filepath.Dir(framesDir)),
+		Date:     filepath.Base(framesDir),
+		Frames:   len(frames),
+		DryRun:   dryRun,
+	}
+
+	// Group by source video, keeping manifest order. Frames whose JPEG is
+	// already gone are left alone.
+	var videos []string
+	byVideo := make(map[string][]models.FrameMetadata)
+	for _, f := range frames {
+		if _, err := os.Stat(f.FramePath); err != nil {
+			continue
+		}
+		if _, ok := byVideo[f.SourceVideo]; !ok {
+			videos = append(videos, f.SourceVideo)
+		}
+		byVideo[f.SourceVideo] = append(byVideo[f.SourceVideo], f)
+	}
+
+	var dups []models.FrameMetadata
+	for _, v := range videos {
+		_, d, err := findDuplicateFrames(byVideo[v], threshold)
+		if err != nil {
+			return nil, err
+		}
+		dups = append(dups, d...)
+	}
+	result.Duplicates = len(dups)
+	if dryRun || len(dups) == 0 {
+		return result, nil
+	}
+
+	paths := make([]string, len(dups))
+	isDup := make(map[string]bool, len(dups))
+	for i, f := range dups {
+		paths[i] = f.FramePath
+		isDup[f.FramePath] = true
+	}
+	result.ClipEmbeddings, result.FaceEmbeddings, err = storage.DeleteFrameEmbeddings(paths)
+	if err != nil {
+		return nil, fmt.Errorf("deleting embeddings: %w", err)
+	}
+
+	kept := make([]models.FrameMetadata, 0, len(frames)-len(dups))
+	for _, f := range frames {
+		if !isDup[f.FramePath] {
+			kept = append(kept, f)
+		}
+	}
+	if err := WriteManifest(framesDir, kept); err != nil {
+		return nil, fmt.Errorf("writing manifest: %w", err)
+	}
+
+	statePath := filepath.Join(framesDir, "index_state.json")
+	if _, err := os.Stat(statePath); err == nil {
+		state := loadIndexState(statePath)
+		for _, f := range dups {
+			delete(state.IndexedFrames, frameID(f))
+		}
+		if err := saveIndexState(statePath, state); err != nil {
+			return nil, fmt.Errorf("saving index state: %w", err)
+		}
+	}
+
+	for _, f := range dups {
+		os.Remove(f.FramePath)
+	}
+	return result, nil
+}
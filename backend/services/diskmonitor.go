@@ -0,0 +1,108 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/intelsk/backend/config"
+)
+
+// Alert rules raised by DiskMonitor.
+const (
+	RuleDiskLow      = "disk_low"
+	RuleDiskCritical = "disk_critical"
+	RuleDBSize       = "db_size"
+)
+
+const gigabyte = 1 << 30
+
+// DiskMonitor checks the free space of the data disk and the size of the
+// database every minute against the alerts.disk_low_gb,
+// alerts.disk_critical_gb and alerts.db_size_gb thresholds, raising an alert
+// when one is crossed. Below the critical threshold, with
+// jobs.pause_on_low_disk set, Critical makes jobs pause before their next
+// download or extraction rather than fail mid-write and leave a truncated
+// video or manifest behind.
+type DiskMonitor struct {
+	cfg      *config.AppConfig
+	settings *SettingsService
+	alerts   *AlertService
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewDiskMonitor(cfg *config.AppConfig, settings *SettingsService, alerts *AlertService) *DiskMonitor {
+	return &DiskMonitor{
+		cfg:      cfg,
+		settings: settings,
+		alerts:   alerts,
+		stop:     make(chan struct{}),
+	}
+}
+
+// belowThreshold returns an error describing free space under the
+// threshold of setting key, or nil when there is more or it is 0 (off).
+func (d *DiskMonitor) belowThreshold(usage *DiskUsage, key string) error {
+	threshold := d.settings.GetFloat64(key)
+	if threshold <= 0 || float64(usage.FreeBytes) >= threshold*gigabyte {
+		return nil
+	}
+	return fmt.Errorf("%.1f GB free on the data disk (%s), below the %g GB of %s",
+		float64(usage.FreeBytes)/gigabyte, d.cfg.App.DataDir, threshold, key)
+}
+
+// Critical returns an error while new downloads and extractions should
+// wait: jobs.pause_on_low_disk is set and the data disk has less free space
+// than alerts.disk_critical_gb. It is meant for JobManager.WithDiskCheck.
+func (d *DiskMonitor) Critical() error {
+	if !d.settings.GetBool("jobs.pause_on_low_disk") {
+		return nil
+	}
+	usage, err := GetDiskUsage(d.cfg.App.DataDir)
+	if err != nil {
+		return nil
+	}
+	return d.belowThreshold(usage, "alerts.disk_critical_gb")
+}
+
+// check runs every check once.
+func (d *DiskMonitor) check() {
+	if usage, err := GetDiskUsage(d.cfg.App.DataDir); err == nil {
+		d.alerts.Track(RuleDiskLow, "", "Data disk space", d.belowThreshold(usage, "alerts.disk_low_gb"),
+			"Data disk space is low")
+		d.alerts.Track(RuleDiskCritical, "", "Data disk space", d.belowThreshold(usage, "alerts.disk_critical_gb"),
+			"Data disk space is critically low")
+	}
+
+	var dbErr error
+	limit := d.settings.GetFloat64("alerts.db_size_gb")
+	if fi, err := os.Stat(d.cfg.Storage.DBPath); err == nil && limit > 0 && float64(fi.Size()) > limit*gigabyte {
+		dbErr = fmt.Errorf("the database (%s) is %.1f GB, over the %g GB of alerts.db_size_gb",
+			d.cfg.Storage.DBPath, float64(fi.Size())/gigabyte, limit)
+	}
+	d.alerts.Track(RuleDBSize, "", "Database size", dbErr, "Database is large")
+}
+
+// Start checks the disk every minute until Close.
+func (d *DiskMonitor) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			d.check()
+			select {
+			case <-ticker.C:
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the checks.
+func (d *DiskMonitor) Close() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// How videos.duplicates treats a video that duplicates one already stored.
+const (
+	DuplicatesSkip = "skip" // delete the new copy
+	DuplicatesFlag = "flag" // keep it, renamed with DuplicateSuffix
+	DuplicatesOff  = "off"  // keep and process it like any other video
+)
+
+// DuplicateSuffix is appended to the name of a flagged duplicate. Only
+// .mp4 files are listed, extracted and indexed, so it is kept for review
+// without being processed.
+const DuplicateSuffix = ".duplicate"
+
+// duplicateToleranceSec is how far apart two durations may be and still
+// count as the same recording; NVR downloads of one recording can differ
+// by a keyframe.
+const duplicateToleranceSec = 2.0
+
+// DuplicateMode returns the videos.duplicates setting, DuplicatesSkip for
+// a value it doesn't know.
+func DuplicateMode(settings *SettingsService) string {
+	switch mode := strings.TrimSpace(strings.ToLower(settings.Get("videos.duplicates"))); mode {
+	case DuplicatesFlag, DuplicatesOff:
+		return mode
+	default:
+		return DuplicatesSkip
+	}
+}
+
+// FindDuplicateVideo returns the video in dir, i.e. of the same camera and
+// date, that path duplicates when stored there as name, or "" if none does.
+// A video is a duplicate when it has the same size and SHA-256 as another,
+// or when both have a start in their name (HHMM, with or without a _n
+// suffix) and cover the same span: starts within the minute the names round
+// to and matching durations, as when a recording is uploaded or downloaded
+// again.
+func FindDuplicateVideo(path, dir, name string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	names := ListVideoFiles(dir)
+	start, hasStart := recordingStartSec(strings.TrimSuffix(name, filepath.Ext(name)))
+	var duration float64
+	if hasStart {
+		if duration, err = videoDuration(path, info); err != nil {
+			hasStart = false // not probeable, so only a byte match can tell
+		}
+	}
+
+	var sum []byte
+	for _, otherName := range names {
+		other := filepath.Join(dir, otherName)
+		if other == path {
+			continue
+		}
+		otherInfo, err := os.Stat(other)
+		if err != nil {
+			continue
+		}
+		if otherInfo.Size() == info.Size() {
+			if sum == nil {
+				if sum, err = FileChecksum(path, ChecksumSHA256); err != nil {
+					return "", err
+				}
+			}
+			if otherSum, err := FileChecksum(other, ChecksumSHA256); err == nil && bytes.Equal(sum, otherSum) {
+				return other, nil
+			}
+		}
+		if !hasStart {
+			continue
+		}
+		otherStart, ok := recordingStartSec(strings.TrimSuffix(otherName, filepath.Ext(otherName)))
+		if !ok {
+			continue
+		}
+		otherDuration, err := videoDuration(other, otherInfo)
+		if err != nil {
+			continue
+		}
+		// Names only have the minute, so the spans of the same recording can
+		// be up to a minute apart; consecutive recordings are a whole
+		// recording apart
+		if math.Abs(start-otherStart) <= 60 && math.Abs(duration-otherDuration) <= duplicateToleranceSec {
+			return other, nil
+		}
+	}
+	return "", nil
+}
+
+// DownloadedRecording returns the video in dir already holding the NVR
+// recording rec: named after its start minute (HHMM.mp4 or HHMM_n.mp4)
+// with a matching duration, or the day archive it was merged into. NVR
+// downloads check it first, unless videos.duplicates is off, so a
+// recording isn't fetched twice.
+func DownloadedRecording(dir string, rec Recording) string {
+	base := rec.StartTime.Format("1504")
+	want := rec.EndTime.Sub(rec.StartTime).Seconds()
+	names := ListVideoFiles(dir)
+	for _, name := range names {
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		if stem != base && !strings.HasPrefix(stem, base+"_") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if d, err := videoDuration(path, info); err == nil && math.Abs(d-want) <= duplicateToleranceSec {
+			return path
+		}
+	}
+	if archive := loadDayArchive(dir); archive != nil {
+		for _, seg := range archive.Segments {
+			stem := strings.TrimSuffix(seg.Name, filepath.Ext(seg.Name))
+			if (stem == base || strings.HasPrefix(stem, base+"_")) && math.Abs(seg.DurationSec-want) <= duplicateToleranceSec {
+				return filepath.Join(dir, dayArchiveName)
+			}
+		}
+	}
+	return ""
+}
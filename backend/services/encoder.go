@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+)
+
+// Encoder computes CLIP embeddings and searches frames by text: the ML
+// sidecar (MLClient) or, for small deployments without Python, the ONNX
+// encoders run in the backend itself (ONNXEncoder).
+type Encoder interface {
+	ImageEncoder
+	// EncodeImagesPreset encodes with the model of preset, loaded next to
+	// the current one, so an index can be re-embedded for a model switch
+	// while search still uses the old model
+	EncodeImagesPreset(ctx context.Context, preset string, paths []string) ([][]float64, error)
+	EncodeText(text string) ([]float64, error)
+	SearchByText(dbPath, text string, cameraIDs []string,
+		startTime, endTime string, limit int, minScore float64) ([]models.SearchResult, error)
+	GetModelInfo() (*ModelInfo, error)
+	ReloadModel(preset string) (*ModelInfo, error)
+	HealthCheck() error
+	WaitForReady(timeout time.Duration) error
+	ReadyTimeout() time.Duration
+}
+
+// Values of mlservice.backend.
+const (
+	BackendSidecar = "sidecar"
+	BackendONNX    = "onnx"
+)
+
+// NewEncoder returns the encoder mlservice.backend picks: mlClient, or an
+// ONNXEncoder over the models exported to mlservice.onnx_dir.
+func NewEncoder(cfg *config.AppConfig, mlClient *MLClient) (Encoder, error) {
+	switch cfg.MLService.Backend {
+	case BackendSidecar, "":
+		return mlClient, nil
+	case BackendONNX:
+		return NewONNXEncoder(cfg.MLService.ONNXDir, cfg.MLService.ONNXLibrary, cfg.CLIP.BatchSize), nil
+	default:
+		return nil, fmt.Errorf("unknown mlservice.backend %q (want %s or %s)", cfg.MLService.Backend, BackendSidecar, BackendONNX)
+	}
+}
+
+// searchEmbeddings ranks the CLIP embeddings stored in the database at
+// dbPath by cosine similarity to query, like the sidecar's /search/image:
+// the best limit results scoring at least minScore, with optional camera
+// and time filters. An endTime without a time of day covers that whole day.
+func searchEmbeddings(dbPath string, query []float64, cameraIDs []string,
+	startTime, endTime string, limit int, minScore float64) ([]models.SearchResult, error) {
+	storage, err := OpenStorageReadOnly(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", dbPath, err)
+	}
+	defer storage.Close()
+
+	q := "SELECT id, embedding, camera_id, timestamp, frame_path, source_video FROM clip_embeddings"
+	var where []string
+	var args []any
+	if len(cameraIDs) > 0 {
+		where = append(where, "camera_id IN (?"+strings.Repeat(", ?", len(cameraIDs)-1)+")")
+		for _, id := range cameraIDs {
+			args = append(args, id)
+		}
+	}
+	if startTime != "" {
+		where = append(where, "timestamp >= ?")
+		args = append(args, startTime)
+	}
+	if endTime != "" {
+		if !strings.Contains(endTime, "T") {
+			endTime += "T23:59:59"
+		}
+		where = append(where, "timestamp <= ?")
+		args = append(args, endTime)
+	}
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := storage.DB().Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying embeddings: %w", err)
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var r models.SearchResult
+		var blob []byte
+		if err := rows.Scan(&r.ID, &blob, &r.CameraID, &r.Timestamp, &r.FramePath, &r.SourceVideo); err != nil {
+			return nil, fmt.Errorf("scanning embedding row: %w", err)
+		}
+		emb := BytesToFloat64s(blob)
+		if len(emb) != len(query) {
+			// Indexed with another model; it can't be compared
+			continue
+		}
+		// Both are L2-normalized, so the dot product is the cosine
+		for i := range emb {
+			r.Score += emb[i] * query[i]
+		}
+		if r.Score >= minScore {
+			results = append(results, r)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
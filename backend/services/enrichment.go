@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intelsk/backend/models"
+)
+
+const (
+	// enrichmentTimeout bounds one weather lookup.
+	enrichmentTimeout = 15 * time.Second
+	// weatherRefresh is how long the cached weather of a day that isn't
+	// over yet is used before it is looked up again.
+	weatherRefresh = time.Hour
+	// weatherRetry is how long after a failed lookup a day's weather isn't
+	// asked for again, so a provider that is down doesn't slow every search.
+	weatherRetry = 10 * time.Minute
+)
+
+// Light at a frame, by the sun's altitude.
+const (
+	LightDay      = "day"
+	LightTwilight = "twilight"
+	LightNight    = "night"
+)
+
+// WeatherProvider looks up the hourly weather of a day at a site. The one
+// used is named by enrichment.weather_provider.
+type WeatherProvider interface {
+	Name() string
+	// Hourly returns the weather of each hour of date (YYYY-MM-DD) in loc
+	// at lat and lon. endpoint is enrichment.weather_url, empty for the
+	// provider's public API.
+	Hourly(ctx context.Context, endpoint string, lat, lon float64, date string, loc *time.Location) ([]models.WeatherHour, error)
+}
+
+// EnrichmentService attaches the outside context of the cameras' site to
+// frames and days: whether it was day, twilight or night, computed from
+// enrichment.latitude and enrichment.longitude, and the weather from the
+// enrichment.weather_provider. Weather is cached in the database per day
+// and site; a day that is over is looked up once.
+type EnrichmentService struct {
+	db        *sql.DB
+	settings  *SettingsService
+	providers map[string]WeatherProvider
+
+	mu     sync.Mutex
+	failed map[string]failedLookup // by date and site
+}
+
+type failedLookup struct {
+	at  time.Time
+	err error
+}
+
+// siteDay is a day's context with the sun crossings its light is read from.
+type siteDay struct {
+	context      *models.DayContext
+	sun, civil   sunCrossing
+	weatherHours map[int]*models.WeatherHour
+}
+
+func NewEnrichmentService(db *sql.DB, settings *SettingsService) *EnrichmentService {
+	client := &http.Client{Timeout: enrichmentTimeout}
+	s := &EnrichmentService{
+		db:        db,
+		settings:  settings,
+		providers: make(map[string]WeatherProvider),
+		failed:    make(map[string]failedLookup),
+	}
+	for _, p := range []WeatherProvider{&openMeteoProvider{client: client}} {
+		s.providers[p.Name()] = p
+	}
+	return s
+}
+
+// Enabled is whether the site's location is set.
+func (s *EnrichmentService) Enabled() bool {
+	return s.settings.GetFloat64("enrichment.latitude") != 0 || s.settings.GetFloat64("enrichment.longitude") != 0
+}
+
+// Day returns the context of date (YYYY-MM-DD), or nil with enrichment off.
+// A failed weather lookup leaves the weather empty, with WeatherError set.
+func (s *EnrichmentService) Day(date string) (*models.DayContext, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	d, err := time.ParseInLocation("2006-01-02", date, s.settings.Location())
+	if err != nil {
+		return nil, newError(ErrInvalidInput, "date must be YYYY-MM-DD, got %q", date)
+	}
+	return s.day(d).context, nil
+}
+
+// Frames returns the context of frames taken at stored timestamps, nil
+// for each with enrichment off or a timestamp that doesn't parse.
+func (s *EnrichmentService) Frames(timestamps []string) []*models.FrameContext {
+	contexts := make([]*models.FrameContext, len(timestamps))
+	if !s.Enabled() {
+		return contexts
+	}
+	loc := s.settings.Location()
+	days := make(map[string]*siteDay)
+	for i, ts := range timestamps {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			continue
+		}
+		t = t.In(loc)
+		date := t.Format("2006-01-02")
+		if days[date] == nil {
+			days[date] = s.day(t)
+		}
+		contexts[i] = days[date].frame(t)
+	}
+	return contexts
+}
+
+// light returns the light at t, a time of the day in general.timezone.
+func (d *siteDay) light(t time.Time) string {
+	switch {
+	case d.sun.above(t):
+		return LightDay
+	case d.civil.above(t):
+		return LightTwilight
+	}
+	return LightNight
+}
+
+// frame returns the context of a frame taken at t, a time of the day.
+func (d *siteDay) frame(t time.Time) *models.FrameContext {
+	return &models.FrameContext{Light: d.light(t), Weather: d.weatherHours[t.Hour()]}
+}
+
+// HourLights returns the light at the middle of each hour of date, or nil
+// with enrichment off.
+func (s *EnrichmentService) HourLights(date string) []string {
+	if !s.Enabled() {
+		return nil
+	}
+	loc := s.settings.Location()
+	d, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return nil
+	}
+	sd := &siteDay{}
+	sd.sun, sd.civil = s.sunOn(d)
+	lights := make([]string, 24)
+	for h := range lights {
+		lights[h] = sd.light(time.Date(d.Year(), d.Month(), d.Day(), h, 30, 0, 0, loc))
+	}
+	return lights
+}
+
+func (s *EnrichmentService) sunOn(d time.Time) (sun, civil sunCrossing) {
+	lat := s.settings.GetFloat64("enrichment.latitude")
+	lon := s.settings.GetFloat64("enrichment.longitude")
+	return sunCrossingOn(d, lat, lon, sunriseAltitude), sunCrossingOn(d, lat, lon, civilAltitude)
+}
+
+// day builds the context of the day of d, a time in general.timezone.
+func (s *EnrichmentService) day(d time.Time) *siteDay {
+	loc := d.Location()
+	date := d.Format("2006-01-02")
+	sd := &siteDay{
+		context:      &models.DayContext{Date: date, Weather: []models.WeatherHour{}},
+		weatherHours: make(map[int]*models.WeatherHour),
+	}
+	sd.sun, sd.civil = s.sunOn(d)
+	sunTime := func(c sunCrossing, rise bool) string {
+		if c.up || c.down {
+			return ""
+		}
+		if rise {
+			return c.rise.In(loc).Format(time.RFC3339)
+		}
+		return c.set.In(loc).Format(time.RFC3339)
+	}
+	sd.context.Dawn = sunTime(sd.civil, true)
+	sd.context.Sunrise = sunTime(sd.sun, true)
+	sd.context.Sunset = sunTime(sd.sun, false)
+	sd.context.Dusk = sunTime(sd.civil, false)
+
+	weather, err := s.weather(date, loc)
+	if err != nil {
+		sd.context.WeatherError = err.Error()
+	}
+	if weather != nil {
+		sd.context.Weather = weather
+	}
+	for i := range sd.context.Weather {
+		sd.weatherHours[sd.context.Weather[i].Hour] = &sd.context.Weather[i]
+	}
+	return sd
+}
+
+// weather returns the hourly weather of date from the cache or the
+// provider, nil without a provider.
+func (s *EnrichmentService) weather(date string, loc *time.Location) ([]models.WeatherHour, error) {
+	name := strings.TrimSpace(strings.ToLower(s.settings.Get("enrichment.weather_provider")))
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown weather provider %q", name)
+	}
+	lat := s.settings.GetFloat64("enrichment.latitude")
+	lon := s.settings.GetFloat64("enrichment.longitude")
+	site := fmt.Sprintf("%s:%.4f,%.4f", name, lat, lon)
+	key := date + " " + site
+
+	d, _ := time.ParseInLocation("2006-01-02", date, loc)
+	dayEnd := d.AddDate(0, 0, 1)
+	var data, fetchedAt string
+	err := s.db.QueryRow("SELECT weather, fetched_at FROM day_context WHERE date = ? AND site = ?", date, site).Scan(&data, &fetchedAt)
+	if err == nil {
+		fetched, _ := time.Parse(time.RFC3339, fetchedAt)
+		if fetched.After(dayEnd) || time.Since(fetched) < weatherRefresh {
+			var hours []models.WeatherHour
+			if err := json.Unmarshal([]byte(data), &hours); err == nil {
+				return hours, nil
+			}
+		}
+	} else if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("reading cached weather: %w", err)
+	}
+
+	s.mu.Lock()
+	f, failed := s.failed[key]
+	s.mu.Unlock()
+	if failed && time.Since(f.at) < weatherRetry {
+		return nil, f.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), enrichmentTimeout)
+	defer cancel()
+	hours, err := provider.Hourly(ctx, s.settings.Get("enrichment.weather_url"), lat, lon, date, loc)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+		log.Printf("Looking up the weather of %s: %v", date, err)
+		s.mu.Lock()
+		s.failed[key] = failedLookup{at: time.Now(), err: err}
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.mu.Lock()
+	delete(s.failed, key)
+	s.mu.Unlock()
+
+	blob, err := json.Marshal(hours)
+	if err != nil {
+		return hours, nil
+	}
+	if _, err := s.db.Exec(`INSERT INTO day_context (date, site, weather, fetched_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(date, site) DO UPDATE SET weather = excluded.weather, fetched_at = excluded.fetched_at`,
+		date, site, string(blob), FormatTimestamp(time.Now())); err != nil {
+		log.Printf("Caching the weather of %s: %v", date, err)
+	}
+	return hours, nil
+}
+
+// Open-Meteo's public APIs: the forecast API reaches back about three
+// months, the archive API covers the years before.
+const (
+	openMeteoForecastURL = "https://api.open-meteo.com/v1/forecast"
+	openMeteoArchiveURL  = "https://archive-api.open-meteo.com/v1/archive"
+	openMeteoForecastAge = 90 * 24 * time.Hour
+)
+
+// openMeteoProvider looks the weather up in Open-Meteo, which needs no key.
+type openMeteoProvider struct {
+	client *http.Client
+}
+
+func (p *openMeteoProvider) Name() string { return "open-meteo" }
+
+func (p *openMeteoProvider) Hourly(ctx context.Context, endpoint string, lat, lon float64, date string, loc *time.Location) ([]models.WeatherHour, error) {
+	d, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == "" {
+		endpoint = openMeteoForecastURL
+		if time.Since(d) > openMeteoForecastAge {
+			endpoint = openMeteoArchiveURL
+		}
+	}
+	// Ask in UTC for the UTC days the local day spans, and sort the hours
+	// into the local day here, which keeps DST changes right
+	q := url.Values{}
+	q.Set("latitude", fmt.Sprintf("%.4f", lat))
+	q.Set("longitude", fmt.Sprintf("%.4f", lon))
+	q.Set("start_date", d.UTC().Format("2006-01-02"))
+	q.Set("end_date", d.AddDate(0, 0, 1).Add(-time.Second).UTC().Format("2006-01-02"))
+	q.Set("hourly", "temperature_2m,precipitation,cloud_cover,weather_code")
+	q.Set("timezone", "GMT")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Reason string `json:"reason"`
+		Hourly struct {
+			Time          []string   `json:"time"`
+			Temperature   []*float64 `json:"temperature_2m"`
+			Precipitation []*float64 `json:"precipitation"`
+			CloudCover    []*float64 `json:"cloud_cover"`
+			WeatherCode   []*int     `json:"weather_code"`
+		} `json:"hourly"`
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data[:min(len(data), 200)])))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, body.Reason)
+	}
+
+	at := func(values []*float64, i int) *float64 {
+		if i < len(values) {
+			return values[i]
+		}
+		return nil
+	}
+	hours := []models.WeatherHour{}
+	seen := make(map[int]bool)
+	for i, ts := range body.Hourly.Time {
+		t, err := time.ParseInLocation("2006-01-02T15:04", ts, time.UTC)
+		if err != nil {
+			continue
+		}
+		t = t.In(loc)
+		// The repeated hour of a DST change keeps its first reading
+		if t.Format("2006-01-02") != date || seen[t.Hour()] {
+			continue
+		}
+		seen[t.Hour()] = true
+		h := models.WeatherHour{
+			Hour:            t.Hour(),
+			TemperatureC:    at(body.Hourly.Temperature, i),
+			PrecipitationMM: at(body.Hourly.Precipitation, i),
+			CloudCoverPct:   at(body.Hourly.CloudCover, i),
+		}
+		if i < len(body.Hourly.WeatherCode) && body.Hourly.WeatherCode[i] != nil {
+			h.Code = body.Hourly.WeatherCode[i]
+			h.Conditions = weatherConditions(*h.Code)
+		}
+		hours = append(hours, h)
+	}
+	return hours, nil
+}
+
+// weatherConditions describes a WMO weather code in a word or two.
+func weatherConditions(code int) string {
+	switch {
+	case code == 0:
+		return "clear"
+	case code == 1 || code == 2:
+		return "partly cloudy"
+	case code == 3:
+		return "overcast"
+	case code == 45 || code == 48:
+		return "fog"
+	case code >= 51 && code <= 57:
+		return "drizzle"
+	case code >= 61 && code <= 67, code >= 80 && code <= 82:
+		return "rain"
+	case code >= 71 && code <= 77, code == 85 || code == 86:
+		return "snow"
+	case code >= 95 && code <= 99:
+		return "thunderstorm"
+	}
+	return ""
+}
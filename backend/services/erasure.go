@@ -0,0 +1,376 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/intelsk/backend/models"
+)
+
+// What Erase does with a person's face embeddings and with the frames they
+// appear in.
+const (
+	EraseDelete    = "delete"    // embeddings, frames
+	EraseAnonymize = "anonymize" // embeddings: the encoding is wiped, the face's place kept
+	EraseKeep      = "keep"      // frames
+	EraseBlur      = "blur"      // frames: the person's faces are pixelated in place
+)
+
+// erasureBlurBlocks is how many blocks across a face is pixelated into;
+// too few for it to be recognised.
+const erasureBlurBlocks = 6
+
+// erasureBlurQuality is the JPEG quality blurred frames are written with.
+const erasureBlurQuality = 90
+
+// personFace is a stored face matched to a person.
+type personFace struct {
+	id        string
+	cameraID  string
+	framePath string
+	box       FaceBox
+}
+
+// WithJobs makes Erase hold the camera and dates whose frames it changes,
+// refusing those a job is working on.
+func (s *FaceService) WithJobs(jobs *JobManager) *FaceService {
+	s.jobs = jobs
+	return s
+}
+
+// Erase removes an enrolled person's data: the stored faces that match any
+// of their reference encodings (or were labelled with their name) are
+// deleted or anonymized, the frames they appear in are optionally blurred
+// or deleted, and last the person is removed from the registry. Frames are
+// found as SearchPerson finds them, so the same max distance applies. A
+// deleted frame goes with its CLIP and other face embeddings and its
+// manifest entry; resized variants of the frames and cached timelapses of
+// their dates are dropped. Bookmarks and case items of the frames are
+// counted but kept, as they may be evidence, and source videos are not
+// touched. Frames are changed before the faces in them are erased, and
+// the dates they are of held from jobs meanwhile; when a frame can't be
+// changed the error is reported, its faces are kept and the person stays
+// enrolled, so running the erasure again finishes it.
+func (s *FaceService) Erase(name string, req models.ErasureRequest) (*models.ErasureReport, error) {
+	report := &models.ErasureReport{
+		Person:      name,
+		DryRun:      req.DryRun,
+		Embeddings:  req.Embeddings,
+		Frames:      req.Frames,
+		MaxDistance: req.MaxDistance,
+		ErasedAt:    time.Now().UTC(),
+		Cameras:     map[string]int{},
+	}
+	if report.Embeddings == "" {
+		report.Embeddings = EraseDelete
+	}
+	if report.Frames == "" {
+		report.Frames = EraseKeep
+	}
+	if report.MaxDistance <= 0 {
+		report.MaxDistance = DefaultFaceDistance
+	}
+	if report.Embeddings != EraseDelete && report.Embeddings != EraseAnonymize {
+		return nil, newError(ErrInvalidInput, "embeddings must be %q or %q, got %q", EraseDelete, EraseAnonymize, report.Embeddings)
+	}
+	if report.Frames != EraseKeep && report.Frames != EraseBlur && report.Frames != EraseDelete {
+		return nil, newError(ErrInvalidInput, "frames must be %q, %q or %q, got %q", EraseKeep, EraseBlur, EraseDelete, report.Frames)
+	}
+
+	reg, err := s.loadRegistry()
+	if err != nil {
+		return nil, err
+	}
+	person := reg.People[name]
+	if person == nil {
+		return nil, newError(ErrPersonNotFound, "person %q is not enrolled", name)
+	}
+	report.References = len(person.Embeddings)
+
+	faces, err := s.personFaces(name, person, report.MaxDistance)
+	if err != nil {
+		return nil, err
+	}
+	report.FaceEmbeddings = len(faces)
+	frames := make(map[string][]FaceBox) // the person's faces by frame path
+	for _, f := range faces {
+		if _, ok := frames[f.framePath]; !ok {
+			report.Cameras[f.cameraID]++
+		}
+		frames[f.framePath] = append(frames[f.framePath], f.box)
+	}
+	report.FramesFound = len(frames)
+
+	db := s.storage.DB()
+	for path := range frames {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM bookmarks WHERE frame_path = ?", path).Scan(&n); err != nil {
+			return nil, err
+		}
+		report.Bookmarks += n
+		if err := db.QueryRow("SELECT COUNT(*) FROM case_items WHERE frame_path = ?", path).Scan(&n); err != nil {
+			return nil, err
+		}
+		report.CaseItems += n
+	}
+
+	// Frames are changed under their date's directory, as jobs write it
+	dirs := make(map[string][]string) // frame paths by frames directory
+	for path := range frames {
+		dirs[filepath.Dir(path)] = append(dirs[filepath.Dir(path)], path)
+	}
+	if report.Frames != EraseKeep && s.jobs != nil {
+		for dir := range dirs {
+			key := JobKey(filepath.Base(filepath.Dir(dir)), filepath.Base(dir))
+			unlock, err := s.jobs.Reserve(key, "erasure of "+name)
+			if err != nil {
+				return nil, err
+			}
+			defer unlock()
+		}
+	}
+	if req.DryRun {
+		if report.Frames == EraseBlur {
+			report.FramesBlurred = len(frames)
+		} else if report.Frames == EraseDelete {
+			report.FramesDeleted = len(frames)
+		}
+		return report, nil
+	}
+
+	// The frames are changed first and only the faces of those changed are
+	// erased, so a frame that fails is found again when the erasure is rerun
+	changed := make(map[string][]FaceBox, len(frames))
+	switch report.Frames {
+	case EraseKeep:
+		changed = frames
+	case EraseBlur:
+		for path, boxes := range frames {
+			if err := blurFaces(path, boxes); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("blurring %s: %v", path, err))
+				continue
+			}
+			changed[path] = boxes
+			report.FramesBlurred++
+		}
+	case EraseDelete:
+		for dir, paths := range dirs {
+			n, err := deleteFrames(dir, paths)
+			for _, p := range paths[:n] {
+				changed[p] = frames[p]
+			}
+			report.FramesDeleted += n
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("deleting frames in %s: %v", dir, err))
+			}
+		}
+	}
+	if report.Frames != EraseKeep {
+		// Resized variants and cached timelapses show the frames as they were
+		for path := range changed {
+			RemoveFrameSizes(s.cfg, path)
+		}
+		for dir := range dirs {
+			cameraID, date := filepath.Base(filepath.Dir(dir)), filepath.Base(dir)
+			timelapses, _ := filepath.Glob(filepath.Join(s.cfg.App.DataDir, "timelapses", cameraID, date+"_*.mp4"))
+			for _, t := range timelapses {
+				os.Remove(t)
+			}
+		}
+	}
+
+	erased := faces[:0]
+	for _, f := range faces {
+		if _, ok := changed[f.framePath]; ok {
+			erased = append(erased, f)
+		}
+	}
+	report.FaceEmbeddings = len(erased)
+	if err := s.eraseEmbeddings(erased, changed, report); err != nil {
+		return nil, err
+	}
+	if len(report.Errors) > 0 {
+		sort.Strings(report.Errors)
+		report.References = 0
+		report.Errors = append(report.Errors, fmt.Sprintf("%q is still enrolled so the erasure can be run again", name))
+		return report, nil
+	}
+
+	delete(reg.People, name)
+	if err := s.saveRegistry(reg); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// personFaces returns the stored faces labelled with name or closer than
+// maxDistance to one of person's reference encodings.
+func (s *FaceService) personFaces(name string, person *FacePerson, maxDistance float64) ([]personFace, error) {
+	rows, err := s.storage.DB().Query(`SELECT id, embedding, camera_id, frame_path,
+		bbox_top, bbox_right, bbox_bottom, bbox_left, COALESCE(person_name, '') FROM face_embeddings`)
+	if err != nil {
+		return nil, fmt.Errorf("querying faces: %w", err)
+	}
+	defer rows.Close()
+
+	var faces []personFace
+	for rows.Next() {
+		var f personFace
+		var blob []byte
+		var label string
+		if err := rows.Scan(&f.id, &blob, &f.cameraID, &f.framePath,
+			&f.box.Top, &f.box.Right, &f.box.Bottom, &f.box.Left, &label); err != nil {
+			return nil, err
+		}
+		match := label == name
+		enc := BytesToFloat64s(blob)
+		for _, ref := range person.Embeddings {
+			if match {
+				break
+			}
+			match = faceDistance(enc, ref.Encoding) < maxDistance
+		}
+		if match {
+			faces = append(faces, f)
+		}
+	}
+	return faces, rows.Err()
+}
+
+// eraseEmbeddings deletes or anonymizes the person's faces, and with frames
+// deleted every embedding of those frames, in one transaction.
+func (s *FaceService) eraseEmbeddings(faces []personFace, frames map[string][]FaceBox, report *models.ErasureReport) error {
+	tx, err := s.storage.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, f := range faces {
+		q := "DELETE FROM face_embeddings WHERE id = ?"
+		if report.Embeddings == EraseAnonymize {
+			// An empty encoding matches no one
+			q = "UPDATE face_embeddings SET embedding = X'', person_name = NULL WHERE id = ?"
+		}
+		if _, err := tx.Exec(q, f.id); err != nil {
+			return fmt.Errorf("erasing face %s: %w", f.id, err)
+		}
+	}
+	if report.Frames == EraseDelete {
+		for path := range frames {
+			if _, err := tx.Exec("DELETE FROM face_embeddings WHERE frame_path = ?", path); err != nil {
+				return fmt.Errorf("erasing faces of %s: %w", path, err)
+			}
+			res, err := tx.Exec("DELETE FROM clip_embeddings WHERE frame_path = ?", path)
+			if err != nil {
+				return fmt.Errorf("erasing %s from the index: %w", path, err)
+			}
+			n, _ := res.RowsAffected()
+			report.ClipEmbeddings += n
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteFrames removes paths, frames of the frames directory dir, from its
+// manifest and then from disk. It returns how many of paths, in order, were
+// removed.
+func deleteFrames(dir string, paths []string) (int, error) {
+	gone := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		gone[p] = true
+	}
+	frames, err := LoadManifest(dir)
+	if err != nil {
+		return 0, err
+	}
+	kept := frames[:0]
+	for _, f := range frames {
+		if !gone[f.FramePath] {
+			kept = append(kept, f)
+		}
+	}
+	if len(kept) < len(frames) {
+		if err := WriteManifest(dir, kept); err != nil {
+			return 0, err
+		}
+	}
+	removed := 0
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// blurFaces pixelates each box, padded by a fifth of its size, of the JPEG
+// frame at path and writes it back in place.
+func blurFaces(path string, boxes []FaceBox) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	src, err := jpeg.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	for _, b := range boxes {
+		padX, padY := (b.Right-b.Left)/5, (b.Bottom-b.Top)/5
+		r := image.Rect(b.Left-padX, b.Top-padY, b.Right+padX, b.Bottom+padY).Intersect(img.Bounds())
+		if r.Empty() {
+			continue
+		}
+		block := max(int(math.Ceil(float64(max(r.Dx(), r.Dy()))/erasureBlurBlocks)), 1)
+		for y := r.Min.Y; y < r.Max.Y; y += block {
+			for x := r.Min.X; x < r.Max.X; x += block {
+				cell := image.Rect(x, y, x+block, y+block).Intersect(r)
+				draw.Draw(img, cell, &image.Uniform{averageColor(img, cell)}, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(out, img, &jpeg.Options{Quality: erasureBlurQuality}); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// averageColor is the mean colour of r in img.
+func averageColor(img *image.RGBA, r image.Rectangle) color.RGBA {
+	var sr, sg, sb, n uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			sr, sg, sb = sr+uint64(c.R), sg+uint64(c.G), sb+uint64(c.B)
+			n++
+		}
+	}
+	if n == 0 {
+		return color.RGBA{A: 255}
+	}
+	return color.RGBA{R: uint8(sr / n), G: uint8(sg / n), B: uint8(sb / n), A: 255}
+}
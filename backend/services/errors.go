@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Sentinel errors classifying service failures. Handlers use errors.Is
+// against these to pick an HTTP status and machine-readable error code.
+var (
+	ErrInvalidInput       = errors.New("invalid input")
+	ErrCameraNotFound     = errors.New("camera not found")
+	ErrVideoNotFound      = errors.New("video not found")
+	ErrFrameNotFound      = errors.New("frame not found")
+	ErrAlreadyExists      = errors.New("already exists")
+	ErrWorkspaceNotFound  = errors.New("workspace not found")
+	ErrUploadNotFound     = errors.New("upload not found")
+	ErrUploadOffset       = errors.New("upload offset mismatch")
+	ErrJobNotFound        = errors.New("job not found")
+	ErrJobFinished        = errors.New("job already finished")
+	ErrScheduleNotFound   = errors.New("schedule not found")
+	ErrAlertNotFound      = errors.New("alert not found")
+	ErrBookmarkNotFound   = errors.New("bookmark not found")
+	ErrCaseNotFound       = errors.New("case not found")
+	ErrCaseItemNotFound   = errors.New("case item not found")
+	ErrPersonNotFound     = errors.New("person not found")
+	ErrTaskNotFound       = errors.New("worker task not found")
+	ErrBusy               = errors.New("resource busy")
+	ErrStreamLimit        = errors.New("too many live streams")
+	ErrSidecarUnavailable = errors.New("ML sidecar unavailable")
+	ErrSidecarTimeout     = errors.New("ML sidecar timeout")
+	ErrSidecarFailed      = errors.New("ML sidecar request failed")
+	ErrNVRUnreachable     = errors.New("NVR unreachable")
+	ErrNVRTimeout         = errors.New("NVR timeout")
+	ErrNVRFailed          = errors.New("NVR request failed")
+	ErrNVRAuth            = errors.New("NVR authentication failed")
+)
+
+// kindError attaches a sentinel kind to an error while keeping the original
+// human-readable message intact.
+type kindError struct {
+	kind error
+	msg  string
+	err  error
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+func (e *kindError) Unwrap() []error {
+	if e.err != nil {
+		return []error{e.kind, e.err}
+	}
+	return []error{e.kind}
+}
+
+// newError builds an error of the given kind with a formatted message.
+// A %w verb in format is honoured, so the cause stays inspectable.
+func newError(kind error, format string, args ...any) error {
+	wrapped := fmt.Errorf(format, args...)
+	return &kindError{kind: kind, msg: wrapped.Error(), err: errors.Unwrap(wrapped)}
+}
+
+// classifyNetError tags a transport-level error (as returned by http.Client)
+// as a timeout or an unreachable-peer failure of the given kinds.
+func classifyNetError(err error, unreachable, timeout error, format string, args ...any) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return newError(timeout, format, append(args, err)...)
+	}
+	return newError(unreachable, format, append(args, err)...)
+}
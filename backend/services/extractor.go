@@ -1,10 +1,12 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"image"
 	_ "image/jpeg"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,33 +20,102 @@ import (
 )
 
 // ExtractFramesTime runs ffmpeg to extract frames at a fixed interval from a video file.
-// It parses the video path to derive camera_id, date, and segment hour.
+// It parses the video path to derive camera_id and date, and takes the
+// video's start from its name or else its metadata (see videoStart).
 // Returns a slice of FrameMetadata for each extracted frame.
 func ExtractFramesTime(videoPath, outputDir string, intervalSec, quality int) ([]models.FrameMetadata, error) {
+	return ExtractFramesTimeContext(context.Background(), videoPath, outputDir, intervalSec, quality, VideoClock{})
+}
+
+// ExtractFramesTimeContext is ExtractFramesTime that kills ffmpeg once ctx is
+// done. The frames it wrote for the video so far are removed again.
+// clock.OffsetSec, the camera's clock_offset_sec, is added to every frame
+// timestamp; it and the video's start are recorded next to the video for
+// SeekOffset.
+func ExtractFramesTimeContext(ctx context.Context, videoPath, outputDir string, intervalSec, quality int, clock VideoClock) ([]models.FrameMetadata, error) {
+	return ExtractFramesTimeWindow(ctx, videoPath, outputDir, intervalSec, quality, clock, time.Time{}, time.Time{})
+}
+
+// ExtractFramesTimeWindow is ExtractFramesTimeContext for the part of the
+// video whose frames fall from "from" up to "to"; a zero bound leaves that
+// end open. ffmpeg seeks to the window, and the frames are numbered and
+// timed as extracting the whole video would, so a later full extraction
+// replaces them in place. Frames of the video outside the window are left
+// alone.
+func ExtractFramesTimeWindow(ctx context.Context, videoPath, outputDir string, intervalSec, quality int, clock VideoClock, from, to time.Time) ([]models.FrameMetadata, error) {
 	if err := os.MkdirAll(outputDir, 0o755); err != nil {
 		return nil, fmt.Errorf("creating output dir: %w", err)
 	}
 
+	loc := clock.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	cameraID, date, err := parseVideoPath(videoPath, loc)
+	if err != nil {
+		return nil, err
+	}
+	start := videoStart(videoPath, date, clock)
+	segmentStart := start.Add(time.Duration(clock.OffsetSec) * time.Second)
+
+	// Seek to the first frame in the window, on the interval grid
+	interval := time.Duration(intervalSec) * time.Second
+	var skip time.Duration
+	if !from.IsZero() && from.After(segmentStart) {
+		skip = from.Sub(segmentStart).Truncate(interval)
+	}
+	firstFrame := int(skip/interval) + 1
+	inWindow := func(frameNum int) bool {
+		return frameNum >= firstFrame && (to.IsZero() || segmentStart.Add(time.Duration(frameNum-1)*interval).Before(to))
+	}
+
 	// Use segment-specific prefix (e.g. frame_0800_000001.jpg) to avoid
 	// collisions when multiple videos share the same output directory.
 	segName := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(filepath.Base(videoPath)))
 	outputPattern := filepath.Join(outputDir, fmt.Sprintf("frame_%s_%%06d.jpg", segName))
 
-	cmd := exec.Command("ffmpeg",
-		"-i", videoPath,
+	var args []string
+	if skip > 0 {
+		args = append(args, "-ss", strconv.Itoa(int(skip.Seconds())))
+	}
+	args = append(args, "-i", videoPath)
+	if !to.IsZero() {
+		length := to.Sub(segmentStart.Add(skip))
+		if length <= 0 {
+			return nil, nil
+		}
+		args = append(args, "-t", strconv.FormatFloat(length.Seconds(), 'f', -1, 64))
+	}
+	args = append(args,
 		"-vf", fmt.Sprintf("fps=1/%d", intervalSec),
 		"-q:v", strconv.Itoa(quality),
+		"-start_number", strconv.Itoa(firstFrame),
 		"-y",
 		outputPattern,
 	)
-	cmd.Stderr = os.Stderr
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stderr := &tailWriter{max: 4096}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			partial, _ := filepath.Glob(filepath.Join(outputDir, fmt.Sprintf("frame_%s_*.jpg", segName)))
+			for _, f := range partial {
+				n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "frame_"+segName+"_"), ".jpg"))
+				if err == nil && inWindow(n) {
+					os.Remove(f)
+				}
+			}
+			return nil, ctx.Err()
+		}
+		// ffmpeg ends its output with what went wrong
+		if line := stderr.lastLine(); line != "" {
+			return nil, fmt.Errorf("ffmpeg: %w: %s", err, line)
+		}
 		return nil, fmt.Errorf("ffmpeg: %w", err)
 	}
 
-	cameraID, segmentStart, err := parseVideoPath(videoPath)
-	if err != nil {
-		return nil, err
+	if err := recordVideoTime(videoPath, start, clock.OffsetSec); err != nil {
+		return nil, fmt.Errorf("recording video start: %w", err)
 	}
 
 	entries, err := os.ReadDir(outputDir)
@@ -65,7 +136,7 @@ func ExtractFramesTime(videoPath, outputDir string, intervalSec, quality int) ([
 		numStr := strings.TrimPrefix(e.Name(), prefix)
 		numStr = strings.TrimSuffix(numStr, ".jpg")
 		frameNum, err := strconv.Atoi(numStr)
-		if err != nil {
+		if err != nil || !inWindow(frameNum) {
 			continue
 		}
 
@@ -89,43 +160,77 @@ func ExtractFramesTime(videoPath, outputDir string, intervalSec, quality int) ([
 	return frames, nil
 }
 
+// tailWriter keeps the last max bytes written to it.
+type tailWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.max {
+		w.buf = w.buf[len(w.buf)-w.max:]
+	}
+	return len(p), nil
+}
+
+// lastLine returns the last non-blank line written, counting the carriage
+// returns ffmpeg redraws its progress with as line breaks.
+func (w *tailWriter) lastLine() string {
+	lines := strings.FieldsFunc(string(w.buf), func(r rune) bool { return r == '\n' || r == '\r' })
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
 // DeduplicateFrames removes near-duplicate frames using perceptual hashing (pHash).
 // Frames whose pHash distance is below the threshold are considered duplicates;
 // their JPEG files are deleted and they are removed from the returned slice.
 func DeduplicateFrames(frames []models.FrameMetadata, threshold int) ([]models.FrameMetadata, error) {
-	if len(frames) == 0 {
-		return frames, nil
+	kept, dups, err := findDuplicateFrames(frames, threshold)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range dups {
+		os.Remove(f.FramePath)
 	}
+	return kept, nil
+}
 
-	type hashedFrame struct {
-		meta models.FrameMetadata
-		hash *goimagehash.ImageHash
+// findDuplicateFrames splits frames into the ones to keep and the
+// near-duplicates of an earlier kept frame, without touching any files.
+func findDuplicateFrames(frames []models.FrameMetadata, threshold int) (kept, dups []models.FrameMetadata, err error) {
+	if len(frames) == 0 {
+		return frames, nil, nil
 	}
 
-	var kept []hashedFrame
+	var keptHashes []*goimagehash.ImageHash
 
 	for _, f := range frames {
 		file, err := os.Open(f.FramePath)
 		if err != nil {
-			return nil, fmt.Errorf("opening %s: %w", f.FramePath, err)
+			return nil, nil, fmt.Errorf("opening %s: %w", f.FramePath, err)
 		}
 
 		img, _, err := image.Decode(file)
 		file.Close()
 		if err != nil {
-			return nil, fmt.Errorf("decoding %s: %w", f.FramePath, err)
+			return nil, nil, fmt.Errorf("decoding %s: %w", f.FramePath, err)
 		}
 
 		hash, err := goimagehash.PerceptionHash(img)
 		if err != nil {
-			return nil, fmt.Errorf("hashing %s: %w", f.FramePath, err)
+			return nil, nil, fmt.Errorf("hashing %s: %w", f.FramePath, err)
 		}
 
 		isDup := false
-		for _, k := range kept {
-			dist, err := hash.Distance(k.hash)
+		for _, k := range keptHashes {
+			dist, err := hash.Distance(k)
 			if err != nil {
-				return nil, fmt.Errorf("comparing hashes: %w", err)
+				return nil, nil, fmt.Errorf("comparing hashes: %w", err)
 			}
 			if dist < threshold {
 				isDup = true
@@ -134,17 +239,13 @@ func DeduplicateFrames(frames []models.FrameMetadata, threshold int) ([]models.F
 		}
 
 		if isDup {
-			os.Remove(f.FramePath)
+			dups = append(dups, f)
 		} else {
-			kept = append(kept, hashedFrame{meta: f, hash: hash})
+			kept = append(kept, f)
+			keptHashes = append(keptHashes, hash)
 		}
 	}
-
-	result := make([]models.FrameMetadata, len(kept))
-	for i, k := range kept {
-		result[i] = k.meta
-	}
-	return result, nil
+	return kept, dups, nil
 }
 
 // WriteManifest writes the frame metadata slice as a JSON manifest file.
@@ -157,6 +258,23 @@ func WriteManifest(outputDir string, frames []models.FrameMetadata) error {
 	return os.WriteFile(path, data, 0o644)
 }
 
+// MergeManifest adds newly extracted frames to a manifest's existing ones.
+// Frames of a video extracted again, e.g. by a resumed or retried job,
+// replace those from its earlier extraction instead of being listed twice.
+func MergeManifest(existing, added []models.FrameMetadata) []models.FrameMetadata {
+	videos := make(map[string]bool)
+	for _, f := range added {
+		videos[f.SourceVideo] = true
+	}
+	merged := make([]models.FrameMetadata, 0, len(existing)+len(added))
+	for _, f := range existing {
+		if !videos[f.SourceVideo] {
+			merged = append(merged, f)
+		}
+	}
+	return append(merged, added...)
+}
+
 // LoadManifest reads frame metadata from an existing manifest.json.
 // Returns nil, nil if no manifest exists yet.
 func LoadManifest(dir string) ([]models.FrameMetadata, error) {
@@ -172,11 +290,10 @@ func LoadManifest(dir string) ([]models.FrameMetadata, error) {
 	return frames, json.Unmarshal(data, &frames)
 }
 
-// parseVideoPath extracts camera_id and segment start time from a video path.
+// parseVideoPath extracts camera_id and date, as midnight in loc, from a
+// video path.
 // Path structure: .../data/videos/{camera_id}/{date}/{filename}.mp4
-// If the filename starts with a 2-digit hour (e.g. "0800.mp4"), the segment
-// start time includes that hour. Otherwise, it defaults to midnight.
-func parseVideoPath(videoPath string) (cameraID string, segmentStart time.Time, err error) {
+func parseVideoPath(videoPath string, loc *time.Location) (cameraID string, date time.Time, err error) {
 	abs, err := filepath.Abs(videoPath)
 	if err != nil {
 		return "", time.Time{}, fmt.Errorf("resolving path: %w", err)
@@ -187,25 +304,12 @@ func parseVideoPath(videoPath string) (cameraID string, segmentStart time.Time,
 		return "", time.Time{}, fmt.Errorf("video path too short to parse camera/date: %s", videoPath)
 	}
 
-	filename := parts[len(parts)-1]   // e.g. "0800.mp4" or "my_video.mp4"
 	dateStr := parts[len(parts)-2]    // e.g. "2026-02-18"
 	cameraID = parts[len(parts)-3]    // e.g. "front_door"
 
-	date, err := time.Parse("2006-01-02", dateStr)
+	date, err = time.ParseInLocation("2006-01-02", dateStr, loc)
 	if err != nil {
 		return "", time.Time{}, fmt.Errorf("parsing date %s: %w", dateStr, err)
 	}
-
-	// Try to parse hour from filename (e.g. "0800.mp4" → hour 8).
-	// If the filename doesn't start with a valid hour, default to midnight.
-	hour := 0
-	stem := strings.TrimSuffix(filename, filepath.Ext(filename))
-	if len(stem) >= 2 {
-		if h, err := strconv.Atoi(stem[:2]); err == nil && h >= 0 && h <= 23 {
-			hour = h
-		}
-	}
-
-	segmentStart = date.Add(time.Duration(hour) * time.Hour)
-	return cameraID, segmentStart, nil
+	return cameraID, date, nil
 }
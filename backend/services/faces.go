@@ -0,0 +1,359 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+)
+
+// DefaultFaceDistance is the L2 distance below which two face encodings are
+// taken to be the same person, matching face_recognition's default.
+const DefaultFaceDistance = 0.6
+
+// FaceRegistry is the enrolled persons file (data/face_registry.json), in
+// the format the desktop experiment already uses.
+type FaceRegistry struct {
+	People map[string]*FacePerson `json:"people"`
+}
+
+type FacePerson struct {
+	Embeddings []FaceReference `json:"embeddings"`
+}
+
+// FaceReference is one reference encoding and the image it came from.
+type FaceReference struct {
+	Source   string    `json:"source"`
+	Encoding []float64 `json:"encoding"`
+}
+
+// PersonInfo summarises an enrolled person.
+type PersonInfo struct {
+	Name      string   `json:"name"`
+	Encodings int      `json:"encodings"`
+	Sources   []string `json:"sources"`
+}
+
+// EnrollResult reports what happened to one photo passed to Enroll.
+type EnrollResult struct {
+	Photo string `json:"photo"`
+	Faces int    `json:"faces"`
+	Added bool   `json:"added"`
+	Error string `json:"error,omitempty"`
+}
+
+// FaceService runs face detection over extracted frames and matches the
+// stored faces against the enrolled persons. Detection is done by the ML
+// sidecar; matching is a brute-force L2 scan over face_embeddings.
+type FaceService struct {
+	cfg      *config.AppConfig
+	storage  *Storage
+	mlClient *MLClient
+	jobs     *JobManager
+}
+
+func NewFaceService(cfg *config.AppConfig, storage *Storage, mlClient *MLClient) *FaceService {
+	return &FaceService{cfg: cfg, storage: storage, mlClient: mlClient}
+}
+
+// RegistryPath returns the location of the face registry for this config's
+// workspace.
+func (s *FaceService) RegistryPath() string {
+	return filepath.Join(s.cfg.App.DataDir, "face_registry.json")
+}
+
+func (s *FaceService) loadRegistry() (*FaceRegistry, error) {
+	reg := &FaceRegistry{People: make(map[string]*FacePerson)}
+	data, err := os.ReadFile(s.RegistryPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return reg, nil
+		}
+		return nil, fmt.Errorf("reading face registry: %w", err)
+	}
+	if err := json.Unmarshal(data, reg); err != nil {
+		return nil, fmt.Errorf("parsing face registry: %w", err)
+	}
+	if reg.People == nil {
+		reg.People = make(map[string]*FacePerson)
+	}
+	return reg, nil
+}
+
+func (s *FaceService) saveRegistry(reg *FaceRegistry) error {
+	data, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling face registry: %w", err)
+	}
+	path := s.RegistryPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("writing face registry: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Enroll adds a reference encoding to name for every photo that contains
+// exactly one face. Photos with no face or several faces are reported and
+// skipped, as the desktop app does.
+func (s *FaceService) Enroll(name string, photos []string) ([]EnrollResult, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, newError(ErrInvalidInput, "person name is required")
+	}
+	reg, err := s.loadRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []EnrollResult
+	added := 0
+	for _, photo := range photos {
+		res := EnrollResult{Photo: photo}
+		abs, err := filepath.Abs(photo)
+		if err == nil {
+			_, err = os.Stat(abs)
+		}
+		if err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+		faces, err := s.mlClient.DetectFaces(abs)
+		if err != nil {
+			return results, err
+		}
+		res.Faces = len(faces)
+		switch len(faces) {
+		case 0:
+			res.Error = "no face detected"
+		case 1:
+			if reg.People[name] == nil {
+				reg.People[name] = &FacePerson{}
+			}
+			reg.People[name].Embeddings = append(reg.People[name].Embeddings,
+				FaceReference{Source: abs, Encoding: faces[0].Encoding})
+			res.Added = true
+			added++
+		default:
+			res.Error = fmt.Sprintf("%d faces detected, need a photo with exactly one", len(faces))
+		}
+		results = append(results, res)
+	}
+
+	if added > 0 {
+		if err := s.saveRegistry(reg); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// Persons lists the enrolled persons sorted by name.
+func (s *FaceService) Persons() ([]PersonInfo, error) {
+	reg, err := s.loadRegistry()
+	if err != nil {
+		return nil, err
+	}
+	persons := make([]PersonInfo, 0, len(reg.People))
+	for name, p := range reg.People {
+		info := PersonInfo{Name: name, Encodings: len(p.Embeddings), Sources: []string{}}
+		for _, e := range p.Embeddings {
+			info.Sources = append(info.Sources, e.Source)
+		}
+		persons = append(persons, info)
+	}
+	sort.Slice(persons, func(i, j int) bool { return persons[i].Name < persons[j].Name })
+	return persons, nil
+}
+
+// IndexFaces detects faces in every frame of a frames directory and stores
+// their encodings in face_embeddings. Frames already scanned are recorded
+// in face_state.json next to the manifest, so re-runs only look at new ones.
+func (s *FaceService) IndexFaces(framesDir string, progress chan<- ProgressEvent) error {
+	frames, err := LoadManifest(framesDir)
+	if err != nil {
+		return err
+	}
+
+	statePath := filepath.Join(framesDir, "face_state.json")
+	state := loadIndexState(statePath)
+
+	var pending []models.FrameMetadata
+	for _, f := range frames {
+		if !state.IndexedFrames[frameID(f)] {
+			pending = append(pending, f)
+		}
+	}
+	if len(pending) == 0 {
+		if progress != nil {
+			progress <- ProgressEvent{
+				Stage:   "complete",
+				Message: fmt.Sprintf("all %d frames already scanned for faces", len(frames)),
+			}
+		}
+		return nil
+	}
+
+	cameraID := pending[0].CameraID
+	total := len(pending)
+	found := 0
+	if progress != nil {
+		progress <- ProgressEvent{
+			Stage:       "faces",
+			CameraID:    cameraID,
+			FramesTotal: total,
+			Message:     fmt.Sprintf("scanning %d frames (%d already done)", total, len(frames)-total),
+		}
+	}
+
+	for i, f := range pending {
+		path := f.FramePath
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(framesDir, filepath.Base(path))
+		}
+		faces, err := s.mlClient.DetectFaces(path)
+		if err != nil {
+			return fmt.Errorf("detecting faces in %s: %w", path, err)
+		}
+		id := frameID(f)
+		ts := FormatTimestamp(f.Timestamp)
+		for j, face := range faces {
+			if err := s.storage.AddFaceEmbedding(fmt.Sprintf("%s_face%d", id, j),
+				Float64sToBytes(face.Encoding), f.CameraID, ts, f.FramePath, face.BBox); err != nil {
+				return fmt.Errorf("storing face for %s: %w", id, err)
+			}
+		}
+		found += len(faces)
+		state.IndexedFrames[id] = true
+
+		// Persist every batch so an interrupted run resumes where it stopped
+		if (i+1)%32 == 0 || i+1 == total {
+			state.LastUpdated = time.Now()
+			if err := saveIndexState(statePath, state); err != nil {
+				return fmt.Errorf("saving face state: %w", err)
+			}
+			if progress != nil {
+				progress <- ProgressEvent{
+					Stage:       "faces",
+					CameraID:    cameraID,
+					FramesDone:  i + 1,
+					FramesTotal: total,
+					Message:     fmt.Sprintf("%d faces found", found),
+				}
+			}
+		}
+	}
+
+	if progress != nil {
+		progress <- ProgressEvent{
+			Stage:       "complete",
+			CameraID:    cameraID,
+			FramesDone:  total,
+			FramesTotal: total,
+			Message:     fmt.Sprintf("face scan complete, %d faces found", found),
+		}
+	}
+	return nil
+}
+
+// FaceSearchOptions narrows SearchPerson. StartTime and EndTime are
+// inclusive bounds in the stored form, as TimeRange returns them, compared
+// as strings like the CLIP search filters.
+type FaceSearchOptions struct {
+	CameraIDs   []string
+	StartTime   string
+	EndTime     string
+	MaxDistance float64
+	Limit       int
+}
+
+// SearchPerson returns the stored faces closest to any reference encoding
+// of an enrolled person, best match first. Score is 1 - L2 distance.
+func (s *FaceService) SearchPerson(name string, opts FaceSearchOptions) ([]models.SearchResult, error) {
+	reg, err := s.loadRegistry()
+	if err != nil {
+		return nil, err
+	}
+	person := reg.People[name]
+	if person == nil || len(person.Embeddings) == 0 {
+		return nil, newError(ErrInvalidInput, "person %q is not enrolled", name)
+	}
+	if opts.MaxDistance <= 0 {
+		opts.MaxDistance = DefaultFaceDistance
+	}
+
+	query := "SELECT id, embedding, camera_id, timestamp, frame_path FROM face_embeddings WHERE 1=1"
+	var args []any
+	if len(opts.CameraIDs) > 0 {
+		query += " AND camera_id IN (?" + strings.Repeat(", ?", len(opts.CameraIDs)-1) + ")"
+		for _, id := range opts.CameraIDs {
+			args = append(args, id)
+		}
+	}
+	if opts.StartTime != "" {
+		query += " AND timestamp >= ?"
+		args = append(args, opts.StartTime)
+	}
+	if opts.EndTime != "" {
+		query += " AND timestamp <= ?"
+		args = append(args, opts.EndTime)
+	}
+	rows, err := s.storage.DB().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying faces: %w", err)
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		var r models.SearchResult
+		var blob []byte
+		if err := rows.Scan(&r.ID, &blob, &r.CameraID, &r.Timestamp, &r.FramePath); err != nil {
+			return nil, err
+		}
+		enc := BytesToFloat64s(blob)
+		best := math.Inf(1)
+		for _, ref := range person.Embeddings {
+			if d := faceDistance(enc, ref.Encoding); d < best {
+				best = d
+			}
+		}
+		if best < opts.MaxDistance {
+			r.Score = 1 - best
+			results = append(results, r)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+	return results, nil
+}
+
+// faceDistance is the Euclidean distance face_recognition.face_distance uses.
+func faceDistance(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return math.Inf(1)
+	}
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
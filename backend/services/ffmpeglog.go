@@ -0,0 +1,200 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ffmpegLogLines is how many stderr lines a stream keeps, across
+	// restarts, for its diagnostics.
+	ffmpegLogLines = 200
+	// maxStderrLine bounds a line still waiting for its newline.
+	maxStderrLine = 4096
+)
+
+var (
+	// ffmpeg's periodic "frame=  250 fps= 25 ... speed=1.01x" report
+	ffmpegProgressRe = regexp.MustCompile(`(\w+)=\s*(\S+)`)
+	// user:password@ in RTSP URLs, which ffmpeg echoes when it opens the input
+	urlCredentialsRe = regexp.MustCompile(`(\w+://)[^/\s@]+@`)
+)
+
+// StreamLogLine is one line of a stream's ffmpeg output.
+type StreamLogLine struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// StreamDiagnostics extends StreamStatus with what's needed to tell why a
+// stream doesn't play: ffmpeg's recent output and its throughput.
+type StreamDiagnostics struct {
+	StreamStatus
+	UptimeSec   float64         `json:"uptime_sec"`   // of the running ffmpeg process
+	Frames      int64           `json:"frames"`       // encoded by the running process
+	FPS         float64         `json:"fps"`          // encoding rate
+	Speed       float64         `json:"speed"`        // encoding speed relative to real time
+	BitrateKbps float64         `json:"bitrate_kbps"` // of the segments in the playlist
+	Log         []StreamLogLine `json:"log"`          // oldest first; credentials redacted
+}
+
+type ffmpegProgress struct {
+	frames int64
+	fps    float64
+	speed  float64
+}
+
+// ffmpegLog keeps the last ffmpegLogLines lines of a stream's ffmpeg output
+// in a ring buffer, and its latest progress report.
+type ffmpegLog struct {
+	mu       sync.Mutex
+	lines    []StreamLogLine
+	next     int // slot the next line overwrites once the ring is full
+	progress ffmpegProgress
+}
+
+func (l *ffmpegLog) add(text string) {
+	line := StreamLogLine{Time: time.Now(), Text: redactURLCredentials(text)}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.lines) < ffmpegLogLines {
+		l.lines = append(l.lines, line)
+		return
+	}
+	l.lines[l.next] = line
+	l.next = (l.next + 1) % ffmpegLogLines
+}
+
+func (l *ffmpegLog) setProgress(p ffmpegProgress) {
+	l.mu.Lock()
+	l.progress = p
+	l.mu.Unlock()
+}
+
+// snapshot returns the buffered lines, oldest first, and the last progress
+// report.
+func (l *ffmpegLog) snapshot() ([]StreamLogLine, ffmpegProgress) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := make([]StreamLogLine, 0, len(l.lines))
+	lines = append(lines, l.lines[l.next:]...)
+	lines = append(lines, l.lines[:l.next]...)
+	return lines, l.progress
+}
+
+// stderrWriter passes one ffmpeg process's stderr through to w, records its
+// lines in the stream's log and remembers the last one, which usually says
+// why ffmpeg exited. Progress reports, which ffmpeg ends with \r, update the
+// log's progress instead.
+type stderrWriter struct {
+	w   io.Writer
+	log *ffmpegLog
+
+	mu      sync.Mutex
+	partial []byte
+	line    string
+}
+
+func (s *stderrWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	buf := append(s.partial, p...)
+	for {
+		i := bytes.IndexAny(buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		s.handle(string(buf[:i]))
+		buf = buf[i+1:]
+	}
+	if len(buf) > maxStderrLine {
+		s.handle(string(buf))
+		buf = nil
+	}
+	s.partial = append([]byte(nil), buf...)
+	s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func (s *stderrWriter) handle(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	if p, ok := parseFFmpegProgress(line); ok {
+		s.log.setProgress(p)
+		return
+	}
+	s.line = redactURLCredentials(line)
+	s.log.add(line)
+}
+
+func (s *stderrWriter) last() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.line
+}
+
+// parseFFmpegProgress reads a progress report such as
+// "frame=  250 fps= 25 q=29.0 size=N/A time=00:00:10.00 bitrate=N/A speed=1.01x".
+func parseFFmpegProgress(line string) (ffmpegProgress, bool) {
+	if !strings.HasPrefix(line, "frame=") {
+		return ffmpegProgress{}, false
+	}
+	var p ffmpegProgress
+	for _, m := range ffmpegProgressRe.FindAllStringSubmatch(line, -1) {
+		switch m[1] {
+		case "frame":
+			p.frames, _ = strconv.ParseInt(m[2], 10, 64)
+		case "fps":
+			p.fps, _ = strconv.ParseFloat(m[2], 64)
+		case "speed":
+			p.speed, _ = strconv.ParseFloat(strings.TrimSuffix(m[2], "x"), 64)
+		}
+	}
+	return p, true
+}
+
+func redactURLCredentials(s string) string {
+	return urlCredentialsRe.ReplaceAllString(s, "${1}***@")
+}
+
+// playlistBitrate measures the bitrate of the segments an HLS playlist
+// lists, in kbit/s. ffmpeg's own report is N/A for HLS output.
+func playlistBitrate(dir string) float64 {
+	f, err := os.Open(filepath.Join(dir, "index.m3u8"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var size int64
+	var seconds, duration float64
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(v, 64)
+		case line != "" && !strings.HasPrefix(line, "#"):
+			info, err := os.Stat(filepath.Join(dir, filepath.Base(line)))
+			if err == nil && duration > 0 {
+				size += info.Size()
+				seconds += duration
+			}
+			duration = 0
+		}
+	}
+	if seconds == 0 {
+		return 0
+	}
+	return float64(size) * 8 / 1000 / seconds
+}
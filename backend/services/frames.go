@@ -0,0 +1,59 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/intelsk/backend/models"
+)
+
+// Frames lists the frames extracted for a camera on date (YYYY-MM-DD) in
+// chronological order, each marked with whether it has a CLIP embedding.
+// A date that hasn't been processed has no frames.
+func (s *CameraService) Frames(id, date string) ([]models.FrameInfo, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	from, to, err := DayRange(date, s.now().Location())
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := LoadManifest(filepath.Join(s.cfg.Extraction.StoragePath, id, date))
+	if err != nil {
+		return []models.FrameInfo{}, nil
+	}
+
+	indexed := make(map[string]bool)
+	rows, err := s.db.Query("SELECT id FROM clip_embeddings WHERE camera_id = ? AND timestamp >= ? AND timestamp <= ?",
+		id, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("querying embeddings: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var frameID string
+		if err := rows.Scan(&frameID); err != nil {
+			return nil, fmt.Errorf("scanning embedding row: %w", err)
+		}
+		indexed[frameID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(manifest, func(i, j int) bool { return manifest[i].Timestamp.Before(manifest[j].Timestamp) })
+	frames := make([]models.FrameInfo, len(manifest))
+	for i, f := range manifest {
+		fid := frameID(f)
+		frames[i] = models.FrameInfo{
+			FrameID:     fid,
+			Timestamp:   FormatTimestamp(f.Timestamp),
+			FramePath:   f.FramePath,
+			SourceVideo: f.SourceVideo,
+			Indexed:     indexed[fid],
+		}
+	}
+	return frames, nil
+}
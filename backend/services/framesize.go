@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/intelsk/backend/config"
+	"github.com/nfnt/resize"
+)
+
+// Resized frames: grids, strips and alert thumbnails show frames far
+// smaller than they were extracted, so /api/frames/* takes a box to fit the
+// frame in. A variant is made on first request and cached as
+// data/frame_sizes/{camera}/{date}/{stem}_{w}x{h}.jpg until the frame
+// changes (blurring it rewrites it, for one).
+const (
+	// MaxFrameSize bounds the requested width and height.
+	MaxFrameSize = 3840
+	// frameSizeStep is what requested sizes round up to a multiple of, so
+	// a client can't fill the cache with a variant per pixel of width.
+	frameSizeStep    = 32
+	frameSizeQuality = 85
+	// maxFrameSizeJobs bounds concurrent resizing: a page of search
+	// results asks for all its frames at once.
+	maxFrameSizeJobs = 4
+	// frameSizeStripes is how many locks variant paths are spread over.
+	frameSizeStripes = 64
+)
+
+// frameSizeLocks serializes resizing per variant, so concurrent requests
+// for the same one decode the frame once. Variants share a lock by the hash
+// of their path, which keeps the set of locks fixed however many variants
+// get made.
+var frameSizeLocks [frameSizeStripes]sync.Mutex
+
+var frameSizeJobs = make(chan struct{}, maxFrameSizeJobs)
+
+// FrameSizesDir is the directory holding the resized variants of the frame
+// at framePath, below the frames storage path.
+func FrameSizesDir(cfg *config.AppConfig, framePath string) (string, error) {
+	root, err := filepath.Abs(cfg.Extraction.StoragePath)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(framePath)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(abs))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not under %s", framePath, root)
+	}
+	return filepath.Join(cfg.App.DataDir, "frame_sizes", rel), nil
+}
+
+// ResizedFrame returns the path of the JPEG frame at framePath fitted within
+// w×h, keeping its aspect ratio; a zero width or height leaves that side
+// unbounded. Sizes round up to a multiple of frameSizeStep. A frame that
+// already fits is returned as is, as frames are never enlarged; otherwise the
+// variant in cacheDir is made if it is missing or older than the frame.
+func ResizedFrame(ctx context.Context, framePath, cacheDir string, w, h int) (string, error) {
+	if w < 0 || h < 0 || w > MaxFrameSize || h > MaxFrameSize {
+		return "", newError(ErrInvalidInput, "frame width and height must be between 0 (unbounded) and %d", MaxFrameSize)
+	}
+	if w == 0 && h == 0 {
+		return framePath, nil
+	}
+	w = (w + frameSizeStep - 1) / frameSizeStep * frameSizeStep
+	h = (h + frameSizeStep - 1) / frameSizeStep * frameSizeStep
+
+	frame, err := os.Stat(framePath)
+	if err != nil {
+		return "", newError(ErrFrameNotFound, "frame not found")
+	}
+	f, err := os.Open(framePath)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := jpeg.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", framePath, err)
+	}
+	dw, dh := fitFrameSize(cfg.Width, cfg.Height, w, h)
+	if dw >= cfg.Width && dh >= cfg.Height {
+		return framePath, nil
+	}
+
+	stem := strings.TrimSuffix(filepath.Base(framePath), filepath.Ext(framePath))
+	path := filepath.Join(cacheDir, fmt.Sprintf("%s_%dx%d.jpg", stem, w, h))
+	fresh := func() bool {
+		variant, err := os.Stat(path)
+		return err == nil && !variant.ModTime().Before(frame.ModTime())
+	}
+	if fresh() {
+		return path, nil
+	}
+	stripe := fnv.New32a()
+	stripe.Write([]byte(path))
+	mu := &frameSizeLocks[stripe.Sum32()%frameSizeStripes]
+	mu.Lock()
+	defer mu.Unlock()
+	if fresh() {
+		return path, nil
+	}
+	select {
+	case frameSizeJobs <- struct{}{}:
+		defer func() { <-frameSizeJobs }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	if f, err = os.Open(framePath); err != nil {
+		return "", err
+	}
+	src, err := jpeg.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", fmt.Errorf("decoding %s: %w", framePath, err)
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	if err := jpeg.Encode(out, resize.Resize(uint(dw), uint(dh), src, resize.Bicubic), &jpeg.Options{Quality: frameSizeQuality}); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+// RemoveFrameSizes deletes the cached variants of the frame at framePath.
+func RemoveFrameSizes(cfg *config.AppConfig, framePath string) {
+	dir, err := FrameSizesDir(cfg, framePath)
+	if err != nil {
+		return
+	}
+	stem := strings.TrimSuffix(filepath.Base(framePath), filepath.Ext(framePath))
+	variants, _ := filepath.Glob(filepath.Join(dir, stem+"_*x*.jpg"))
+	for _, v := range variants {
+		os.Remove(v)
+	}
+}
+
+// frameSizeSource is the frame a variant file name was made from:
+// "frame_000042_320x0.jpg" → "frame_000042.jpg".
+func frameSizeSource(name string) string {
+	i := strings.LastIndex(name, "_")
+	if i < 0 {
+		return name
+	}
+	return name[:i] + ".jpg"
+}
+
+// fitFrameSize scales width×height to fit within w×h, a zero bound leaving
+// that side free.
+func fitFrameSize(width, height, w, h int) (int, int) {
+	scale := math.Inf(1)
+	if w > 0 {
+		scale = float64(w) / float64(width)
+	}
+	if h > 0 {
+		scale = math.Min(scale, float64(h)/float64(height))
+	}
+	return max(int(math.Round(float64(width)*scale)), 1), max(int(math.Round(float64(height)*scale)), 1)
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/intelsk/backend/config"
+)
+
+// GCOptions controls CollectGarbage. Temporary files, frames of unfinished
+// extractions and stream directories younger than MinAge are left alone, as
+// a running server or pipeline may still be writing them.
+type GCOptions struct {
+	DryRun bool
+	MinAge time.Duration
+}
+
+// GCItem is one file or directory removed (or, with DryRun, that would be).
+type GCItem struct {
+	Kind  string `json:"kind"` // "frame", "state", "tmp", "stream", "preview", "timelapse", "frame_size"
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// GCResult summarises a garbage collection pass over a workspace.
+type GCResult struct {
+	DryRun         bool     `json:"dry_run,omitempty"`
+	ClipEmbeddings int64    `json:"clip_embeddings"`
+	FaceEmbeddings int64    `json:"face_embeddings"`
+	EmbeddingBytes int64    `json:"embedding_bytes"`
+	FrameFiles     int      `json:"frame_files"`
+	StateFiles     int      `json:"state_files"`
+	TmpFiles       int      `json:"tmp_files"`
+	StreamDirs     int      `json:"stream_dirs"`
+	PreviewDirs    int      `json:"preview_dirs"`
+	TimelapseFiles int      `json:"timelapse_files"`
+	FrameSizeFiles int      `json:"frame_size_files"`
+	FileBytes      int64    `json:"file_bytes"`
+	Items          []GCItem `json:"items"`
+}
+
+// TotalBytes is the space reclaimed on disk plus the embedding data freed
+// inside the database.
+func (r *GCResult) TotalBytes() int64 {
+	return r.FileBytes + r.EmbeddingBytes
+}
+
+// CollectGarbage removes what processing, deletions and crashes leave behind
+// in a workspace:
+//   - CLIP and face embeddings whose frame JPEG no longer exists
+//   - frame JPEGs not listed in their directory's manifest.json
+//   - index_state.json/face_state.json in directories without a manifest
+//   - stale *.tmp and *.transcoding.mp4 files
+//   - live stream directories in data/streams no ffmpeg is writing to
+//   - preview sprites in data/previews whose video is gone, and unfinished
+//     ones
+//   - timelapses in data/timelapses whose date has no extracted frames
+//   - resized frames in data/frame_sizes whose frame is gone
+func CollectGarbage(cfg *config.AppConfig, storage *Storage, opts GCOptions) (*GCResult, error) {
+	result := &GCResult{DryRun: opts.DryRun, Items: []GCItem{}}
+	cutoff := time.Now().Add(-opts.MinAge)
+
+	remove := func(kind, path string, bytes int64) {
+		if !opts.DryRun {
+			if err := os.RemoveAll(path); err != nil {
+				return
+			}
+		}
+		result.Items = append(result.Items, GCItem{Kind: kind, Path: path, Bytes: bytes})
+		result.FileBytes += bytes
+		switch kind {
+		case "frame":
+			result.FrameFiles++
+		case "state":
+			result.StateFiles++
+		case "tmp":
+			result.TmpFiles++
+		case "stream":
+			result.StreamDirs++
+		case "preview":
+			result.PreviewDirs++
+		case "timelapse":
+			result.TimelapseFiles++
+		case "frame_size":
+			result.FrameSizeFiles++
+		}
+	}
+
+	// Manifests map the frame paths stored with embeddings to files on disk;
+	// relative paths resolve against the manifest's directory, as IndexFaces
+	// does.
+	resolved := make(map[string]string)
+	cameras, _ := os.ReadDir(cfg.Extraction.StoragePath)
+	for _, cam := range cameras {
+		if !cam.IsDir() || strings.HasPrefix(cam.Name(), ".") {
+			continue
+		}
+		dates, _ := os.ReadDir(filepath.Join(cfg.Extraction.StoragePath, cam.Name()))
+		for _, d := range dates {
+			if !d.IsDir() {
+				continue
+			}
+			dir := filepath.Join(cfg.Extraction.StoragePath, cam.Name(), d.Name())
+			if err := gcFramesDir(dir, cutoff, resolved, remove); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := gcEmbeddings(storage, resolved, opts.DryRun, result); err != nil {
+		return nil, err
+	}
+
+	roots := []string{cfg.App.DataDir}
+	if rel, err := filepath.Rel(cfg.App.DataDir, cfg.Extraction.StoragePath); err != nil || strings.HasPrefix(rel, "..") {
+		roots = append(roots, cfg.Extraction.StoragePath)
+	}
+	workspaces := filepath.Join(cfg.App.DataDir, "workspaces")
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			// Other workspaces are collected on their own
+			if d.IsDir() && cfg.Workspace == "" && path == workspaces {
+				return filepath.SkipDir
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			name := d.Name()
+			if !strings.HasSuffix(name, ".tmp") && !strings.HasSuffix(name, ".transcoding.mp4") {
+				return nil
+			}
+			if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+				remove("tmp", path, info.Size())
+			}
+			return nil
+		})
+	}
+
+	streamsDir := filepath.Join(cfg.App.DataDir, "streams")
+	streams, _ := os.ReadDir(streamsDir)
+	for _, st := range streams {
+		if !st.IsDir() {
+			// Concat lists of pseudo-live streams
+			if info, err := st.Info(); err == nil && info.ModTime().Before(cutoff) {
+				remove("stream", filepath.Join(streamsDir, st.Name()), info.Size())
+			}
+			continue
+		}
+		// ffmpeg rewrites the playlist every segment while a stream is live
+		dir := filepath.Join(streamsDir, st.Name())
+		if latestModTime(dir).Before(cutoff) {
+			remove("stream", dir, dirSize(dir))
+		}
+	}
+
+	// data/previews/{camera}/{date}/{stem}[.tmp] mirrors data/videos
+	previewsDir := filepath.Join(cfg.App.DataDir, "previews")
+	previews, _ := filepath.Glob(filepath.Join(previewsDir, "*", "*", "*"))
+	for _, dir := range previews {
+		rel, _ := filepath.Rel(previewsDir, dir)
+		stem, unfinished := strings.CutSuffix(rel, ".tmp")
+		video := filepath.Join(cfg.App.DataDir, "videos", stem+".mp4")
+		if (unfinished && latestModTime(dir).Before(cutoff)) || (!unfinished && !fileExists(video)) {
+			remove("preview", dir, dirSize(dir))
+		}
+	}
+
+	// data/timelapses/{camera}/{date}_{fps}fps_{height}p.mp4
+	timelapses, _ := filepath.Glob(filepath.Join(cfg.App.DataDir, "timelapses", "*", "*.mp4"))
+	for _, path := range timelapses {
+		date, _, _ := strings.Cut(filepath.Base(path), "_")
+		camera := filepath.Base(filepath.Dir(path))
+		if !fileExists(filepath.Join(cfg.Extraction.StoragePath, camera, date, "manifest.json")) {
+			if info, err := os.Stat(path); err == nil {
+				remove("timelapse", path, info.Size())
+			}
+		}
+	}
+
+	// data/frame_sizes/{camera}/{date}/{stem}_{w}x{h}.jpg mirrors the frames
+	variants, _ := filepath.Glob(filepath.Join(cfg.App.DataDir, "frame_sizes", "*", "*", "*.jpg"))
+	for _, path := range variants {
+		date := filepath.Dir(path)
+		camera := filepath.Dir(date)
+		frame := filepath.Join(cfg.Extraction.StoragePath, filepath.Base(camera), filepath.Base(date), frameSizeSource(filepath.Base(path)))
+		if !fileExists(frame) {
+			if info, err := os.Stat(path); err == nil {
+				remove("frame_size", path, info.Size())
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// gcFramesDir removes the frames a manifest no longer lists and records the
+// manifest's frame paths in resolved. A directory without a manifest is an
+// extraction that never finished; once it is older than cutoff its frames
+// and state files all go.
+func gcFramesDir(dir string, cutoff time.Time, resolved map[string]string,
+	remove func(kind, path string, bytes int64)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "manifest.json"))
+	hasManifest := err == nil
+	if !hasManifest && latestModTime(dir).After(cutoff) {
+		return nil
+	}
+	frames, err := LoadManifest(dir)
+	if err != nil {
+		// Unreadable manifest: don't guess which frames are referenced
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	listed := make(map[string]bool)
+	for _, f := range frames {
+		path := f.FramePath
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, filepath.Base(path))
+		}
+		resolved[f.FramePath] = path
+		listed[filepath.Base(path)] = true
+	}
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		name := e.Name()
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, ".jpg") && !listed[name]:
+			remove("frame", filepath.Join(dir, name), info.Size())
+		case !hasManifest && (name == "index_state.json" || name == "face_state.json"):
+			remove("state", filepath.Join(dir, name), info.Size())
+		}
+	}
+	return nil
+}
+
+// gcEmbeddings deletes the embeddings of frames whose JPEG is gone.
+func gcEmbeddings(storage *Storage, resolved map[string]string, dryRun bool, result *GCResult) error {
+	type frameRows struct {
+		clip, face, bytes int64
+	}
+	byFrame := make(map[string]*frameRows)
+	for _, table := range []string{"clip_embeddings", "face_embeddings"} {
+		rows, err := storage.DB().Query(
+			"SELECT frame_path, COUNT(*), SUM(LENGTH(embedding)) FROM " + table + " GROUP BY frame_path")
+		if err != nil {
+			return fmt.Errorf("scanning %s: %w", table, err)
+		}
+		for rows.Next() {
+			var path string
+			var n, bytes int64
+			if err := rows.Scan(&path, &n, &bytes); err != nil {
+				rows.Close()
+				return fmt.Errorf("scanning %s: %w", table, err)
+			}
+			fr := byFrame[path]
+			if fr == nil {
+				fr = &frameRows{}
+				byFrame[path] = fr
+			}
+			if table == "clip_embeddings" {
+				fr.clip += n
+			} else {
+				fr.face += n
+			}
+			fr.bytes += bytes
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	var orphans []string
+	for path, fr := range byFrame {
+		file := path
+		if r, ok := resolved[path]; ok {
+			file = r
+		}
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			continue
+		}
+		orphans = append(orphans, path)
+		result.ClipEmbeddings += fr.clip
+		result.FaceEmbeddings += fr.face
+		result.EmbeddingBytes += fr.bytes
+	}
+	if dryRun || len(orphans) == 0 {
+		return nil
+	}
+	if _, _, err := storage.DeleteFrameEmbeddings(orphans); err != nil {
+		return fmt.Errorf("deleting orphaned embeddings: %w", err)
+	}
+	return nil
+}
+
+// latestModTime returns the newest modification time of dir and the files
+// directly inside it.
+func latestModTime(dir string) time.Time {
+	var latest time.Time
+	if info, err := os.Stat(dir); err == nil {
+		latest = info.ModTime()
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
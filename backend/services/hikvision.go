@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/tls"
 	"encoding/hex"
@@ -23,6 +24,7 @@ type HikvisionClient struct {
 	username string
 	password string
 	client   *http.Client
+	ctx      context.Context
 }
 
 func NewHikvisionClient(ip, username, password string) *HikvisionClient {
@@ -39,6 +41,27 @@ func NewHikvisionClient(ip, username, password string) *HikvisionClient {
 	}
 }
 
+// SetTimeout overrides the HTTP timeout for subsequent requests, e.g. to
+// keep connectivity probes short.
+func (c *HikvisionClient) SetTimeout(d time.Duration) {
+	c.client.Timeout = d
+}
+
+// WithContext returns a copy of the client whose requests are aborted once
+// ctx is done, e.g. a recording download of a cancelled job.
+func (c *HikvisionClient) WithContext(ctx context.Context) *HikvisionClient {
+	cp := *c
+	cp.ctx = ctx
+	return &cp
+}
+
+func (c *HikvisionClient) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 // Recording represents a single recording found on the NVR.
 type Recording struct {
 	SourceID    string
@@ -53,11 +76,14 @@ func (c *HikvisionClient) Snapshot(channel int) ([]byte, error) {
 	url := fmt.Sprintf("https://%s/ISAPI/Streaming/channels/%d01/picture", c.ip, channel)
 	resp, err := c.doDigest("GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("snapshot request: %w", err)
+		return nil, classifyNetError(err, ErrNVRUnreachable, ErrNVRTimeout, "snapshot request: %w")
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, newError(ErrNVRAuth, "snapshot returned %d", resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("snapshot returned %d", resp.StatusCode)
+		return nil, newError(ErrNVRFailed, "snapshot returned %d", resp.StatusCode)
 	}
 	return io.ReadAll(resp.Body)
 }
@@ -112,15 +138,15 @@ func (c *HikvisionClient) GetDeviceInfo() (*DeviceInfo, error) {
 	url := fmt.Sprintf("https://%s/ISAPI/System/deviceInfo", c.ip)
 	resp, err := c.doDigest("GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("device info request: %w", err)
+		return nil, classifyNetError(err, ErrNVRUnreachable, ErrNVRTimeout, "device info request: %w")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusUnauthorized {
-		return nil, fmt.Errorf("authentication failed: check username and password")
+		return nil, newError(ErrNVRAuth, "authentication failed: check username and password")
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("device info returned %d", resp.StatusCode)
+		return nil, newError(ErrNVRFailed, "device info returned %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -170,13 +196,13 @@ func (c *HikvisionClient) SearchRecordings(channel int, start, end time.Time) ([
 	url := fmt.Sprintf("https://%s/ISAPI/ContentMgmt/search", c.ip)
 	resp, err := c.doDigest("POST", url, strings.NewReader(searchXML))
 	if err != nil {
-		return nil, fmt.Errorf("search request: %w", err)
+		return nil, classifyNetError(err, ErrNVRUnreachable, ErrNVRTimeout, "search request: %w")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("search returned %d: %s", resp.StatusCode, string(body))
+		return nil, newError(ErrNVRFailed, "search returned %d: %s", resp.StatusCode, string(body))
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -208,13 +234,13 @@ func (c *HikvisionClient) DownloadClip(playbackURI, outputPath string) error {
 
 	resp, err := c.doDigest("POST", downloadURL, strings.NewReader(downloadXML))
 	if err != nil {
-		return fmt.Errorf("download request: %w", err)
+		return classifyNetError(err, ErrNVRUnreachable, ErrNVRTimeout, "download request: %w")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("download returned %d: %s", resp.StatusCode, string(body))
+		return newError(ErrNVRFailed, "download returned %d: %s", resp.StatusCode, string(body))
 	}
 
 	tmpPath := outputPath + ".tmp"
@@ -249,7 +275,7 @@ func (c *HikvisionClient) doDigest(method, url string, body io.Reader) (*http.Re
 		}
 	}
 
-	req, err := http.NewRequest(method, url, nil)
+	req, err := http.NewRequestWithContext(c.context(), method, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -262,7 +288,7 @@ func (c *HikvisionClient) doDigest(method, url string, body io.Reader) (*http.Re
 		// No auth needed or different error
 		if bodyBytes != nil {
 			resp.Body.Close()
-			req2, _ := http.NewRequest(method, url, strings.NewReader(string(bodyBytes)))
+			req2, _ := http.NewRequestWithContext(c.context(), method, url, strings.NewReader(string(bodyBytes)))
 			return c.client.Do(req2)
 		}
 		return resp, nil
@@ -310,7 +336,7 @@ func (c *HikvisionClient) doDigest(method, url string, body io.Reader) (*http.Re
 	if bodyBytes != nil {
 		reqBody = strings.NewReader(string(bodyBytes))
 	}
-	req2, err := http.NewRequest(method, url, reqBody)
+	req2, err := http.NewRequestWithContext(c.context(), method, url, reqBody)
 	if err != nil {
 		return nil, err
 	}
@@ -418,6 +444,15 @@ func RTSPUrl(ip string, rtspPort int, username, password string, channel, stream
 		username, password, ip, rtspPort, channel, streamType)
 }
 
+// PlaybackRTSPUrl builds the RTSP URL that plays a channel's main-stream
+// recordings from start to end. Like SearchRecordings it sends the NVR's
+// local wall-clock time with a Z suffix, which is what Hikvision expects.
+func PlaybackRTSPUrl(ip string, rtspPort int, username, password string, channel int, start, end time.Time) string {
+	const layout = "20060102T150405Z"
+	return fmt.Sprintf("rtsp://%s:%s@%s:%d/Streaming/tracks/%d01/?starttime=%s&endtime=%s",
+		username, password, ip, rtspPort, channel, start.Format(layout), end.Format(layout))
+}
+
 // NVRChannel extracts the channel number from a hikvision camera config.
 func NVRChannel(cam *models.CameraInfo) int {
 	if ch, ok := cam.Config["nvr_channel"].(float64); ok && ch >= 1 {
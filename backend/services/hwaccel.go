@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hardware encoders for live transcoding, selected by stream.hwaccel.
+const (
+	HWAccelNone  = "none" // libx264 in software
+	HWAccelAuto  = "auto" // the first of hwAccelOrder that works
+	HWAccelVAAPI = "vaapi"
+	HWAccelNVENC = "nvenc"
+	HWAccelQSV   = "qsv"
+)
+
+// DefaultVAAPIDevice is the render node VAAPI uses unless configured.
+const DefaultVAAPIDevice = "/dev/dri/renderD128"
+
+// hwAccelOrder is the order DetectHWAccel tries the accelerators in.
+var hwAccelOrder = []string{HWAccelNVENC, HWAccelVAAPI, HWAccelQSV}
+
+// videoEncoder is the part of an ffmpeg command line that picks and feeds
+// the H.264 encoder.
+type videoEncoder struct {
+	name    string   // ffmpeg encoder
+	global  []string // options before the input
+	filters []string // appended to the video filters to hand frames over
+	opts    []string // encoder options
+}
+
+// videoEncoderFor returns the encoder for an accelerator; anything unknown
+// gets libx264. Decoding stays in software, which costs far less than
+// encoding and works for every input.
+func videoEncoderFor(accel, vaapiDevice string) videoEncoder {
+	switch accel {
+	case HWAccelVAAPI:
+		if vaapiDevice == "" {
+			vaapiDevice = DefaultVAAPIDevice
+		}
+		return videoEncoder{
+			name:    "h264_vaapi",
+			global:  []string{"-vaapi_device", vaapiDevice},
+			filters: []string{"format=nv12", "hwupload"},
+		}
+	case HWAccelNVENC:
+		// Forced keyframes must be IDR frames for segments to start clean
+		return videoEncoder{name: "h264_nvenc", opts: []string{"-preset", "p1", "-tune", "ll", "-forced-idr", "1"}}
+	case HWAccelQSV:
+		return videoEncoder{name: "h264_qsv", filters: []string{"format=nv12"}, opts: []string{"-preset", "veryfast"}}
+	default:
+		return videoEncoder{name: "libx264", opts: []string{"-preset", "ultrafast", "-tune", "zerolatency"}}
+	}
+}
+
+// DetectHWAccel returns the first accelerator in hwAccelOrder that can
+// encode a short test clip, or HWAccelNone. An encoder compiled into ffmpeg
+// proves nothing about the hardware, so each one is tried for real.
+func DetectHWAccel(ctx context.Context, vaapiDevice string) string {
+	for _, accel := range hwAccelOrder {
+		if ProbeHWAccel(ctx, accel, vaapiDevice) == nil {
+			return accel
+		}
+	}
+	return HWAccelNone
+}
+
+// ProbeHWAccel checks that an accelerator's encoder works by encoding a
+// short test clip.
+func ProbeHWAccel(ctx context.Context, accel, vaapiDevice string) error {
+	enc := videoEncoderFor(accel, vaapiDevice)
+	if accel == HWAccelVAAPI {
+		if _, err := os.Stat(enc.global[1]); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, enc.global...)
+	args = append(args, "-f", "lavfi", "-i", "color=c=black:s=320x240:d=0.2")
+	if len(enc.filters) > 0 {
+		args = append(args, "-vf", strings.Join(enc.filters, ","))
+	}
+	args = append(args, "-c:v", enc.name)
+	args = append(args, enc.opts...)
+	args = append(args, "-f", "null", "-")
+	out, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			line, _, _ := strings.Cut(msg, "\n")
+			return fmt.Errorf("%s: %s", enc.name, line)
+		}
+		return fmt.Errorf("%s: %w", enc.name, err)
+	}
+	return nil
+}
+
+// detectedHWAccel caches auto-detection for the process; every workspace's
+// Streamer shares the hardware.
+var detectedHWAccel struct {
+	once  sync.Once
+	accel string
+}
+
+// encoder resolves stream.hwaccel to the encoder live streams use. "auto"
+// runs detection on first use.
+func (s *Streamer) encoder() videoEncoder {
+	accel := s.opts.HWAccel
+	if accel == HWAccelAuto {
+		detectedHWAccel.once.Do(func() {
+			detectedHWAccel.accel = DetectHWAccel(context.Background(), s.opts.VAAPIDevice)
+			log.Printf("Live transcoding: detected hardware acceleration %q", detectedHWAccel.accel)
+		})
+		accel = detectedHWAccel.accel
+	}
+	return videoEncoderFor(accel, s.opts.VAAPIDevice)
+}
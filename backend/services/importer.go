@@ -0,0 +1,209 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportableExtensions lists the video containers the importer accepts.
+// Anything other than .mp4 is remuxed (stream copy, no re-encode) to MP4.
+var ImportableExtensions = []string{".mp4", ".m4v", ".mov", ".mkv", ".avi", ".ts"}
+
+// defaultDatePattern matches the date and optional time found in most NVR
+// and camera export filenames, e.g. "2024-03-01_14-05-00.mp4",
+// "ch01_20240301140500.mp4" or "VID_20240301_1405.mp4".
+var defaultDatePattern = regexp.MustCompile(
+	`(?P<year>(?:19|20)\d{2})[-_.]?(?P<month>[01]\d)[-_.]?(?P<day>[0-3]\d)` +
+		`(?:[T _.-]?(?P<hour>[0-2]\d)[-_.:h]?(?P<minute>[0-5]\d)(?:[-_.:m]?(?P<second>[0-5]\d))?)?`)
+
+// RecordingTime is when a recording started, as inferred by InferRecordingTime.
+type RecordingTime struct {
+	Start   time.Time
+	HasTime bool   // false when only the date is known
+	Source  string // "filename", "mtime" or "override"
+}
+
+// InferRecordingTime works out when a video was recorded. With source
+// "filename" the date (and time, if present) is parsed from the file name
+// using pattern, or the default pattern when nil; custom patterns use the
+// named groups year, month, day and optionally hour, minute, second. With
+// "mtime" the file's modification time minus its duration is used, since
+// recorders usually touch a file last when they finish writing it. "auto"
+// tries the filename first and falls back to mtime.
+func InferRecordingTime(path, source string, pattern *regexp.Regexp) (*RecordingTime, error) {
+	if pattern == nil {
+		pattern = defaultDatePattern
+	}
+	switch source {
+	case "filename":
+		return timeFromFilename(path, pattern)
+	case "mtime":
+		return timeFromMtime(path)
+	case "auto", "":
+		if rt, err := timeFromFilename(path, pattern); err == nil {
+			return rt, nil
+		}
+		return timeFromMtime(path)
+	default:
+		return nil, newError(ErrInvalidInput, "unknown date source %q (want filename, mtime or auto)", source)
+	}
+}
+
+func timeFromFilename(path string, pattern *regexp.Regexp) (*RecordingTime, error) {
+	name := filepath.Base(path)
+	m := pattern.FindStringSubmatch(name)
+	if m == nil {
+		return nil, newError(ErrInvalidInput, "no date in filename %q", name)
+	}
+	group := func(name string) (int, bool) {
+		i := pattern.SubexpIndex(name)
+		if i < 0 || m[i] == "" {
+			return 0, false
+		}
+		n, err := strconv.Atoi(m[i])
+		return n, err == nil
+	}
+
+	year, okY := group("year")
+	month, okM := group("month")
+	day, okD := group("day")
+	if !okY || !okM || !okD {
+		return nil, newError(ErrInvalidInput, "pattern must capture year, month and day")
+	}
+	hour, hasTime := group("hour")
+	minute, _ := group("minute")
+	second, _ := group("second")
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 {
+		return nil, newError(ErrInvalidInput, "invalid date in filename %q", name)
+	}
+
+	start := time.Date(year, time.Month(month), day, hour, minute, second, 0, time.Local)
+	if start.Day() != day {
+		return nil, newError(ErrInvalidInput, "invalid date in filename %q", name)
+	}
+	return &RecordingTime{Start: start, HasTime: hasTime, Source: "filename"}, nil
+}
+
+func timeFromMtime(path string) (*RecordingTime, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	start := fi.ModTime()
+	if probe, err := ProbeVideo(path); err == nil && probe.DurationSec > 0 {
+		start = start.Add(-time.Duration(probe.DurationSec * float64(time.Second)))
+	}
+	return &RecordingTime{Start: start, HasTime: true, Source: "mtime"}, nil
+}
+
+// ImportDestination returns where a recording belongs in the videos tree:
+// videos/{camera}/{date}/HHMM.mp4 when the start time is known (the hour
+// prefix is what frame timestamps are derived from), otherwise the sanitized
+// original name. An existing file gets a _1, _2, ... suffix.
+func ImportDestination(dataDir, cameraID, srcPath string, rt *RecordingTime) string {
+	dir := filepath.Join(dataDir, "videos", cameraID, rt.Start.Format("2006-01-02"))
+
+	stem := sanitizeFilename(strings.TrimSuffix(filepath.Base(srcPath), filepath.Ext(srcPath)))
+	if rt.HasTime {
+		stem = rt.Start.Format("1504")
+	}
+	dest := filepath.Join(dir, stem+".mp4")
+	for i := 1; fileExists(dest); i++ {
+		dest = filepath.Join(dir, fmt.Sprintf("%s_%d.mp4", stem, i))
+	}
+	return dest
+}
+
+// ImportVideo copies (or moves) srcPath to destPath, remuxing non-MP4
+// containers to MP4 without re-encoding.
+func ImportVideo(srcPath, destPath string, move bool) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	if strings.ToLower(filepath.Ext(srcPath)) != ".mp4" {
+		tmp := destPath + ".importing.mp4"
+		cmd := exec.Command("ffmpeg", "-v", "error", "-i", srcPath,
+			"-c", "copy", "-movflags", "+faststart", "-y", tmp)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("ffmpeg remux: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		if err := os.Rename(tmp, destPath); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if move {
+			return os.Remove(srcPath)
+		}
+		return nil
+	}
+
+	if move {
+		if err := os.Rename(srcPath, destPath); err == nil {
+			return nil
+		}
+		// Different filesystem: fall back to copy + delete
+	}
+	if err := CopyFile(srcPath, destPath); err != nil {
+		return err
+	}
+	if move {
+		return os.Remove(srcPath)
+	}
+	return nil
+}
+
+// CopyFile copies src to dst, keeping the modification time. A partially
+// written dst is removed on failure.
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	// Keep the original mtime so a re-import infers the same time
+	if fi, err := os.Stat(src); err == nil {
+		os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+	}
+	return nil
+}
+
+func sanitizeFilename(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+	if safe == "" || safe == "." || safe == ".." {
+		safe = "import"
+	}
+	return safe
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
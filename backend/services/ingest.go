@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+)
+
+// ingestSettle is how long after it ends a recording is left alone, so one
+// the NVR is still writing is not downloaded half-finished.
+const ingestSettle = 2 * time.Minute
+
+// IngestParams are the params of an ingest job.
+type IngestParams struct {
+	CameraID string `json:"camera_id"`
+}
+
+// AutoIngest reports whether a camera has auto_ingest set in its config.
+// Only Hikvision cameras are ingested.
+func AutoIngest(cam *models.CameraInfo) bool {
+	v, _ := cam.Config["auto_ingest"].(bool)
+	return v && cam.Type == "hikvision"
+}
+
+// Ingester polls the NVR for the cameras with auto_ingest set: every
+// ingest.interval_min minutes it submits an ingest job per camera, which
+// downloads the recordings finished since the camera's cursor, the end of
+// the last one ingested, and extracts and indexes them. That turns the
+// daily batch into near-real-time indexing. Cursors are kept in the
+// ingest_state table, so a restart carries on where ingest stopped.
+type Ingester struct {
+	db       *sql.DB
+	cfg      *config.AppConfig
+	settings *SettingsService
+	cameras  *CameraService
+	jobs     *JobManager
+
+	// mu serializes polls, so a camera never gets two jobs for one tick
+	mu sync.Mutex
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewIngester(db *sql.DB, cfg *config.AppConfig, settings *SettingsService, cameras *CameraService, jobs *JobManager) *Ingester {
+	return &Ingester{
+		db:       db,
+		cfg:      cfg,
+		settings: settings,
+		cameras:  cameras,
+		jobs:     jobs,
+		stop:     make(chan struct{}),
+	}
+}
+
+// state reads a camera's ingest_state row; a camera never ingested has a
+// zero state.
+func (g *Ingester) state(cameraID string) (models.IngestState, error) {
+	st := models.IngestState{CameraID: cameraID}
+	var cursor, lastRunAt sql.NullString
+	err := g.db.QueryRow("SELECT cursor, last_run_at, last_job_id, last_error FROM ingest_state WHERE camera_id = ?", cameraID).
+		Scan(&cursor, &lastRunAt, &st.LastJobID, &st.LastError)
+	if errors.Is(err, sql.ErrNoRows) {
+		return st, nil
+	}
+	if err != nil {
+		return st, fmt.Errorf("querying ingest state of %s: %w", cameraID, err)
+	}
+	if cursor.Valid {
+		t, _ := time.Parse(time.DateTime, cursor.String)
+		st.Cursor = &t
+	}
+	if lastRunAt.Valid {
+		t, _ := time.Parse(jobTimeLayout, lastRunAt.String)
+		st.LastRunAt = &t
+	}
+	return st, nil
+}
+
+// List returns the ingest state of every camera with auto_ingest set.
+func (g *Ingester) List() ([]models.IngestState, error) {
+	cameras, err := g.cameras.List()
+	if err != nil {
+		return nil, err
+	}
+	interval := time.Duration(g.settings.GetInt("ingest.interval_min")) * time.Minute
+	states := []models.IngestState{}
+	for i := range cameras {
+		if !AutoIngest(&cameras[i]) {
+			continue
+		}
+		st, err := g.state(cameras[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		next := time.Now().UTC()
+		if st.LastRunAt != nil && st.LastRunAt.Add(interval).After(next) {
+			next = st.LastRunAt.Add(interval)
+		}
+		st.NextRunAt = &next
+		states = append(states, st)
+	}
+	return states, nil
+}
+
+// RunNow polls a camera immediately and returns the ingest job it started.
+// It is ErrBusy while the camera's previous ingest job is still running.
+func (g *Ingester) RunNow(cameraID string) (*Job, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	cam, err := g.cameras.Get(cameraID)
+	if err != nil {
+		return nil, err
+	}
+	if !AutoIngest(cam) {
+		return nil, newError(ErrInvalidInput, "camera %s does not have auto_ingest set", cameraID)
+	}
+	return g.run(cam.ID)
+}
+
+// run submits an ingest job for a camera and records it as its last run.
+func (g *Ingester) run(cameraID string) (*Job, error) {
+	st, err := g.state(cameraID)
+	if err != nil {
+		return nil, err
+	}
+	if st.LastJobID != "" {
+		if prev, err := g.jobs.Get(st.LastJobID); err == nil && (prev.Status == "running" || prev.Status == "queued") {
+			return nil, newError(ErrBusy, "camera %s: previous ingest job %s is still %s", cameraID, prev.ID, prev.Status)
+		}
+	}
+	job, err := g.jobs.Submit(JobTypeIngest, IngestParams{CameraID: cameraID})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := g.db.Exec(`INSERT INTO ingest_state (camera_id, last_run_at, last_job_id) VALUES (?, ?, ?)
+		ON CONFLICT(camera_id) DO UPDATE SET last_run_at = excluded.last_run_at, last_job_id = excluded.last_job_id`,
+		cameraID, time.Now().UTC().Format(jobTimeLayout), job.ID); err != nil {
+		log.Printf("recording ingest run of %s: %v", cameraID, err)
+	}
+	return job, nil
+}
+
+// setCursor records how far a camera's recordings were ingested, and the
+// error the last ingest stopped at, if any.
+func (g *Ingester) setCursor(cameraID string, cursor time.Time, lastError string) {
+	if _, err := g.db.Exec(`INSERT INTO ingest_state (camera_id, cursor, last_error) VALUES (?, ?, ?)
+		ON CONFLICT(camera_id) DO UPDATE SET cursor = excluded.cursor, last_error = excluded.last_error`,
+		cameraID, cursor.Format(time.DateTime), lastError); err != nil {
+		log.Printf("recording ingest cursor of %s: %v", cameraID, err)
+	}
+}
+
+// Download fetches the recordings of cam that started at or after its
+// cursor and ended at least ingestSettle ago, oldest first, into
+// data/videos/{camera}/{date}/, advancing the cursor past each one. A camera
+// never ingested starts at midnight today. It stops at the first failure,
+// so nothing is skipped, and returns the dates it downloaded into.
+func (g *Ingester) Download(ctx context.Context, cam *models.CameraInfo, events chan<- ProgressEvent) ([]string, error) {
+	nvrIP := g.settings.Get("nvr.ip")
+	if nvrIP == "" {
+		return nil, fmt.Errorf("NVR IP not configured in settings")
+	}
+	st, err := g.state(cam.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := nvrNow(g.settings)
+	cursor := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if st.Cursor != nil {
+		cursor = *st.Cursor
+	}
+	until := now.Add(-ingestSettle)
+	if !until.After(cursor) {
+		return nil, nil
+	}
+
+	events <- ProgressEvent{
+		Stage:    "downloading",
+		CameraID: cam.ID,
+		Message:  fmt.Sprintf("Searching recordings of %s since %s", cam.Name, cursor.Format("2006-01-02 15:04:05")),
+	}
+	client := NewHikvisionClient(nvrIP, g.settings.Get("nvr.username"), g.settings.Get("nvr.password")).WithContext(ctx)
+	found, err := client.SearchRecordings(NVRChannel(cam), cursor, now)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		g.setCursor(cam.ID, cursor, err.Error())
+		return nil, fmt.Errorf("NVR search for %s: %w", cam.ID, err)
+	}
+	var recordings []Recording
+	for _, rec := range found {
+		// One starting before the cursor was ingested by an earlier poll
+		if !rec.StartTime.Before(cursor) && !rec.EndTime.After(until) {
+			recordings = append(recordings, rec)
+		}
+	}
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].StartTime.Before(recordings[j].StartTime) })
+
+	var dates []string
+	skipDuplicates := DuplicateMode(g.settings) != DuplicatesOff
+	for i, rec := range recordings {
+		date := rec.StartTime.Format("2006-01-02")
+		videosDir := filepath.Join(g.cfg.App.DataDir, "videos", cam.ID, date)
+		if skipDuplicates {
+			// Fetched already, e.g. by a processing job
+			if existing := DownloadedRecording(videosDir, rec); existing != "" {
+				JobLogf(ctx, "Skipping %s/%s %s: already stored as %s", cam.ID, date, rec.StartTime.Format("15:04"), filepath.Base(existing))
+				cursor = rec.EndTime
+				g.setCursor(cam.ID, cursor, "")
+				continue
+			}
+		}
+		filename := rec.StartTime.Format("1504") + ".mp4"
+		for j := 1; ; j++ {
+			if _, err := os.Stat(filepath.Join(videosDir, filename)); os.IsNotExist(err) {
+				break
+			}
+			filename = fmt.Sprintf("%s_%d.mp4", rec.StartTime.Format("1504"), j)
+		}
+		events <- ProgressEvent{
+			Stage:       "downloading",
+			CameraID:    cam.ID,
+			Date:        date,
+			File:        filename,
+			VideosDone:  i,
+			VideosTotal: len(recordings),
+			Message:     fmt.Sprintf("Downloading %s %s-%s (%d/%d)...", cam.Name, rec.StartTime.Format("15:04"), rec.EndTime.Format("15:04"), i+1, len(recordings)),
+		}
+		if err := client.DownloadClip(rec.PlaybackURI, filepath.Join(videosDir, filename)); ctx.Err() != nil {
+			return dates, ctx.Err()
+		} else if err != nil {
+			JobLogf(ctx, "NVR download failed for %s/%s/%s: %v", cam.ID, date, filename, err)
+			g.setCursor(cam.ID, cursor, err.Error())
+			return dates, fmt.Errorf("downloading %s: %w", filename, err)
+		}
+		cursor = rec.EndTime
+		g.setCursor(cam.ID, cursor, "")
+		if len(dates) == 0 || dates[len(dates)-1] != date {
+			dates = append(dates, date)
+		}
+	}
+	if len(recordings) == 0 {
+		events <- ProgressEvent{
+			Stage:    "downloading",
+			CameraID: cam.ID,
+			Message:  fmt.Sprintf("No new recordings of %s", cam.Name),
+		}
+		g.setCursor(cam.ID, cursor, "")
+	}
+	return dates, nil
+}
+
+// tick polls the auto_ingest cameras whose interval has passed.
+func (g *Ingester) tick() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	states, err := g.List()
+	if err != nil {
+		log.Printf("checking auto-ingest cameras: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, st := range states {
+		if st.NextRunAt.After(now) {
+			continue
+		}
+		// A camera whose previous job still runs is polled on the next tick
+		if _, err := g.run(st.CameraID); err != nil && !errors.Is(err, ErrBusy) {
+			log.Printf("Auto-ingest %s: %v", st.CameraID, err)
+		}
+	}
+}
+
+// Start polls the auto_ingest cameras until Close, checking every minute
+// which are due.
+func (g *Ingester) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			g.tick()
+			select {
+			case <-ticker.C:
+			case <-g.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops polling. Ingest jobs already running are not affected.
+func (g *Ingester) Close() {
+	g.stopOnce.Do(func() { close(g.stop) })
+}
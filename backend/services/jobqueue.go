@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// jobPriority is the default priority of a job type; higher goes first.
+// Uploads go ahead of processing runs because someone is usually watching
+// them.
+var jobPriority = map[string]int{
+	JobTypeUpload: 1,
+}
+
+// Bounds of the priority a job can be submitted with.
+const (
+	MinJobPriority = -10
+	MaxJobPriority = 10
+)
+
+// JobQueue bounds how many jobs run at once across every workspace, so
+// processing runs and uploads don't all compete for the CPU, ffmpeg and the
+// ML sidecar. Jobs waiting for a slot start in priority order, first come
+// first served within a priority. One job runs one ffmpeg extraction at a
+// time, so the limit bounds those too.
+type JobQueue struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	waiting []*queuedJob
+	seq     uint64
+}
+
+type queuedJob struct {
+	priority int
+	seq      uint64
+	ready    chan struct{} // closed when the job gets its slot
+}
+
+func NewJobQueue(limit int) *JobQueue {
+	return &JobQueue{limit: max(limit, 1)}
+}
+
+// SetLimit changes how many jobs may run at once. Raising it starts waiting
+// jobs right away; lowering it lets running jobs finish.
+func (q *JobQueue) SetLimit(limit int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit = max(limit, 1)
+	q.dispatch()
+}
+
+// TryAcquire takes a slot if one is free and nobody is waiting for it.
+func (q *JobQueue) TryAcquire() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running < q.limit && len(q.waiting) == 0 {
+		q.running++
+		return true
+	}
+	return false
+}
+
+// Acquire waits for a slot, or until ctx is done.
+func (q *JobQueue) Acquire(ctx context.Context, priority int) error {
+	if q.TryAcquire() {
+		return nil
+	}
+
+	q.mu.Lock()
+	q.seq++
+	job := &queuedJob{priority: priority, seq: q.seq, ready: make(chan struct{})}
+	// Behind every job of the same or a higher priority
+	i := slices.IndexFunc(q.waiting, func(w *queuedJob) bool { return w.priority < priority })
+	if i < 0 {
+		i = len(q.waiting)
+	}
+	q.waiting = slices.Insert(q.waiting, i, job)
+	q.dispatch()
+	q.mu.Unlock()
+
+	select {
+	case <-job.ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		select {
+		case <-job.ready:
+			// Got the slot just as ctx ended; hand it on
+			q.running--
+			q.dispatch()
+		default:
+			q.waiting = slices.DeleteFunc(q.waiting, func(w *queuedJob) bool { return w == job })
+		}
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire or TryAcquire.
+func (q *JobQueue) Release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+	q.dispatch()
+}
+
+// dispatch hands free slots to the jobs at the head of the line.
+func (q *JobQueue) dispatch() {
+	for q.running < q.limit && len(q.waiting) > 0 {
+		job := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.running++
+		close(job.ready)
+	}
+}
+
+// Outranked reports whether a job of a higher priority than priority is
+// waiting for a slot.
+func (q *JobQueue) Outranked(priority int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting) > 0 && q.waiting[0].priority > priority
+}
+
+// Stats returns the slot limit and how many jobs are running and waiting.
+func (q *JobQueue) Stats() (limit, running, waiting int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.limit, q.running, len(q.waiting)
+}
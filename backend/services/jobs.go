@@ -0,0 +1,968 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/intelsk/backend/models"
+)
+
+// Job types run by the JobManager.
+const (
+	JobTypeProcess = "process"
+	JobTypeUpload  = "upload"
+	JobTypeIngest  = "ingest"
+	JobTypeReembed = "reembed"
+	JobTypeArchive = "archive"
+)
+
+// jobMemoryTTL is how long a finished job's event log stays in memory for
+// late or reconnecting SSE clients. Its jobs row and its job_log entries
+// are kept until jobs.retention_days.
+const jobMemoryTTL = time.Hour
+
+// maxJobResumes is how many restarts a job is resumed after. One that keeps
+// getting interrupted, e.g. by taking the server down with it, is then
+// marked failed.
+const maxJobResumes = 3
+
+// jobTimeLayout stores job timestamps in UTC with a fixed width, so the
+// text columns sort and compare chronologically.
+const jobTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// JobRunner does the work of one job type. It reports progress through emit
+// and should return soon after ctx is cancelled. A runner that returns nil
+// has emitted its own final "complete" event; for an error or a cancel the
+// manager emits an "error" or "cancelled" event itself.
+type JobRunner func(ctx context.Context, job *Job, emit func(ProgressEvent)) error
+
+// Job is a job submitted during this server run, with its event log.
+// Fields of the embedded models.Job are guarded by the manager's lock.
+type Job struct {
+	models.Job
+	m      *JobManager
+	events []ProgressEvent
+	logSeq int // entries written to job_log; guarded by the manager's lock
+	done   chan struct{}
+	cancel context.CancelFunc
+	slot   bool     // holds a JobQueue slot; only touched by the job's goroutine
+	keys   []string // units of work its params cover, see JobManager.KeyBy
+}
+
+// JobKey names a unit of work jobs are keyed by: a camera and date.
+func JobKey(cameraID, date string) string {
+	return cameraID + "/" + date
+}
+
+// jobLock is a key held by a running job, see Job.Lock, or reserved for
+// work outside jobs, see JobManager.Reserve; holder names it.
+type jobLock struct {
+	holder   string
+	released chan struct{}
+}
+
+// Lock reserves key (see JobKey) for the job until unlock is called,
+// waiting while another job of the workspace holds it, or until ctx is
+// done. Runners lock a camera and date while they extract and index it, so
+// two jobs never write its manifest and history at once.
+func (j *Job) Lock(ctx context.Context, key string) (unlock func(), err error) {
+	m := j.m
+	waited := false
+	for {
+		m.mu.Lock()
+		held, ok := m.locks[key]
+		if !ok {
+			lock := &jobLock{holder: "job " + j.ID, released: make(chan struct{})}
+			m.locks[key] = lock
+			m.mu.Unlock()
+			return m.unlocker(key, lock), nil
+		}
+		m.mu.Unlock()
+
+		if !waited {
+			waited = true
+			j.emit(ProgressEvent{
+				Stage:   "waiting",
+				Message: fmt.Sprintf("waiting for %s, which is working on %s", held.holder, key),
+			})
+		}
+		select {
+		case <-held.released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// unlocker returns the func releasing lock of key.
+func (m *JobManager) unlocker(key string, lock *jobLock) func() {
+	return func() {
+		m.mu.Lock()
+		delete(m.locks, key)
+		m.mu.Unlock()
+		close(lock.released)
+	}
+}
+
+// SetStatus moves a running job between "queued" and "running".
+func (j *Job) SetStatus(status string) {
+	j.m.mu.Lock()
+	j.Status = status
+	j.UpdatedAt = time.Now().UTC()
+	j.m.mu.Unlock()
+	j.m.persist(j)
+}
+
+// WaitForSlot holds the job "queued" until the manager's JobQueue has a free
+// slot, or until ctx is done. Runners call it before their heavy work, after
+// anything else they wait for; the slot is freed when the job ends. It also
+// waits while the disk check reports the data disk critically low.
+func (j *Job) WaitForSlot(ctx context.Context) error {
+	if err := j.waitForDisk(ctx); err != nil {
+		return err
+	}
+	q := j.m.queue
+	if q == nil || j.slot {
+		return nil
+	}
+	if q.TryAcquire() {
+		j.slot = true
+		return nil
+	}
+
+	limit, running, waiting := q.Stats()
+	j.SetStatus("queued")
+	j.emit(ProgressEvent{
+		Stage:   "queued",
+		Message: fmt.Sprintf("waiting for a free job slot (%d of %d in use, %d waiting)", running, limit, waiting),
+	})
+	if err := q.Acquire(ctx, j.Priority); err != nil {
+		return err
+	}
+	j.slot = true
+	j.SetStatus("running")
+	return nil
+}
+
+// YieldSlot hands the job's slot to a higher-priority job waiting for one
+// and waits for it to come back, or until ctx is done. Long runners call it
+// between units of work, so an urgent job doesn't wait behind a backfill.
+func (j *Job) YieldSlot(ctx context.Context) error {
+	if err := j.waitForDisk(ctx); err != nil {
+		return err
+	}
+	q := j.m.queue
+	if q == nil || !j.slot || !q.Outranked(j.Priority) {
+		return nil
+	}
+	q.Release()
+	j.slot = false
+	j.SetStatus("queued")
+	j.emit(ProgressEvent{
+		Stage:   "queued",
+		Message: "paused for a higher-priority job",
+	})
+	if err := q.Acquire(ctx, j.Priority); err != nil {
+		return err
+	}
+	j.slot = true
+	j.SetStatus("running")
+	return nil
+}
+
+// diskPollInterval is how often a job paused for disk space checks again.
+const diskPollInterval = 30 * time.Second
+
+// waitForDisk holds the job "queued" while the manager's disk check fails,
+// so a download or extraction waits for space instead of failing mid-write.
+func (j *Job) waitForDisk(ctx context.Context) error {
+	check := j.m.diskCheck
+	if check == nil {
+		return nil
+	}
+	err := check()
+	if err == nil {
+		return nil
+	}
+	j.SetStatus("queued")
+	j.emit(ProgressEvent{
+		Stage:   "queued",
+		Message: fmt.Sprintf("paused for disk space: %v", err),
+	})
+	ticker := time.NewTicker(diskPollInterval)
+	defer ticker.Stop()
+	for check() != nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	j.SetStatus("running")
+	return nil
+}
+
+func (j *Job) emit(ev ProgressEvent) {
+	j.m.mu.Lock()
+	j.events = append(j.events, ev)
+	j.logSeq++
+	seq := j.logSeq
+	j.m.mu.Unlock()
+	raw, _ := json.Marshal(ev)
+	j.m.writeLog(j.ID, seq, "event", ev.Message, string(raw))
+}
+
+// Logf writes a line to the server log and to the job's log, for detail that
+// doesn't belong in a progress event, like ffmpeg's error output.
+func (j *Job) Logf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("Job %s: %s", j.ID, msg)
+	j.m.mu.Lock()
+	j.logSeq++
+	seq := j.logSeq
+	j.m.mu.Unlock()
+	j.m.writeLog(j.ID, seq, "log", msg, "")
+}
+
+type jobContextKey struct{}
+
+// JobLogf is Job.Logf for the job running with ctx, or log.Printf outside of
+// a job (e.g. in the CLI).
+func JobLogf(ctx context.Context, format string, args ...any) {
+	if job, ok := ctx.Value(jobContextKey{}).(*Job); ok {
+		job.Logf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// JobManager runs the background jobs of a workspace and records them in the
+// jobs table, and their events and log lines in job_log, so they can be
+// listed, cancelled, retried and inspected after a restart. Jobs that were
+// still running when the server stopped are resumed by ResumeInterrupted.
+type JobManager struct {
+	db       *sql.DB
+	settings *SettingsService
+	queue    *JobQueue
+	// diskCheck, when set, fails while jobs should wait for disk space
+	diskCheck func() error
+
+	mu       sync.Mutex
+	runners  map[string]JobRunner
+	keyFuncs map[string]func(params json.RawMessage) []string
+	redacts  map[string]func(params json.RawMessage) json.RawMessage
+	jobs     map[string]*Job
+	locks    map[string]*jobLock
+	onFinish []func(job models.Job)
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewJobManager(db *sql.DB, settings *SettingsService) *JobManager {
+	return &JobManager{
+		db:       db,
+		settings: settings,
+		runners:  make(map[string]JobRunner),
+		keyFuncs: make(map[string]func(params json.RawMessage) []string),
+		redacts:  make(map[string]func(params json.RawMessage) json.RawMessage),
+		jobs:     make(map[string]*Job),
+		locks:    make(map[string]*jobLock),
+		stop:     make(chan struct{}),
+	}
+}
+
+// WithQueue makes the manager's jobs share q's slots, e.g. with the jobs of
+// every other workspace.
+func (m *JobManager) WithQueue(q *JobQueue) *JobManager {
+	m.queue = q
+	return m
+}
+
+// WithDiskCheck makes the manager's jobs wait in WaitForSlot and YieldSlot
+// while check returns an error, e.g. DiskMonitor.Critical.
+func (m *JobManager) WithDiskCheck(check func() error) *JobManager {
+	m.diskCheck = check
+	return m
+}
+
+// Register sets the runner for jobs of jobType.
+func (m *JobManager) Register(jobType string, run JobRunner) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runners[jobType] = run
+}
+
+// OnFinish registers fn to be called with every job that completes, fails
+// or is cancelled, once its final state is recorded, e.g. to notify of
+// failures. fn runs on the job's goroutine and should not block.
+func (m *JobManager) OnFinish(fn func(job models.Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onFinish = append(m.onFinish, fn)
+}
+
+// KeyBy sets how the params of jobs of jobType map to the units of work
+// they cover, e.g. one JobKey per camera and date. A job submitted while
+// one of its type that is running or queued covers all of its keys joins
+// that job instead of starting a duplicate.
+func (m *JobManager) KeyBy(jobType string, keys func(params json.RawMessage) []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keyFuncs[jobType] = keys
+}
+
+// RedactBy sets how the params of jobs of jobType are shown outside the
+// manager, by Get, List and OnFinish listeners, e.g. without a URL's
+// credentials. Runners, resumes and retries still get them as submitted.
+func (m *JobManager) RedactBy(jobType string, redact func(params json.RawMessage) json.RawMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.redacts[jobType] = redact
+}
+
+// redacted returns job with its params as RedactBy says to show them.
+func (m *JobManager) redacted(job models.Job) models.Job {
+	m.mu.Lock()
+	redact := m.redacts[job.Type]
+	m.mu.Unlock()
+	if redact != nil {
+		job.Params = redact(job.Params)
+	}
+	return job
+}
+
+// Submit records a job of jobType with params (stored as JSON, and handed
+// back to the runner through Job.Params) and starts it in the background
+// with the type's default priority. If a running job already covers params
+// (see KeyBy), that job is returned instead.
+func (m *JobManager) Submit(jobType string, params any) (*Job, error) {
+	job, _, err := m.submit(jobType, params, jobPriority[jobType], "")
+	return job, err
+}
+
+// SubmitWithPriority is Submit for a job that waits for a slot with
+// priority instead of its type's default. existing reports that a running
+// job already covered params and was returned instead.
+func (m *JobManager) SubmitWithPriority(jobType string, params any, priority int) (job *Job, existing bool, err error) {
+	if priority < MinJobPriority || priority > MaxJobPriority {
+		return nil, false, newError(ErrInvalidInput, "priority must be between %d and %d", MinJobPriority, MaxJobPriority)
+	}
+	return m.submit(jobType, params, priority, "")
+}
+
+func (m *JobManager) submit(jobType string, params any, priority int, retryOf string) (*Job, bool, error) {
+	m.mu.Lock()
+	run, ok := m.runners[jobType]
+	keyFunc := m.keyFuncs[jobType]
+	m.mu.Unlock()
+	if !ok {
+		return nil, false, newError(ErrInvalidInput, "unknown job type %q", jobType)
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, false, fmt.Errorf("encoding job params: %w", err)
+	}
+	var keys []string
+	if keyFunc != nil {
+		keys = keyFunc(raw)
+	}
+
+	now := time.Now().UTC()
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		Job: models.Job{
+			ID:        uuid.New().String(),
+			Type:      jobType,
+			Params:    raw,
+			Status:    "running",
+			RetryOf:   retryOf,
+			Priority:  priority,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		m:      m,
+		done:   make(chan struct{}),
+		cancel: cancel,
+		keys:   keys,
+	}
+
+	// Checked and added under one lock, so two identical requests arriving
+	// together can't both start
+	m.mu.Lock()
+	if existing := m.covering(jobType, keys); existing != nil {
+		m.mu.Unlock()
+		cancel()
+		log.Printf("Job %s already covers the %s job submitted; joining it", existing.ID, jobType)
+		return existing, true, nil
+	}
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+
+	if _, err := m.db.Exec(`INSERT INTO jobs (id, type, params, status, retry_of, priority, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.Type, string(raw), job.Status, retryOf, priority,
+		now.Format(jobTimeLayout), now.Format(jobTimeLayout)); err != nil {
+		cancel()
+		m.mu.Lock()
+		delete(m.jobs, job.ID)
+		m.mu.Unlock()
+		return nil, false, fmt.Errorf("recording job: %w", err)
+	}
+
+	go m.run(ctx, job, run)
+	return job, false, nil
+}
+
+// covering returns a running or queued job of jobType whose keys include
+// all of keys, or nil. Jobs without keys are never covered. The caller
+// holds m.mu.
+func (m *JobManager) covering(jobType string, keys []string) *Job {
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, job := range m.jobs {
+		if job.Type != jobType || (job.Status != "running" && job.Status != "queued") {
+			continue
+		}
+		if !slices.ContainsFunc(keys, func(k string) bool { return !slices.Contains(job.keys, k) }) {
+			return job
+		}
+	}
+	return nil
+}
+
+// ResumeInterrupted picks up the jobs the previous server run left queued or
+// running; call it once the runners are registered. With
+// jobs.resume_interrupted on, each starts again under the same ID with its
+// event log restored. Runners skip the work that is already done (processed
+// dates, indexed frames and, for a resumed job, downloaded recordings), so a
+// long backfill carries on where it stopped. Other jobs, and ones resumed
+// maxJobResumes times already, are marked failed and can be retried.
+func (m *JobManager) ResumeInterrupted() {
+	rows, err := m.db.Query("SELECT " + jobColumns + " FROM jobs WHERE status IN ('queued', 'running') ORDER BY priority DESC, created_at")
+	if err != nil {
+		log.Printf("querying interrupted jobs: %v", err)
+		return
+	}
+	var interrupted []models.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			log.Printf("scanning job row: %v", err)
+			continue
+		}
+		interrupted = append(interrupted, job)
+	}
+	rows.Close()
+
+	resume := m.settings.GetBool("jobs.resume_interrupted")
+	for _, info := range interrupted {
+		m.mu.Lock()
+		run, ok := m.runners[info.Type]
+		m.mu.Unlock()
+		switch {
+		case !resume || !ok:
+			m.fail(info.ID, "interrupted by a server restart")
+		case info.Resumes >= maxJobResumes:
+			m.fail(info.ID, fmt.Sprintf("interrupted by a server restart after %d resumes", info.Resumes))
+		default:
+			if err := m.resume(info, run); err != nil {
+				log.Printf("resuming job %s: %v", info.ID, err)
+				m.fail(info.ID, "interrupted by a server restart")
+			}
+		}
+	}
+}
+
+// fail marks a recorded job that is not running in this server run failed.
+func (m *JobManager) fail(id, errMsg string) {
+	now := time.Now().UTC().Format(jobTimeLayout)
+	if _, err := m.db.Exec("UPDATE jobs SET status = 'failed', error = ?, updated_at = ?, finished_at = ? WHERE id = ?",
+		errMsg, now, now, id); err != nil {
+		log.Printf("marking job %s failed: %v", id, err)
+		return
+	}
+	log.Printf("Job %s: %s", id, errMsg)
+}
+
+// resume starts an interrupted job again under its own ID.
+func (m *JobManager) resume(info models.Job, run JobRunner) error {
+	entries, err := m.Log(info.ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	info.Status = "running"
+	info.Resumes++
+	info.UpdatedAt = now
+	if _, err := m.db.Exec("UPDATE jobs SET status = ?, resumes = ?, updated_at = ? WHERE id = ?",
+		info.Status, info.Resumes, now.Format(jobTimeLayout), info.ID); err != nil {
+		return fmt.Errorf("recording job: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		Job:    info,
+		m:      m,
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	m.mu.Lock()
+	if keyFunc := m.keyFuncs[info.Type]; keyFunc != nil {
+		job.keys = keyFunc(info.Params)
+	}
+	m.mu.Unlock()
+	for _, e := range entries {
+		var ev ProgressEvent
+		if e.Kind == "event" && json.Unmarshal(e.Event, &ev) == nil {
+			job.events = append(job.events, ev)
+		}
+		job.logSeq = e.Seq
+	}
+
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+
+	log.Printf("Resuming %s job %s after a server restart", job.Type, job.ID)
+	job.emit(ProgressEvent{Stage: "resumed", Message: "resumed after a server restart"})
+	go m.run(ctx, job, run)
+	return nil
+}
+
+func (m *JobManager) run(ctx context.Context, job *Job, run JobRunner) {
+	defer close(job.done)
+
+	err := m.waitReservedAll(ctx, job)
+	if err == nil {
+		err = run(context.WithValue(ctx, jobContextKey{}, job), job, job.emit)
+	}
+	if job.slot {
+		m.queue.Release()
+	}
+	status, errMsg := "complete", ""
+	switch {
+	case ctx.Err() != nil:
+		status = "cancelled"
+		job.emit(ProgressEvent{Stage: "cancelled", Message: job.Type + " cancelled"})
+	case err != nil:
+		status, errMsg = "failed", err.Error()
+		job.emit(ProgressEvent{Stage: "error", Message: errMsg})
+	}
+
+	now := time.Now().UTC()
+	m.mu.Lock()
+	job.Status = status
+	job.Error = errMsg
+	job.UpdatedAt = now
+	job.FinishedAt = &now
+	m.mu.Unlock()
+	job.cancel()
+	m.persist(job)
+
+	info := m.redacted(m.snapshot(job))
+	m.mu.Lock()
+	listeners := slices.Clone(m.onFinish)
+	m.mu.Unlock()
+	for _, fn := range listeners {
+		fn(info)
+	}
+}
+
+// writeLog appends an entry to a job's log.
+func (m *JobManager) writeLog(jobID string, seq int, kind, message, event string) {
+	if _, err := m.db.Exec("INSERT INTO job_log (job_id, seq, time, kind, message, event) VALUES (?, ?, ?, ?, ?, ?)",
+		jobID, seq, time.Now().UTC().Format(jobTimeLayout), kind, message, event); err != nil {
+		log.Printf("writing log of job %s: %v", jobID, err)
+	}
+}
+
+// persist writes a job's current state to its row.
+func (m *JobManager) persist(job *Job) {
+	info := m.snapshot(job)
+	var finishedAt any
+	if info.FinishedAt != nil {
+		finishedAt = info.FinishedAt.Format(jobTimeLayout)
+	}
+	if _, err := m.db.Exec(`UPDATE jobs SET status = ?, error = ?, events = ?, last_event = ?,
+		updated_at = ?, finished_at = ? WHERE id = ?`,
+		info.Status, info.Error, info.Events, string(info.LastEvent),
+		info.UpdatedAt.Format(jobTimeLayout), finishedAt, info.ID); err != nil {
+		log.Printf("recording job %s: %v", info.ID, err)
+	}
+}
+
+// snapshot copies a live job's state, with its event count and last event.
+func (m *JobManager) snapshot(job *Job) models.Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info := job.Job
+	info.Events = len(job.events)
+	if n := len(job.events); n > 0 {
+		info.LastEvent, _ = json.Marshal(job.events[n-1])
+	}
+	return info
+}
+
+const jobColumns = `id, type, params, status, error, retry_of, resumes, priority, events, last_event, created_at, updated_at, finished_at`
+
+func scanJob(row interface{ Scan(...any) error }) (models.Job, error) {
+	var job models.Job
+	var params, lastEvent, createdAt, updatedAt string
+	var finishedAt sql.NullString
+	if err := row.Scan(&job.ID, &job.Type, &params, &job.Status, &job.Error, &job.RetryOf,
+		&job.Resumes, &job.Priority, &job.Events, &lastEvent, &createdAt, &updatedAt, &finishedAt); err != nil {
+		return job, err
+	}
+	job.Params = json.RawMessage(params)
+	if lastEvent != "" {
+		job.LastEvent = json.RawMessage(lastEvent)
+	}
+	job.CreatedAt, _ = time.Parse(jobTimeLayout, createdAt)
+	job.UpdatedAt, _ = time.Parse(jobTimeLayout, updatedAt)
+	if finishedAt.Valid {
+		t, _ := time.Parse(jobTimeLayout, finishedAt.String)
+		job.FinishedAt = &t
+	}
+	return job, nil
+}
+
+// List returns the recorded jobs, newest first, optionally only those of
+// jobType. Jobs of this run report their live state.
+func (m *JobManager) List(jobType string) ([]models.Job, error) {
+	query := "SELECT " + jobColumns + " FROM jobs"
+	var args []any
+	if jobType != "" {
+		query += " WHERE type = ?"
+		args = append(args, jobType)
+	}
+	rows, err := m.db.Query(query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning job row: %w", err)
+		}
+		if live := m.live(job.ID); live != nil {
+			job = m.snapshot(live)
+		}
+		jobs = append(jobs, m.redacted(job))
+	}
+	return jobs, rows.Err()
+}
+
+// Get returns a single job.
+func (m *JobManager) Get(id string) (models.Job, error) {
+	job, err := m.get(id)
+	if err != nil {
+		return job, err
+	}
+	return m.redacted(job), nil
+}
+
+// get is Get with the params as submitted.
+func (m *JobManager) get(id string) (models.Job, error) {
+	if live := m.live(id); live != nil {
+		return m.snapshot(live), nil
+	}
+	job, err := scanJob(m.db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return job, newError(ErrJobNotFound, "job %s not found", id)
+	}
+	if err != nil {
+		return job, fmt.Errorf("querying job %s: %w", id, err)
+	}
+	return job, nil
+}
+
+func (m *JobManager) live(id string) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jobs[id]
+}
+
+// Cancel stops a running or queued job. It ends with status "cancelled" once
+// its runner returns.
+func (m *JobManager) Cancel(id string) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if ok && (job.Status == "running" || job.Status == "queued") {
+		job.cancel()
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
+
+	info, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	return newError(ErrJobFinished, "job already %s", info.Status)
+}
+
+// Retry starts a new job with the type, params and priority of a finished
+// one.
+func (m *JobManager) Retry(id string) (*Job, error) {
+	info, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Status == "running" || info.Status == "queued" {
+		return nil, newError(ErrBusy, "job %s is still %s", id, info.Status)
+	}
+	job, _, err := m.submit(info.Type, info.Params, info.Priority, id)
+	return job, err
+}
+
+// Log returns a job's log: its progress events and log lines, oldest first.
+// It survives restarts until the job is pruned.
+func (m *JobManager) Log(id string) ([]models.JobLogEntry, error) {
+	if _, err := m.Get(id); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.Query("SELECT seq, time, kind, message, event FROM job_log WHERE job_id = ? ORDER BY seq", id)
+	if err != nil {
+		return nil, fmt.Errorf("querying log of job %s: %w", id, err)
+	}
+	defer rows.Close()
+
+	entries := []models.JobLogEntry{}
+	for rows.Next() {
+		var e models.JobLogEntry
+		var at, event string
+		if err := rows.Scan(&e.Seq, &at, &e.Kind, &e.Message, &event); err != nil {
+			return nil, fmt.Errorf("scanning job log row: %w", err)
+		}
+		e.Time, _ = time.Parse(jobTimeLayout, at)
+		if event != "" {
+			e.Event = json.RawMessage(event)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// EventLog returns a job's progress events, the lock they are appended
+// under and a channel closed once the job has finished, for streaming. A job
+// no longer held in memory yields the events recorded in its log, or its
+// last event for a job from before logs were kept.
+func (m *JobManager) EventLog(id string) (*sync.Mutex, *[]ProgressEvent, <-chan struct{}, error) {
+	if job := m.live(id); job != nil {
+		return &m.mu, &job.events, job.done, nil
+	}
+	info, err := m.Get(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	entries, err := m.Log(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var events []ProgressEvent
+	for _, e := range entries {
+		var ev ProgressEvent
+		if e.Kind == "event" && json.Unmarshal(e.Event, &ev) == nil {
+			events = append(events, ev)
+		}
+	}
+	var ev ProgressEvent
+	if len(events) == 0 && info.LastEvent != nil && json.Unmarshal(info.LastEvent, &ev) == nil {
+		events = append(events, ev)
+	}
+	done := make(chan struct{})
+	close(done)
+	return &sync.Mutex{}, &events, done, nil
+}
+
+// Working returns what is working on key: "job " and the ID of a running
+// or queued job covering it (see KeyBy; one for some hours of a date covers
+// the date too) or whatever holds its lock, or "" if nothing is.
+func (m *JobManager) Working(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.working(key)
+}
+
+// Reserve takes key's lock for work done outside a job, such as deleting a
+// date, for holder (e.g. "deletion of front_door/2026-02-18"). Jobs locking
+// the key wait until unlock is called. If a job or another reservation is
+// working on the key, it fails with ErrBusy instead.
+func (m *JobManager) Reserve(key, holder string) (unlock func(), err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if busy := m.working(key); busy != "" {
+		return nil, newError(ErrBusy, "%s is working on %s; try again once it finishes or is cancelled", busy, key)
+	}
+	lock := &jobLock{holder: holder, released: make(chan struct{})}
+	m.locks[key] = lock
+	return m.unlocker(key, lock), nil
+}
+
+// reservedAll is the key of the lock ReserveAll takes.
+const reservedAll = ""
+
+// ReserveAll takes the whole workspace for work done outside a job that
+// may touch any of its data, such as renaming a camera, for holder. It
+// fails with ErrBusy while jobs are running or queued or a key is locked.
+// Jobs submitted meanwhile wait to start until unlock is called, and other
+// reservations fail.
+func (m *JobManager) ReserveAll(holder string) (unlock func(), err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.jobs {
+		if job.Status == "running" || job.Status == "queued" {
+			return nil, newError(ErrBusy, "job %s is running or queued; try again once it finishes or is cancelled", job.ID)
+		}
+	}
+	for key, lock := range m.locks {
+		return nil, newError(ErrBusy, "%s is working on %s; try again once it finishes", lock.holder, key)
+	}
+	lock := &jobLock{holder: holder, released: make(chan struct{})}
+	m.locks[reservedAll] = lock
+	return m.unlocker(reservedAll, lock), nil
+}
+
+// waitReservedAll holds job back while ReserveAll's lock is taken, or
+// until ctx is done.
+func (m *JobManager) waitReservedAll(ctx context.Context, job *Job) error {
+	waited := false
+	for {
+		m.mu.Lock()
+		held, ok := m.locks[reservedAll]
+		m.mu.Unlock()
+		if !ok {
+			return nil
+		}
+		if !waited {
+			waited = true
+			job.emit(ProgressEvent{Stage: "waiting", Message: fmt.Sprintf("waiting for %s", held.holder)})
+		}
+		select {
+		case <-held.released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// working is Working with m.mu held.
+func (m *JobManager) working(key string) string {
+	if lock, ok := m.locks[key]; ok {
+		return lock.holder
+	}
+	if lock, ok := m.locks[reservedAll]; ok {
+		return lock.holder
+	}
+	for _, job := range m.jobs {
+		if job.Status != "running" && job.Status != "queued" {
+			continue
+		}
+		if slices.ContainsFunc(job.keys, func(k string) bool { return k == key || strings.HasPrefix(k, key+" ") }) {
+			return "job " + job.ID
+		}
+	}
+	return ""
+}
+
+// ActiveCount returns the number of jobs of jobType (all types if empty)
+// running or queued.
+func (m *JobManager) ActiveCount(jobType string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, job := range m.jobs {
+		if (jobType == "" || job.Type == jobType) && (job.Status == "running" || job.Status == "queued") {
+			n++
+		}
+	}
+	return n
+}
+
+// ActiveCounts returns the number of jobs running or queued by job type.
+func (m *JobManager) ActiveCounts() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[string]int)
+	for _, job := range m.jobs {
+		if job.Status == "running" || job.Status == "queued" {
+			counts[job.Type]++
+		}
+	}
+	return counts
+}
+
+// TrackedCount returns the number of jobs of jobType (all types if empty)
+// held in memory, running or finished within jobMemoryTTL.
+func (m *JobManager) TrackedCount(jobType string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, job := range m.jobs {
+		if jobType == "" || job.Type == jobType {
+			n++
+		}
+	}
+	return n
+}
+
+// StartPruning periodically drops finished jobs from memory and deletes the
+// rows and logs of jobs finished more than jobs.retention_days ago, until
+// Close.
+func (m *JobManager) StartPruning() {
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for {
+			m.prune()
+			select {
+			case <-ticker.C:
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the pruning loop. Running jobs are not affected.
+func (m *JobManager) Close() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *JobManager) prune() {
+	now := time.Now().UTC()
+	m.mu.Lock()
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > jobMemoryTTL {
+			delete(m.jobs, id)
+		}
+	}
+	m.mu.Unlock()
+
+	cutoff := now.AddDate(0, 0, -m.settings.GetInt("jobs.retention_days"))
+	res, err := m.db.Exec("DELETE FROM jobs WHERE finished_at IS NOT NULL AND finished_at < ?",
+		cutoff.Format(jobTimeLayout))
+	if err != nil {
+		log.Printf("pruning jobs: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Pruned %d job(s) finished before %s", n, cutoff.Format(time.DateOnly))
+	}
+	if _, err := m.db.Exec("DELETE FROM job_log WHERE job_id NOT IN (SELECT id FROM jobs)"); err != nil {
+		log.Printf("pruning job logs: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ffmpeg's HLS muxer can write fMP4 but not Low-Latency HLS partial
+// segments. For LL-HLS, ffmpeg cuts the stream into short fMP4 parts
+// (part_N.m4s, each starting on a keyframe) listed in parts.m3u8, and
+// llhlsPackager groups every partsPerSegment consecutive parts into a full
+// segment (seg_N.m4s, the parts' fragments concatenated) and writes
+// index.m3u8 with EXT-X-PART tags for the most recent segments. Clients
+// that don't understand LL-HLS simply play the full segments.
+
+const (
+	llhlsPartsPlaylist = "parts.m3u8"
+	// llhlsPartSegments is how many of the newest segments keep their
+	// EXT-X-PART tags; the spec asks for at least the last three target
+	// durations.
+	llhlsPartSegments = 3
+)
+
+type llhlsPart struct {
+	index    int
+	duration float64
+	file     string
+}
+
+type llhlsPackager struct {
+	dir             string
+	partSec         float64
+	partsPerSegment int
+	listSize        int // full segments index.m3u8 lists
+
+	written  map[int]float64 // full segments on disk → duration
+	lastMod  time.Time
+	lastSize int64
+}
+
+func newLLHLSPackager(dir string, segmentSec, partSec float64, listSize int) *llhlsPackager {
+	n := int(math.Round(segmentSec / partSec))
+	if n < 1 {
+		n = 1
+	}
+	return &llhlsPackager{dir: dir, partSec: partSec, partsPerSegment: n, listSize: listSize, written: make(map[int]float64)}
+}
+
+// run rewrites index.m3u8 whenever ffmpeg updates parts.m3u8, until done
+// is closed.
+func (p *llhlsPackager) run(done <-chan struct{}) {
+	interval := time.Duration(p.partSec * float64(time.Second) / 4)
+	if interval < 50*time.Millisecond {
+		interval = 50 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			// Errors are transient (ffmpeg mid-write); the next tick retries
+			p.update()
+		}
+	}
+}
+
+// update regenerates the segments and index.m3u8 if parts.m3u8 changed.
+func (p *llhlsPackager) update() error {
+	info, err := os.Stat(filepath.Join(p.dir, llhlsPartsPlaylist))
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(p.lastMod) && info.Size() == p.lastSize {
+		return nil
+	}
+	parts, err := p.readParts()
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+
+	// Group parts by segment; a segment is complete once its last part is
+	// listed and none of its parts were already deleted by ffmpeg.
+	bySeg := make(map[int][]llhlsPart)
+	var segs []int
+	for _, pt := range parts {
+		n := pt.index / p.partsPerSegment
+		if _, ok := bySeg[n]; !ok {
+			segs = append(segs, n)
+		}
+		bySeg[n] = append(bySeg[n], pt)
+	}
+	complete := func(n int) bool {
+		ps := bySeg[n]
+		return len(ps) == p.partsPerSegment && ps[0].index == n*p.partsPerSegment
+	}
+
+	for _, n := range segs {
+		if _, ok := p.written[n]; ok || !complete(n) {
+			continue
+		}
+		dur, err := p.writeSegment(n, bySeg[n])
+		if err != nil {
+			return err
+		}
+		p.written[n] = dur
+	}
+
+	// The newest incomplete segment is announced through its parts only
+	listed := make([]int, 0, len(p.written))
+	for n := range p.written {
+		listed = append(listed, n)
+	}
+	sort.Ints(listed)
+	// Media sequence numbers must be consecutive; a segment missed while
+	// lagging behind ffmpeg ends the list
+	start := len(listed) - 1
+	for start > 0 && listed[start-1] == listed[start]-1 && len(listed)-start < p.listSize {
+		start--
+	}
+	if len(listed) > 0 {
+		listed = listed[start:]
+	}
+	last := segs[len(segs)-1]
+	var pending []llhlsPart
+	if !complete(last) && bySeg[last][0].index == last*p.partsPerSegment {
+		pending = bySeg[last]
+	}
+	if len(listed) == 0 && len(pending) == 0 {
+		return nil
+	}
+
+	if err := p.writePlaylist(listed, bySeg, pending); err != nil {
+		return err
+	}
+	p.lastMod, p.lastSize = info.ModTime(), info.Size()
+
+	// Drop segments that left the playlist (one extra kept for slow readers)
+	if len(listed) > 0 {
+		for n := range p.written {
+			if n < listed[0]-1 {
+				os.Remove(filepath.Join(p.dir, fmt.Sprintf("seg_%d.m4s", n)))
+				delete(p.written, n)
+			}
+		}
+	}
+	return nil
+}
+
+// readParts parses ffmpeg's playlist of parts. Part numbers come from the
+// file names, which ffmpeg numbers from 0 (-start_number 0).
+func (p *llhlsPackager) readParts() ([]llhlsPart, error) {
+	f, err := os.Open(filepath.Join(p.dir, llhlsPartsPlaylist))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var parts []llhlsPart
+	duration := -1.0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			if duration, err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, fmt.Errorf("parsing %q: %w", line, err)
+			}
+		case line != "" && !strings.HasPrefix(line, "#") && duration >= 0:
+			name := filepath.Base(line)
+			num := strings.TrimSuffix(strings.TrimPrefix(name, "part_"), ".m4s")
+			idx, err := strconv.Atoi(num)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected part name %q", name)
+			}
+			parts = append(parts, llhlsPart{index: idx, duration: duration, file: name})
+			duration = -1
+		}
+	}
+	return parts, sc.Err()
+}
+
+// writeSegment concatenates a segment's parts into seg_N.m4s. fMP4 parts
+// are self-contained moof+mdat fragments, so the result is a valid segment
+// for the same init.mp4.
+func (p *llhlsPackager) writeSegment(n int, parts []llhlsPart) (float64, error) {
+	var buf []byte
+	var dur float64
+	for _, pt := range parts {
+		data, err := os.ReadFile(filepath.Join(p.dir, pt.file))
+		if err != nil {
+			return 0, err
+		}
+		buf = append(buf, data...)
+		dur += pt.duration
+	}
+	path := filepath.Join(p.dir, fmt.Sprintf("seg_%d.m4s", n))
+	if err := os.WriteFile(path+".tmp", buf, 0o644); err != nil {
+		return 0, err
+	}
+	return dur, os.Rename(path+".tmp", path)
+}
+
+func (p *llhlsPackager) writePlaylist(listed []int, bySeg map[int][]llhlsPart, pending []llhlsPart) error {
+	target := p.partSec * float64(p.partsPerSegment)
+	for _, n := range listed {
+		target = math.Max(target, p.written[n])
+	}
+	partTarget := p.partSec
+	for _, ps := range bySeg {
+		for _, pt := range ps {
+			partTarget = math.Max(partTarget, pt.duration)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:9\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target)))
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=%.3f\n", 3*partTarget)
+	var seq int
+	if len(listed) > 0 {
+		seq = listed[0]
+	} else {
+		seq = pending[0].index / p.partsPerSegment
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", seq)
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	b.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+
+	writeParts := func(parts []llhlsPart) {
+		for _, pt := range parts {
+			fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\",INDEPENDENT=YES\n", pt.duration, pt.file)
+		}
+	}
+	for i, n := range listed {
+		if i >= len(listed)-llhlsPartSegments {
+			writeParts(bySeg[n])
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\nseg_%d.m4s\n", p.written[n], n)
+	}
+	writeParts(pending)
+
+	path := filepath.Join(p.dir, "index.m3u8")
+	if err := os.WriteFile(path+".tmp", []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
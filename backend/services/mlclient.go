@@ -2,37 +2,297 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/intelsk/backend/config"
 	"github.com/intelsk/backend/models"
 )
 
 type MLClient struct {
-	baseURL    string
-	httpClient *http.Client
+	mu sync.RWMutex
+	// instances are the sidecars requests go to; the first, the primary,
+	// serves every request, the others only image encoding
+	instances    []*mlInstance
+	next         atomic.Uint32 // turn of the instances among equals
+	httpClient   *http.Client
+	readyTimeout time.Duration
+	// sem bounds the requests in flight; nil means no limit
+	sem *mlSemaphore
+	// retries is how often a transient failure of an idempotent request is
+	// retried
+	retries int
+	// sendImages uploads image bytes rather than paths
+	sendImages bool
+	// batch tunes the size of image encoding batches
+	batch adaptiveBatch
+}
+
+// mlInstance is one sidecar, with the circuit breaker that fails requests
+// fast while it is down.
+type mlInstance struct {
+	baseURL  string
+	breaker  *circuitBreaker
+	inFlight atomic.Int32
+}
+
+// MLInstanceStatus is the state of one sidecar instance, for the health
+// check.
+type MLInstanceStatus struct {
+	URL      string `json:"url"`
+	Circuit  string `json:"circuit"`
+	InFlight int    `json:"in_flight"`
+}
+
+// MLConfig is how the backend reaches the ML sidecar. The server takes it
+// from the ml.* settings so the sidecar can be moved without a restart.
+type MLConfig struct {
+	URL string
+	// ExtraURLs are further sidecar instances that share image encoding
+	// with the one at URL, which alone serves text encoding, search, face
+	// detection and model management.
+	ExtraURLs []string
+	// Timeout bounds a single sidecar request; CPU CLIP inference is slow.
+	Timeout time.Duration
+	// ReadyTimeout is how long jobs wait for the sidecar to come up.
+	ReadyTimeout time.Duration
+	// MaxConcurrent bounds the image encoding, face detection, text
+	// encoding and search requests in flight at once per instance, across
+	// all jobs, searches and workspaces; 0 is no limit. Searches wait
+	// ahead of indexing requests.
+	MaxConcurrent int
+	// Retries is how often image encoding and face detection requests are
+	// retried after a transient failure, with exponential backoff.
+	Retries int
+	// BreakerThreshold consecutive failed requests make every request fail
+	// at once for BreakerCooldown; 0 never does.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// SendImages uploads the image bytes, base64-encoded, with image
+	// encoding and face detection requests instead of their file paths, so
+	// the sidecar need not share the backend's filesystem.
+	SendImages bool
+	// AdaptiveBatch tunes the image encoding batch size between BatchMin
+	// and BatchMax so a batch takes about BatchTarget, instead of the fixed
+	// clip.batch_size.
+	AdaptiveBatch      bool
+	BatchMin, BatchMax int
+	BatchTarget        time.Duration
+}
+
+// MLConfigFromSettings reads the sidecar connection from the ml.* settings,
+// falling back to mlservice.url when ml.url is empty.
+func MLConfigFromSettings(s *SettingsService, cfg *config.AppConfig) MLConfig {
+	url := s.Get("ml.url")
+	if url == "" {
+		url = cfg.MLService.URL
+	}
+	var extra []string
+	for _, u := range strings.Split(s.Get("ml.extra_urls"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			extra = append(extra, u)
+		}
+	}
+	return MLConfig{
+		URL:              url,
+		ExtraURLs:        extra,
+		Timeout:          time.Duration(s.GetInt("ml.timeout_sec")) * time.Second,
+		ReadyTimeout:     time.Duration(s.GetInt("ml.ready_timeout_sec")) * time.Second,
+		MaxConcurrent:    s.GetInt("ml.max_concurrent"),
+		Retries:          s.GetInt("ml.retries"),
+		BreakerThreshold: s.GetInt("ml.breaker_threshold"),
+		BreakerCooldown:  time.Duration(s.GetInt("ml.breaker_cooldown_sec")) * time.Second,
+		SendImages:       s.GetBool("ml.send_images"),
+		AdaptiveBatch:    s.GetBool("clip.batch_adaptive"),
+		BatchMin:         s.GetInt("clip.batch_size_min"),
+		BatchMax:         s.GetInt("clip.batch_size_max"),
+		BatchTarget:      time.Duration(s.GetFloat64("clip.batch_target_sec") * float64(time.Second)),
+	}
 }
 
 func NewMLClient(baseURL string) *MLClient {
-	return &MLClient{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 120 * time.Second, // CPU CLIP inference is slow
-		},
+	c := &MLClient{}
+	c.Configure(MLConfig{URL: baseURL, Timeout: 120 * time.Second, ReadyTimeout: 120 * time.Second,
+		Retries: 3, BreakerThreshold: 5, BreakerCooldown: 30 * time.Second})
+	return c
+}
+
+// Configure points the client at (possibly new) sidecars. Requests already
+// in flight finish against the old ones, and under the old concurrency
+// limit. An instance keeps its circuit state unless the breaker settings
+// change; a new one starts with a closed circuit.
+func (c *MLClient) Configure(cfg MLConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := make(map[string]*mlInstance)
+	for _, inst := range c.instances {
+		old[inst.baseURL] = inst
 	}
+	var instances []*mlInstance
+	seen := make(map[string]bool)
+	for _, u := range append([]string{cfg.URL}, cfg.ExtraURLs...) {
+		u = strings.TrimRight(u, "/")
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		inst := old[u]
+		if inst == nil || cfg.BreakerThreshold != inst.breaker.threshold || cfg.BreakerCooldown != inst.breaker.cooldown {
+			inst = &mlInstance{baseURL: u, breaker: &circuitBreaker{threshold: cfg.BreakerThreshold, cooldown: cfg.BreakerCooldown}}
+		}
+		instances = append(instances, inst)
+	}
+
+	c.retries = cfg.Retries
+	c.sendImages = cfg.SendImages
+	c.httpClient = &http.Client{Timeout: cfg.Timeout}
+	c.readyTimeout = cfg.ReadyTimeout
+	limit := cfg.MaxConcurrent * len(instances)
+	if c.sem == nil || limit != c.sem.limit {
+		c.sem = nil
+		if limit > 0 {
+			c.sem = newMLSemaphore(limit)
+		}
+	}
+	c.instances = instances
+	c.batch.configure(cfg.AdaptiveBatch, cfg.BatchMin, cfg.BatchMax, cfg.BatchTarget)
 }
 
+// BatchSize returns the image encoding batch size tuned from the latency
+// of the batches so far, or fallback with clip.batch_adaptive off.
+func (c *MLClient) BatchSize(fallback int) int {
+	return c.batch.get(fallback)
+}
+
+// TunedBatchSize returns the batch size tuning has settled on, or 0 when
+// it is off or hasn't started, for the health check.
+func (c *MLClient) TunedBatchSize() int {
+	return c.batch.current()
+}
+
+// Parallelism is how many image batches the sidecar instances serve at
+// once: one per instance.
+func (c *MLClient) Parallelism() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.instances)
+}
+
+// Instances returns the state of every sidecar instance, the primary first.
+func (c *MLClient) Instances() []MLInstanceStatus {
+	c.mu.RLock()
+	instances := c.instances
+	c.mu.RUnlock()
+	statuses := make([]MLInstanceStatus, len(instances))
+	for i, inst := range instances {
+		statuses[i] = MLInstanceStatus{URL: inst.baseURL, Circuit: inst.breaker.state(), InFlight: int(inst.inFlight.Load())}
+	}
+	return statuses
+}
+
+// pick returns the instance for a request: the primary one, or with spread
+// the one with the fewest requests in flight of those whose circuit lets
+// requests through, taking turns among equals.
+func (c *MLClient) pick(spread bool) (*mlInstance, error) {
+	c.mu.RLock()
+	instances := c.instances
+	c.mu.RUnlock()
+	if !spread || len(instances) == 1 {
+		if err := instances[0].breaker.allow(); err != nil {
+			return nil, err
+		}
+		return instances[0], nil
+	}
+
+	start := int(c.next.Add(1))
+	order := make([]*mlInstance, len(instances))
+	for i := range instances {
+		order[i] = instances[(start+i)%len(instances)]
+	}
+	sort.SliceStable(order, func(a, b int) bool { return order[a].inFlight.Load() < order[b].inFlight.Load() })
+	var lastErr error
+	for _, inst := range order {
+		if lastErr = inst.breaker.allow(); lastErr == nil {
+			return inst, nil
+		}
+	}
+	return nil, newError(ErrSidecarUnavailable, "all %d ML sidecars are down (last: %v)", len(order), lastErr)
+}
+
+// acquire waits for a free request slot, or until ctx is done, and returns
+// the func that frees it. Interactive requests go ahead of waiting
+// indexing ones.
+func (c *MLClient) acquire(ctx context.Context, interactive bool) (func(), error) {
+	c.mu.RLock()
+	sem := c.sem
+	c.mu.RUnlock()
+	if sem == nil {
+		return func() {}, nil
+	}
+	return sem.acquire(ctx, interactive)
+}
+
+// Requests returns the state of the request limit, or nil without one.
+func (c *MLClient) Requests() *MLRequestStatus {
+	c.mu.RLock()
+	sem := c.sem
+	c.mu.RUnlock()
+	if sem == nil {
+		return nil
+	}
+	status := sem.status()
+	return &status
+}
+
+// BaseURL returns the URL of the primary sidecar.
+func (c *MLClient) BaseURL() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.instances[0].baseURL
+}
+
+// ReadyTimeout returns how long jobs should wait for the sidecar.
+func (c *MLClient) ReadyTimeout() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.readyTimeout
+}
+
+func (c *MLClient) client() *http.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.httpClient
+}
+
+func (c *MLClient) url(path string) string {
+	return c.BaseURL() + path
+}
+
+// HealthCheck pings the sidecar's /health endpoint. It uses a short timeout
+// rather than the client's inference timeout so probes fail fast.
 func (c *MLClient) HealthCheck() error {
-	resp, err := c.httpClient.Get(c.baseURL + "/health")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/health"), nil)
 	if err != nil {
 		return err
 	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return classifyNetError(err, ErrSidecarUnavailable, ErrSidecarTimeout, "health check: %w")
+	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("health check returned %d", resp.StatusCode)
+		return newError(ErrSidecarUnavailable, "health check returned %d", resp.StatusCode)
 	}
 	return nil
 }
@@ -45,25 +305,75 @@ func (c *MLClient) WaitForReady(timeout time.Duration) error {
 		}
 		time.Sleep(2 * time.Second)
 	}
-	return fmt.Errorf("ML sidecar not ready after %s", timeout)
+	return newError(ErrSidecarUnavailable, "ML sidecar not ready after %s", timeout)
 }
 
 func (c *MLClient) EncodeImages(paths []string) ([][]float64, error) {
-	body, err := json.Marshal(map[string]any{"paths": paths})
+	return c.EncodeImagesContext(context.Background(), paths)
+}
+
+// imagesBody is the JSON body of a request about the images at paths:
+// {"paths": [...]} or, with ml.send_images, the file contents as
+// {"images": [base64, ...]}. A single image is sent as {"path": ...} or
+// {"image": ...}. A preset other than "" is sent along for the sidecar to
+// encode with that model instead of the loaded one.
+func (c *MLClient) imagesBody(paths []string, single bool, preset string) ([]byte, error) {
+	c.mu.RLock()
+	sendImages := c.sendImages
+	c.mu.RUnlock()
+
+	key := "path"
+	if sendImages {
+		key = "image"
+	}
+	values := make([]any, 0, len(paths))
+	for _, p := range paths {
+		if !sendImages {
+			values = append(values, p)
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("reading image for the ML sidecar: %w", err)
+		}
+		values = append(values, data)
+	}
+	body := map[string]any{key + "s": values}
+	if single {
+		body = map[string]any{key: values[0]}
+	}
+	if preset != "" {
+		body["preset"] = preset
+	}
+	return json.Marshal(body)
+}
+
+// EncodeImagesContext is EncodeImages with a request that is aborted once ctx
+// is done. Transient failures are retried.
+func (c *MLClient) EncodeImagesContext(ctx context.Context, paths []string) ([][]float64, error) {
+	return c.EncodeImagesPreset(ctx, "", paths)
+}
+
+// EncodeImagesPreset is EncodeImagesContext with the model of preset, which
+// the sidecar loads next to the current one until ReloadModel switches to
+// it; "" is the loaded model.
+func (c *MLClient) EncodeImagesPreset(ctx context.Context, preset string, paths []string) ([][]float64, error) {
+	body, err := c.imagesBody(paths, false, preset)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.httpClient.Post(c.baseURL+"/encode/image",
-		"application/json", bytes.NewReader(body))
+	release, err := c.acquire(ctx, false)
 	if err != nil {
-		return nil, fmt.Errorf("encode images request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("encode images returned %d: %s", resp.StatusCode, respBody)
+	defer release()
+	start := time.Now()
+	resp, err := c.do(ctx, "encode images", true, true, jsonRequest("/encode/image", body))
+	c.batch.observe(len(paths), time.Since(start), err)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	var result struct {
 		Embeddings [][]float64 `json:"embeddings"`
@@ -74,22 +384,33 @@ func (c *MLClient) EncodeImages(paths []string) ([][]float64, error) {
 	return result.Embeddings, nil
 }
 
+// jsonRequest returns a builder of POST requests of body to path, for do.
+func jsonRequest(path string, body []byte) func(ctx context.Context, baseURL string) (*http.Request, error) {
+	return func(ctx context.Context, baseURL string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}
+}
+
 func (c *MLClient) EncodeText(text string) ([]float64, error) {
 	body, err := json.Marshal(map[string]any{"text": text})
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.httpClient.Post(c.baseURL+"/encode/text",
-		"application/json", bytes.NewReader(body))
+	release, err := c.acquire(context.Background(), true)
 	if err != nil {
-		return nil, fmt.Errorf("encode text request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("encode text returned %d: %s", resp.StatusCode, respBody)
+	defer release()
+	resp, err := c.do(context.Background(), "encode text", false, false, jsonRequest("/encode/text", body))
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	var result struct {
 		Embedding []float64 `json:"embedding"`
@@ -100,24 +421,68 @@ func (c *MLClient) EncodeText(text string) ([]float64, error) {
 	return result.Embedding, nil
 }
 
+// FaceBox is a face bounding box in pixel coordinates, as returned by dlib.
+type FaceBox struct {
+	Top    int `json:"top"`
+	Right  int `json:"right"`
+	Bottom int `json:"bottom"`
+	Left   int `json:"left"`
+}
+
+// DetectedFace is one face found in an image by the sidecar.
+type DetectedFace struct {
+	Encoding []float64 `json:"encoding"`
+	BBox     FaceBox   `json:"bbox"`
+}
+
+// DetectFaces finds faces in a single image and returns their 128-dim
+// encodings. The sidecar answers 501 when face_recognition isn't installed.
+func (c *MLClient) DetectFaces(path string) ([]DetectedFace, error) {
+	body, err := c.imagesBody([]string{path}, true, "")
+	if err != nil {
+		return nil, err
+	}
+	release, err := c.acquire(context.Background(), false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	resp, err := c.do(context.Background(), "detect faces", true, false, jsonRequest("/detect/faces", body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Faces []DetectedFace `json:"faces"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding detect faces response: %w", err)
+	}
+	return result.Faces, nil
+}
+
 type ModelInfo struct {
 	Preset       string            `json:"preset"`
 	Model        string            `json:"model"`
 	EmbeddingDim int               `json:"embedding_dim"`
 	Presets      map[string]string `json:"presets,omitempty"`
 	Status       string            `json:"status,omitempty"`
+	// JobID is the reembed job migrating the index to Preset, when a model
+	// switch runs in the background
+	JobID string `json:"job_id,omitempty"`
 }
 
 func (c *MLClient) GetModelInfo() (*ModelInfo, error) {
-	resp, err := c.httpClient.Get(c.baseURL + "/model")
+	resp, err := c.client().Get(c.url("/model"))
 	if err != nil {
-		return nil, fmt.Errorf("get model info request: %w", err)
+		return nil, classifyNetError(err, ErrSidecarUnavailable, ErrSidecarTimeout, "get model info request: %w")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("get model info returned %d: %s", resp.StatusCode, respBody)
+		return nil, newError(ErrSidecarFailed, "get model info returned %d: %s", resp.StatusCode, respBody)
 	}
 
 	var info ModelInfo
@@ -127,21 +492,84 @@ func (c *MLClient) GetModelInfo() (*ModelInfo, error) {
 	return &info, nil
 }
 
+// ModelDownload is the progress of the sidecar's latest model load:
+// Status is idle, downloading (the weights, which the byte and file counts
+// are of), loading, ready or error.
+type ModelDownload struct {
+	Preset     string  `json:"preset"`
+	Status     string  `json:"status"`
+	File       string  `json:"file,omitempty"`
+	FilesDone  int     `json:"files_done"`
+	FilesTotal int     `json:"files_total"`
+	BytesDone  int64   `json:"bytes_done"`
+	BytesTotal int64   `json:"bytes_total"`
+	Percent    float64 `json:"percent"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Loading reports whether the load is still going.
+func (d ModelDownload) Loading() bool {
+	return d.Status == "downloading" || d.Status == "loading"
+}
+
+// ModelDownload returns the progress of the primary sidecar's latest model
+// load, e.g. of the weights a ReloadModel call is downloading.
+func (c *MLClient) ModelDownload() (*ModelDownload, error) {
+	resp, err := c.client().Get(c.url("/model/download"))
+	if err != nil {
+		return nil, classifyNetError(err, ErrSidecarUnavailable, ErrSidecarTimeout, "model download request: %w")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, newError(ErrSidecarFailed, "model download returned %d: %s", resp.StatusCode, respBody)
+	}
+
+	var d ModelDownload
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("decoding model download response: %w", err)
+	}
+	return &d, nil
+}
+
+// ReloadModel loads preset on every sidecar instance, so all of them encode
+// into the same embedding space, and returns the primary's model.
 func (c *MLClient) ReloadModel(preset string) (*ModelInfo, error) {
+	c.mu.RLock()
+	instances := c.instances
+	c.mu.RUnlock()
+	var info *ModelInfo
+	for i, inst := range instances {
+		instInfo, err := c.reloadModel(inst.baseURL, preset)
+		if err != nil {
+			if i > 0 {
+				return nil, fmt.Errorf("sidecar %s: %w", inst.baseURL, err)
+			}
+			return nil, err
+		}
+		if i == 0 {
+			info = instInfo
+		}
+	}
+	return info, nil
+}
+
+func (c *MLClient) reloadModel(baseURL, preset string) (*ModelInfo, error) {
 	body, err := json.Marshal(map[string]string{"preset": preset})
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.httpClient.Post(c.baseURL+"/reload",
+	resp, err := c.client().Post(baseURL+"/reload",
 		"application/json", bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("reload model request: %w", err)
+		return nil, classifyNetError(err, ErrSidecarUnavailable, ErrSidecarTimeout, "reload model request: %w")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("reload model returned %d: %s", resp.StatusCode, respBody)
+		return nil, newError(ErrSidecarFailed, "reload model returned %d: %s", resp.StatusCode, respBody)
 	}
 
 	var info ModelInfo
@@ -165,17 +593,16 @@ func (c *MLClient) SearchByText(dbPath, text string, cameraIDs []string,
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.httpClient.Post(c.baseURL+"/search/image",
-		"application/json", bytes.NewReader(body))
+	release, err := c.acquire(context.Background(), true)
 	if err != nil {
-		return nil, fmt.Errorf("search request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("search returned %d: %s", resp.StatusCode, respBody)
+	defer release()
+	resp, err := c.do(context.Background(), "search", false, false, jsonRequest("/search/image", body))
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	var result struct {
 		Results []models.SearchResult `json:"results"`
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// mlRetryBase is the wait before the first retry of a sidecar request;
+	// each further retry waits twice as long, up to mlRetryMax.
+	mlRetryBase = time.Second
+	mlRetryMax  = 30 * time.Second
+)
+
+// Circuit breaker states, as reported by MLClient.CircuitState.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// circuitBreaker stops requests to a sidecar that keeps failing. After
+// threshold consecutive transient failures it opens and fails every request
+// at once for cooldown; then a single probe request is let through, and its
+// outcome closes or reopens the circuit. A threshold of 0 never opens it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+	lastErr   error
+}
+
+// allow returns nil when a request may go to the sidecar, or the error to
+// fail it with.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold == 0 || b.failures < b.threshold {
+		return nil
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return newError(ErrSidecarUnavailable, "ML sidecar is down after %d failed requests (last: %v); retrying in %s",
+			b.failures, b.lastErr, wait.Round(time.Second))
+	}
+	if b.probing {
+		return newError(ErrSidecarUnavailable, "ML sidecar is down (last: %v); a probe request is in flight", b.lastErr)
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a request: err is nil on success or a
+// failure that shows the sidecar is up.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// abandon ends a request that tells nothing of the sidecar, like one the
+// caller cancelled, freeing the probe slot it may hold.
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (b *circuitBreaker) state() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.threshold == 0 || b.failures < b.threshold:
+		return CircuitClosed
+	case time.Now().Before(b.openUntil):
+		return CircuitOpen
+	default:
+		return CircuitHalfOpen
+	}
+}
+
+// transientSidecarError reports whether err is worth retrying: the sidecar
+// could not be reached, timed out, or answered that it is overloaded or
+// restarting.
+func transientSidecarError(err error) bool {
+	var status *sidecarStatusError
+	if errors.As(err, &status) {
+		switch status.code {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, ErrSidecarUnavailable) || errors.Is(err, ErrSidecarTimeout)
+}
+
+// sidecarStatusError is a non-200 answer from the sidecar.
+type sidecarStatusError struct {
+	code int
+}
+
+func (e *sidecarStatusError) Error() string { return fmt.Sprintf("HTTP %d", e.code) }
+
+// CircuitState returns the state of the circuit breaker in front of the
+// primary sidecar: closed, open or half_open.
+func (c *MLClient) CircuitState() string {
+	c.mu.RLock()
+	breaker := c.instances[0].breaker
+	c.mu.RUnlock()
+	return breaker.state()
+}
+
+// do sends the request built by newReq, named op in errors, through the
+// circuit breaker of a sidecar and returns the response of a 200 answer;
+// any other status is an ErrSidecarFailed error carrying the body. With
+// spread set the request goes to the least busy instance rather than the
+// primary. With retry set, which only idempotent requests may use,
+// transient failures are retried up to the configured number of times with
+// exponential backoff, each time on a freshly picked instance.
+func (c *MLClient) do(ctx context.Context, op string, retry, spread bool, newReq func(ctx context.Context, baseURL string) (*http.Request, error)) (*http.Response, error) {
+	c.mu.RLock()
+	retries := c.retries
+	c.mu.RUnlock()
+	if !retry {
+		retries = 0
+	}
+
+	wait := mlRetryBase
+	for attempt := 0; ; attempt++ {
+		inst, err := c.pick(spread)
+		if err != nil {
+			return nil, err
+		}
+		inst.inFlight.Add(1)
+		resp, err := c.send(ctx, op, inst.baseURL, newReq)
+		inst.inFlight.Add(-1)
+		if ctx.Err() != nil {
+			// Cancelled by the caller, which says nothing of the sidecar
+			inst.breaker.abandon()
+			return nil, err
+		}
+		if err == nil || !transientSidecarError(err) {
+			inst.breaker.record(nil)
+			return resp, err
+		}
+		inst.breaker.record(err)
+		if attempt == retries {
+			if retries > 0 {
+				return nil, fmt.Errorf("%w (after %d retries)", err, retries)
+			}
+			return nil, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		wait = min(wait*2, mlRetryMax)
+	}
+}
+
+// send makes one attempt of a request to the sidecar at baseURL.
+func (c *MLClient) send(ctx context.Context, op, baseURL string, newReq func(ctx context.Context, baseURL string) (*http.Request, error)) (*http.Response, error) {
+	req, err := newReq(ctx, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, classifyNetError(err, ErrSidecarUnavailable, ErrSidecarTimeout, "%s request: %w", op)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, &kindError{
+			kind: ErrSidecarFailed,
+			msg:  fmt.Sprintf("%s returned %d: %s", op, resp.StatusCode, respBody),
+			err:  &sidecarStatusError{code: resp.StatusCode},
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// mlSemaphore bounds the requests in flight to the ML sidecars across all
+// jobs, searches and workspaces, so parallel jobs don't flood a
+// single-threaded CPU sidecar with requests that then all time out
+// together. Interactive requests (text encoding and search) count against
+// the limit too, but are let in ahead of waiting indexing ones, so a search
+// waits for a batch in flight to finish, not for a backlog of them.
+type mlSemaphore struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+	// waiting are the requests waiting for a slot, interactive ones first,
+	// each in arrival order; a slot is handed over by closing the channel
+	waiting [2][]chan struct{}
+}
+
+// MLRequestStatus is the state of the sidecar request limit, for the
+// health check.
+type MLRequestStatus struct {
+	Limit   int `json:"limit"`
+	Active  int `json:"active"`
+	Waiting int `json:"waiting"`
+}
+
+func newMLSemaphore(limit int) *mlSemaphore {
+	return &mlSemaphore{limit: limit}
+}
+
+// acquire waits for a free slot, or until ctx is done, and returns the
+// func that frees it.
+func (s *mlSemaphore) acquire(ctx context.Context, interactive bool) (func(), error) {
+	class := 1
+	if interactive {
+		class = 0
+	}
+	s.mu.Lock()
+	if s.active < s.limit && len(s.waiting[0])+len(s.waiting[1]) == 0 {
+		s.active++
+		s.mu.Unlock()
+		return s.release, nil
+	}
+	ready := make(chan struct{})
+	s.waiting[class] = append(s.waiting[class], ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return s.release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if i := slices.Index(s.waiting[class], ready); i >= 0 {
+			s.waiting[class] = slices.Delete(s.waiting[class], i, i+1)
+			return nil, ctx.Err()
+		}
+		// Handed a slot as ctx ended; pass it on
+		s.active--
+		s.handOver()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *mlSemaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	s.handOver()
+}
+
+// handOver gives free slots to the first waiting requests; s.mu is held.
+func (s *mlSemaphore) handOver() {
+	for class := range s.waiting {
+		for s.active < s.limit && len(s.waiting[class]) > 0 {
+			close(s.waiting[class][0])
+			s.waiting[class] = s.waiting[class][1:]
+			s.active++
+		}
+	}
+}
+
+func (s *mlSemaphore) status() MLRequestStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return MLRequestStatus{Limit: s.limit, Active: s.active, Waiting: len(s.waiting[0]) + len(s.waiting[1])}
+}
@@ -0,0 +1,443 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intelsk/backend/models"
+)
+
+// notifyTimeout bounds the delivery of one notification to one channel.
+const notifyTimeout = 30 * time.Second
+
+// Notification is a message sent to the configured notification channels.
+type Notification struct {
+	Event    string    `json:"event"` // e.g. "job_failed", "test"
+	Title    string    `json:"title"`
+	Message  string    `json:"message"`
+	CameraID string    `json:"camera_id,omitempty"`
+	JobID    string    `json:"job_id,omitempty"`
+	AlertID  string    `json:"alert_id,omitempty"`
+	Time     time.Time `json:"time"`
+	// Attachments of an alert: its matched frame and, with
+	// notify.attach_clip, a clip around it
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment is a file sent with a notification. Email and Telegram send it
+// as a file; the webhook gets it base64-encoded in its JSON.
+type Attachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// NotificationChannel delivers notifications to one destination. Each is
+// configured by notify.* settings and is skipped while they are unset.
+type NotificationChannel interface {
+	Name() string
+	Configured() bool
+	Send(ctx context.Context, n Notification) error
+}
+
+// Notifier fans notifications out to every configured channel: SMTP email,
+// a Telegram bot, a Slack incoming webhook and a generic JSON webhook.
+// Delivery runs in the background, so a slow or failing channel never holds
+// up the job or alert that raised the notification.
+type Notifier struct {
+	settings *SettingsService
+	channels []NotificationChannel
+}
+
+func NewNotifier(settings *SettingsService) *Notifier {
+	client := &http.Client{Timeout: notifyTimeout}
+	return &Notifier{
+		settings: settings,
+		channels: []NotificationChannel{
+			&emailChannel{settings: settings},
+			&telegramChannel{settings: settings, client: client},
+			&slackChannel{settings: settings, client: client},
+			&webhookChannel{settings: settings, client: client},
+		},
+	}
+}
+
+// Channels lists the notification channels and whether each is configured.
+func (n *Notifier) Channels() []models.NotificationChannelInfo {
+	infos := make([]models.NotificationChannelInfo, len(n.channels))
+	for i, ch := range n.channels {
+		infos[i] = models.NotificationChannelInfo{Name: ch.Name(), Configured: ch.Configured()}
+	}
+	return infos
+}
+
+func (n *Notifier) channel(name string) (NotificationChannel, error) {
+	for _, ch := range n.channels {
+		if ch.Name() == name {
+			return ch, nil
+		}
+	}
+	return nil, newError(ErrInvalidInput, "unknown notification channel %q", name)
+}
+
+// Notify sends msg to every configured channel in the background. Failed
+// deliveries are logged.
+func (n *Notifier) Notify(msg Notification) {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now().UTC()
+	}
+	for _, ch := range n.channels {
+		if !ch.Configured() {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := ch.Send(ctx, msg); err != nil {
+				log.Printf("Notification %s via %s: %v", msg.Event, ch.Name(), err)
+			}
+		}()
+	}
+}
+
+// Test sends a test notification through one channel and waits for it. It
+// is ErrInvalidInput for an unknown or unconfigured channel.
+func (n *Notifier) Test(ctx context.Context, name string) error {
+	ch, err := n.channel(name)
+	if err != nil {
+		return err
+	}
+	if !ch.Configured() {
+		return newError(ErrInvalidInput, "notification channel %s is not configured", name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	return ch.Send(ctx, Notification{
+		Event:   "test",
+		Title:   "Test notification",
+		Message: fmt.Sprintf("Notifications from %s reach this channel.", n.settings.Get("general.system_name")),
+		Time:    time.Now().UTC(),
+	})
+}
+
+// JobFinished notifies of a failed job when notify.job_failures is set. It
+// is meant for JobManager.OnFinish.
+func (n *Notifier) JobFinished(job models.Job) {
+	if job.Status != "failed" || !n.settings.GetBool("notify.job_failures") {
+		return
+	}
+	var params struct {
+		CameraID string `json:"camera_id"`
+	}
+	json.Unmarshal(job.Params, &params)
+	n.Notify(Notification{
+		Event:    "job_failed",
+		Title:    fmt.Sprintf("%s job failed", job.Type),
+		Message:  fmt.Sprintf("Job %s failed: %s", job.ID, job.Error),
+		CameraID: params.CameraID,
+		JobID:    job.ID,
+	})
+}
+
+// text renders a notification as plain text for the chat channels.
+func (msg Notification) text(systemName string) string {
+	return fmt.Sprintf("[%s] %s\n%s", systemName, msg.Title, msg.Message)
+}
+
+// postJSON posts body as JSON to url and fails on a non-2xx response.
+func postJSON(ctx context.Context, client *http.Client, url string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// postMultipart posts fields and one file, as form field fileField, to url
+// and fails on a non-2xx response.
+func postMultipart(ctx context.Context, client *http.Client, url string, fields map[string]string, fileField string, file Attachment) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": fileField, "filename": file.Name}))
+	header.Set("Content-Type", file.ContentType)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	part.Write(file.Data)
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// emailChannel sends mail through the notify.email_* SMTP server, with
+// STARTTLS when the server offers it, or implicit TLS on port 465.
+type emailChannel struct {
+	settings *SettingsService
+}
+
+func (c *emailChannel) Name() string { return "email" }
+
+func (c *emailChannel) Configured() bool {
+	return c.settings.Get("notify.email_smtp_host") != "" && len(c.recipients()) > 0
+}
+
+func (c *emailChannel) recipients() []string {
+	var to []string
+	for _, addr := range strings.Split(c.settings.Get("notify.email_to"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	return to
+}
+
+func (c *emailChannel) Send(ctx context.Context, msg Notification) error {
+	host := c.settings.Get("notify.email_smtp_host")
+	port := c.settings.GetInt("notify.email_smtp_port")
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	from := c.settings.Get("notify.email_from")
+	if from == "" {
+		from = c.settings.Get("notify.email_username")
+	}
+	to := c.recipients()
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP handshake with %s: %w", addr, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != 465 {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if user := c.settings.Get("notify.email_username"); user != "" {
+		if err := client.Auth(smtp.PlainAuth("", user, c.settings.Get("notify.email_password"), host)); err != nil {
+			return fmt.Errorf("SMTP auth: %w", err)
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM %s: %w", from, err)
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s: %w", rcpt, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA: %w", err)
+	}
+	subject := fmt.Sprintf("[%s] %s", c.settings.Get("general.system_name"), msg.Title)
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\n",
+		headerValue(from), headerValue(strings.Join(to, ", ")),
+		mime.QEncoding.Encode("utf-8", headerValue(subject)), msg.Time.Format(time.RFC1123Z))
+	if err := writeMailBody(w, msg); err != nil {
+		return fmt.Errorf("SMTP DATA: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP DATA: %w", err)
+	}
+	return client.Quit()
+}
+
+// headerValue makes s safe as the value of a mail header: line breaks, as
+// a camera name may hold, would otherwise start headers of their own.
+func headerValue(s string) string {
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool { return r == '\r' || r == '\n' }), " ")
+}
+
+// writeMailBody writes the Content-Type header and body of a mail: the
+// message as plain text, with the attachments as a multipart/mixed message.
+func writeMailBody(w io.Writer, msg Notification) error {
+	if len(msg.Attachments) == 0 {
+		_, err := fmt.Fprintf(w, "Content-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", msg.Message)
+		return err
+	}
+	mw := multipart.NewWriter(w)
+	fmt.Fprintf(w, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	text, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(text, "%s\r\n", msg.Message)
+	for _, a := range msg.Attachments {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		// RFC 2045 caps encoded lines at 76 characters
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	return mw.Close()
+}
+
+// telegramChannel sends messages through a Telegram bot to one chat.
+type telegramChannel struct {
+	settings *SettingsService
+	client   *http.Client
+}
+
+func (c *telegramChannel) Name() string { return "telegram" }
+
+func (c *telegramChannel) Configured() bool {
+	return c.settings.Get("notify.telegram_bot_token") != "" && c.settings.Get("notify.telegram_chat_id") != ""
+}
+
+// telegramCaptionMax is the longest caption Telegram takes on a photo or
+// video.
+const telegramCaptionMax = 1024
+
+// Send posts msg as a text message or, with attachments, as photos and
+// videos with the text as the caption of the first.
+func (c *telegramChannel) Send(ctx context.Context, msg Notification) error {
+	token := c.settings.Get("notify.telegram_bot_token")
+	chatID := c.settings.Get("notify.telegram_chat_id")
+	text := msg.text(c.settings.Get("general.system_name"))
+	if len(msg.Attachments) == 0 {
+		url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", token)
+		if err := postJSON(ctx, c.client, url, map[string]string{"chat_id": chatID, "text": text}); err != nil {
+			// The URL carries the bot token; keep it out of logs
+			return fmt.Errorf("telegram sendMessage: %s", strings.ReplaceAll(err.Error(), token, MaskedSecret))
+		}
+		return nil
+	}
+
+	for i, a := range msg.Attachments {
+		method, field := "sendDocument", "document"
+		switch {
+		case strings.HasPrefix(a.ContentType, "image/"):
+			method, field = "sendPhoto", "photo"
+		case strings.HasPrefix(a.ContentType, "video/"):
+			method, field = "sendVideo", "video"
+		}
+		fields := map[string]string{"chat_id": chatID}
+		if i == 0 {
+			if r := []rune(text); len(r) > telegramCaptionMax {
+				text = string(r[:telegramCaptionMax])
+			}
+			fields["caption"] = text
+		}
+		url := fmt.Sprintf("https://api.telegram.org/bot%s/%s", token, method)
+		if err := postMultipart(ctx, c.client, url, fields, field, a); err != nil {
+			return fmt.Errorf("telegram %s: %s", method, strings.ReplaceAll(err.Error(), token, MaskedSecret))
+		}
+	}
+	return nil
+}
+
+// slackChannel posts to a Slack incoming webhook. Incoming webhooks take
+// no files, so attachments are left out.
+type slackChannel struct {
+	settings *SettingsService
+	client   *http.Client
+}
+
+func (c *slackChannel) Name() string { return "slack" }
+
+func (c *slackChannel) Configured() bool {
+	return c.settings.Get("notify.slack_webhook_url") != ""
+}
+
+func (c *slackChannel) Send(ctx context.Context, msg Notification) error {
+	text := fmt.Sprintf("*[%s] %s*\n%s", c.settings.Get("general.system_name"), msg.Title, msg.Message)
+	if err := postJSON(ctx, c.client, c.settings.Get("notify.slack_webhook_url"), map[string]string{"text": text}); err != nil {
+		return fmt.Errorf("slack webhook: %w", err)
+	}
+	return nil
+}
+
+// webhookChannel posts the Notification itself as JSON, for integrations
+// of the user's own.
+type webhookChannel struct {
+	settings *SettingsService
+	client   *http.Client
+}
+
+func (c *webhookChannel) Name() string { return "webhook" }
+
+func (c *webhookChannel) Configured() bool {
+	return c.settings.Get("notify.webhook_url") != ""
+}
+
+func (c *webhookChannel) Send(ctx context.Context, msg Notification) error {
+	if err := postJSON(ctx, c.client, c.settings.Get("notify.webhook_url"), msg); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/intelsk/backend/models"
+	"github.com/nfnt/resize"
+)
+
+// onnxSession runs one ONNX model with a single input and output. It is
+// implemented on ONNX Runtime in binaries built with the onnx tag.
+type onnxSession interface {
+	runFloat32(input []float32, shape []int64) ([]float32, error)
+	runInt64(input []int64, shape []int64) ([]float32, error)
+	close()
+}
+
+// Files of a preset exported by mlservice/export_onnx.py into its own
+// directory under mlservice.onnx_dir.
+const (
+	onnxPresetFile = "preset.json"
+	onnxImageModel = "image.onnx"
+	onnxTextModel  = "text.onnx"
+	onnxVocabFile  = "bpe_simple_vocab_16e6.txt.gz"
+)
+
+// defaultPreset is the CLIP model preset loaded until another is asked for,
+// as in the sidecar.
+const defaultPreset = "mobileclip-s0"
+
+// onnxPreset describes an exported preset: its name and the preprocessing
+// its image encoder expects.
+type onnxPreset struct {
+	Name          string     `json:"name"`
+	ImageSize     int        `json:"image_size"`
+	Mean          [3]float32 `json:"mean"`
+	Std           [3]float32 `json:"std"`
+	EmbeddingDim  int        `json:"embedding_dim"`
+	ContextLength int        `json:"context_length"`
+}
+
+// onnxModel is a loaded preset.
+type onnxModel struct {
+	key         string
+	preset      onnxPreset
+	image, text onnxSession
+	tokenizer   *clipTokenizer
+}
+
+func (m *onnxModel) close() {
+	m.image.close()
+	m.text.close()
+}
+
+// ONNXEncoder runs the CLIP image and text encoders in the backend, with
+// ONNX Runtime, so small deployments need no Python sidecar. Presets are
+// exported beforehand by mlservice/export_onnx.py into dir; search ranks
+// the embeddings in Go. Face detection still needs the sidecar.
+type ONNXEncoder struct {
+	dir       string
+	library   string
+	batchSize int
+
+	// mu guards model, which is loaded on first use, and staged, the
+	// preset a re-embedding encodes with until ReloadModel switches to it;
+	// encoding holds it for reading, so a reload waits for the batches in
+	// flight
+	mu     sync.RWMutex
+	model  *onnxModel
+	staged *onnxModel
+}
+
+func NewONNXEncoder(dir, library string, batchSize int) *ONNXEncoder {
+	return &ONNXEncoder{dir: dir, library: library, batchSize: max(batchSize, 1)}
+}
+
+// Dir returns the directory the presets are loaded from.
+func (e *ONNXEncoder) Dir() string {
+	return e.dir
+}
+
+// loadModel reads the preset key from dir.
+func (e *ONNXEncoder) loadModel(key string) (*onnxModel, error) {
+	dir := filepath.Join(e.dir, key)
+	data, err := os.ReadFile(filepath.Join(dir, onnxPresetFile))
+	if os.IsNotExist(err) {
+		return nil, newError(ErrInvalidInput, "preset %s is not exported to %s (run mlservice/export_onnx.py --preset %s)", key, e.dir, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &onnxModel{key: key}
+	if err := json.Unmarshal(data, &m.preset); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filepath.Join(dir, onnxPresetFile), err)
+	}
+	if m.preset.ImageSize <= 0 || m.preset.ContextLength <= 0 || m.preset.Std[0]*m.preset.Std[1]*m.preset.Std[2] == 0 {
+		return nil, fmt.Errorf("%s: image_size, context_length and a nonzero std are required", filepath.Join(dir, onnxPresetFile))
+	}
+	if m.tokenizer, err = loadCLIPTokenizer(filepath.Join(dir, onnxVocabFile)); err != nil {
+		return nil, err
+	}
+	if m.image, err = newONNXSession(e.library, filepath.Join(dir, onnxImageModel)); err != nil {
+		return nil, newError(ErrSidecarUnavailable, "ONNX image encoder of %s: %w", key, err)
+	}
+	if m.text, err = newONNXSession(e.library, filepath.Join(dir, onnxTextModel)); err != nil {
+		m.image.close()
+		return nil, newError(ErrSidecarUnavailable, "ONNX text encoder of %s: %w", key, err)
+	}
+	return m, nil
+}
+
+// acquire returns the loaded model, loading the default preset first if
+// none is, with mu held for reading; the caller unlocks it.
+func (e *ONNXEncoder) acquire() (*onnxModel, error) {
+	e.mu.RLock()
+	if e.model != nil {
+		return e.model, nil
+	}
+	e.mu.RUnlock()
+
+	e.mu.Lock()
+	if e.model == nil {
+		m, err := e.loadModel(defaultPreset)
+		if err != nil {
+			e.mu.Unlock()
+			return nil, err
+		}
+		e.model = m
+	}
+	e.mu.Unlock()
+	return e.acquire()
+}
+
+// HealthCheck loads the model if it isn't yet, and reports why it can't be.
+func (e *ONNXEncoder) HealthCheck() error {
+	if _, err := e.acquire(); err != nil {
+		return err
+	}
+	e.mu.RUnlock()
+	return nil
+}
+
+// WaitForReady is HealthCheck: loading the model is not worth waiting for
+// when it fails.
+func (e *ONNXEncoder) WaitForReady(timeout time.Duration) error {
+	return e.HealthCheck()
+}
+
+// ReadyTimeout is 0; the encoder is ready as soon as its model loads.
+func (e *ONNXEncoder) ReadyTimeout() time.Duration {
+	return 0
+}
+
+// acquireStaged returns the model of preset for EncodeImagesPreset, the
+// current one or staged, loading it into staged if neither is, with mu held
+// for reading; the caller unlocks it.
+func (e *ONNXEncoder) acquireStaged(preset string) (*onnxModel, error) {
+	m, err := e.acquire()
+	if err != nil || m.key == preset {
+		return m, err
+	}
+	if e.staged != nil && e.staged.key == preset {
+		return e.staged, nil
+	}
+	e.mu.RUnlock()
+
+	e.mu.Lock()
+	if e.staged == nil || e.staged.key != preset {
+		m, err := e.loadModel(preset)
+		if err != nil {
+			e.mu.Unlock()
+			return nil, err
+		}
+		if e.staged != nil {
+			e.staged.close()
+		}
+		e.staged = m
+	}
+	e.mu.Unlock()
+	return e.acquireStaged(preset)
+}
+
+// EncodeImagesContext encodes the image files at paths in batches of
+// clip.batch_size, stopping between batches once ctx is done.
+func (e *ONNXEncoder) EncodeImagesContext(ctx context.Context, paths []string) ([][]float64, error) {
+	m, err := e.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer e.mu.RUnlock()
+	return e.encodeImages(ctx, m, paths)
+}
+
+// EncodeImagesPreset is EncodeImagesContext with the model of preset, ""
+// being the current one.
+func (e *ONNXEncoder) EncodeImagesPreset(ctx context.Context, preset string, paths []string) ([][]float64, error) {
+	if preset == "" {
+		return e.EncodeImagesContext(ctx, paths)
+	}
+	m, err := e.acquireStaged(preset)
+	if err != nil {
+		return nil, err
+	}
+	defer e.mu.RUnlock()
+	return e.encodeImages(ctx, m, paths)
+}
+
+func (e *ONNXEncoder) encodeImages(ctx context.Context, m *onnxModel, paths []string) ([][]float64, error) {
+	size := m.preset.ImageSize
+	pixels := 3 * size * size
+	embeddings := make([][]float64, 0, len(paths))
+	for i := 0; i < len(paths); i += e.batchSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		batch := paths[i:min(i+e.batchSize, len(paths))]
+		input := make([]float32, len(batch)*pixels)
+		for j, path := range batch {
+			if err := preprocessImage(path, m.preset, input[j*pixels:(j+1)*pixels]); err != nil {
+				return nil, newError(ErrSidecarFailed, "encode images: %s: %w", path, err)
+			}
+		}
+		out, err := m.image.runFloat32(input, []int64{int64(len(batch)), 3, int64(size), int64(size)})
+		if err != nil {
+			return nil, newError(ErrSidecarFailed, "encode images: %w", err)
+		}
+		embeddings = append(embeddings, splitEmbeddings(out, len(batch))...)
+	}
+	return embeddings, nil
+}
+
+// EncodeText encodes a text query.
+func (e *ONNXEncoder) EncodeText(text string) ([]float64, error) {
+	m, err := e.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer e.mu.RUnlock()
+
+	tokens := m.tokenizer.tokenize(text, m.preset.ContextLength)
+	out, err := m.text.runInt64(tokens, []int64{1, int64(len(tokens))})
+	if err != nil {
+		return nil, newError(ErrSidecarFailed, "encode text: %w", err)
+	}
+	return splitEmbeddings(out, 1)[0], nil
+}
+
+// SearchByText ranks the frames indexed in the database at dbPath against
+// text, like the sidecar's /search/image.
+func (e *ONNXEncoder) SearchByText(dbPath, text string, cameraIDs []string,
+	startTime, endTime string, limit int, minScore float64) ([]models.SearchResult, error) {
+	query, err := e.EncodeText(text)
+	if err != nil {
+		return nil, err
+	}
+	return searchEmbeddings(dbPath, query, cameraIDs, startTime, endTime, limit, minScore)
+}
+
+// GetModelInfo returns the loaded preset and the presets exported to dir.
+func (e *ONNXEncoder) GetModelInfo() (*ModelInfo, error) {
+	m, err := e.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer e.mu.RUnlock()
+	return e.modelInfo(m), nil
+}
+
+func (e *ONNXEncoder) modelInfo(m *onnxModel) *ModelInfo {
+	info := &ModelInfo{Preset: m.key, Model: m.preset.Name, EmbeddingDim: m.preset.EmbeddingDim, Presets: map[string]string{}}
+	matches, _ := filepath.Glob(filepath.Join(e.dir, "*", onnxPresetFile))
+	sort.Strings(matches)
+	for _, path := range matches {
+		var p onnxPreset
+		if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &p) == nil {
+			info.Presets[filepath.Base(filepath.Dir(path))] = p.Name
+		}
+	}
+	return info
+}
+
+// ReloadModel switches to preset, once the requests using the current
+// model are done: the staged model if it is that preset, else loaded anew
+// unless it is the current one. Any other staged model is dropped.
+func (e *ONNXEncoder) ReloadModel(preset string) (*ModelInfo, error) {
+	e.mu.Lock()
+	var m *onnxModel
+	var unused []*onnxModel
+	switch {
+	case e.staged != nil && e.staged.key == preset:
+		m, e.staged = e.staged, nil
+	case e.model != nil && e.model.key == preset:
+		m = e.model
+	}
+	if e.staged != nil {
+		unused = append(unused, e.staged)
+		e.staged = nil
+	}
+	e.mu.Unlock()
+
+	if m == nil {
+		var err error
+		if m, err = e.loadModel(preset); err != nil {
+			for _, u := range unused {
+				u.close()
+			}
+			return nil, err
+		}
+	}
+	e.mu.Lock()
+	if e.model != nil && e.model != m {
+		unused = append(unused, e.model)
+	}
+	e.model = m
+	e.mu.Unlock()
+	for _, u := range unused {
+		u.close()
+	}
+	info := e.modelInfo(m)
+	info.Status = "ok"
+	return info, nil
+}
+
+// preprocessImage writes the image file at path into dst as the encoder
+// input: resized so its shorter side is the preset's image size, center
+// cropped to a square, in normalized channel-first RGB.
+func preprocessImage(path string, p onnxPreset, dst []float32) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return err
+	}
+
+	size := p.ImageSize
+	b := img.Bounds()
+	w, h := uint(size), uint(0)
+	if b.Dx() > b.Dy() {
+		w, h = 0, uint(size)
+	}
+	img = resize.Resize(w, h, img, resize.Bicubic)
+	b = img.Bounds()
+	x0 := b.Min.X + (b.Dx()-size)/2
+	y0 := b.Min.Y + (b.Dy()-size)/2
+
+	plane := size * size
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			r, g, bl, _ := img.At(x0+x, y0+y).RGBA()
+			i := y*size + x
+			dst[i] = (float32(r)/0xffff - p.Mean[0]) / p.Std[0]
+			dst[plane+i] = (float32(g)/0xffff - p.Mean[1]) / p.Std[1]
+			dst[2*plane+i] = (float32(bl)/0xffff - p.Mean[2]) / p.Std[2]
+		}
+	}
+	return nil
+}
+
+// splitEmbeddings splits the output of a batch of n into its embeddings,
+// L2-normalized.
+func splitEmbeddings(out []float32, n int) [][]float64 {
+	dim := len(out) / n
+	embeddings := make([][]float64, n)
+	for i := range embeddings {
+		emb := make([]float64, dim)
+		var norm float64
+		for j, v := range out[i*dim : (i+1)*dim] {
+			emb[j] = float64(v)
+			norm += emb[j] * emb[j]
+		}
+		if norm = math.Sqrt(norm); norm > 0 {
+			for j := range emb {
+				emb[j] /= norm
+			}
+		}
+		embeddings[i] = emb
+	}
+	return embeddings
+}
@@ -0,0 +1,84 @@
+//go:build onnx
+
+package services
+
+import (
+	"fmt"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+var (
+	ortOnce sync.Once
+	ortErr  error
+)
+
+// ortSession is an onnxSession on ONNX Runtime, through its C API.
+type ortSession struct {
+	session *ort.DynamicAdvancedSession
+}
+
+// newONNXSession loads the model at modelPath. The first call loads ONNX
+// Runtime from library, or from the platform's default library name when
+// empty; later calls share it.
+func newONNXSession(library, modelPath string) (onnxSession, error) {
+	ortOnce.Do(func() {
+		if library != "" {
+			ort.SetSharedLibraryPath(library)
+		}
+		ortErr = ort.InitializeEnvironment()
+	})
+	if ortErr != nil {
+		return nil, fmt.Errorf("loading ONNX Runtime: %w", ortErr)
+	}
+
+	inputs, outputs, err := ort.GetInputOutputInfo(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", modelPath, err)
+	}
+	if len(inputs) != 1 || len(outputs) != 1 {
+		return nil, fmt.Errorf("%s: expected one input and one output, got %d and %d", modelPath, len(inputs), len(outputs))
+	}
+	session, err := ort.NewDynamicAdvancedSession(modelPath, []string{inputs[0].Name}, []string{outputs[0].Name}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", modelPath, err)
+	}
+	return &ortSession{session: session}, nil
+}
+
+func (s *ortSession) runFloat32(input []float32, shape []int64) ([]float32, error) {
+	tensor, err := ort.NewTensor(ort.NewShape(shape...), input)
+	if err != nil {
+		return nil, err
+	}
+	defer tensor.Destroy()
+	return s.run(tensor)
+}
+
+func (s *ortSession) runInt64(input []int64, shape []int64) ([]float32, error) {
+	tensor, err := ort.NewTensor(ort.NewShape(shape...), input)
+	if err != nil {
+		return nil, err
+	}
+	defer tensor.Destroy()
+	return s.run(tensor)
+}
+
+func (s *ortSession) run(input ort.Value) ([]float32, error) {
+	outputs := []ort.Value{nil}
+	if err := s.session.Run([]ort.Value{input}, outputs); err != nil {
+		return nil, err
+	}
+	defer outputs[0].Destroy()
+	out, ok := outputs[0].(*ort.Tensor[float32])
+	if !ok {
+		return nil, fmt.Errorf("expected a float32 output")
+	}
+	// The tensor's memory is freed with it
+	return append([]float32(nil), out.GetData()...), nil
+}
+
+func (s *ortSession) close() {
+	s.session.Destroy()
+}
@@ -0,0 +1,11 @@
+//go:build !onnx
+
+package services
+
+import "errors"
+
+// newONNXSession fails: ONNX Runtime is only linked into binaries built
+// with the onnx tag, which needs cgo.
+func newONNXSession(library, modelPath string) (onnxSession, error) {
+	return nil, errors.New("this binary was built without ONNX support; rebuild it with -tags onnx")
+}
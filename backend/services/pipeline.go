@@ -1,11 +1,14 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/intelsk/backend/models"
@@ -14,25 +17,75 @@ import (
 type ProgressEvent struct {
 	Stage       string `json:"stage"`
 	CameraID    string `json:"camera_id"`
+	Date        string `json:"date,omitempty"`
+	File        string `json:"file,omitempty"`
 	FramesDone  int    `json:"frames_done"`
 	FramesTotal int    `json:"frames_total"`
-	Message     string `json:"message"`
+	// VideosDone/VideosTotal count the recordings downloaded or extracted
+	// so far in the current stage.
+	VideosDone  int `json:"videos_done,omitempty"`
+	VideosTotal int `json:"videos_total,omitempty"`
+	// BytesDone/BytesTotal count the bytes of a video imported from a URL;
+	// BytesTotal is 0 while the size isn't known.
+	BytesDone  int64 `json:"bytes_done,omitempty"`
+	BytesTotal int64 `json:"bytes_total,omitempty"`
+	// Percent is how much of the whole job is done (0-100) and ETASec an
+	// estimate of the seconds left, when the job tracks them (JobProgress).
+	Percent float64 `json:"percent,omitempty"`
+	ETASec  int     `json:"eta_sec,omitempty"`
+	Message string  `json:"message"`
 }
 
 type Pipeline struct {
-	mlClient  *MLClient
+	encoder   ImageEncoder
 	storage   *Storage
 	batchSize int
+	ctx       context.Context
 }
 
-func NewPipeline(mlClient *MLClient, storage *Storage, batchSize int) *Pipeline {
+func NewPipeline(encoder ImageEncoder, storage *Storage, batchSize int) *Pipeline {
 	return &Pipeline{
-		mlClient:  mlClient,
+		encoder:   encoder,
 		storage:   storage,
 		batchSize: batchSize,
 	}
 }
 
+// WithEncoder returns a copy of the pipeline that encodes frames with e
+// instead of the ML sidecar, e.g. a WorkerPool.
+func (p *Pipeline) WithEncoder(e ImageEncoder) *Pipeline {
+	cp := *p
+	cp.encoder = e
+	return &cp
+}
+
+// WithContext returns a copy of the pipeline that stops once ctx is done:
+// IndexFrames between batches and ProcessCameraDate between videos, with
+// the running ffmpeg extraction killed and the sidecar request in flight
+// aborted. Work finished so far stays recorded, so a later run resumes from
+// there.
+func (p *Pipeline) WithContext(ctx context.Context) *Pipeline {
+	cp := *p
+	cp.ctx = ctx
+	return &cp
+}
+
+// context returns the pipeline's context, or context.Background().
+func (p *Pipeline) context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+// err reports why the pipeline's context is done, or nil.
+func (p *Pipeline) err() error {
+	if p.ctx == nil {
+		return nil
+	}
+	return p.ctx.Err()
+}
+
 func (p *Pipeline) IndexFrames(framesDir string, progress chan<- ProgressEvent) error {
 	// 1. Read manifest.json
 	manifestPath := filepath.Join(framesDir, "manifest.json")
@@ -90,59 +143,114 @@ func (p *Pipeline) IndexFrames(framesDir string, progress chan<- ProgressEvent)
 		}
 	}
 
-	// 3. Batch frames and process
-	for i := 0; i < len(pending); i += p.batchSize {
-		end := i + p.batchSize
-		if end > len(pending) {
-			end = len(pending)
+	// 3. Batch frames; with several sidecar instances, encode as many
+	// batches at once and store them in order. An encoder that tunes its
+	// batch size picks it anew for every window, and the frames of a batch
+	// that timed out go back in the queue to be sent in smaller batches.
+	parallel := 1
+	if pe, ok := p.encoder.(ParallelEncoder); ok {
+		parallel = max(pe.Parallelism(), 1)
+	}
+	sizer, _ := p.encoder.(BatchSizer)
+	queue := pending
+	n := 0 // batches stored
+
+	for len(queue) > 0 {
+		if err := p.err(); err != nil {
+			return err
 		}
-		batch := pending[i:end]
-
-		// Collect absolute paths for the ML sidecar
-		paths := make([]string, len(batch))
-		for j, f := range batch {
-			if filepath.IsAbs(f.FramePath) {
-				paths[j] = f.FramePath
-			} else {
-				paths[j] = filepath.Join(framesDir, filepath.Base(f.FramePath))
-			}
+		size := p.batchSize
+		if sizer != nil {
+			size = sizer.BatchSize(p.batchSize)
+		}
+		var window [][]models.FrameMetadata
+		for len(window) < parallel && len(queue) > 0 {
+			k := min(max(size, 1), len(queue))
+			window = append(window, queue[:k])
+			queue = queue[k:]
 		}
 
-		// 4. Encode images via ML sidecar
-		embeddings, err := p.mlClient.EncodeImages(paths)
-		if err != nil {
-			return fmt.Errorf("encoding batch %d: %w", i/p.batchSize, err)
+		// 4. Encode images via ML sidecar (or remote workers)
+		embeddings := make([][][]float64, len(window))
+		errs := make([]error, len(window))
+		var wg sync.WaitGroup
+		for k, batch := range window {
+			// Collect absolute paths for the ML sidecar
+			paths := make([]string, len(batch))
+			for j, f := range batch {
+				if filepath.IsAbs(f.FramePath) {
+					paths[j] = f.FramePath
+				} else {
+					paths[j] = filepath.Join(framesDir, filepath.Base(f.FramePath))
+				}
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				embeddings[k], errs[k] = p.encoder.EncodeImagesContext(p.context(), paths)
+			}()
+		}
+		wg.Wait()
+		if err := p.err(); err != nil {
+			return err
 		}
 
-		// 5. Store embeddings
-		for j, f := range batch {
-			id := frameID(f)
-			embBytes := Float64sToBytes(embeddings[j])
-			ts := f.Timestamp.Format(time.RFC3339)
+		var retry []models.FrameMetadata
+		for k, batch := range window {
+			if errs[k] != nil {
+				if sizer != nil && errors.Is(errs[k], ErrSidecarTimeout) && sizer.BatchSize(p.batchSize) < len(batch) {
+					retry = append(retry, batch...)
+					continue
+				}
+				return fmt.Errorf("encoding batch %d: %w", n, errs[k])
+			}
+
+			// 5. Store embeddings
+			stored := make([]IndexedFrame, len(batch))
+			for j, f := range batch {
+				id := frameID(f)
+				embBytes := Float64sToBytes(embeddings[k][j])
+				ts := FormatTimestamp(f.Timestamp)
+
+				if err := p.storage.AddClipEmbedding(id, embBytes,
+					f.CameraID, ts, f.FramePath, f.SourceVideo); err != nil {
+					return fmt.Errorf("storing embedding for %s: %w", id, err)
+				}
 
-			if err := p.storage.AddClipEmbedding(id, embBytes,
-				f.CameraID, ts, f.FramePath, f.SourceVideo); err != nil {
-				return fmt.Errorf("storing embedding for %s: %w", id, err)
+				state.IndexedFrames[id] = true
+				stored[j] = IndexedFrame{FrameMetadata: f, Embedding: embeddings[k][j]}
 			}
+			p.storage.indexed(stored)
 
-			state.IndexedFrames[id] = true
-		}
+			// 6. Save index state after each batch
+			state.LastUpdated = time.Now()
+			if err := saveIndexState(statePath, state); err != nil {
+				return fmt.Errorf("saving index state: %w", err)
+			}
 
-		// 6. Save index state after each batch
-		state.LastUpdated = time.Now()
-		if err := saveIndexState(statePath, state); err != nil {
-			return fmt.Errorf("saving index state: %w", err)
+			n++
+			done += len(batch)
+			if progress != nil {
+				progress <- ProgressEvent{
+					Stage:       "indexing",
+					CameraID:    cameraID,
+					FramesDone:  done,
+					FramesTotal: total,
+					Message:     fmt.Sprintf("batch %d complete (%d frames)", n, len(batch)),
+				}
+			}
 		}
-
-		done += len(batch)
-		if progress != nil {
-			progress <- ProgressEvent{
-				Stage:       "indexing",
-				CameraID:    cameraID,
-				FramesDone:  done,
-				FramesTotal: total,
-				Message:     fmt.Sprintf("batch %d complete", i/p.batchSize+1),
+		if len(retry) > 0 {
+			if progress != nil {
+				progress <- ProgressEvent{
+					Stage:       "indexing",
+					CameraID:    cameraID,
+					FramesDone:  done,
+					FramesTotal: total,
+					Message:     fmt.Sprintf("ML sidecar timed out; retrying %d frames in batches of %d", len(retry), sizer.BatchSize(p.batchSize)),
+				}
 			}
+			queue = append(retry, queue...)
 		}
 	}
 
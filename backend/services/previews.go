@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hover-scrub previews: thumbnails every few seconds of a video, tiled
+// into JPEG sprite sheets, with a WebVTT file mapping each time range to
+// its tile ("previews/sprite_001.jpg#xywh=160,0,160,90"). They are
+// generated on first request and cached under data/previews/{camera}/
+// {date}/{stem}/ until the video changes.
+const (
+	previewThumbWidth     = 160
+	previewColumns        = 10
+	previewRows           = 10
+	maxPreviewThumbs      = 300 // longer videos space their thumbnails further apart
+	minPreviewIntervalSec = 2
+
+	PreviewVTT = "previews.vtt"
+
+	posterMaxWidth = 640
+	// maxPosterJobs bounds concurrent poster extraction: a list of
+	// recordings asks for all its posters at once.
+	maxPosterJobs = 2
+)
+
+// Poster frames VideoPoster can take.
+const (
+	PosterFirst  = "first"
+	PosterMiddle = "middle"
+)
+
+// previewLocks serializes generation per cache directory, so concurrent
+// requests for the same video run ffmpeg once.
+var previewLocks sync.Map // cache dir → *sync.Mutex
+
+var posterJobs = make(chan struct{}, maxPosterJobs)
+
+func lockPreviews(cacheDir string) func() {
+	mu, _ := previewLocks.LoadOrStore(cacheDir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// PreviewSprites returns the WebVTT file of videoPath's previews in
+// cacheDir, generating the sprites first if they are missing or older than
+// the video.
+func PreviewSprites(ctx context.Context, videoPath, cacheDir string) (string, error) {
+	defer lockPreviews(cacheDir)()
+
+	video, err := os.Stat(videoPath)
+	if err != nil {
+		return "", newError(ErrVideoNotFound, "video not found")
+	}
+	vttPath := filepath.Join(cacheDir, PreviewVTT)
+	if vtt, err := os.Stat(vttPath); err == nil && !vtt.ModTime().Before(video.ModTime()) {
+		return vttPath, nil
+	}
+
+	probe, err := ProbeVideo(videoPath)
+	if err != nil {
+		return "", err
+	}
+	if probe.DurationSec <= 0 || probe.Width <= 0 || probe.Height <= 0 {
+		return "", fmt.Errorf("ffprobe reported no duration or size for %s", videoPath)
+	}
+	interval := math.Max(minPreviewIntervalSec, math.Ceil(probe.DurationSec/maxPreviewThumbs))
+	count := int(math.Ceil(probe.DurationSec / interval))
+	w := previewThumbWidth
+	h := int(math.Round(float64(w)*float64(probe.Height)/float64(probe.Width)/2)) * 2
+
+	// Build in a sibling directory and swap it in, so a failed run leaves
+	// the previous previews (or none) rather than half a set
+	tmp := cacheDir + ".tmp"
+	os.RemoveAll(tmp)
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return "", err
+	}
+	filter := fmt.Sprintf("fps=1/%s,scale=%d:%d,tile=%dx%d",
+		strconv.FormatFloat(interval, 'f', -1, 64), w, h, previewColumns, previewRows)
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-i", videoPath,
+		"-an", "-sn", "-vf", filter, "-q:v", "5", "-y", filepath.Join(tmp, "sprite_%03d.jpg"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("ffmpeg previews: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	perSheet := previewColumns * previewRows
+	for i := 0; i < count; i++ {
+		start := float64(i) * interval
+		end := math.Min(start+interval, probe.DurationSec)
+		tile := i % perSheet
+		fmt.Fprintf(&b, "\n%s --> %s\npreviews/sprite_%03d.jpg#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), i/perSheet+1,
+			(tile%previewColumns)*w, (tile/previewColumns)*h, w, h)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, PreviewVTT), []byte(b.String()), 0o644); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+
+	// Keep the posters; VideoPoster checks them against the video itself
+	posters, _ := filepath.Glob(filepath.Join(cacheDir, "poster_*.jpg"))
+	for _, p := range posters {
+		os.Rename(p, filepath.Join(tmp, filepath.Base(p)))
+	}
+	os.RemoveAll(cacheDir)
+	if err := os.Rename(tmp, cacheDir); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	return vttPath, nil
+}
+
+// VideoPoster returns the path of a JPEG poster of videoPath, at most
+// posterMaxWidth wide, kept in cacheDir beside its preview sprites: the
+// video's first frame, or the one halfway through, which is more often
+// representative. It is extracted on first request and again once the video
+// is newer.
+func VideoPoster(ctx context.Context, videoPath, cacheDir, frame string) (string, error) {
+	if frame != PosterFirst && frame != PosterMiddle {
+		return "", newError(ErrInvalidInput, "frame must be %s or %s, got %q", PosterFirst, PosterMiddle, frame)
+	}
+	defer lockPreviews(cacheDir)()
+
+	video, err := os.Stat(videoPath)
+	if err != nil {
+		return "", newError(ErrVideoNotFound, "video not found")
+	}
+	path := filepath.Join(cacheDir, "poster_"+frame+".jpg")
+	if poster, err := os.Stat(path); err == nil && !poster.ModTime().Before(video.ModTime()) {
+		return path, nil
+	}
+
+	var seek float64
+	if frame == PosterMiddle {
+		probe, err := ProbeVideo(videoPath)
+		if err != nil {
+			return "", err
+		}
+		seek = probe.DurationSec / 2
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", err
+	}
+	select {
+	case posterJobs <- struct{}{}:
+		defer func() { <-posterJobs }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	tmp := filepath.Join(cacheDir, "poster_"+frame+".tmp.jpg")
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error",
+		"-ss", strconv.FormatFloat(seek, 'f', 3, 64), "-i", videoPath,
+		"-frames:v", "1", "-vf", fmt.Sprintf("scale='min(%d,iw)':-2", posterMaxWidth),
+		"-q:v", "3", "-y", tmp)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("ffmpeg poster: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+// vttTimestamp formats seconds as a WebVTT timestamp, HH:MM:SS.mmm.
+func vttTimestamp(sec float64) string {
+	d := time.Duration(math.Round(sec*1000)) * time.Millisecond
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, d.Milliseconds()%1000)
+}
@@ -0,0 +1,670 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+)
+
+// ExtractionOptions are the frame extraction parameters used when processing
+// a camera+date. The server and the CLI both take them from the runtime
+// settings so the two paths produce the same frames.
+type ExtractionOptions struct {
+	IntervalSec    int
+	Quality        int
+	Dedup          bool
+	DedupThreshold int
+	// How videos without a start in their name are dated, and the zone
+	// their metadata times are turned into wall-clock time in
+	TimestampSource string
+	Location        *time.Location
+	// Hours limits the run to these hours of each date; nil for whole days
+	Hours *HourWindow
+}
+
+// HourWindow is the hours of each date a processing run covers, from the
+// start of hour Start up to hour End (1-24) on the cameras' wall clock, e.g.
+// 18-22 for 18:00 to 21:59:59.
+type HourWindow struct {
+	Start, End int
+}
+
+// NewHourWindow checks the start_hour and end_hour of a process request,
+// either of which may be nil for 0 and 24. It returns nil for a window
+// covering the whole day.
+func NewHourWindow(start, end *int) (*HourWindow, error) {
+	w := &HourWindow{Start: 0, End: 24}
+	if start != nil {
+		w.Start = *start
+	}
+	if end != nil {
+		w.End = *end
+	}
+	if w.Start < 0 || w.Start > 23 || w.End < 1 || w.End > 24 {
+		return nil, newError(ErrInvalidInput, "start_hour must be 0-23 and end_hour 1-24")
+	}
+	if w.End <= w.Start {
+		return nil, newError(ErrInvalidInput, "end_hour %d is not after start_hour %d", w.End, w.Start)
+	}
+	if w.Start == 0 && w.End == 24 {
+		return nil, nil
+	}
+	return w, nil
+}
+
+func (w *HourWindow) String() string {
+	return fmt.Sprintf("%02d:00-%02d:00", w.Start, w.End)
+}
+
+// Bounds returns the window on the day of date: from its start up to, not
+// including, its end.
+func (w *HourWindow) Bounds(date time.Time) (from, to time.Time) {
+	y, m, d := date.Date()
+	return time.Date(y, m, d, w.Start, 0, 0, 0, date.Location()), time.Date(y, m, d, w.End, 0, 0, 0, date.Location())
+}
+
+// ExtractionOptionsFromSettings reads the current extraction.* settings.
+func ExtractionOptionsFromSettings(settings *SettingsService) ExtractionOptions {
+	return ExtractionOptions{
+		IntervalSec:     settings.GetInt("extraction.time_interval_sec"),
+		Quality:         settings.GetInt("extraction.output_quality"),
+		Dedup:           settings.GetBool("extraction.dedup_enabled"),
+		DedupThreshold:  settings.GetInt("extraction.dedup_phash_threshold"),
+		TimestampSource: TimestampSource(settings),
+		Location:        settings.Location(),
+	}
+}
+
+// ProcessCameraDate extracts frames from the videos of cameraID/date that are
+// not yet recorded in the process history, indexes them and records the date
+// as processed. Progress (including a "skipped" event when there is nothing
+// new) is sent on events, which may be nil. With opts.Hours only the videos
+// recorded in those hours are extracted, and only their frames in them; a
+// video is recorded as processed when it lies wholly inside the window, so
+// a later run over the whole day still extracts the rest of the others.
+func ProcessCameraDate(cfg *config.AppConfig, pipeline *Pipeline, opts ExtractionOptions,
+	cameraID, date string, events chan<- ProgressEvent) error {
+	emit := func(ev ProgressEvent) {
+		if events != nil {
+			events <- ev
+		}
+	}
+
+	videosDir := filepath.Join(cfg.App.DataDir, "videos", cameraID, date)
+	framesDir := filepath.Join(cfg.Extraction.StoragePath, cameraID, date)
+
+	// Determine which videos still need processing
+	history := LoadProcessHistory(cfg.Process.HistoryPath)
+	videosToProcess := NewVideosForDate(history, cameraID, date, videosDir)
+	clock := VideoClock{
+		OffsetSec: cameraClockOffset(pipeline.storage.DB(), cameraID),
+		Source:    opts.TimestampSource,
+		Location:  opts.Location,
+	}
+
+	var from, to time.Time
+	var covered []string // videos wholly inside opts.Hours
+	if opts.Hours != nil && len(videosToProcess) > 0 {
+		loc := opts.Location
+		if loc == nil {
+			loc = time.Local
+		}
+		day, err := time.ParseInLocation("2006-01-02", date, loc)
+		if err != nil {
+			return fmt.Errorf("invalid date %q: %w", date, err)
+		}
+		from, to = opts.Hours.Bounds(day)
+		videosToProcess, covered = videosInWindow(videosDir, videosToProcess, day, clock, from, to)
+	}
+
+	if len(videosToProcess) == 0 {
+		msg := fmt.Sprintf("%s/%s already indexed", cameraID, date)
+		if opts.Hours != nil {
+			msg = fmt.Sprintf("%s/%s has nothing new in %s", cameraID, date, opts.Hours)
+		}
+		emit(ProgressEvent{
+			Stage:    "skipped",
+			CameraID: cameraID,
+			Date:     date,
+			Message:  msg,
+		})
+		return nil
+	}
+
+	// Step 1: Extract frames from new videos only
+	emit(ProgressEvent{
+		Stage:       "extracting",
+		CameraID:    cameraID,
+		Date:        date,
+		VideosTotal: len(videosToProcess),
+		Message:     fmt.Sprintf("extracting frames from %d video(s) for %s/%s", len(videosToProcess), cameraID, date),
+	})
+
+	// Load existing manifest (frames from previously processed videos)
+	existingFrames, _ := LoadManifest(framesDir)
+	var newFrames []models.FrameMetadata
+
+	for i, videoFile := range videosToProcess {
+		if err := pipeline.err(); err != nil {
+			return err
+		}
+		videoPath := filepath.Join(videosDir, videoFile)
+		frames, err := ExtractFramesTimeWindow(pipeline.context(), videoPath, framesDir, opts.IntervalSec, opts.Quality, clock, from, to)
+		if err := pipeline.err(); err != nil {
+			return err
+		}
+		extracted := ProgressEvent{
+			Stage:       "extracting",
+			CameraID:    cameraID,
+			Date:        date,
+			File:        videoFile,
+			VideosDone:  i + 1,
+			VideosTotal: len(videosToProcess),
+		}
+		if err != nil {
+			JobLogf(pipeline.context(), "extraction failed for %s: %v", videoPath, err)
+			extracted.Message = fmt.Sprintf("extraction failed for %s (%d/%d)", videoFile, i+1, len(videosToProcess))
+			emit(extracted)
+			continue
+		}
+
+		if opts.Dedup {
+			frames, _ = DeduplicateFrames(frames, opts.DedupThreshold)
+		}
+
+		newFrames = append(newFrames, frames...)
+		extracted.Message = fmt.Sprintf("extracted %d frames from %s (%d/%d)", len(frames), videoFile, i+1, len(videosToProcess))
+		emit(extracted)
+	}
+
+	// Merge with existing and write combined manifest
+	allFrames := MergeManifest(existingFrames, newFrames)
+	if opts.Hours != nil {
+		allFrames = append(allFrames, framesOutside(existingFrames, newFrames, from, to)...)
+	}
+	if err := WriteManifest(framesDir, allFrames); err != nil {
+		JobLogf(pipeline.context(), "writing manifest for %s/%s: %v", cameraID, date, err)
+	}
+
+	// Step 2: Index frames (pipeline handles incrementality via index_state.json)
+	manifest := filepath.Join(framesDir, "manifest.json")
+	if _, err := os.Stat(manifest); err != nil {
+		err := fmt.Errorf("no manifest for %s/%s after extraction", cameraID, date)
+		emit(ProgressEvent{Stage: "error", CameraID: cameraID, Date: date, Message: err.Error()})
+		return err
+	}
+
+	emit(ProgressEvent{
+		Stage:    "indexing",
+		CameraID: cameraID,
+		Date:     date,
+		Message:  fmt.Sprintf("indexing frames for %s/%s", cameraID, date),
+	})
+
+	if err := pipeline.IndexFrames(framesDir, events); err != nil {
+		if pipeline.err() != nil {
+			// Cancelled, not failed; the caller reports it
+			return err
+		}
+		JobLogf(pipeline.context(), "indexing failed for %s/%s: %v", cameraID, date, err)
+		emit(ProgressEvent{
+			Stage:    "error",
+			CameraID: cameraID,
+			Date:     date,
+			Message:  fmt.Sprintf("indexing failed: %v", err),
+		})
+		return fmt.Errorf("indexing %s/%s: %w", cameraID, date, err)
+	}
+
+	// Record all videos for this camera+date in process history, or with
+	// opts.Hours those wholly inside it
+	processed := ListVideoFiles(videosDir)
+	if opts.Hours != nil {
+		processed = append(processedVideos(history, cameraID, date), covered...)
+	}
+	AddProcessHistory(cfg.Process.HistoryPath, cameraID, date, processed)
+	return nil
+}
+
+// videosInWindow returns the videos of videosDir, of the day day, that
+// were recording between from and to, and of them those that lie wholly
+// inside it. A video whose length can't be read is taken to overlap but
+// not to lie inside.
+func videosInWindow(videosDir string, videos []string, day time.Time, clock VideoClock, from, to time.Time) (overlapping, inside []string) {
+	offset := time.Duration(clock.OffsetSec) * time.Second
+	for _, v := range videos {
+		path := filepath.Join(videosDir, v)
+		start := videoStart(path, day, clock).Add(offset)
+		if !start.Before(to) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		dur, err := videoDuration(path, info)
+		if err != nil {
+			overlapping = append(overlapping, v)
+			continue
+		}
+		end := start.Add(time.Duration(dur * float64(time.Second)))
+		if !end.After(from) {
+			continue
+		}
+		overlapping = append(overlapping, v)
+		if !start.Before(from) && !end.After(to) {
+			inside = append(inside, v)
+		}
+	}
+	return overlapping, inside
+}
+
+// framesOutside returns the frames of existing from the videos extracted
+// again into added that lie outside from-to, which MergeManifest dropped
+// but an extraction limited to the window left in place.
+func framesOutside(existing, added []models.FrameMetadata, from, to time.Time) []models.FrameMetadata {
+	videos := make(map[string]bool)
+	for _, f := range added {
+		videos[f.SourceVideo] = true
+	}
+	var out []models.FrameMetadata
+	for _, f := range existing {
+		if videos[f.SourceVideo] && (f.Timestamp.Before(from) || !f.Timestamp.Before(to)) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// processedVideos returns the videos the history records as processed for
+// cameraID/date.
+func processedVideos(history []models.ProcessHistoryEntry, cameraID, date string) []string {
+	for _, h := range history {
+		if h.CameraID == cameraID && h.Date == date {
+			return h.Videos
+		}
+	}
+	return nil
+}
+
+// DownloadFromNVR downloads recordings from the NVR for a hikvision camera
+// into data/videos/{camera}/{date}/. startTime and endTime are optional
+// "HH:MM" strings that constrain the query window on the first and last date
+// respectively; hours, if not nil, constrains it on every date. Returns the
+// number of recordings downloaded. Once ctx is done the download in progress
+// is aborted (its .tmp file removed) and no further recordings are fetched.
+// With resume set, for a job interrupted by a restart, recordings whose file
+// the interrupted run already wrote are not fetched again. Unless
+// videos.duplicates is off, a recording already stored under its start
+// minute with the same duration isn't fetched again either.
+func DownloadFromNVR(ctx context.Context, cfg *config.AppConfig, settings *SettingsService, cam *models.CameraInfo,
+	dates []string, startTime, endTime string, hours *HourWindow, resume bool, events chan<- ProgressEvent) int {
+	emit := func(ev ProgressEvent) {
+		if events != nil {
+			events <- ev
+		}
+	}
+
+	nvrIP := settings.Get("nvr.ip")
+	if nvrIP == "" {
+		emit(ProgressEvent{
+			Stage:    "error",
+			CameraID: cam.ID,
+			Message:  "NVR IP not configured in settings",
+		})
+		return 0
+	}
+	nvrUsername := settings.Get("nvr.username")
+	nvrPassword := settings.Get("nvr.password")
+
+	nvrClient := NewHikvisionClient(nvrIP, nvrUsername, nvrPassword).WithContext(ctx)
+
+	channel := NVRChannel(cam)
+	downloaded := 0
+
+	for i, date := range dates {
+		if ctx.Err() != nil {
+			return downloaded
+		}
+		dayStart, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			continue
+		}
+		dayEnd := dayStart.Add(24*time.Hour - time.Second)
+
+		// Apply time boundaries on the first and last dates
+		isFirst := i == 0
+		isLast := i == len(dates)-1
+		if isFirst {
+			if h, m, ok := parseHHMM(startTime); ok {
+				dayStart = dayStart.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute)
+			}
+		}
+		if isLast {
+			if h, m, ok := parseHHMM(endTime); ok {
+				dayEnd = time.Date(dayStart.Year(), dayStart.Month(), dayStart.Day(), h, m, 59, 0, dayStart.Location())
+				if !isFirst {
+					// For the last date (when it's a different day), use that date
+					lastDay, _ := time.Parse("2006-01-02", date)
+					dayEnd = time.Date(lastDay.Year(), lastDay.Month(), lastDay.Day(), h, m, 59, 0, lastDay.Location())
+				}
+			}
+		}
+		if hours != nil {
+			from, to := hours.Bounds(dayStart)
+			if from.After(dayStart) {
+				dayStart = from
+			}
+			if to = to.Add(-time.Second); to.Before(dayEnd) {
+				dayEnd = to
+			}
+			if dayEnd.Before(dayStart) {
+				continue
+			}
+		}
+
+		emit(ProgressEvent{
+			Stage:    "downloading",
+			CameraID: cam.ID,
+			Date:     date,
+			Message:  fmt.Sprintf("Searching recordings for %s on %s", cam.Name, date),
+		})
+
+		recordings, err := nvrClient.SearchRecordings(channel, dayStart, dayEnd)
+		if ctx.Err() != nil {
+			return downloaded
+		}
+		if err != nil {
+			JobLogf(ctx, "NVR search failed for %s/%s: %v", cam.ID, date, err)
+			emit(ProgressEvent{
+				Stage:    "error",
+				CameraID: cam.ID,
+				Date:     date,
+				Message:  fmt.Sprintf("NVR search failed for %s: %v", date, err),
+			})
+			continue
+		}
+
+		if len(recordings) == 0 {
+			emit(ProgressEvent{
+				Stage:    "downloading",
+				CameraID: cam.ID,
+				Date:     date,
+				Message:  fmt.Sprintf("No recordings found for %s on %s", cam.Name, date),
+			})
+			continue
+		}
+
+		videosDir := filepath.Join(cfg.App.DataDir, "videos", cam.ID, date)
+		os.MkdirAll(videosDir, 0o755)
+
+		// Clean up stale .tmp files from previous failed downloads
+		cleanTmpFiles(videosDir)
+
+		total := len(recordings)
+		skipDuplicates := DuplicateMode(settings) != DuplicatesOff
+		starts := make(map[string]int) // recordings so far per start minute
+		for i, rec := range recordings {
+			base := rec.StartTime.Format("1504")
+			filename := fmt.Sprintf("%s.mp4", base)
+			outputPath := filepath.Join(videosDir, filename)
+
+			// The interrupted run named the n-th recording of a minute
+			// HHMM_n.mp4, as below; a completed download was renamed from
+			// its .tmp file, so one that exists is whole
+			n := starts[base]
+			starts[base]++
+			if resume {
+				prev := filename
+				if n > 0 {
+					prev = fmt.Sprintf("%s_%d.mp4", base, n)
+				}
+				if _, err := os.Stat(filepath.Join(videosDir, prev)); err == nil {
+					emit(ProgressEvent{
+						Stage:       "downloading",
+						CameraID:    cam.ID,
+						Date:        date,
+						File:        prev,
+						VideosDone:  i + 1,
+						VideosTotal: total,
+						Message:     fmt.Sprintf("Already downloaded %s %s-%s (%d/%d)", cam.Name, rec.StartTime.Format("15:04"), rec.EndTime.Format("15:04"), i+1, total),
+					})
+					continue
+				}
+			}
+
+			if skipDuplicates {
+				if existing := DownloadedRecording(videosDir, rec); existing != "" {
+					emit(ProgressEvent{
+						Stage:       "downloading",
+						CameraID:    cam.ID,
+						Date:        date,
+						File:        filepath.Base(existing),
+						VideosDone:  i + 1,
+						VideosTotal: total,
+						Message:     fmt.Sprintf("Skipped %s %s-%s, already stored as %s (%d/%d)", cam.Name, rec.StartTime.Format("15:04"), rec.EndTime.Format("15:04"), filepath.Base(existing), i+1, total),
+					})
+					continue
+				}
+			}
+
+			// Handle filename collision: if file exists, try _1, _2, etc.
+			if _, err := os.Stat(outputPath); err == nil {
+				found := false
+				for j := 1; j <= 100; j++ {
+					candidate := filepath.Join(videosDir, fmt.Sprintf("%s_%d.mp4", base, j))
+					if _, err := os.Stat(candidate); os.IsNotExist(err) {
+						outputPath = candidate
+						filename = fmt.Sprintf("%s_%d.mp4", base, j)
+						found = true
+						break
+					}
+				}
+				if !found {
+					continue
+				}
+			}
+
+			emit(ProgressEvent{
+				Stage:       "downloading",
+				CameraID:    cam.ID,
+				Date:        date,
+				File:        filename,
+				VideosDone:  i,
+				VideosTotal: total,
+				Message:     fmt.Sprintf("Downloading %s %s-%s (%d/%d)...", cam.Name, rec.StartTime.Format("15:04"), rec.EndTime.Format("15:04"), i+1, total),
+			})
+
+			if err := nvrClient.DownloadClip(rec.PlaybackURI, outputPath); ctx.Err() != nil {
+				return downloaded
+			} else if err != nil {
+				JobLogf(ctx, "NVR download failed for %s: %v", filename, err)
+				emit(ProgressEvent{
+					Stage:    "error",
+					CameraID: cam.ID,
+					Date:     date,
+					File:     filename,
+					Message:  fmt.Sprintf("Download failed for %s: %v", filename, err),
+				})
+				continue
+			}
+
+			downloaded++
+		}
+	}
+	return downloaded
+}
+
+// parseHHMM parses an "HH:MM" string into hour and minute components.
+func parseHHMM(s string) (hour, minute int, ok bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, 0, false
+	}
+	return h, m, true
+}
+
+// cleanTmpFiles removes stale .tmp files from a directory.
+func cleanTmpFiles(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
+
+// Process history helpers
+
+// historyMu serializes read-modify-write cycles on the process history file,
+// which several jobs (or CLI workers) may update at once.
+var historyMu sync.Mutex
+
+// LoadProcessHistory reads the process history. A missing or unreadable file
+// is an empty history.
+func LoadProcessHistory(path string) []models.ProcessHistoryEntry {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var history []models.ProcessHistoryEntry
+	json.Unmarshal(data, &history)
+	return history
+}
+
+// ListVideoFiles returns basenames of .mp4 files in a directory, other than
+// its day archive (see ArchiveDate), whose recordings were processed before
+// they were merged into it.
+func ListVideoFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(strings.ToLower(e.Name()), ".mp4") || e.Name() == dayArchiveName {
+			continue
+		}
+		files = append(files, e.Name())
+	}
+	return files
+}
+
+// NewVideosForDate returns video basenames in videosDir that haven't been
+// processed yet according to the history. If the history entry for this
+// camera+date has no Videos list (legacy format), it is treated as fully
+// processed for backward compatibility.
+func NewVideosForDate(history []models.ProcessHistoryEntry, cameraID, date, videosDir string) []string {
+	allVideos := ListVideoFiles(videosDir)
+	if len(allVideos) == 0 {
+		return nil
+	}
+
+	// Find history entry
+	var processed []string
+	for _, h := range history {
+		if h.CameraID == cameraID && h.Date == date {
+			if len(h.Videos) == 0 {
+				// Legacy entry without video list — treat as fully processed
+				return nil
+			}
+			processed = h.Videos
+			break
+		}
+	}
+
+	processedSet := make(map[string]bool, len(processed))
+	for _, v := range processed {
+		processedSet[v] = true
+	}
+
+	var newVids []string
+	for _, v := range allVideos {
+		if !processedSet[v] {
+			newVids = append(newVids, v)
+		}
+	}
+	return newVids
+}
+
+// AddProcessHistory records videos as the processed set for cameraID/date.
+func AddProcessHistory(path, cameraID, date string, videos []string) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	history := LoadProcessHistory(path)
+
+	// Update existing entry or add new one
+	found := false
+	for i := range history {
+		if history[i].CameraID == cameraID && history[i].Date == date {
+			history[i].Videos = videos
+			history[i].IndexedAt = time.Now()
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		history = append(history, models.ProcessHistoryEntry{
+			CameraID:  cameraID,
+			Date:      date,
+			Videos:    videos,
+			IndexedAt: time.Now(),
+		})
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Printf("creating history directory: %v", err)
+		return
+	}
+
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		log.Printf("marshaling process history: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Printf("writing process history: %v", err)
+	}
+}
+
+// DateRange returns every date from start to end inclusive, as YYYY-MM-DD.
+func DateRange(start, end string) ([]string, error) {
+	startDate, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date: %w", err)
+	}
+	endDate, err := time.Parse("2006-01-02", end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end date: %w", err)
+	}
+
+	var dates []string
+	for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format("2006-01-02"))
+	}
+	return dates, nil
+}
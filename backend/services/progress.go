@@ -0,0 +1,186 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ProgressTask is one camera and date of a job's work. Download is set for
+// Hikvision cameras, whose recordings are fetched from the NVR first.
+type ProgressTask struct {
+	CameraID string
+	Date     string
+	Download bool
+}
+
+// Weights of a task's phases, for tasks with and without an NVR download.
+// Downloading an hour of recording takes about as long as extracting and
+// indexing it on a small box.
+var (
+	phaseWeights         = [3]float64{0.3, 0.4, 0.3} // download, extraction, indexing
+	phaseWeightsNoSource = [3]float64{0, 0.55, 0.45}
+)
+
+const (
+	phaseDownload = iota
+	phaseExtract
+	phaseIndex
+)
+
+// etaMinElapsed and etaMinFraction keep the ETA out of events until there is
+// enough history for a meaningful estimate.
+const (
+	etaMinElapsed  = 5 * time.Second
+	etaMinFraction = 0.01
+)
+
+type taskProgress struct {
+	ProgressTask
+	phase [3]float64 // fraction done of each phase
+}
+
+func (t *taskProgress) fraction() float64 {
+	w := phaseWeightsNoSource
+	if t.Download {
+		w = phaseWeights
+	}
+	return w[0]*t.phase[0] + w[1]*t.phase[1] + w[2]*t.phase[2]
+}
+
+// finishBefore marks every phase before p complete.
+func (t *taskProgress) finishBefore(p int) {
+	for i := range p {
+		t.phase[i] = 1
+	}
+}
+
+// JobProgress turns a job's progress events into an overall percent
+// complete and an ETA, so clients can show a progress bar rather than a
+// message log. Each task's phases are weighted by phaseWeights; within a
+// phase, progress comes from the videos or frames counts of its events.
+// Events of the indexing pipeline carry no date and count towards the task
+// the job is on.
+type JobProgress struct {
+	mu      sync.Mutex
+	tasks   []*taskProgress
+	current *taskProgress
+	start   time.Time // first event of actual work; zero until then
+	now     func() time.Time
+}
+
+func NewJobProgress(tasks []ProgressTask) *JobProgress {
+	p := &JobProgress{now: time.Now}
+	for _, t := range tasks {
+		p.tasks = append(p.tasks, &taskProgress{ProgressTask: t})
+	}
+	return p
+}
+
+// task finds the task of cameraID and date, falling back to the current one.
+func (p *JobProgress) task(cameraID, date string) *taskProgress {
+	if date == "" {
+		return p.current
+	}
+	for _, t := range p.tasks {
+		if t.CameraID == cameraID && t.Date == date {
+			return t
+		}
+	}
+	return p.current
+}
+
+// Update records ev and returns it with Percent and, once it can be
+// estimated, ETASec filled in.
+func (p *JobProgress) Update(ev ProgressEvent) ProgressEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.task(ev.CameraID, ev.Date)
+	switch ev.Stage {
+	case "downloading":
+		if t != nil {
+			if ev.VideosTotal > 0 {
+				t.phase[phaseDownload] = float64(ev.VideosDone) / float64(ev.VideosTotal)
+			} else if ev.BytesTotal > 0 {
+				t.phase[phaseDownload] = float64(ev.BytesDone) / float64(ev.BytesTotal)
+			}
+			// Recordings are fetched date by date before any is processed
+			for _, prev := range p.tasks {
+				if prev == t {
+					break
+				}
+				if prev.CameraID == t.CameraID {
+					prev.phase[phaseDownload] = 1
+				}
+			}
+		}
+	case "extracting":
+		if t != nil {
+			// Extraction starts once all of the camera's downloads are done
+			for _, other := range p.tasks {
+				if other.CameraID == t.CameraID {
+					other.phase[phaseDownload] = 1
+				}
+			}
+			if ev.VideosTotal > 0 {
+				t.phase[phaseExtract] = float64(ev.VideosDone) / float64(ev.VideosTotal)
+			}
+		}
+	case "indexing":
+		if t != nil {
+			t.finishBefore(phaseIndex)
+			if ev.FramesTotal > 0 {
+				t.phase[phaseIndex] = float64(ev.FramesDone) / float64(ev.FramesTotal)
+			}
+		}
+	case "complete", "skipped":
+		if t != nil {
+			t.finishBefore(len(t.phase))
+		}
+	case "error":
+		// A failed NVR search or download leaves the rest of the date to
+		// do; a later failure ends it
+		if t != nil && ev.Date != "" && (!t.Download || t.phase[phaseDownload] == 1) {
+			t.finishBefore(len(t.phase))
+		}
+	}
+	if t != nil && ev.Date != "" {
+		p.current = t
+	}
+
+	switch ev.Stage {
+	case "downloading", "extracting", "indexing":
+		if p.start.IsZero() {
+			p.start = p.now()
+		}
+	}
+
+	fraction := p.fraction()
+	ev.Percent = math.Round(fraction*1000) / 10
+	if elapsed := p.now().Sub(p.start); !p.start.IsZero() && elapsed >= etaMinElapsed &&
+		fraction >= etaMinFraction && fraction < 1 {
+		ev.ETASec = int(math.Ceil(elapsed.Seconds() * (1 - fraction) / fraction))
+	}
+	return ev
+}
+
+// Finish marks all the work done, e.g. before the job's final event.
+func (p *JobProgress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.tasks {
+		t.finishBefore(len(t.phase))
+	}
+}
+
+func (p *JobProgress) fraction() float64 {
+	if len(p.tasks) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, t := range p.tasks {
+		sum += t.fraction()
+	}
+	return sum / float64(len(p.tasks))
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReembedParams are the params of a reembed job: the CLIP model preset the
+// index is migrated to.
+type ReembedParams struct {
+	Preset string `json:"preset"`
+}
+
+// reembedTable holds the embeddings computed with the new model during a
+// migration; it takes the place of clip_embeddings once every frame has
+// one. Rows already in it are skipped, so a migration resumes where an
+// interrupted one stopped.
+const reembedTable = "clip_embeddings_next"
+
+// Reembed migrates the CLIP index in storage to the model of preset: the
+// frame of every embedding is encoded again with that model, loaded next
+// to the current one, into reembedTable while search keeps using the old
+// model and index. The frames indexed in the meantime are caught up, then
+// the encoder switches to preset and the new table replaces
+// clip_embeddings in one step, and the few frames indexed with the old
+// model since the catch-up are encoded again. Embeddings whose frame file
+// is gone are dropped. It returns how many frames it re-embedded.
+func Reembed(ctx context.Context, storage *Storage, encoder Encoder, storagePath, preset string,
+	batchSize int, emit func(ProgressEvent)) (int, error) {
+	db := storage.DB()
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + reembedTable + ` (
+    id           TEXT PRIMARY KEY,
+    embedding    BLOB NOT NULL,
+    camera_id    TEXT NOT NULL,
+    timestamp    TEXT NOT NULL,
+    frame_path   TEXT NOT NULL,
+    source_video TEXT NOT NULL,
+    created_at   TEXT NOT NULL DEFAULT (datetime('now'))
+)`); err != nil {
+		return 0, fmt.Errorf("creating %s: %w", reembedTable, err)
+	}
+
+	m := &reembedRun{db: db, encoder: encoder, storagePath: storagePath, preset: preset,
+		batchSize: max(batchSize, 1), emit: emit}
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + reembedTable).Scan(&m.done); err != nil {
+		return 0, err
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM clip_embeddings WHERE id NOT IN (SELECT id FROM " + reembedTable + ")").
+		Scan(&m.total); err != nil {
+		return 0, err
+	}
+	m.total += m.done
+	emit(ProgressEvent{Stage: "indexing", FramesDone: m.done, FramesTotal: m.total,
+		Message: fmt.Sprintf("re-embedding %d frames with %s", m.total, preset)})
+
+	if err := m.pass(ctx); err != nil {
+		return 0, err
+	}
+	// Frames indexed with the old model while the migration ran
+	if err := m.pass(ctx); err != nil {
+		return 0, err
+	}
+	current, err := encoder.GetModelInfo()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := encoder.ReloadModel(preset); err != nil {
+		return 0, fmt.Errorf("switching to %s: %w", preset, err)
+	}
+	stale, err := swapReembedTable(db)
+	if err != nil {
+		if _, rerr := encoder.ReloadModel(current.Preset); rerr != nil {
+			log.Printf("reembed: switching back to %s: %v", current.Preset, rerr)
+		}
+		return 0, err
+	}
+	// Search runs on the new model and index from here on; the rows copied
+	// over at the swap were still encoded with the old model
+	if err := m.refresh(ctx, stale); err != nil {
+		log.Printf("reembed: %d frames indexed during the switch keep embeddings of the old model: %v", len(stale), err)
+	}
+	if m.missing > 0 {
+		log.Printf("reembed: dropped %d embeddings whose frame file is gone", m.missing)
+	}
+	return m.done, nil
+}
+
+// DiscardReembed drops the embeddings of an unfinished migration, so the
+// next one starts over, e.g. for another preset.
+func DiscardReembed(storage *Storage) error {
+	_, err := storage.DB().Exec("DROP TABLE IF EXISTS " + reembedTable)
+	return err
+}
+
+// reembedRun is the state of one Reembed.
+type reembedRun struct {
+	db          *sql.DB
+	encoder     Encoder
+	storagePath string
+	preset      string
+	batchSize   int
+	emit        func(ProgressEvent)
+
+	done, total, missing int
+}
+
+// pass re-embeds, batch by batch, the frames of clip_embeddings that have
+// no row in reembedTable yet.
+func (m *reembedRun) pass(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ids, paths, err := m.nextBatch()
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if len(paths) == 0 {
+			continue // the whole batch had lost its frames
+		}
+		embeddings, err := m.encoder.EncodeImagesPreset(ctx, m.preset, paths)
+		if err != nil {
+			return fmt.Errorf("re-embedding frames: %w", err)
+		}
+		if len(embeddings) != len(ids) {
+			return fmt.Errorf("re-embedding frames: got %d embeddings for %d frames", len(embeddings), len(ids))
+		}
+		if err := m.store(ids, embeddings); err != nil {
+			return err
+		}
+		m.done += len(ids)
+		m.total = max(m.total, m.done)
+		m.emit(ProgressEvent{Stage: "indexing", FramesDone: m.done, FramesTotal: m.total,
+			Percent: 100 * float64(m.done) / float64(m.total),
+			Message: fmt.Sprintf("re-embedded %d/%d frames", m.done, m.total)})
+	}
+}
+
+// nextBatch returns up to a batch of embeddings still to migrate, with the
+// files of their frames: batchSize, or what an encoder tuning its batch
+// size picks. Those whose file is gone are deleted instead; the
+// result is empty once none is left.
+func (m *reembedRun) nextBatch() (ids, paths []string, err error) {
+	size := m.batchSize
+	if sizer, ok := m.encoder.(BatchSizer); ok {
+		size = sizer.BatchSize(size)
+	}
+	rows, err := m.db.Query("SELECT id, frame_path FROM clip_embeddings WHERE id NOT IN (SELECT id FROM "+
+		reembedTable+") ORDER BY id LIMIT ?", size)
+	if err != nil {
+		return nil, nil, err
+	}
+	var gone []string
+	for rows.Next() {
+		var id, framePath string
+		if err := rows.Scan(&id, &framePath); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		path := frameFile(m.storagePath, framePath)
+		if _, err := os.Stat(path); err != nil {
+			gone = append(gone, id)
+			continue
+		}
+		ids = append(ids, id)
+		paths = append(paths, path)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	for _, id := range gone {
+		if _, err := m.db.Exec("DELETE FROM clip_embeddings WHERE id = ?", id); err != nil {
+			return nil, nil, err
+		}
+	}
+	m.missing += len(gone)
+	m.total -= len(gone)
+	if len(ids) == 0 && len(gone) > 0 {
+		// Report a batch to go on with, not the end of the pass
+		return gone, nil, nil
+	}
+	return ids, paths, nil
+}
+
+// store writes the new embeddings of ids into reembedTable, with the rest
+// of their rows as they are in clip_embeddings.
+func (m *reembedRun) store(ids []string, embeddings [][]float64) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, id := range ids {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO `+reembedTable+`
+		(id, embedding, camera_id, timestamp, frame_path, source_video, created_at)
+		SELECT id, ?, camera_id, timestamp, frame_path, source_video, created_at
+		FROM clip_embeddings WHERE id = ?`, Float64sToBytes(embeddings[i]), id); err != nil {
+			return fmt.Errorf("storing embedding for %s: %w", id, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// swapReembedTable replaces clip_embeddings with reembedTable. Rows of
+// frames deleted, erased or collected since they were re-embedded go
+// first, so they don't come back; then the rows reembedTable lacks, frames
+// indexed since the last pass, are copied over. It returns the IDs of
+// those, whose embeddings are still of the old model.
+func swapReembedTable(db *sql.DB) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM " + reembedTable + " WHERE id NOT IN (SELECT id FROM clip_embeddings)"); err != nil {
+		return nil, fmt.Errorf("replacing clip_embeddings: %w", err)
+	}
+	rows, err := tx.Query("SELECT id FROM clip_embeddings WHERE id NOT IN (SELECT id FROM " + reembedTable + ")")
+	if err != nil {
+		return nil, fmt.Errorf("replacing clip_embeddings: %w", err)
+	}
+	var stale []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stale = append(stale, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{
+		`INSERT OR IGNORE INTO ` + reembedTable + `
+		(id, embedding, camera_id, timestamp, frame_path, source_video, created_at)
+		SELECT id, embedding, camera_id, timestamp, frame_path, source_video, created_at FROM clip_embeddings`,
+		"DROP TABLE clip_embeddings",
+		"ALTER TABLE " + reembedTable + " RENAME TO clip_embeddings",
+		"CREATE INDEX IF NOT EXISTS idx_clip_camera_ts ON clip_embeddings(camera_id, timestamp)",
+		"CREATE INDEX IF NOT EXISTS idx_clip_created ON clip_embeddings(created_at)",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("replacing clip_embeddings: %w", err)
+		}
+	}
+	return stale, tx.Commit()
+}
+
+// refresh encodes the frames of ids again with the encoder's current
+// model, now preset, updating their rows of clip_embeddings. Frames whose
+// file is gone keep their rows.
+func (m *reembedRun) refresh(ctx context.Context, ids []string) error {
+	for len(ids) > 0 {
+		batch := ids[:min(m.batchSize, len(ids))]
+		ids = ids[len(batch):]
+		var found, paths []string
+		for _, id := range batch {
+			var framePath string
+			err := m.db.QueryRow("SELECT frame_path FROM clip_embeddings WHERE id = ?", id).Scan(&framePath)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			path := frameFile(m.storagePath, framePath)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			found = append(found, id)
+			paths = append(paths, path)
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		embeddings, err := m.encoder.EncodeImagesContext(ctx, paths)
+		if err != nil {
+			return err
+		}
+		if len(embeddings) != len(found) {
+			return fmt.Errorf("got %d embeddings for %d frames", len(embeddings), len(found))
+		}
+		for i, id := range found {
+			if _, err := m.db.Exec("UPDATE clip_embeddings SET embedding = ? WHERE id = ?",
+				Float64sToBytes(embeddings[i]), id); err != nil {
+				return err
+			}
+		}
+		m.done += len(found)
+	}
+	return nil
+}
+
+// frameFile maps a stored frame_path, absolute or relative to the data
+// directory, to its file under storagePath.
+func frameFile(storagePath, framePath string) string {
+	if filepath.IsAbs(framePath) {
+		if _, err := os.Stat(framePath); err == nil {
+			return framePath
+		}
+	}
+	fp := filepath.ToSlash(framePath)
+	if idx := strings.Index(fp, "frames/"); idx >= 0 {
+		return filepath.Join(storagePath, filepath.FromSlash(fp[idx+len("frames/"):]))
+	}
+	return framePath
+}
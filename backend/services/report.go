@@ -0,0 +1,229 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+)
+
+// reportBusiestHours is how many hours a DailyReport names as the busiest.
+const reportBusiestHours = 3
+
+// ReportService builds the activity summaries of a workspace's days.
+type ReportService struct {
+	cfg        *config.AppConfig
+	db         *sql.DB
+	settings   *SettingsService
+	cameraSvc  *CameraService
+	encoder    Encoder
+	classes    *Classifier
+	enrichment *EnrichmentService
+}
+
+func NewReportService(cfg *config.AppConfig, db *sql.DB, settings *SettingsService, cameraSvc *CameraService, encoder Encoder, enrichment *EnrichmentService) *ReportService {
+	return &ReportService{cfg: cfg, db: db, settings: settings, cameraSvc: cameraSvc, encoder: encoder,
+		classes: NewClassifier(encoder, settings), enrichment: enrichment}
+}
+
+// Daily summarizes date (YYYY-MM-DD; empty for yesterday) of cameraID, or
+// of every camera when it is empty. The top classes need the encoder; when
+// it fails the rest of the report is still returned, with ClassesError set.
+// With enrichment on, the report gives the day's sun times and weather and
+// the light of each hour.
+func (s *ReportService) Daily(cameraID, date string) (*models.DailyReport, error) {
+	if date == "" {
+		date = s.settings.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	loc := s.settings.Location()
+	from, to, err := DayRange(date, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	var cameraIDs []string
+	if cameraID != "" {
+		if _, err := s.cameraSvc.Get(cameraID); err != nil {
+			return nil, err
+		}
+		cameraIDs = []string{cameraID}
+	} else {
+		cameras, err := s.cameraSvc.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, cam := range cameras {
+			cameraIDs = append(cameraIDs, cam.ID)
+		}
+	}
+
+	report := &models.DailyReport{
+		Date:     date,
+		CameraID: cameraID,
+		Cameras:  cameraIDs,
+		Alerts: models.ReportAlerts{
+			ByRule:   make(map[string]int),
+			ByStatus: make(map[string]int),
+		},
+		Hours:        make([]models.ReportHour, 24),
+		BusiestHours: []int{},
+		TopClasses:   []models.ReportClass{},
+	}
+	if report.Cameras == nil {
+		report.Cameras = []string{}
+	}
+	for h := range report.Hours {
+		report.Hours[h].Hour = h
+	}
+	if report.Context, err = s.enrichment.Day(date); err != nil {
+		return nil, err
+	}
+	for h, light := range s.enrichment.HourLights(date) {
+		report.Hours[h].Light = light
+	}
+
+	for _, id := range cameraIDs {
+		s.addFootage(report, id, date, loc)
+	}
+	if len(cameraIDs) == 0 {
+		return report, nil
+	}
+
+	inCameras := "camera_id IN (?" + strings.Repeat(", ?", len(cameraIDs)-1) + ")"
+	var args []any
+	for _, id := range cameraIDs {
+		args = append(args, id)
+	}
+	dayArgs := slices.Concat(args, []any{from, to})
+
+	var faceBytes int64
+	if err := s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(length(embedding)), 0) FROM face_embeddings WHERE "+
+		inCameras+" AND timestamp >= ? AND timestamp <= ?", dayArgs...).Scan(&report.Faces, &faceBytes); err != nil {
+		return nil, fmt.Errorf("counting faces: %w", err)
+	}
+	report.Storage.EmbeddingBytes += faceBytes
+
+	if err := s.addAlerts(report, inCameras, slices.Concat(args, []any{date}), loc); err != nil {
+		return nil, err
+	}
+	if err := s.addClasses(report, inCameras, dayArgs); err != nil {
+		return nil, err
+	}
+
+	report.BusiestHours = busiestHours(report.Hours)
+	report.Storage.TotalBytes = report.Storage.VideoBytes + report.Storage.FrameBytes + report.Storage.EmbeddingBytes
+	return report, nil
+}
+
+// addFootage adds the recordings and extracted frames of one camera's day.
+func (s *ReportService) addFootage(report *models.DailyReport, cameraID, date string, loc *time.Location) {
+	videoDir := filepath.Join(s.cfg.App.DataDir, "videos", cameraID, date)
+	frameDir := filepath.Join(s.cfg.Extraction.StoragePath, cameraID, date)
+	if videos, err := filepath.Glob(filepath.Join(videoDir, "*.mp4")); err == nil {
+		report.Videos += len(videos)
+	}
+	report.Storage.VideoBytes += dirSize(videoDir)
+	report.Storage.FrameBytes += dirSize(frameDir)
+
+	frames, err := LoadManifest(frameDir)
+	if err != nil {
+		return // not processed
+	}
+	report.FramesExtracted += len(frames)
+	for _, f := range frames {
+		report.Hours[f.Timestamp.In(loc).Hour()].Frames++
+	}
+}
+
+// addAlerts counts the alerts of the day, by rule, status and hour. Alerts
+// without a timestamp, such as those raised before monitors set one, have
+// no hour.
+func (s *ReportService) addAlerts(report *models.DailyReport, inCameras string, args []any, loc *time.Location) error {
+	rows, err := s.db.Query("SELECT rule, status, timestamp FROM alerts WHERE "+inCameras+" AND date = ?", args...)
+	if err != nil {
+		return fmt.Errorf("querying alerts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rule, status, ts string
+		if err := rows.Scan(&rule, &status, &ts); err != nil {
+			return fmt.Errorf("scanning alert: %w", err)
+		}
+		report.Alerts.Total++
+		report.Alerts.ByRule[rule]++
+		report.Alerts.ByStatus[status]++
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			report.Hours[t.In(loc).Hour()].Alerts++
+		}
+	}
+	return rows.Err()
+}
+
+// addClasses counts the day's indexed frames and sorts each into the
+// reports.classes class its CLIP embedding is closest to, if it is at
+// least search.min_score close; frames close to none aren't counted.
+func (s *ReportService) addClasses(report *models.DailyReport, inCameras string, args []any) error {
+	classes, prompts, classErr := s.classes.Prompts()
+	if classErr != nil {
+		report.ClassesError = classErr.Error()
+	}
+	minScore := s.settings.GetFloat64("search.min_score")
+
+	rows, err := s.db.Query("SELECT embedding FROM clip_embeddings WHERE "+inCameras+
+		" AND timestamp >= ? AND timestamp <= ?", args...)
+	if err != nil {
+		return fmt.Errorf("querying embeddings: %w", err)
+	}
+	defer rows.Close()
+	counts := make([]int, len(classes))
+	for rows.Next() {
+		var blob []byte
+		if err := rows.Scan(&blob); err != nil {
+			return fmt.Errorf("scanning embedding row: %w", err)
+		}
+		report.FramesIndexed++
+		report.Storage.EmbeddingBytes += int64(len(blob))
+		if len(prompts) == 0 {
+			continue
+		}
+		if best := ClassOf(BytesToFloat64s(blob), prompts, minScore); best >= 0 {
+			counts[best]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading embeddings: %w", err)
+	}
+
+	for i, class := range classes {
+		if counts[i] > 0 {
+			report.TopClasses = append(report.TopClasses, models.ReportClass{Class: class, Frames: counts[i]})
+		}
+	}
+	sort.SliceStable(report.TopClasses, func(i, j int) bool {
+		return report.TopClasses[i].Frames > report.TopClasses[j].Frames
+	})
+	return nil
+}
+
+// busiestHours returns the hours with the most frames, most first, leaving
+// out hours without any.
+func busiestHours(hours []models.ReportHour) []int {
+	sorted := make([]models.ReportHour, 0, len(hours))
+	for _, h := range hours {
+		if h.Frames > 0 {
+			sorted = append(sorted, h)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Frames > sorted[j].Frames })
+	busiest := []int{}
+	for _, h := range sorted[:min(len(sorted), reportBusiestHours)] {
+		busiest = append(busiest, h.Hour)
+	}
+	return busiest
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+// ProcessWindow is the time of day processing jobs may start in, from the
+// process.window_start and process.window_end settings, so heavy NVR
+// downloads and indexing stay out of the hours the system is watched and
+// searched. An end before the start wraps past midnight (22:00-06:00).
+type ProcessWindow struct {
+	Start, End string         // HH:MM; the window is always open unless both are set
+	Location   *time.Location // zone of Start and End; nil is the local zone
+}
+
+// ProcessWindowFromSettings reads the processing window settings, in the
+// general.timezone zone.
+func ProcessWindowFromSettings(settings *SettingsService) ProcessWindow {
+	return ProcessWindow{
+		Start:    settings.Get("process.window_start"),
+		End:      settings.Get("process.window_end"),
+		Location: settings.Location(),
+	}
+}
+
+// in converts t to the window's zone.
+func (w ProcessWindow) in(t time.Time) time.Time {
+	if w.Location == nil {
+		return t.Local()
+	}
+	return t.In(w.Location)
+}
+
+// Enabled reports whether the window restricts anything.
+func (w ProcessWindow) Enabled() bool {
+	return w.Start != "" && w.End != "" && w.Start != w.End
+}
+
+// Open reports whether t falls inside the window.
+func (w ProcessWindow) Open(t time.Time) bool {
+	if !w.Enabled() {
+		return true
+	}
+	// Zero-padded HH:MM strings compare in time order
+	now := w.in(t).Format("15:04")
+	if w.Start < w.End {
+		return now >= w.Start && now < w.End
+	}
+	return now >= w.Start || now < w.End
+}
+
+// NextOpen returns when the window next opens, in its zone, or t if it is
+// open.
+func (w ProcessWindow) NextOpen(t time.Time) time.Time {
+	if w.Open(t) {
+		return t
+	}
+	t = w.in(t)
+	start, _ := time.Parse("15:04", w.Start)
+	open := time.Date(t.Year(), t.Month(), t.Day(), start.Hour(), start.Minute(), 0, 0, t.Location())
+	if !open.After(t) {
+		open = open.AddDate(0, 0, 1)
+	}
+	return open
+}
+
+func (w ProcessWindow) String() string {
+	return w.Start + "-" + w.End
+}
+
+// WaitForProcessWindow blocks until the window returned by window is open
+// or ctx is done. The window is re-read every minute, so a changed setting
+// applies to jobs already waiting.
+func WaitForProcessWindow(ctx context.Context, window func() ProcessWindow) error {
+	for {
+		now := time.Now()
+		next := window().NextOpen(now)
+		if !next.After(now) {
+			return nil
+		}
+		timer := time.NewTimer(min(next.Sub(now), time.Minute))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/intelsk/backend/models"
+)
+
+// Scheduler runs the schedules of a workspace: each time a schedule's cron
+// expression fires, in the general.timezone zone, it submits a processing
+// job (NVR download, extraction and indexing) for the previous day of its
+// camera, or of every camera. Schedules are checked once a minute; one
+// missed while the server was down runs once when it starts again.
+type Scheduler struct {
+	db       *sql.DB
+	settings *SettingsService
+	cameras  *CameraService
+	jobs     *JobManager
+
+	// mu serializes runs, so a schedule never starts twice for one firing
+	mu sync.Mutex
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewScheduler(db *sql.DB, settings *SettingsService, cameras *CameraService, jobs *JobManager) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		settings: settings,
+		cameras:  cameras,
+		jobs:     jobs,
+		stop:     make(chan struct{}),
+	}
+}
+
+const scheduleColumns = `id, camera_id, cron, enabled, last_run_at, last_job_id, created_at, updated_at`
+
+// scanSchedule reads a schedules row and fills in its next run.
+func (s *Scheduler) scanSchedule(row interface{ Scan(...any) error }) (models.Schedule, error) {
+	var sch models.Schedule
+	var lastRunAt sql.NullString
+	var createdAt, updatedAt string
+	if err := row.Scan(&sch.ID, &sch.CameraID, &sch.Cron, &sch.Enabled, &lastRunAt,
+		&sch.LastJobID, &createdAt, &updatedAt); err != nil {
+		return sch, err
+	}
+	sch.CreatedAt, _ = time.Parse(jobTimeLayout, createdAt)
+	sch.UpdatedAt, _ = time.Parse(jobTimeLayout, updatedAt)
+	if lastRunAt.Valid {
+		t, _ := time.Parse(jobTimeLayout, lastRunAt.String)
+		sch.LastRunAt = &t
+	}
+	if next := s.nextRun(sch); !next.IsZero() {
+		sch.NextRunAt = &next
+	}
+	return sch, nil
+}
+
+// nextRun returns when an enabled schedule fires next. It counts from its
+// last run, or from its last change so an edit doesn't fire for times
+// before it; a time in the past means the run is due.
+func (s *Scheduler) nextRun(sch models.Schedule) time.Time {
+	if !sch.Enabled {
+		return time.Time{}
+	}
+	cron, err := ParseCron(sch.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	from := sch.UpdatedAt
+	if sch.LastRunAt != nil && sch.LastRunAt.After(from) {
+		from = *sch.LastRunAt
+	}
+	next := cron.Next(from.In(s.settings.Location()))
+	if next.IsZero() {
+		return next
+	}
+	return next.UTC()
+}
+
+// List returns the schedules, oldest first.
+func (s *Scheduler) List() ([]models.Schedule, error) {
+	rows, err := s.db.Query("SELECT " + scheduleColumns + " FROM schedules ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("querying schedules: %w", err)
+	}
+	defer rows.Close()
+
+	schedules := []models.Schedule{}
+	for rows.Next() {
+		sch, err := s.scanSchedule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning schedule row: %w", err)
+		}
+		schedules = append(schedules, sch)
+	}
+	return schedules, rows.Err()
+}
+
+// Get returns a single schedule.
+func (s *Scheduler) Get(id string) (models.Schedule, error) {
+	sch, err := s.scanSchedule(s.db.QueryRow("SELECT "+scheduleColumns+" FROM schedules WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return sch, newError(ErrScheduleNotFound, "schedule %s not found", id)
+	}
+	if err != nil {
+		return sch, fmt.Errorf("querying schedule %s: %w", id, err)
+	}
+	return sch, nil
+}
+
+// validate checks a schedule's cron expression and camera.
+func (s *Scheduler) validate(cameraID, cron string) error {
+	if _, err := ParseCron(cron); err != nil {
+		return err
+	}
+	if cameraID != "" {
+		if _, err := s.cameras.Get(cameraID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create adds a schedule. It is enabled unless req says otherwise.
+func (s *Scheduler) Create(req models.CreateScheduleRequest) (models.Schedule, error) {
+	if err := s.validate(req.CameraID, req.Cron); err != nil {
+		return models.Schedule{}, err
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+
+	id := uuid.New().String()
+	now := time.Now().UTC().Format(jobTimeLayout)
+	if _, err := s.db.Exec(`INSERT INTO schedules (id, camera_id, cron, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, id, req.CameraID, req.Cron, enabled, now, now); err != nil {
+		return models.Schedule{}, fmt.Errorf("inserting schedule: %w", err)
+	}
+	return s.Get(id)
+}
+
+// Update changes the fields set in req.
+func (s *Scheduler) Update(id string, req models.UpdateScheduleRequest) (models.Schedule, error) {
+	sch, err := s.Get(id)
+	if err != nil {
+		return sch, err
+	}
+	if req.CameraID != nil {
+		sch.CameraID = *req.CameraID
+	}
+	if req.Cron != nil {
+		sch.Cron = *req.Cron
+	}
+	if req.Enabled != nil {
+		sch.Enabled = *req.Enabled
+	}
+	if err := s.validate(sch.CameraID, sch.Cron); err != nil {
+		return sch, err
+	}
+
+	if _, err := s.db.Exec("UPDATE schedules SET camera_id = ?, cron = ?, enabled = ?, updated_at = ? WHERE id = ?",
+		sch.CameraID, sch.Cron, sch.Enabled, time.Now().UTC().Format(jobTimeLayout), id); err != nil {
+		return sch, fmt.Errorf("updating schedule: %w", err)
+	}
+	return s.Get(id)
+}
+
+// Delete removes a schedule. Jobs it already started keep running.
+func (s *Scheduler) Delete(id string) error {
+	res, err := s.db.Exec("DELETE FROM schedules WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting schedule: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newError(ErrScheduleNotFound, "schedule %s not found", id)
+	}
+	return nil
+}
+
+// RunNow runs a schedule immediately, enabled or not, and returns the job it
+// started. It is ErrBusy while the schedule's previous job is still running.
+func (s *Scheduler) RunNow(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sch, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.run(sch, time.Now())
+}
+
+// run submits the processing job of one firing of sch and records it as the
+// schedule's last run. It returns no job when there is nothing to process.
+func (s *Scheduler) run(sch models.Schedule, now time.Time) (*Job, error) {
+	if sch.LastJobID != "" {
+		if prev, err := s.jobs.Get(sch.LastJobID); err == nil && (prev.Status == "running" || prev.Status == "queued") {
+			return nil, newError(ErrBusy, "schedule %s: previous job %s is still %s", sch.ID, prev.ID, prev.Status)
+		}
+	}
+
+	var cameraIDs []string
+	if sch.CameraID != "" {
+		cameraIDs = []string{sch.CameraID}
+	} else {
+		cameras, err := s.cameras.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, cam := range cameras {
+			cameraIDs = append(cameraIDs, cam.ID)
+		}
+	}
+
+	var job *Job
+	if len(cameraIDs) > 0 {
+		yesterday := now.In(s.settings.Location()).AddDate(0, 0, -1).Format("2006-01-02")
+		var err error
+		job, err = s.jobs.Submit(JobTypeProcess, models.ProcessRequest{
+			CameraIDs: cameraIDs,
+			StartDate: yesterday,
+			EndDate:   yesterday,
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Schedule %s (%s): started job %s for %v on %s", sch.ID, sch.Cron, job.ID, cameraIDs, yesterday)
+	} else {
+		log.Printf("Schedule %s (%s): no cameras to process", sch.ID, sch.Cron)
+	}
+
+	jobID := sch.LastJobID
+	if job != nil {
+		jobID = job.ID
+	}
+	if _, err := s.db.Exec("UPDATE schedules SET last_run_at = ?, last_job_id = ? WHERE id = ?",
+		now.UTC().Format(jobTimeLayout), jobID, sch.ID); err != nil {
+		log.Printf("recording run of schedule %s: %v", sch.ID, err)
+	}
+	return job, nil
+}
+
+// tick runs every enabled schedule that is due.
+func (s *Scheduler) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules, err := s.List()
+	if err != nil {
+		log.Printf("checking schedules: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, sch := range schedules {
+		if sch.NextRunAt == nil || sch.NextRunAt.After(now) {
+			continue
+		}
+		// A schedule whose previous job is still running is retried on the
+		// next tick, so the day is processed late rather than never
+		if _, err := s.run(sch, now); err != nil && !errors.Is(err, ErrBusy) {
+			log.Printf("Schedule %s (%s): %v", sch.ID, sch.Cron, err)
+		}
+	}
+}
+
+// Start checks the schedules every minute until Close.
+func (s *Scheduler) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			s.tick()
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the scheduler. Jobs it started are not affected.
+func (s *Scheduler) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
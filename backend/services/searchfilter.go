@@ -0,0 +1,390 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+)
+
+// maxFilterConditions bounds the conditions of one search filter.
+const maxFilterConditions = 64
+
+// filterFields are the fields a search filter condition may test, with the
+// ops each takes.
+var filterFields = map[string][]string{
+	"camera":     {"eq", "in"},
+	"camera_tag": {"eq", "in"},
+	"weekday":    {"eq", "in"},
+	"class":      {"eq", "in"},
+	"person":     {"eq", "in"},
+	"hour":       {"eq", "in", "gte", "gt", "lte", "lt"},
+	"score":      {"gte", "gt", "lte", "lt"},
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// SearchFilter is a checked models.SearchFilter, ready to Apply.
+type SearchFilter struct {
+	root    *filterNode
+	classes bool     // tests the frames' class
+	tags    bool     // tests the cameras' tags
+	persons []string // persons tested for
+}
+
+type filterNode struct {
+	and, or []*filterNode
+	not     *filterNode
+	field   string
+	op      string
+	strs    []string  // values of string fields
+	nums    []float64 // values of numeric fields
+}
+
+// frameFacts is what the conditions of a filter test a result against.
+type frameFacts struct {
+	result  models.SearchResult
+	time    time.Time // in general.timezone
+	tags    []string
+	class   string
+	persons map[string]bool
+}
+
+// SearchFilterService narrows search results with search filters: by
+// camera and its config's tags, by the weekday and hour of the frame on
+// the cameras' wall clock, by its reports.classes object class, by the
+// enrolled persons whose faces it shows, and by score.
+type SearchFilterService struct {
+	db       *sql.DB
+	settings *SettingsService
+	cameras  *CameraService
+	classes  *Classifier
+	faces    *FaceService
+}
+
+func NewSearchFilterService(cfg *config.AppConfig, storage *Storage, settings *SettingsService, cameras *CameraService, encoder Encoder) *SearchFilterService {
+	return &SearchFilterService{
+		db:       storage.DB(),
+		settings: settings,
+		cameras:  cameras,
+		classes:  NewClassifier(encoder, settings),
+		faces:    NewFaceService(cfg, storage, nil),
+	}
+}
+
+// Parse checks f: its fields, ops and values, that the classes it names
+// are in reports.classes and that the persons are enrolled.
+func (s *SearchFilterService) Parse(f *models.SearchFilter) (*SearchFilter, error) {
+	sf := &SearchFilter{}
+	n := 0
+	root, err := s.parseNode(f, sf, &n)
+	if err != nil {
+		return nil, err
+	}
+	sf.root = root
+	if len(sf.persons) > 0 {
+		reg, err := s.faces.loadRegistry()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range sf.persons {
+			if p := reg.People[name]; p == nil || len(p.Embeddings) == 0 {
+				return nil, newError(ErrInvalidInput, "filter: person %q is not enrolled", name)
+			}
+		}
+	}
+	return sf, nil
+}
+
+func (s *SearchFilterService) parseNode(f *models.SearchFilter, sf *SearchFilter, n *int) (*filterNode, error) {
+	set := 0
+	for _, ok := range []bool{f.And != nil, f.Or != nil, f.Not != nil, f.Field != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, newError(ErrInvalidInput, "filter: each filter needs exactly one of and, or, not and field")
+	}
+
+	node := &filterNode{}
+	switch {
+	case f.And != nil || f.Or != nil:
+		subs := f.And
+		if f.Or != nil {
+			subs = f.Or
+		}
+		if len(subs) == 0 {
+			return nil, newError(ErrInvalidInput, "filter: and/or needs at least one filter")
+		}
+		for i := range subs {
+			sub, err := s.parseNode(&subs[i], sf, n)
+			if err != nil {
+				return nil, err
+			}
+			if f.And != nil {
+				node.and = append(node.and, sub)
+			} else {
+				node.or = append(node.or, sub)
+			}
+		}
+		return node, nil
+	case f.Not != nil:
+		sub, err := s.parseNode(f.Not, sf, n)
+		if err != nil {
+			return nil, err
+		}
+		node.not = sub
+		return node, nil
+	}
+
+	if *n++; *n > maxFilterConditions {
+		return nil, newError(ErrInvalidInput, "filter: at most %d conditions", maxFilterConditions)
+	}
+	ops, ok := filterFields[f.Field]
+	if !ok {
+		return nil, newError(ErrInvalidInput, "filter: unknown field %q", f.Field)
+	}
+	node.field, node.op = f.Field, f.Op
+	if node.op == "" {
+		node.op = "eq"
+	}
+	if !slices.Contains(ops, node.op) {
+		return nil, newError(ErrInvalidInput, "filter: %s takes %s, not %q", f.Field, strings.Join(ops, ", "), node.op)
+	}
+	if err := parseFilterValue(node, f.Value); err != nil {
+		return nil, err
+	}
+
+	switch node.field {
+	case "weekday":
+		for i, d := range node.strs {
+			d = strings.ToLower(d)
+			if len(d) > 3 {
+				d = d[:3]
+			}
+			if _, ok := weekdays[d]; !ok {
+				return nil, newError(ErrInvalidInput, "filter: %q is not a weekday (mon..sun)", node.strs[i])
+			}
+			node.strs[i] = d
+		}
+	case "hour":
+		for _, h := range node.nums {
+			if h < 0 || h > 23 {
+				return nil, newError(ErrInvalidInput, "filter: hour must be 0-23, got %v", h)
+			}
+		}
+	case "class":
+		classes := s.classes.Classes()
+		for _, c := range node.strs {
+			if !slices.Contains(classes, c) {
+				return nil, newError(ErrInvalidInput, "filter: class %q is not in reports.classes (%s)", c, strings.Join(classes, ", "))
+			}
+		}
+		sf.classes = true
+	case "camera_tag":
+		sf.tags = true
+	case "person":
+		for _, p := range node.strs {
+			if !slices.Contains(sf.persons, p) {
+				sf.persons = append(sf.persons, p)
+			}
+		}
+	}
+	return node, nil
+}
+
+// parseFilterValue reads a condition's value: a string or number, or a
+// non-empty list of them for "in".
+func parseFilterValue(node *filterNode, value json.RawMessage) error {
+	numeric := node.field == "hour" || node.field == "score"
+	kind := "a string"
+	if numeric {
+		kind = "a number"
+	}
+	value = bytes.TrimSpace(value)
+	if node.op == "in" {
+		var err error
+		if numeric {
+			err = json.Unmarshal(value, &node.nums)
+		} else {
+			err = json.Unmarshal(value, &node.strs)
+		}
+		if err != nil || len(node.nums)+len(node.strs) == 0 {
+			return newError(ErrInvalidInput, "filter: %s in needs a list of at least one value, each %s", node.field, kind)
+		}
+		return nil
+	}
+	if numeric {
+		var v float64
+		if err := json.Unmarshal(value, &v); err != nil || len(value) == 0 {
+			return newError(ErrInvalidInput, "filter: %s %s needs %s", node.field, node.op, kind)
+		}
+		node.nums = []float64{v}
+		return nil
+	}
+	var v string
+	if err := json.Unmarshal(value, &v); err != nil || v == "" {
+		return newError(ErrInvalidInput, "filter: %s %s needs %s", node.field, node.op, kind)
+	}
+	node.strs = []string{v}
+	return nil
+}
+
+// Apply returns the results f matches, in their order. opts bound the
+// face search run for person conditions to the search's cameras and time.
+func (s *SearchFilterService) Apply(f *SearchFilter, results []models.SearchResult, opts FaceSearchOptions) ([]models.SearchResult, error) {
+	if len(results) == 0 {
+		return results, nil
+	}
+	loc := s.settings.Location()
+
+	tags := make(map[string][]string)
+	if f.tags {
+		cams, err := s.cameras.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cams {
+			tags[c.ID] = CameraTags(c.Config)
+		}
+	}
+
+	classes := make(map[string]string)
+	if f.classes {
+		names, prompts, err := s.classes.Prompts()
+		if err != nil {
+			return nil, err
+		}
+		minScore := s.settings.GetFloat64("search.min_score")
+		ids := make([]string, len(results))
+		for i, r := range results {
+			ids[i] = r.ID
+		}
+		for chunk := range slices.Chunk(ids, 500) {
+			args := make([]any, len(chunk))
+			for i, id := range chunk {
+				args[i] = id
+			}
+			rows, err := s.db.Query("SELECT id, embedding FROM clip_embeddings WHERE id IN (?"+strings.Repeat(", ?", len(chunk)-1)+")", args...)
+			if err != nil {
+				return nil, fmt.Errorf("querying embeddings: %w", err)
+			}
+			for rows.Next() {
+				var id string
+				var blob []byte
+				if err := rows.Scan(&id, &blob); err != nil {
+					rows.Close()
+					return nil, fmt.Errorf("scanning embedding row: %w", err)
+				}
+				if best := ClassOf(BytesToFloat64s(blob), prompts, minScore); best >= 0 {
+					classes[id] = names[best]
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return nil, fmt.Errorf("reading embeddings: %w", err)
+			}
+		}
+	}
+
+	// Frames by the persons whose faces they show
+	persons := make(map[string]map[string]bool)
+	for _, name := range f.persons {
+		opts.Limit = 0
+		faces, err := s.faces.SearchPerson(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, face := range faces {
+			if persons[face.FramePath] == nil {
+				persons[face.FramePath] = make(map[string]bool)
+			}
+			persons[face.FramePath][name] = true
+		}
+	}
+
+	out := make([]models.SearchResult, 0, len(results))
+	for _, r := range results {
+		t, err := time.Parse(time.RFC3339, r.Timestamp)
+		if err != nil {
+			continue
+		}
+		facts := &frameFacts{
+			result:  r,
+			time:    t.In(loc),
+			tags:    tags[r.CameraID],
+			class:   classes[r.ID],
+			persons: persons[r.FramePath],
+		}
+		if f.root.match(facts) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (n *filterNode) match(f *frameFacts) bool {
+	switch {
+	case n.and != nil:
+		for _, sub := range n.and {
+			if !sub.match(f) {
+				return false
+			}
+		}
+		return true
+	case n.or != nil:
+		for _, sub := range n.or {
+			if sub.match(f) {
+				return true
+			}
+		}
+		return false
+	case n.not != nil:
+		return !n.not.match(f)
+	}
+
+	switch n.field {
+	case "camera":
+		return slices.Contains(n.strs, f.result.CameraID)
+	case "camera_tag":
+		return slices.ContainsFunc(n.strs, func(t string) bool { return slices.Contains(f.tags, t) })
+	case "weekday":
+		return slices.ContainsFunc(n.strs, func(d string) bool { return weekdays[d] == f.time.Weekday() })
+	case "class":
+		return slices.Contains(n.strs, f.class)
+	case "person":
+		return slices.ContainsFunc(n.strs, func(p string) bool { return f.persons[p] })
+	case "hour":
+		return n.compare(float64(f.time.Hour()))
+	case "score":
+		return n.compare(f.result.Score)
+	}
+	return false
+}
+
+// compare tests a numeric field's value v.
+func (n *filterNode) compare(v float64) bool {
+	switch n.op {
+	case "in", "eq":
+		return slices.Contains(n.nums, v)
+	case "gte":
+		return v >= n.nums[0]
+	case "gt":
+		return v > n.nums[0]
+	case "lte":
+		return v <= n.nums[0]
+	case "lt":
+		return v < n.nums[0]
+	}
+	return false
+}
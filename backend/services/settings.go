@@ -3,34 +3,110 @@ package services
 import (
 	"database/sql"
 	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
 )
 
 type settingDef struct {
-	Key      string
-	Type     string // "float", "int", "bool"
-	Default  string
-	Min      float64
-	Max      float64
+	Key         string
+	Type        string // "float", "int", "bool", "string", "time" (HH:MM), "timezone", "url", "urls" (comma-separated)
+	Default     string
+	Min         float64
+	Max         float64
+	Secret      bool // masked by All; set only by a value other than MaskedSecret
+	Restart     bool // read once at startup; a change waits for a restart
+	Description string
 }
 
+// MaskedSecret stands in for a non-empty secret setting in API responses and
+// exports. Submitting it back leaves the stored secret unchanged.
+const MaskedSecret = "********"
+
 var settingDefs = []settingDef{
-	{"general.system_name", "string", "CCTV Intelligence", 0, 0},
-	{"search.min_score", "float", "0.18", 0.0, 1.0},
-	{"search.default_limit", "int", "20", 1, 500},
-	{"extraction.time_interval_sec", "int", "5", 1, 3600},
-	{"extraction.output_quality", "int", "85", 1, 100},
-	{"extraction.dedup_enabled", "bool", "true", 0, 0},
-	{"extraction.dedup_phash_threshold", "int", "8", 0, 64},
-	{"clip.batch_size", "int", "32", 1, 256},
-	{"clip.model", "string", "mobileclip-s0", 0, 0},
-	{"nvr.ip", "string", "", 0, 0},
-	{"nvr.rtsp_port", "int", "554", 1, 65535},
-	{"nvr.username", "string", "", 0, 0},
-	{"nvr.password", "string", "", 0, 0},
+	{"general.system_name", "string", "CCTV Intelligence", 0, 0, false, false, "Name shown in the web UI header"},
+	{"general.timezone", "timezone", "", 0, 0, false, false, "IANA timezone of the cameras' wall clock, e.g. Europe/Warsaw (empty = the server's local zone)"},
+	{"search.min_score", "float", "0.18", 0.0, 1.0, false, false, "Minimum CLIP similarity for a search result"},
+	{"search.default_limit", "int", "20", 1, 500, false, false, "Results per search when the request sets no limit"},
+	{"search.dedup_window_sec", "int", "60", 0, 3600, false, false, "Keep only the best result per camera within this many seconds (0 = every frame)"},
+	{"reports.classes", "string", "person,car,truck,bicycle,motorcycle,dog,cat,package", 0, 0, false, false, "Comma-separated object classes the daily report counts frames of"},
+	{"extraction.time_interval_sec", "int", "5", 1, 3600, false, false, "Seconds between extracted frames"},
+	{"extraction.output_quality", "int", "85", 1, 100, false, false, "JPEG quality of extracted frames"},
+	{"extraction.dedup_enabled", "bool", "true", 0, 0, false, false, "Drop near-duplicate frames after extraction"},
+	{"extraction.dedup_phash_threshold", "int", "8", 0, 64, false, false, "Perceptual hash distance under which frames count as duplicates"},
+	{"extraction.timestamp_source", "string", "metadata", 0, 0, false, false, "Where frame timestamps of a video without a start time (HHMM) in its name come from: metadata (the container's creation_time, else the file's mtime) or mtime"},
+	{"videos.duplicates", "string", "skip", 0, 0, false, false, "What happens to an uploaded or NVR video that duplicates one already stored: skip (delete it), flag (keep it as .duplicate, unprocessed) or off (process it again)"},
+	{"videos.archive_after_days", "int", "0", 0, 3650, false, false, "Merge the recordings of a date into one archive file this many days after it, once all are indexed (0 = off)"},
+	{"clip.batch_size", "int", "32", 1, 256, false, false, "Frames per CLIP encoding request to the ML sidecar (the starting size with clip.batch_adaptive)"},
+	{"clip.batch_adaptive", "bool", "true", 0, 0, false, false, "Tune the batch size from ML sidecar latency: grow it while batches are fast, shrink it on slow or timed out ones"},
+	{"clip.batch_size_min", "int", "4", 1, 256, false, false, "Smallest batch size clip.batch_adaptive goes down to"},
+	{"clip.batch_size_max", "int", "128", 1, 256, false, false, "Largest batch size clip.batch_adaptive goes up to"},
+	{"clip.batch_target_sec", "float", "10", 1, 600, false, false, "Seconds a batch should take with clip.batch_adaptive"},
+	{"clip.model", "string", "mobileclip-s0", 0, 0, false, true, "CLIP model preset loaded by the ML sidecar"},
+	{"ml.url", "url", "", 0, 0, false, false, "ML sidecar address, applied without a restart (empty = mlservice.url from app.yaml)"},
+	{"ml.extra_urls", "urls", "", 0, 0, false, false, "Further ML sidecar addresses, comma-separated, that share image encoding with ml.url"},
+	{"ml.timeout_sec", "int", "120", 5, 3600, false, false, "Seconds a single ML sidecar request may take"},
+	{"ml.ready_timeout_sec", "int", "120", 0, 3600, false, false, "Seconds jobs wait for the ML sidecar to come up"},
+	{"ml.send_images", "bool", "false", 0, 0, false, false, "Upload image bytes to the ML sidecar instead of file paths, for a sidecar on another host"},
+	{"ml.retries", "int", "3", 0, 10, false, false, "Retries of an image encoding or face detection request after a transient ML sidecar failure, with exponential backoff"},
+	{"ml.breaker_threshold", "int", "5", 0, 100, false, false, "Consecutive failed ML sidecar requests after which requests fail at once (0 = never)"},
+	{"ml.breaker_cooldown_sec", "int", "30", 1, 3600, false, false, "Seconds requests fail at once before the ML sidecar is tried again"},
+	{"ml.max_concurrent", "int", "2", 0, 32, false, false, "Requests sent to each ML sidecar at once by all jobs and searches; searches go first (0 = no limit)"},
+	{"nvr.ip", "string", "", 0, 0, false, false, "Hikvision NVR address"},
+	{"nvr.rtsp_port", "int", "554", 1, 65535, false, false, "NVR RTSP port"},
+	{"nvr.username", "string", "", 0, 0, false, false, "NVR login user"},
+	{"nvr.password", "string", "", 0, 0, true, false, "NVR login password"},
+	{"stream.segment_sec", "float", "2", 0.5, 10, false, false, "Live stream HLS segment length in seconds"},
+	{"stream.playlist_size", "int", "5", 2, 30, false, false, "Segments listed in the live playlist"},
+	{"stream.idle_timeout_sec", "int", "30", 10, 3600, false, false, "Stop a live stream nobody has requested for this long"},
+	{"stream.max_height", "int", "0", 0, 2160, false, false, "Downscale live video taller than this (0 = source size)"},
+	{"stream.bitrate_kbps", "int", "0", 0, 20000, false, false, "Cap on the live video bitrate (0 = encoder default)"},
+	{"stream.nvr_stream", "int", "2", 1, 2, false, false, "NVR stream for live view: 1 = main, 2 = substream"},
+	{"process.window_start", "time", "", 0, 0, false, false, "Processing jobs wait for this time of day (HH:MM; empty = any time)"},
+	{"process.window_end", "time", "", 0, 0, false, false, "No processing job starts from this time of day (HH:MM); may wrap past midnight"},
+	{"jobs.retention_days", "int", "7", 1, 365, false, false, "Days finished jobs and their logs are kept before they are pruned"},
+	{"jobs.max_concurrent", "int", "2", 1, 16, false, false, "Processing and upload jobs running at once, across all workspaces; the rest wait in line"},
+	{"jobs.resume_interrupted", "bool", "true", 0, 0, false, false, "Resume jobs interrupted by a server restart instead of marking them failed"},
+	{"jobs.pause_on_low_disk", "bool", "true", 0, 0, false, false, "Pause downloads and extraction while the data disk is below alerts.disk_critical_gb instead of failing mid-write"},
+	{"ingest.interval_min", "int", "5", 1, 1440, false, false, "Minutes between NVR polls of the cameras with auto_ingest set"},
+	{"alerts.camera_check_min", "int", "5", 0, 1440, false, false, "Minutes between checks of the NVR and its cameras for offline alerts (0 = off)"},
+	{"alerts.no_recording_hours", "int", "0", 0, 168, false, false, "Alert when an NVR camera has recorded nothing for this many hours (0 = off)"},
+	{"alerts.quiet_start", "time", "", 0, 0, false, false, "Alert notifications are held from this time of day (HH:MM; empty = never) and sent as a digest at alerts.quiet_end"},
+	{"alerts.quiet_end", "time", "", 0, 0, false, false, "End of the alert quiet hours (HH:MM); may wrap past midnight"},
+	{"alerts.cooldown_min", "int", "10", 0, 1440, false, false, "Minutes after an alert notification before the same rule and camera notify again; alerts in between go out as a digest (0 = off)"},
+	{"alerts.group_window_sec", "int", "30", 0, 600, false, false, "Group alerts of the same rule on other cameras within this many seconds of each other into one (0 = off)"},
+	{"alerts.disk_low_gb", "float", "5", 0, 100000, false, false, "Alert when the data disk has less free space than this many GB (0 = off)"},
+	{"alerts.disk_critical_gb", "float", "1", 0, 100000, false, false, "Alert, and with jobs.pause_on_low_disk pause downloads and extraction, below this many GB free (0 = off)"},
+	{"alerts.db_size_gb", "float", "0", 0, 100000, false, false, "Alert when the database grows past this many GB (0 = off)"},
+	{"alerts.watch_queries", "string", "", 0, 0, false, false, "Comma-separated text queries; a newly indexed frame matching one raises a frame_match alert (empty = off)"},
+	{"alerts.watch_min_score", "float", "0.25", 0.0, 1.0, false, false, "Minimum CLIP similarity of a newly indexed frame to an alerts.watch_queries query to raise an alert"},
+	{"notify.attach_frame", "bool", "true", 0, 0, false, false, "Attach the matched frame JPEG to alert notifications"},
+	{"notify.attach_clip", "bool", "false", 0, 0, false, false, "Attach a clip around the match time to alert notifications"},
+	{"notify.clip_sec", "int", "10", 1, 60, false, false, "Seconds of video before and after the match in an attached clip"},
+	{"notify.job_failures", "bool", "true", 0, 0, false, false, "Notify the configured channels when a job fails"},
+	{"notify.email_smtp_host", "string", "", 0, 0, false, false, "SMTP server notification emails are sent through (empty = email disabled)"},
+	{"notify.email_smtp_port", "int", "587", 1, 65535, false, false, "SMTP port: STARTTLS when offered, implicit TLS on 465"},
+	{"notify.email_username", "string", "", 0, 0, false, false, "SMTP login user (empty = no authentication)"},
+	{"notify.email_password", "string", "", 0, 0, true, false, "SMTP login password"},
+	{"notify.email_from", "string", "", 0, 0, false, false, "Sender address of notification emails (empty = the SMTP user)"},
+	{"notify.email_to", "string", "", 0, 0, false, false, "Comma-separated recipients of notification emails"},
+	{"notify.telegram_bot_token", "string", "", 0, 0, true, false, "Telegram bot token (empty = Telegram disabled)"},
+	{"notify.telegram_chat_id", "string", "", 0, 0, false, false, "Telegram chat the bot posts notifications to"},
+	{"notify.slack_webhook_url", "url", "", 0, 0, true, false, "Slack incoming webhook URL (empty = Slack disabled)"},
+	{"notify.webhook_url", "url", "", 0, 0, false, false, "URL notifications are POSTed to as JSON (empty = webhook disabled)"},
+	{"enrichment.latitude", "float", "0", -90, 90, false, false, "Latitude of the cameras' site, for the sun times and weather attached to search results and reports (0 with enrichment.longitude 0 = enrichment off)"},
+	{"enrichment.longitude", "float", "0", -180, 180, false, false, "Longitude of the cameras' site"},
+	{"enrichment.weather_provider", "string", "none", 0, 0, false, false, "Where the hourly weather of a day comes from: none or open-meteo"},
+	{"enrichment.weather_url", "url", "", 0, 0, false, false, "URL of the weather provider's API (empty = its public API)"},
+	{"workers.enabled", "bool", "false", 0, 0, false, false, "Index frames on remote workers ('backend worker') instead of the ML sidecar; needs workers.token in app.yaml"},
 }
 
 type SettingsService struct {
@@ -38,6 +114,12 @@ type SettingsService struct {
 	mu    sync.RWMutex
 	cache map[string]string
 	defs  map[string]settingDef
+	// applied holds the Restart settings as this process uses them
+	applied map[string]string
+
+	eventsMu  sync.Mutex
+	events    []models.SettingsChangeEvent
+	listeners []func(keys []string)
 }
 
 func NewSettingsService(db *sql.DB, cfg *config.AppConfig) *SettingsService {
@@ -53,23 +135,93 @@ func NewSettingsService(db *sql.DB, cfg *config.AppConfig) *SettingsService {
 
 	// Build defaults from config values
 	s.cache["general.system_name"] = "CCTV Intelligence"
+	s.cache["general.timezone"] = ""
 	s.cache["search.min_score"] = "0.18"
 	s.cache["search.default_limit"] = "20"
+	s.cache["search.dedup_window_sec"] = "60"
+	s.cache["reports.classes"] = "person,car,truck,bicycle,motorcycle,dog,cat,package"
 	s.cache["extraction.time_interval_sec"] = strconv.Itoa(cfg.Extraction.TimeIntervalSec)
 	s.cache["extraction.output_quality"] = strconv.Itoa(cfg.Extraction.OutputQuality)
 	s.cache["extraction.dedup_enabled"] = strconv.FormatBool(cfg.Extraction.DedupEnabled)
 	s.cache["extraction.dedup_phash_threshold"] = strconv.Itoa(cfg.Extraction.DedupPHashThreshold)
+	s.cache["extraction.timestamp_source"] = "metadata"
+	s.cache["videos.duplicates"] = "skip"
+	s.cache["videos.archive_after_days"] = "0"
 	s.cache["clip.batch_size"] = strconv.Itoa(cfg.CLIP.BatchSize)
+	s.cache["clip.batch_adaptive"] = "true"
+	s.cache["clip.batch_size_min"] = "4"
+	s.cache["clip.batch_size_max"] = "128"
+	s.cache["clip.batch_target_sec"] = "10"
 	s.cache["clip.model"] = "mobileclip-s0"
+	s.cache["ml.url"] = ""
+	s.cache["ml.extra_urls"] = ""
+	s.cache["ml.timeout_sec"] = "120"
+	s.cache["ml.ready_timeout_sec"] = "120"
+	s.cache["ml.send_images"] = "false"
+	s.cache["ml.retries"] = "3"
+	s.cache["ml.breaker_threshold"] = "5"
+	s.cache["ml.breaker_cooldown_sec"] = "30"
+	s.cache["ml.max_concurrent"] = "2"
 	s.cache["nvr.ip"] = ""
 	s.cache["nvr.rtsp_port"] = "554"
 	s.cache["nvr.username"] = ""
 	s.cache["nvr.password"] = ""
+	s.cache["stream.segment_sec"] = strconv.FormatFloat(cfg.Stream.SegmentSec, 'f', -1, 64)
+	s.cache["stream.playlist_size"] = "5"
+	s.cache["stream.idle_timeout_sec"] = "30"
+	s.cache["stream.max_height"] = "0"
+	s.cache["stream.bitrate_kbps"] = "0"
+	s.cache["stream.nvr_stream"] = "2"
+	s.cache["process.window_start"] = ""
+	s.cache["process.window_end"] = ""
+	s.cache["jobs.retention_days"] = "7"
+	s.cache["jobs.max_concurrent"] = "2"
+	s.cache["jobs.resume_interrupted"] = "true"
+	s.cache["jobs.pause_on_low_disk"] = "true"
+	s.cache["ingest.interval_min"] = "5"
+	s.cache["alerts.camera_check_min"] = "5"
+	s.cache["alerts.no_recording_hours"] = "0"
+	s.cache["alerts.quiet_start"] = ""
+	s.cache["alerts.quiet_end"] = ""
+	s.cache["alerts.cooldown_min"] = "10"
+	s.cache["alerts.group_window_sec"] = "30"
+	s.cache["alerts.disk_low_gb"] = "5"
+	s.cache["alerts.disk_critical_gb"] = "1"
+	s.cache["alerts.db_size_gb"] = "0"
+	s.cache["alerts.watch_queries"] = ""
+	s.cache["alerts.watch_min_score"] = "0.25"
+	s.cache["notify.attach_frame"] = "true"
+	s.cache["notify.attach_clip"] = "false"
+	s.cache["notify.clip_sec"] = "10"
+	s.cache["notify.job_failures"] = "true"
+	s.cache["notify.email_smtp_host"] = ""
+	s.cache["notify.email_smtp_port"] = "587"
+	s.cache["notify.email_username"] = ""
+	s.cache["notify.email_password"] = ""
+	s.cache["notify.email_from"] = ""
+	s.cache["notify.email_to"] = ""
+	s.cache["notify.telegram_bot_token"] = ""
+	s.cache["notify.telegram_chat_id"] = ""
+	s.cache["notify.slack_webhook_url"] = ""
+	s.cache["notify.webhook_url"] = ""
+	s.cache["enrichment.latitude"] = "0"
+	s.cache["enrichment.longitude"] = "0"
+	s.cache["enrichment.weather_provider"] = "none"
+	s.cache["enrichment.weather_url"] = ""
+	s.cache["workers.enabled"] = "false"
 
 	// Seed DB with defaults for any keys not yet persisted, then load all
 	s.seedDefaults()
+	// workers.token moved to app.yaml; don't keep a copy of it around
+	db.Exec("DELETE FROM settings WHERE key = 'workers.token'")
 	s.loadFromDB()
 
+	s.applied = make(map[string]string)
+	for key, def := range s.defs {
+		if def.Restart {
+			s.applied[key] = s.cache[key]
+		}
+	}
 	return s
 }
 
@@ -102,12 +254,94 @@ func (s *SettingsService) loadFromDB() {
 	}
 }
 
+// Reload re-reads the settings from the database, picking up changes made
+// by another process (e.g. a CLI run) since the service was created.
+func (s *SettingsService) Reload() {
+	s.mu.Lock()
+	before := maps.Clone(s.cache)
+	s.loadFromDB()
+	var changed []string
+	for key, value := range s.cache {
+		if before[key] != value {
+			changed = append(changed, key)
+		}
+	}
+	s.mu.Unlock()
+	s.notify(changed)
+}
+
+// OnChange registers fn to be called with the keys of every settings change,
+// so services can apply new values at runtime. fn runs on the goroutine
+// that made the change and should not block.
+func (s *SettingsService) OnChange(fn func(keys []string)) {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Events returns the log of settings changes since startup, and the lock it
+// is appended under, for streaming to clients.
+func (s *SettingsService) Events() (*sync.Mutex, *[]models.SettingsChangeEvent) {
+	return &s.eventsMu, &s.events
+}
+
+// notify logs a change of keys and tells the listeners.
+func (s *SettingsService) notify(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	s.eventsMu.Lock()
+	s.events = append(s.events, models.SettingsChangeEvent{
+		Keys:            keys,
+		ChangedAt:       time.Now().UTC(),
+		RestartRequired: s.RestartRequired(),
+	})
+	listeners := slices.Clone(s.listeners)
+	s.eventsMu.Unlock()
+	for _, fn := range listeners {
+		fn(keys)
+	}
+}
+
+// RestartRequired lists the settings changed since startup that only take
+// effect after a restart.
+func (s *SettingsService) RestartRequired() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := []string{}
+	for key, value := range s.applied {
+		if s.cache[key] != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (s *SettingsService) Get(key string) string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.cache[key]
 }
 
+// Location is the general.timezone setting: the zone recordings are named
+// in and "today" is counted in, whatever zone the server itself runs in.
+// It falls back to the server's local zone when unset.
+func (s *SettingsService) Location() *time.Location {
+	if name := s.Get("general.timezone"); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// Now returns the current time in Location.
+func (s *SettingsService) Now() time.Time {
+	return time.Now().In(s.Location())
+}
+
 func (s *SettingsService) GetFloat64(key string) float64 {
 	v, _ := strconv.ParseFloat(s.Get(key), 64)
 	return v
@@ -124,12 +358,27 @@ func (s *SettingsService) GetBool(key string) bool {
 }
 
 func (s *SettingsService) Set(key string, value any) error {
+	return s.set(key, value, false)
+}
+
+// SetApplied sets key like Set, for a Restart setting the caller has already
+// put into effect (e.g. a CLIP model switched in the sidecar), so it isn't
+// reported as waiting for a restart.
+func (s *SettingsService) SetApplied(key string, value any) error {
+	return s.set(key, value, true)
+}
+
+func (s *SettingsService) set(key string, value any, applied bool) error {
 	def, ok := s.defs[key]
 	if !ok {
 		return fmt.Errorf("unknown setting: %s", key)
 	}
 
-	strVal, err := s.validate(def, value)
+	if def.Secret && value == MaskedSecret {
+		return nil // the client echoed the placeholder back
+	}
+
+	strVal, err := validateSetting(def, value)
 	if err != nil {
 		return fmt.Errorf("invalid value for %s: %w", key, err)
 	}
@@ -146,13 +395,32 @@ func (s *SettingsService) Set(key string, value any) error {
 
 	// Update cache
 	s.mu.Lock()
+	old := s.cache[key]
 	s.cache[key] = strVal
+	if _, ok := s.applied[key]; ok && applied {
+		s.applied[key] = strVal
+	}
 	s.mu.Unlock()
 
+	if old != strVal {
+		s.notify([]string{key})
+	}
 	return nil
 }
 
-func (s *SettingsService) validate(def settingDef, value any) (string, error) {
+// ValidateSetting checks value against the type and range of setting key
+// without storing it.
+func ValidateSetting(key string, value any) error {
+	for _, def := range settingDefs {
+		if def.Key == key {
+			_, err := validateSetting(def, value)
+			return err
+		}
+	}
+	return fmt.Errorf("unknown setting: %s", key)
+}
+
+func validateSetting(def settingDef, value any) (string, error) {
 	switch def.Type {
 	case "float":
 		v, err := toFloat64(value)
@@ -184,11 +452,65 @@ func (s *SettingsService) validate(def settingDef, value any) (string, error) {
 			return "", fmt.Errorf("expected string")
 		}
 		return s, nil
+	case "timezone":
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("expected timezone name")
+		}
+		if _, err := time.LoadLocation(s); err != nil {
+			return "", fmt.Errorf("unknown timezone %q", s)
+		}
+		return s, nil
+	case "url":
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("expected URL")
+		}
+		if s == "" {
+			return "", nil
+		}
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", fmt.Errorf("expected an http(s) URL, got %q", s)
+		}
+		return s, nil
+	case "urls":
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("expected comma-separated URLs")
+		}
+		var urls []string
+		for _, part := range strings.Split(s, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			u, err := url.Parse(part)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return "", fmt.Errorf("expected http(s) URLs, got %q", part)
+			}
+			urls = append(urls, part)
+		}
+		return strings.Join(urls, ","), nil
+	case "time":
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("expected time of day")
+		}
+		if s == "" {
+			return "", nil
+		}
+		t, err := time.Parse("15:04", s)
+		if err != nil {
+			return "", fmt.Errorf("expected HH:MM, got %q", s)
+		}
+		return t.Format("15:04"), nil
 	default:
 		return "", fmt.Errorf("unknown type %s", def.Type)
 	}
 }
 
+// All returns every setting as a typed value, with secrets masked.
 func (s *SettingsService) All() map[string]any {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -208,6 +530,9 @@ func (s *SettingsService) All() map[string]any {
 			result[key] = v
 		case "string":
 			result[key] = raw
+			if def.Secret && raw != "" {
+				result[key] = MaskedSecret
+			}
 		default:
 			result[key] = raw
 		}
@@ -215,6 +540,29 @@ func (s *SettingsService) All() map[string]any {
 	return result
 }
 
+// Schema describes every setting, in definition order, so clients can
+// render and validate a settings form without knowing the keys.
+func (s *SettingsService) Schema() []models.SettingSchema {
+	defaults := s.Defaults()
+	schema := make([]models.SettingSchema, 0, len(settingDefs))
+	for _, def := range settingDefs {
+		entry := models.SettingSchema{
+			Key:         def.Key,
+			Type:        def.Type,
+			Category:    strings.SplitN(def.Key, ".", 2)[0],
+			Default:     defaults[def.Key],
+			Secret:      def.Secret,
+			Restart:     def.Restart,
+			Description: def.Description,
+		}
+		if def.Type == "int" || def.Type == "float" {
+			entry.Min, entry.Max = &def.Min, &def.Max
+		}
+		schema = append(schema, entry)
+	}
+	return schema
+}
+
 // Defaults returns all setting default values as typed values.
 func (s *SettingsService) Defaults() map[string]any {
 	result := make(map[string]any, len(s.defs))
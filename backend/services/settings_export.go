@@ -0,0 +1,161 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/intelsk/backend/models"
+)
+
+// SettingsExportVersion is the format version written by Export; Import
+// rejects newer ones.
+const SettingsExportVersion = 1
+
+const settingsKDFIterations = 600000
+
+// settingsCipher derives the AES-256-GCM cipher for passphrase and salt.
+func settingsCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, settingsKDFIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Export returns all settings for copying to another installation. Secrets
+// are masked, or encrypted with passphrase when it is not empty.
+func (s *SettingsService) Export(passphrase string) (*models.SettingsExport, error) {
+	exp := &models.SettingsExport{
+		Version:    SettingsExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Settings:   s.All(),
+	}
+
+	if passphrase == "" {
+		return exp, nil // All already masked the secrets
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	aead, err := settingsCipher(passphrase, salt)
+	if err != nil {
+		return nil, fmt.Errorf("deriving export key: %w", err)
+	}
+	exp.Salt = base64.StdEncoding.EncodeToString(salt)
+	exp.Encrypted = make(map[string]string)
+
+	for key, def := range s.defs {
+		value := s.Get(key)
+		if !def.Secret || value == "" {
+			continue // an unset secret has nothing to hide
+		}
+		nonce := make([]byte, aead.NonceSize())
+		rand.Read(nonce)
+		// The key is authenticated too, so values can't be swapped between keys
+		sealed := aead.Seal(nonce, nonce, []byte(value), []byte(key))
+		exp.Encrypted[key] = base64.StdEncoding.EncodeToString(sealed)
+		delete(exp.Settings, key)
+	}
+	return exp, nil
+}
+
+// Import applies an export made by Export. Every value is validated before
+// any is stored, so a bad export changes nothing. Masked secrets keep the
+// current value; encrypted ones need the export's passphrase. Keys this
+// version doesn't know (e.g. from a newer installation) are skipped and
+// returned.
+func (s *SettingsService) Import(exp *models.SettingsExport, passphrase string) ([]string, error) {
+	if exp.Version < 1 || exp.Version > SettingsExportVersion {
+		return nil, newError(ErrInvalidInput, "unsupported settings export version %d", exp.Version)
+	}
+
+	values := make(map[string]string)
+	skipped := []string{}
+	for key, value := range exp.Settings {
+		def, ok := s.defs[key]
+		if !ok {
+			skipped = append(skipped, key)
+			continue
+		}
+		if def.Secret && value == MaskedSecret {
+			continue
+		}
+		strVal, err := validateSetting(def, value)
+		if err != nil {
+			return nil, newError(ErrInvalidInput, "invalid value for %s: %v", key, err)
+		}
+		values[key] = strVal
+	}
+
+	if len(exp.Encrypted) > 0 {
+		if passphrase == "" {
+			return nil, newError(ErrInvalidInput, "the export has encrypted secrets; a passphrase is required")
+		}
+		salt, err := base64.StdEncoding.DecodeString(exp.Salt)
+		if err != nil || len(salt) == 0 {
+			return nil, newError(ErrInvalidInput, "invalid salt in settings export")
+		}
+		aead, err := settingsCipher(passphrase, salt)
+		if err != nil {
+			return nil, fmt.Errorf("deriving export key: %w", err)
+		}
+		for key, enc := range exp.Encrypted {
+			if def, ok := s.defs[key]; !ok || !def.Secret {
+				skipped = append(skipped, key)
+				continue
+			}
+			sealed, err := base64.StdEncoding.DecodeString(enc)
+			if err != nil || len(sealed) < aead.NonceSize() {
+				return nil, newError(ErrInvalidInput, "invalid encrypted value for %s", key)
+			}
+			nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+			plain, err := aead.Open(nil, nonce, ciphertext, []byte(key))
+			if err != nil {
+				return nil, newError(ErrInvalidInput, "cannot decrypt %s: wrong passphrase?", key)
+			}
+			values[key] = string(plain)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for key, value := range values {
+		if _, err := tx.Exec(
+			`INSERT INTO settings (key, value, updated_at) VALUES (?, ?, datetime('now'))
+			 ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+			key, value,
+		); err != nil {
+			return nil, fmt.Errorf("saving setting %s: %w", key, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("saving settings: %w", err)
+	}
+
+	var changed []string
+	s.mu.Lock()
+	for key, value := range values {
+		if s.cache[key] != value {
+			changed = append(changed, key)
+		}
+		s.cache[key] = value
+	}
+	s.mu.Unlock()
+	s.notify(changed)
+
+	sort.Strings(skipped)
+	return skipped, nil
+}
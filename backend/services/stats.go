@@ -0,0 +1,148 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+)
+
+// CollectStats gathers per-camera and total statistics for a workspace: video
+// counts and sizes from disk, frame counts from manifests, embedding counts
+// from the database and last processed dates from the process history. It
+// only reads, so db may be opened read-only.
+func CollectStats(cfg *config.AppConfig, db *sql.DB) (*models.SystemStats, error) {
+	cameraSvc := NewCameraService(db, cfg)
+	cameras, err := cameraSvc.List()
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*models.CameraStats)
+	get := func(id string) *models.CameraStats {
+		if cs, ok := byID[id]; ok {
+			return cs
+		}
+		cs := &models.CameraStats{CameraID: id}
+		byID[id] = cs
+		return cs
+	}
+	for _, cam := range cameras {
+		get(cam.ID).Name = cam.Name
+	}
+
+	// Cameras deleted from the DB can still have footage or embeddings left
+	// behind; include them so the totals add up.
+	for _, dir := range []string{filepath.Join(cfg.App.DataDir, "videos"), cfg.Extraction.StoragePath} {
+		entries, _ := os.ReadDir(dir)
+		for _, de := range entries {
+			if de.IsDir() && !strings.HasPrefix(de.Name(), ".") {
+				get(de.Name())
+			}
+		}
+	}
+
+	counts := []struct {
+		query string
+		set   func(cs *models.CameraStats, n int)
+	}{
+		{"SELECT camera_id, COUNT(*) FROM clip_embeddings GROUP BY camera_id",
+			func(cs *models.CameraStats, n int) { cs.ClipEmbeddings = n }},
+		{"SELECT camera_id, COUNT(DISTINCT frame_path) FROM clip_embeddings GROUP BY camera_id",
+			func(cs *models.CameraStats, n int) { cs.IndexedFrames = n }},
+		{"SELECT camera_id, COUNT(*) FROM face_embeddings GROUP BY camera_id",
+			func(cs *models.CameraStats, n int) { cs.FaceEmbeddings = n }},
+	}
+	for _, c := range counts {
+		if err := scanCameraCounts(db, c.query, func(id string, n int) { c.set(get(id), n) }); err != nil {
+			return nil, err
+		}
+	}
+
+	for id, cs := range byID {
+		dates, err := cameraSvc.Stats(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dates {
+			cs.VideoCount += d.VideoCount
+			cs.FrameCount += d.FrameCount
+		}
+		cs.VideoBytes = dirSize(filepath.Join(cfg.App.DataDir, "videos", id))
+	}
+
+	for _, h := range LoadProcessHistory(cfg.Process.HistoryPath) {
+		cs, ok := byID[h.CameraID]
+		if !ok {
+			continue
+		}
+		if h.Date > cs.LastProcessedDate {
+			cs.LastProcessedDate = h.Date
+		}
+		if cs.LastIndexedAt == nil || h.IndexedAt.After(*cs.LastIndexedAt) {
+			t := h.IndexedAt
+			cs.LastIndexedAt = &t
+		}
+	}
+
+	stats := &models.SystemStats{Cameras: make([]models.CameraStats, 0, len(byID))}
+	for _, cs := range byID {
+		stats.Cameras = append(stats.Cameras, *cs)
+		stats.VideoCount += cs.VideoCount
+		stats.VideoBytes += cs.VideoBytes
+		stats.FrameCount += cs.FrameCount
+		stats.IndexedFrames += cs.IndexedFrames
+		stats.ClipEmbeddings += cs.ClipEmbeddings
+		stats.FaceEmbeddings += cs.FaceEmbeddings
+	}
+	sort.Slice(stats.Cameras, func(i, j int) bool {
+		return stats.Cameras[i].CameraID < stats.Cameras[j].CameraID
+	})
+
+	// SQLite keeps recent writes in the -wal file until a checkpoint
+	for _, suffix := range []string{"", "-wal"} {
+		if info, err := os.Stat(cfg.Storage.DBPath + suffix); err == nil {
+			stats.DBBytes += info.Size()
+		}
+	}
+	return stats, nil
+}
+
+func scanCameraCounts(db *sql.DB, query string, fn func(id string, n int)) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("counting embeddings: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return fmt.Errorf("scanning embedding count: %w", err)
+		}
+		fn(id, n)
+	}
+	return rows.Err()
+}
+
+// dirSize returns the total size of the regular files under dir.
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
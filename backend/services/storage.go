@@ -3,17 +3,31 @@ package services
 import (
 	"database/sql"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/intelsk/backend/models"
 	_ "modernc.org/sqlite"
 )
 
 type Storage struct {
 	db *sql.DB
+
+	mu        sync.Mutex
+	onIndexed []func(frames []IndexedFrame)
+}
+
+// IndexedFrame is a frame whose CLIP embedding the pipeline stored.
+type IndexedFrame struct {
+	models.FrameMetadata
+	Embedding []float64
 }
 
 func NewStorage(dbPath string) (*Storage, error) {
@@ -21,7 +35,9 @@ func NewStorage(dbPath string) (*Storage, error) {
 		return nil, fmt.Errorf("creating db directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite", dbPath)
+	// Wait for locks instead of failing with SQLITE_BUSY when several
+	// pipelines (or the server and a CLI run) write at once
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, err
 	}
@@ -40,6 +56,23 @@ func NewStorage(dbPath string) (*Storage, error) {
 	return &Storage{db: db}, nil
 }
 
+// OpenStorageReadOnly opens an existing database without creating it or
+// running migrations, for diagnostics that must not modify anything.
+func OpenStorageReadOnly(dbPath string) (*Storage, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+filepath.ToSlash(dbPath)+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Storage{db: db}, nil
+}
+
 func runMigrations(db *sql.DB) error {
 	schema := `
 CREATE TABLE IF NOT EXISTS clip_embeddings (
@@ -87,14 +120,188 @@ CREATE TABLE IF NOT EXISTS cameras (
     created_at TEXT NOT NULL DEFAULT (datetime('now')),
     updated_at TEXT NOT NULL DEFAULT (datetime('now'))
 );
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id          TEXT PRIMARY KEY,
+    type        TEXT NOT NULL,
+    params      TEXT NOT NULL DEFAULT '{}',
+    status      TEXT NOT NULL,
+    error       TEXT NOT NULL DEFAULT '',
+    retry_of    TEXT NOT NULL DEFAULT '',
+    resumes     INTEGER NOT NULL DEFAULT 0,
+    priority    INTEGER NOT NULL DEFAULT 0,
+    events      INTEGER NOT NULL DEFAULT 0,
+    last_event  TEXT NOT NULL DEFAULT '',
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL,
+    finished_at TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_created ON jobs(created_at);
+
+CREATE TABLE IF NOT EXISTS job_log (
+    job_id  TEXT NOT NULL,
+    seq     INTEGER NOT NULL,
+    time    TEXT NOT NULL,
+    kind    TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    event   TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (job_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS schedules (
+    id          TEXT PRIMARY KEY,
+    camera_id   TEXT NOT NULL DEFAULT '',
+    cron        TEXT NOT NULL,
+    enabled     INTEGER NOT NULL DEFAULT 1,
+    last_run_at TEXT,
+    last_job_id TEXT NOT NULL DEFAULT '',
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS alerts (
+    id              TEXT PRIMARY KEY,
+    rule            TEXT NOT NULL,
+    camera_id       TEXT NOT NULL DEFAULT '',
+    status          TEXT NOT NULL DEFAULT 'new',
+    title           TEXT NOT NULL,
+    message         TEXT NOT NULL DEFAULT '',
+    date            TEXT NOT NULL,
+    timestamp       TEXT NOT NULL DEFAULT '',
+    frame_path      TEXT NOT NULL DEFAULT '',
+    source_video    TEXT NOT NULL DEFAULT '',
+    created_at      TEXT NOT NULL,
+    updated_at      TEXT NOT NULL,
+    acknowledged_at TEXT,
+    notify_state    TEXT NOT NULL DEFAULT 'sent',
+    notified_at     TEXT,
+    group_id        TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_alerts_created ON alerts(created_at);
+
+CREATE TABLE IF NOT EXISTS alert_rules (
+    rule         TEXT PRIMARY KEY,
+    quiet_start  TEXT NOT NULL DEFAULT '',
+    quiet_end    TEXT NOT NULL DEFAULT '',
+    cooldown_min INTEGER NOT NULL DEFAULT 0,
+    updated_at   TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+    id           TEXT PRIMARY KEY,
+    frame_id     TEXT NOT NULL UNIQUE,
+    camera_id    TEXT NOT NULL,
+    timestamp    TEXT NOT NULL,
+    frame_path   TEXT NOT NULL,
+    source_video TEXT NOT NULL DEFAULT '',
+    note         TEXT NOT NULL DEFAULT '',
+    tags         TEXT NOT NULL DEFAULT '[]',
+    created_at   TEXT NOT NULL,
+    updated_at   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_bookmarks_camera_ts ON bookmarks(camera_id, timestamp);
+
+CREATE TABLE IF NOT EXISTS cases (
+    id          TEXT PRIMARY KEY,
+    title       TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    status      TEXT NOT NULL DEFAULT 'open',
+    created_at  TEXT NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS case_items (
+    id           TEXT PRIMARY KEY,
+    case_id      TEXT NOT NULL,
+    kind         TEXT NOT NULL,
+    frame_id     TEXT NOT NULL DEFAULT '',
+    camera_id    TEXT NOT NULL DEFAULT '',
+    timestamp    TEXT NOT NULL DEFAULT '',
+    frame_path   TEXT NOT NULL DEFAULT '',
+    source_video TEXT NOT NULL DEFAULT '',
+    query        TEXT NOT NULL DEFAULT '',
+    score        REAL NOT NULL DEFAULT 0,
+    start_sec    INTEGER NOT NULL DEFAULT 0,
+    end_sec      INTEGER NOT NULL DEFAULT 0,
+    note         TEXT NOT NULL DEFAULT '',
+    created_at   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_case_items_case ON case_items(case_id, created_at);
+
+CREATE TABLE IF NOT EXISTS ingest_state (
+    camera_id   TEXT PRIMARY KEY,
+    cursor      TEXT,
+    last_run_at TEXT,
+    last_job_id TEXT NOT NULL DEFAULT '',
+    last_error  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS day_context (
+    date       TEXT NOT NULL,
+    site       TEXT NOT NULL,
+    weather    TEXT NOT NULL,
+    fetched_at TEXT NOT NULL,
+    PRIMARY KEY (date, site)
+);
+
+CREATE TABLE IF NOT EXISTS workspaces (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
 `
 	_, err := db.Exec(schema)
 	if err != nil {
 		return fmt.Errorf("running migrations: %w", err)
 	}
+
+	// Columns added to tables that older databases already have
+	if err := addColumn(db, "jobs", "resumes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("running migrations: %w", err)
+	}
+	if err := addColumn(db, "jobs", "priority", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("running migrations: %w", err)
+	}
+	if err := addColumn(db, "alerts", "notify_state", "TEXT NOT NULL DEFAULT 'sent'"); err != nil {
+		return fmt.Errorf("running migrations: %w", err)
+	}
+	if err := addColumn(db, "alerts", "notified_at", "TEXT"); err != nil {
+		return fmt.Errorf("running migrations: %w", err)
+	}
+	if err := addColumn(db, "alerts", "group_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("running migrations: %w", err)
+	}
+	if err := normalizeTimestamps(db); err != nil {
+		return fmt.Errorf("running migrations: normalizing timestamps: %w", err)
+	}
 	return nil
 }
 
+// addColumn adds a column to table unless it already has it.
+func addColumn(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
+
 func (s *Storage) AddClipEmbedding(id string, embedding []byte,
 	cameraID, timestamp, framePath, sourceVideo string) error {
 	_, err := s.db.Exec(`INSERT OR REPLACE INTO clip_embeddings
@@ -104,6 +311,49 @@ func (s *Storage) AddClipEmbedding(id string, embedding []byte,
 	return err
 }
 
+// OnIndexed registers fn to be called with every batch of frames the
+// pipeline stores the embeddings of, e.g. to raise alerts on them. fn runs
+// on the pipeline's goroutine and should not block.
+func (s *Storage) OnIndexed(fn func(frames []IndexedFrame)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onIndexed = append(s.onIndexed, fn)
+}
+
+// indexed calls the OnIndexed listeners with frames.
+func (s *Storage) indexed(frames []IndexedFrame) {
+	s.mu.Lock()
+	listeners := slices.Clone(s.onIndexed)
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(frames)
+	}
+}
+
+func (s *Storage) AddFaceEmbedding(id string, embedding []byte,
+	cameraID, timestamp, framePath string, box FaceBox) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO face_embeddings
+		(id, embedding, camera_id, timestamp, frame_path, bbox_top, bbox_right, bbox_bottom, bbox_left)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, embedding, cameraID, timestamp, framePath, box.Top, box.Right, box.Bottom, box.Left)
+	return err
+}
+
+// GetClipEmbedding returns the indexed frame id without its embedding, as
+// a search result would have it.
+func (s *Storage) GetClipEmbedding(id string) (*models.SearchResult, error) {
+	r := &models.SearchResult{ID: id}
+	err := s.db.QueryRow("SELECT camera_id, timestamp, frame_path, source_video FROM clip_embeddings WHERE id = ?", id).
+		Scan(&r.CameraID, &r.Timestamp, &r.FramePath, &r.SourceVideo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, newError(ErrFrameNotFound, "frame %s not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
 func (s *Storage) Cleanup(olderThan time.Time) (int64, error) {
 	ts := olderThan.Format(time.RFC3339)
 	var total int64
@@ -127,6 +377,78 @@ func (s *Storage) Cleanup(olderThan time.Time) (int64, error) {
 	return total, nil
 }
 
+// DeleteFrameEmbeddings removes the CLIP and face embeddings of the given
+// frames in a single transaction and reports how many rows of each went.
+func (s *Storage) DeleteFrameEmbeddings(framePaths []string) (clip, face int64, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, p := range framePaths {
+		res, err := tx.Exec("DELETE FROM clip_embeddings WHERE frame_path = ?", p)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, _ := res.RowsAffected()
+		clip += n
+
+		res, err = tx.Exec("DELETE FROM face_embeddings WHERE frame_path = ?", p)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, _ = res.RowsAffected()
+		face += n
+	}
+	return clip, face, tx.Commit()
+}
+
+// CheckWritable verifies the database answers queries and can take a write
+// lock. The probe runs in a transaction that is always rolled back.
+func (s *Storage) CheckWritable() error {
+	if err := s.db.Ping(); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM settings WHERE key = '__health_check__'"); err != nil {
+		return fmt.Errorf("write probe: %w", err)
+	}
+	return nil
+}
+
+// IntegrityCheck runs SQLite's PRAGMA integrity_check and returns an error
+// listing the problems it reports, if any.
+func (s *Storage) IntegrityCheck() error {
+	rows, err := s.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
 func (s *Storage) DB() *sql.DB {
 	return s.db
 }
@@ -144,3 +466,12 @@ func Float64sToBytes(vals []float64) []byte {
 	}
 	return buf
 }
+
+// BytesToFloat64s is the inverse of Float64sToBytes.
+func BytesToFloat64s(buf []byte) []float64 {
+	vals := make([]float64, len(buf)/4)
+	for i := range vals {
+		vals[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:])))
+	}
+	return vals
+}
@@ -3,99 +3,573 @@ package services
 import (
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/intelsk/backend/config"
 )
 
 // Streamer manages on-demand ffmpeg processes for RTSP → HLS transcoding.
+// Every format is served through index.m3u8 in the stream's directory.
+// Each stream is supervised: when ffmpeg exits or stops writing (an RTSP
+// hiccup), it is restarted with exponential backoff.
 type Streamer struct {
 	streams map[string]*stream
 	mu      sync.Mutex
 	baseDir string // e.g., data/streams/
+	opts    config.StreamSettings
+}
+
+// Stream health states reported by Status.
+const (
+	StreamStopped      = "stopped"
+	StreamStarting     = "starting"     // ffmpeg running, no playlist yet
+	StreamHealthy      = "healthy"      // playlist is being updated
+	StreamReconnecting = "reconnecting" // waiting to restart ffmpeg
+	StreamFailed       = "failed"       // gave up after maxStreamRestarts
+	StreamEnded        = "ended"        // an NVR recording played to its end
+)
+
+// Stream sources reported by Status.
+const (
+	StreamSourceRTSP       = "rtsp"       // the camera's live feed
+	StreamSourceRecordings = "recordings" // uploaded files played back-to-back
+	StreamSourceNVR        = "nvr"        // a stretch of NVR recordings, via RTSP playback
+)
+
+const (
+	// maxStreamRestarts is how many restarts in a row may fail (exit before
+	// the stream became healthy) before the stream is marked failed.
+	maxStreamRestarts = 5
+	maxStreamBackoff  = 30 * time.Second
+	// streamEvictIdle is how long a stream must go unrequested before it
+	// may be stopped to make room under stream.max_streams. Players fetch
+	// the playlist every segment, so a watched stream is touched far more
+	// often.
+	streamEvictIdle = 15 * time.Second
+)
+
+// streamLimit enforces stream.max_streams across the Streamers of every
+// workspace, which share the machine's CPU and GPU.
+var streamLimit = struct {
+	sync.Mutex // held while a stream is admitted and launched
+	streamers  map[*Streamer]struct{}
+}{streamers: make(map[*Streamer]struct{})}
+
+// StreamStatus describes a camera's live stream.
+type StreamStatus struct {
+	CameraID   string     `json:"camera_id"`
+	State      string     `json:"state"`
+	Format     string     `json:"format,omitempty"`
+	Source     string     `json:"source,omitempty"`
+	Encoder    string     `json:"encoder,omitempty"` // e.g. libx264, h264_vaapi
+	Restarts   int        `json:"restarts"`
+	LastError  string     `json:"last_error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`  // current ffmpeg process
+	LastAccess *time.Time `json:"last_access,omitempty"` // last playlist or segment request
 }
 
 type stream struct {
-	cmd        *exec.Cmd
+	cmd        *exec.Cmd // current ffmpeg process; nil between restarts
 	dir        string
+	source     string
+	input      []string // ffmpeg input arguments
+	format     string   // HLS format, see Streamer.format
+	params     StreamParams
+	encoder    string // ffmpeg video encoder of the current launch
+	listFile   string // concat list for StreamSourceRecordings
+	list       string // its contents, rewritten on every launch
 	lastAccess time.Time
+	stop       chan struct{} // closed by Stop
+	log        *ffmpegLog    // ffmpeg output of every launch
+
+	// Guarded by Streamer.mu
+	state     string
+	restarts  int
+	lastError string
+	startedAt time.Time
 }
 
-func NewStreamer(baseDir string) *Streamer {
+func NewStreamer(baseDir string, opts config.StreamSettings) *Streamer {
 	os.MkdirAll(baseDir, 0o755)
-	return &Streamer{
+	s := &Streamer{
 		streams: make(map[string]*stream),
 		baseDir: baseDir,
+		opts:    opts,
+	}
+	streamLimit.Lock()
+	streamLimit.streamers[s] = struct{}{}
+	streamLimit.Unlock()
+	// Detect hardware encoders now rather than delaying the first stream
+	go s.encoder()
+	return s
+}
+
+// StreamParams are the per-stream HLS and encoding parameters, resolved by
+// ResolveStreamParams from the stream.* settings and camera overrides.
+type StreamParams struct {
+	SegmentSec   float64
+	PlaylistSize int // segments listed in the playlist
+	IdleTimeout  time.Duration
+	MaxHeight    int    // downscale taller video; 0 keeps the source size
+	BitrateKbps  int    // cap the video bitrate; 0 leaves it to the encoder
+	NVRStream    int    // 1 = main stream, 2 = substream
+	Audio        string // StreamAudioAAC, StreamAudioCopy or StreamAudioNone
+}
+
+// Audio handling in live streams, set per camera as config.stream.audio.
+// Many RTSP sources carry G.711, which browsers can't play: transcode it,
+// or drop it if the encode fails.
+const (
+	StreamAudioAAC  = "aac"  // transcode to AAC (default)
+	StreamAudioCopy = "copy" // pass through, for sources that already send AAC
+	StreamAudioNone = "none" // drop audio
+)
+
+// ResolveStreamParams reads the stream.* settings, overridden by the keys of
+// the camera config's "stream" object (the same names without the prefix),
+// e.g. {"stream": {"bitrate_kbps": 400, "max_height": 360}}.
+func ResolveStreamParams(settings *SettingsService, camConfig map[string]any) StreamParams {
+	overrides, _ := camConfig["stream"].(map[string]any)
+	float := func(name string) float64 {
+		if v, ok := overrides[name]; ok {
+			if f, err := toFloat64(v); err == nil {
+				return f
+			}
+		}
+		return settings.GetFloat64("stream." + name)
+	}
+	integer := func(name string) int { return int(float(name)) }
+	p := StreamParams{
+		SegmentSec:   float("segment_sec"),
+		PlaylistSize: integer("playlist_size"),
+		IdleTimeout:  time.Duration(integer("idle_timeout_sec")) * time.Second,
+		MaxHeight:    integer("max_height"),
+		BitrateKbps:  integer("bitrate_kbps"),
+		NVRStream:    integer("nvr_stream"),
+		Audio:        StreamAudioAAC,
+	}
+	if audio, ok := overrides["audio"].(string); ok && audio != "" {
+		p.Audio = audio
+	}
+	return p
+}
+
+// ValidateStreamOverrides checks a camera config's "stream" object against
+// the ranges of the matching stream.* settings.
+func ValidateStreamOverrides(camConfig map[string]any) error {
+	v, ok := camConfig["stream"]
+	if !ok {
+		return nil
+	}
+	overrides, ok := v.(map[string]any)
+	if !ok {
+		return newError(ErrInvalidInput, "config.stream must be an object")
+	}
+	for name, value := range overrides {
+		if name == "audio" {
+			switch value {
+			case StreamAudioAAC, StreamAudioCopy, StreamAudioNone:
+				continue
+			}
+			return newError(ErrInvalidInput, "config.stream.audio must be %q, %q or %q", StreamAudioAAC, StreamAudioCopy, StreamAudioNone)
+		}
+		if err := ValidateSetting("stream."+name, value); err != nil {
+			return newError(ErrInvalidInput, "config.stream.%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// format is the HLS format of a stream from source. NVR playback is a
+// finite recording that gains nothing from low latency, so it gets fMP4
+// segments instead of LL-HLS.
+func (s *Streamer) format(source string) string {
+	if source == StreamSourceNVR && s.opts.Format == "llhls" {
+		return "fmp4"
+	}
+	return s.opts.Format
+}
+
+// ffmpegArgs builds the transcoding command line for st's format. fMP4
+// formats force a keyframe at every segment (or part) boundary so each one
+// can be decoded on its own. NVR playback keeps every segment in an EVENT
+// playlist, so everything received so far can be seeked in.
+func (s *Streamer) ffmpegArgs(enc videoEncoder, st *stream) []string {
+	p, dir := st.params, st.dir
+	args := append(append([]string{}, enc.global...), st.input...)
+	args = append(args, "-c:v", enc.name)
+	args = append(args, enc.opts...)
+	var filters []string
+	if p.MaxHeight > 0 {
+		// Never upscale; -2 keeps the width even as H.264 encoders require
+		filters = append(filters, fmt.Sprintf("scale=-2:'min(%d,ih)'", p.MaxHeight))
+	}
+	if filters = append(filters, enc.filters...); len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
 	}
+	if p.BitrateKbps > 0 {
+		rate := strconv.Itoa(p.BitrateKbps) + "k"
+		args = append(args, "-b:v", rate, "-maxrate", rate, "-bufsize", strconv.Itoa(2*p.BitrateKbps)+"k")
+	}
+	switch p.Audio {
+	case StreamAudioNone:
+		args = append(args, "-an")
+	case StreamAudioCopy:
+		args = append(args, "-c:a", "copy")
+	default:
+		args = append(args, "-c:a", "aac")
+	}
+	listSize, flags := strconv.Itoa(p.PlaylistSize), "delete_segments"
+	if st.source == StreamSourceNVR {
+		args = append(args, "-hls_playlist_type", "event")
+		listSize, flags = "0", ""
+	}
+	partSec := s.partSec(p)
+	secs := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	keyframes := func(every float64) []string {
+		return []string{"-force_key_frames", "expr:gte(t,n_forced*" + secs(every) + ")", "-sc_threshold", "0"}
+	}
+
+	switch st.format {
+	case "ts":
+		args = append(args, "-g", "40",
+			"-f", "hls",
+			"-hls_time", secs(p.SegmentSec),
+			"-hls_list_size", listSize,
+		)
+		if flags != "" {
+			args = append(args, "-hls_flags", flags)
+		}
+		return append(args, "-y", filepath.Join(dir, "index.m3u8"))
+	case "fmp4":
+		args = append(args, keyframes(p.SegmentSec)...)
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", secs(p.SegmentSec),
+			"-hls_list_size", listSize,
+			"-hls_flags", strings.TrimPrefix(flags+"+independent_segments", "+"),
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", "init.mp4",
+			"-hls_segment_filename", filepath.Join(dir, "seg_%d.m4s"),
+		)
+		return append(args, "-y", filepath.Join(dir, "index.m3u8"))
+	default: // llhls
+		perSegment := int(math.Max(1, math.Round(p.SegmentSec/partSec)))
+		args = append(args, keyframes(partSec)...)
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", secs(partSec),
+			// Enough parts for the newest segments' EXT-X-PART tags
+			"-hls_list_size", strconv.Itoa((llhlsPartSegments+2)*perSegment),
+			"-hls_flags", "delete_segments+independent_segments",
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", "init.mp4",
+			"-hls_segment_filename", filepath.Join(dir, "part_%d.m4s"),
+			"-start_number", "0",
+		)
+		return append(args, "-y", filepath.Join(dir, llhlsPartsPlaylist))
+	}
+}
+
+// Start spawns a supervised ffmpeg process to transcode RTSP to HLS for the
+// given camera. A stream that already runs is kept; a failed one is started
+// afresh.
+func (s *Streamer) Start(cameraID, rtspURL string, p StreamParams) error {
+	return s.start(cameraID, StreamSourceRTSP, []string{"-rtsp_transport", "tcp", "-i", rtspURL}, "", p)
+}
+
+// StartNVR streams NVR recordings through an RTSP playback URL (see
+// PlaybackRTSPUrl) for review before they are downloaded. The NVR sends them
+// in real time; the stream ends, rather than restarts, when ffmpeg reaches
+// the end.
+func (s *Streamer) StartNVR(cameraID, playbackURL string, p StreamParams) error {
+	return s.start(cameraID, StreamSourceNVR, []string{"-rtsp_transport", "tcp", "-i", playbackURL}, "", p)
+}
+
+// partSec is the LL-HLS part length, which can't exceed the segment length.
+func (s *Streamer) partSec(p StreamParams) float64 {
+	return math.Min(s.opts.PartSec, p.SegmentSec)
+}
+
+// StartPlayback streams files back-to-back, looping, at their native frame
+// rate: a pseudo-live view for cameras that only have uploaded recordings.
+// The files should share codec and resolution, as ffmpeg's concat demuxer
+// expects.
+func (s *Streamer) StartPlayback(cameraID string, files []string, p StreamParams) error {
+	var list strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(f, "'", `'\''`))
+	}
+	// Kept beside the stream directory, which is emptied on every launch
+	listFile := filepath.Join(s.baseDir, cameraID+".concat")
+	input := []string{"-re", "-stream_loop", "-1", "-f", "concat", "-safe", "0", "-i", listFile}
+	return s.start(cameraID, StreamSourceRecordings, input, list.String(), p)
 }
 
-// Start spawns an ffmpeg process to transcode RTSP to HLS for the given camera.
-func (s *Streamer) Start(cameraID, rtspURL string) error {
+// start launches and supervises a stream unless the same one is already
+// running; a stream from another input is replaced. list, if set, is
+// written to the concat list file the input reads.
+func (s *Streamer) start(cameraID, source string, input []string, list string, p StreamParams) error {
+	streamLimit.Lock()
+	defer streamLimit.Unlock()
+
+	s.mu.Lock()
+	if st, ok := s.streams[cameraID]; ok {
+		if st.state != StreamFailed && st.state != StreamEnded && slices.Equal(st.input, input) {
+			st.lastAccess = time.Now()
+			s.mu.Unlock()
+			return nil
+		}
+		s.mu.Unlock()
+		s.Stop(cameraID)
+	} else {
+		s.mu.Unlock()
+	}
+	if err := s.admit(); err != nil {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Already running?
-	if st, ok := s.streams[cameraID]; ok {
-		st.lastAccess = time.Now()
+	st := &stream{
+		dir:        filepath.Join(s.baseDir, cameraID),
+		source:     source,
+		input:      input,
+		format:     s.format(source),
+		params:     p,
+		lastAccess: time.Now(),
+		stop:       make(chan struct{}),
+		log:        &ffmpegLog{},
+	}
+	if list != "" {
+		st.listFile, st.list = input[len(input)-1], list
+	}
+	exited, err := s.launch(cameraID, st)
+	if err != nil {
+		if st.listFile != "" {
+			os.Remove(st.listFile)
+		}
+		return err
+	}
+	s.streams[cameraID] = st
+	go s.supervise(cameraID, st, exited)
+	return nil
+}
+
+// admit makes room for one more stream under stream.max_streams by stopping
+// the least recently requested stream of any workspace, provided nobody has
+// requested it for streamEvictIdle. Failed and ended streams run no ffmpeg
+// and don't count. Callers hold streamLimit but not s.mu.
+func (s *Streamer) admit() error {
+	var victim *Streamer
+	var victimID string
+	var oldest time.Time
+	running := 0
+	for other := range streamLimit.streamers {
+		other.mu.Lock()
+		for id, st := range other.streams {
+			if st.state == StreamFailed || st.state == StreamEnded {
+				continue
+			}
+			running++
+			if time.Since(st.lastAccess) >= streamEvictIdle && (victim == nil || st.lastAccess.Before(oldest)) {
+				victim, victimID, oldest = other, id, st.lastAccess
+			}
+		}
+		other.mu.Unlock()
+	}
+	if s.opts.MaxStreams <= 0 || running < s.opts.MaxStreams {
 		return nil
 	}
+	if victim == nil {
+		return fmt.Errorf("%w: all %d are being watched", ErrStreamLimit, running)
+	}
+	log.Printf("Stream limit of %d reached, stopping the stream for camera %s (unwatched for %s)",
+		s.opts.MaxStreams, victimID, time.Since(oldest).Round(time.Second))
+	victim.Stop(victimID)
+	return nil
+}
 
-	dir := filepath.Join(s.baseDir, cameraID)
-	os.MkdirAll(dir, 0o755)
-
-	playlist := filepath.Join(dir, "index.m3u8")
-	cmd := exec.Command(
-		"ffmpeg",
-		"-rtsp_transport", "tcp",
-		"-i", rtspURL,
-		"-c:v", "libx264",
-		"-preset", "ultrafast",
-		"-tune", "zerolatency",
-		"-g", "40",
-		"-c:a", "aac",
-		"-f", "hls",
-		"-hls_time", "2",
-		"-hls_list_size", "5",
-		"-hls_flags", "delete_segments",
-		"-y",
-		playlist,
-	)
-	cmd.Stdout = nil
-	cmd.Stderr = log.Writer()
+// launch starts ffmpeg for st in an emptied directory and returns a channel
+// closed when it exits. Callers hold s.mu.
+func (s *Streamer) launch(cameraID string, st *stream) (<-chan struct{}, error) {
+	// Segment numbering restarts with ffmpeg; don't mix in the old ones
+	os.RemoveAll(st.dir)
+	os.MkdirAll(st.dir, 0o755)
+	if st.listFile != "" {
+		if err := os.WriteFile(st.listFile, []byte(st.list), 0o644); err != nil {
+			return nil, fmt.Errorf("writing playlist: %w", err)
+		}
+	}
 
+	enc := s.encoder()
+	args := s.ffmpegArgs(enc, st)
+	stderr := &stderrWriter{w: log.Writer(), log: st.log}
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stdout = nil
+	cmd.Stderr = stderr
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("starting ffmpeg: %w", err)
+		st.log.add("starting ffmpeg: " + err.Error())
+		return nil, fmt.Errorf("starting ffmpeg: %w", err)
 	}
+	log.Printf("Stream started for camera %s (%s from %s with %s, pid %d)", cameraID, st.format, st.source, enc.name, cmd.Process.Pid)
+	st.log.setProgress(ffmpegProgress{})
+	st.log.add(fmt.Sprintf("started ffmpeg (pid %d): ffmpeg %s", cmd.Process.Pid, strings.Join(args, " ")))
 
-	log.Printf("Stream started for camera %s (pid %d)", cameraID, cmd.Process.Pid)
+	st.cmd = cmd
+	st.encoder = enc.name
+	st.state = StreamStarting
+	st.startedAt = time.Now()
 
-	s.streams[cameraID] = &stream{
-		cmd:        cmd,
-		dir:        dir,
-		lastAccess: time.Now(),
+	// ffmpeg can't write LL-HLS playlists itself; package its parts until
+	// it exits
+	exited := make(chan struct{})
+	if st.format == "llhls" {
+		go newLLHLSPackager(st.dir, st.params.SegmentSec, s.partSec(st.params), st.params.PlaylistSize).run(exited)
 	}
-
-	// Reap process when it exits
 	go func() {
-		cmd.Wait()
+		err := cmd.Wait()
 		s.mu.Lock()
-		if st, ok := s.streams[cameraID]; ok && st.cmd == cmd {
-			delete(s.streams, cameraID)
+		if st.cmd == cmd {
+			st.cmd = nil
+			if err == nil && st.source == StreamSourceNVR {
+				st.state = StreamEnded
+			} else if msg := stderr.last(); msg != "" {
+				st.lastError = msg
+			} else if err != nil {
+				st.lastError = err.Error()
+			} else {
+				st.lastError = "ffmpeg exited"
+			}
 		}
 		s.mu.Unlock()
+		close(exited)
 	}()
+	return exited, nil
+}
 
-	return nil
+// supervise watches a stream until Stop: it marks it healthy once the
+// playlist appears, kills ffmpeg if the playlist stops changing, and restarts
+// ffmpeg with exponential backoff whenever it exits.
+func (s *Streamer) supervise(cameraID string, st *stream, exited <-chan struct{}) {
+	playlist := filepath.Join(st.dir, "index.m3u8")
+	// A stalled RTSP source can leave ffmpeg running without output
+	stallAfter := time.Duration(math.Max(10, 4*st.params.SegmentSec) * float64(time.Second))
+	failures := 0
+
+	for {
+		becameHealthy := s.watch(cameraID, st, exited, playlist, stallAfter)
+
+		select {
+		case <-st.stop:
+			return
+		default:
+		}
+		s.mu.Lock()
+		ended := st.state == StreamEnded
+		s.mu.Unlock()
+		if ended {
+			log.Printf("Stream for camera %s reached the end of the recording", cameraID)
+			return
+		}
+		if becameHealthy {
+			failures = 0
+		}
+		failures++
+
+		s.mu.Lock()
+		if failures > maxStreamRestarts {
+			st.state = StreamFailed
+			log.Printf("Stream for camera %s failed after %d restarts: %s", cameraID, maxStreamRestarts, st.lastError)
+			s.mu.Unlock()
+			return
+		}
+		st.state = StreamReconnecting
+		st.restarts++
+		backoff := min(time.Second<<(failures-1), maxStreamBackoff)
+		log.Printf("Stream for camera %s exited (%s), restarting in %s", cameraID, st.lastError, backoff)
+		s.mu.Unlock()
+
+		select {
+		case <-st.stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		s.mu.Lock()
+		select {
+		case <-st.stop:
+			// Stopped while we waited for the lock
+			s.mu.Unlock()
+			return
+		default:
+		}
+		var err error
+		exited, err = s.launch(cameraID, st)
+		if err != nil {
+			st.lastError = err.Error()
+			closed := make(chan struct{})
+			close(closed)
+			exited = closed
+		}
+		s.mu.Unlock()
+	}
 }
 
-// Stop kills the ffmpeg process and removes the temp directory.
+// watch polls the playlist until ffmpeg exits and reports whether the stream
+// became healthy in the meantime.
+func (s *Streamer) watch(cameraID string, st *stream, exited <-chan struct{}, playlist string, stallAfter time.Duration) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	healthy := false
+	for {
+		select {
+		case <-exited:
+			return healthy
+		case <-st.stop:
+			return healthy
+		case <-ticker.C:
+		}
+		info, err := os.Stat(playlist)
+		s.mu.Lock()
+		started := st.startedAt
+		switch {
+		// launch empties the directory, so any playlist is this process's
+		case err == nil && !healthy:
+			healthy = true
+			st.state = StreamHealthy
+		case healthy && (err != nil || time.Since(info.ModTime()) > stallAfter),
+			!healthy && time.Since(started) > 2*stallAfter:
+			if st.cmd != nil && st.cmd.Process != nil {
+				log.Printf("Stream for camera %s stalled, killing ffmpeg", cameraID)
+				st.lastError = "no new segments"
+				st.cmd.Process.Kill()
+				st.cmd = nil // keep this error rather than ffmpeg's
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Stop kills the ffmpeg process, ends supervision and removes the temp
+// directory.
 func (s *Streamer) Stop(cameraID string) error {
 	s.mu.Lock()
 	st, ok := s.streams[cameraID]
 	if ok {
 		delete(s.streams, cameraID)
+		close(st.stop)
+		if st.cmd != nil && st.cmd.Process != nil {
+			st.cmd.Process.Kill()
+		}
 	}
 	s.mu.Unlock()
 
@@ -103,19 +577,112 @@ func (s *Streamer) Stop(cameraID string) error {
 		return nil
 	}
 
-	if st.cmd.Process != nil {
-		st.cmd.Process.Kill()
-	}
 	os.RemoveAll(st.dir)
+	if st.listFile != "" {
+		os.Remove(st.listFile)
+	}
 	log.Printf("Stream stopped for camera %s", cameraID)
 	return nil
 }
 
-// Dir returns the HLS directory path for a camera's stream.
+// ApplyParams brings running streams in line with new stream settings, as
+// resolve now reports them for each camera. A new idle timeout is applied in
+// place; any other change restarts ffmpeg, which players ride out like any
+// restart. NVR playback is left alone, as a restart would rewind it.
+func (s *Streamer) ApplyParams(resolve func(cameraID string) StreamParams) {
+	s.mu.Lock()
+	current := make(map[string]StreamParams)
+	for id, st := range s.streams {
+		if st.state != StreamFailed && st.state != StreamEnded && st.source != StreamSourceNVR {
+			current[id] = st.params
+		}
+	}
+	s.mu.Unlock()
+
+	for id, old := range current {
+		p := resolve(id)
+		if p == old {
+			continue
+		}
+		s.mu.Lock()
+		st, ok := s.streams[id]
+		if !ok || st.params != old {
+			s.mu.Unlock()
+			continue // stopped or restarted meanwhile
+		}
+		if old.IdleTimeout = p.IdleTimeout; old == p {
+			st.params.IdleTimeout = p.IdleTimeout
+			s.mu.Unlock()
+			continue
+		}
+		source, input, list := st.source, st.input, st.list
+		s.mu.Unlock()
+
+		log.Printf("Stream settings changed, restarting the stream for camera %s", id)
+		s.Stop(id)
+		if err := s.start(id, source, input, list, p); err != nil {
+			log.Printf("Restarting the stream for camera %s: %v", id, err)
+		}
+	}
+}
+
+// Status reports the health of a camera's stream; cameras without one are
+// StreamStopped.
+func (s *Streamer) Status(cameraID string) StreamStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status(cameraID, s.streams[cameraID])
+}
+
+// Diagnostics reports a stream's status with ffmpeg's recent output and
+// throughput. A failed stream keeps its output until it is started again.
+func (s *Streamer) Diagnostics(cameraID string) StreamDiagnostics {
+	s.mu.Lock()
+	st := s.streams[cameraID]
+	d := StreamDiagnostics{StreamStatus: s.status(cameraID, st), Log: []StreamLogLine{}}
+	if st == nil {
+		s.mu.Unlock()
+		return d
+	}
+	running, started := st.cmd != nil, st.startedAt
+	s.mu.Unlock()
+
+	lines, progress := st.log.snapshot()
+	d.Log = lines
+	if running {
+		d.UptimeSec = time.Since(started).Seconds()
+		d.Frames, d.FPS, d.Speed = progress.frames, progress.fps, progress.speed
+		d.BitrateKbps = playlistBitrate(st.dir)
+	}
+	return d
+}
+
+// status describes st, which is nil for a camera without a stream. Callers
+// hold s.mu.
+func (s *Streamer) status(cameraID string, st *stream) StreamStatus {
+	if st == nil {
+		return StreamStatus{CameraID: cameraID, State: StreamStopped}
+	}
+	started, access := st.startedAt, st.lastAccess
+	return StreamStatus{
+		CameraID:   cameraID,
+		State:      st.state,
+		Format:     st.format,
+		Source:     st.source,
+		Encoder:    st.encoder,
+		Restarts:   st.restarts,
+		LastError:  st.lastError,
+		StartedAt:  &started,
+		LastAccess: &access,
+	}
+}
+
+// Dir returns the HLS directory path for a camera's stream, or "" if it
+// isn't running or has failed.
 func (s *Streamer) Dir(cameraID string) string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if st, ok := s.streams[cameraID]; ok {
+	if st, ok := s.streams[cameraID]; ok && st.state != StreamFailed {
 		return st.dir
 	}
 	return ""
@@ -138,7 +705,15 @@ func (s *Streamer) IsActive(cameraID string) bool {
 	return ok
 }
 
-// StartCleanup runs a background goroutine that kills streams idle for more than 30s.
+// ActiveCount returns the number of running streams.
+func (s *Streamer) ActiveCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// StartCleanup runs a background goroutine that kills streams nobody has
+// requested for their idle timeout.
 func (s *Streamer) StartCleanup() {
 	go func() {
 		ticker := time.NewTicker(10 * time.Second)
@@ -153,7 +728,7 @@ func (s *Streamer) cleanIdle() {
 	s.mu.Lock()
 	var toStop []string
 	for id, st := range s.streams {
-		if time.Since(st.lastAccess) > 30*time.Second {
+		if time.Since(st.lastAccess) > st.params.IdleTimeout {
 			toStop = append(toStop, id)
 		}
 	}
@@ -165,8 +740,13 @@ func (s *Streamer) cleanIdle() {
 	}
 }
 
-// StopAll stops all active streams (for graceful shutdown).
+// StopAll stops all active streams (for graceful shutdown, or when the
+// workspace closes) and releases the Streamer's share of stream.max_streams.
 func (s *Streamer) StopAll() {
+	streamLimit.Lock()
+	delete(streamLimit.streamers, s)
+	streamLimit.Unlock()
+
 	s.mu.Lock()
 	ids := make([]string, 0, len(s.streams))
 	for id := range s.streams {
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"math"
+	"time"
+)
+
+// Altitudes of the sun's centre, in degrees, that bound daylight and civil
+// twilight. Sunrise and sunset allow for refraction and the sun's radius.
+const (
+	sunriseAltitude = -0.833
+	civilAltitude   = -6.0
+)
+
+// sunCrossing is when the sun rises above and sets below an altitude on a
+// day. Up and down mark a day it never crosses it: above it all day, as in
+// a polar summer, or below it all day.
+type sunCrossing struct {
+	rise, set time.Time
+	up, down  bool
+}
+
+// above is whether the sun is above the altitude at t, a time of the day
+// the crossing was computed for.
+func (c sunCrossing) above(t time.Time) bool {
+	switch {
+	case c.up:
+		return true
+	case c.down:
+		return false
+	}
+	return !t.Before(c.rise) && t.Before(c.set)
+}
+
+// sunCrossingOn computes when the sun crosses altitude on date (its year,
+// month and day are taken as they are) at lat and lon (degrees, north and
+// east positive), by the sunrise equation. It is good to a minute or two
+// away from the poles.
+func sunCrossingOn(date time.Time, lat, lon, altitude float64) sunCrossing {
+	rad := math.Pi / 180
+	// Days since J2000.0 at noon UTC on date, then the mean solar noon at lon
+	noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, time.UTC)
+	n := float64(noon.Unix())/86400 + 2440587.5 - 2451545.0
+	j := n + 0.0008 - lon/360
+
+	m := math.Mod(357.5291+0.98560028*j, 360)
+	c := 1.9148*math.Sin(m*rad) + 0.02*math.Sin(2*m*rad) + 0.0003*math.Sin(3*m*rad)
+	lambda := math.Mod(m+c+180+102.9372, 360)
+	transit := 2451545.0 + j + 0.0053*math.Sin(m*rad) - 0.0069*math.Sin(2*lambda*rad)
+
+	sinDecl := math.Sin(lambda*rad) * math.Sin(23.4397*rad)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	cosHour := (math.Sin(altitude*rad) - math.Sin(lat*rad)*sinDecl) / (math.Cos(lat*rad) * cosDecl)
+	switch {
+	case cosHour < -1:
+		return sunCrossing{up: true}
+	case cosHour > 1:
+		return sunCrossing{down: true}
+	}
+	hour := math.Acos(cosHour) / rad
+	return sunCrossing{
+		rise: julianTime(transit - hour/360),
+		set:  julianTime(transit + hour/360),
+	}
+}
+
+// julianTime converts a Julian date to a time, to the second.
+func julianTime(jd float64) time.Time {
+	return time.Unix(int64(math.Round((jd-2440587.5)*86400)), 0)
+}
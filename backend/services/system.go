@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskUsage reports capacity of the filesystem holding a path.
+type DiskUsage struct {
+	TotalBytes uint64 `json:"total_bytes"`
+	FreeBytes  uint64 `json:"free_bytes"`
+}
+
+// ToolVersion returns the first line of "<name> -version" (e.g. the ffmpeg
+// or ffprobe banner). Results are cached for a minute because health checks
+// poll this frequently and spawning processes is comparatively expensive.
+func ToolVersion(name string) (string, error) {
+	toolVersionMu.Lock()
+	defer toolVersionMu.Unlock()
+
+	if c, ok := toolVersionCache[name]; ok && time.Since(c.at) < time.Minute {
+		return c.version, c.err
+	}
+
+	version, err := probeToolVersion(name)
+	toolVersionCache[name] = cachedToolVersion{version: version, err: err, at: time.Now()}
+	return version, err
+}
+
+type cachedToolVersion struct {
+	version string
+	err     error
+	at      time.Time
+}
+
+var (
+	toolVersionMu    sync.Mutex
+	toolVersionCache = make(map[string]cachedToolVersion)
+)
+
+func probeToolVersion(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s not found on PATH", name)
+	}
+	out, err := exec.Command(path, "-version").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s -version: %w", name, err)
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package services
+
+import "fmt"
+
+// GetDiskUsage is not supported on this platform.
+func GetDiskUsage(path string) (*DiskUsage, error) {
+	return nil, fmt.Errorf("disk usage not supported on this platform")
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package services
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// GetDiskUsage returns total and available bytes on the filesystem that
+// contains path.
+func GetDiskUsage(path string) (*DiskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, fmt.Errorf("statfs %s: %w", path, err)
+	}
+	return &DiskUsage{
+		TotalBytes: uint64(st.Blocks) * uint64(st.Bsize),
+		FreeBytes:  uint64(st.Bavail) * uint64(st.Bsize),
+	}, nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timelapse defaults and limits, shared by the API and the CLI.
+const (
+	DefaultTimelapseFPS    = 30
+	DefaultTimelapseHeight = 720
+	MaxTimelapseFPS        = 60
+	MinTimelapseHeight     = 144
+	MaxTimelapseHeight     = 2160
+)
+
+// TimelapseOptions shape a timelapse. Every extracted frame becomes one
+// video frame, so at 30 fps a day extracted every 5 seconds (17280 frames)
+// lasts under ten minutes.
+type TimelapseOptions struct {
+	FPS    int // output frame rate
+	Height int // output height in pixels; smaller frames aren't upscaled
+}
+
+// Validate checks the options against the timelapse limits.
+func (o TimelapseOptions) Validate() error {
+	if o.FPS < 1 || o.FPS > MaxTimelapseFPS {
+		return newError(ErrInvalidInput, "fps must be between 1 and %d, got %d", MaxTimelapseFPS, o.FPS)
+	}
+	if o.Height < MinTimelapseHeight || o.Height > MaxTimelapseHeight {
+		return newError(ErrInvalidInput, "height must be between %d and %d, got %d", MinTimelapseHeight, MaxTimelapseHeight, o.Height)
+	}
+	return nil
+}
+
+// timelapseLocks serializes building per output file, so concurrent
+// requests for the same timelapse run ffmpeg once.
+var timelapseLocks sync.Map // output path → *sync.Mutex
+
+// BuildTimelapse encodes the frames listed in framesDir's manifest, in time
+// order, into an H.264 MP4 at outPath and returns how many it used. Frames
+// whose JPEG is gone (e.g. removed by dedup) are skipped.
+func BuildTimelapse(ctx context.Context, framesDir, outPath string, opts TimelapseOptions) (int, error) {
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+	frames, err := LoadManifest(framesDir)
+	if err != nil {
+		return 0, fmt.Errorf("reading manifest: %w", err)
+	}
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].Timestamp.Before(frames[j].Timestamp) })
+
+	// ffmpeg's concat demuxer shows each listed image for its duration
+	frameSec := strconv.FormatFloat(1/float64(opts.FPS), 'f', 6, 64)
+	var list strings.Builder
+	list.WriteString("ffconcat version 1.0\n")
+	var last string
+	count := 0
+	for _, f := range frames {
+		path := f.FramePath
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(framesDir, filepath.Base(path))
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		fmt.Fprintf(&list, "file '%s'\nduration %s\n", strings.ReplaceAll(path, "'", `'\''`), frameSec)
+		last = path
+		count++
+	}
+	if count == 0 {
+		return 0, newError(ErrVideoNotFound, "no extracted frames in %s", framesDir)
+	}
+	// The last image's duration only counts if it is listed once more
+	fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(last, "'", `'\''`))
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return 0, err
+	}
+	listFile := outPath + ".ffconcat.tmp"
+	if err := os.WriteFile(listFile, []byte(list.String()), 0o644); err != nil {
+		return 0, err
+	}
+	defer os.Remove(listFile)
+
+	// Frames of one camera share a size, but pad to even dimensions anyway
+	// as yuv420p requires
+	filter := fmt.Sprintf("scale=-2:'min(%d,ih)',pad=ceil(iw/2)*2:ceil(ih/2)*2,fps=%d,format=yuv420p", opts.Height, opts.FPS)
+	tmp := outPath + ".tmp"
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error",
+		"-f", "concat", "-safe", "0", "-i", listFile,
+		"-vf", filter, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23",
+		"-movflags", "+faststart", "-f", "mp4", "-y", tmp)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("ffmpeg timelapse: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(tmp, outPath); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return count, nil
+}
+
+// Timelapse returns camera id's timelapse of date (YYYY-MM-DD), built from
+// its extracted frames. It is cached in data/timelapses/{camera}/ per fps
+// and height, and rebuilt once the date's manifest is newer.
+func (s *CameraService) Timelapse(ctx context.Context, id, date string, opts TimelapseOptions) (string, error) {
+	if _, err := s.Get(id); err != nil {
+		return "", err
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return "", newError(ErrInvalidInput, "date must be YYYY-MM-DD, got %q", date)
+	}
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+	framesDir := filepath.Join(s.cfg.Extraction.StoragePath, id, date)
+	manifest, err := os.Stat(filepath.Join(framesDir, "manifest.json"))
+	if err != nil {
+		return "", newError(ErrVideoNotFound, "no extracted frames for %s on %s", id, date)
+	}
+
+	out := filepath.Join(s.cfg.App.DataDir, "timelapses", id, fmt.Sprintf("%s_%dfps_%dp.mp4", date, opts.FPS, opts.Height))
+	mu, _ := timelapseLocks.LoadOrStore(out, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	if info, err := os.Stat(out); err == nil && !info.ModTime().Before(manifest.ModTime()) {
+		return out, nil
+	}
+	if _, err := BuildTimelapse(ctx, framesDir, out, opts); err != nil {
+		return "", err
+	}
+	return out, nil
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intelsk/backend/models"
+)
+
+// minTimelineGapSec ignores gaps shorter than this between recordings,
+// which are rounding in the file names rather than missing footage.
+const minTimelineGapSec = 1
+
+// TimelineTimeLayout is the wall-clock format Locate accepts and reports.
+const TimelineTimeLayout = "2006-01-02T15:04:05"
+
+// durationCache remembers recording durations by path, size and mtime so a
+// timeline doesn't run ffprobe on every file each time it is drawn.
+var durationCache = struct {
+	sync.Mutex
+	m map[string]cachedDuration
+}{m: make(map[string]cachedDuration)}
+
+type cachedDuration struct {
+	size    int64
+	modTime time.Time
+	sec     float64
+}
+
+func videoDuration(path string, info os.FileInfo) (float64, error) {
+	durationCache.Lock()
+	c, ok := durationCache.m[path]
+	durationCache.Unlock()
+	if ok && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		return c.sec, nil
+	}
+	probe, err := ProbeVideo(path)
+	if err != nil {
+		return 0, err
+	}
+	durationCache.Lock()
+	durationCache.m[path] = cachedDuration{size: info.Size(), modTime: info.ModTime(), sec: probe.DurationSec}
+	durationCache.Unlock()
+	return probe.DurationSec, nil
+}
+
+// recordingStartSec reads a recording's start, in seconds since midnight,
+// from its file name: "HHMM" (e.g. "1400", as NVR downloads and imports
+// write) or just "HH", optionally followed by a suffix such as "_1".
+func recordingStartSec(stem string) (float64, bool) {
+	rest := strings.TrimLeft(stem, "0123456789")
+	if rest != "" && !strings.HasPrefix(rest, "_") {
+		return 0, false // e.g. "2024-03-01_14-05-00", an import without a known time
+	}
+	var h, m int
+	var err error
+	switch len(stem) - len(rest) {
+	case 4:
+		h, err = strconv.Atoi(stem[:2])
+		if err == nil {
+			m, err = strconv.Atoi(stem[2:4])
+		}
+	case 2:
+		h, err = strconv.Atoi(stem[:2])
+	default:
+		return 0, false
+	}
+	if err != nil || h > 23 || m > 59 {
+		return 0, false
+	}
+	return float64(h*3600 + m*60), true
+}
+
+// Timeline stitches a camera's recordings for date (YYYY-MM-DD) into one
+// day: each recording spans its start plus its duration, and the uncovered
+// stretches are reported as gaps. The start and clock offset are those its
+// frames were stamped with, or for a recording not processed yet the time
+// in its file name and the camera's current offset.
+func (s *CameraService) Timeline(id, date string) (*models.CameraTimeline, error) {
+	cam, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return nil, newError(ErrInvalidInput, "date must be YYYY-MM-DD, got %q", date)
+	}
+
+	tl := &models.CameraTimeline{
+		CameraID: id,
+		Date:     date,
+		DaySec:   24 * 3600,
+		Segments: []models.TimelineSegment{},
+		Gaps:     []models.TimelineGap{},
+	}
+	now := s.now()
+	if date == now.Format("2006-01-02") {
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		tl.DaySec = math.Floor(now.Sub(midnight).Seconds())
+	}
+
+	dir := filepath.Join(s.cfg.App.DataDir, "videos", id, date)
+	entries, _ := os.ReadDir(dir) // no recordings that day is an empty timeline
+	times := loadVideoTimes(dir)
+	day, _ := time.ParseInLocation("2006-01-02", date, now.Location())
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(strings.ToLower(e.Name()), ".mp4") {
+			continue
+		}
+		stem := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if e.Name() == dayArchiveName {
+			tl.Segments = append(tl.Segments, archiveTimeline(dir, id+"--"+date+"--"+stem, day)...)
+			continue
+		}
+		var start float64
+		var offset int
+		if vt, ok := times[e.Name()]; ok {
+			t, err := time.Parse(time.RFC3339, vt.Start)
+			if err != nil {
+				continue
+			}
+			start, offset = wallClockSec(t, day), vt.OffsetSec
+		} else if sec, ok := recordingStartSec(stem); ok {
+			start, offset = sec, ClockOffset(cam.Config)
+		} else {
+			tl.Untimed = append(tl.Untimed, e.Name())
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		dur, err := videoDuration(filepath.Join(dir, e.Name()), info)
+		if err != nil {
+			log.Printf("Timeline: skipping %s/%s/%s: %v", id, date, e.Name(), err)
+			continue
+		}
+		start += float64(offset)
+		if start+dur <= 0 || start >= 24*3600 {
+			continue // shifted into the day before or after
+		}
+		tl.Segments = append(tl.Segments, models.TimelineSegment{
+			VideoID:  id + "--" + date + "--" + stem,
+			Filename: e.Name(),
+			StartSec: start, // below 0 when shifted over midnight
+			EndSec:   math.Min(start+dur, 24*3600),
+		})
+	}
+	sort.Slice(tl.Segments, func(i, j int) bool {
+		return tl.Segments[i].StartSec < tl.Segments[j].StartSec
+	})
+
+	covered := 0.0 // end of the footage seen so far
+	for _, seg := range tl.Segments {
+		if seg.StartSec-covered >= minTimelineGapSec {
+			tl.Gaps = append(tl.Gaps, models.TimelineGap{StartSec: covered, EndSec: seg.StartSec})
+		}
+		if seg.EndSec > covered {
+			tl.RecordedSec += seg.EndSec - math.Max(seg.StartSec, covered)
+			covered = seg.EndSec
+		}
+	}
+	if tl.DaySec-covered >= minTimelineGapSec {
+		tl.Gaps = append(tl.Gaps, models.TimelineGap{StartSec: covered, EndSec: tl.DaySec})
+	}
+	return tl, nil
+}
+
+// archiveTimeline returns the recordings merged into the day archive in
+// dir as timeline segments of videoID, the archive, on day.
+func archiveTimeline(dir, videoID string, day time.Time) []models.TimelineSegment {
+	archive := loadDayArchive(dir)
+	if archive == nil {
+		return nil
+	}
+	var segments []models.TimelineSegment
+	for _, seg := range archive.Segments {
+		t, ok := seg.start()
+		if !ok {
+			continue
+		}
+		start := wallClockSec(t, day)
+		if start+seg.DurationSec <= 0 || start >= 24*3600 {
+			continue
+		}
+		segments = append(segments, models.TimelineSegment{
+			VideoID:       videoID,
+			Filename:      seg.Name,
+			StartSec:      start,
+			EndSec:        math.Min(start+seg.DurationSec, 24*3600),
+			VideoStartSec: seg.AtSec,
+		})
+	}
+	return segments
+}
+
+// wallClockSec is t as seconds since midnight of day on the wall clock of
+// day's zone, the scale timelines and file names use: on a DST day as much
+// as on any other, 14:00 is 50400. It is negative or past a day for times
+// on the day before or after.
+func wallClockSec(t, day time.Time) float64 {
+	t = t.In(day.Location())
+	days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Sub(
+		time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24
+	return days*24*3600 + float64(t.Hour()*3600+t.Minute()*60+t.Second())
+}
+
+// Locate finds the recording covering a wall-clock time and the offset
+// into it. Where recordings overlap the one that started last wins. A time
+// with no footage reports the gap instead, whose end is where footage
+// resumes.
+func (s *CameraService) Locate(id string, at time.Time) (*models.TimelinePosition, error) {
+	date := at.Format("2006-01-02")
+	tl, err := s.Timeline(id, date)
+	if err != nil {
+		return nil, err
+	}
+	sec := float64(at.Hour()*3600+at.Minute()*60+at.Second()) + float64(at.Nanosecond())/1e9
+	pos := &models.TimelinePosition{CameraID: id, At: at.Format(TimelineTimeLayout)}
+
+	for i := len(tl.Segments) - 1; i >= 0; i-- {
+		seg := tl.Segments[i]
+		if seg.StartSec <= sec && sec < seg.EndSec {
+			pos.VideoID = seg.VideoID
+			pos.OffsetSec = seg.VideoStartSec + sec - seg.StartSec
+			pos.Segment = &seg
+			return pos, nil
+		}
+	}
+	for _, gap := range tl.Gaps {
+		if gap.StartSec <= sec && sec < gap.EndSec {
+			pos.Gap = &gap
+			return pos, nil
+		}
+	}
+	// Inside a gap too short to report: play the next recording from its start
+	for _, seg := range tl.Segments {
+		if seg.StartSec > sec {
+			pos.VideoID = seg.VideoID
+			pos.OffsetSec = seg.VideoStartSec
+			pos.Segment = &seg
+			return pos, nil
+		}
+	}
+	return nil, newError(ErrInvalidInput, "%s is in the future", pos.At)
+}
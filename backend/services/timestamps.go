@@ -0,0 +1,262 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frame and embedding timestamps are stored in UTC as RFC 3339 ("...Z"),
+// which compare as strings in time order. Requests name times and dates on
+// the cameras' wall clock in general.timezone, and responses give them in
+// that zone with its offset; the functions here convert at that boundary.
+// Frame manifests keep each frame's time in the camera zone, with its
+// offset, so frame IDs go on naming frames by their wall-clock time.
+
+// timestampLayouts are the forms a requested time may take. Those without
+// a zone are wall-clock time in general.timezone.
+var timestampLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// timestampsVersion is the PRAGMA user_version of a database whose
+// timestamps are stored in UTC.
+const timestampsVersion = 1
+
+// FormatTimestamp returns t in the stored form.
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ParseTimestamp reads a stored or requested time. One without a zone is
+// taken as wall-clock time in loc.
+func ParseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, newError(ErrInvalidInput, "%q is not YYYY-MM-DD, YYYY-MM-DDTHH:MM[:SS] or RFC 3339", s)
+}
+
+// LocalTimestamp converts a stored timestamp to RFC 3339 in loc, for a
+// response. A value that doesn't parse is returned as it is.
+func LocalTimestamp(s string, loc *time.Location) string {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return s
+	}
+	return t.In(loc).Format(time.RFC3339)
+}
+
+// TimeRange turns requested start and end times into inclusive bounds in
+// the stored form. Either may be a date (YYYY-MM-DD), which as an end
+// covers that whole day, or a time as ParseTimestamp reads it; an empty one
+// stays empty, for no bound.
+func TimeRange(start, end string, loc *time.Location) (from, to string, err error) {
+	if start != "" {
+		t, err := parseBound(start, loc)
+		if err != nil {
+			return "", "", err
+		}
+		from = FormatTimestamp(t)
+	}
+	if end != "" {
+		t, err := parseBound(end, loc)
+		if err != nil {
+			return "", "", err
+		}
+		if len(end) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1).Add(-time.Second) // 23:59:59, however long the day is
+		}
+		to = FormatTimestamp(t)
+	}
+	if from != "" && to != "" && to < from {
+		return "", "", newError(ErrInvalidInput, "end %s is before start %s", end, start)
+	}
+	return from, to, nil
+}
+
+// DayRange is TimeRange for the one day date.
+func DayRange(date string, loc *time.Location) (from, to string, err error) {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return "", "", newError(ErrInvalidInput, "date must be YYYY-MM-DD, got %q", date)
+	}
+	return TimeRange(date, date, loc)
+}
+
+func parseBound(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		return t, nil
+	}
+	return ParseTimestamp(s, loc)
+}
+
+// normalizeTimestamps moves a database, and the frame manifests and video
+// records its frames came from, from the wall-clock timestamps older
+// versions stored (written with a "Z" they didn't mean, or no zone at all)
+// to UTC. The wall clock is read in the database's general.timezone. It
+// runs once; user_version records that it has.
+func normalizeTimestamps(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version >= timestampsVersion {
+		return nil
+	}
+
+	loc := time.Local
+	var zone string
+	db.QueryRow("SELECT value FROM settings WHERE key = 'general.timezone'").Scan(&zone)
+	if zone != "" {
+		if l, err := time.LoadLocation(zone); err == nil {
+			loc = l
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	n := 0
+	for _, table := range []string{"clip_embeddings", "face_embeddings", "alerts", "bookmarks", "case_items"} {
+		converted, err := normalizeTableTimestamps(tx, table, loc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+		n += converted
+	}
+	// Frame directories are {root}/{camera}/{date}; find the roots from
+	// the frames indexed
+	roots := make(map[string]bool)
+	rows, err := tx.Query("SELECT frame_path FROM clip_embeddings GROUP BY camera_id")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return err
+		}
+		roots[filepath.Dir(filepath.Dir(filepath.Dir(path)))] = true
+	}
+	rows.Close()
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", timestampsVersion)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for root := range roots {
+		manifests, _ := filepath.Glob(filepath.Join(root, "*", "*", "manifest.json"))
+		for _, m := range manifests {
+			if err := normalizeManifest(filepath.Dir(m), loc); err != nil {
+				log.Printf("Normalizing timestamps in %s: %v", m, err)
+			}
+		}
+	}
+	if n > 0 {
+		log.Printf("Converted %d stored timestamps from %s wall-clock time to UTC", n, loc)
+	}
+	return nil
+}
+
+// normalizeTableTimestamps converts a table's timestamp column from
+// wall-clock time in loc to the stored form, a batch of rows at a time.
+func normalizeTableTimestamps(tx *sql.Tx, table string, loc *time.Location) (int, error) {
+	const batch = 5000
+	n := 0
+	var last int64
+	for {
+		rows, err := tx.Query(fmt.Sprintf("SELECT rowid, timestamp FROM %s WHERE rowid > ? ORDER BY rowid LIMIT %d", table, batch), last)
+		if err != nil {
+			return n, err
+		}
+		type row struct {
+			id int64
+			ts string
+		}
+		var page []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.ts); err != nil {
+				rows.Close()
+				return n, err
+			}
+			page = append(page, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return n, err
+		}
+		for _, r := range page {
+			last = r.id
+			t, err := time.ParseInLocation("2006-01-02T15:04:05", r.ts[:min(len(r.ts), len("2006-01-02T15:04:05"))], loc)
+			if err != nil {
+				continue // empty, e.g. an alert without a frame
+			}
+			if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET timestamp = ? WHERE rowid = ?", table), FormatTimestamp(t), r.id); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(page) < batch {
+			return n, nil
+		}
+	}
+}
+
+// normalizeManifest gives the frames of the manifest in dir their
+// wall-clock time in loc, and records the start of each of their videos
+// as older versions took it: the hour at the start of the file name, or
+// midnight.
+func normalizeManifest(dir string, loc *time.Location) error {
+	frames, err := LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+	date, err := time.ParseInLocation("2006-01-02", filepath.Base(dir), loc)
+	if err != nil {
+		return nil // not a date directory
+	}
+	videos := make(map[string]bool)
+	for i, f := range frames {
+		t := f.Timestamp
+		frames[i].Timestamp = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+		videos[f.SourceVideo] = true
+	}
+	if err := WriteManifest(dir, frames); err != nil {
+		return err
+	}
+
+	for video := range videos {
+		if video == "" {
+			continue
+		}
+		if _, _, ok := recordedVideoTime(video); ok {
+			continue
+		}
+		hour := 0
+		stem := strings.TrimSuffix(filepath.Base(video), filepath.Ext(video))
+		if len(stem) >= 2 {
+			if h, err := strconv.Atoi(stem[:2]); err == nil && h <= 23 {
+				hour = h
+			}
+		}
+		if _, err := os.Stat(filepath.Dir(video)); err != nil {
+			continue // the videos were deleted, or live elsewhere now
+		}
+		start := time.Date(date.Year(), date.Month(), date.Day(), hour, 0, 0, 0, loc)
+		if err := recordVideoTime(video, start, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/intelsk/backend/models"
+)
+
+// uploadSessionTTL is how long an unfinished upload may sit idle before its
+// partial data is discarded.
+const uploadSessionTTL = 24 * time.Hour
+
+var validUploadID = regexp.MustCompile(`^[0-9a-f-]{36}$`)
+
+// uploadLock guards one upload's session file. It is held to check the
+// offset and save the session, not while a chunk streams in from the
+// network, so a slow client holds up no one else; receiving marks the
+// chunk in flight meanwhile, and gone an upload aborted under it.
+type uploadLock struct {
+	mu        sync.Mutex
+	receiving bool
+	gone      bool
+}
+
+var (
+	uploadLocksMu sync.Mutex
+	uploadLocks   = make(map[string]*uploadLock) // upload ID → lock
+)
+
+// lockUpload returns the upload's lock, locked.
+func lockUpload(uploadID string) *uploadLock {
+	uploadLocksMu.Lock()
+	l := uploadLocks[uploadID]
+	if l == nil {
+		l = &uploadLock{}
+		uploadLocks[uploadID] = l
+	}
+	uploadLocksMu.Unlock()
+	l.mu.Lock()
+	return l
+}
+
+// Resumable uploads keep two files per session under data/uploads:
+// {id}.json with the session metadata and {id}.part with the bytes received
+// so far. Finalizing stages the .part file for validation, after which
+// PlaceUpload moves it into the camera's video directory.
+
+func (s *CameraService) uploadsDir() string {
+	return filepath.Join(s.cfg.App.DataDir, "uploads")
+}
+
+// CreateUploadSession starts a resumable upload of size bytes for a camera.
+func (s *CameraService) CreateUploadSession(cameraID string, req models.CreateUploadRequest) (*models.UploadSession, error) {
+	if _, err := s.Get(cameraID); err != nil {
+		return nil, err
+	}
+	if req.Filename == "" {
+		return nil, newError(ErrInvalidInput, "filename is required")
+	}
+	if strings.ToLower(filepath.Ext(req.Filename)) != ".mp4" {
+		return nil, newError(ErrInvalidInput, "only .mp4 files can be uploaded")
+	}
+	if req.Size <= 0 {
+		return nil, newError(ErrInvalidInput, "size must be positive")
+	}
+	if req.Size > s.cfg.MaxUploadBytes() {
+		return nil, newError(ErrInvalidInput, "file exceeds the %d MB upload limit", s.cfg.Upload.MaxFileSizeMB)
+	}
+
+	s.cleanStaleUploads()
+
+	dir := s.uploadsDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating uploads directory: %w", err)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	sess := &models.UploadSession{
+		ID:        uuid.New().String(),
+		CameraID:  cameraID,
+		Filename:  req.Filename,
+		Size:      req.Size,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	f, err := os.Create(filepath.Join(dir, sess.ID+".part"))
+	if err != nil {
+		return nil, fmt.Errorf("creating upload file: %w", err)
+	}
+	f.Close()
+	if err := s.saveUploadSession(sess); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// GetUploadSession returns a session with its current offset, which is what
+// a client needs to resume after a dropped connection.
+func (s *CameraService) GetUploadSession(cameraID, uploadID string) (*models.UploadSession, error) {
+	if !validUploadID.MatchString(uploadID) {
+		return nil, newError(ErrUploadNotFound, "upload not found: %s", uploadID)
+	}
+	data, err := os.ReadFile(filepath.Join(s.uploadsDir(), uploadID+".json"))
+	if os.IsNotExist(err) {
+		return nil, newError(ErrUploadNotFound, "upload not found: %s", uploadID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading upload session: %w", err)
+	}
+	var sess models.UploadSession
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, fmt.Errorf("parsing upload session: %w", err)
+	}
+	if sess.CameraID != cameraID {
+		return nil, newError(ErrUploadNotFound, "upload not found: %s", uploadID)
+	}
+
+	// The .part file is the source of truth: a chunk may have been partly
+	// written when the connection dropped.
+	if fi, err := os.Stat(filepath.Join(s.uploadsDir(), uploadID+".part")); err == nil {
+		sess.Offset = fi.Size()
+	}
+	return &sess, nil
+}
+
+// AppendUploadChunk writes a chunk at offset, which must equal the number of
+// bytes already received. It returns the new offset. A partially received
+// chunk is kept, so the client resumes from the returned/queried offset.
+func (s *CameraService) AppendUploadChunk(cameraID, uploadID string, offset int64, chunk io.Reader) (int64, error) {
+	l := lockUpload(uploadID)
+	sess, err := s.GetUploadSession(cameraID, uploadID)
+	if err != nil {
+		l.mu.Unlock()
+		return 0, err
+	}
+	if l.receiving {
+		l.mu.Unlock()
+		return sess.Offset, newError(ErrUploadOffset, "a chunk of upload %s is already being received", uploadID)
+	}
+	if offset != sess.Offset {
+		l.mu.Unlock()
+		return sess.Offset, newError(ErrUploadOffset, "offset %d does not match received bytes %d", offset, sess.Offset)
+	}
+	f, err := os.OpenFile(filepath.Join(s.uploadsDir(), uploadID+".part"), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		l.mu.Unlock()
+		return offset, fmt.Errorf("opening upload file: %w", err)
+	}
+	l.receiving = true
+	l.mu.Unlock()
+
+	n, copyErr := io.Copy(f, io.LimitReader(chunk, sess.Size-sess.Offset+1))
+	f.Close()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.receiving = false
+	if l.gone {
+		return 0, newError(ErrUploadNotFound, "upload %s was aborted", uploadID)
+	}
+
+	sess.Offset += n
+	if sess.Offset > sess.Size {
+		// Drop the excess byte we read to detect overflow
+		os.Truncate(filepath.Join(s.uploadsDir(), uploadID+".part"), sess.Size)
+		return sess.Size, newError(ErrInvalidInput, "chunk exceeds declared upload size %d", sess.Size)
+	}
+	sess.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := s.saveUploadSession(sess); err != nil {
+		return sess.Offset, err
+	}
+	if copyErr != nil {
+		return sess.Offset, fmt.Errorf("receiving chunk: %w", copyErr)
+	}
+	return sess.Offset, nil
+}
+
+// FinalizeUpload stages a completely received upload and returns its path,
+// to be validated and placed with PlaceUpload.
+func (s *CameraService) FinalizeUpload(cameraID, uploadID string) (string, error) {
+	l := lockUpload(uploadID)
+	defer l.mu.Unlock()
+
+	sess, err := s.GetUploadSession(cameraID, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if l.receiving || sess.Offset != sess.Size {
+		return "", newError(ErrUploadOffset, "upload %s incomplete: %d of %d bytes received", uploadID, sess.Offset, sess.Size)
+	}
+
+	staged := s.stagedUploadPath()
+	if err := os.Rename(filepath.Join(s.uploadsDir(), uploadID+".part"), staged); err != nil {
+		return "", fmt.Errorf("staging upload: %w", err)
+	}
+	os.Remove(filepath.Join(s.uploadsDir(), uploadID+".json"))
+	forgetUploadLock(uploadID)
+	return staged, nil
+}
+
+// stagedUploadPath returns a new path in the uploads directory for a
+// received file to wait in while it is validated, so a rejected one never
+// shows up among the camera's videos. The .tmp suffix leaves one a crash
+// strands to gc.
+func (s *CameraService) stagedUploadPath() string {
+	return filepath.Join(s.uploadsDir(), uuid.New().String()+".mp4.tmp")
+}
+
+// PlaceUpload moves a staged, validated upload into today's directory of
+// the camera as filename (sanitized, and suffixed on collision) and returns
+// its path. A duplicate of a video already stored there is deleted, or with
+// DuplicatesFlag placed with DuplicateSuffix, as duplicates says; the video
+// it duplicates is returned as dup and the caller doesn't process it.
+func (s *CameraService) PlaceUpload(id, staged, filename, duplicates string) (path, dup string, err error) {
+	if path, err = s.newVideoPath(id, filename); err != nil {
+		return "", "", err
+	}
+	if duplicates != DuplicatesOff {
+		dup, err = FindDuplicateVideo(staged, filepath.Dir(path), filepath.Base(path))
+		if err != nil {
+			log.Printf("Checking %s for duplicates: %v", filename, err)
+		}
+	}
+	switch {
+	case dup != "" && duplicates == DuplicatesFlag:
+		path += DuplicateSuffix
+	case dup != "":
+		os.Remove(staged)
+		return "", dup, nil
+	}
+	// Link rather than rename, so a name taken meanwhile isn't overwritten
+	for {
+		err = os.Link(staged, path)
+		if err == nil {
+			os.Remove(staged)
+			return path, dup, nil
+		}
+		if !os.IsExist(err) {
+			break
+		}
+		if path, err = s.newVideoPath(id, filename); err != nil {
+			return "", "", err
+		}
+		if dup != "" {
+			path += DuplicateSuffix
+		}
+	}
+	// Filesystems without hard links
+	if err = os.Rename(staged, path); err != nil {
+		return "", "", fmt.Errorf("moving upload into place: %w", err)
+	}
+	return path, dup, nil
+}
+
+// AbortUpload discards an upload session and its partial data.
+func (s *CameraService) AbortUpload(cameraID, uploadID string) error {
+	l := lockUpload(uploadID)
+	defer l.mu.Unlock()
+
+	if _, err := s.GetUploadSession(cameraID, uploadID); err != nil {
+		return err
+	}
+	l.gone = true
+	s.removeUpload(uploadID)
+	return nil
+}
+
+// forgetUploadLock drops the lock of an upload that is finalized or gone.
+func forgetUploadLock(uploadID string) {
+	uploadLocksMu.Lock()
+	delete(uploadLocks, uploadID)
+	uploadLocksMu.Unlock()
+}
+
+func (s *CameraService) saveUploadSession(sess *models.UploadSession) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return fmt.Errorf("marshaling upload session: %w", err)
+	}
+	path := filepath.Join(s.uploadsDir(), sess.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("writing upload session: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *CameraService) removeUpload(uploadID string) {
+	os.Remove(filepath.Join(s.uploadsDir(), uploadID+".part"))
+	os.Remove(filepath.Join(s.uploadsDir(), uploadID+".json"))
+	forgetUploadLock(uploadID)
+}
+
+// cleanStaleUploads discards sessions that haven't received data within
+// uploadSessionTTL.
+func (s *CameraService) cleanStaleUploads() {
+	entries, err := os.ReadDir(s.uploadsDir())
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < uploadSessionTTL {
+			continue
+		}
+		l := lockUpload(id)
+		if !l.receiving {
+			log.Printf("Discarding stale upload %s", id)
+			l.gone = true
+			s.removeUpload(id)
+		}
+		l.mu.Unlock()
+	}
+}
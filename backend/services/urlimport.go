@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// importProgressInterval is how often ImportURL reports download progress.
+const importProgressInterval = time.Second
+
+const (
+	// importTimeout bounds a whole import download, body included, which
+	// is long enough for an upload-size video over a slow link.
+	importTimeout = 2 * time.Hour
+	// importMaxRedirects is how many redirects an import follows.
+	importMaxRedirects = 5
+)
+
+// importClient fetches import URLs, on behalf of whoever can reach the
+// API, so it only connects to public addresses: loopback, private,
+// link-local (cloud metadata at 169.254.169.254) and other special ones,
+// such as the ML sidecar's, are refused as it dials, after DNS, and each
+// redirect is checked again. Proxies from the environment aren't used, as
+// they would be dialed instead of the target.
+var importClient = &http.Client{
+	Timeout: importTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				return checkImportAddr(address)
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= importMaxRedirects {
+			return fmt.Errorf("stopped after %d redirects", importMaxRedirects)
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return newError(ErrInvalidInput, "redirected to a %s URL", req.URL.Scheme)
+		}
+		// Literal addresses are refused here; names once dialed
+		if addr, err := netip.ParseAddr(req.URL.Hostname()); err == nil && !publicAddr(addr) {
+			return newError(ErrInvalidInput, "redirected to non-public address %s", addr)
+		}
+		return nil
+	},
+}
+
+// checkImportAddr refuses to dial address, host:port, unless it is public.
+func checkImportAddr(address string) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return newError(ErrInvalidInput, "can't check address %s: %v", address, err)
+	}
+	if !publicAddr(ap.Addr()) {
+		return newError(ErrInvalidInput, "importing from non-public address %s is not allowed", ap.Addr())
+	}
+	return nil
+}
+
+// sharedAddrSpace is carrier-grade NAT space (RFC 6598), private in effect.
+var sharedAddrSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether addr is a globally routable unicast address.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddrSpace.Contains(addr)
+}
+
+// ImportSource checks a video URL to import, http or https (e.g. an S3
+// presigned URL), and returns the file name the video is saved under:
+// filename if set, else the last element of the URL path. Either must be
+// an .mp4, as for uploads.
+func ImportSource(rawURL, filename string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", newError(ErrInvalidInput, "url must be an http or https URL")
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !publicAddr(addr) {
+		return "", newError(ErrInvalidInput, "importing from non-public address %s is not allowed", addr)
+	}
+	if filename == "" {
+		filename = path.Base(u.Path)
+	}
+	if !strings.EqualFold(path.Ext(filename), ".mp4") {
+		return "", newError(ErrInvalidInput, "%q is not an .mp4 file; set filename", filename)
+	}
+	return filename, nil
+}
+
+// RedactURL drops the query and credentials of a URL, such as the
+// signature of a presigned one, for logs and progress messages.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// ImportURL downloads the video at rawURL into the uploads directory, as
+// Upload would save it, and returns its path, to be validated and placed
+// with PlaceUpload; one over the upload size limit is refused. Only public
+// addresses are fetched from (see importClient). progress is called with
+// the bytes received and the total, 0 when the server doesn't say, about
+// once a second.
+func (s *CameraService) ImportURL(ctx context.Context, id, rawURL, filename string, progress func(done, total int64)) (string, error) {
+	if _, err := s.Get(id); err != nil {
+		return "", err
+	}
+	filename, err := ImportSource(rawURL, filename)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", newError(ErrInvalidInput, "url must be an http or https URL")
+	}
+	resp, err := importClient.Do(req)
+	if err != nil {
+		// A *url.Error repeats the URL, signature and all
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return "", fmt.Errorf("downloading %s: %w", RedactURL(rawURL), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading %s: %s", RedactURL(rawURL), resp.Status)
+	}
+	limit := s.cfg.MaxUploadBytes()
+	if resp.ContentLength > limit {
+		return "", newError(ErrInvalidInput, "%s exceeds the %d MB upload limit", filename, s.cfg.Upload.MaxFileSizeMB)
+	}
+
+	if err := os.MkdirAll(s.uploadsDir(), 0o755); err != nil {
+		return "", fmt.Errorf("creating uploads directory: %w", err)
+	}
+	tmp := s.stagedUploadPath()
+	out, err := os.Create(tmp)
+	if err != nil {
+		return "", fmt.Errorf("creating file: %w", err)
+	}
+	pw := &progressWriter{total: resp.ContentLength, report: progress}
+	n, err := io.Copy(io.MultiWriter(out, pw), io.LimitReader(resp.Body, limit+1))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	switch {
+	case err != nil:
+		err = fmt.Errorf("downloading %s: %w", RedactURL(rawURL), err)
+	case n > limit:
+		err = newError(ErrInvalidInput, "%s exceeds the %d MB upload limit", filename, s.cfg.Upload.MaxFileSizeMB)
+	case resp.ContentLength > 0 && n != resp.ContentLength:
+		err = fmt.Errorf("downloading %s: got %d of %d bytes", RedactURL(rawURL), n, resp.ContentLength)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	progress(n, max(resp.ContentLength, n))
+	return tmp, nil
+}
+
+// progressWriter counts the bytes written through it and reports them at
+// most every importProgressInterval.
+type progressWriter struct {
+	done, total int64
+	last        time.Time
+	report      func(done, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if now := time.Now(); now.Sub(p.last) >= importProgressInterval {
+		p.last = now
+		p.report(p.done, max(p.total, 0))
+	}
+	return len(b), nil
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How extraction.timestamp_source dates a video whose name holds no start
+// time (HHMM or HH), e.g. an uploaded phone clip.
+const (
+	TimestampMetadata = "metadata" // the container's creation_time, else the file's mtime
+	TimestampMtime    = "mtime"    // the file's mtime, taken as the end of the recording
+)
+
+// videoTimesFile sits in a camera's video directory for a date and records,
+// per video file name, the start and clock offset its frames were stamped
+// with.
+const videoTimesFile = "video_times.json"
+
+// earliestVideoTime rejects creation_time values of cameras that never set
+// their clock, which write 1970 or 1904 (the MP4 epoch).
+var earliestVideoTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// VideoClock is what frame timestamps of a video are derived from besides
+// its name.
+type VideoClock struct {
+	OffsetSec int            // the camera's clock_offset_sec
+	Source    string         // extraction.timestamp_source, TimestampMetadata if empty
+	Location  *time.Location // general.timezone, the zone of names and dates; time.Local if nil
+}
+
+// TimestampSource returns the extraction.timestamp_source setting,
+// TimestampMetadata for a value it doesn't know.
+func TimestampSource(settings *SettingsService) string {
+	if strings.TrimSpace(strings.ToLower(settings.Get("extraction.timestamp_source"))) == TimestampMtime {
+		return TimestampMtime
+	}
+	return TimestampMetadata
+}
+
+// videoTime is a video's entry in videoTimesFile. Start is RFC 3339 time
+// on the camera's clock, before OffsetSec is added.
+type videoTime struct {
+	Start     string `json:"start"`
+	OffsetSec int    `json:"clock_offset_sec,omitempty"`
+}
+
+// videoTimesCache remembers parsed videoTimesFile contents by path and
+// mtime, so mapping a page of search results doesn't re-read it per result.
+var videoTimesCache = struct {
+	sync.Mutex
+	m map[string]cachedVideoTimes
+}{m: make(map[string]cachedVideoTimes)}
+
+type cachedVideoTimes struct {
+	modTime time.Time
+	times   map[string]videoTime
+}
+
+// videoStart returns when videoPath, of the day date (midnight in the
+// camera zone), began on the camera's clock. A start in the name wins;
+// otherwise it comes from clock.Source, and failing that the video is
+// taken to start at midnight.
+func videoStart(videoPath string, date time.Time, clock VideoClock) time.Time {
+	stem := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+	if sec, ok := recordingStartSec(stem); ok {
+		// On the wall clock, so an hour lost or repeated to DST earlier
+		// that day doesn't shift it
+		return time.Date(date.Year(), date.Month(), date.Day(), int(sec)/3600, int(sec)%3600/60, 0, 0, date.Location())
+	}
+	var start time.Time
+	if clock.Source != TimestampMtime {
+		start = creationTime(videoPath)
+	}
+	if start.IsZero() {
+		if info, err := os.Stat(videoPath); err == nil {
+			start = info.ModTime()
+			if dur, err := videoDuration(videoPath, info); err == nil {
+				start = start.Add(-time.Duration(dur * float64(time.Second)))
+			}
+		}
+	}
+	if start.Before(earliestVideoTime) {
+		return date
+	}
+	return start.In(date.Location()).Truncate(time.Second)
+}
+
+// creationTime reads the container's creation_time tag, the zero time when
+// it has none.
+func creationTime(videoPath string) time.Time {
+	out, err := exec.Command(
+		"ffprobe", "-v", "error",
+		"-show_entries", "format_tags=creation_time",
+		"-of", "csv=p=0",
+		videoPath,
+	).Output()
+	if err != nil {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(out)))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// loadVideoTimes reads the videoTimesFile in dir. A missing or broken file
+// records nothing.
+func loadVideoTimes(dir string) map[string]videoTime {
+	path := filepath.Join(dir, videoTimesFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	videoTimesCache.Lock()
+	c, ok := videoTimesCache.m[path]
+	videoTimesCache.Unlock()
+	if ok && c.modTime.Equal(info.ModTime()) {
+		return c.times
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var times map[string]videoTime
+	if err := json.Unmarshal(data, &times); err != nil {
+		return nil
+	}
+	videoTimesCache.Lock()
+	videoTimesCache.m[path] = cachedVideoTimes{modTime: info.ModTime(), times: times}
+	videoTimesCache.Unlock()
+	return times
+}
+
+// recordVideoTime notes the start and clock offset videoPath's frames were
+// stamped with, so seek offsets into it stay right after the camera's
+// offset is changed, e.g. back to 0 once its clock is fixed.
+func recordVideoTime(videoPath string, start time.Time, offsetSec int) error {
+	dir, name := filepath.Split(videoPath)
+	times := loadVideoTimes(dir)
+	vt := videoTime{Start: start.Format(time.RFC3339), OffsetSec: offsetSec}
+	if prev, ok := times[name]; ok && prev == vt {
+		return nil
+	}
+	updated := make(map[string]videoTime, len(times)+1)
+	for k, v := range times {
+		updated[k] = v
+	}
+	updated[name] = vt
+	data, err := json.MarshalIndent(updated, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, videoTimesFile), data, 0o644)
+}
+
+// recordedVideoTime returns the start and clock offset videoPath's frames
+// were stamped with; ok is false for videos processed before they were
+// recorded.
+func recordedVideoTime(videoPath string) (start time.Time, offsetSec int, ok bool) {
+	dir, name := filepath.Split(filepath.FromSlash(videoPath))
+	vt, ok := loadVideoTimes(dir)[name]
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	start, err := time.Parse(time.RFC3339, vt.Start)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return start, vt.OffsetSec, true
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// FolderWatcher reports files appearing anywhere under a directory tree once
+// they have stopped changing, so a recorder or copy still writing a file is
+// not picked up half-way. Subdirectories created later are watched too.
+type FolderWatcher struct {
+	root   string
+	settle time.Duration
+	match  func(path string) bool
+
+	watcher *fsnotify.Watcher
+	pending map[string]pendingFile
+}
+
+type pendingFile struct {
+	lastChange time.Time
+	size       int64
+}
+
+// NewFolderWatcher watches root (created if missing). Only files for which
+// match returns true are reported; hidden files and in-progress downloads
+// (.tmp, .part) are always ignored. A file is ready once neither an event
+// nor a size change has been seen for settle.
+func NewFolderWatcher(root string, settle time.Duration, match func(path string) bool) (*FolderWatcher, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", root, err)
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("creating watcher: %w", err)
+	}
+	return &FolderWatcher{
+		root:    root,
+		settle:  settle,
+		match:   match,
+		watcher: w,
+		pending: make(map[string]pendingFile),
+	}, nil
+}
+
+// Run watches until ctx is done and calls onReady for each settled file,
+// including files already present when Run starts. onReady runs on the
+// watcher goroutine, so it should hand long work off rather than block.
+func (w *FolderWatcher) Run(ctx context.Context, onReady func(path string)) error {
+	defer w.watcher.Close()
+
+	if err := w.addTree(w.root); err != nil {
+		return err
+	}
+
+	tick := w.settle / 4
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(ev)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			// Usually a queue overflow; a rescan catches anything missed
+			log.Printf("watcher: %v, rescanning %s", err, w.root)
+			w.addTree(w.root)
+		case now := <-ticker.C:
+			for path, p := range w.pending {
+				if now.Sub(p.lastChange) < w.settle {
+					continue
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					delete(w.pending, path)
+					continue
+				}
+				if info.Size() != p.size {
+					w.pending[path] = pendingFile{lastChange: now, size: info.Size()}
+					continue
+				}
+				delete(w.pending, path)
+				onReady(path)
+			}
+		}
+	}
+}
+
+func (w *FolderWatcher) handleEvent(ev fsnotify.Event) {
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		delete(w.pending, ev.Name)
+		return
+	}
+	info, err := os.Stat(ev.Name)
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		if ev.Has(fsnotify.Create) {
+			// Files may land in a new directory before it is watched
+			w.addTree(ev.Name)
+		}
+		return
+	}
+	w.track(ev.Name, info)
+}
+
+// addTree watches dir and every directory below it and queues the files
+// already there.
+func (w *FolderWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Vanished or unreadable; not fatal for the rest of the tree
+			return nil
+		}
+		if d.IsDir() {
+			if path != w.root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if err := w.watcher.Add(path); err != nil {
+				return fmt.Errorf("watching %s: %w", path, err)
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			w.track(path, info)
+		}
+		return nil
+	})
+}
+
+func (w *FolderWatcher) track(path string, info os.FileInfo) {
+	name := info.Name()
+	if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") ||
+		strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".part") {
+		return
+	}
+	if w.match != nil && !w.match(path) {
+		return
+	}
+	w.pending[path] = pendingFile{lastChange: time.Now(), size: info.Size()}
+}
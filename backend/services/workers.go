@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/intelsk/backend/models"
+)
+
+// ImageEncoder turns frame images into CLIP embeddings, one per path. The
+// MLClient does it with the local sidecar, a WorkerPool on remote workers.
+type ImageEncoder interface {
+	EncodeImagesContext(ctx context.Context, paths []string) ([][]float64, error)
+}
+
+// ParallelEncoder is an ImageEncoder that serves several batches at once,
+// like an MLClient with several sidecar instances. The pipeline keeps that
+// many batches in flight.
+type ParallelEncoder interface {
+	ImageEncoder
+	Parallelism() int
+}
+
+const (
+	// workerLease is how long a worker may hold a batch before it is handed
+	// to another one, e.g. because the worker went away.
+	workerLease = 5 * time.Minute
+	// workerMaxAttempts is how often a batch is handed out before indexing
+	// gives up on it.
+	workerMaxAttempts = 3
+	// workerClaimTimeout is how long a batch may wait in the queue for a
+	// worker to claim it before indexing gives up, e.g. because no worker
+	// is online.
+	workerClaimTimeout = 2 * time.Minute
+	// workerOnlineTTL is how recently a worker must have polled to count as
+	// online.
+	workerOnlineTTL = time.Minute
+)
+
+// WorkerPool is the queue of indexing work for remote workers ('backend
+// worker'), so CLIP encoding can run on a GPU box while the API, the
+// database and the frames stay on the NAS. Jobs hand it frame batches in
+// place of the ML sidecar; workers claim a batch over the API, download its
+// frames, encode them with their own sidecar and post the embeddings back.
+// Everything else (extraction, storing embeddings, index state) stays on
+// this node, so cancelling and resuming jobs work as before. The queue is
+// shared by every workspace and held in memory: a batch in flight when the
+// server stops is redone by the resumed job.
+type WorkerPool struct {
+	mu      sync.Mutex
+	enabled bool
+	token   string
+	pending []*workerTask
+	claimed map[string]*workerTask
+	workers map[string]*models.WorkerInfo
+	dim     int           // embedding length of the batches accepted so far
+	notify  chan struct{} // closed and replaced when a batch is queued
+}
+
+type workerTask struct {
+	id         string
+	paths      []string
+	worker     string
+	claimBy    time.Time // while queued
+	leaseUntil time.Time // while claimed
+	attempts   int
+	done       chan workerResult // buffered; receives exactly one result
+}
+
+type workerResult struct {
+	embeddings [][]float64
+	err        error
+}
+
+func NewWorkerPool() *WorkerPool {
+	return &WorkerPool{
+		claimed: make(map[string]*workerTask),
+		workers: make(map[string]*models.WorkerInfo),
+		notify:  make(chan struct{}),
+	}
+}
+
+// Configure turns worker mode on or off and sets the token workers
+// authenticate with; without one, worker mode stays off. Batches already
+// queued stay queued. The embedding length workers must return is learnt
+// again from the next batch, as the workers may have changed.
+func (p *WorkerPool) Configure(enabled bool, token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.enabled = enabled
+	p.token = token
+	p.dim = 0
+}
+
+// Enabled reports whether jobs should index on remote workers.
+func (p *WorkerPool) Enabled() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.enabled && p.token != ""
+}
+
+// Token returns the token workers authenticate with; empty disables the
+// worker API.
+func (p *WorkerPool) Token() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.token
+}
+
+// EncodeImagesContext queues paths as one batch and waits for a worker to
+// encode it, or until ctx is done. A batch that fails on workerMaxAttempts
+// workers fails with ErrSidecarFailed, one that no worker claims within
+// workerClaimTimeout with ErrSidecarUnavailable.
+func (p *WorkerPool) EncodeImagesContext(ctx context.Context, paths []string) ([][]float64, error) {
+	task := &workerTask{
+		id:    uuid.New().String(),
+		paths: paths,
+		done:  make(chan workerResult, 1),
+	}
+	p.mu.Lock()
+	task.claimBy = time.Now().Add(workerClaimTimeout)
+	p.pending = append(p.pending, task)
+	p.broadcast()
+	wait := workerClaimTimeout
+	p.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case res := <-task.done:
+			return res.embeddings, res.err
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.drop(task)
+			p.mu.Unlock()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		// Leases otherwise only run out when a worker polls, which none
+		// may do any more
+		p.mu.Lock()
+		now := time.Now()
+		p.expire(now)
+		if slices.Contains(p.pending, task) && !now.Before(task.claimBy) {
+			p.drop(task)
+			p.mu.Unlock()
+			return nil, newError(ErrSidecarUnavailable, "no worker claimed the batch within %s", workerClaimTimeout)
+		}
+		wait = time.Until(task.claimBy)
+		if task.worker != "" {
+			wait = time.Until(task.leaseUntil)
+		}
+		p.mu.Unlock()
+		timer.Reset(max(wait, time.Second))
+	}
+}
+
+// drop removes a batch from the queue, claimed or not.
+func (p *WorkerPool) drop(task *workerTask) {
+	p.pending = slices.DeleteFunc(p.pending, func(t *workerTask) bool { return t == task })
+	if p.claimed[task.id] == task {
+		delete(p.claimed, task.id)
+	}
+}
+
+// broadcast wakes the workers waiting in Claim.
+func (p *WorkerPool) broadcast() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+// seen records a request from worker.
+func (p *WorkerPool) seen(worker string) *models.WorkerInfo {
+	w, ok := p.workers[worker]
+	if !ok {
+		w = &models.WorkerInfo{Name: worker}
+		p.workers[worker] = w
+	}
+	w.LastSeen = time.Now().UTC()
+	return w
+}
+
+// expire takes back the batches whose lease ran out: they are queued again,
+// or fail once they have used up their attempts.
+func (p *WorkerPool) expire(now time.Time) {
+	for id, t := range p.claimed {
+		if now.Before(t.leaseUntil) {
+			continue
+		}
+		delete(p.claimed, id)
+		p.retry(t, newError(ErrSidecarTimeout, "worker %s did not finish batch %s within %s", t.worker, t.id, workerLease))
+	}
+}
+
+// retry queues a batch a worker gave back, at the front, or fails it with
+// err once it has used up its attempts.
+func (p *WorkerPool) retry(t *workerTask, err error) {
+	if t.attempts >= workerMaxAttempts {
+		t.done <- workerResult{err: err}
+		return
+	}
+	t.worker = ""
+	t.claimBy = time.Now().Add(workerClaimTimeout)
+	p.pending = slices.Insert(p.pending, 0, t)
+	p.broadcast()
+}
+
+// Claim hands worker the oldest queued batch, waiting for one until ctx is
+// done. ok is false when there was none.
+func (p *WorkerPool) Claim(ctx context.Context, worker string) (task models.WorkerTask, ok bool) {
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		p.seen(worker)
+		p.expire(now)
+		if len(p.pending) > 0 {
+			t := p.pending[0]
+			p.pending = p.pending[1:]
+			t.worker = worker
+			t.leaseUntil = now.Add(workerLease)
+			t.attempts++
+			p.claimed[t.id] = t
+			p.mu.Unlock()
+			return models.WorkerTask{TaskID: t.id, Frames: len(t.paths), LeaseSec: int(workerLease.Seconds())}, true
+		}
+		wake := p.notify
+		p.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return task, false
+		}
+	}
+}
+
+// claimedBy returns a batch claimed by worker. It is ErrTaskNotFound once
+// the batch was cancelled, finished or handed to another worker.
+func (p *WorkerPool) claimedBy(taskID, worker string) (*workerTask, error) {
+	t, ok := p.claimed[taskID]
+	if !ok || t.worker != worker {
+		return nil, newError(ErrTaskNotFound, "task %s is not claimed by worker %s", taskID, worker)
+	}
+	return t, nil
+}
+
+// FramePath returns the path of frame i of a batch worker claimed.
+func (p *WorkerPool) FramePath(taskID, worker string, i int) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seen(worker)
+	t, err := p.claimedBy(taskID, worker)
+	if err != nil {
+		return "", err
+	}
+	if i < 0 || i >= len(t.paths) {
+		return "", newError(ErrInvalidInput, "task %s has no frame %d", taskID, i)
+	}
+	return t.paths[i], nil
+}
+
+// Complete records a worker's result for a batch: its embeddings, in frame
+// order, or the error it failed with, in which case the batch is retried.
+// Embeddings must all have the length of those accepted before, so a
+// worker running another model can't mix its vectors into the index.
+func (p *WorkerPool) Complete(taskID, worker string, result models.WorkerResult) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w := p.seen(worker)
+	t, err := p.claimedBy(taskID, worker)
+	if err != nil {
+		return err
+	}
+	delete(p.claimed, taskID)
+
+	if result.Error == "" && len(result.Embeddings) != len(t.paths) {
+		result.Error = fmt.Sprintf("returned %d embeddings for %d frames", len(result.Embeddings), len(t.paths))
+	}
+	if result.Error == "" {
+		result.Error = p.checkDim(result.Embeddings)
+	}
+	if result.Error != "" {
+		w.Failed++
+		p.retry(t, newError(ErrSidecarFailed, "worker %s: %s", worker, result.Error))
+		return nil
+	}
+	w.Batches++
+	w.Frames += len(t.paths)
+	if p.dim == 0 && len(result.Embeddings) > 0 {
+		p.dim = len(result.Embeddings[0])
+	}
+	t.done <- workerResult{embeddings: result.Embeddings}
+	return nil
+}
+
+// checkDim describes what is wrong with the lengths of embeddings, or
+// returns "" when they are all that of the batches accepted so far.
+func (p *WorkerPool) checkDim(embeddings [][]float64) string {
+	want := p.dim
+	for i, emb := range embeddings {
+		if want == 0 {
+			want = len(emb)
+		}
+		if len(emb) == 0 || len(emb) != want {
+			return fmt.Sprintf("embedding %d has %d dimensions, want %d", i, len(emb), want)
+		}
+	}
+	return ""
+}
+
+// Status returns the workers seen so far, most recently seen first, and
+// how many batches are queued and in progress.
+func (p *WorkerPool) Status() models.WorkersStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	status := models.WorkersStatus{
+		Enabled: p.enabled && p.token != "",
+		Pending: len(p.pending),
+		Claimed: len(p.claimed),
+		Workers: []models.WorkerInfo{},
+	}
+	for _, w := range p.workers {
+		info := *w
+		info.Online = now.Sub(w.LastSeen) < workerOnlineTTL
+		for _, t := range p.claimed {
+			if t.worker == w.Name {
+				info.Claimed++
+			}
+		}
+		status.Workers = append(status.Workers, info)
+	}
+	sort.Slice(status.Workers, func(i, j int) bool {
+		return status.Workers[i].LastSeen.After(status.Workers[j].LastSeen)
+	})
+	return status
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/models"
+)
+
+var validWorkspaceID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// WorkspaceService manages the workspace registry. The registry lives in the
+// default workspace's database; each workspace keeps its own database and
+// data directory under data_dir/workspaces/{id}.
+type WorkspaceService struct {
+	db  *sql.DB
+	cfg *config.AppConfig
+}
+
+func NewWorkspaceService(db *sql.DB, cfg *config.AppConfig) *WorkspaceService {
+	return &WorkspaceService{db: db, cfg: cfg}
+}
+
+// List returns the default workspace followed by all registered ones.
+func (s *WorkspaceService) List() ([]models.Workspace, error) {
+	workspaces := []models.Workspace{s.defaultWorkspace()}
+
+	rows, err := s.db.Query("SELECT id, name, created_at FROM workspaces ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("querying workspaces: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ws models.Workspace
+		if err := rows.Scan(&ws.ID, &ws.Name, &ws.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning workspace row: %w", err)
+		}
+		ws.DataDir = s.cfg.WorkspaceDir(ws.ID)
+		workspaces = append(workspaces, ws)
+	}
+	return workspaces, rows.Err()
+}
+
+// Get returns a single workspace by ID.
+func (s *WorkspaceService) Get(id string) (*models.Workspace, error) {
+	if id == config.DefaultWorkspace {
+		ws := s.defaultWorkspace()
+		return &ws, nil
+	}
+
+	var ws models.Workspace
+	err := s.db.QueryRow("SELECT id, name, created_at FROM workspaces WHERE id = ?", id).
+		Scan(&ws.ID, &ws.Name, &ws.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, newError(ErrWorkspaceNotFound, "workspace not found: %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying workspace: %w", err)
+	}
+	ws.DataDir = s.cfg.WorkspaceDir(ws.ID)
+	return &ws, nil
+}
+
+// Create registers a new workspace and creates its data directory.
+func (s *WorkspaceService) Create(req models.CreateWorkspaceRequest) (*models.Workspace, error) {
+	if !validWorkspaceID.MatchString(req.ID) {
+		return nil, newError(ErrInvalidInput, "invalid workspace ID: must match [a-z0-9][a-z0-9_-]{0,31}")
+	}
+	if req.Name == "" {
+		return nil, newError(ErrInvalidInput, "name is required")
+	}
+	if _, err := s.Get(req.ID); err == nil {
+		return nil, newError(ErrAlreadyExists, "workspace already exists: %s", req.ID)
+	}
+
+	if err := os.MkdirAll(s.cfg.WorkspaceDir(req.ID), 0o755); err != nil {
+		return nil, fmt.Errorf("creating workspace directory: %w", err)
+	}
+	if _, err := s.db.Exec("INSERT INTO workspaces (id, name) VALUES (?, ?)", req.ID, req.Name); err != nil {
+		return nil, fmt.Errorf("inserting workspace: %w", err)
+	}
+	return s.Get(req.ID)
+}
+
+// Delete unregisters a workspace and, if deleteData is set, removes its data
+// directory (videos, frames, database). The default workspace cannot be deleted.
+func (s *WorkspaceService) Delete(id string, deleteData bool) error {
+	if id == config.DefaultWorkspace {
+		return newError(ErrInvalidInput, "the default workspace cannot be deleted")
+	}
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM workspaces WHERE id = ?", id); err != nil {
+		return fmt.Errorf("deleting workspace: %w", err)
+	}
+	if deleteData {
+		if err := os.RemoveAll(s.cfg.WorkspaceDir(id)); err != nil {
+			return fmt.Errorf("removing workspace data: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *WorkspaceService) defaultWorkspace() models.Workspace {
+	return models.Workspace{
+		ID:      config.DefaultWorkspace,
+		Name:    "Default",
+		DataDir: s.cfg.App.DataDir,
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/intelsk/backend/services"
+)
+
+func runStats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	camera := fs.String("camera", "", "Only show this camera (totals still cover all cameras)")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+	parseFlags(fs, args)
+
+	cfg := loadAppConfig()
+
+	// Read-only so it is safe to run next to a live server
+	storage, err := services.OpenStorageReadOnly(cfg.Storage.DBPath)
+	if err != nil {
+		log.Fatalf("opening database %s: %v", cfg.Storage.DBPath, err)
+	}
+	defer storage.Close()
+
+	stats, err := services.CollectStats(cfg, storage.DB())
+	if err != nil {
+		log.Fatalf("collecting stats: %v", err)
+	}
+
+	cameras := stats.Cameras
+	if *camera != "" {
+		cameras = nil
+		for _, cs := range stats.Cameras {
+			if cs.CameraID == *camera {
+				cameras = append(cameras, cs)
+			}
+		}
+		if len(cameras) == 0 {
+			log.Fatalf("camera not found: %s", *camera)
+		}
+	}
+
+	if jsonOutput {
+		stats.Cameras = cameras
+		printJSON(stats)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CAMERA\tVIDEOS\tSIZE\tFRAMES\tINDEXED\tCLIP\tFACES\tLAST DATE\tLAST INDEXED\t")
+	for _, cs := range cameras {
+		lastIndexed := "-"
+		if cs.LastIndexedAt != nil {
+			lastIndexed = cs.LastIndexedAt.Local().Format("2006-01-02 15:04")
+		}
+		lastDate := cs.LastProcessedDate
+		if lastDate == "" {
+			lastDate = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t\n", cs.CameraID, cs.VideoCount,
+			formatBytes(cs.VideoBytes), cs.FrameCount, cs.IndexedFrames, cs.ClipEmbeddings,
+			cs.FaceEmbeddings, lastDate, lastIndexed)
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%s\t%d\t%d\t%d\t%d\t\t\t\n", stats.VideoCount, formatBytes(stats.VideoBytes),
+		stats.FrameCount, stats.IndexedFrames, stats.ClipEmbeddings, stats.FaceEmbeddings)
+	tw.Flush()
+
+	fmt.Println()
+	if cfg.Workspace != "" {
+		fmt.Printf("Workspace: %s\n", cfg.Workspace)
+	}
+	fmt.Printf("Cameras:   %d\n", len(stats.Cameras))
+	fmt.Printf("Database:  %s (%s)\n", cfg.Storage.DBPath, formatBytes(stats.DBBytes))
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/intelsk/backend/services"
+)
+
+func runTimelapse(args []string) {
+	fs := flag.NewFlagSet("timelapse", flag.ExitOnError)
+	camera := fs.String("camera", "", "camera ID")
+	date := fs.String("date", "", "date in YYYY-MM-DD format")
+	fps := fs.Int("fps", services.DefaultTimelapseFPS, "output frames per second; each extracted frame is one video frame")
+	height := fs.Int("height", services.DefaultTimelapseHeight, "output height in pixels (never upscaled)")
+	output := fs.String("o", "", "output MP4 (default: {camera}_{date}_timelapse.mp4)")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	addJSONFlag(fs)
+	parseFlags(fs, args)
+
+	if *camera == "" || *date == "" {
+		fmt.Fprintln(os.Stderr, "error: -camera and -date are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	opts := services.TimelapseOptions{FPS: *fps, Height: *height}
+	if err := opts.Validate(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *output == "" {
+		*output = fmt.Sprintf("%s_%s_timelapse.mp4", *camera, *date)
+	}
+
+	cfg := loadAppConfig()
+	framesDir, err := services.ResolveFramesDir(cfg.Extraction.StoragePath, *camera, *date)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	frames, err := services.BuildTimelapse(ctx, framesDir, *output, opts)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	durationSec := float64(frames) / float64(opts.FPS)
+	if jsonOutput {
+		printJSON(map[string]any{
+			"camera_id":    *camera,
+			"date":         *date,
+			"path":         *output,
+			"frames":       frames,
+			"fps":          opts.FPS,
+			"height":       opts.Height,
+			"duration_sec": durationSec,
+		})
+		return
+	}
+	fmt.Printf("Wrote %s: %d frames, %.1fs at %d fps\n", *output, frames, durationSec, opts.FPS)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/intelsk/backend/config"
+	"github.com/intelsk/backend/services"
+)
+
+// watchQueue hands camera+date combinations from the watcher to the
+// processing goroutine, dropping ones that are already waiting.
+type watchQueue struct {
+	mu     sync.Mutex
+	queued map[processUnit]bool
+	ch     chan processUnit
+}
+
+func newWatchQueue() *watchQueue {
+	return &watchQueue{queued: make(map[processUnit]bool), ch: make(chan processUnit, 1024)}
+}
+
+func (q *watchQueue) push(u processUnit) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queued[u] {
+		return
+	}
+	q.queued[u] = true
+	q.ch <- u
+}
+
+func (q *watchQueue) done(u processUnit) {
+	q.mu.Lock()
+	delete(q.queued, u)
+	q.mu.Unlock()
+}
+
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	src := fs.String("src", "", "drop folder to import from (default: watch data/videos/{camera}/{date}/ directly)")
+	camera := fs.String("camera", "", "camera ID files from -src are imported into (required with -src)")
+	keep := fs.Bool("keep", false, "copy files out of -src instead of moving them")
+	settle := fs.Duration("settle", 10*time.Second, "how long a file must stop changing before it is processed")
+	dateFrom := fs.String("date-from", "auto", "with -src: where to read the recording time: filename, mtime or auto")
+	datePattern := fs.String("date-pattern", "", "with -src: regexp with named groups year, month, day[, hour, minute, second]")
+	addRootFlag(fs)
+	addWorkspaceFlag(fs)
+	parseFlags(fs, args)
+
+	if (*src == "") != (*camera == "") {
+		fmt.Fprintln(os.Stderr, "error: -src and -camera must be given together")
+		fs.Usage()
+		os.Exit(1)
+	}
+	var pattern *regexp.Regexp
+	if *datePattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(*datePattern); err != nil {
+			log.Fatalf("invalid -date-pattern: %v", err)
+		}
+	}
+
+	cfg := loadAppConfig()
+	videosRoot := filepath.Join(cfg.App.DataDir, "videos")
+
+	storage, err := services.NewStorage(cfg.Storage.DBPath)
+	if err != nil {
+		log.Fatalf("opening storage: %v", err)
+	}
+	defer storage.Close()
+	if *src != "" {
+		ensureImportCamera(cfg, *camera)
+	}
+
+	root := videosRoot
+	match := func(path string) bool { return strings.EqualFold(filepath.Ext(path), ".mp4") }
+	if *src != "" {
+		root = *src
+		match = func(path string) bool {
+			return slices.Contains(services.ImportableExtensions, strings.ToLower(filepath.Ext(path)))
+		}
+	}
+	watcher, err := services.NewFolderWatcher(root, *settle, match)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	queue := newWatchQueue()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		processWatchQueue(ctx, cfg, storage, queue)
+	}()
+
+	onReady := func(path string) {
+		if *src == "" {
+			if u, ok := unitFromVideoPath(videosRoot, path); ok {
+				queue.push(u)
+			}
+			return
+		}
+		if u, ok := importDropped(cfg, *camera, path, *dateFrom, pattern, !*keep); ok {
+			queue.push(u)
+		}
+	}
+
+	statusf("Watching %s (settle %s); press Ctrl+C to stop\n", root, *settle)
+	if err := watcher.Run(ctx, onReady); err != nil {
+		log.Fatalf("watch failed: %v", err)
+	}
+	statusf("Stopping; waiting for the current camera+date to finish...\n")
+	wg.Wait()
+}
+
+// processWatchQueue runs the incremental pipeline for each queued
+// camera+date until ctx is done. Settings are re-read every time so changes
+// made in the web UI apply without restarting the watcher.
+func processWatchQueue(ctx context.Context, cfg *config.AppConfig, storage *services.Storage, queue *watchQueue) {
+	mlClient := newMLClient(cfg)
+	encoder := newEncoder(cfg, mlClient, services.NewSettingsService(storage.DB(), cfg).Get("clip.model"))
+	events := make(chan services.ProgressEvent, 64)
+	go func() {
+		for ev := range events {
+			printProgress(ev)
+		}
+	}()
+	defer close(events)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u := <-queue.ch:
+			queue.done(u)
+			mlClient.Configure(services.MLConfigFromSettings(services.NewSettingsService(storage.DB(), cfg), cfg))
+			if err := encoder.WaitForReady(encoder.ReadyTimeout()); err != nil {
+				log.Printf("ML sidecar not ready, leaving %s/%s for the next run: %v", u.cameraID, u.date, err)
+				continue
+			}
+			window := func() services.ProcessWindow {
+				return services.ProcessWindowFromSettings(services.NewSettingsService(storage.DB(), cfg))
+			}
+			if w := window(); !w.Open(time.Now()) {
+				statusf("Waiting for the processing window (%s) to process %s/%s\n", w, u.cameraID, u.date)
+				if services.WaitForProcessWindow(ctx, window) != nil {
+					return
+				}
+			}
+			settings := services.NewSettingsService(storage.DB(), cfg)
+			pipeline := services.NewPipeline(encoder, storage, settings.GetInt("clip.batch_size"))
+			opts := services.ExtractionOptionsFromSettings(settings)
+			if err := services.ProcessCameraDate(cfg, pipeline, opts, u.cameraID, u.date, events); err != nil {
+				log.Printf("processing %s/%s: %v", u.cameraID, u.date, err)
+			}
+		}
+	}
+}
+
+// unitFromVideoPath maps data/videos/{camera}/{date}/{file}.mp4 to its
+// camera+date. Files anywhere else in the tree are ignored.
+func unitFromVideoPath(videosRoot, path string) (processUnit, bool) {
+	rel, err := filepath.Rel(videosRoot, path)
+	if err != nil {
+		return processUnit{}, false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 3 {
+		return processUnit{}, false
+	}
+	if _, err := time.Parse("2006-01-02", parts[1]); err != nil {
+		return processUnit{}, false
+	}
+	return processUnit{cameraID: parts[0], date: parts[1]}, true
+}
+
+// importDropped imports a file from the drop folder into cameraID the same
+// way 'backend import' does and returns the camera+date it landed on.
+func importDropped(cfg *config.AppConfig, cameraID, path, dateFrom string, pattern *regexp.Regexp, move bool) (processUnit, bool) {
+	rt, err := services.InferRecordingTime(path, dateFrom, pattern)
+	if err != nil {
+		log.Printf("skipping %s: %v", path, err)
+		return processUnit{}, false
+	}
+	date := rt.Start.Format("2006-01-02")
+	u := processUnit{cameraID: cameraID, date: date}
+	if alreadyImported(path, filepath.Join(cfg.App.DataDir, "videos", cameraID, date)) {
+		if move {
+			os.Remove(path)
+		}
+		return u, true
+	}
+	dest := services.ImportDestination(cfg.App.DataDir, cameraID, path, rt)
+	if err := services.ImportVideo(path, dest, move); err != nil {
+		log.Printf("importing %s: %v", path, err)
+		return processUnit{}, false
+	}
+	statusf("[import] %s -> %s\n", path, dest)
+	return u, true
+}
@@ -0,0 +1,52 @@
+// Package web embeds the built frontend so a single backend binary can serve
+// the UI. `make build` copies frontend/dist into web/dist before compiling;
+// without that step only a placeholder is embedded and the handler answers
+// with a hint instead of the app.
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//go:embed all:dist
+var distFS embed.FS
+
+// Handler serves static assets from the embedded build and falls back to
+// index.html for any other non-/api path, so client-side routes such as
+// /cameras/front_door survive a page reload.
+func Handler() http.Handler {
+	dist, _ := fs.Sub(distFS, "dist")
+	fileServer := http.FileServer(http.FS(dist))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name != "" && name != "index.html" {
+			if fi, err := fs.Stat(dist, name); err == nil && !fi.IsDir() {
+				// Vite fingerprints everything under assets/
+				if strings.HasPrefix(name, "assets/") {
+					w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+				}
+				fileServer.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		index, err := fs.ReadFile(dist, "index.html")
+		if err != nil {
+			http.Error(w, "frontend not built into this binary; run 'make build' or use the dev server", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(index)
+	})
+}
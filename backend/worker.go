@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/intelsk/backend/api"
+	"github.com/intelsk/backend/models"
+	"github.com/intelsk/backend/services"
+)
+
+const workerUsage = `Usage:
+  backend worker -server URL [flags]
+
+Indexes frames for a server with workers.enabled on, using the ML sidecar on
+this machine: claims frame batches, downloads the frames, encodes them and
+posts the embeddings back. Needs no config, data directory or database.`
+
+// indexWorker pulls indexing work from a server's worker API.
+type indexWorker struct {
+	base  string // e.g. http://nas:8000/api/v1
+	token string
+	name  string
+	http  *http.Client
+	ml    *services.MLClient
+}
+
+func runWorker(args []string) {
+	hostname, _ := os.Hostname()
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	serverURL := fs.String("server", "", "URL of the server to index for, e.g. http://nas:8000 (required)")
+	token := fs.String("token", os.Getenv("INTELSK_WORKER_TOKEN"), "the server's workers.token (default: $INTELSK_WORKER_TOKEN)")
+	mlURL := fs.String("ml", "http://localhost:8001", "ML sidecar on this machine")
+	name := fs.String("name", hostname, "name the server lists this worker under")
+	concurrency := fs.Int("concurrency", 1, "batches encoded at once")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, workerUsage)
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if *serverURL == "" || *token == "" || *name == "" || *concurrency < 1 {
+		fmt.Fprintln(os.Stderr, "error: -server, -token and -name are required and -concurrency must be at least 1")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	w := &indexWorker{
+		base:  strings.TrimSuffix(*serverURL, "/") + "/api/" + api.Version,
+		token: *token,
+		name:  *name,
+		http:  &http.Client{Timeout: 2 * time.Minute},
+		ml:    services.NewMLClient(*mlURL),
+	}
+	log.Printf("Waiting for ML sidecar at %s...", *mlURL)
+	if err := w.ml.WaitForReady(w.ml.ReadyTimeout()); err != nil {
+		log.Fatalf("ML sidecar: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("Worker %s indexing for %s (%d at a time)", w.name, *serverURL, *concurrency)
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+	log.Printf("Worker stopped")
+}
+
+// loop claims and encodes batches until ctx is done. While the server is
+// unreachable it retries every 10 seconds.
+func (w *indexWorker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		task, ok, err := w.claim(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("claiming work: %v", err)
+			select {
+			case <-time.After(10 * time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if ok {
+			w.run(ctx, task)
+		}
+	}
+}
+
+// do sends a request to the worker API. Error responses are returned with
+// the server's message; rate limited requests are retried after the time
+// the server asks for, if body can be rewound.
+func (w *indexWorker) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	for {
+		resp, err := w.send(ctx, method, path, body)
+		if err != nil {
+			return nil, err
+		}
+		seeker, rewindable := body.(io.Seeker)
+		if resp.StatusCode != http.StatusTooManyRequests || (body != nil && !rewindable) {
+			return w.checkResponse(resp)
+		}
+		wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		resp.Body.Close()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(max(wait, 1)) * time.Second):
+		}
+		if rewindable {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (w *indexWorker) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, w.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	q.Set("worker", w.name)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Authorization", "Bearer "+w.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return w.http.Do(req)
+}
+
+// checkResponse turns an error response into an error with the server's
+// message.
+func (w *indexWorker) checkResponse(resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var apiErr api.ErrorResponse
+		raw, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(raw, &apiErr) == nil && apiErr.Message != "" {
+			return nil, fmt.Errorf("%s", apiErr.Message)
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	return resp, nil
+}
+
+// claim asks for the next batch; ok is false when the server had none.
+func (w *indexWorker) claim(ctx context.Context) (task models.WorkerTask, ok bool, err error) {
+	resp, err := w.do(ctx, http.MethodPost, "/workers/claim", nil)
+	if err != nil {
+		return task, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return task, false, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return task, false, fmt.Errorf("decoding task: %w", err)
+	}
+	return task, true, nil
+}
+
+// run downloads a batch's frames, encodes them and posts the result. A
+// failure, including the worker being stopped, is posted too, so the server
+// hands the batch to another worker right away.
+func (w *indexWorker) run(ctx context.Context, task models.WorkerTask) {
+	start := time.Now()
+	dir, err := os.MkdirTemp("", "intelsk-worker-")
+	if err != nil {
+		log.Printf("batch %s: %v", task.TaskID, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	var result models.WorkerResult
+	paths, err := w.fetchFrames(ctx, task, dir)
+	if err == nil {
+		result.Embeddings, err = w.ml.EncodeImagesContext(ctx, paths)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		log.Printf("batch %s: %v", task.TaskID, err)
+	}
+
+	body, _ := json.Marshal(result)
+	postCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	resp, err := w.do(postCtx, http.MethodPost, "/workers/tasks/"+task.TaskID+"/result", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("batch %s: posting result: %v", task.TaskID, err)
+		return
+	}
+	resp.Body.Close()
+	if result.Error == "" {
+		log.Printf("Batch %s: %d frames encoded in %s", task.TaskID, task.Frames, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// fetchFrames downloads a batch's frames into dir, in order.
+func (w *indexWorker) fetchFrames(ctx context.Context, task models.WorkerTask, dir string) ([]string, error) {
+	paths := make([]string, task.Frames)
+	for i := range paths {
+		resp, err := w.do(ctx, http.MethodGet, fmt.Sprintf("/workers/tasks/%s/frames/%d", task.TaskID, i), nil)
+		if err != nil {
+			return nil, fmt.Errorf("fetching frame %d: %w", i, err)
+		}
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%06d.jpg", i))
+		f, err := os.Create(paths[i])
+		if err == nil {
+			_, err = io.Copy(f, resp.Body)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("saving frame %d: %w", i, err)
+		}
+	}
+	return paths, nil
+}
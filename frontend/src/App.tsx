@@ -5,6 +5,7 @@ import MainPage from './pages/MainPage';
 import CamerasPage from './pages/CamerasPage';
 import CameraDetailPage from './pages/CameraDetailPage';
 import ProcessPage from './pages/ProcessPage';
+import AlertsPage from './pages/AlertsPage';
 import SettingsPage from './pages/SettingsPage';
 
 const queryClient = new QueryClient({
@@ -27,6 +28,7 @@ export default function App() {
             <Route path="/cameras" element={<CamerasPage />} />
             <Route path="/cameras/:id" element={<CameraDetailPage />} />
             <Route path="/process" element={<ProcessPage />} />
+            <Route path="/alerts" element={<AlertsPage />} />
             <Route path="/settings" element={<SettingsPage />} />
           </Routes>
         </div>
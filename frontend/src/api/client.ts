@@ -4,6 +4,8 @@ import type {
   CreateCameraRequest,
   UpdateCameraRequest,
   VideoFile,
+  CameraTimeline,
+  TimelinePosition,
   ProcessRequest,
   ProcessResponse,
   ProcessHistoryEntry,
@@ -11,12 +13,23 @@ import type {
   SearchResponse,
   ProgressEvent,
   UploadJobEvent,
+  UploadJobInfo,
+  Alert,
+  AlertStatus,
+  AlertRuleSettings,
+  Bookmark,
   SettingsMap,
   SettingsResponse,
+  SettingsImportResponse,
+  SettingsChangeEvent,
+  SettingSchema,
   ModelInfo,
+  ModelDownload,
+  StreamStatus,
+  StreamDiagnostics,
 } from './types';
 
-const BASE = '/api';
+const BASE = '/api/v1';
 
 async function fetchJSON<T>(url: string, options?: RequestInit): Promise<T> {
   const res = await fetch(url, options);
@@ -59,6 +72,14 @@ export async function updateCamera(id: string, req: UpdateCameraRequest): Promis
   });
 }
 
+export async function renameCamera(id: string, newId: string): Promise<CameraInfo> {
+  return fetchJSON(`${BASE}/cameras/${id}/rename`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ new_id: newId }),
+  });
+}
+
 export async function deleteCamera(id: string, deleteData: boolean): Promise<void> {
   await fetchJSON(`${BASE}/cameras/${id}?delete_data=${deleteData}`, {
     method: 'DELETE',
@@ -69,6 +90,16 @@ export async function getCameraVideos(id: string): Promise<VideoFile[]> {
   return fetchJSON(`${BASE}/cameras/${id}/videos`);
 }
 
+export async function getCameraTimeline(id: string, date: string): Promise<CameraTimeline> {
+  return fetchJSON(`${BASE}/cameras/${id}/timeline?date=${encodeURIComponent(date)}`);
+}
+
+// locateInTimeline resolves a wall-clock time (YYYY-MM-DDTHH:MM:SS) to the
+// video and offset to play, or the gap it falls in.
+export async function locateInTimeline(id: string, at: string): Promise<TimelinePosition> {
+  return fetchJSON(`${BASE}/cameras/${id}/timeline/locate?at=${encodeURIComponent(at)}`);
+}
+
 export async function deleteVideo(id: string, date: string, filename: string): Promise<void> {
   await fetchJSON(`${BASE}/cameras/${id}/videos?date=${encodeURIComponent(date)}&filename=${encodeURIComponent(filename)}`, {
     method: 'DELETE',
@@ -81,35 +112,134 @@ export async function cleanCameraData(id: string, scope: 'videos' | 'all'): Prom
   });
 }
 
-export function uploadVideos(
-  id: string,
-  files: FileList | File[],
-  onProgress?: (loaded: number, total: number) => void,
-): Promise<{ status: string; paths: string[]; job_id?: string }> {
+const UPLOAD_CHUNK_SIZE = 8 * 1024 * 1024;
+const UPLOAD_MAX_RETRIES = 5;
+
+function sendChunk(
+  url: string,
+  offset: number,
+  chunk: Blob,
+  onProgress?: (loaded: number) => void,
+): Promise<number> {
   return new Promise((resolve, reject) => {
-    const formData = new FormData();
-    for (let i = 0; i < files.length; i++) {
-      formData.append('files', files[i]);
-    }
     const xhr = new XMLHttpRequest();
-    xhr.open('POST', `${BASE}/cameras/${id}/upload`);
+    xhr.open('PATCH', url);
+    xhr.setRequestHeader('Upload-Offset', String(offset));
+    xhr.setRequestHeader('Content-Type', 'application/octet-stream');
     if (onProgress) {
-      xhr.upload.onprogress = (e) => {
-        if (e.lengthComputable) onProgress(e.loaded, e.total);
-      };
+      xhr.upload.onprogress = (e) => onProgress(e.loaded);
     }
     xhr.onload = () => {
       if (xhr.status >= 200 && xhr.status < 300) {
-        resolve(JSON.parse(xhr.responseText));
+        resolve(Number(xhr.getResponseHeader('Upload-Offset') ?? offset + chunk.size));
       } else {
         reject(new Error(`${xhr.status}: ${xhr.responseText}`));
       }
     };
     xhr.onerror = () => reject(new Error('Upload failed'));
-    xhr.send(formData);
+    xhr.send(chunk);
   });
 }
 
+// Uploads one file in chunks. After a failed chunk it asks the server how
+// much it has and resumes from there instead of starting over.
+async function uploadFileResumable(
+  id: string,
+  file: File,
+  onProgress: (loaded: number) => void,
+): Promise<string> {
+  const session = await fetchJSON<{ upload_id: string }>(`${BASE}/cameras/${id}/uploads`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ filename: file.name, size: file.size }),
+  });
+  const url = `${BASE}/cameras/${id}/uploads/${session.upload_id}`;
+
+  let offset = 0;
+  let retries = 0;
+  while (offset < file.size) {
+    const chunk = file.slice(offset, offset + UPLOAD_CHUNK_SIZE);
+    try {
+      const base = offset;
+      offset = await sendChunk(url, offset, chunk, (loaded) => onProgress(base + loaded));
+      retries = 0;
+    } catch (err) {
+      if (++retries > UPLOAD_MAX_RETRIES) throw err;
+      await new Promise((r) => setTimeout(r, 1000 * 2 ** (retries - 1)));
+      const status = await fetchJSON<{ offset: number }>(url);
+      offset = status.offset;
+    }
+    onProgress(offset);
+  }
+  return session.upload_id;
+}
+
+export async function uploadVideos(
+  id: string,
+  files: FileList | File[],
+  onProgress?: (loaded: number, total: number) => void,
+): Promise<{ status: string; paths: string[]; job_id?: string }> {
+  const list = Array.from(files).filter((f) => f.name.toLowerCase().endsWith('.mp4'));
+  if (list.length === 0) {
+    throw new Error('400: no .mp4 files found in upload');
+  }
+  const total = list.reduce((sum, f) => sum + f.size, 0);
+  let done = 0;
+
+  const uploadIds: string[] = [];
+  for (const file of list) {
+    uploadIds.push(await uploadFileResumable(id, file, (loaded) => onProgress?.(done + loaded, total)));
+    done += file.size;
+  }
+
+  return fetchJSON(`${BASE}/cameras/${id}/uploads/finalize`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ upload_ids: uploadIds }),
+  });
+}
+
+// importVideoURL has the server download a video from an http(s) URL, such
+// as an S3 presigned one, and process it like an upload; the download is
+// the first phase of the returned job.
+export async function importVideoURL(
+  id: string,
+  url: string,
+): Promise<{ status: string; filename: string; job_id: string }> {
+  return fetchJSON(`${BASE}/cameras/${id}/import`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ url }),
+  });
+}
+
+const SSE_MAX_RECONNECTS = 5;
+
+// EventSource reconnects on its own and sends Last-Event-ID, so the server
+// resumes after the last event we saw. Give up only when the browser has
+// stopped retrying (e.g. 404 for an unknown job) or after repeated failures
+// without a message in between.
+function reconnectOrFinish(eventSource: EventSource, onDone: () => void): () => void {
+  let failures = 0;
+  eventSource.addEventListener('message', () => {
+    failures = 0;
+  });
+  return () => {
+    failures++;
+    if (eventSource.readyState === EventSource.CLOSED || failures > SSE_MAX_RECONNECTS) {
+      eventSource.close();
+      onDone();
+    }
+  };
+}
+
+// getUploadJobs lists a camera's upload jobs, newest first, optionally only
+// those with the given status.
+export async function getUploadJobs(cameraId: string, status?: string): Promise<UploadJobInfo[]> {
+  const query = status ? `?status=${status}` : '';
+  return fetchJSON<UploadJobInfo[]>(`${BASE}/cameras/${cameraId}/upload/jobs${query}`);
+}
+
 export function streamUploadStatus(
   cameraId: string,
   jobId: string,
@@ -129,10 +259,7 @@ export function streamUploadStatus(
     }
   };
 
-  eventSource.onerror = () => {
-    eventSource.close();
-    onDone();
-  };
+  eventSource.onerror = reconnectOrFinish(eventSource, onDone);
 
   return () => eventSource.close();
 }
@@ -161,10 +288,7 @@ export function streamProcessStatus(
     }
   };
 
-  eventSource.onerror = () => {
-    eventSource.close();
-    onDone();
-  };
+  eventSource.onerror = reconnectOrFinish(eventSource, onDone);
 
   return () => eventSource.close();
 }
@@ -185,6 +309,10 @@ export async function getSettings(): Promise<SettingsResponse> {
   return fetchJSON<SettingsResponse>(`${BASE}/settings`);
 }
 
+export async function getSettingsSchema(): Promise<SettingSchema[]> {
+  return fetchJSON<SettingSchema[]>(`${BASE}/settings/schema`);
+}
+
 export interface NVRStatusResponse {
   status: 'connected' | 'error' | 'not_configured';
   error?: string;
@@ -199,6 +327,26 @@ export async function getNVRStatus(): Promise<NVRStatusResponse> {
   return fetchJSON<NVRStatusResponse>(`${BASE}/settings/nvr/status`);
 }
 
+export interface NotificationChannelInfo {
+  name: 'email' | 'telegram' | 'slack' | 'webhook';
+  configured: boolean;
+}
+
+export interface NotificationTestResponse {
+  status: 'sent' | 'error';
+  error?: string;
+}
+
+export async function getNotificationChannels(): Promise<NotificationChannelInfo[]> {
+  return fetchJSON<NotificationChannelInfo[]>(`${BASE}/settings/notifications`);
+}
+
+export async function testNotificationChannel(channel: string): Promise<NotificationTestResponse> {
+  return fetchJSON<NotificationTestResponse>(`${BASE}/settings/notifications/${encodeURIComponent(channel)}/test`, {
+    method: 'POST',
+  });
+}
+
 export async function updateSettings(settings: Partial<SettingsMap>): Promise<SettingsResponse> {
   return fetchJSON<SettingsResponse>(`${BASE}/settings`, {
     method: 'PUT',
@@ -207,6 +355,36 @@ export async function updateSettings(settings: Partial<SettingsMap>): Promise<Se
   });
 }
 
+// streamSettingsEvents calls onEvent whenever the server's settings change,
+// by this client or any other. EventSource reconnects on its own.
+export function streamSettingsEvents(onEvent: (event: SettingsChangeEvent) => void): () => void {
+  const eventSource = new EventSource(`${BASE}/settings/events`);
+  eventSource.onmessage = (e) => onEvent(JSON.parse(e.data));
+  return () => eventSource.close();
+}
+
+// exportSettings fetches the settings export as a JSON string. Secrets are
+// masked unless a passphrase is given to encrypt them.
+export async function exportSettings(passphrase: string): Promise<string> {
+  const headers: Record<string, string> = {};
+  if (passphrase) headers['X-Settings-Passphrase'] = passphrase;
+  const resp = await fetch(`${BASE}/settings/export`, { headers });
+  if (!resp.ok) {
+    throw new Error(`${resp.status}: ${await resp.text()}`);
+  }
+  return resp.text();
+}
+
+export async function importSettings(exported: string, passphrase: string): Promise<SettingsImportResponse> {
+  const headers: Record<string, string> = { 'Content-Type': 'application/json' };
+  if (passphrase) headers['X-Settings-Passphrase'] = passphrase;
+  return fetchJSON<SettingsImportResponse>(`${BASE}/settings/import`, {
+    method: 'POST',
+    headers,
+    body: exported,
+  });
+}
+
 export async function getModelInfo(): Promise<ModelInfo> {
   return fetchJSON<ModelInfo>(`${BASE}/clip/model`);
 }
@@ -219,18 +397,133 @@ export async function switchModel(preset: string): Promise<ModelInfo> {
   });
 }
 
+// streamModelDownload follows the ML sidecar loading a preset, downloading
+// its weights first when they aren't cached. The server ends the stream
+// once the load is ready or has failed.
+export function streamModelDownload(
+  preset: string,
+  onEvent: (event: ModelDownload) => void,
+): () => void {
+  const eventSource = new EventSource(`${BASE}/clip/model/download?preset=${encodeURIComponent(preset)}`);
+  eventSource.onmessage = (e) => {
+    const event: ModelDownload = JSON.parse(e.data);
+    onEvent(event);
+    if (event.status === 'ready' || event.status === 'error') {
+      eventSource.close();
+    }
+  };
+  return () => eventSource.close();
+}
+
 export function getCameraSnapshotUrl(id: string): string {
   return `${BASE}/cameras/${id}/snapshot`;
 }
 
-export async function startStream(id: string): Promise<void> {
-  await fetchJSON(`${BASE}/cameras/${id}/stream/start`, { method: 'POST' });
+// getFrameUrl asks for a frame fitted within width pixels, a cached copy
+// the server resizes once, rather than the full-size JPEG.
+export function getFrameUrl(frameUrl: string, width: number): string {
+  return `${frameUrl}${frameUrl.includes('?') ? '&' : '?'}w=${width}`;
+}
+
+// getVideoPosterUrl points at a recording's poster JPEG (its middle frame),
+// extracted by the server on first request.
+export function getVideoPosterUrl(cameraId: string, date: string, filename: string): string {
+  const stem = filename.replace(/\.mp4$/i, '');
+  return `${BASE}/videos/${encodeURIComponent(`${cameraId}--${date}--${stem}`)}/poster`;
+}
+
+// getTimelapseUrl points at the MP4 the server renders from a date's
+// extracted frames; the first request for a date takes a while.
+export function getTimelapseUrl(cameraId: string, date: string): string {
+  return `${BASE}/cameras/${cameraId}/timelapse?date=${date}`;
+}
+
+// startStream returns the signed playlist URL (segment URLs inside the
+// playlist are signed by the server) and the stream's source: the camera's
+// RTSP feed, or recent recordings looped for local cameras. With a range
+// (YYYY-MM-DDTHH:MM:SS; end defaults to an hour later) an NVR camera streams
+// its recordings of that stretch instead.
+export async function startStream(
+  id: string,
+  range?: { start: string; end?: string },
+): Promise<{ playlist_url: string; source: StreamStatus['source'] }> {
+  const params = new URLSearchParams();
+  if (range) {
+    params.set('start', range.start);
+    if (range.end) params.set('end', range.end);
+  }
+  const query = params.toString();
+  return fetchJSON(`${BASE}/cameras/${id}/stream/start${query ? `?${query}` : ''}`, { method: 'POST' });
+}
+
+export async function getStreamStatus(id: string): Promise<StreamStatus> {
+  return fetchJSON<StreamStatus>(`${BASE}/cameras/${id}/stream/status`);
+}
+
+// getStreamDiagnostics adds ffmpeg's recent output (credentials redacted)
+// and throughput to the stream status.
+export async function getStreamDiagnostics(id: string): Promise<StreamDiagnostics> {
+  return fetchJSON<StreamDiagnostics>(`${BASE}/cameras/${id}/stream/diagnostics`);
 }
 
 export async function stopStream(id: string): Promise<void> {
   await fetchJSON(`${BASE}/cameras/${id}/stream/stop`, { method: 'POST' });
 }
 
-export function getStreamPlaylistUrl(id: string): string {
-  return `${BASE}/cameras/${id}/stream/index.m3u8`;
+export interface AlertFilter {
+  status?: AlertStatus;
+  camera_id?: string;
+  rule?: string;
+  start_date?: string;
+  end_date?: string;
+  limit?: number;
+}
+
+// getAlerts lists alerts, newest first, matching every filter set.
+export async function getAlerts(filter: AlertFilter = {}): Promise<Alert[]> {
+  const params = new URLSearchParams();
+  for (const [key, value] of Object.entries(filter)) {
+    if (value !== undefined && value !== '') params.set(key, String(value));
+  }
+  const query = params.toString();
+  return fetchJSON<Alert[]>(`${BASE}/alerts${query ? `?${query}` : ''}`);
+}
+
+export async function ackAlert(id: string): Promise<Alert> {
+  return fetchJSON<Alert>(`${BASE}/alerts/${id}/ack`, { method: 'POST' });
+}
+
+export async function dismissAlert(id: string): Promise<Alert> {
+  return fetchJSON<Alert>(`${BASE}/alerts/${id}/dismiss`, { method: 'POST' });
+}
+
+export async function getAlertRules(): Promise<AlertRuleSettings[]> {
+  return fetchJSON(`${BASE}/alerts/rules`);
+}
+
+export async function updateAlertRule(
+  rule: string,
+  req: Partial<Pick<AlertRuleSettings, 'quiet_start' | 'quiet_end' | 'cooldown_min'>>,
+): Promise<AlertRuleSettings> {
+  return fetchJSON(`${BASE}/alerts/rules/${encodeURIComponent(rule)}`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(req),
+  });
+}
+
+export async function resetAlertRule(rule: string): Promise<AlertRuleSettings> {
+  return fetchJSON(`${BASE}/alerts/rules/${encodeURIComponent(rule)}`, { method: 'DELETE' });
+}
+
+export async function createBookmark(frameId: string, note = '', tags: string[] = []): Promise<Bookmark> {
+  return fetchJSON(`${BASE}/bookmarks`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ frame_id: frameId, note, tags }),
+  });
+}
+
+export async function deleteBookmark(id: string): Promise<void> {
+  await fetchJSON(`${BASE}/bookmarks/${id}`, { method: 'DELETE' });
 }
@@ -26,6 +26,9 @@ export interface ProcessRequest {
   end_date: string;
   start_time?: string;
   end_time?: string;
+  start_hour?: number;
+  end_hour?: number;
+  priority?: number;
 }
 
 export interface ProcessResponse {
@@ -36,8 +39,14 @@ export interface ProcessResponse {
 export interface ProgressEvent {
   stage: string;
   camera_id?: string;
+  date?: string;
+  file?: string;
   frames_done?: number;
   frames_total?: number;
+  videos_done?: number;
+  videos_total?: number;
+  percent?: number;
+  eta_sec?: number;
   message?: string;
 }
 
@@ -47,8 +56,21 @@ export interface TextSearchRequest {
   start_time?: string;
   end_time?: string;
   limit?: number;
+  dedup_window_sec?: number;
+  filter?: SearchFilter;
 }
 
+// A condition on search results, or an and/or/not of others.
+export type SearchFilter =
+  | { and: SearchFilter[] }
+  | { or: SearchFilter[] }
+  | { not: SearchFilter }
+  | {
+      field: 'camera' | 'camera_tag' | 'weekday' | 'hour' | 'class' | 'person' | 'score';
+      op?: 'eq' | 'in' | 'gte' | 'gt' | 'lte' | 'lt';
+      value: string | number | string[] | number[];
+    };
+
 export interface SearchResult {
   frame_id: string;
   frame_url: string;
@@ -57,6 +79,40 @@ export interface SearchResult {
   score: number;
   source_video_url?: string;
   seek_offset_sec: number;
+  clip_url?: string;
+  bookmarked: boolean;
+  bookmark_id?: string;
+  context?: FrameContext;
+}
+
+export interface WeatherHour {
+  hour: number;
+  temperature_c?: number;
+  precipitation_mm?: number;
+  cloud_cover_pct?: number;
+  code?: number;
+  conditions?: string;
+}
+
+// The light and weather at the site when a frame was taken
+export interface FrameContext {
+  light: 'day' | 'twilight' | 'night';
+  weather?: WeatherHour;
+}
+
+export interface Bookmark {
+  bookmark_id: string;
+  frame_id: string;
+  camera_id: string;
+  timestamp: string;
+  note: string;
+  tags: string[];
+  frame_url?: string;
+  source_video_url?: string;
+  seek_offset_sec: number;
+  clip_url?: string;
+  created_at: string;
+  updated_at: string;
 }
 
 export interface SearchResponse {
@@ -71,6 +127,41 @@ export interface VideoFile {
   size: number;
 }
 
+// Times on the day timeline are seconds since midnight.
+export interface TimelineSegment {
+  video_id: string;
+  video_url: string;
+  filename: string;
+  start_sec: number;
+  end_sec: number;
+  video_start_sec?: number;
+}
+
+export interface TimelineGap {
+  start_sec: number;
+  end_sec: number;
+}
+
+export interface CameraTimeline {
+  camera_id: string;
+  date: string;
+  day_sec: number;
+  recorded_sec: number;
+  segments: TimelineSegment[];
+  gaps: TimelineGap[];
+  untimed?: string[];
+}
+
+export interface TimelinePosition {
+  camera_id: string;
+  at: string;
+  video_id?: string;
+  video_url?: string;
+  offset_sec: number;
+  segment?: TimelineSegment;
+  gap?: TimelineGap;
+}
+
 export interface CameraDateStats {
   date: string;
   video_count: number;
@@ -87,10 +178,53 @@ export interface ProcessHistoryEntry {
 export interface UploadJobEvent {
   stage: string;
   file?: string;
-  current?: number;
-  total?: number;
+  bytes_done?: number;
+  bytes_total?: number;
   frames_done?: number;
   frames_total?: number;
+  percent?: number;
+  eta_sec?: number;
+}
+
+export interface UploadJobInfo {
+  job_id: string;
+  status: string;
+  error?: string;
+  date: string;
+  files: string[];
+  created_at: string;
+  finished_at?: string;
+  events: number;
+  last_event?: UploadJobEvent;
+}
+
+export type AlertStatus = 'new' | 'acknowledged' | 'dismissed';
+
+export interface Alert {
+  alert_id: string;
+  rule: string;
+  camera_id?: string;
+  status: AlertStatus;
+  title: string;
+  message?: string;
+  date: string;
+  timestamp?: string;
+  frame_url?: string;
+  clip_url?: string;
+  created_at: string;
+  updated_at: string;
+  acknowledged_at?: string;
+  group_id?: string;
+  views?: Alert[];
+}
+
+export interface AlertRuleSettings {
+  rule: string;
+  quiet_start: string;
+  quiet_end: string;
+  cooldown_min: number;
+  custom: boolean;
+  held: number;
 }
 
 export interface ModelInfo {
@@ -99,6 +233,21 @@ export interface ModelInfo {
   embedding_dim: number;
   presets?: Record<string, string>;
   status?: string;
+  // Reembed job migrating the index to preset, when the switch runs in the
+  // background
+  job_id?: string;
+}
+
+export interface ModelDownload {
+  preset: string;
+  status: 'idle' | 'downloading' | 'loading' | 'ready' | 'error';
+  file?: string;
+  files_done: number;
+  files_total: number;
+  bytes_done: number;
+  bytes_total: number;
+  percent: number;
+  error?: string;
 }
 
 export type SettingsMap = Record<string, number | boolean | string>;
@@ -106,4 +255,53 @@ export type SettingsMap = Record<string, number | boolean | string>;
 export interface SettingsResponse {
   settings: SettingsMap;
   defaults: SettingsMap;
+  restart_required: string[];
+}
+
+export interface SettingSchema {
+  key: string;
+  type: 'string' | 'int' | 'float' | 'bool' | 'time' | 'timezone' | 'url' | 'urls';
+  category: string;
+  default: number | boolean | string;
+  min?: number;
+  max?: number;
+  secret?: boolean;
+  restart?: boolean;
+  description: string;
+}
+
+export interface SettingsChangeEvent {
+  keys: string[];
+  changed_at: string;
+  restart_required: string[];
+}
+
+export interface SettingsImportResponse extends SettingsResponse {
+  skipped: string[];
+}
+
+export interface StreamStatus {
+  camera_id: string;
+  state: 'stopped' | 'starting' | 'healthy' | 'reconnecting' | 'failed' | 'ended';
+  format?: string;
+  source?: 'rtsp' | 'recordings' | 'nvr';
+  encoder?: string;
+  restarts: number;
+  last_error?: string;
+  started_at?: string;
+  last_access?: string;
+}
+
+export interface StreamLogLine {
+  time: string;
+  text: string;
+}
+
+export interface StreamDiagnostics extends StreamStatus {
+  uptime_sec: number;
+  frames: number;
+  fps: number;
+  speed: number;
+  bitrate_kbps: number;
+  log: StreamLogLine[];
 }
@@ -1,7 +1,7 @@
 import { useState, useEffect } from 'react';
 import { useTranslation } from 'react-i18next';
 import type { CameraInfo, CreateCameraRequest, UpdateCameraRequest } from '../api/types';
-import { createCamera, updateCamera, deleteCamera, uploadVideos, streamUploadStatus } from '../api/client';
+import { createCamera, updateCamera, renameCamera, deleteCamera, uploadVideos, importVideoURL, streamUploadStatus, getUploadJobs } from '../api/client';
 
 // --- Shared modal backdrop ---
 
@@ -35,6 +35,31 @@ function ModalBackdrop({
   );
 }
 
+// --- Live stream audio option (config.stream.audio) ---
+
+type StreamAudio = 'aac' | 'copy' | 'none';
+
+function StreamAudioSelect({ value, onChange }: { value: StreamAudio; onChange: (v: StreamAudio) => void }) {
+  const { t } = useTranslation();
+  return (
+    <div>
+      <label className="block text-sm font-medium text-gray-700 mb-1">
+        {t('cameras.field_stream_audio')}
+      </label>
+      <select
+        value={value}
+        onChange={(e) => onChange(e.target.value as StreamAudio)}
+        className="w-full border rounded px-3 py-2 text-sm"
+      >
+        <option value="aac">{t('cameras.stream_audio_aac')}</option>
+        <option value="copy">{t('cameras.stream_audio_copy')}</option>
+        <option value="none">{t('cameras.stream_audio_none')}</option>
+      </select>
+      <p className="text-xs text-gray-500 mt-1">{t('cameras.field_stream_audio_hint')}</p>
+    </div>
+  );
+}
+
 // --- Add Camera Modal ---
 
 interface AddCameraModalProps {
@@ -48,9 +73,10 @@ export function AddCameraModal({ isOpen, onClose, onCreated }: AddCameraModalPro
   const [id, setId] = useState('');
   const [name, setName] = useState('');
   const [cameraType, setCameraType] = useState<'local' | 'hikvision'>('local');
-  const [transcode, setTranscode] = useState(true);
   const [processOnUpload, setProcessOnUpload] = useState(true);
+  const [audio, setAudio] = useState<StreamAudio>('aac');
   const [nvrChannel, setNvrChannel] = useState(1);
+  const [autoIngest, setAutoIngest] = useState(false);
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState('');
 
@@ -59,9 +85,10 @@ export function AddCameraModal({ isOpen, onClose, onCreated }: AddCameraModalPro
       setId('');
       setName('');
       setCameraType('local');
-      setTranscode(true);
       setProcessOnUpload(true);
       setNvrChannel(1);
+      setAutoIngest(false);
+      setAudio('aac');
       setError('');
     }
   }, [isOpen]);
@@ -74,8 +101,9 @@ export function AddCameraModal({ isOpen, onClose, onCreated }: AddCameraModalPro
     setError('');
     try {
       const config: Record<string, unknown> = cameraType === 'local'
-        ? { transcode, process_on_upload: processOnUpload }
-        : { nvr_channel: nvrChannel, transcode, process_on_upload: processOnUpload };
+        ? { process_on_upload: processOnUpload }
+        : { nvr_channel: nvrChannel, auto_ingest: autoIngest, process_on_upload: processOnUpload };
+      config.stream = { audio };
       const req: CreateCameraRequest = { id, name, type: cameraType, config };
       await createCamera(req);
       onCreated();
@@ -171,15 +199,17 @@ export function AddCameraModal({ isOpen, onClose, onCreated }: AddCameraModalPro
               <p className="text-xs text-gray-500 mt-1">{t('cameras.field_nvr_channel_hint')}</p>
             </div>
           )}
-          <label className="flex items-center gap-2 text-sm text-gray-700">
-            <input
-              type="checkbox"
-              checked={transcode}
-              onChange={(e) => setTranscode(e.target.checked)}
-              className="rounded"
-            />
-            {t('cameras.transcode')}
-          </label>
+          {cameraType === 'hikvision' && (
+            <label className="flex items-center gap-2 text-sm text-gray-700">
+              <input
+                type="checkbox"
+                checked={autoIngest}
+                onChange={(e) => setAutoIngest(e.target.checked)}
+                className="rounded"
+              />
+              {t('cameras.auto_ingest')}
+            </label>
+          )}
           <label className="flex items-center gap-2 text-sm text-gray-700">
             <input
               type="checkbox"
@@ -189,6 +219,7 @@ export function AddCameraModal({ isOpen, onClose, onCreated }: AddCameraModalPro
             />
             {t('cameras.process_on_upload')}
           </label>
+          <StreamAudioSelect value={audio} onChange={setAudio} />
         </div>
         <div className="px-6 py-4 border-t flex justify-end gap-3">
           <button
@@ -222,19 +253,27 @@ interface EditCameraModalProps {
 
 export function EditCameraModal({ isOpen, camera, onClose, onUpdated }: EditCameraModalProps) {
   const { t } = useTranslation();
+  const [cameraId, setCameraId] = useState('');
   const [name, setName] = useState('');
-  const [transcode, setTranscode] = useState(true);
   const [processOnUpload, setProcessOnUpload] = useState(true);
+  const [audio, setAudio] = useState<StreamAudio>('aac');
   const [nvrChannel, setNvrChannel] = useState(1);
+  const [autoIngest, setAutoIngest] = useState(false);
+  const [clockOffset, setClockOffset] = useState(0);
+  const [tags, setTags] = useState('');
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState('');
 
   useEffect(() => {
     if (isOpen && camera) {
+      setCameraId(camera.id);
+      setTags(((camera.config?.tags as string[] | undefined) ?? []).join(', '));
       setName(camera.name);
-      setTranscode(camera.config?.transcode !== false);
       setProcessOnUpload(camera.config?.process_on_upload !== false);
       setNvrChannel((camera.config?.nvr_channel as number) ?? 1);
+      setAutoIngest(camera.config?.auto_ingest === true);
+      setClockOffset((camera.config?.clock_offset_sec as number) ?? 0);
+      setAudio(((camera.config?.stream as Record<string, unknown> | undefined)?.audio as StreamAudio) ?? 'aac');
       setError('');
     }
   }, [isOpen, camera]);
@@ -248,11 +287,31 @@ export function EditCameraModal({ isOpen, camera, onClose, onUpdated }: EditCame
     setLoading(true);
     setError('');
     try {
-      const config: Record<string, unknown> = isHikvision
-        ? { nvr_channel: nvrChannel, transcode, process_on_upload: processOnUpload }
-        : { transcode, process_on_upload: processOnUpload };
+      // Keep keys this form doesn't edit, e.g. other stream overrides
+      const config: Record<string, unknown> = { ...camera.config, process_on_upload: processOnUpload };
+      if (isHikvision) {
+        config.nvr_channel = nvrChannel;
+        config.auto_ingest = autoIngest;
+      }
+      config.stream = { ...(camera.config?.stream as Record<string, unknown> | undefined), audio };
+      if (clockOffset !== 0) {
+        config.clock_offset_sec = clockOffset;
+      } else {
+        delete config.clock_offset_sec;
+      }
+      const tagList = tags.split(',').map((tag) => tag.trim()).filter(Boolean);
+      if (tagList.length > 0) {
+        config.tags = tagList;
+      } else {
+        delete config.tags;
+      }
       const req: UpdateCameraRequest = { name, config };
-      await updateCamera(camera.id, req);
+      let id = camera.id;
+      if (cameraId !== camera.id) {
+        await renameCamera(camera.id, cameraId);
+        id = cameraId;
+      }
+      await updateCamera(id, req);
       onUpdated();
       onClose();
     } catch (err) {
@@ -278,10 +337,13 @@ export function EditCameraModal({ isOpen, camera, onClose, onUpdated }: EditCame
             </label>
             <input
               type="text"
-              value={camera.id}
-              disabled
-              className="w-full border rounded px-3 py-2 text-sm bg-gray-50 text-gray-500"
+              value={cameraId}
+              onChange={(e) => setCameraId(e.target.value)}
+              required
+              pattern="[a-zA-Z0-9][a-zA-Z0-9_-]*"
+              className="w-full border rounded px-3 py-2 text-sm"
             />
+            <p className="text-xs text-gray-500 mt-1">{t('cameras.field_id_rename_hint')}</p>
           </div>
           <div>
             <label className="block text-sm font-medium text-gray-700 mb-1">
@@ -311,15 +373,17 @@ export function EditCameraModal({ isOpen, camera, onClose, onUpdated }: EditCame
               <p className="text-xs text-gray-500 mt-1">{t('cameras.field_nvr_channel_hint')}</p>
             </div>
           )}
-          <label className="flex items-center gap-2 text-sm text-gray-700">
-            <input
-              type="checkbox"
-              checked={transcode}
-              onChange={(e) => setTranscode(e.target.checked)}
-              className="rounded"
-            />
-            {t('cameras.transcode')}
-          </label>
+          {isHikvision && (
+            <label className="flex items-center gap-2 text-sm text-gray-700">
+              <input
+                type="checkbox"
+                checked={autoIngest}
+                onChange={(e) => setAutoIngest(e.target.checked)}
+                className="rounded"
+              />
+              {t('cameras.auto_ingest')}
+            </label>
+          )}
           <label className="flex items-center gap-2 text-sm text-gray-700">
             <input
               type="checkbox"
@@ -329,6 +393,34 @@ export function EditCameraModal({ isOpen, camera, onClose, onUpdated }: EditCame
             />
             {t('cameras.process_on_upload')}
           </label>
+          <div>
+            <label className="block text-sm font-medium text-gray-700 mb-1">
+              {t('cameras.field_clock_offset')}
+            </label>
+            <input
+              type="number"
+              value={clockOffset}
+              onChange={(e) => setClockOffset(parseInt(e.target.value, 10) || 0)}
+              min={-86400}
+              max={86400}
+              className="w-full border rounded px-3 py-2 text-sm"
+            />
+            <p className="text-xs text-gray-500 mt-1">{t('cameras.field_clock_offset_hint')}</p>
+          </div>
+          <div>
+            <label className="block text-sm font-medium text-gray-700 mb-1">
+              {t('cameras.field_tags')}
+            </label>
+            <input
+              type="text"
+              value={tags}
+              onChange={(e) => setTags(e.target.value)}
+              placeholder="outdoor, entrance"
+              className="w-full border rounded px-3 py-2 text-sm"
+            />
+            <p className="text-xs text-gray-500 mt-1">{t('cameras.field_tags_hint')}</p>
+          </div>
+          <StreamAudioSelect value={audio} onChange={setAudio} />
         </div>
         <div className="px-6 py-4 border-t flex justify-end gap-3">
           <button
@@ -443,17 +535,17 @@ interface UploadVideoModalProps {
 
 export function UploadVideoModal({ isOpen, camera, onClose, onUploaded }: UploadVideoModalProps) {
   const { t } = useTranslation();
-  const [mode, setMode] = useState<'files' | 'directory'>('files');
+  const [mode, setMode] = useState<'files' | 'directory' | 'url'>('files');
   const [selectedFiles, setSelectedFiles] = useState<File[]>([]);
+  const [importURL, setImportURL] = useState('');
   const [loading, setLoading] = useState(false);
   const [uploadProgress, setUploadProgress] = useState(0);
   const [jobStatus, setJobStatus] = useState<{
     stage: string;
-    current?: number;
-    total?: number;
     file?: string;
     framesDone?: number;
     framesTotal?: number;
+    percent?: number;
   } | null>(null);
   const [error, setError] = useState('');
 
@@ -461,12 +553,55 @@ export function UploadVideoModal({ isOpen, camera, onClose, onUploaded }: Upload
     if (isOpen) {
       setMode('files');
       setSelectedFiles([]);
+      setImportURL('');
       setUploadProgress(0);
       setJobStatus(null);
       setError('');
     }
   }, [isOpen]);
 
+  // followJob shows an upload job's extract/index progress until it ends
+  const followJob = (cameraId: string, jobId: string) =>
+    new Promise<void>((resolve) => {
+      streamUploadStatus(
+        cameraId,
+        jobId,
+        (event) => {
+          setJobStatus({
+            stage: event.stage,
+            file: event.file,
+            framesDone: event.frames_done,
+            framesTotal: event.frames_total,
+            percent: event.percent,
+          });
+        },
+        () => resolve(),
+      );
+    });
+
+  // An upload still being indexed, e.g. from before a page reload, is
+  // picked up again instead of looking idle
+  const cameraId = camera?.id;
+  useEffect(() => {
+    if (!isOpen || !cameraId) return;
+    let active = true;
+    getUploadJobs(cameraId)
+      .then(async (jobs) => {
+        const job = jobs.find((j) => j.status === 'running' || j.status === 'queued');
+        if (!active || !job) return;
+        setLoading(true);
+        setUploadProgress(100);
+        await followJob(cameraId, job.job_id);
+        if (!active) return;
+        setLoading(false);
+        onUploaded();
+      })
+      .catch(() => {});
+    return () => {
+      active = false;
+    };
+  }, [isOpen, cameraId]);
+
   if (!isOpen || !camera) return null;
 
   const handleFileChange = (e: React.ChangeEvent<HTMLInputElement>) => {
@@ -479,37 +614,26 @@ export function UploadVideoModal({ isOpen, camera, onClose, onUploaded }: Upload
     setError('');
   };
 
+  const canUpload = mode === 'url' ? importURL.trim() !== '' : selectedFiles.length > 0;
+
   const handleUpload = async () => {
-    if (selectedFiles.length === 0) return;
+    if (!canUpload) return;
     setLoading(true);
     setUploadProgress(0);
     setJobStatus(null);
     setError('');
     try {
-      // Phase 1: Upload files
-      const result = await uploadVideos(camera.id, selectedFiles, (loaded, total) => {
-        setUploadProgress(Math.round((loaded / total) * 100));
-      });
+      // Phase 1: Upload files, or have the server download the URL as
+      // the first phase of its job
+      const result = mode === 'url'
+        ? await importVideoURL(camera.id, importURL.trim())
+        : await uploadVideos(camera.id, selectedFiles, (loaded, total) => {
+          setUploadProgress(Math.round((loaded / total) * 100));
+        });
 
-      // Phases 2-4: Transcode + Extract + Index via SSE
+      // Phases 2-3: Extract + Index via SSE
       if (result.job_id) {
-        await new Promise<void>((resolve) => {
-          streamUploadStatus(
-            camera.id,
-            result.job_id!,
-            (event) => {
-              setJobStatus({
-                stage: event.stage,
-                current: event.current,
-                total: event.total,
-                file: event.file,
-                framesDone: event.frames_done,
-                framesTotal: event.frames_total,
-              });
-            },
-            () => resolve(),
-          );
-        });
+        await followJob(camera.id, result.job_id);
       }
 
       // Done — refresh data and auto-close
@@ -527,23 +651,16 @@ export function UploadVideoModal({ isOpen, camera, onClose, onUploaded }: Upload
 
   if (jobStatus) {
     switch (jobStatus.stage) {
-      case 'transcoding':
-      case 'done':
-        progressPercent = jobStatus.total && jobStatus.total > 0
-          ? Math.round((jobStatus.current! / jobStatus.total) * 100)
-          : 0;
-        progressLabel = jobStatus.total && jobStatus.total > 0
-          ? t('cameras.transcoding', { current: jobStatus.current, total: jobStatus.total })
-          : t('cameras.uploading');
+      case 'downloading':
+        progressPercent = Math.round(jobStatus.percent ?? 0);
+        progressLabel = t('cameras.downloading');
         break;
       case 'extracting':
-        progressPercent = 100;
+        progressPercent = Math.round(jobStatus.percent ?? 0);
         progressLabel = t('cameras.extracting');
         break;
       case 'indexing':
-        progressPercent = jobStatus.framesTotal && jobStatus.framesTotal > 0
-          ? Math.round((jobStatus.framesDone! / jobStatus.framesTotal) * 100)
-          : 0;
+        progressPercent = Math.round(jobStatus.percent ?? 0);
         progressLabel = jobStatus.framesTotal && jobStatus.framesTotal > 0
           ? t('cameras.indexing', { done: jobStatus.framesDone, total: jobStatus.framesTotal })
           : t('cameras.indexing', { done: 0, total: 0 });
@@ -556,9 +673,8 @@ export function UploadVideoModal({ isOpen, camera, onClose, onUploaded }: Upload
   if (loading) {
     if (jobStatus) {
       switch (jobStatus.stage) {
-        case 'transcoding':
-        case 'done':
-          buttonLabel = t('cameras.transcoding', { current: jobStatus.current ?? 0, total: jobStatus.total ?? 0 });
+        case 'downloading':
+          buttonLabel = t('cameras.downloading');
           break;
         case 'extracting':
           buttonLabel = t('cameras.extracting');
@@ -613,6 +729,18 @@ export function UploadVideoModal({ isOpen, camera, onClose, onUploaded }: Upload
               >
                 {t('cameras.upload_mode_directory')}
               </button>
+              <button
+                type="button"
+                disabled={loading}
+                onClick={() => { setMode('url'); setSelectedFiles([]); }}
+                className={`px-3 py-1.5 text-sm rounded disabled:opacity-50 ${
+                  mode === 'url'
+                    ? 'bg-blue-600 text-white'
+                    : 'bg-gray-100 text-gray-700 hover:bg-gray-200'
+                }`}
+              >
+                {t('cameras.upload_mode_url')}
+              </button>
             </div>
           </div>
           <div>
@@ -626,6 +754,19 @@ export function UploadVideoModal({ isOpen, camera, onClose, onUploaded }: Upload
                 onChange={handleFileChange}
                 className="block w-full text-sm text-gray-500 file:mr-4 file:py-2 file:px-4 file:rounded file:border-0 file:text-sm file:font-medium file:bg-blue-50 file:text-blue-700 hover:file:bg-blue-100 disabled:opacity-50"
               />
+            ) : mode === 'url' ? (
+              <div>
+                <input
+                  key="url"
+                  type="url"
+                  value={importURL}
+                  disabled={loading}
+                  onChange={(e) => { setImportURL(e.target.value); setError(''); }}
+                  placeholder="https://"
+                  className="w-full border rounded px-3 py-2 text-sm disabled:opacity-50"
+                />
+                <p className="text-xs text-gray-500 mt-1">{t('cameras.upload_url_hint')}</p>
+              </div>
             ) : (
               <input
                 key="directory"
@@ -673,7 +814,7 @@ export function UploadVideoModal({ isOpen, camera, onClose, onUploaded }: Upload
           <button
             type="button"
             onClick={handleUpload}
-            disabled={loading || selectedFiles.length === 0}
+            disabled={loading || !canUpload}
             className="px-4 py-2 text-sm text-white bg-blue-600 hover:bg-blue-700 rounded disabled:opacity-50 min-h-[44px]"
           >
             {buttonLabel}
@@ -2,20 +2,30 @@ import { useEffect, useRef, useState } from 'react';
 import { useTranslation } from 'react-i18next';
 import Hls from 'hls.js';
 import type { CameraInfo } from '../api/types';
-import { startStream, stopStream, getStreamPlaylistUrl } from '../api/client';
+import { startStream, stopStream, getStreamStatus, getStreamDiagnostics } from '../api/client';
 
 interface LiveStreamModalProps {
   isOpen: boolean;
   camera: CameraInfo | null;
   onClose: () => void;
+  // Plays the NVR's recordings of this stretch instead of the live feed
+  playback?: { start: string; end?: string };
 }
 
-export function LiveStreamModal({ isOpen, camera, onClose }: LiveStreamModalProps) {
+export function LiveStreamModal({ isOpen, camera, onClose, playback }: LiveStreamModalProps) {
   const { t } = useTranslation();
   const videoRef = useRef<HTMLVideoElement>(null);
   const hlsRef = useRef<Hls | null>(null);
+  const urlRef = useRef('');
+  const playbackStart = playback?.start;
+  const playbackEnd = playback?.end;
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState('');
+  const [reconnecting, setReconnecting] = useState(false);
+  const [replay, setReplay] = useState(false);
+  const [recording, setRecording] = useState(false);
+  const [ffmpegLog, setFfmpegLog] = useState<string[]>([]);
+  const [showLog, setShowLog] = useState(false);
 
   useEffect(() => {
     if (!isOpen || !camera) return;
@@ -23,13 +33,20 @@ export function LiveStreamModal({ isOpen, camera, onClose }: LiveStreamModalProp
     let cancelled = false;
     setLoading(true);
     setError('');
+    setReconnecting(false);
+    setReplay(false);
+    setRecording(false);
+    setFfmpegLog([]);
+    setShowLog(false);
 
     const init = async () => {
       try {
-        await startStream(camera.id);
+        const started = await startStream(camera.id, playbackStart ? { start: playbackStart, end: playbackEnd } : undefined);
         if (cancelled) return;
-
-        const url = getStreamPlaylistUrl(camera.id);
+        const url = started.playlist_url;
+        urlRef.current = url;
+        setReplay(started.source === 'recordings');
+        setRecording(started.source === 'nvr');
 
         // Wait for the HLS playlist to become available
         const waitForPlaylist = async () => {
@@ -55,10 +72,12 @@ export function LiveStreamModal({ isOpen, camera, onClose }: LiveStreamModalProp
         }
 
         if (Hls.isSupported() && videoRef.current) {
-          const hls = new Hls({
-            enableWorker: true,
-            lowLatencyMode: true,
-          });
+          // A recording plays from its start, not the newest segment
+          const hls = new Hls(
+            started.source === 'nvr'
+              ? { enableWorker: true, startPosition: 0 }
+              : { enableWorker: true, lowLatencyMode: true },
+          );
           hls.loadSource(url);
           hls.attachMedia(videoRef.current);
           hls.on(Hls.Events.MANIFEST_PARSED, () => {
@@ -89,7 +108,11 @@ export function LiveStreamModal({ isOpen, camera, onClose }: LiveStreamModalProp
         }
       } catch (err) {
         if (!cancelled) {
-          setError(err instanceof Error ? err.message : 'Failed to start stream');
+          const message = err instanceof Error ? err.message : '';
+          // Every stream the server allows is being watched elsewhere
+          setError(message.includes('stream_limit')
+            ? t('cameras.live_stream_limit')
+            : message || 'Failed to start stream');
           setLoading(false);
         }
       }
@@ -107,8 +130,46 @@ export function LiveStreamModal({ isOpen, camera, onClose }: LiveStreamModalProp
         stopStream(camera.id).catch(() => {});
       }
     };
+  }, [isOpen, camera, playbackStart, playbackEnd, t]);
+
+  // The server restarts ffmpeg when the camera connection drops; follow its
+  // state and reload the playlist once the stream is healthy again.
+  useEffect(() => {
+    if (!isOpen || !camera) return;
+    let wasReconnecting = false;
+    const timer = setInterval(async () => {
+      try {
+        const status = await getStreamStatus(camera.id);
+        if (status.state === 'reconnecting') {
+          wasReconnecting = true;
+          setReconnecting(true);
+        } else if (status.state === 'failed') {
+          setReconnecting(false);
+          setError(status.last_error || 'Stream failed');
+        } else if (status.state === 'healthy' && wasReconnecting) {
+          wasReconnecting = false;
+          setReconnecting(false);
+          setError('');
+          if (hlsRef.current && urlRef.current) {
+            hlsRef.current.loadSource(urlRef.current);
+          }
+        }
+      } catch {
+        // status is advisory; the player reports its own errors
+      }
+    }, 3000);
+    return () => clearInterval(timer);
   }, [isOpen, camera]);
 
+  // A black player says nothing about why; fetch ffmpeg's last output so
+  // the error can show it (e.g. an RTSP 401 or an unsupported codec).
+  useEffect(() => {
+    if (!error || !camera) return;
+    getStreamDiagnostics(camera.id)
+      .then((d) => setFfmpegLog(d.log.slice(-20).map((l) => l.text)))
+      .catch(() => {});
+  }, [error, camera]);
+
   useEffect(() => {
     if (!isOpen) return;
     const handleEscape = (e: KeyboardEvent) => {
@@ -132,9 +193,19 @@ export function LiveStreamModal({ isOpen, camera, onClose }: LiveStreamModalProp
         <div className="px-4 py-3 flex items-center justify-between border-b border-gray-700">
           <div className="flex items-center gap-3">
             <h2 className="text-white font-medium">{camera.name}</h2>
-            <span className="px-2 py-0.5 text-xs font-bold text-white bg-red-600 rounded">
-              {t('cameras.live_indicator')}
-            </span>
+            {recording ? (
+              <span className="px-2 py-0.5 text-xs font-bold text-white bg-blue-600 rounded">
+                {t('cameras.recording_indicator')}
+              </span>
+            ) : replay ? (
+              <span className="px-2 py-0.5 text-xs font-bold text-white bg-gray-600 rounded">
+                {t('cameras.replay_indicator')}
+              </span>
+            ) : (
+              <span className="px-2 py-0.5 text-xs font-bold text-white bg-red-600 rounded">
+                {t('cameras.live_indicator')}
+              </span>
+            )}
           </div>
           <button
             onClick={onClose}
@@ -170,9 +241,27 @@ export function LiveStreamModal({ isOpen, camera, onClose }: LiveStreamModalProp
               </div>
             </div>
           )}
+          {reconnecting && !error && (
+            <div className="absolute top-2 left-2 px-2 py-1 text-xs text-white bg-yellow-600 rounded">
+              {t('cameras.live_reconnecting')}
+            </div>
+          )}
           {error && (
-            <div className="absolute inset-0 flex items-center justify-center">
+            <div className="absolute inset-0 flex flex-col items-center justify-center gap-2 p-4">
               <p className="text-red-400 text-sm">{error}</p>
+              {ffmpegLog.length > 0 && (
+                <button
+                  onClick={() => setShowLog(!showLog)}
+                  className="text-xs text-gray-400 hover:text-white underline"
+                >
+                  {showLog ? t('cameras.live_hide_details') : t('cameras.live_show_details')}
+                </button>
+              )}
+              {showLog && (
+                <pre className="w-full max-h-48 overflow-auto text-left text-xs text-gray-300 bg-gray-800 rounded p-2 whitespace-pre-wrap">
+                  {ffmpegLog.join('\n')}
+                </pre>
+              )}
             </div>
           )}
           <video
@@ -181,6 +270,7 @@ export function LiveStreamModal({ isOpen, camera, onClose }: LiveStreamModalProp
             autoPlay
             muted
             playsInline
+            controls={recording}
           />
         </div>
       </div>
@@ -29,6 +29,7 @@ export default function NavBar() {
     { to: '/', label: t('nav.title') },
     { to: '/cameras', label: t('nav.cameras') },
     { to: '/process', label: t('nav.process') },
+    { to: '/alerts', label: t('nav.alerts') },
     { to: '/settings', label: t('nav.settings') },
   ];
 
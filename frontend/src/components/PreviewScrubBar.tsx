@@ -0,0 +1,115 @@
+import { useEffect, useRef, useState } from 'react';
+
+interface PreviewCue {
+  start: number;
+  end: number;
+  url: string;
+  x: number;
+  y: number;
+  w: number;
+  h: number;
+}
+
+interface Props {
+  vttUrl: string;
+  duration: number;
+  onSeek: (sec: number) => void;
+}
+
+function parseTimestamp(ts: string): number {
+  const [h, m, s] = ts.trim().split(':');
+  return parseInt(h, 10) * 3600 + parseInt(m, 10) * 60 + parseFloat(s);
+}
+
+// parsePreviewVTT reads the server's sprite index: each cue's payload is a
+// sprite URL (relative to the VTT) with an #xywh= tile fragment.
+function parsePreviewVTT(text: string, base: string): PreviewCue[] {
+  const cues: PreviewCue[] = [];
+  for (const block of text.split(/\r?\n\r?\n/)) {
+    const lines = block.trim().split(/\r?\n/);
+    const timing = lines.findIndex((l) => l.includes('-->'));
+    if (timing < 0 || !lines[timing + 1]) continue;
+    const [start, end] = lines[timing].split('-->');
+    const [path, frag] = lines[timing + 1].split('#xywh=');
+    const [x, y, w, h] = (frag ?? '').split(',').map(Number);
+    cues.push({
+      start: parseTimestamp(start),
+      end: parseTimestamp(end),
+      url: new URL(path, base).toString(),
+      x, y, w, h,
+    });
+  }
+  return cues;
+}
+
+function formatTime(sec: number): string {
+  const m = Math.floor(sec / 60);
+  const s = Math.floor(sec % 60);
+  return `${m}:${s.toString().padStart(2, '0')}`;
+}
+
+// PreviewScrubBar is a seek bar showing the thumbnail under the pointer,
+// YouTube style, from the sprites the server generates per video.
+export default function PreviewScrubBar({ vttUrl, duration, onSeek }: Props) {
+  const barRef = useRef<HTMLDivElement>(null);
+  const [cues, setCues] = useState<PreviewCue[]>([]);
+  const [hover, setHover] = useState<{ left: number; time: number } | null>(null);
+
+  useEffect(() => {
+    let cancelled = false;
+    setCues([]);
+    const base = new URL(vttUrl, window.location.href).toString();
+    fetch(vttUrl)
+      .then((resp) => (resp.ok ? resp.text() : ''))
+      .then((text) => {
+        if (!cancelled && text) setCues(parsePreviewVTT(text, base));
+      })
+      .catch(() => {
+        // previews are optional; the native controls still seek
+      });
+    return () => {
+      cancelled = true;
+    };
+  }, [vttUrl]);
+
+  if (cues.length === 0 || !duration) return null;
+
+  const timeAt = (clientX: number) => {
+    const rect = barRef.current!.getBoundingClientRect();
+    const frac = Math.min(1, Math.max(0, (clientX - rect.left) / rect.width));
+    return { left: frac * rect.width, time: frac * duration };
+  };
+  const cue = hover && cues.find((c) => hover.time >= c.start && hover.time < c.end);
+
+  return (
+    <div className="px-4 py-3">
+      <div
+        ref={barRef}
+        className="relative h-2 bg-gray-700 rounded cursor-pointer"
+        onMouseMove={(e) => setHover(timeAt(e.clientX))}
+        onMouseLeave={() => setHover(null)}
+        onClick={(e) => onSeek(timeAt(e.clientX).time)}
+      >
+        {hover && (
+          <div
+            className="absolute bottom-4 -translate-x-1/2 pointer-events-none"
+            style={{ left: hover.left }}
+          >
+            {cue && (
+              <div
+                className="rounded border border-gray-600"
+                style={{
+                  width: cue.w,
+                  height: cue.h,
+                  backgroundImage: `url(${cue.url})`,
+                  backgroundPosition: `-${cue.x}px -${cue.y}px`,
+                }}
+              />
+            )}
+            <div className="text-center text-xs text-white mt-1">{formatTime(hover.time)}</div>
+          </div>
+        )}
+      </div>
+    </div>
+  );
+}
@@ -1,6 +1,7 @@
+import { useState } from 'react';
 import { useTranslation } from 'react-i18next';
-import { format } from 'date-fns';
 import type { SearchResult } from '../api/types';
+import { createBookmark, deleteBookmark, getFrameUrl } from '../api/client';
 import PlayButtonOverlay from './PlayButtonOverlay';
 
 interface Props {
@@ -11,19 +12,42 @@ interface Props {
 
 export default function ResultCard({ result, rank, onPlayVideo }: Props) {
   const { t } = useTranslation();
+  const [bookmarkId, setBookmarkId] = useState(result.bookmark_id);
+  const [saving, setSaving] = useState(false);
 
-  let formattedTime = result.timestamp;
-  try {
-    formattedTime = format(new Date(result.timestamp), 'HH:mm:ss');
-  } catch {
-    // keep raw timestamp
-  }
+  const toggleBookmark = async () => {
+    setSaving(true);
+    try {
+      if (bookmarkId) {
+        await deleteBookmark(bookmarkId);
+        setBookmarkId(undefined);
+      } else {
+        const b = await createBookmark(result.frame_id);
+        setBookmarkId(b.bookmark_id);
+      }
+    } catch (err) {
+      console.error('Bookmark failed:', err);
+    } finally {
+      setSaving(false);
+    }
+  };
+
+  // The timestamp carries the offset of general.timezone; show the camera's
+  // wall clock rather than the browser's
+  const formattedTime = result.timestamp.slice(11, 19) || result.timestamp;
+
+  const weather = result.context?.weather;
+  const contextText = result.context && [
+    t(`results.light_${result.context.light}`),
+    weather?.conditions,
+    weather?.temperature_c != null && `${Math.round(weather.temperature_c)}\u00b0C`,
+  ].filter(Boolean).join(', ');
 
   return (
     <div className="bg-white rounded-lg shadow overflow-hidden">
       <div className="relative aspect-video bg-gray-100">
         <img
-          src={result.frame_url}
+          src={getFrameUrl(result.frame_url, 640)}
           alt={`${result.camera_id} ${result.timestamp}`}
           className="w-full h-full object-cover"
           loading="lazy"
@@ -34,14 +58,32 @@ export default function ResultCard({ result, rank, onPlayVideo }: Props) {
         <div className="absolute top-1 right-1 bg-black/70 text-white text-xs px-1.5 py-0.5 rounded font-mono">
           #{rank}
         </div>
+        <button
+          onClick={toggleBookmark}
+          disabled={saving}
+          title={t(bookmarkId ? 'results.unbookmark' : 'results.bookmark')}
+          className={`absolute top-1 left-1 bg-black/70 text-xs px-1.5 py-0.5 rounded ${
+            bookmarkId ? 'text-yellow-400' : 'text-white'
+          }`}
+        >
+          {bookmarkId ? '\u2605' : '\u2606'}
+        </button>
       </div>
       <div className="p-2 text-sm space-y-0.5">
         <div className="flex justify-between items-center">
           <span className="font-medium text-gray-900">{result.camera_id}</span>
           <span className="text-gray-500 text-xs">{formattedTime}</span>
         </div>
+        {contextText && (
+          <div className="text-xs text-gray-500">{contextText}</div>
+        )}
         <div className="flex justify-between items-center text-xs text-gray-500">
           <span>{t('results.score')}: {result.score.toFixed(3)}</span>
+          {result.clip_url && (
+            <a href={`${result.clip_url}?download=1`} className="text-blue-600 hover:underline" download>
+              {t('results.download_clip')}
+            </a>
+          )}
         </div>
       </div>
     </div>
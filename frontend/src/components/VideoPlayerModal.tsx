@@ -1,5 +1,6 @@
 import { useEffect, useRef, useState } from 'react';
 import { useTranslation } from 'react-i18next';
+import PreviewScrubBar from './PreviewScrubBar';
 
 interface Props {
   isOpen: boolean;
@@ -10,6 +11,8 @@ interface Props {
   timestamp: string;
 }
 
+const CLIP_MARGIN_SEC = 15;
+
 export default function VideoPlayerModal({
   isOpen,
   onClose,
@@ -21,6 +24,10 @@ export default function VideoPlayerModal({
   const { t } = useTranslation();
   const videoRef = useRef<HTMLVideoElement>(null);
   const [error, setError] = useState(false);
+  // Set after the browser fails to play the original (e.g. HEVC); the
+  // server then transcodes it on the fly, starting at the seek offset
+  const [transcode, setTranscode] = useState(false);
+  const [duration, setDuration] = useState(0);
 
   useEffect(() => {
     if (!isOpen) return;
@@ -35,14 +42,36 @@ export default function VideoPlayerModal({
   useEffect(() => {
     if (isOpen) {
       setError(false);
+      setTranscode(false);
+      setDuration(0);
     }
   }, [isOpen, sourceVideoUrl]);
 
   if (!isOpen) return null;
 
+  const videoUrl = transcode
+    ? `${sourceVideoUrl}?transcode=1&start=${seekOffsetSec}`
+    : sourceVideoUrl;
+
+  // 30 seconds around the matched frame, cut server-side without re-encoding
+  const clipStart = Math.max(0, Math.floor(seekOffsetSec - CLIP_MARGIN_SEC));
+  const clipUrl = `${sourceVideoUrl.replace(/\/play$/, '/clip')}?start=${clipStart}&end=${Math.ceil(seekOffsetSec + CLIP_MARGIN_SEC)}`;
+  // The whole original, with its SHA-256 in the response headers
+  const downloadUrl = `${sourceVideoUrl.replace(/\/play$/, '/download')}?checksum=sha256`;
+
   const handleLoadedMetadata = () => {
-    if (videoRef.current) {
+    // The transcoded stream already starts at the offset and can't seek
+    if (videoRef.current && !transcode) {
       videoRef.current.currentTime = seekOffsetSec;
+      setDuration(videoRef.current.duration);
+    }
+  };
+
+  const handleError = () => {
+    if (transcode) {
+      setError(true);
+    } else {
+      setTranscode(true);
     }
   };
 
@@ -62,15 +91,31 @@ export default function VideoPlayerModal({
             <span className="mx-2">—</span>
             <span>{timestamp}</span>
           </div>
-          <button
-            onClick={onClose}
-            className="p-1.5 hover:bg-gray-700 rounded min-w-[44px] min-h-[44px] flex items-center justify-center"
-            aria-label={t('video.close')}
-          >
-            <svg className="w-5 h-5" fill="none" stroke="currentColor" viewBox="0 0 24 24">
-              <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2} d="M6 18L18 6M6 6l12 12" />
-            </svg>
-          </button>
+          <div className="flex items-center gap-2">
+            <a
+              href={clipUrl}
+              download
+              className="px-3 py-1.5 text-sm text-gray-300 hover:bg-gray-700 rounded min-h-[44px] flex items-center"
+            >
+              {t('video.download_clip')}
+            </a>
+            <a
+              href={downloadUrl}
+              download
+              className="px-3 py-1.5 text-sm text-gray-300 hover:bg-gray-700 rounded min-h-[44px] flex items-center"
+            >
+              {t('video.download_video')}
+            </a>
+            <button
+              onClick={onClose}
+              className="p-1.5 hover:bg-gray-700 rounded min-w-[44px] min-h-[44px] flex items-center justify-center"
+              aria-label={t('video.close')}
+            >
+              <svg className="w-5 h-5" fill="none" stroke="currentColor" viewBox="0 0 24 24">
+                <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2} d="M6 18L18 6M6 6l12 12" />
+              </svg>
+            </button>
+          </div>
         </div>
 
         {/* Video */}
@@ -81,13 +126,23 @@ export default function VideoPlayerModal({
         ) : (
           <video
             ref={videoRef}
-            src={sourceVideoUrl}
+            key={videoUrl}
+            src={videoUrl}
             className="w-full max-h-[75vh]"
             controls
             autoPlay
             muted
             onLoadedMetadata={handleLoadedMetadata}
-            onError={() => setError(true)}
+            onError={handleError}
+          />
+        )}
+        {!error && !transcode && (
+          <PreviewScrubBar
+            vttUrl={sourceVideoUrl.replace(/\/play$/, '/previews.vtt')}
+            duration={duration}
+            onSeek={(sec) => {
+              if (videoRef.current) videoRef.current.currentTime = sec;
+            }}
           />
         )}
       </div>
@@ -0,0 +1,302 @@
+import { useState } from 'react';
+import { useTranslation } from 'react-i18next';
+import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query';
+import {
+  getAlerts,
+  getCameras,
+  ackAlert,
+  dismissAlert,
+  getAlertRules,
+  updateAlertRule,
+  resetAlertRule,
+  getFrameUrl,
+} from '../api/client';
+import type { Alert, AlertStatus, AlertRuleSettings } from '../api/types';
+
+const statusTabs: (AlertStatus | '')[] = ['new', 'acknowledged', 'dismissed', ''];
+
+export default function AlertsPage() {
+  const { t } = useTranslation();
+  const queryClient = useQueryClient();
+  const [status, setStatus] = useState<AlertStatus | ''>('new');
+  const [cameraId, setCameraId] = useState('');
+  const [startDate, setStartDate] = useState('');
+  const [endDate, setEndDate] = useState('');
+
+  const { data: cameras = [] } = useQuery({
+    queryKey: ['cameras'],
+    queryFn: getCameras,
+  });
+
+  const filter = {
+    status: status || undefined,
+    camera_id: cameraId,
+    start_date: startDate,
+    end_date: endDate,
+    limit: 200,
+  };
+  const { data: alerts = [], isLoading } = useQuery({
+    queryKey: ['alerts', filter],
+    queryFn: () => getAlerts(filter),
+    refetchInterval: 15000,
+  });
+
+  const mutation = useMutation({
+    mutationFn: ({ id, action }: { id: string; action: 'ack' | 'dismiss' }) =>
+      action === 'ack' ? ackAlert(id) : dismissAlert(id),
+    onSuccess: () => queryClient.invalidateQueries({ queryKey: ['alerts'] }),
+  });
+
+  const statusBadge = (a: Alert) => (
+    <span
+      className={`text-xs px-2 py-0.5 rounded-full ${
+        a.status === 'new'
+          ? 'bg-red-100 text-red-700'
+          : a.status === 'acknowledged'
+            ? 'bg-amber-100 text-amber-700'
+            : 'bg-gray-100 text-gray-500'
+      }`}
+    >
+      {t(`alerts.status_${a.status}`)}
+    </span>
+  );
+
+  return (
+    <div className="max-w-5xl mx-auto px-4 sm:px-6 lg:px-8 py-6">
+      <h1 className="text-xl font-semibold text-gray-900 mb-4">{t('alerts.title')}</h1>
+
+      <div className="flex flex-wrap items-end gap-3 mb-5">
+        <div className="flex rounded border border-gray-300 overflow-hidden">
+          {statusTabs.map((s) => (
+            <button
+              key={s || 'all'}
+              type="button"
+              onClick={() => setStatus(s)}
+              className={`px-3 py-1.5 text-sm ${status === s ? 'bg-gray-900 text-white' : 'bg-white text-gray-700 hover:bg-gray-50'}`}
+            >
+              {s ? t(`alerts.status_${s}`) : t('alerts.all')}
+            </button>
+          ))}
+        </div>
+        <select
+          value={cameraId}
+          onChange={(e) => setCameraId(e.target.value)}
+          className="rounded border border-gray-300 px-3 py-1.5 text-sm"
+        >
+          <option value="">{t('alerts.all_cameras')}</option>
+          {cameras.map((c) => (
+            <option key={c.id} value={c.id}>{c.name}</option>
+          ))}
+        </select>
+        <input
+          type="date"
+          value={startDate}
+          onChange={(e) => setStartDate(e.target.value)}
+          className="rounded border border-gray-300 px-3 py-1.5 text-sm"
+          aria-label={t('alerts.start_date')}
+        />
+        <input
+          type="date"
+          value={endDate}
+          onChange={(e) => setEndDate(e.target.value)}
+          className="rounded border border-gray-300 px-3 py-1.5 text-sm"
+          aria-label={t('alerts.end_date')}
+        />
+      </div>
+
+      {isLoading ? null : alerts.length === 0 ? (
+        <div className="text-center text-gray-500 py-12">{t('alerts.none')}</div>
+      ) : (
+        <div className="space-y-3">
+          {alerts.map((a) => (
+            <div key={a.alert_id} className="bg-white rounded-lg shadow p-4 flex gap-4">
+              {a.frame_url && (
+                <a href={a.frame_url} target="_blank" rel="noreferrer" className="shrink-0">
+                  <img
+                    src={getFrameUrl(a.frame_url, 320)}
+                    alt={a.title}
+                    className="w-40 aspect-video object-cover rounded bg-gray-100"
+                    loading="lazy"
+                  />
+                </a>
+              )}
+              <div className="flex-1 min-w-0">
+                <div className="flex items-center justify-between gap-2">
+                  <h3 className="font-medium text-gray-900 truncate">{a.title}</h3>
+                  {statusBadge(a)}
+                </div>
+                {a.message && <p className="text-sm text-gray-600 mt-1">{a.message}</p>}
+                <p className="text-xs text-gray-400 mt-1">
+                  {[a.camera_id, a.timestamp?.slice(0, 19).replace('T', ' ') || a.date, a.rule].filter(Boolean).join(' · ')}
+                </p>
+                {a.views && a.views.length > 0 && (
+                  <div className="mt-2">
+                    <p className="text-xs text-gray-500">{t('alerts.views', { count: a.views.length })}</p>
+                    <div className="flex flex-wrap gap-2 mt-1">
+                      {a.views.map((v) => (
+                        <a
+                          key={v.alert_id}
+                          href={v.frame_url || v.clip_url}
+                          target="_blank"
+                          rel="noreferrer"
+                          className="block w-24"
+                          title={[v.camera_id, v.timestamp?.slice(0, 19).replace('T', ' ')].filter(Boolean).join(' · ')}
+                        >
+                          {v.frame_url ? (
+                            <img
+                              src={getFrameUrl(v.frame_url, 192)}
+                              alt={v.camera_id}
+                              className="w-24 aspect-video object-cover rounded bg-gray-100"
+                              loading="lazy"
+                            />
+                          ) : null}
+                          <span className="block text-[11px] text-gray-500 truncate">{v.camera_id}</span>
+                        </a>
+                      ))}
+                    </div>
+                  </div>
+                )}
+                <div className="flex items-center gap-2 mt-3">
+                  {a.clip_url && (
+                    <a
+                      href={a.clip_url}
+                      className="px-3 py-1.5 text-xs text-blue-600 hover:bg-blue-50 rounded min-h-[36px] inline-flex items-center"
+                    >
+                      {t('alerts.clip')}
+                    </a>
+                  )}
+                  {a.status === 'new' && (
+                    <button
+                      onClick={() => mutation.mutate({ id: a.alert_id, action: 'ack' })}
+                      disabled={mutation.isPending}
+                      className="px-3 py-1.5 text-xs text-amber-700 hover:bg-amber-50 rounded min-h-[36px] font-medium disabled:opacity-50"
+                    >
+                      {t('alerts.ack')}
+                    </button>
+                  )}
+                  {a.status !== 'dismissed' && (
+                    <button
+                      onClick={() => mutation.mutate({ id: a.alert_id, action: 'dismiss' })}
+                      disabled={mutation.isPending}
+                      className="px-3 py-1.5 text-xs text-gray-600 hover:bg-gray-100 rounded min-h-[36px] disabled:opacity-50"
+                    >
+                      {t('alerts.dismiss')}
+                    </button>
+                  )}
+                </div>
+              </div>
+            </div>
+          ))}
+        </div>
+      )}
+
+      <AlertRules />
+    </div>
+  );
+}
+
+// AlertRules edits the quiet hours and cooldown of each alert rule.
+function AlertRules() {
+  const { t } = useTranslation();
+  const { data: rules = [] } = useQuery({
+    queryKey: ['alert-rules'],
+    queryFn: getAlertRules,
+    refetchInterval: 60000,
+  });
+  if (rules.length === 0) return null;
+
+  return (
+    <div className="bg-white rounded-lg shadow p-4 mt-8">
+      <h2 className="font-medium text-gray-900">{t('alerts.rules')}</h2>
+      <p className="text-xs text-gray-500 mt-1 mb-3">{t('alerts.rules_hint')}</p>
+      <div className="overflow-x-auto">
+        <table className="w-full text-sm">
+          <thead>
+            <tr className="text-left text-xs text-gray-500">
+              <th className="py-2 pr-3 font-medium">{t('alerts.rule')}</th>
+              <th className="py-2 pr-3 font-medium">{t('alerts.quiet_hours')}</th>
+              <th className="py-2 pr-3 font-medium">{t('alerts.cooldown')}</th>
+              <th className="py-2 pr-3 font-medium">{t('alerts.held')}</th>
+              <th />
+            </tr>
+          </thead>
+          <tbody>
+            {rules.map((rule) => (
+              <AlertRuleRow key={`${rule.rule}-${rule.custom}-${rule.quiet_start}-${rule.quiet_end}-${rule.cooldown_min}`} rule={rule} />
+            ))}
+          </tbody>
+        </table>
+      </div>
+    </div>
+  );
+}
+
+function AlertRuleRow({ rule }: { rule: AlertRuleSettings }) {
+  const { t } = useTranslation();
+  const queryClient = useQueryClient();
+  const [quietStart, setQuietStart] = useState(rule.quiet_start);
+  const [quietEnd, setQuietEnd] = useState(rule.quiet_end);
+  const [cooldown, setCooldown] = useState(rule.cooldown_min);
+  const dirty = quietStart !== rule.quiet_start || quietEnd !== rule.quiet_end || cooldown !== rule.cooldown_min;
+
+  const onSuccess = () => queryClient.invalidateQueries({ queryKey: ['alert-rules'] });
+  const save = useMutation({
+    mutationFn: () =>
+      updateAlertRule(rule.rule, { quiet_start: quietStart, quiet_end: quietEnd, cooldown_min: cooldown }),
+    onSuccess,
+  });
+  const reset = useMutation({ mutationFn: () => resetAlertRule(rule.rule), onSuccess });
+
+  return (
+    <tr className="border-t border-gray-100">
+      <td className="py-2 pr-3 font-mono text-xs text-gray-700">
+        {rule.rule}
+        {!rule.custom && <span className="ml-2 font-sans text-gray-400">({t('alerts.default')})</span>}
+      </td>
+      <td className="py-2 pr-3 whitespace-nowrap">
+        <input
+          type="time"
+          value={quietStart}
+          onChange={(e) => setQuietStart(e.target.value)}
+          className="rounded border border-gray-300 px-2 py-1 text-sm"
+        />
+        <span className="mx-1 text-gray-400">–</span>
+        <input
+          type="time"
+          value={quietEnd}
+          onChange={(e) => setQuietEnd(e.target.value)}
+          className="rounded border border-gray-300 px-2 py-1 text-sm"
+        />
+      </td>
+      <td className="py-2 pr-3">
+        <input
+          type="number"
+          min={0}
+          max={1440}
+          value={cooldown}
+          onChange={(e) => setCooldown(Number(e.target.value))}
+          className="w-20 rounded border border-gray-300 px-2 py-1 text-sm"
+        />
+      </td>
+      <td className="py-2 pr-3 text-gray-600">{rule.held}</td>
+      <td className="py-2 whitespace-nowrap text-right">
+        <button
+          onClick={() => save.mutate()}
+          disabled={!dirty || save.isPending}
+          className="px-3 py-1.5 text-xs text-blue-600 hover:bg-blue-50 rounded font-medium disabled:opacity-50"
+        >
+          {t('alerts.save')}
+        </button>
+        {rule.custom && (
+          <button
+            onClick={() => reset.mutate()}
+            disabled={reset.isPending}
+            className="px-3 py-1.5 text-xs text-gray-600 hover:bg-gray-100 rounded disabled:opacity-50"
+          >
+            {t('alerts.reset')}
+          </button>
+        )}
+      </td>
+    </tr>
+  );
+}
@@ -2,7 +2,7 @@ import { useState, useEffect } from 'react';
 import { useParams, Link, useNavigate } from 'react-router-dom';
 import { useTranslation } from 'react-i18next';
 import { useQuery, useQueryClient } from '@tanstack/react-query';
-import { getCamera, getCameraStats, getCameraVideos, cleanCameraData, deleteVideo } from '../api/client';
+import { getCamera, getCameraStats, getCameraVideos, getVideoPosterUrl, getTimelapseUrl, cleanCameraData, deleteVideo } from '../api/client';
 import { LiveStreamModal } from '../components/LiveStreamModal';
 
 function formatSize(bytes: number): string {
@@ -126,14 +126,12 @@ export default function CameraDetailPage() {
           <p className="text-sm text-gray-500">{camera.id}</p>
         </div>
         <div className="flex items-center gap-2">
-          {camera.type === 'hikvision' && (
-            <button
-              onClick={() => setShowLive(true)}
-              className="px-3 py-1.5 text-xs text-green-600 hover:bg-green-50 rounded border border-green-200 font-medium min-h-[36px]"
-            >
-              {t('cameras.live')}
-            </button>
-          )}
+          <button
+            onClick={() => setShowLive(true)}
+            className="px-3 py-1.5 text-xs text-green-600 hover:bg-green-50 rounded border border-green-200 font-medium min-h-[36px]"
+          >
+            {t('cameras.live')}
+          </button>
           <span
             className={`text-xs px-2 py-0.5 rounded-full ${
               camera.status === 'indexed'
@@ -171,9 +169,19 @@ export default function CameraDetailPage() {
                 <div className="px-4 py-3 border-b flex items-center justify-between">
                   <h3 className="font-medium text-gray-900">{date}</h3>
                   {frameCount > 0 && (
-                    <span className="text-xs text-gray-500">
-                      {t('detail.frame_count', { count: frameCount })}
-                    </span>
+                    <div className="flex items-center gap-3">
+                      <a
+                        href={getTimelapseUrl(id!, date)}
+                        target="_blank"
+                        rel="noreferrer"
+                        className="text-xs text-blue-600 hover:text-blue-800"
+                      >
+                        {t('detail.timelapse')}
+                      </a>
+                      <span className="text-xs text-gray-500">
+                        {t('detail.frame_count', { count: frameCount })}
+                      </span>
+                    </div>
                   )}
                 </div>
                 <ul className="divide-y">
@@ -184,7 +192,18 @@ export default function CameraDetailPage() {
                         key={key}
                         className="px-4 py-2 flex items-center justify-between text-sm"
                       >
-                        <span className="text-gray-800 truncate">{v.filename}</span>
+                        <div className="flex items-center gap-3 min-w-0">
+                          <img
+                            src={getVideoPosterUrl(id!, v.date, v.filename)}
+                            alt=""
+                            loading="lazy"
+                            className="w-20 h-12 object-cover rounded bg-gray-100 shrink-0"
+                            onError={(e) => {
+                              e.currentTarget.style.visibility = 'hidden';
+                            }}
+                          />
+                          <span className="text-gray-800 truncate">{v.filename}</span>
+                        </div>
                         <div className="flex items-center gap-3 ml-4 shrink-0">
                           <span className="text-gray-400">{formatSize(v.size)}</span>
                           <button
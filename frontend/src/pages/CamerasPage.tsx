@@ -132,14 +132,13 @@ export default function CamerasPage() {
                     {t('cameras.edit')}
                   </button>
                 )}
-                {cam.type === 'hikvision' ? (
-                  <button
-                    onClick={() => setLiveTarget(cam)}
-                    className="px-3 py-1.5 text-xs text-green-600 hover:bg-green-50 rounded min-h-[36px] font-medium"
-                  >
-                    {t('cameras.live')}
-                  </button>
-                ) : (
+                <button
+                  onClick={() => setLiveTarget(cam)}
+                  className="px-3 py-1.5 text-xs text-green-600 hover:bg-green-50 rounded min-h-[36px] font-medium"
+                >
+                  {t('cameras.live')}
+                </button>
+                {cam.type !== 'hikvision' && (
                   <button
                     onClick={() => setUploadTarget(cam)}
                     className="px-3 py-1.5 text-xs text-blue-600 hover:bg-blue-50 rounded min-h-[36px]"
@@ -3,6 +3,7 @@ import { useTranslation } from 'react-i18next';
 import { useQuery } from '@tanstack/react-query';
 import {
   getCameras,
+  getSettings,
   searchText,
 } from '../api/client';
 import type { SearchResult } from '../api/types';
@@ -33,6 +34,22 @@ export default function MainPage() {
   const [startTime, setStartTime] = useState(defaults.startTime);
   const [endTime, setEndTime] = useState(defaults.endTime);
 
+  // Dates and times are in the server's timezone setting, which may differ
+  // from the browser's; redo the default range once it is known
+  const { data: settingsData } = useQuery({
+    queryKey: ['settings'],
+    queryFn: getSettings,
+  });
+  const timeZone = settingsData?.settings['general.timezone'] as string | undefined;
+  useEffect(() => {
+    if (!timeZone) return;
+    const range = defaultTimeRange(timeZone);
+    setStartDate(range.startDate);
+    setEndDate(range.endDate);
+    setStartTime(range.startTime);
+    setEndTime(range.endTime);
+  }, [timeZone]);
+
   // Search state
   const [query, setQuery] = useState('');
   const [searching, setSearching] = useState(false);
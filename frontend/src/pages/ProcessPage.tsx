@@ -3,11 +3,13 @@ import { useTranslation } from 'react-i18next';
 import { useQuery } from '@tanstack/react-query';
 import {
   getCameras,
+  getSettings,
   startProcess,
   streamProcessStatus,
 } from '../api/client';
-import type { ProgressEvent } from '../api/types';
-import { defaultTimeRange } from '../utils/time';
+import type { CameraInfo, ProgressEvent } from '../api/types';
+import { LiveStreamModal } from '../components/LiveStreamModal';
+import { defaultTimeRange, formatDuration } from '../utils/time';
 
 export default function ProcessPage() {
   const { t } = useTranslation();
@@ -25,12 +27,34 @@ export default function ProcessPage() {
   const [endDate, setEndDate] = useState(defaults.endDate);
   const [startTime, setStartTime] = useState(defaults.startTime);
   const [endTime, setEndTime] = useState(defaults.endTime);
+  // Urgent runs go ahead of, and pause, backfills waiting or running
+  const [startHour, setStartHour] = useState('');
+  const [endHour, setEndHour] = useState('');
+  const [urgent, setUrgent] = useState(false);
+
+  // Dates and times are in the server's timezone setting, which may differ
+  // from the browser's; redo the default range once it is known
+  const { data: settingsData } = useQuery({
+    queryKey: ['settings'],
+    queryFn: getSettings,
+  });
+  const timeZone = settingsData?.settings['general.timezone'] as string | undefined;
+  useEffect(() => {
+    if (!timeZone) return;
+    const range = defaultTimeRange(timeZone);
+    setStartDate(range.startDate);
+    setEndDate(range.endDate);
+    setStartTime(range.startTime);
+    setEndTime(range.endTime);
+  }, [timeZone]);
 
   // Processing state
   const [processing, setProcessing] = useState(false);
   const [processReady, setProcessReady] = useState(false);
   const [events, setEvents] = useState<ProgressEvent[]>([]);
   const [progressPct, setProgressPct] = useState(0);
+  // NVR camera whose recordings are streamed while the job downloads them
+  const [watchCamera, setWatchCamera] = useState<CameraInfo | null>(null);
 
   // Auto-select all cameras on load
   useEffect(() => {
@@ -60,6 +84,9 @@ export default function ProcessPage() {
         end_date: endDate || startDate,
         start_time: startTime || undefined,
         end_time: endTime || undefined,
+        start_hour: startHour !== '' ? Number(startHour) : undefined,
+        end_hour: endHour !== '' ? Number(endHour) : undefined,
+        priority: urgent ? 10 : undefined,
       });
 
       if (res.status === 'already_cached') {
@@ -72,10 +99,10 @@ export default function ProcessPage() {
         res.job_id,
         (event) => {
           setEvents((prev) => [...prev, event]);
-          if (event.frames_total && event.frames_total > 0) {
-            setProgressPct(
-              Math.round(((event.frames_done || 0) / event.frames_total) * 100),
-            );
+          // The server reports the whole job's progress; events before any
+          // work starts carry none
+          if (event.percent !== undefined) {
+            setProgressPct(event.percent);
           }
         },
         () => {
@@ -88,6 +115,14 @@ export default function ProcessPage() {
     }
   };
 
+  // The NVR plays the selected stretch directly; without an end on the same
+  // day the server streams an hour from the start
+  const playbackRange = {
+    start: `${startDate}T${startTime || '00:00'}:00`,
+    end: endTime && (!endDate || endDate === startDate) ? `${startDate}T${endTime}:59` : undefined,
+  };
+  const watchable = cameras.filter((c) => c.type === 'hikvision' && selectedCameras.includes(c.id));
+
   const logRef = useRef<HTMLDivElement>(null);
   const lastEvent = events[events.length - 1];
   const hasErrors = events.some((e) => e.stage === 'error');
@@ -176,6 +211,37 @@ export default function ProcessPage() {
           </div>
         </div>
 
+        {/* Hours of each date, e.g. only 18-22 */}
+        <div className="flex flex-wrap items-center gap-2 text-sm text-gray-700">
+          <span className="font-medium">{t('process.hours')}</span>
+          <input
+            type="number"
+            min={0}
+            max={23}
+            placeholder="0"
+            value={startHour}
+            onChange={(e) => setStartHour(e.target.value)}
+            className="w-20 px-3 py-2 border rounded-md text-sm"
+          />
+          <span>&ndash;</span>
+          <input
+            type="number"
+            min={1}
+            max={24}
+            placeholder="24"
+            value={endHour}
+            onChange={(e) => setEndHour(e.target.value)}
+            className="w-20 px-3 py-2 border rounded-md text-sm"
+          />
+          <span className="text-gray-400">{t('process.hours_hint')}</span>
+        </div>
+
+        <label className="flex items-center gap-2 text-sm text-gray-700">
+          <input type="checkbox" checked={urgent} onChange={(e) => setUrgent(e.target.checked)} />
+          {t('process.urgent')}
+          <span className="text-gray-400">{t('process.urgent_hint')}</span>
+        </label>
+
         {/* Process button */}
         <div className="flex items-center gap-4">
           <button
@@ -210,11 +276,32 @@ export default function ProcessPage() {
               </div>
             )}
 
+            {/* Review NVR footage before it is downloaded */}
+            {processing && watchable.length > 0 && (
+              <div className="flex flex-wrap gap-2">
+                {watchable.map((cam) => (
+                  <button
+                    key={cam.id}
+                    onClick={() => setWatchCamera(cam)}
+                    className="px-3 py-1.5 text-sm border border-blue-600 text-blue-600 rounded-md hover:bg-blue-50 min-h-[44px]"
+                  >
+                    {t('process.watch_now', { camera: cam.name })}
+                  </button>
+                ))}
+              </div>
+            )}
+
             {/* Current status */}
             {processing && lastEvent && (
-              <p className="text-sm text-gray-600 font-medium">
-                {lastEvent.message}
-              </p>
+              <div className="flex justify-between gap-4 text-sm text-gray-600 font-medium">
+                <p>{lastEvent.message}</p>
+                {progressPct > 0 && (
+                  <p className="shrink-0 tabular-nums">
+                    {Math.round(progressPct)}%
+                    {lastEvent.eta_sec ? ` · ${t('process.eta', { time: formatDuration(lastEvent.eta_sec) })}` : ''}
+                  </p>
+                )}
+              </div>
             )}
 
             {/* Scrollable event log */}
@@ -230,11 +317,9 @@ export default function ProcessPage() {
                           ? 'text-green-600'
                           : ev.stage === 'downloading'
                             ? 'text-blue-600'
-                            : ev.stage === 'transcoding'
-                              ? 'text-purple-600'
-                              : ev.stage === 'skipped'
-                                ? 'text-gray-400'
-                                : 'text-gray-600'
+                            : ev.stage === 'skipped'
+                              ? 'text-gray-400'
+                              : 'text-gray-600'
                     }`}
                   >
                     {ev.message}
@@ -245,6 +330,13 @@ export default function ProcessPage() {
           </div>
         )}
       </section>
+
+      <LiveStreamModal
+        isOpen={watchCamera !== null}
+        camera={watchCamera}
+        onClose={() => setWatchCamera(null)}
+        playback={playbackRange}
+      />
     </div>
   );
 }
@@ -6,31 +6,44 @@ import {
   updateSettings,
   getModelInfo,
   switchModel,
-  getCameras,
-  startProcess,
   streamProcessStatus,
+  streamModelDownload,
   getNVRStatus,
+  getNotificationChannels,
+  testNotificationChannel,
+  exportSettings,
+  importSettings,
+  streamSettingsEvents,
+  getSettingsSchema,
 } from '../api/client';
-import type { NVRStatusResponse } from '../api/client';
-import type { SettingsMap, ProgressEvent } from '../api/types';
+import type { NVRStatusResponse, NotificationChannelInfo, NotificationTestResponse } from '../api/client';
+import type { SettingsMap, SettingSchema, ProgressEvent, ModelDownload } from '../api/types';
 
 interface FieldDef {
   key: string;
   label: string;
   hint: string;
-  type: 'float' | 'int' | 'bool' | 'string' | 'password';
+  type: 'float' | 'int' | 'bool' | 'string' | 'password' | 'time';
   step?: number;
   min?: number;
   max?: number;
+  untranslated?: boolean;
 }
 
 const generalFields: FieldDef[] = [
   { key: 'general.system_name', label: 'settings.system_name', hint: 'settings.system_name_hint', type: 'string' },
+  { key: 'general.timezone', label: 'settings.timezone', hint: 'settings.timezone_hint', type: 'string' },
 ];
 
 const searchFields: FieldDef[] = [
   { key: 'search.min_score', label: 'settings.min_score', hint: 'settings.min_score_hint', type: 'float', step: 0.01, min: 0, max: 1 },
   { key: 'search.default_limit', label: 'settings.default_limit', hint: 'settings.default_limit_hint', type: 'int', min: 1, max: 500 },
+  { key: 'search.dedup_window_sec', label: 'settings.dedup_window', hint: 'settings.dedup_window_hint', type: 'int', min: 0, max: 3600 },
+  { key: 'reports.classes', label: 'settings.report_classes', hint: 'settings.report_classes_hint', type: 'string' },
+  { key: 'enrichment.latitude', label: 'settings.enrichment_latitude', hint: 'settings.enrichment_latitude_hint', type: 'float', step: 0.0001, min: -90, max: 90 },
+  { key: 'enrichment.longitude', label: 'settings.enrichment_longitude', hint: 'settings.enrichment_longitude_hint', type: 'float', step: 0.0001, min: -180, max: 180 },
+  { key: 'enrichment.weather_provider', label: 'settings.enrichment_weather_provider', hint: 'settings.enrichment_weather_provider_hint', type: 'string' },
+  { key: 'enrichment.weather_url', label: 'settings.enrichment_weather_url', hint: 'settings.enrichment_weather_url_hint', type: 'string' },
 ];
 
 const extractionFields: FieldDef[] = [
@@ -38,10 +51,17 @@ const extractionFields: FieldDef[] = [
   { key: 'extraction.output_quality', label: 'settings.output_quality', hint: 'settings.output_quality_hint', type: 'int', min: 1, max: 100 },
   { key: 'extraction.dedup_enabled', label: 'settings.dedup_enabled', hint: 'settings.dedup_enabled_hint', type: 'bool' },
   { key: 'extraction.dedup_phash_threshold', label: 'settings.dedup_threshold', hint: 'settings.dedup_threshold_hint', type: 'int', min: 0, max: 64 },
+  { key: 'extraction.timestamp_source', label: 'settings.timestamp_source', hint: 'settings.timestamp_source_hint', type: 'string' },
+  { key: 'videos.duplicates', label: 'settings.video_duplicates', hint: 'settings.video_duplicates_hint', type: 'string' },
+  { key: 'videos.archive_after_days', label: 'settings.video_archive_after_days', hint: 'settings.video_archive_after_days_hint', type: 'int', min: 0, max: 3650 },
 ];
 
 const clipFields: FieldDef[] = [
   { key: 'clip.batch_size', label: 'settings.batch_size', hint: 'settings.batch_size_hint', type: 'int', min: 1, max: 256 },
+  { key: 'clip.batch_adaptive', label: 'settings.batch_adaptive', hint: 'settings.batch_adaptive_hint', type: 'bool' },
+  { key: 'clip.batch_size_min', label: 'settings.batch_size_min', hint: 'settings.batch_size_min_hint', type: 'int', min: 1, max: 256 },
+  { key: 'clip.batch_size_max', label: 'settings.batch_size_max', hint: 'settings.batch_size_max_hint', type: 'int', min: 1, max: 256 },
+  { key: 'clip.batch_target_sec', label: 'settings.batch_target_sec', hint: 'settings.batch_target_sec_hint', type: 'float', step: 1, min: 1, max: 600 },
 ];
 
 const nvrFields: FieldDef[] = [
@@ -49,8 +69,93 @@ const nvrFields: FieldDef[] = [
   { key: 'nvr.rtsp_port', label: 'settings.nvr_rtsp_port', hint: 'settings.nvr_rtsp_port_hint', type: 'int', min: 1, max: 65535 },
   { key: 'nvr.username', label: 'settings.nvr_username', hint: 'settings.nvr_username_hint', type: 'string' },
   { key: 'nvr.password', label: 'settings.nvr_password', hint: 'settings.nvr_password_hint', type: 'password' },
+  { key: 'ingest.interval_min', label: 'settings.ingest_interval', hint: 'settings.ingest_interval_hint', type: 'int', min: 1, max: 1440 },
 ];
 
+const streamFields: FieldDef[] = [
+  { key: 'stream.segment_sec', label: 'settings.stream_segment', hint: 'settings.stream_segment_hint', type: 'float', step: 0.5, min: 0.5, max: 10 },
+  { key: 'stream.playlist_size', label: 'settings.stream_playlist_size', hint: 'settings.stream_playlist_size_hint', type: 'int', min: 2, max: 30 },
+  { key: 'stream.idle_timeout_sec', label: 'settings.stream_idle_timeout', hint: 'settings.stream_idle_timeout_hint', type: 'int', min: 10, max: 3600 },
+  { key: 'stream.max_height', label: 'settings.stream_max_height', hint: 'settings.stream_max_height_hint', type: 'int', min: 0, max: 2160 },
+  { key: 'stream.bitrate_kbps', label: 'settings.stream_bitrate', hint: 'settings.stream_bitrate_hint', type: 'int', min: 0, max: 20000 },
+  { key: 'stream.nvr_stream', label: 'settings.stream_nvr_stream', hint: 'settings.stream_nvr_stream_hint', type: 'int', min: 1, max: 2 },
+];
+
+const processFields: FieldDef[] = [
+  { key: 'process.window_start', label: 'settings.process_window_start', hint: 'settings.process_window_start_hint', type: 'time' },
+  { key: 'process.window_end', label: 'settings.process_window_end', hint: 'settings.process_window_end_hint', type: 'time' },
+  { key: 'jobs.retention_days', label: 'settings.jobs_retention', hint: 'settings.jobs_retention_hint', type: 'int', min: 1, max: 365 },
+  { key: 'jobs.max_concurrent', label: 'settings.jobs_max_concurrent', hint: 'settings.jobs_max_concurrent_hint', type: 'int', min: 1, max: 16 },
+  { key: 'jobs.resume_interrupted', label: 'settings.jobs_resume_interrupted', hint: 'settings.jobs_resume_interrupted_hint', type: 'bool' },
+  { key: 'jobs.pause_on_low_disk', label: 'settings.jobs_pause_on_low_disk', hint: 'settings.jobs_pause_on_low_disk_hint', type: 'bool' },
+];
+
+const alertFields: FieldDef[] = [
+  { key: 'alerts.camera_check_min', label: 'settings.alerts_camera_check', hint: 'settings.alerts_camera_check_hint', type: 'int', min: 0, max: 1440 },
+  { key: 'alerts.no_recording_hours', label: 'settings.alerts_no_recording', hint: 'settings.alerts_no_recording_hint', type: 'int', min: 0, max: 168 },
+  { key: 'alerts.quiet_start', label: 'settings.alerts_quiet_start', hint: 'settings.alerts_quiet_start_hint', type: 'time' },
+  { key: 'alerts.quiet_end', label: 'settings.alerts_quiet_end', hint: 'settings.alerts_quiet_end_hint', type: 'time' },
+  { key: 'alerts.cooldown_min', label: 'settings.alerts_cooldown', hint: 'settings.alerts_cooldown_hint', type: 'int', min: 0, max: 1440 },
+  { key: 'alerts.group_window_sec', label: 'settings.alerts_group_window', hint: 'settings.alerts_group_window_hint', type: 'int', min: 0, max: 600 },
+  { key: 'alerts.disk_low_gb', label: 'settings.alerts_disk_low', hint: 'settings.alerts_disk_low_hint', type: 'float', step: 0.5, min: 0, max: 100000 },
+  { key: 'alerts.disk_critical_gb', label: 'settings.alerts_disk_critical', hint: 'settings.alerts_disk_critical_hint', type: 'float', step: 0.5, min: 0, max: 100000 },
+  { key: 'alerts.db_size_gb', label: 'settings.alerts_db_size', hint: 'settings.alerts_db_size_hint', type: 'float', step: 0.5, min: 0, max: 100000 },
+  { key: 'alerts.watch_queries', label: 'settings.alerts_watch_queries', hint: 'settings.alerts_watch_queries_hint', type: 'string' },
+  { key: 'alerts.watch_min_score', label: 'settings.alerts_watch_min_score', hint: 'settings.alerts_watch_min_score_hint', type: 'float', step: 0.01, min: 0, max: 1 },
+];
+
+const notifyFields: FieldDef[] = [
+  { key: 'notify.job_failures', label: 'settings.notify_job_failures', hint: 'settings.notify_job_failures_hint', type: 'bool' },
+  { key: 'notify.attach_frame', label: 'settings.notify_attach_frame', hint: 'settings.notify_attach_frame_hint', type: 'bool' },
+  { key: 'notify.attach_clip', label: 'settings.notify_attach_clip', hint: 'settings.notify_attach_clip_hint', type: 'bool' },
+  { key: 'notify.clip_sec', label: 'settings.notify_clip_sec', hint: 'settings.notify_clip_sec_hint', type: 'int', min: 1, max: 60 },
+  { key: 'notify.email_smtp_host', label: 'settings.notify_email_smtp_host', hint: 'settings.notify_email_smtp_host_hint', type: 'string' },
+  { key: 'notify.email_smtp_port', label: 'settings.notify_email_smtp_port', hint: 'settings.notify_email_smtp_port_hint', type: 'int', min: 1, max: 65535 },
+  { key: 'notify.email_username', label: 'settings.notify_email_username', hint: 'settings.notify_email_username_hint', type: 'string' },
+  { key: 'notify.email_password', label: 'settings.notify_email_password', hint: 'settings.notify_email_password_hint', type: 'password' },
+  { key: 'notify.email_from', label: 'settings.notify_email_from', hint: 'settings.notify_email_from_hint', type: 'string' },
+  { key: 'notify.email_to', label: 'settings.notify_email_to', hint: 'settings.notify_email_to_hint', type: 'string' },
+  { key: 'notify.telegram_bot_token', label: 'settings.notify_telegram_bot_token', hint: 'settings.notify_telegram_bot_token_hint', type: 'password' },
+  { key: 'notify.telegram_chat_id', label: 'settings.notify_telegram_chat_id', hint: 'settings.notify_telegram_chat_id_hint', type: 'string' },
+  { key: 'notify.slack_webhook_url', label: 'settings.notify_slack_webhook_url', hint: 'settings.notify_slack_webhook_url_hint', type: 'password' },
+  { key: 'notify.webhook_url', label: 'settings.notify_webhook_url', hint: 'settings.notify_webhook_url_hint', type: 'string' },
+];
+
+const mlFields: FieldDef[] = [
+  { key: 'ml.url', label: 'settings.ml_url', hint: 'settings.ml_url_hint', type: 'string' },
+  { key: 'ml.extra_urls', label: 'settings.ml_extra_urls', hint: 'settings.ml_extra_urls_hint', type: 'string' },
+  { key: 'ml.timeout_sec', label: 'settings.ml_timeout', hint: 'settings.ml_timeout_hint', type: 'int', min: 5, max: 3600 },
+  { key: 'ml.ready_timeout_sec', label: 'settings.ml_ready_timeout', hint: 'settings.ml_ready_timeout_hint', type: 'int', min: 0, max: 3600 },
+  { key: 'ml.max_concurrent', label: 'settings.ml_max_concurrent', hint: 'settings.ml_max_concurrent_hint', type: 'int', min: 0, max: 32 },
+  { key: 'ml.send_images', label: 'settings.ml_send_images', hint: 'settings.ml_send_images_hint', type: 'bool' },
+  { key: 'ml.retries', label: 'settings.ml_retries', hint: 'settings.ml_retries_hint', type: 'int', min: 0, max: 10 },
+  { key: 'ml.breaker_threshold', label: 'settings.ml_breaker_threshold', hint: 'settings.ml_breaker_threshold_hint', type: 'int', min: 0, max: 100 },
+  { key: 'ml.breaker_cooldown_sec', label: 'settings.ml_breaker_cooldown', hint: 'settings.ml_breaker_cooldown_hint', type: 'int', min: 1, max: 3600 },
+  { key: 'workers.enabled', label: 'settings.workers_enabled', hint: 'settings.workers_enabled_hint', type: 'bool' },
+];
+
+// Keys with a field above, or a card of their own (clip.model)
+const knownKeys = new Set([
+  ...[generalFields, searchFields, extractionFields, clipFields, nvrFields, streamFields, processFields, alertFields, notifyFields, mlFields].flat().map((f) => f.key),
+  'clip.model',
+]);
+
+// schemaField describes a setting this page has no field for, e.g. one added
+// by a newer backend, from the server's schema. Its label and hint are plain
+// text rather than translation keys.
+function schemaField(s: SettingSchema): FieldDef {
+  return {
+    key: s.key,
+    label: s.key,
+    hint: s.description,
+    type: s.secret ? 'password' : s.type === 'timezone' || s.type === 'url' || s.type === 'urls' ? 'string' : s.type,
+    step: s.type === 'float' ? 0.01 : undefined,
+    min: s.min,
+    max: s.max,
+    untranslated: true,
+  };
+}
+
 type ModelSwitchPhase = 'confirm' | 'loading_model' | 'reprocessing' | 'done' | 'error';
 
 export default function SettingsPage() {
@@ -66,11 +171,23 @@ export default function SettingsPage() {
   const [nvrStatus, setNvrStatus] = useState<NVRStatusResponse | null>(null);
   const [nvrChecking, setNvrChecking] = useState(false);
 
+  // Notification channels
+  const [channels, setChannels] = useState<NotificationChannelInfo[]>([]);
+  const [channelTesting, setChannelTesting] = useState<string | null>(null);
+  const [channelResults, setChannelResults] = useState<Record<string, NotificationTestResponse>>({});
+
   // Model switch dialog state
   const [modelDialogOpen, setModelDialogOpen] = useState(false);
   const [modelSwitchPhase, setModelSwitchPhase] = useState<ModelSwitchPhase>('confirm');
   const [modelSwitchError, setModelSwitchError] = useState<string | null>(null);
   const [processEvents, setProcessEvents] = useState<ProgressEvent[]>([]);
+  const [modelDownload, setModelDownload] = useState<ModelDownload | null>(null);
+  const downloadCleanupRef = useRef<(() => void) | null>(null);
+
+  // Settings backup (export/import)
+  const [backupPassphrase, setBackupPassphrase] = useState('');
+  const [backupResult, setBackupResult] = useState<{ ok: boolean; text: string } | null>(null);
+  const importInput = useRef<HTMLInputElement>(null);
   const cleanupRef = useRef<(() => void) | null>(null);
 
   const { data, isLoading } = useQuery({
@@ -78,6 +195,15 @@ export default function SettingsPage() {
     queryFn: getSettings,
   });
 
+  const { data: schema } = useQuery({
+    queryKey: ['settingsSchema'],
+    queryFn: getSettingsSchema,
+    staleTime: Infinity,
+  });
+  const otherFields = (schema ?? []).filter((s) => !knownKeys.has(s.key)).map(schemaField);
+  // The server's ranges win over the ones hardcoded above
+  const schemaByKey = new Map((schema ?? []).map((s) => [s.key, s]));
+
   const { data: modelData } = useQuery({
     queryKey: ['clipModel'],
     queryFn: getModelInfo,
@@ -113,6 +239,33 @@ export default function SettingsPage() {
     checkNVR();
   }, [checkNVR]);
 
+  const loadChannels = useCallback(async () => {
+    try {
+      setChannels(await getNotificationChannels());
+    } catch {
+      setChannels([]);
+    }
+  }, []);
+
+  useEffect(() => {
+    loadChannels();
+  }, [loadChannels]);
+
+  const testChannel = async (name: string) => {
+    setChannelTesting(name);
+    try {
+      const result = await testNotificationChannel(name);
+      setChannelResults((prev) => ({ ...prev, [name]: result }));
+    } catch (err) {
+      setChannelResults((prev) => ({
+        ...prev,
+        [name]: { status: 'error', error: err instanceof Error ? err.message : 'Request failed' },
+      }));
+    } finally {
+      setChannelTesting(null);
+    }
+  };
+
   const mutation = useMutation({
     mutationFn: updateSettings,
     onSuccess: (resp) => {
@@ -126,10 +279,21 @@ export default function SettingsPage() {
       const nvrKeys = nvrFields.map((f) => f.key);
       const changed = nvrKeys.some((k) => resp.settings[k] !== data?.settings[k]);
       if (changed) checkNVR();
+      if (notifyFields.some((f) => resp.settings[f.key] !== data?.settings[f.key])) loadChannels();
     },
   });
 
   const dirty = data && JSON.stringify(form) !== JSON.stringify(data.settings);
+  const dirtyRef = useRef(dirty);
+  dirtyRef.current = dirty;
+
+  // Pick up changes made elsewhere (another tab, an import, a reload), but
+  // don't overwrite edits that are still waiting to be saved
+  useEffect(() => {
+    return streamSettingsEvents(() => {
+      if (!dirtyRef.current) queryClient.invalidateQueries({ queryKey: ['settings'] });
+    });
+  }, [queryClient]);
 
   // Auto-save: debounce 1.5s after last change
   useEffect(() => {
@@ -147,6 +311,7 @@ export default function SettingsPage() {
   useEffect(() => {
     return () => {
       if (cleanupRef.current) cleanupRef.current();
+      if (downloadCleanupRef.current) downloadCleanupRef.current();
     };
   }, []);
 
@@ -154,44 +319,35 @@ export default function SettingsPage() {
     setModelSwitchPhase('loading_model');
     setModelSwitchError(null);
     setProcessEvents([]);
+    setModelDownload(null);
+
+    // The sidecar downloads the weights on the switch, or on the first
+    // batch of the re-embedding; show how far along it is
+    if (downloadCleanupRef.current) downloadCleanupRef.current();
+    downloadCleanupRef.current = streamModelDownload(selectedPreset, setModelDownload);
 
     try {
-      // Phase 1: Switch model (downloads weights if needed, clears data)
-      await switchModel(selectedPreset);
+      // Phase 1: Switch model (downloads weights if needed)
+      const info = await switchModel(selectedPreset);
       queryClient.invalidateQueries({ queryKey: ['clipModel'] });
 
-      // Phase 2: Reprocess all cameras
-      setModelSwitchPhase('reprocessing');
-
-      const cameras = await getCameras();
-      if (cameras.length === 0) {
-        setModelSwitchPhase('done');
-        return;
-      }
-
-      const cameraIds = cameras.map((c) => c.id);
-      const today = new Date().toISOString().split('T')[0];
-
-      const resp = await startProcess({
-        camera_ids: cameraIds,
-        start_date: '2000-01-01',
-        end_date: today,
-      });
-
-      if (resp.status === 'already_cached' || !resp.job_id) {
-        // No videos to process
+      if (!info.job_id) {
+        // Nothing indexed yet, the model switched at once
         setModelSwitchPhase('done');
         return;
       }
 
-      // Stream process progress
+      // Phase 2: Re-embed the indexed frames; search keeps the old model
+      // until the migration completes
+      setModelSwitchPhase('reprocessing');
       const cleanup = streamProcessStatus(
-        resp.job_id,
+        info.job_id,
         (event) => {
           setProcessEvents((prev) => [...prev, event]);
         },
         () => {
           setModelSwitchPhase('done');
+          queryClient.invalidateQueries({ queryKey: ['clipModel'] });
           cleanupRef.current = null;
         },
       );
@@ -207,10 +363,15 @@ export default function SettingsPage() {
       cleanupRef.current();
       cleanupRef.current = null;
     }
+    if (downloadCleanupRef.current) {
+      downloadCleanupRef.current();
+      downloadCleanupRef.current = null;
+    }
     setModelDialogOpen(false);
     setModelSwitchPhase('confirm');
     setModelSwitchError(null);
     setProcessEvents([]);
+    setModelDownload(null);
   };
 
   const handleChange = (key: string, value: number | boolean | string) => {
@@ -228,6 +389,44 @@ export default function SettingsPage() {
     mutation.mutate(form);
   };
 
+  const handleExport = async () => {
+    setBackupResult(null);
+    try {
+      const text = await exportSettings(backupPassphrase);
+      const url = URL.createObjectURL(new Blob([text], { type: 'application/json' }));
+      const a = document.createElement('a');
+      a.href = url;
+      a.download = 'intelsk-settings.json';
+      a.click();
+      URL.revokeObjectURL(url);
+    } catch (err) {
+      setBackupResult({ ok: false, text: (err as Error).message });
+    }
+  };
+
+  const handleImport = async (file: File) => {
+    setBackupResult(null);
+    try {
+      const resp = await importSettings(await file.text(), backupPassphrase);
+      setForm(resp.settings);
+      setDefaults(resp.defaults);
+      queryClient.setQueryData(['settings'], {
+        settings: resp.settings,
+        defaults: resp.defaults,
+        restart_required: resp.restart_required,
+      });
+      setBackupResult({
+        ok: true,
+        text: resp.skipped.length > 0
+          ? t('settings.backup_imported_skipped', { keys: resp.skipped.join(', ') })
+          : t('settings.backup_imported'),
+      });
+      checkNVR();
+    } catch (err) {
+      setBackupResult({ ok: false, text: (err as Error).message });
+    }
+  };
+
   if (isLoading) {
     return (
       <div className="max-w-3xl mx-auto px-4 py-12 text-center text-gray-500">
@@ -263,19 +462,21 @@ export default function SettingsPage() {
 
   const renderField = (field: FieldDef) => {
     const value = form[field.key];
+    const label = field.untranslated ? field.label : t(field.label);
+    const hint = field.untranslated ? field.hint : t(field.hint);
 
-    if (field.type === 'string' || field.type === 'password') {
+    if (field.type === 'string' || field.type === 'password' || field.type === 'time') {
       return (
         <label key={field.key} className="block py-3">
           <div className="flex items-center justify-between mb-1">
-            <span className="text-sm font-medium text-gray-700">{t(field.label)}</span>
+            <span className="text-sm font-medium text-gray-700">{label}</span>
             <span className="text-xs text-gray-400">
-              {t(field.hint)}
+              {hint}
               {renderResetLink(field.key, field.type)}
             </span>
           </div>
           <input
-            type={field.type === 'password' ? 'password' : 'text'}
+            type={field.type === 'password' || field.type === 'time' ? field.type : 'text'}
             value={(value as string) ?? ''}
             placeholder={String(defaults[field.key] ?? '')}
             onChange={(e) => handleChange(field.key, e.target.value)}
@@ -288,9 +489,9 @@ export default function SettingsPage() {
       return (
         <label key={field.key} className="flex items-center justify-between py-3">
           <div>
-            <div className="text-sm font-medium text-gray-700">{t(field.label)}</div>
+            <div className="text-sm font-medium text-gray-700">{label}</div>
             <div className="text-xs text-gray-400">
-              {t(field.hint)}
+              {hint}
               {renderResetLink(field.key, field.type)}
             </div>
           </div>
@@ -306,9 +507,9 @@ export default function SettingsPage() {
     return (
       <label key={field.key} className="block py-3">
         <div className="flex items-center justify-between mb-1">
-          <span className="text-sm font-medium text-gray-700">{t(field.label)}</span>
+          <span className="text-sm font-medium text-gray-700">{label}</span>
           <span className="text-xs text-gray-400">
-            {t(field.hint)}
+            {hint}
             {renderResetLink(field.key, field.type)}
           </span>
         </div>
@@ -316,8 +517,8 @@ export default function SettingsPage() {
           type="number"
           value={value ?? ''}
           step={field.step ?? 1}
-          min={field.min}
-          max={field.max}
+          min={schemaByKey.get(field.key)?.min ?? field.min}
+          max={schemaByKey.get(field.key)?.max ?? field.max}
           placeholder={formatDefault(field.key, field.type)}
           onChange={(e) => {
             const v = field.type === 'float' ? parseFloat(e.target.value) : parseInt(e.target.value, 10);
@@ -383,6 +584,12 @@ export default function SettingsPage() {
         </div>
       )}
 
+      {data && data.restart_required.length > 0 && (
+        <div className="mb-5 p-3 bg-amber-50 border border-amber-200 rounded text-sm text-amber-800">
+          {t('settings.restart_required', { keys: data.restart_required.join(', ') })}
+        </div>
+      )}
+
       <div className="space-y-5">
         {renderCard(t('settings.general_title'), generalFields)}
         <div className="bg-white rounded-lg shadow p-5">
@@ -433,9 +640,55 @@ export default function SettingsPage() {
             {nvrFields.map(renderField)}
           </div>
         </div>
+        {renderCard(t('settings.stream_title'), streamFields)}
         {renderCard(t('settings.search_title'), searchFields)}
         {renderCard(t('settings.extraction_title'), extractionFields)}
+        {renderCard(t('settings.process_title'), processFields)}
         {renderCard(t('settings.clip_title'), clipFields)}
+        {renderCard(t('settings.alerts_title'), alertFields)}
+        <div className="bg-white rounded-lg shadow p-5">
+          <h2 className="text-base font-semibold text-gray-900 mb-3">{t('settings.notify_title')}</h2>
+          <div className="divide-y divide-gray-100">
+            {notifyFields.map(renderField)}
+          </div>
+          {channels.length > 0 && (
+            <div className="mt-3 pt-3 border-t border-gray-100 space-y-2">
+              {channels.map((ch) => {
+                const result = channelResults[ch.name];
+                return (
+                  <div key={ch.name} className="flex items-center justify-between gap-2 text-sm">
+                    <span className="text-gray-700">{t(`settings.notify_channel_${ch.name}`)}</span>
+                    <div className="flex items-center gap-2">
+                      {!ch.configured ? (
+                        <span className="text-xs px-2 py-0.5 rounded-full bg-gray-100 text-gray-500">
+                          {t('settings.notify_not_configured')}
+                        </span>
+                      ) : result?.status === 'sent' ? (
+                        <span className="text-xs px-2 py-0.5 rounded-full bg-green-100 text-green-700">
+                          {t('settings.notify_sent')}
+                        </span>
+                      ) : result?.status === 'error' ? (
+                        <span className="text-xs px-2 py-0.5 rounded-full bg-red-100 text-red-700" title={result.error}>
+                          {t('settings.notify_failed')}
+                        </span>
+                      ) : null}
+                      <button
+                        type="button"
+                        onClick={() => testChannel(ch.name)}
+                        disabled={!ch.configured || channelTesting !== null}
+                        className="text-xs text-blue-600 hover:text-blue-700 disabled:opacity-50"
+                      >
+                        {channelTesting === ch.name ? t('settings.notify_sending') : t('settings.notify_test')}
+                      </button>
+                    </div>
+                  </div>
+                );
+              })}
+            </div>
+          )}
+        </div>
+        {renderCard(t('settings.ml_title'), mlFields)}
+        {otherFields.length > 0 && renderCard(t('settings.other_title'), otherFields)}
 
         {/* CLIP Model selector */}
         <div className="bg-white rounded-lg shadow p-5">
@@ -475,6 +728,52 @@ export default function SettingsPage() {
             </button>
           </div>
         </div>
+
+        {/* Settings backup */}
+        <div className="bg-white rounded-lg shadow p-5">
+          <h2 className="text-base font-semibold text-gray-900 mb-1">{t('settings.backup_title')}</h2>
+          <p className="text-xs text-gray-400 mb-3">{t('settings.backup_hint')}</p>
+          <label className="block py-2">
+            <span className="text-sm font-medium text-gray-700">{t('settings.backup_passphrase')}</span>
+            <input
+              type="password"
+              value={backupPassphrase}
+              onChange={(e) => setBackupPassphrase(e.target.value)}
+              autoComplete="new-password"
+              className="mt-1 w-full rounded border border-gray-300 px-3 py-1.5 text-sm focus:border-blue-500 focus:ring-1 focus:ring-blue-500"
+            />
+          </label>
+          {backupResult && (
+            <div className={`mt-2 text-xs ${backupResult.ok ? 'text-green-600' : 'text-red-600'}`}>
+              {backupResult.text}
+            </div>
+          )}
+          <div className="flex items-center justify-end gap-3 mt-2">
+            <input
+              ref={importInput}
+              type="file"
+              accept="application/json,.json"
+              className="hidden"
+              onChange={(e) => {
+                const file = e.target.files?.[0];
+                e.target.value = '';
+                if (file) handleImport(file);
+              }}
+            />
+            <button
+              onClick={() => importInput.current?.click()}
+              className="px-4 py-2 text-sm font-medium text-gray-700 bg-gray-100 rounded hover:bg-gray-200 min-h-[44px]"
+            >
+              {t('settings.backup_import')}
+            </button>
+            <button
+              onClick={handleExport}
+              className="px-4 py-2 bg-blue-600 text-white text-sm font-medium rounded hover:bg-blue-700 min-h-[44px]"
+            >
+              {t('settings.backup_export')}
+            </button>
+          </div>
+        </div>
       </div>
 
       {/* Model switch dialog */}
@@ -525,6 +824,23 @@ export default function SettingsPage() {
                       {t('settings.clip_model_switching')}
                     </span>
                   </div>
+                  {modelDownload?.status === 'downloading' && modelDownload.files_total > 0 && (
+                    <div className="ml-8 text-xs text-gray-500">
+                      <div className="w-full bg-gray-200 rounded-full h-1.5">
+                        <div
+                          className="bg-blue-500 h-1.5 rounded-full transition-all"
+                          style={{ width: `${Math.round(modelDownload.percent)}%` }}
+                        />
+                      </div>
+                      <p className="mt-0.5">
+                        {t('settings.clip_model_downloading', {
+                          done: modelDownload.files_done,
+                          total: modelDownload.files_total,
+                          percent: Math.round(modelDownload.percent),
+                        })}
+                      </p>
+                    </div>
+                  )}
 
                   {/* Step 2: Reprocessing */}
                   <div className="flex items-start gap-3">
@@ -1,12 +1,30 @@
-export function defaultTimeRange() {
+// wallClock formats d as YYYY-MM-DD and HH:MM in timeZone (the server's
+// general.timezone setting), or in the browser's zone when it is empty.
+export function wallClock(d: Date, timeZone?: string) {
+  // sv-SE formats as "2026-02-18 08:30:00"
+  const s = d.toLocaleString('sv-SE', { timeZone: timeZone || undefined, hourCycle: 'h23' });
+  return { date: s.slice(0, 10), time: s.slice(11, 16) };
+}
+
+export function defaultTimeRange(timeZone?: string) {
   const now = new Date();
   const sixHoursAgo = new Date(now.getTime() - 6 * 60 * 60 * 1000);
-  const fmtDate = (d: Date) => d.toISOString().split('T')[0];
-  const fmtTime = (d: Date) => d.toTimeString().slice(0, 5);
+  const start = wallClock(sixHoursAgo, timeZone);
+  const end = wallClock(now, timeZone);
   return {
-    startDate: fmtDate(sixHoursAgo),
-    startTime: fmtTime(sixHoursAgo),
-    endDate: fmtDate(now),
-    endTime: fmtTime(now),
+    startDate: start.date,
+    startTime: start.time,
+    endDate: end.date,
+    endTime: end.time,
   };
 }
+
+// formatDuration formats a number of seconds as "1h 05m", "3m 20s" or "45s".
+export function formatDuration(sec: number) {
+  const h = Math.floor(sec / 3600);
+  const m = Math.floor((sec % 3600) / 60);
+  const s = Math.floor(sec % 60);
+  if (h > 0) return `${h}h ${String(m).padStart(2, '0')}m`;
+  if (m > 0) return `${m}m ${String(s).padStart(2, '0')}s`;
+  return `${s}s`;
+}
@@ -71,8 +71,9 @@ class CLIPEncoder:
             return "mps"
         return "cpu"
 
-    def encode_images(self, paths: list[str]) -> list[np.ndarray]:
-        """Batch encode images, returning list of L2-normalized numpy arrays."""
+    def encode_images(self, paths: list) -> list[np.ndarray]:
+        """Batch encode images, given as paths or file objects, returning
+        list of L2-normalized numpy arrays."""
         all_features = []
         for i in range(0, len(paths), self.batch_size):
             batch_paths = paths[i : i + self.batch_size]
@@ -0,0 +1,93 @@
+"""Export a CLIP preset to ONNX for the backend's embedded encoder.
+
+Writes image.onnx, text.onnx, the BPE vocabulary and preset.json (the
+preprocessing the image encoder expects) into OUT/<preset>, the layout the
+backend reads with mlservice.backend set to onnx:
+
+    python export_onnx.py --preset mobileclip-s0 --out ../data/models
+"""
+
+import argparse
+import json
+import os
+import shutil
+
+import torch
+from open_clip.tokenizer import default_bpe
+from torchvision import transforms
+
+from clip_encoder import CLIPEncoder, MODEL_PRESETS
+
+
+class ImageTower(torch.nn.Module):
+    def __init__(self, model):
+        super().__init__()
+        self.model = model
+
+    def forward(self, image):
+        features = self.model.encode_image(image)
+        return features / features.norm(dim=-1, keepdim=True)
+
+
+class TextTower(torch.nn.Module):
+    def __init__(self, model):
+        super().__init__()
+        self.model = model
+
+    def forward(self, text):
+        features = self.model.encode_text(text)
+        return features / features.norm(dim=-1, keepdim=True)
+
+
+def preprocessing(preprocess) -> dict:
+    """Read the crop size and normalization from open_clip's transforms."""
+    out = {}
+    for t in preprocess.transforms:
+        if isinstance(t, transforms.CenterCrop):
+            out["image_size"] = int(t.size[0])
+        elif isinstance(t, transforms.Normalize):
+            out["mean"] = [float(v) for v in t.mean]
+            out["std"] = [float(v) for v in t.std]
+    return out
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument("--preset", default="mobileclip-s0", choices=sorted(MODEL_PRESETS))
+    parser.add_argument("--out", default="../data/models")
+    args = parser.parse_args()
+
+    encoder = CLIPEncoder(preset=args.preset)
+    model = encoder.model.float().cpu().eval()
+    out_dir = os.path.join(args.out, args.preset)
+    os.makedirs(out_dir, exist_ok=True)
+
+    meta = {"name": MODEL_PRESETS[args.preset]["name"], **preprocessing(encoder.preprocess)}
+    size = meta["image_size"]
+    tokens = encoder.tokenizer(["a photo of a dog"]).cpu()
+    meta["context_length"] = int(tokens.shape[1])
+    meta["embedding_dim"] = int(encoder.embedding_dim)
+
+    with torch.no_grad():
+        torch.onnx.export(
+            ImageTower(model), torch.zeros(1, 3, size, size),
+            os.path.join(out_dir, "image.onnx"),
+            input_names=["image"], output_names=["embedding"],
+            dynamic_axes={"image": {0: "batch"}, "embedding": {0: "batch"}},
+            opset_version=17,
+        )
+        torch.onnx.export(
+            TextTower(model), tokens,
+            os.path.join(out_dir, "text.onnx"),
+            input_names=["text"], output_names=["embedding"],
+            dynamic_axes={"text": {0: "batch"}, "embedding": {0: "batch"}},
+            opset_version=17,
+        )
+    shutil.copy(default_bpe(), os.path.join(out_dir, "bpe_simple_vocab_16e6.txt.gz"))
+    with open(os.path.join(out_dir, "preset.json"), "w") as f:
+        json.dump(meta, f, indent=2)
+    print(f"Exported {args.preset} to {out_dir}")
+
+
+if __name__ == "__main__":
+    main()
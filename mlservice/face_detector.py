@@ -0,0 +1,44 @@
+"""Face detection and encoding with face_recognition (dlib)."""
+
+
+class FaceDetector:
+    """Finds faces in an image and returns their 128-dim dlib encodings.
+
+    face_recognition is imported lazily so the sidecar still serves CLIP
+    requests when it isn't installed.
+    """
+
+    def __init__(self, model: str = "hog"):
+        self.model = model
+        self._fr = None
+
+    @property
+    def available(self) -> bool:
+        try:
+            self._load()
+        except ImportError:
+            return False
+        return True
+
+    def _load(self):
+        if self._fr is None:
+            import face_recognition
+
+            self._fr = face_recognition
+        return self._fr
+
+    def detect_and_encode(self, path) -> list[dict]:
+        """Detect faces in an image given as a path or a file object."""
+        fr = self._load()
+        image = fr.load_image_file(path)
+        locations = fr.face_locations(image, model=self.model)
+        if not locations:
+            return []
+        encodings = fr.face_encodings(image, known_face_locations=locations)
+        return [
+            {
+                "encoding": enc.tolist(),
+                "bbox": {"top": top, "right": right, "bottom": bottom, "left": left},
+            }
+            for enc, (top, right, bottom, left) in zip(encodings, locations)
+        ]
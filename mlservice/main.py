@@ -1,21 +1,33 @@
-"""FastAPI ML sidecar for CLIP inference and search."""
+"""FastAPI ML sidecar for CLIP inference, face detection and search."""
 
+import base64
+import binascii
+import io
+import threading
 from contextlib import asynccontextmanager
 
 from fastapi import FastAPI, HTTPException
 from pydantic import BaseModel
 
 from clip_encoder import CLIPEncoder, MODEL_PRESETS
+from face_detector import FaceDetector
+from model_loader import ModelLoader
 from searcher import Searcher
 
 encoder: CLIPEncoder | None = None
 searcher: Searcher | None = None
+# The model a re-embedding migration encodes with, loaded next to encoder
+# until /reload switches to it
+staged: CLIPEncoder | None = None
+staged_lock = threading.Lock()
+faces = FaceDetector()
+loader = ModelLoader()
 
 
 @asynccontextmanager
 async def lifespan(app: FastAPI):
     global encoder, searcher
-    encoder = CLIPEncoder()
+    encoder = loader.load("mobileclip-s0")
     searcher = Searcher(encoder)
     yield
 
@@ -26,13 +38,24 @@ app = FastAPI(title="intelsk ML sidecar", lifespan=lifespan)
 # --- Request models ---
 
 class EncodeImageRequest(BaseModel):
-    paths: list[str]
+    # Either file paths on a volume shared with the backend, or the image
+    # files themselves, base64-encoded, for a sidecar on another host
+    paths: list[str] | None = None
+    images: list[str] | None = None
+    # Encode with this preset instead of the loaded one, for re-embedding
+    # the index before a model switch
+    preset: str | None = None
 
 
 class EncodeTextRequest(BaseModel):
     text: str
 
 
+class DetectFacesRequest(BaseModel):
+    path: str | None = None
+    image: str | None = None
+
+
 class SearchImageRequest(BaseModel):
     db_path: str
     text: str
@@ -47,6 +70,26 @@ class ReloadRequest(BaseModel):
     preset: str
 
 
+def decode_image(data: str) -> io.BytesIO:
+    try:
+        return io.BytesIO(base64.b64decode(data, validate=True))
+    except binascii.Error:
+        raise HTTPException(status_code=400, detail="image is not valid base64")
+
+
+def encoder_for(preset: str | None) -> CLIPEncoder:
+    """The loaded encoder or, for another preset, the staged one."""
+    global staged
+    if preset is None or preset == encoder.preset_key:
+        return encoder
+    if preset not in MODEL_PRESETS:
+        raise HTTPException(status_code=400, detail=f"unknown preset: {preset}")
+    with staged_lock:
+        if staged is None or staged.preset_key != preset:
+            staged = loader.load(preset)
+        return staged
+
+
 # --- Endpoints ---
 
 @app.get("/health")
@@ -56,7 +99,13 @@ def health():
 
 @app.post("/encode/image")
 def encode_image(req: EncodeImageRequest):
-    embeddings = encoder.encode_images(req.paths)
+    if req.images is not None:
+        sources = [decode_image(data) for data in req.images]
+    elif req.paths is not None:
+        sources = req.paths
+    else:
+        raise HTTPException(status_code=400, detail="paths or images is required")
+    embeddings = encoder_for(req.preset).encode_images(sources)
     return {"embeddings": [emb.tolist() for emb in embeddings]}
 
 
@@ -66,6 +115,22 @@ def encode_text(req: EncodeTextRequest):
     return {"embedding": embedding.tolist()}
 
 
+@app.post("/detect/faces")
+def detect_faces(req: DetectFacesRequest):
+    if not faces.available:
+        raise HTTPException(
+            status_code=501,
+            detail="face_recognition is not installed (pip install face_recognition)",
+        )
+    if req.image is not None:
+        source = decode_image(req.image)
+    elif req.path is not None:
+        source = req.path
+    else:
+        raise HTTPException(status_code=400, detail="path or image is required")
+    return {"faces": faces.detect_and_encode(source)}
+
+
 @app.post("/search/image")
 def search_image(req: SearchImageRequest):
     results = searcher.search_by_text(
@@ -91,14 +156,27 @@ def get_model():
     }
 
 
+@app.get("/model/download")
+def model_download():
+    """Progress of the latest model load: status idle, downloading (with
+    files_done/files_total and bytes_done/bytes_total of the weights),
+    loading, ready or error."""
+    return loader.status()
+
+
 @app.post("/reload")
 def reload_model(req: ReloadRequest):
-    global encoder, searcher
+    global encoder, searcher, staged
 
     if req.preset not in MODEL_PRESETS:
         raise HTTPException(status_code=400, detail=f"unknown preset: {req.preset}")
 
-    encoder = CLIPEncoder(preset=req.preset)
+    # The staged encoder is dropped whichever preset is loaded
+    with staged_lock:
+        ready, staged = staged, None
+    if ready is None or ready.preset_key != req.preset:
+        ready = encoder if encoder.preset_key == req.preset else loader.load(req.preset)
+    encoder = ready
     searcher = Searcher(encoder)
 
     return {
@@ -0,0 +1,92 @@
+"""Loading of CLIP model presets with download progress the backend polls."""
+
+import glob
+import os
+import threading
+
+import open_clip
+from huggingface_hub import HfApi, constants, hf_hub_download, try_to_load_from_cache
+from huggingface_hub.file_download import repo_folder_name
+
+from clip_encoder import CLIPEncoder, MODEL_PRESETS
+
+# Weight files open_clip reads from a Hugging Face repo, in its order of
+# preference; the config is read along with them when there is one
+WEIGHT_FILES = ("open_clip_model.safetensors", "open_clip_pytorch_model.bin")
+CONFIG_FILE = "open_clip_config.json"
+
+
+class ModelLoader:
+    """Creates CLIPEncoders, first downloading the weights of their preset
+    file by file so /model/download can report how far along it is.
+
+    Only the latest load is tracked. Presets whose weights aren't on the
+    Hugging Face hub are left to open_clip and reported as loading."""
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._state = {"preset": "", "status": "idle"}
+        self._partial_dir = None  # blobs directory of the file downloading
+
+    def status(self) -> dict:
+        with self._lock:
+            state = dict(self._state)
+            partial_dir = self._partial_dir
+        if state["status"] == "downloading" and partial_dir:
+            # hf_hub_download writes into <blob>.incomplete until it's done
+            partial = sum(os.path.getsize(p) for p in glob.glob(os.path.join(partial_dir, "*.incomplete")))
+            state["bytes_done"] = min(state["bytes_done"] + partial, state["bytes_total"])
+        total = state.get("bytes_total", 0)
+        state["percent"] = round(100 * state.get("bytes_done", 0) / total, 1) if total else 0
+        return state
+
+    def _update(self, **fields):
+        with self._lock:
+            self._state.update(fields)
+
+    def load(self, preset: str) -> CLIPEncoder:
+        self._update(preset=preset, status="downloading", file="", error="",
+                     files_done=0, files_total=0, bytes_done=0, bytes_total=0)
+        try:
+            self._download(preset)
+            self._update(status="loading", file="")
+            encoder = CLIPEncoder(preset=preset)
+        except Exception as e:
+            self._update(status="error", error=str(e))
+            raise
+        finally:
+            with self._lock:
+                self._partial_dir = None
+        self._update(status="ready")
+        return encoder
+
+    def _download(self, preset: str):
+        cfg = MODEL_PRESETS[preset]
+        hf_hub = open_clip.get_pretrained_cfg(cfg["model"], cfg["pretrained"]).get("hf_hub", "")
+        if not hf_hub:
+            return
+        # "org/repo/" for the default weight file, as open_clip splits it
+        repo_id, filename = os.path.split(hf_hub)
+        if any(isinstance(try_to_load_from_cache(repo_id, f), str) for f in ([filename] if filename else WEIGHT_FILES)):
+            return  # cached, nothing to report
+        try:
+            sizes = {s.rfilename: s.size or 0 for s in HfApi().model_info(repo_id, files_metadata=True).siblings}
+        except Exception:
+            return  # offline or a private mirror; open_clip reports what fails
+
+        if filename:
+            files = [filename]
+        else:
+            files = [next((f for f in WEIGHT_FILES if f in sizes), WEIGHT_FILES[-1])]
+            if CONFIG_FILE in sizes:
+                files.append(CONFIG_FILE)
+        self._update(files_total=len(files), bytes_total=sum(sizes.get(f, 0) for f in files))
+        with self._lock:
+            self._partial_dir = os.path.join(constants.HF_HUB_CACHE, repo_folder_name(repo_id=repo_id, repo_type="model"), "blobs")
+
+        for i, f in enumerate(files):
+            self._update(file=f)
+            hf_hub_download(repo_id, f)
+            with self._lock:
+                self._state["files_done"] = i + 1
+                self._state["bytes_done"] += sizes.get(f, 0)